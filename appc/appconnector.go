@@ -11,13 +11,18 @@
 
 import (
 	"context"
+	"hash/fnv"
+	"io"
 	"net/netip"
 	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	xmaps "golang.org/x/exp/maps"
 	"golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/envknob"
+	"tailscale.com/tstime"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/views"
 	"tailscale.com/util/dnsname"
@@ -36,6 +41,24 @@ type RouteAdvertiser interface {
 	UnadvertiseRoute(...netip.Prefix) error
 }
 
+// routeMaxAge, if positive, is how long a route learned from DNS responses
+// stays advertised after the last response that contained it, unless the app
+// connector configuration sets another. See SetRouteMaxAge.
+var routeMaxAge = envknob.RegisterDuration("TS_APPC_ROUTE_MAX_AGE")
+
+// Partition returns which of n partitions the domain or route name belongs to
+// when the domains and routes of an app connector configuration are split
+// across several connectors. Domains are compared case-insensitively and
+// without any trailing dot. It returns 0 if n is less than 2.
+func Partition(name string, n int) int {
+	if n < 2 {
+		return 0
+	}
+	h := fnv.New32a()
+	io.WriteString(h, strings.ToLower(strings.TrimSuffix(name, ".")))
+	return int(h.Sum32() % uint32(n))
+}
+
 // AppConnector is an implementation of an AppConnector that performs
 // its function as a subsystem inside of a tailscale node. At the control plane
 // side App Connector routing is configured in terms of domains rather than IP
@@ -48,10 +71,15 @@ type RouteAdvertiser interface {
 type AppConnector struct {
 	logf            logger.Logf
 	routeAdvertiser RouteAdvertiser
+	clock           tstime.Clock
 
 	// mu guards the fields that follow
 	mu sync.Mutex
 
+	// routeMaxAge is how long a route learned from DNS responses remains
+	// advertised after it was last observed. Zero means forever.
+	routeMaxAge time.Duration
+
 	// domains is a map of lower case domain names with no trailing dot, to an
 	// ordered list of resolved IP addresses.
 	domains map[string][]netip.Addr
@@ -62,6 +90,17 @@ type AppConnector struct {
 	// wildcards is the list of domain strings that match subdomains.
 	wildcards []string
 
+	// lastSeen maps the addresses of routes advertised as a result of DNS
+	// responses to the last time they were observed in one, or, for routes
+	// learned before tailscaled started, to when RestoreRoutes was called.
+	lastSeen map[netip.Addr]time.Time
+
+	// expiryTimer, if non-nil, schedules the next expireRoutes.
+	expiryTimer tstime.TimerController
+
+	// closed is whether Close has been called.
+	closed bool
+
 	// queue provides ordering for update operations
 	queue execqueue.ExecQueue
 }
@@ -71,6 +110,20 @@ func NewAppConnector(logf logger.Logf, routeAdvertiser RouteAdvertiser) *AppConn
 	return &AppConnector{
 		logf:            logger.WithPrefix(logf, "appc: "),
 		routeAdvertiser: routeAdvertiser,
+		clock:           tstime.StdClock{},
+		routeMaxAge:     max(routeMaxAge(), 0),
+	}
+}
+
+// Close stops the expiry of learned routes. The routes that are currently
+// advertised remain advertised.
+func (e *AppConnector) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	if e.expiryTimer != nil {
+		e.expiryTimer.Stop()
+		e.expiryTimer = nil
 	}
 }
 
@@ -84,6 +137,52 @@ func (e *AppConnector) UpdateDomainsAndRoutes(domains []string, routes []netip.P
 	})
 }
 
+// SetRouteMaxAge asynchronously sets how long a route learned from DNS
+// responses stays advertised after the last response that contained it, as
+// set in the app connector configuration. Zero means the TS_APPC_ROUTE_MAX_AGE
+// environment variable, if set, or forever.
+func (e *AppConnector) SetRouteMaxAge(d time.Duration) {
+	e.queue.Add(func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if d <= 0 {
+			d = max(routeMaxAge(), 0)
+		}
+		if d == e.routeMaxAge {
+			return
+		}
+		e.routeMaxAge = d
+		if e.expiryTimer != nil {
+			e.expiryTimer.Stop()
+			e.expiryTimer = nil
+		}
+		e.scheduleExpiryLocked(0)
+	})
+}
+
+// RestoreRoutes asynchronously starts tracking the single-address routes
+// among advertised, the routes that the node currently advertises, that
+// aren't covered by the routes supplied by control. Those are presumably
+// routes that were learned from DNS responses before tailscaled restarted;
+// they're treated as if they were observed now, so that they expire if
+// they aren't observed again.
+func (e *AppConnector) RestoreRoutes(advertised []netip.Prefix) {
+	e.queue.Add(func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		now := e.clock.Now()
+		for _, pfx := range advertised {
+			if !pfx.IsSingleIP() || e.inControlRoutesLocked(pfx.Addr()) {
+				continue
+			}
+			if _, ok := e.lastSeen[pfx.Addr()]; !ok {
+				mak.Set(&e.lastSeen, pfx.Addr(), now)
+			}
+		}
+		e.scheduleExpiryLocked(0)
+	})
+}
+
 // UpdateDomains asynchronously replaces the current set of configured domains
 // with the supplied set of domains. Domains must not contain a trailing dot,
 // and should be lower case. If the domain contains a leading '*' label it
@@ -159,11 +258,26 @@ func (e *AppConnector) updateRoutes(routes []netip.Prefix) {
 				if r.Contains(a) && netip.PrefixFrom(a, a.BitLen()) != r {
 					pfx := netip.PrefixFrom(a, a.BitLen())
 					toRemove = append(toRemove, pfx)
+					delete(e.lastSeen, a)
 					continue nextRoute
 				}
 			}
 		}
 	}
+	// Learned routes, including those not associated with a domain, that
+	// are now covered by control's routes are no longer tracked for
+	// expiry.
+	for a := range e.lastSeen {
+		for _, r := range routes {
+			if r.Contains(a) {
+				if pfx := netip.PrefixFrom(a, a.BitLen()); pfx != r {
+					toRemove = append(toRemove, pfx)
+				}
+				delete(e.lastSeen, a)
+				break
+			}
+		}
+	}
 
 	if err := e.routeAdvertiser.UnadvertiseRoute(toRemove...); err != nil {
 		e.logf("failed to unadvertise routes: %v: %v", toRemove, err)
@@ -274,7 +388,9 @@ func (e *AppConnector) ObserveDNSResponse(res []byte) {
 			if err != nil {
 				return
 			}
-			addr := netip.AddrFrom16(r.AAAA)
+			// IPv4-mapped answers are reached over IPv4, so route the
+			// IPv4 address rather than a /128 that no packet will match.
+			addr := netip.AddrFrom16(r.AAAA).Unmap()
 			mak.Set(&addressRecords, domain, append(addressRecords[domain], addr))
 		default:
 			if err := p.SkipAnswer(); err != nil {
@@ -287,6 +403,7 @@ func (e *AppConnector) ObserveDNSResponse(res []byte) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	now := e.clock.Now()
 	for domain, addrs := range addressRecords {
 		domain, isRouted := e.findRoutedDomainLocked(domain, cnameChain)
 
@@ -299,6 +416,15 @@ func (e *AppConnector) ObserveDNSResponse(res []byte) {
 		// was not already known.
 		var toAdvertise []netip.Prefix
 		for _, addr := range addrs {
+			if _, ok := e.lastSeen[addr]; ok {
+				// Already advertised, though perhaps restored
+				// without its domain.
+				e.lastSeen[addr] = now
+				if !e.hasDomainAddrLocked(domain, addr) {
+					e.addDomainAddrLocked(domain, addr)
+				}
+				continue
+			}
 			if !e.isAddrKnownLocked(domain, addr) {
 				toAdvertise = append(toAdvertise, netip.PrefixFrom(addr, addr.BitLen()))
 			}
@@ -348,11 +474,21 @@ func (e *AppConnector) isAddrKnownLocked(domain string, addr netip.Addr) bool {
 	if e.hasDomainAddrLocked(domain, addr) {
 		return true
 	}
+	if e.inControlRoutesLocked(addr) {
+		// record the new address associated with the domain for faster matching in subsequent
+		// requests and for diagnostic records.
+		e.addDomainAddrLocked(domain, addr)
+		return true
+	}
+	return false
+}
+
+// inControlRoutesLocked reports whether addr is covered by one of the routes
+// supplied by control.
+// e.mu must be held.
+func (e *AppConnector) inControlRoutesLocked(addr netip.Addr) bool {
 	for _, route := range e.controlRoutes {
 		if route.Contains(addr) {
-			// record the new address associated with the domain for faster matching in subsequent
-			// requests and for diagnostic records.
-			e.addDomainAddrLocked(domain, addr)
 			return true
 		}
 	}
@@ -370,6 +506,7 @@ func (e *AppConnector) scheduleAdvertisement(domain string, routes ...netip.Pref
 		e.mu.Lock()
 		defer e.mu.Unlock()
 
+		now := e.clock.Now()
 		for _, route := range routes {
 			if !route.IsSingleIP() {
 				continue
@@ -379,10 +516,76 @@ func (e *AppConnector) scheduleAdvertisement(domain string, routes ...netip.Pref
 				e.addDomainAddrLocked(domain, addr)
 				e.logf("[v2] advertised route for %v: %v", domain, addr)
 			}
+			mak.Set(&e.lastSeen, addr, now)
 		}
+		e.scheduleExpiryLocked(0)
 	})
 }
 
+// expiryRetryInterval is how long to wait before trying again to unadvertise
+// expired routes after that failed.
+const expiryRetryInterval = time.Minute
+
+// scheduleExpiryLocked arranges for expireRoutes to run once the least
+// recently observed learned route is older than e.routeMaxAge, but no sooner
+// than minDelay, if there are learned routes and one isn't already scheduled.
+// e.mu must be held.
+func (e *AppConnector) scheduleExpiryLocked(minDelay time.Duration) {
+	if e.routeMaxAge <= 0 || e.closed || e.expiryTimer != nil || len(e.lastSeen) == 0 {
+		return
+	}
+	var oldest time.Time
+	for _, t := range e.lastSeen {
+		if oldest.IsZero() || t.Before(oldest) {
+			oldest = t
+		}
+	}
+	d := max(oldest.Add(e.routeMaxAge).Sub(e.clock.Now()), minDelay)
+	e.expiryTimer = e.clock.AfterFunc(d, func() {
+		e.queue.Add(e.expireRoutes)
+	})
+}
+
+// expireRoutes unadvertises the routes learned from DNS responses that
+// haven't been observed in one for e.routeMaxAge, and forgets their
+// addresses, so that the advertised routes of domains that rotate through
+// many addresses don't grow without bound.
+func (e *AppConnector) expireRoutes() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.expiryTimer = nil
+	if e.closed {
+		return
+	}
+	now := e.clock.Now()
+	var toRemove []netip.Prefix
+	var retry time.Duration
+	for addr, t := range e.lastSeen {
+		if now.Sub(t) >= e.routeMaxAge {
+			toRemove = append(toRemove, netip.PrefixFrom(addr, addr.BitLen()))
+		}
+	}
+	if len(toRemove) > 0 {
+		slices.SortFunc(toRemove, func(a, b netip.Prefix) int { return compareAddr(a.Addr(), b.Addr()) })
+		if err := e.routeAdvertiser.UnadvertiseRoute(toRemove...); err != nil {
+			e.logf("failed to unadvertise expired routes: %v: %v", toRemove, err)
+			retry = expiryRetryInterval
+		} else {
+			for _, pfx := range toRemove {
+				delete(e.lastSeen, pfx.Addr())
+				for domain, addrs := range e.domains {
+					e.domains[domain] = slices.DeleteFunc(addrs, func(a netip.Addr) bool {
+						return a == pfx.Addr()
+					})
+				}
+			}
+			e.logf("unadvertised %d routes not observed in %v: %v", len(toRemove), e.routeMaxAge, toRemove)
+		}
+	}
+	e.scheduleExpiryLocked(retry)
+}
+
 // hasDomainAddrLocked returns true if the address has been observed in a
 // resolution of domain.
 func (e *AppConnector) hasDomainAddrLocked(domain string, addr netip.Addr) bool {
@@ -8,11 +8,14 @@
 	"net/netip"
 	"reflect"
 	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	xmaps "golang.org/x/exp/maps"
 	"golang.org/x/net/dns/dnsmessage"
 	"tailscale.com/appc/appctest"
+	"tailscale.com/tstest"
 	"tailscale.com/util/mak"
 	"tailscale.com/util/must"
 )
@@ -157,8 +160,17 @@ func TestObserveDNSResponse(t *testing.T) {
 		t.Errorf("got %v; want %v", got, want)
 	}
 
+	// IPv4-mapped AAAA answers are routed as IPv4 addresses
+	wantRoutes = append(wantRoutes, netip.MustParsePrefix("192.0.0.11/32"))
+	a.ObserveDNSResponse(dnsResponse("example.com.", "::ffff:192.0.0.11"))
+	a.Wait(ctx)
+	if got, want := rc.Routes(), wantRoutes; !slices.Equal(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
 	// don't re-advertise routes that have already been advertised
 	a.ObserveDNSResponse(dnsResponse("example.com.", "2001:db8::1"))
+	a.ObserveDNSResponse(dnsResponse("example.com.", "192.0.0.11"))
 	a.Wait(ctx)
 	if !slices.Equal(rc.Routes(), wantRoutes) {
 		t.Errorf("rc.Routes(): got %v; want %v", rc.Routes(), wantRoutes)
@@ -208,6 +220,114 @@ func TestWildcardDomains(t *testing.T) {
 	}
 }
 
+func TestRouteExpiry(t *testing.T) {
+	ctx := context.Background()
+	rc := &appctest.RouteCollector{}
+	clock := tstest.NewClock(tstest.ClockOpts{})
+	a := NewAppConnector(t.Logf, rc)
+	defer a.Close()
+	a.clock = clock
+	a.routeMaxAge = time.Hour
+	a.updateDomains([]string{"example.com"})
+
+	a.ObserveDNSResponse(dnsResponse("example.com.", "192.0.0.8"))
+	a.Wait(ctx)
+	clock.Advance(30 * time.Minute)
+	a.ObserveDNSResponse(dnsResponse("example.com.", "192.0.0.9"))
+	a.Wait(ctx)
+	wantRoutes := []netip.Prefix{netip.MustParsePrefix("192.0.0.8/32"), netip.MustParsePrefix("192.0.0.9/32")}
+	if got := rc.Routes(); !slices.Equal(got, wantRoutes) {
+		t.Fatalf("routes: got %v; want %v", got, wantRoutes)
+	}
+
+	// Observing 192.0.0.9 again keeps it alive, while 192.0.0.8 expires an
+	// hour after it was last seen.
+	clock.Advance(20 * time.Minute)
+	a.ObserveDNSResponse(dnsResponse("example.com.", "192.0.0.9"))
+	a.Wait(ctx)
+	clock.Advance(10 * time.Minute)
+	a.Wait(ctx)
+	wantRoutes = wantRoutes[1:]
+	if got := rc.Routes(); !slices.Equal(got, wantRoutes) {
+		t.Errorf("routes after 1h: got %v; want %v", got, wantRoutes)
+	}
+	if got, want := a.DomainRoutes()["example.com"], []netip.Addr{netip.MustParseAddr("192.0.0.9")}; !slices.Equal(got, want) {
+		t.Errorf("domain routes after 1h: got %v; want %v", got, want)
+	}
+
+	clock.Advance(time.Hour)
+	a.Wait(ctx)
+	if got := rc.Routes(); len(got) != 0 {
+		t.Errorf("routes after 2h: got %v; want none", got)
+	}
+
+	// An expired route is advertised again when it's next observed.
+	a.ObserveDNSResponse(dnsResponse("example.com.", "192.0.0.8"))
+	a.Wait(ctx)
+	wantRoutes = []netip.Prefix{netip.MustParsePrefix("192.0.0.8/32")}
+	if got := rc.Routes(); !slices.Equal(got, wantRoutes) {
+		t.Errorf("routes after re-observing: got %v; want %v", got, wantRoutes)
+	}
+
+	// Routes covered by control's routes aren't learned, so don't expire.
+	a.updateRoutes([]netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")})
+	a.ObserveDNSResponse(dnsResponse("example.com.", "192.0.2.1"))
+	a.Wait(ctx)
+	if _, ok := a.lastSeen[netip.MustParseAddr("192.0.2.1")]; ok {
+		t.Errorf("address in control route tracked for expiry")
+	}
+}
+
+func TestRestoreRoutes(t *testing.T) {
+	ctx := context.Background()
+	rc := &appctest.RouteCollector{}
+	clock := tstest.NewClock(tstest.ClockOpts{})
+	a := NewAppConnector(t.Logf, rc)
+	defer a.Close()
+	a.clock = clock
+	a.updateDomains([]string{"example.com"})
+
+	// Routes advertised before a restart: two learned ones, a subnet
+	// route and one covered by control's routes.
+	advertised := []netip.Prefix{
+		netip.MustParsePrefix("192.0.0.8/32"),
+		netip.MustParsePrefix("2001:db8::1/128"),
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.0.2.1/32"),
+	}
+	must.Do(rc.SetRoutes(slices.Clone(advertised)))
+	a.UpdateDomainsAndRoutes([]string{"example.com"}, []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")})
+	a.RestoreRoutes(advertised)
+	a.SetRouteMaxAge(time.Hour)
+	a.Wait(ctx)
+
+	got := xmaps.Keys(a.lastSeen)
+	slices.SortFunc(got, compareAddr)
+	if want := []netip.Addr{netip.MustParseAddr("192.0.0.8"), netip.MustParseAddr("2001:db8::1")}; !slices.Equal(got, want) {
+		t.Fatalf("tracked routes: got %v; want %v", got, want)
+	}
+
+	clock.Advance(30 * time.Minute)
+	a.ObserveDNSResponse(dnsResponse("example.com.", "192.0.0.8"))
+	a.Wait(ctx)
+	clock.Advance(30 * time.Minute)
+	a.Wait(ctx)
+	wantRemoved := []netip.Prefix{netip.MustParsePrefix("2001:db8::1/128")}
+	if got := rc.RemovedRoutes(); !slices.Equal(got, wantRemoved) {
+		t.Errorf("removed routes after 1h: got %v; want %v", got, wantRemoved)
+	}
+
+	// A shorter maximum age from the configuration expires routes sooner.
+	a.SetRouteMaxAge(10 * time.Minute)
+	a.Wait(ctx)
+	clock.Advance(10 * time.Minute)
+	a.Wait(ctx)
+	wantRemoved = append(wantRemoved, netip.MustParsePrefix("192.0.0.8/32"))
+	if got := rc.RemovedRoutes(); !slices.Equal(got, wantRemoved) {
+		t.Errorf("removed routes after 1h10m: got %v; want %v", got, wantRemoved)
+	}
+}
+
 // dnsResponse is a test helper that creates a DNS response buffer for the given domain and address
 func dnsResponse(domain, address string) []byte {
 	addr := netip.MustParseAddr(address)
@@ -300,6 +420,28 @@ func dnsCNAMEResponse(address string, domains ...string) []byte {
 	return must.Get(b.Finish())
 }
 
+func TestPartition(t *testing.T) {
+	for _, n := range []int{-1, 0, 1} {
+		if got := Partition("example.com", n); got != 0 {
+			t.Errorf("Partition(example.com, %d) = %d; want 0", n, got)
+		}
+	}
+	counts := make([]int, 4)
+	for _, d := range []string{"a.com", "b.com", "c.com", "d.com", "e.com", "f.com", "g.com", "h.com", "*.example.com", "10.0.0.0/8"} {
+		p := Partition(d, len(counts))
+		if p < 0 || p >= len(counts) {
+			t.Fatalf("Partition(%q, %d) = %d; out of range", d, len(counts), p)
+		}
+		counts[p]++
+		if got := Partition(strings.ToUpper(d)+".", len(counts)); got != p {
+			t.Errorf("Partition of %q is %d in upper case with trailing dot; want %d", d, got, p)
+		}
+	}
+	if slices.Contains(counts, 10) {
+		t.Errorf("all names fell into a single partition: %v", counts)
+	}
+}
+
 func prefixEqual(a, b netip.Prefix) bool {
 	return a == b
 }
@@ -4,7 +4,11 @@
 // Package apitype contains types for the Tailscale LocalAPI and control plane API.
 package apitype
 
-import "tailscale.com/tailcfg"
+import (
+	"time"
+
+	"tailscale.com/tailcfg"
+)
 
 // LocalAPIHost is the Host header value used by the LocalAPI.
 const LocalAPIHost = "local-tailscaled.sock"
@@ -49,3 +53,55 @@ type ReloadConfigResponse struct {
 	Reloaded bool   // whether the config was reloaded
 	Err      string // any error message
 }
+
+// PacketFilterCheckResponse is the response to a LocalAPI
+// debug-packet-filter-check request, which evaluates the node's current packet
+// filter for a hypothetical incoming packet.
+type PacketFilterCheckResponse struct {
+	Allowed bool   // whether the packet would be accepted
+	Verdict string // the filter's verdict, e.g. "Accept" or "Drop"
+	Reason  string // why the filter reached Verdict, e.g. "tcp ok" or "no rules matched"
+
+	// Rule is the packet filter rule that accepted the packet, if any.
+	Rule string `json:",omitempty"`
+}
+
+// LocalSession is a client of tailscaled, such as the GUI or the CLI, that has
+// a LocalAPI request in flight. It is returned by the LocalAPI "sessions"
+// endpoint.
+type LocalSession struct {
+	PID      int    `json:",omitempty"` // of the client process, if known
+	UserID   string `json:",omitempty"` // local user ID (a SID on Windows), if known
+	Username string `json:",omitempty"` // of UserID, if it could be looked up
+
+	Path  string    // LocalAPI path being served
+	Since time.Time // when the request started
+}
+
+// DetachSessionsResponse is the response to a LocalAPI sessions/detach
+// request.
+type DetachSessionsResponse struct {
+	Detached int // number of sessions that were detached
+}
+
+// ExitNodeSuggestion is an exit node suggested by the LocalAPI
+// suggest-exit-node handler, which returns them best first.
+type ExitNodeSuggestion struct {
+	ID   tailcfg.StableNodeID
+	Name string // MagicDNS name, without the trailing dot
+
+	// Location is the exit node's location, if it declared one.
+	Location *tailcfg.Location `json:",omitempty"`
+
+	// DERPRegion is the exit node's home DERP region, or 0 if unknown.
+	DERPRegion int `json:",omitempty"`
+
+	// Latency is the round-trip latency to the exit node, or 0 if unknown.
+	Latency time.Duration `json:",omitempty"`
+
+	// LatencySource says how Latency was determined: "ping" if it was
+	// measured with a disco ping, or "derp" if it's our latency to the exit
+	// node's home DERP region. It's "unreachable" if the exit node didn't
+	// answer a ping, and empty if Latency is otherwise unknown.
+	LatencySource string `json:",omitempty"`
+}
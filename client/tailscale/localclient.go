@@ -37,6 +37,8 @@
 	"tailscale.com/tailcfg"
 	"tailscale.com/tailfs"
 	"tailscale.com/tka"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/types/ipproto"
 	"tailscale.com/types/key"
 	"tailscale.com/types/tkatype"
 )
@@ -336,6 +338,58 @@ func (lc *LocalClient) TailDaemonLogs(ctx context.Context) (io.Reader, error) {
 	return res.Body, nil
 }
 
+// TailDNSQueryLog returns a stream of the DNS queries handled by the Tailscale
+// daemon's DNS resolver, as JSON dnstype.QueryLogEntry values: first the
+// recent queries it has kept, then new ones as they happen. It requires the
+// DNSQueryLog pref to be enabled.
+func (lc *LocalClient) TailDNSQueryLog(ctx context.Context) (io.Reader, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/dns-query-log", nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, errors.New(strings.TrimSpace(string(body)))
+	}
+	return res.Body, nil
+}
+
+// FlushDNSCache drops the responses from upstream resolvers cached by the
+// Tailscale daemon's DNS resolver and returns how many there were.
+func (lc *LocalClient) FlushDNSCache(ctx context.Context) (int, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/dns-cache-flush", 200, nil)
+	if err != nil {
+		return 0, err
+	}
+	return decodeJSON[int](body)
+}
+
+// DNSFailoverStatus returns the state of the split DNS routes whose resolvers
+// are used in order, failing over from one to the next, including which
+// resolver each is currently using.
+func (lc *LocalClient) DNSFailoverStatus(ctx context.Context) ([]dnstype.FailoverRouteStatus, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/dns-failover-status")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]dnstype.FailoverRouteStatus](body)
+}
+
+// SuggestExitNodes returns the exit nodes that are online, best first, ranked
+// by their latency and location priority.
+func (lc *LocalClient) SuggestExitNodes(ctx context.Context) ([]apitype.ExitNodeSuggestion, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/suggest-exit-node")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]apitype.ExitNodeSuggestion](body)
+}
+
 // Pprof returns a pprof profile of the Tailscale daemon.
 func (lc *LocalClient) Pprof(ctx context.Context, pprofType string, sec int) ([]byte, error) {
 	var secArg string
@@ -570,6 +624,27 @@ func (lc *LocalClient) status(ctx context.Context, queryString string) (*ipnstat
 	return decodeJSON[*ipnstate.Status](body)
 }
 
+// PeerConnHistory returns the connectivity transitions of the peer with
+// Tailscale IP ip over the last hour, oldest first.
+func (lc *LocalClient) PeerConnHistory(ctx context.Context, ip netip.Addr) ([]ipnstate.PeerConnEvent, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/peer-history?ip="+url.QueryEscape(ip.String()))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]ipnstate.PeerConnEvent](body)
+}
+
+// UsageReport returns the local usage report of the current week, or of the
+// previous week if last is true. It returns an error if the UsageReports pref
+// is not enabled.
+func (lc *LocalClient) UsageReport(ctx context.Context, last bool) (*ipnstate.UsageReport, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/usage-report?last="+strconv.FormatBool(last))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipnstate.UsageReport](body)
+}
+
 // IDToken is a request to get an OIDC ID token for an audience.
 // The token can be presented to any resource provider which offers OIDC
 // Federation.
@@ -722,6 +797,16 @@ func (lc *LocalClient) GetPrefs(ctx context.Context) (*ipn.Prefs, error) {
 	return &p, nil
 }
 
+// PrefsProvenance returns, for each preference, where its current value comes
+// from. Prefs nested in structs are named with dots, as in "AutoUpdate.Apply".
+func (lc *LocalClient) PrefsProvenance(ctx context.Context) (map[string]ipn.PrefSource, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/prefs-provenance")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[map[string]ipn.PrefSource](body)
+}
+
 func (lc *LocalClient) EditPrefs(ctx context.Context, mp *ipn.MaskedPrefs) (*ipn.Prefs, error) {
 	body, err := lc.send(ctx, "PATCH", "/localapi/v0/prefs", http.StatusOK, jsonBody(mp))
 	if err != nil {
@@ -970,6 +1055,20 @@ func (lc *LocalClient) Ping(ctx context.Context, ip netip.Addr, pingtype tailcfg
 	return lc.PingWithOpts(ctx, ip, pingtype, PingOpts{})
 }
 
+// BenchPeer measures the latency, loss and throughput of the path to the
+// peer with Tailscale IP ip. The throughput is measured with transfers of
+// duration d in each direction, which the peer must permit.
+func (lc *LocalClient) BenchPeer(ctx context.Context, ip netip.Addr, d time.Duration) (*ipnstate.BenchResult, error) {
+	v := url.Values{}
+	v.Set("ip", ip.String())
+	v.Set("duration", d.String())
+	body, err := lc.send(ctx, "POST", "/localapi/v0/bench-peer?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[*ipnstate.BenchResult](body)
+}
+
 // NetworkLockStatus fetches information about the tailnet key authority, if one is configured.
 func (lc *LocalClient) NetworkLockStatus(ctx context.Context) (*ipnstate.NetworkLockStatus, error) {
 	body, err := lc.send(ctx, "GET", "/localapi/v0/tka/status", 200, nil)
@@ -1169,6 +1268,120 @@ func (lc *LocalClient) NetworkLockDisable(ctx context.Context, secret []byte) er
 	return nil
 }
 
+// NetworkLockGenerateDisablements generates n disablement secrets, returning
+// them along with the disablement values to pass to NetworkLockInit.
+func (lc *LocalClient) NetworkLockGenerateDisablements(ctx context.Context, n int) (secrets, values [][]byte, err error) {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(struct{ Count int }{n}); err != nil {
+		return nil, nil, err
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/tka/generate-disablements", 200, &b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error: %w", err)
+	}
+	res, err := decodeJSON[struct {
+		Secrets [][]byte
+		Values  [][]byte
+	}](body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return res.Secrets, res.Values, nil
+}
+
+// NetworkLockCheckDisablements reports, for each of the given disablement
+// secrets, whether it can be used with NetworkLockDisable to shut down
+// network-lock. Checking a secret doesn't consume it.
+func (lc *LocalClient) NetworkLockCheckDisablements(ctx context.Context, secrets [][]byte) ([]bool, error) {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(struct{ Secrets [][]byte }{secrets}); err != nil {
+		return nil, err
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/tka/check-disablements", 200, &b)
+	if err != nil {
+		return nil, fmt.Errorf("error: %w", err)
+	}
+	return decodeJSON[[]bool](body)
+}
+
+// NetworkLockPendingSigs returns the peers which are locked out by
+// network-lock, pending a signature of their node-key.
+func (lc *LocalClient) NetworkLockPendingSigs(ctx context.Context) ([]*ipnstate.TKAFilteredPeer, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/tka/pending-sigs")
+	if err != nil {
+		return nil, fmt.Errorf("error: %w", err)
+	}
+	return decodeJSON[[]*ipnstate.TKAFilteredPeer](body)
+}
+
+// NetworkLockSignRequest describes a node to sign with NetworkLockSignBulk.
+type NetworkLockSignRequest struct {
+	NodeKey key.NodePublic
+	// RotationPublic, if specified, must be an ed25519 public key.
+	RotationPublic []byte
+}
+
+// NetworkLockSignBulk signs the specified node-keys and transmits the
+// signatures to the control plane. Unlike NetworkLockSign, it doesn't stop at
+// the first node that fails to be signed; the returned map has the error of
+// each of them.
+func (lc *LocalClient) NetworkLockSignBulk(ctx context.Context, reqs []NetworkLockSignRequest) (failed map[key.NodePublic]error, err error) {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(reqs); err != nil {
+		return nil, err
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/tka/sign-bulk", 200, &b)
+	if err != nil {
+		return nil, fmt.Errorf("error: %w", err)
+	}
+	res, err := decodeJSON[[]struct {
+		NodeKey key.NodePublic
+		Error   string
+	}](body)
+	if err != nil {
+		return nil, err
+	}
+	failed = make(map[key.NodePublic]error)
+	for _, r := range res {
+		if r.Error != "" {
+			failed[r.NodeKey] = errors.New(r.Error)
+		}
+	}
+	return failed, nil
+}
+
+// PathStats returns the statistics of the paths to each peer: whether it's
+// reached directly or through DERP, the round-trip times of its endpoints,
+// its last WireGuard handshake and its recent path changes.
+func (lc *LocalClient) PathStats(ctx context.Context) (*ipnstate.PathStats, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/path-stats")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipnstate.PathStats](body)
+}
+
+// ServeStats returns the request counters of the serve and funnel web
+// handlers, and their most recent requests.
+func (lc *LocalClient) ServeStats(ctx context.Context) (*ipnstate.ServeStats, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/serve-stats")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipnstate.ServeStats](body)
+}
+
+// FunnelScheduleStatus returns the state of the Funnel schedules of the
+// serve config, which allow Funnel traffic only part-time, and of the
+// certificates provisioned ahead of them opening.
+func (lc *LocalClient) FunnelScheduleStatus(ctx context.Context) (*ipnstate.FunnelScheduleStatus, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/serve-funnel-schedule")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipnstate.FunnelScheduleStatus](body)
+}
+
 // GetServeConfig return the current serve config.
 //
 // If the serve config is empty, it returns (nil, nil).
@@ -1298,10 +1511,45 @@ func (lc *LocalClient) SwitchProfile(ctx context.Context, profile ipn.ProfileID)
 // If the profile is the current profile, an empty profile
 // will be selected as if SwitchToEmptyProfile was called.
 func (lc *LocalClient) DeleteProfile(ctx context.Context, profile ipn.ProfileID) error {
-	_, err := lc.send(ctx, "DELETE", "/localapi/v0/profiles"+url.PathEscape(string(profile)), http.StatusNoContent, nil)
+	_, err := lc.send(ctx, "DELETE", "/localapi/v0/profiles/"+url.PathEscape(string(profile)), http.StatusNoContent, nil)
 	return err
 }
 
+// AllProfiles returns the profiles of all local users, along with who owns
+// them. It requires administrator access.
+func (lc *LocalClient) AllProfiles(ctx context.Context) ([]ipn.ProfileOwnership, error) {
+	body, err := lc.send(ctx, "GET", "/localapi/v0/profiles/all", 200, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]ipn.ProfileOwnership](body)
+}
+
+// Sessions returns the clients of tailscaled that currently have LocalAPI
+// requests in flight, oldest first.
+func (lc *LocalClient) Sessions(ctx context.Context) ([]apitype.LocalSession, error) {
+	body, err := lc.send(ctx, "GET", "/localapi/v0/sessions", 200, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]apitype.LocalSession](body)
+}
+
+// DetachSessions disconnects all clients of tailscaled with LocalAPI requests
+// in flight, such as the GUI of another user still connected after a fast
+// user switch on Windows, and returns how many were detached.
+func (lc *LocalClient) DetachSessions(ctx context.Context) (int, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/sessions/detach", 200, nil)
+	if err != nil {
+		return 0, err
+	}
+	res, err := decodeJSON[apitype.DetachSessionsResponse](body)
+	if err != nil {
+		return 0, err
+	}
+	return res.Detached, nil
+}
+
 // QueryFeature makes a request for instructions on how to enable
 // a feature, such as Funnel, for the node's tailnet. If relevant,
 // this includes a control server URL the user can visit to enable
@@ -1341,6 +1589,23 @@ func (lc *LocalClient) DebugPacketFilterRules(ctx context.Context) ([]tailcfg.Fi
 	return decodeJSON[[]tailcfg.FilterRule](body)
 }
 
+// DebugPacketFilterCheck evaluates the node's current packet filter for a
+// hypothetical incoming packet from src to dst:port using protocol proto,
+// without sending any packets.
+func (lc *LocalClient) DebugPacketFilterCheck(ctx context.Context, src, dst netip.Addr, port uint16, proto ipproto.Proto) (*apitype.PacketFilterCheckResponse, error) {
+	v := url.Values{
+		"src":   {src.String()},
+		"dst":   {dst.String()},
+		"port":  {strconv.Itoa(int(port))},
+		"proto": {strconv.Itoa(int(proto))},
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/debug-packet-filter-check?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.PacketFilterCheckResponse](body)
+}
+
 // DebugSetExpireIn marks the current node key to expire in d.
 //
 // This is meant primarily for debug and testing.
@@ -1448,6 +1713,18 @@ func (lc *LocalClient) TailFSShareRemove(ctx context.Context, name string) error
 	return err
 }
 
+// TailFSShareRename renames the share oldName to newName, keeping the rest of
+// its configuration.
+func (lc *LocalClient) TailFSShareRename(ctx context.Context, oldName, newName string) error {
+	_, err := lc.send(
+		ctx,
+		"POST",
+		"/localapi/v0/tailfs/shares",
+		http.StatusNoContent,
+		jsonBody([2]string{oldName, newName}))
+	return err
+}
+
 // TailFSShareList returns the list of shares that TailFS is currently serving
 // to remote nodes.
 func (lc *LocalClient) TailFSShareList(ctx context.Context) (map[string]*tailfs.Share, error) {
@@ -1460,6 +1737,54 @@ func (lc *LocalClient) TailFSShareList(ctx context.Context) (map[string]*tailfs.
 	return shares, err
 }
 
+// TailFSShareUsage reports how much each share that TailFS is serving to
+// remote nodes is being used, sorted by name.
+func (lc *LocalClient) TailFSShareUsage(ctx context.Context) ([]*tailfs.ShareUsage, error) {
+	result, err := lc.get200(ctx, "/localapi/v0/tailfs/usage")
+	if err != nil {
+		return nil, err
+	}
+	var usage []*tailfs.ShareUsage
+	err = json.Unmarshal(result, &usage)
+	return usage, err
+}
+
+// TailFSAuditLog returns the recorded operations of remote nodes on the
+// shares that TailFS is serving, oldest first. If since is non-zero, only
+// operations that finished after it are returned.
+func (lc *LocalClient) TailFSAuditLog(ctx context.Context, since time.Time) ([]*tailfs.AuditEntry, error) {
+	p := "/localapi/v0/tailfs/audit"
+	if !since.IsZero() {
+		p += "?since=" + url.QueryEscape(since.Format(time.RFC3339Nano))
+	}
+	result, err := lc.get200(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*tailfs.AuditEntry
+	err = json.Unmarshal(result, &entries)
+	return entries, err
+}
+
+// TailFSTransfers returns the files being read or written on remote TailFS
+// shares by local clients, sorted by ID.
+func (lc *LocalClient) TailFSTransfers(ctx context.Context) ([]*tailfs.Transfer, error) {
+	result, err := lc.get200(ctx, "/localapi/v0/tailfs/transfers")
+	if err != nil {
+		return nil, err
+	}
+	var transfers []*tailfs.Transfer
+	err = json.Unmarshal(result, &transfers)
+	return transfers, err
+}
+
+// TailFSCancelTransfer cancels the transfer with the given ID, failing it
+// for the client.
+func (lc *LocalClient) TailFSCancelTransfer(ctx context.Context, id int64) error {
+	_, err := lc.send(ctx, "DELETE", "/localapi/v0/tailfs/transfers?id="+strconv.FormatInt(id, 10), http.StatusNoContent, nil)
+	return err
+}
+
 // IPNBusWatcher is an active subscription (watch) of the local tailscaled IPN bus.
 // It's returned by LocalClient.WatchIPNBus.
 //
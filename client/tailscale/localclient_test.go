@@ -6,8 +6,13 @@
 package tailscale
 
 import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"tailscale.com/ipn"
 	"tailscale.com/tstest/deptest"
 )
 
@@ -30,6 +35,30 @@ func TestGetServeConfigFromJSON(t *testing.T) {
 	}
 }
 
+func TestDeleteProfile(t *testing.T) {
+	var gotMethod, gotPath string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod, gotPath = r.Method, r.URL.EscapedPath()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	lc := &LocalClient{
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", ts.Listener.Addr().String())
+		},
+	}
+
+	err := lc.DeleteProfile(context.Background(), ipn.ProfileID("1a2b/c"))
+	lc.tsClient.CloseIdleConnections()
+	if err != nil {
+		t.Fatalf("DeleteProfile: %v", err)
+	}
+	if want := "/localapi/v0/profiles/1a2b%2Fc"; gotMethod != "DELETE" || gotPath != want {
+		t.Errorf("DeleteProfile sent %s %s; want DELETE %s", gotMethod, gotPath, want)
+	}
+}
+
 func TestDeps(t *testing.T) {
 	deptest.DepChecker{
 		BadDeps: map[string]string{
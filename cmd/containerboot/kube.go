@@ -6,14 +6,22 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/netip"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/encstore"
+	"tailscale.com/ipn/store/mem"
 	"tailscale.com/kube"
 	"tailscale.com/tailcfg"
 )
@@ -32,22 +40,11 @@ func findKeyInKubeSecret(ctx context.Context, secretName string) (string, error)
 	return string(ak), nil
 }
 
-// storeDeviceInfo writes deviceID into the "device_id" data field of the kube
-// secret secretName.
-func storeDeviceInfo(ctx context.Context, secretName string, deviceID tailcfg.StableNodeID, fqdn string, addresses []netip.Prefix) error {
-	// First check if the secret exists at all. Even if running on
-	// kubernetes, we do not necessarily store state in a k8s secret.
-	if _, err := kc.GetSecret(ctx, secretName); err != nil {
-		if s, ok := err.(*kube.Status); ok {
-			if s.Code >= 400 && s.Code <= 499 {
-				// Assume the secret doesn't exist, or we don't have
-				// permission to access it.
-				return nil
-			}
-		}
-		return err
-	}
-
+// storeDeviceInfo writes deviceID, fqdn, addresses and the approved subnet
+// routes into the "device_id", "device_fqdn", "device_ips" and
+// "device_routes" data fields of the kube secret secretName, removing its
+// stale fields (see staleSecretFields) in the same patch.
+func storeDeviceInfo(ctx context.Context, secretName string, authOnce bool, deviceID tailcfg.StableNodeID, fqdn string, addresses, routes []netip.Prefix) error {
 	var ips []string
 	for _, addr := range addresses {
 		ips = append(ips, addr.Addr().String())
@@ -56,15 +53,186 @@ func storeDeviceInfo(ctx context.Context, secretName string, deviceID tailcfg.St
 	if err != nil {
 		return err
 	}
+	routeStrs := make([]string, 0, len(routes))
+	for _, r := range routes {
+		routeStrs = append(routeStrs, r.String())
+	}
+	deviceRoutes, err := json.Marshal(routeStrs)
+	if err != nil {
+		return err
+	}
+	return patchStateSecret(ctx, secretName, authOnce, map[string][]byte{
+		"device_id":     []byte(deviceID),
+		"device_fqdn":   []byte(fqdn),
+		"device_ips":    deviceIPs,
+		"device_routes": deviceRoutes,
+	})
+}
 
-	m := &kube.Secret{
-		Data: map[string][]byte{
-			"device_id":   []byte(deviceID),
-			"device_fqdn": []byte(fqdn),
-			"device_ips":  deviceIPs,
-		},
+// stateSecretCompactInterval is how often containerboot removes the stale
+// fields of its state secret, in addition to whenever it stores device info.
+const stateSecretCompactInterval = 6 * time.Hour
+
+// compactStateSecretPeriodically calls compactStateSecret for cfg's kube
+// secret every stateSecretCompactInterval until ctx is done.
+func compactStateSecretPeriodically(ctx context.Context, cfg *settings) {
+	t := time.NewTicker(stateSecretCompactInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if err := compactStateSecret(ctx, cfg.KubeSecret, cfg.AuthOnce); err != nil {
+			log.Printf("error removing stale fields from kube secret: %v", err)
+		}
+	}
+}
+
+// compactStateSecret removes the stale fields (see staleSecretFields) of the
+// kube secret secretName, so that it doesn't grow without bound over many
+// restarts and re-logins.
+func compactStateSecret(ctx context.Context, secretName string, authOnce bool) error {
+	return patchStateSecret(ctx, secretName, authOnce, nil)
+}
+
+// patchStateSecret sets the data fields of the kube secret secretName to set
+// and removes its stale fields, in a single patch that only applies if the
+// secret didn't change since it was read, so that fields tailscaled writes
+// concurrently are never lost. It retries a few times if the secret did
+// change.
+func patchStateSecret(ctx context.Context, secretName string, authOnce bool, set map[string][]byte) error {
+	const maxTries = 3
+	for try := 1; ; try++ {
+		s, err := kc.GetSecret(ctx, secretName)
+		if err != nil {
+			if s, ok := err.(*kube.Status); ok {
+				if s.Code >= 400 && s.Code <= 499 {
+					// Even if running on kubernetes, we do not
+					// necessarily store state in a k8s secret.
+					// Assume the secret doesn't exist, or we
+					// don't have permission to access it.
+					return nil
+				}
+			}
+			return err
+		}
+		stale := staleSecretFields(s, authOnce)
+		patch := stateSecretPatch(s, stale, set)
+		if len(patch) == 0 {
+			return nil
+		}
+		if len(stale) > 0 {
+			log.Printf("Removing stale fields %q from kube secret", stale)
+		}
+		err = kc.JSONPatchSecret(ctx, secretName, patch)
+		if s, ok := err.(*kube.Status); ok && (s.Code == http.StatusConflict || s.Code == http.StatusUnprocessableEntity) && try < maxTries {
+			// The secret changed since we read it, so the
+			// resourceVersion test failed.
+			continue
+		}
+		return err
+	}
+}
+
+// stateSecretPatch returns the JSON patch that sets the data fields of the
+// kube secret s to set and removes its fields named in stale, or nil if
+// there's nothing to change.
+func stateSecretPatch(s *kube.Secret, stale []string, set map[string][]byte) []kube.JSONPatch {
+	var patch []kube.JSONPatch
+	for _, k := range stale {
+		patch = append(patch, kube.JSONPatch{Op: "remove", Path: "/data/" + k})
+	}
+	if len(s.Data) == 0 && len(set) > 0 {
+		patch = append(patch, kube.JSONPatch{Op: "add", Path: "/data", Value: set})
+	} else {
+		keys := make([]string, 0, len(set))
+		for k := range set {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			if v, ok := s.Data[k]; ok && bytes.Equal(v, set[k]) {
+				continue
+			}
+			patch = append(patch, kube.JSONPatch{Op: "add", Path: "/data/" + k, Value: set[k]})
+		}
+	}
+	if len(patch) == 0 {
+		return nil
+	}
+	test := kube.JSONPatch{Op: "test", Path: "/metadata/resourceVersion", Value: s.ResourceVersion}
+	return append([]kube.JSONPatch{test}, patch...)
+}
+
+// staleSecretFields returns the sorted names of the data fields of the state
+// secret s that are no longer needed: the state of login profiles that
+// tailscaled no longer knows about and, if the authkey is only used for the
+// first login (TS_AUTH_ONCE) and tailscaled has a profile, the authkey.
+func staleSecretFields(s *kube.Secret, authOnce bool) []string {
+	profilesJSON, ok := s.Data[string(ipn.KnownProfilesStateKey)]
+	if !ok {
+		// Not logged in yet, or tailscaled's state isn't stored
+		// here.
+		return nil
+	}
+	if encstore.IsEncrypted(profilesJSON) {
+		var err error
+		profilesJSON, err = readEncryptedSecretState(s, ipn.KnownProfilesStateKey)
+		if err != nil {
+			log.Printf("error decrypting profiles in kube secret, not removing stale fields: %v", err)
+			return nil
+		}
+	}
+	var profiles map[ipn.ProfileID]ipn.LoginProfile
+	if err := json.Unmarshal(profilesJSON, &profiles); err != nil {
+		log.Printf("error parsing profiles in kube secret, not removing stale fields: %v", err)
+		return nil
+	}
+	known := make(map[string]bool)
+	for _, p := range profiles {
+		// Profile keys are "profile-" and a hex ID, so the kube
+		// store doesn't need to sanitize them.
+		known[string(p.Key)] = true
+	}
+	var stale []string
+	for k := range s.Data {
+		if strings.HasPrefix(k, "profile-") && !known[k] {
+			stale = append(stale, k)
+		}
+	}
+	if _, ok := s.Data["authkey"]; ok && authOnce && len(profiles) > 0 {
+		stale = append(stale, "authkey")
 	}
-	return kc.StrategicMergePatchSecret(ctx, secretName, m, "tailscale-container")
+	slices.Sort(stale)
+	return stale
+}
+
+// readEncryptedSecretState returns the state id from the state secret s,
+// whose values tailscaled encrypts (TS_STATE_ENCRYPTION_KEY). tailscaled
+// shares our environment, so we load the key the same way it does.
+func readEncryptedSecretState(s *kube.Secret, id ipn.StateKey) ([]byte, error) {
+	ks := os.Getenv("TS_STATE_ENCRYPTION_KEY")
+	if ks == "" {
+		return nil, errors.New("state is encrypted, but TS_STATE_ENCRYPTION_KEY is not set")
+	}
+	// Read it through a copy of the secret, so that decrypting never
+	// writes to the secret itself. The keys that matter here don't need
+	// sanitizing, so they're the same in the secret and the store.
+	data, err := json.Marshal(s.Data)
+	if err != nil {
+		return nil, err
+	}
+	inner := new(mem.Store)
+	if err := inner.LoadFromJSON(data); err != nil {
+		return nil, err
+	}
+	es, err := encstore.New(log.Printf, inner, ks)
+	if err != nil {
+		return nil, err
+	}
+	return es.ReadState(id)
 }
 
 // deleteAuthKey deletes the 'authkey' field of the given kube
@@ -107,3 +275,61 @@ func initKube(root string) {
 		kc.SetURL(fmt.Sprintf("https://%s:%s", os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT_HTTPS")))
 	}
 }
+
+// podRef refers to the Pod that containerboot runs in. It is only set if
+// Events can be posted on the Pod, see initKubeEvents.
+var podRef *kube.ObjectReference
+
+// initKubeEvents enables posting Kubernetes Events on containerboot's Pod if
+// the Pod's name is known and its service account is allowed to create Events.
+// Events are purely informational, so missing permissions are only logged.
+func initKubeEvents(ctx context.Context, cfg *settings) {
+	if cfg.PodName == "" {
+		return
+	}
+	ok, err := kc.CheckEventPermissions(ctx)
+	if err != nil {
+		log.Printf("error checking permission to create Kubernetes Events, not emitting Events: %v", err)
+		return
+	}
+	if !ok {
+		log.Printf("Pod is not permitted to create Kubernetes Events, not emitting Events")
+		return
+	}
+	podRef = &kube.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Name:       cfg.PodName,
+		UID:        cfg.PodUID,
+	}
+}
+
+// recordEvent posts an Event of the given type (kube.EventTypeNormal or
+// kube.EventTypeWarning) on containerboot's Pod, so that it shows up in
+// `kubectl describe pod`. It does nothing if Events are not enabled.
+func recordEvent(ctx context.Context, typ, reason, msg string) {
+	if podRef == nil {
+		return
+	}
+	now := time.Now()
+	e := &kube.Event{
+		TypeMeta: kube.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Event",
+		},
+		ObjectMeta: kube.ObjectMeta{
+			Name: fmt.Sprintf("%s.%x", podRef.Name, now.UnixNano()),
+		},
+		InvolvedObject: *podRef,
+		Reason:         reason,
+		Message:        msg,
+		Source:         kube.EventSource{Component: "containerboot"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           typ,
+	}
+	if err := kc.CreateEvent(ctx, e); err != nil {
+		log.Printf("error posting %s Kubernetes Event: %v", reason, err)
+	}
+}
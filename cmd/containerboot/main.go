@@ -12,6 +12,13 @@
 // variables. All configuration is optional.
 //
 //   - TS_AUTHKEY: the authkey to use for login.
+//   - TS_AUTHKEY_FILE: the path of a file containing the authkey to use for
+//     login, such as a mounted Kubernetes Secret, for when secrets can't be
+//     passed in environment variables. It cannot be used in conjunction with
+//     TS_AUTHKEY. If login fails, containerboot waits for the file to change
+//     (e.g. because the Secret was rotated) and tries again, for as long as
+//     it takes. If tailscaled needs to log in again later, containerboot
+//     logs in with the key then in the file, the same way.
 //   - TS_HOSTNAME: the hostname to request for the node.
 //   - TS_ROUTES: subnet routes to advertise. Explicitly setting it to an empty
 //     value will cause containerboot to stop acting as a subnet router for any
@@ -30,6 +37,12 @@
 //   - TS_STATE_DIR: the directory in which to store tailscaled
 //     state. The data should persist across container
 //     restarts.
+//   - TS_STATE_ENCRYPTION_KEY: if set, tailscaled encrypts the state it
+//     stores in TS_KUBE_SECRET or TS_STATE_DIR with the key from this key
+//     source, like "file:/run/secrets/ts-state-key", "env:VAR" or
+//     "awsssm:<SecureString parameter ARN>". It's read by tailscaled
+//     itself. Set TS_STATE_ENCRYPTION_MIGRATE=true to let tailscaled read
+//     state that is still plaintext, such as when enabling encryption.
 //   - TS_ACCEPT_DNS: whether to use the tailnet's DNS configuration.
 //   - TS_KUBE_SECRET: the name of the Kubernetes secret in which to
 //     store tailscaled state.
@@ -37,8 +50,23 @@
 //     proxying into the tailnet.
 //   - TS_OUTBOUND_HTTP_PROXY_LISTEN: the address on which to listen
 //     for HTTP proxying into the tailnet.
+//   - TS_PROXY_METRICS_LISTEN: the address on which to serve, at
+//     /metrics, the number of packets and bytes that went through the
+//     proxy rules installed for TS_DEST_IP, TS_TAILNET_TARGET_IP or
+//     TS_TAILNET_TARGET_FQDN, in Prometheus format.
 //   - TS_SOCKET: the path where the tailscaled LocalAPI socket should
 //     be created.
+//   - TS_INIT_ONLY: if true, only set up what tailscaled needs privileges
+//     for, and then exit: create the tun device, enable IP forwarding if
+//     proxying or advertising routes, and check that the firewall can be
+//     configured if proxying. This is meant for a privileged initContainer,
+//     so that the main container doesn't need to be privileged or have the
+//     MKNOD capability. Sysctls and the firewall are per network namespace,
+//     which the initContainer shares with the main container, but the tun
+//     device is only shared if both containers mount the same volume (such
+//     as an emptyDir) at /dev/net, where tailscaled looks for it. The main
+//     container still needs the NET_ADMIN capability. It is not supported
+//     with TS_USERSPACE.
 //   - TS_AUTH_ONCE: if true, only attempt to log in if not already
 //     logged in. If false (the default, for backwards
 //     compatibility), forcibly log in every time the
@@ -50,7 +78,7 @@
 //     and will be re-applied when it changes.
 //   - EXPERIMENTAL_TS_CONFIGFILE_PATH: if specified, a path to tailscaled
 //     config. If this is set, TS_HOSTNAME, TS_EXTRA_ARGS, TS_AUTHKEY,
-//     TS_ROUTES, TS_ACCEPT_DNS env vars must not be set. If this is set,
+//     TS_AUTHKEY_FILE, TS_ROUTES, TS_ACCEPT_DNS env vars must not be set. If this is set,
 //     containerboot only runs `tailscaled --config <path-to-this-configfile>`
 //     and not `tailscale up` or `tailscale set`.
 //     The config file contents are currently read once on container start.
@@ -70,8 +98,15 @@
 // TS_KUBE_SECRET="" and TS_STATE_DIR=/path/to/storage/dir. The state dir should
 // be persistent storage.
 //
-// Additionally, if TS_AUTHKEY is not set and the TS_KUBE_SECRET contains an
-// "authkey" field, that key is used as the tailscale authkey.
+// containerboot and tailscaled only write to the directories of TS_SOCKET
+// and TS_STATE_DIR (both /tmp by default), so the container can run with a
+// read-only root filesystem as long as those are writable.
+//
+// Additionally, if neither TS_AUTHKEY nor TS_AUTHKEY_FILE is set and the
+// TS_KUBE_SECRET contains an "authkey" field, that key is used as the
+// tailscale authkey. If containerboot may patch TS_KUBE_SECRET, it also
+// periodically removes the fields that tailscaled no longer uses from it,
+// such as the state of old login profiles.
 package main
 
 import (
@@ -100,9 +135,11 @@
 	"tailscale.com/client/tailscale"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/conffile"
+	"tailscale.com/kube"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/ptr"
+	"tailscale.com/types/views"
 	"tailscale.com/util/deephash"
 	"tailscale.com/util/linuxfw"
 )
@@ -119,6 +156,7 @@ func main() {
 	tailscale.I_Acknowledge_This_API_Is_Unstable = true
 	cfg := &settings{
 		AuthKey:                               defaultEnvs([]string{"TS_AUTHKEY", "TS_AUTH_KEY"}, ""),
+		AuthKeyFile:                           defaultEnv("TS_AUTHKEY_FILE", ""),
 		Hostname:                              defaultEnv("TS_HOSTNAME", ""),
 		Routes:                                defaultEnvStringPointer("TS_ROUTES"),
 		ServeConfigPath:                       defaultEnv("TS_SERVE_CONFIG", ""),
@@ -130,16 +168,21 @@ func main() {
 		InKubernetes:                          os.Getenv("KUBERNETES_SERVICE_HOST") != "",
 		UserspaceMode:                         defaultBool("TS_USERSPACE", true),
 		StateDir:                              defaultEnv("TS_STATE_DIR", ""),
+		EncryptState:                          os.Getenv("TS_STATE_ENCRYPTION_KEY") != "",
 		AcceptDNS:                             defaultEnvBoolPointer("TS_ACCEPT_DNS"),
 		KubeSecret:                            defaultEnv("TS_KUBE_SECRET", "tailscale"),
 		SOCKSProxyAddr:                        defaultEnv("TS_SOCKS5_SERVER", ""),
 		HTTPProxyAddr:                         defaultEnv("TS_OUTBOUND_HTTP_PROXY_LISTEN", ""),
+		ProxyMetricsAddr:                      defaultEnv("TS_PROXY_METRICS_LISTEN", ""),
 		Socket:                                defaultEnv("TS_SOCKET", "/tmp/tailscaled.sock"),
 		AuthOnce:                              defaultBool("TS_AUTH_ONCE", false),
+		InitOnly:                              defaultBool("TS_INIT_ONLY", false),
 		Root:                                  defaultEnv("TS_TEST_ONLY_ROOT", "/"),
 		TailscaledConfigFilePath:              defaultEnv("EXPERIMENTAL_TS_CONFIGFILE_PATH", ""),
 		AllowProxyingClusterTrafficViaIngress: defaultBool("EXPERIMENTAL_ALLOW_PROXYING_CLUSTER_TRAFFIC_VIA_INGRESS", false),
 		PodIP:                                 defaultEnv("POD_IP", ""),
+		PodName:                               defaultEnv("POD_NAME", ""),
+		PodUID:                                defaultEnv("POD_UID", ""),
 	}
 
 	if err := cfg.validate(); err != nil {
@@ -147,6 +190,8 @@ func main() {
 	}
 
 	if !cfg.UserspaceMode {
+		// In an initContainer, this creates the device in the volume
+		// shared with the main container at /dev/net; see TS_INIT_ONLY.
 		if err := ensureTunFile(cfg.Root); err != nil {
 			log.Fatalf("Unable to create tuntap device file: %v", err)
 		}
@@ -155,7 +200,7 @@ func main() {
 				log.Printf("Failed to enable IP forwarding: %v", err)
 				log.Printf("To run tailscale as a proxy or router container, IP forwarding must be enabled.")
 				if cfg.InKubernetes {
-					log.Fatalf("You can either set the sysctls as a privileged initContainer, or run the tailscale container with privileged=true.")
+					log.Fatalf("You can either set the sysctls in a privileged initContainer running containerboot with TS_INIT_ONLY=true, or run the tailscale container with privileged=true.")
 				} else {
 					log.Fatalf("You can fix this by running the container with privileged=true, or the equivalent in your container runtime that permits access to sysctls.")
 				}
@@ -163,6 +208,23 @@ func main() {
 		}
 	}
 
+	if cfg.InitOnly {
+		if cfg.ProxyTo != "" || cfg.TailnetTargetIP != "" || cfg.TailnetTargetFQDN != "" || cfg.AllowProxyingClusterTrafficViaIngress {
+			// The firewall is per network namespace too, so check
+			// now that it can be configured, as the main container
+			// may not be able to tell why it can't.
+			nfr, err := newNetfilterRunner(log.Printf)
+			if err != nil {
+				log.Fatalf("Unable to configure the firewall: %v", err)
+			}
+			if caps := nfr.IPv6Capabilities(); !caps.NAT {
+				log.Printf("IPv6 NAT is not available, only IPv4 traffic will be proxied: %s", caps.Reason)
+			}
+		}
+		log.Printf("Init complete, exiting")
+		return
+	}
+
 	if cfg.InKubernetes {
 		initKube(cfg.Root)
 	}
@@ -170,8 +232,8 @@ func main() {
 	// Context is used for all setup stuff until we're in steady
 	// state, so that if something is hanging we eventually time out
 	// and crashloop the container.
-	bootCtx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
+	bootCtx, bootCancel := context.WithTimeout(context.Background(), bootTimeout)
+	defer func() { bootCancel() }()
 
 	if cfg.InKubernetes && cfg.KubeSecret != "" {
 		canPatch, err := kc.CheckSecretPermissions(bootCtx, cfg.KubeSecret)
@@ -180,7 +242,7 @@ func main() {
 		}
 		cfg.KubernetesCanPatch = canPatch
 
-		if cfg.AuthKey == "" && !isOneStepConfig(cfg) {
+		if cfg.AuthKey == "" && cfg.AuthKeyFile == "" && !isOneStepConfig(cfg) {
 			key, err := findKeyInKubeSecret(bootCtx, cfg.KubeSecret)
 			if err != nil {
 				log.Fatalf("Getting authkey from kube secret: %v", err)
@@ -203,6 +265,9 @@ func main() {
 			}
 		}
 	}
+	if cfg.InKubernetes {
+		initKubeEvents(bootCtx, cfg)
+	}
 
 	client, daemonProcess, err := startTailscaled(bootCtx, cfg)
 	if err != nil {
@@ -233,7 +298,12 @@ func main() {
 		if err == nil {
 			err = syscall.Symlink(cfg.Socket, defaultTailscaledSocketPath)
 		}
-		if err != nil {
+		if errors.Is(err, syscall.EROFS) {
+			// The container runs with a read-only root
+			// filesystem, which is fine: nothing but the CLI
+			// needs the symlink.
+			log.Printf("Not symlinking socket on read-only filesystem. To interact with the Tailscale CLI please use `tailscale --socket=%q`", cfg.Socket)
+		} else if err != nil {
 			log.Printf("[warning] failed to symlink socket: %v\n\tTo interact with the Tailscale CLI please use `tailscale --socket=%q`", err, cfg.Socket)
 		}
 	}
@@ -252,7 +322,16 @@ func main() {
 		}
 		didLogin = true
 		w.Close()
-		if err := tailscaleUp(bootCtx, cfg); err != nil {
+		if cfg.AuthKeyFile != "" {
+			// Waiting for a new authkey can take much longer than
+			// the boot timeout, as kubelet takes a minute or more
+			// to update a mounted Secret, so don't count it.
+			if err := tailscaleUpWithAuthKeyFile(context.Background(), cfg); err != nil {
+				return fmt.Errorf("failed to auth tailscale: %v", err)
+			}
+			bootCancel()
+			bootCtx, bootCancel = context.WithTimeout(context.Background(), bootTimeout)
+		} else if err := tailscaleUp(bootCtx, cfg); err != nil {
 			return fmt.Errorf("failed to auth tailscale: %v", err)
 		}
 		w, err = client.WatchIPNBus(bootCtx, ipn.NotifyInitialNetMap|ipn.NotifyInitialState)
@@ -275,6 +354,10 @@ func main() {
 			log.Fatalf("failed to read from tailscaled: %v", err)
 		}
 
+		logCaptivePortal(n)
+		if n.BrowseToURL != nil && *n.BrowseToURL != "" {
+			recordEvent(bootCtx, kube.EventTypeWarning, "NeedsLogin", fmt.Sprintf("tailscale needs to be logged in, please visit %s", *n.BrowseToURL))
+		}
 		if n.State != nil {
 			switch *n.State {
 			case ipn.NeedsLogin:
@@ -290,6 +373,7 @@ func main() {
 				}
 			case ipn.NeedsMachineAuth:
 				log.Printf("machine authorization required, please visit the admin panel")
+				recordEvent(bootCtx, kube.EventTypeWarning, "NeedsMachineAuth", "machine authorization required, please approve this device in the admin panel")
 			case ipn.Running:
 				// Technically, all we want is to keep monitoring the bus for
 				// netmap updates. However, in order to make the container crash
@@ -297,6 +381,7 @@ func main() {
 				// startup deadline on it. So, we have to break out of this
 				// watch loop, cancel the watch, and watch again with no
 				// deadline to continue monitoring for changes.
+				recordEvent(bootCtx, kube.EventTypeNormal, "AuthSucceeded", "tailscale is logged in and running")
 				break authLoop
 			default:
 				log.Printf("tailscaled in state %q, waiting", *n.State)
@@ -349,18 +434,29 @@ func main() {
 
 		currentEgressIPs deephash.Sum
 
+		currentUnapprovedRoutes deephash.Sum // advertised routes pending approval
+
 		certDomain        = new(atomic.Pointer[string])
 		certDomainChanged = make(chan bool, 1)
 	)
 	if cfg.ServeConfigPath != "" {
 		go watchServeConfigChanges(ctx, cfg.ServeConfigPath, certDomainChanged, certDomain, client)
 	}
+	if wantDeviceInfo {
+		go compactStateSecretPeriodically(ctx, cfg)
+	}
 	var nfr linuxfw.NetfilterRunner
 	if wantProxy {
 		nfr, err = newNetfilterRunner(log.Printf)
 		if err != nil {
 			log.Fatalf("error creating new netfilter runner: %v", err)
 		}
+		if caps := nfr.IPv6Capabilities(); !caps.NAT {
+			log.Printf("IPv6 NAT is not available, only IPv4 traffic will be proxied: %s", caps.Reason)
+		}
+		if cfg.ProxyMetricsAddr != "" {
+			go runProxyMetrics(cfg.ProxyMetricsAddr, nfr)
+		}
 	}
 	notifyChan := make(chan ipn.Notify)
 	errChan := make(chan error)
@@ -377,6 +473,11 @@ func main() {
 	}()
 	var wg sync.WaitGroup
 
+	// relogin is non-nil while containerboot logs in again with the
+	// authkey in cfg.AuthKeyFile, after tailscaled needed a new login,
+	// and receives the result.
+	var relogin chan error
+
 runLoop:
 	for {
 		select {
@@ -389,7 +490,29 @@ func main() {
 			break runLoop
 		case err := <-errChan:
 			log.Fatalf("failed to read from tailscaled: %v", err)
+		case err := <-relogin:
+			relogin = nil
+			if err != nil && ctx.Err() == nil {
+				log.Fatalf("failed to auth tailscale: %v", err)
+			}
 		case n := <-notifyChan:
+			logCaptivePortal(n)
+			if n.State != nil && *n.State == ipn.NeedsLogin && cfg.AuthKeyFile != "" && relogin == nil {
+				// The node was logged out or its key expired. The
+				// authkey file may have a new key by now, or get
+				// one later, so log in again with it, without
+				// blocking the processing of notifications.
+				log.Printf("tailscaled needs to log in again, using the authkey in %s", cfg.AuthKeyFile)
+				relogin = make(chan error, 1)
+				go func(done chan<- error) {
+					done <- tailscaleUpWithAuthKeyFile(ctx, cfg)
+				}(relogin)
+				break
+			}
+			if n.State != nil && *n.State != ipn.Running && relogin != nil {
+				log.Printf("tailscaled in state %q, waiting for login", *n.State)
+				break
+			}
 			if n.State != nil && *n.State != ipn.Running {
 				// Something's gone wrong and we've left the authenticated state.
 				// Our container image never recovered gracefully from this, and the
@@ -403,13 +526,20 @@ func main() {
 				newCurrentIPs := deephash.Hash(&addrs)
 				ipsHaveChanged := newCurrentIPs != currentIPs
 
+				// The whole set of proxy rules is reconciled
+				// whenever any address it depends on changes, so
+				// rules for stale addresses are removed.
+				var (
+					proxyRules        linuxfw.ProxyRules
+					proxyRulesChanged = ipsHaveChanged
+					proxyRuleEvents   []string
+				)
 				if cfg.TailnetTargetFQDN != "" {
 					var (
-						egressAddrs          []netip.Prefix
-						newCurentEgressIPs   deephash.Sum
-						egressIPsHaveChanged bool
-						node                 tailcfg.NodeView
-						nodeFound            bool
+						egressAddrs        []netip.Prefix
+						newCurentEgressIPs deephash.Sum
+						node               tailcfg.NodeView
+						nodeFound          bool
 					)
 					for _, n := range n.NetMap.Peers {
 						if strings.EqualFold(n.Name(), cfg.TailnetTargetFQDN) {
@@ -424,8 +554,10 @@ func main() {
 					}
 					egressAddrs = node.Addresses().AsSlice()
 					newCurentEgressIPs = deephash.Hash(&egressAddrs)
-					egressIPsHaveChanged = newCurentEgressIPs != currentEgressIPs
-					if egressIPsHaveChanged && len(egressAddrs) > 0 {
+					if newCurentEgressIPs != currentEgressIPs {
+						proxyRulesChanged = true
+					}
+					if len(egressAddrs) > 0 && len(addrs) > 0 {
 						for _, egressAddr := range egressAddrs {
 							ea := egressAddr.Addr()
 							// TODO (irbekrm): make it work for IPv6 too.
@@ -433,19 +565,19 @@ func main() {
 								log.Println("Not installing egress forwarding rules for IPv6 as this is currently not supported")
 								continue
 							}
-							log.Printf("Installing forwarding rules for destination %v", ea.String())
-							if err := installEgressForwardingRule(ctx, ea.String(), addrs, nfr); err != nil {
+							if err := addEgressForwardingRules(&proxyRules, ea.String(), addrs); err != nil {
 								log.Fatalf("installing egress proxy rules for destination %s: %v", ea.String(), err)
 							}
 						}
+						proxyRuleEvents = append(proxyRuleEvents, fmt.Sprintf("installed rules to forward traffic to %s", cfg.TailnetTargetFQDN))
 					}
 					currentEgressIPs = newCurentEgressIPs
 				}
-				if cfg.ProxyTo != "" && len(addrs) > 0 && ipsHaveChanged {
-					log.Printf("Installing proxy rules")
-					if err := installIngressForwardingRule(ctx, cfg.ProxyTo, addrs, nfr); err != nil {
+				if cfg.ProxyTo != "" && len(addrs) > 0 {
+					if err := addIngressForwardingRules(&proxyRules, cfg.ProxyTo, addrs); err != nil {
 						log.Fatalf("installing ingress proxy rules: %v", err)
 					}
+					proxyRuleEvents = append(proxyRuleEvents, fmt.Sprintf("installed rules to forward tailnet traffic to %s", cfg.ProxyTo))
 				}
 				if cfg.ServeConfigPath != "" && len(n.NetMap.DNS.CertDomains) > 0 {
 					cd := n.NetMap.DNS.CertDomains[0]
@@ -457,11 +589,11 @@ func main() {
 						}
 					}
 				}
-				if cfg.TailnetTargetIP != "" && ipsHaveChanged && len(addrs) > 0 {
-					log.Printf("Installing forwarding rules for destination %v", cfg.TailnetTargetIP)
-					if err := installEgressForwardingRule(ctx, cfg.TailnetTargetIP, addrs, nfr); err != nil {
+				if cfg.TailnetTargetIP != "" && len(addrs) > 0 {
+					if err := addEgressForwardingRules(&proxyRules, cfg.TailnetTargetIP, addrs); err != nil {
 						log.Fatalf("installing egress proxy rules: %v", err)
 					}
+					proxyRuleEvents = append(proxyRuleEvents, fmt.Sprintf("installed rules to forward traffic to %s", cfg.TailnetTargetIP))
 				}
 				// If this is a L7 cluster ingress proxy (set up
 				// by Kubernetes operator) and proxying of
@@ -469,17 +601,40 @@ func main() {
 				// enabled, set up proxy rule each time the
 				// tailnet IPs of this node change (including
 				// the first time they become available).
-				if cfg.AllowProxyingClusterTrafficViaIngress && cfg.ServeConfigPath != "" && ipsHaveChanged && len(addrs) > 0 {
-					log.Printf("installing rules to forward traffic for %s to node's tailnet IP", cfg.PodIP)
-					if err := installTSForwardingRuleForDestination(ctx, cfg.PodIP, addrs, nfr); err != nil {
+				if cfg.AllowProxyingClusterTrafficViaIngress && cfg.ServeConfigPath != "" && len(addrs) > 0 {
+					if err := addTSForwardingRulesForDestination(&proxyRules, cfg.PodIP, addrs); err != nil {
 						log.Fatalf("installing rules to forward traffic to node's tailnet IP: %v", err)
 					}
 				}
+				if nfr != nil && proxyRulesChanged && len(addrs) > 0 {
+					log.Printf("Installing proxy rules")
+					if err := nfr.EnsureProxyRules(proxyRules); errors.Is(err, linuxfw.ErrIPv6NATUnsupported) {
+						// The IPv4 rules are installed; keep
+						// proxying IPv4 traffic only.
+						msg := fmt.Sprintf("not proxying IPv6 traffic: %v (%s)", err, nfr.IPv6Capabilities().Reason)
+						log.Printf("Warning: %s", msg)
+						recordEvent(ctx, kube.EventTypeWarning, "IPv6ProxyUnsupported", msg)
+					} else if err != nil {
+						log.Fatalf("installing proxy rules: %v", err)
+					}
+					for _, msg := range proxyRuleEvents {
+						recordEvent(ctx, kube.EventTypeNormal, "ProxyRulesInstalled", msg)
+					}
+				}
 				currentIPs = newCurrentIPs
 
-				deviceInfo := []any{n.NetMap.SelfNode.StableID(), n.NetMap.SelfNode.Name()}
+				if cfg.Routes != nil {
+					unapproved := unapprovedRoutes(*cfg.Routes, n.NetMap.SelfNode)
+					if deephash.Update(&currentUnapprovedRoutes, &unapproved) && len(unapproved) > 0 {
+						log.Printf("advertised routes %v are not yet approved", unapproved)
+						recordEvent(ctx, kube.EventTypeWarning, "RoutesNotApproved", fmt.Sprintf("advertised routes %v are pending approval in the admin panel", unapproved))
+					}
+				}
+
+				approved := approvedRoutes(n.NetMap.SelfNode)
+				deviceInfo := []any{n.NetMap.SelfNode.StableID(), n.NetMap.SelfNode.Name(), approved}
 				if cfg.InKubernetes && cfg.KubernetesCanPatch && cfg.KubeSecret != "" && deephash.Update(&currentDeviceInfo, &deviceInfo) {
-					if err := storeDeviceInfo(ctx, cfg.KubeSecret, n.NetMap.SelfNode.StableID(), n.NetMap.SelfNode.Name(), n.NetMap.SelfNode.Addresses().AsSlice()); err != nil {
+					if err := storeDeviceInfo(ctx, cfg.KubeSecret, cfg.AuthOnce, n.NetMap.SelfNode.StableID(), n.NetMap.SelfNode.Name(), n.NetMap.SelfNode.Addresses().AsSlice(), approved); err != nil {
 						log.Fatalf("storing device ID in kube secret: %v", err)
 					}
 				}
@@ -522,6 +677,20 @@ func main() {
 	wg.Wait()
 }
 
+// logCaptivePortal logs changes to whether tailscaled has detected that the
+// container's network is behind a captive portal, which would otherwise only
+// show up as a failure to connect.
+func logCaptivePortal(n ipn.Notify) {
+	if n.CaptivePortalDetected == nil {
+		return
+	}
+	if *n.CaptivePortalDetected {
+		log.Printf("captive portal detected on the network; tailscale cannot connect until it is logged in to")
+	} else {
+		log.Printf("no longer behind a captive portal")
+	}
+}
+
 // watchServeConfigChanges watches path for changes, and when it sees one, reads
 // the serve config from it, replacing ${TS_CERT_DOMAIN} with certDomain, and
 // applies it to lc. It exits when ctx is canceled. cdChanged is a channel that
@@ -640,12 +809,19 @@ func tailscaledArgs(cfg *settings) []string {
 	args := []string{"--socket=" + cfg.Socket}
 	switch {
 	case cfg.InKubernetes && cfg.KubeSecret != "":
-		args = append(args, "--state=kube:"+cfg.KubeSecret)
+		if cfg.EncryptState {
+			args = append(args, "--state=encrypted-kube:"+cfg.KubeSecret)
+		} else {
+			args = append(args, "--state=kube:"+cfg.KubeSecret)
+		}
 		if cfg.StateDir == "" {
 			cfg.StateDir = "/tmp"
 		}
-		fallthrough
+		args = append(args, "--statedir="+cfg.StateDir)
 	case cfg.StateDir != "":
+		if cfg.EncryptState {
+			args = append(args, "--state=encrypted-file:"+filepath.Join(cfg.StateDir, "tailscaled.state"))
+		}
 		args = append(args, "--statedir="+cfg.StateDir)
 	default:
 		args = append(args, "--state=mem:", "--statedir=/tmp")
@@ -681,7 +857,11 @@ func tailscaleUp(ctx context.Context, cfg *settings) error {
 	} else {
 		args = append(args, "--accept-dns=false")
 	}
-	if cfg.AuthKey != "" {
+	if cfg.AuthKeyFile != "" {
+		// Let the CLI read the file so that the key isn't on its
+		// command line either.
+		args = append(args, "--authkey=file:"+cfg.AuthKeyFile)
+	} else if cfg.AuthKey != "" {
 		args = append(args, "--authkey="+cfg.AuthKey)
 	}
 	// --advertise-routes can be passed an empty string to configure a
@@ -707,6 +887,70 @@ func tailscaleUp(ctx context.Context, cfg *settings) error {
 	return nil
 }
 
+// bootTimeout is how long containerboot waits for tailscaled to start and
+// log in, not counting the time spent waiting for a new authkey in
+// TS_AUTHKEY_FILE, before it gives up and crashloops the container.
+const bootTimeout = 60 * time.Second
+
+// authKeyFilePollInterval is how often tailscaleUpWithAuthKeyFile checks
+// whether the auth key file changed after a failed login.
+var authKeyFilePollInterval = 5 * time.Second
+
+// tailscaleUpWithAuthKeyFile is like tailscaleUp, but if 'tailscale up'
+// fails, it waits for the contents of cfg.AuthKeyFile to change and tries
+// again with the new key, until ctx is done. This lets a rotated key be
+// picked up without restarting the container, as mounted Secrets are
+// updated in place.
+func tailscaleUpWithAuthKeyFile(ctx context.Context, cfg *settings) error {
+	for {
+		key, err := readAuthKeyFile(cfg.AuthKeyFile)
+		if err != nil {
+			return err
+		}
+		upErr := tailscaleUp(ctx, cfg)
+		if upErr == nil {
+			return nil
+		}
+		log.Printf("%v; waiting for a new authkey in %s", upErr, cfg.AuthKeyFile)
+		if err := waitForAuthKeyFileChange(ctx, cfg.AuthKeyFile, key); err != nil {
+			return upErr
+		}
+		log.Printf("authkey in %s changed, retrying", cfg.AuthKeyFile)
+	}
+}
+
+// waitForAuthKeyFileChange waits until the file at path contains an authkey
+// other than old, or ctx is done.
+func waitForAuthKeyFileChange(ctx context.Context, path, old string) error {
+	t := time.NewTicker(authKeyFilePollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+		// The file may be briefly missing or empty while it's being
+		// replaced, so errors just mean it hasn't changed yet.
+		if key, err := readAuthKeyFile(path); err == nil && key != old {
+			return nil
+		}
+	}
+}
+
+// readAuthKeyFile returns the authkey in the file at path.
+func readAuthKeyFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	key := strings.TrimSpace(string(b))
+	if key == "" {
+		return "", fmt.Errorf("authkey file %s is empty", path)
+	}
+	return key, nil
+}
+
 // tailscaleSet uses cfg to run 'tailscale set' to set any known configuration
 // options that are passed in via environment variables. This is run after the
 // node is in Running state and only if TS_AUTH_ONCE is set.
@@ -756,6 +1000,37 @@ func ensureTunFile(root string) error {
 	return nil
 }
 
+// unapprovedRoutes returns the routes in the comma-separated list of advertised
+// routes that have not yet been approved for self, i.e. that are missing from
+// its AllowedIPs.
+func unapprovedRoutes(routes string, self tailcfg.NodeView) []netip.Prefix {
+	var ret []netip.Prefix
+	for _, route := range strings.Split(routes, ",") {
+		p, err := netip.ParsePrefix(route)
+		if err != nil {
+			continue
+		}
+		if !views.SliceContains(self.AllowedIPs(), p) {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// approvedRoutes returns the subnet routes (including exit node routes) that
+// have been approved for self, i.e. its AllowedIPs other than its own
+// addresses.
+func approvedRoutes(self tailcfg.NodeView) []netip.Prefix {
+	var ret []netip.Prefix
+	for i := range self.AllowedIPs().Len() {
+		p := self.AllowedIPs().At(i)
+		if !views.SliceContains(self.Addresses(), p) {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
 // ensureIPForwarding enables IPv4/IPv6 forwarding for the container.
 func ensureIPForwarding(root, clusterProxyTarget, tailnetTargetiP, tailnetTargetFQDN string, routes *string) error {
 	var (
@@ -830,7 +1105,10 @@ func ensureIPForwarding(root, clusterProxyTarget, tailnetTargetiP, tailnetTarget
 	return nil
 }
 
-func installEgressForwardingRule(ctx context.Context, dstStr string, tsIPs []netip.Prefix, nfr linuxfw.NetfilterRunner) error {
+// addEgressForwardingRules adds to rules the rules to forward traffic
+// from outside the tailnet to the tailnet IP dstStr, from the tailnet IP
+// in tsIPs of the same family.
+func addEgressForwardingRules(rules *linuxfw.ProxyRules, dstStr string, tsIPs []netip.Prefix) error {
 	dst, err := netip.ParseAddr(dstStr)
 	if err != nil {
 		return err
@@ -849,23 +1127,17 @@ func installEgressForwardingRule(ctx context.Context, dstStr string, tsIPs []net
 	if !local.IsValid() {
 		return fmt.Errorf("no tailscale IP matching family of %s found in %v", dstStr, tsIPs)
 	}
-	if err := nfr.DNATNonTailscaleTraffic("tailscale0", dst); err != nil {
-		return fmt.Errorf("installing egress proxy rules: %w", err)
-	}
-	if err := nfr.AddSNATRuleForDst(local, dst); err != nil {
-		return fmt.Errorf("installing egress proxy rules: %w", err)
-	}
-	if err := nfr.ClampMSSToPMTU("tailscale0", dst); err != nil {
-		return fmt.Errorf("installing egress proxy rules: %w", err)
-	}
+	rules.DNATNonTailscale = append(rules.DNATNonTailscale, linuxfw.DNATNonTailscaleRule{ExemptInterface: "tailscale0", Dst: dst})
+	rules.SNAT = append(rules.SNAT, linuxfw.SNATRule{Src: local, Dst: dst})
+	rules.ClampMSS = append(rules.ClampMSS, linuxfw.ClampMSSRule{Tun: "tailscale0", Addr: dst})
 	return nil
 }
 
-// installTSForwardingRuleForDestination accepts a destination address and a
-// list of node's tailnet addresses, sets up rules to forward traffic for
-// destination to the tailnet IP matching the destination IP family.
-// Destination can be Pod IP of this node.
-func installTSForwardingRuleForDestination(ctx context.Context, dstFilter string, tsIPs []netip.Prefix, nfr linuxfw.NetfilterRunner) error {
+// addTSForwardingRulesForDestination accepts a destination address and a
+// list of node's tailnet addresses, and adds to rules the rules to forward
+// traffic for destination to the tailnet IP matching the destination IP
+// family. Destination can be Pod IP of this node.
+func addTSForwardingRulesForDestination(rules *linuxfw.ProxyRules, dstFilter string, tsIPs []netip.Prefix) error {
 	dst, err := netip.ParseAddr(dstFilter)
 	if err != nil {
 		return err
@@ -884,13 +1156,13 @@ func installTSForwardingRuleForDestination(ctx context.Context, dstFilter string
 	if !local.IsValid() {
 		return fmt.Errorf("no tailscale IP matching family of %s found in %v", dstFilter, tsIPs)
 	}
-	if err := nfr.AddDNATRule(dst, local); err != nil {
-		return fmt.Errorf("installing rule for forwarding traffic to tailnet IP: %w", err)
-	}
+	rules.DNAT = append(rules.DNAT, linuxfw.DNATRule{OrigDst: dst, Dst: local})
 	return nil
 }
 
-func installIngressForwardingRule(ctx context.Context, dstStr string, tsIPs []netip.Prefix, nfr linuxfw.NetfilterRunner) error {
+// addIngressForwardingRules adds to rules the rules to forward traffic
+// from the tailnet IP in tsIPs of the same family as dstStr to dstStr.
+func addIngressForwardingRules(rules *linuxfw.ProxyRules, dstStr string, tsIPs []netip.Prefix) error {
 	dst, err := netip.ParseAddr(dstStr)
 	if err != nil {
 		return err
@@ -909,20 +1181,17 @@ func installIngressForwardingRule(ctx context.Context, dstStr string, tsIPs []ne
 	if !local.IsValid() {
 		return fmt.Errorf("no tailscale IP matching family of %s found in %v", dstStr, tsIPs)
 	}
-	if err := nfr.AddDNATRule(local, dst); err != nil {
-		return fmt.Errorf("installing ingress proxy rules: %w", err)
-	}
-	if err := nfr.ClampMSSToPMTU("tailscale0", dst); err != nil {
-		return fmt.Errorf("installing ingress proxy rules: %w", err)
-	}
+	rules.DNAT = append(rules.DNAT, linuxfw.DNATRule{OrigDst: local, Dst: dst})
+	rules.ClampMSS = append(rules.ClampMSS, linuxfw.ClampMSSRule{Tun: "tailscale0", Addr: dst})
 	return nil
 }
 
 // settings is all the configuration for containerboot.
 type settings struct {
-	AuthKey  string
-	Hostname string
-	Routes   *string
+	AuthKey     string
+	AuthKeyFile string // path to a file containing the authkey, if any
+	Hostname    string
+	Routes      *string
 	// ProxyTo is the destination IP to which all incoming
 	// Tailscale traffic should be proxied. If empty, no proxying
 	// is done. This is typically a locally reachable IP.
@@ -941,12 +1210,15 @@ type settings struct {
 	InKubernetes             bool
 	UserspaceMode            bool
 	StateDir                 string
+	EncryptState             bool // TS_STATE_ENCRYPTION_KEY is set
 	AcceptDNS                *bool
 	KubeSecret               string
 	SOCKSProxyAddr           string
 	HTTPProxyAddr            string
+	ProxyMetricsAddr         string
 	Socket                   string
 	AuthOnce                 bool
+	InitOnly                 bool // only set up the tun device, sysctls and firewall, see TS_INIT_ONLY
 	Root                     string
 	KubernetesCanPatch       bool
 	TailscaledConfigFilePath string
@@ -958,6 +1230,11 @@ type settings struct {
 	// when setting up rules to proxy cluster traffic to cluster ingress
 	// target.
 	PodIP string
+	// PodName and PodUID identify the Pod if running in Kubernetes. If
+	// PodName is set, containerboot posts Kubernetes Events about its
+	// progress on the Pod, if its RBAC permits.
+	PodName string
+	PodUID  string
 }
 
 func (s *settings) validate() error {
@@ -975,11 +1252,25 @@ func (s *settings) validate() error {
 	if s.TailnetTargetFQDN != "" && s.UserspaceMode {
 		return errors.New("TS_TAILNET_TARGET_FQDN is not supported with TS_USERSPACE")
 	}
+	if s.ProxyMetricsAddr != "" && s.UserspaceMode {
+		return errors.New("TS_PROXY_METRICS_LISTEN is not supported with TS_USERSPACE")
+	}
 	if s.TailnetTargetFQDN != "" && s.TailnetTargetIP != "" {
 		return errors.New("Both TS_TAILNET_TARGET_IP and TS_TAILNET_FQDN cannot be set")
 	}
-	if s.TailscaledConfigFilePath != "" && (s.AcceptDNS != nil || s.AuthKey != "" || s.Routes != nil || s.ExtraArgs != "" || s.Hostname != "") {
-		return errors.New("EXPERIMENTAL_TS_CONFIGFILE_PATH cannot be set in combination with TS_HOSTNAME, TS_EXTRA_ARGS, TS_AUTHKEY, TS_ROUTES, TS_ACCEPT_DNS.")
+	if s.TailscaledConfigFilePath != "" && (s.AcceptDNS != nil || s.AuthKey != "" || s.AuthKeyFile != "" || s.Routes != nil || s.ExtraArgs != "" || s.Hostname != "") {
+		return errors.New("EXPERIMENTAL_TS_CONFIGFILE_PATH cannot be set in combination with TS_HOSTNAME, TS_EXTRA_ARGS, TS_AUTHKEY, TS_AUTHKEY_FILE, TS_ROUTES, TS_ACCEPT_DNS.")
+	}
+	if s.AuthKeyFile != "" {
+		if s.AuthKey != "" {
+			return errors.New("Both TS_AUTHKEY and TS_AUTHKEY_FILE cannot be set")
+		}
+		if _, err := readAuthKeyFile(s.AuthKeyFile); err != nil {
+			return fmt.Errorf("error reading TS_AUTHKEY_FILE: %w", err)
+		}
+	}
+	if s.InitOnly && s.UserspaceMode {
+		return errors.New("TS_INIT_ONLY is not supported with TS_USERSPACE")
 	}
 	if s.AllowProxyingClusterTrafficViaIngress && s.UserspaceMode {
 		return errors.New("EXPERIMENTAL_ALLOW_PROXYING_CLUSTER_TRAFFIC_VIA_INGRESS is not supported in userspace mode")
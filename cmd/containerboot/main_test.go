@@ -7,6 +7,7 @@
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
 	"encoding/base64"
 	"encoding/json"
@@ -15,6 +16,7 @@
 	"fmt"
 	"io"
 	"io/fs"
+	"maps"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -31,10 +33,14 @@
 	"github.com/google/go-cmp/cmp"
 	"golang.org/x/sys/unix"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/encstore"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/kube"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstest"
 	"tailscale.com/types/netmap"
 	"tailscale.com/types/ptr"
+	"tailscale.com/util/linuxfw"
 )
 
 func TestContainerBoot(t *testing.T) {
@@ -81,6 +87,7 @@ func TestContainerBoot(t *testing.T) {
 		"proc/sys/net/ipv4/ip_forward":          []byte("0"),
 		"proc/sys/net/ipv6/conf/all/forwarding": []byte("0"),
 		"etc/tailscaled":                        tailscaledConfBytes,
+		"etc/ts-authkey":                        []byte("tskey-file-key\n"),
 	}
 	resetFiles := func() {
 		for path, content := range files {
@@ -116,6 +123,12 @@ type phase struct {
 		// WantFiles files that should exist in the container and their
 		// contents.
 		WantFiles map[string]string
+		// WantKubeEvents is the reasons of the Kubernetes Events that
+		// containerboot should have posted so far, in order.
+		WantKubeEvents []string
+		// WantLog, if non-empty, is a line that containerboot should
+		// have logged by the end of this phase.
+		WantLog string
 	}
 	runningNotify := &ipn.Notify{
 		State: ptr.To(ipn.Running),
@@ -133,6 +146,10 @@ type phase struct {
 		KubeSecret    map[string]string
 		KubeDenyPatch bool
 		Phases        []phase
+		// InitOnlyFiles, if non-nil, are the files and their
+		// contents that containerboot should have set up before
+		// exiting, without running any commands. Phases are ignored.
+		InitOnlyFiles map[string]string
 	}{
 		{
 			// Out of the box default: runs in userspace mode, ephemeral storage, interactive login.
@@ -186,6 +203,38 @@ type phase struct {
 				},
 			},
 		},
+		{
+			// Userspace mode, ephemeral storage, authkey read from a file.
+			Name: "authkey_file",
+			Env: map[string]string{
+				"TS_AUTHKEY_FILE": filepath.Join(d, "etc/ts-authkey"),
+			},
+			Phases: []phase{
+				{
+					WantCmds: []string{
+						"/usr/bin/tailscaled --socket=/tmp/tailscaled.sock --state=mem: --statedir=/tmp --tun=userspace-networking",
+						"/usr/bin/tailscale --socket=/tmp/tailscaled.sock up --accept-dns=false --authkey=file:/etc/ts-authkey",
+					},
+				},
+				{
+					Notify:  runningNotify,
+					WantLog: "Startup complete, waiting for shutdown signal",
+				},
+				{
+					// The node needs to log in again later,
+					// with the key now in the file.
+					Notify: &ipn.Notify{
+						State: ptr.To(ipn.NeedsLogin),
+					},
+					WantCmds: []string{
+						"/usr/bin/tailscale --socket=/tmp/tailscaled.sock up --accept-dns=false --authkey=file:/etc/ts-authkey",
+					},
+				},
+				{
+					Notify: runningNotify,
+				},
+			},
+		},
 		{
 			Name: "authkey_disk_state",
 			Env: map[string]string{
@@ -405,14 +454,99 @@ type phase struct {
 				{
 					Notify: runningNotify,
 					WantKubeSecret: map[string]string{
-						"authkey":     "tskey-key",
-						"device_fqdn": "test-node.test.ts.net",
-						"device_id":   "myID",
-						"device_ips":  `["100.64.0.1"]`,
+						"authkey":       "tskey-key",
+						"device_fqdn":   "test-node.test.ts.net",
+						"device_id":     "myID",
+						"device_ips":    `["100.64.0.1"]`,
+						"device_routes": "[]",
 					},
 				},
 			},
 		},
+		{
+			// Removes the state of profiles that tailscaled forgot
+			// when storing the device info.
+			Name: "kube_storage_stale_profiles",
+			Env: map[string]string{
+				"KUBERNETES_SERVICE_HOST":       kube.Host,
+				"KUBERNETES_SERVICE_PORT_HTTPS": kube.Port,
+			},
+			KubeSecret: map[string]string{
+				"authkey":      "tskey-key",
+				"_profiles":    `{"abcd":{"ID":"abcd","Key":"profile-abcd"}}`,
+				"profile-abcd": "{}",
+				"profile-dead": "",
+			},
+			Phases: []phase{
+				{
+					WantCmds: []string{
+						"/usr/bin/tailscaled --socket=/tmp/tailscaled.sock --state=kube:tailscale --statedir=/tmp --tun=userspace-networking",
+						"/usr/bin/tailscale --socket=/tmp/tailscaled.sock up --accept-dns=false --authkey=tskey-key",
+					},
+					WantKubeSecret: map[string]string{
+						"authkey":      "tskey-key",
+						"_profiles":    `{"abcd":{"ID":"abcd","Key":"profile-abcd"}}`,
+						"profile-abcd": "{}",
+						"profile-dead": "",
+					},
+				},
+				{
+					Notify: runningNotify,
+					WantKubeSecret: map[string]string{
+						"authkey":       "tskey-key",
+						"_profiles":     `{"abcd":{"ID":"abcd","Key":"profile-abcd"}}`,
+						"profile-abcd":  "{}",
+						"device_fqdn":   "test-node.test.ts.net",
+						"device_id":     "myID",
+						"device_ips":    `["100.64.0.1"]`,
+						"device_routes": "[]",
+					},
+				},
+			},
+		},
+		{
+			Name: "kube_events",
+			Env: map[string]string{
+				"KUBERNETES_SERVICE_HOST":       kube.Host,
+				"KUBERNETES_SERVICE_PORT_HTTPS": kube.Port,
+				"POD_NAME":                      "proxy-0",
+				"POD_UID":                       "1234",
+			},
+			KubeSecret: map[string]string{
+				"authkey": "tskey-key",
+			},
+			Phases: []phase{
+				{
+					WantCmds: []string{
+						"/usr/bin/tailscaled --socket=/tmp/tailscaled.sock --state=kube:tailscale --statedir=/tmp --tun=userspace-networking",
+						"/usr/bin/tailscale --socket=/tmp/tailscaled.sock up --accept-dns=false --authkey=tskey-key",
+					},
+					WantKubeSecret: map[string]string{
+						"authkey": "tskey-key",
+					},
+				},
+				{
+					Notify: &ipn.Notify{
+						State: ptr.To(ipn.NeedsMachineAuth),
+					},
+					WantKubeSecret: map[string]string{
+						"authkey": "tskey-key",
+					},
+					WantKubeEvents: []string{"NeedsMachineAuth"},
+				},
+				{
+					Notify: runningNotify,
+					WantKubeSecret: map[string]string{
+						"authkey":       "tskey-key",
+						"device_fqdn":   "test-node.test.ts.net",
+						"device_id":     "myID",
+						"device_ips":    `["100.64.0.1"]`,
+						"device_routes": "[]",
+					},
+					WantKubeEvents: []string{"NeedsMachineAuth", "AuthSucceeded"},
+				},
+			},
+		},
 		{
 			Name: "kube_disk_storage",
 			Env: map[string]string{
@@ -498,9 +632,10 @@ type phase struct {
 						"/usr/bin/tailscale --socket=/tmp/tailscaled.sock set --accept-dns=false",
 					},
 					WantKubeSecret: map[string]string{
-						"device_fqdn": "test-node.test.ts.net",
-						"device_id":   "myID",
-						"device_ips":  `["100.64.0.1"]`,
+						"device_fqdn":   "test-node.test.ts.net",
+						"device_id":     "myID",
+						"device_ips":    `["100.64.0.1"]`,
+						"device_routes": "[]",
 					},
 				},
 			},
@@ -527,10 +662,11 @@ type phase struct {
 				{
 					Notify: runningNotify,
 					WantKubeSecret: map[string]string{
-						"authkey":     "tskey-key",
-						"device_fqdn": "test-node.test.ts.net",
-						"device_id":   "myID",
-						"device_ips":  `["100.64.0.1"]`,
+						"authkey":       "tskey-key",
+						"device_fqdn":   "test-node.test.ts.net",
+						"device_id":     "myID",
+						"device_ips":    `["100.64.0.1"]`,
+						"device_routes": "[]",
 					},
 				},
 				{
@@ -545,10 +681,11 @@ type phase struct {
 						},
 					},
 					WantKubeSecret: map[string]string{
-						"authkey":     "tskey-key",
-						"device_fqdn": "new-name.test.ts.net",
-						"device_id":   "newID",
-						"device_ips":  `["100.64.0.1"]`,
+						"authkey":       "tskey-key",
+						"device_fqdn":   "new-name.test.ts.net",
+						"device_id":     "newID",
+						"device_ips":    `["100.64.0.1"]`,
+						"device_routes": "[]",
 					},
 				},
 			},
@@ -637,6 +774,18 @@ type phase struct {
 				},
 			},
 		},
+		{
+			Name: "init_only",
+			Env: map[string]string{
+				"TS_INIT_ONLY": "true",
+				"TS_USERSPACE": "false",
+				"TS_DEST_IP":   "1.2.3.4",
+			},
+			InitOnlyFiles: map[string]string{
+				"proc/sys/net/ipv4/ip_forward":          "1",
+				"proc/sys/net/ipv6/conf/all/forwarding": "0",
+			},
+		},
 		{
 			Name: "experimental tailscaled configfile",
 			Env: map[string]string{
@@ -694,6 +843,32 @@ type phase struct {
 				cmd.Process.Wait()
 			}()
 
+			if test.InitOnlyFiles != nil {
+				exited := make(chan error, 1)
+				go func() { exited <- cmd.Wait() }()
+				select {
+				case err := <-exited:
+					if err != nil {
+						t.Fatalf("containerboot in init-only mode: %v", err)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("timeout waiting for containerboot to exit")
+				}
+				if _, err := os.Stat(argFile); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("containerboot ran commands in init-only mode")
+				}
+				for path, want := range test.InitOnlyFiles {
+					gotBs, err := os.ReadFile(filepath.Join(d, path))
+					if err != nil {
+						t.Fatalf("reading wanted file %q: %v", path, err)
+					}
+					if got := strings.TrimSpace(string(gotBs)); got != want {
+						t.Errorf("wrong file contents for %q, got %q want %q", path, got, want)
+					}
+				}
+				return
+			}
+
 			var wantCmds []string
 			for i, p := range test.Phases {
 				lapi.Notify(p.Notify)
@@ -711,6 +886,9 @@ type phase struct {
 							return fmt.Errorf("kube secret unexpectedly not empty, got %#v", got)
 						}
 					}
+					if diff := cmp.Diff(kube.Events(), p.WantKubeEvents); diff != "" {
+						return fmt.Errorf("unexpected kube events (-got+want):\n%s", diff)
+					}
 					return nil
 				})
 				if err != nil {
@@ -731,12 +909,36 @@ type phase struct {
 				if err != nil {
 					t.Fatal(err)
 				}
+				if p.WantLog != "" {
+					waitLogLine(t, 2*time.Second, cbOut, p.WantLog)
+				}
 			}
 			waitLogLine(t, 2*time.Second, cbOut, "Startup complete, waiting for shutdown signal")
 		})
 	}
 }
 
+func TestEnsureTunFile(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("test only works when run as root")
+	}
+	// The directory stands in for a volume that an initContainer shares
+	// with the main container at /dev/net.
+	d := t.TempDir()
+	for range 2 {
+		if err := ensureTunFile(d); err != nil {
+			t.Fatalf("ensureTunFile: %v", err)
+		}
+		var st unix.Stat_t
+		if err := unix.Stat(filepath.Join(d, "dev/net/tun"), &st); err != nil {
+			t.Fatal(err)
+		}
+		if st.Mode&unix.S_IFMT != unix.S_IFCHR || unix.Major(st.Rdev) != 10 || unix.Minor(st.Rdev) != 200 {
+			t.Fatalf("dev/net/tun has mode %o, device %d:%d; want tun character device 10:200", st.Mode, unix.Major(st.Rdev), unix.Minor(st.Rdev))
+		}
+	}
+}
+
 type lockingBuffer struct {
 	sync.Mutex
 	b bytes.Buffer
@@ -927,7 +1129,9 @@ type kubeServer struct {
 
 	sync.Mutex
 	secret   map[string]string
+	version  int // resourceVersion of secret
 	canPatch bool
+	events   []string // reasons of posted Events
 }
 
 func (k *kubeServer) Secret() map[string]string {
@@ -944,6 +1148,13 @@ func (k *kubeServer) SetSecret(key, val string) {
 	k.Lock()
 	defer k.Unlock()
 	k.secret[key] = val
+	k.version++
+}
+
+func (k *kubeServer) Events() []string {
+	k.Lock()
+	defer k.Unlock()
+	return append([]string(nil), k.events...)
 }
 
 func (k *kubeServer) SetPatching(canPatch bool) {
@@ -956,6 +1167,7 @@ func (k *kubeServer) Reset() {
 	k.Lock()
 	defer k.Unlock()
 	k.secret = map[string]string{}
+	k.events = nil
 }
 
 func (k *kubeServer) Start() error {
@@ -1000,6 +1212,8 @@ func (k *kubeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		k.serveSecret(w, r)
 	case "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews":
 		k.serveSSAR(w, r)
+	case "/api/v1/namespaces/default/events":
+		k.serveEvents(w, r)
 	default:
 		panic(fmt.Sprintf("unhandled fake kube api path %q", r.URL.Path))
 	}
@@ -1027,6 +1241,30 @@ func (k *kubeServer) serveSSAR(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, `{"status":{"allowed":%v}}`, ok)
 }
 
+func (k *kubeServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		panic(fmt.Sprintf("unhandled HTTP method %q on events", r.Method))
+	}
+	var e struct {
+		Reason         string `json:"reason"`
+		InvolvedObject struct {
+			Name string `json:"name"`
+			UID  string `json:"uid"`
+		} `json:"involvedObject"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		panic(fmt.Sprintf("decoding Event: %v", err))
+	}
+	if e.InvolvedObject.Name != "proxy-0" || e.InvolvedObject.UID != "1234" {
+		panic(fmt.Sprintf("Event posted on unexpected object %+v", e.InvolvedObject))
+	}
+	k.Lock()
+	defer k.Unlock()
+	k.events = append(k.events, e.Reason)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+}
+
 func (k *kubeServer) serveSecret(w http.ResponseWriter, r *http.Request) {
 	bs, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -1037,11 +1275,12 @@ func (k *kubeServer) serveSecret(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 		w.Header().Set("Content-Type", "application/json")
-		ret := map[string]map[string]string{
-			"data": {},
-		}
 		k.Lock()
 		defer k.Unlock()
+		ret := map[string]map[string]string{
+			"metadata": {"resourceVersion": strconv.Itoa(k.version)},
+			"data":     {},
+		}
 		for k, v := range k.secret {
 			v := base64.StdEncoding.EncodeToString([]byte(v))
 			ret["data"][k] = v
@@ -1058,21 +1297,53 @@ func (k *kubeServer) serveSecret(w http.ResponseWriter, r *http.Request) {
 		switch r.Header.Get("Content-Type") {
 		case "application/json-patch+json":
 			req := []struct {
-				Op   string `json:"op"`
-				Path string `json:"path"`
+				Op    string          `json:"op"`
+				Path  string          `json:"path"`
+				Value json.RawMessage `json:"value"`
 			}{}
 			if err := json.Unmarshal(bs, &req); err != nil {
 				panic(fmt.Sprintf("json decode failed: %v. Body:\n\n%s", err, string(bs)))
 			}
+			secret := maps.Clone(k.secret)
 			for _, op := range req {
-				if op.Op != "remove" {
-					panic(fmt.Sprintf("unsupported json-patch op %q", op.Op))
-				}
-				if !strings.HasPrefix(op.Path, "/data/") {
-					panic(fmt.Sprintf("unsupported json-patch path %q", op.Path))
+				switch {
+				case op.Op == "test" && op.Path == "/metadata/resourceVersion":
+					var v string
+					if err := json.Unmarshal(op.Value, &v); err != nil {
+						panic(fmt.Sprintf("json decode failed: %v", err))
+					}
+					if v != strconv.Itoa(k.version) {
+						http.Error(w, "test failed", http.StatusUnprocessableEntity)
+						return
+					}
+				case op.Op == "add" && op.Path == "/data":
+					var data map[string][]byte
+					if err := json.Unmarshal(op.Value, &data); err != nil {
+						panic(fmt.Sprintf("json decode failed: %v", err))
+					}
+					clear(secret)
+					for key, val := range data {
+						secret[key] = string(val)
+					}
+				case op.Op == "add" && strings.HasPrefix(op.Path, "/data/"):
+					var val []byte
+					if err := json.Unmarshal(op.Value, &val); err != nil {
+						panic(fmt.Sprintf("json decode failed: %v", err))
+					}
+					secret[strings.TrimPrefix(op.Path, "/data/")] = string(val)
+				case op.Op == "remove" && strings.HasPrefix(op.Path, "/data/"):
+					key := strings.TrimPrefix(op.Path, "/data/")
+					if _, ok := secret[key]; !ok {
+						http.Error(w, "no such field", http.StatusUnprocessableEntity)
+						return
+					}
+					delete(secret, key)
+				default:
+					panic(fmt.Sprintf("unsupported json-patch op %q on %q", op.Op, op.Path))
 				}
-				delete(k.secret, strings.TrimPrefix(op.Path, "/data/"))
 			}
+			k.secret = secret
+			k.version++
 		case "application/strategic-merge-patch+json":
 			req := struct {
 				Data map[string][]byte `json:"data"`
@@ -1083,6 +1354,7 @@ func (k *kubeServer) serveSecret(w http.ResponseWriter, r *http.Request) {
 			for key, val := range req.Data {
 				k.secret[key] = string(val)
 			}
+			k.version++
 		default:
 			panic(fmt.Sprintf("unknown content type %q", r.Header.Get("Content-Type")))
 		}
@@ -1090,3 +1362,160 @@ func (k *kubeServer) serveSecret(w http.ResponseWriter, r *http.Request) {
 		panic(fmt.Sprintf("unhandled HTTP method %q", r.Method))
 	}
 }
+
+func TestWriteProxyRuleMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	writeProxyRuleMetrics(&buf, []linuxfw.ProxyRuleCounter{
+		{Kind: "dnat", ID: "ts-proxy,dnat,100.64.0.1,10.0.0.1", Packets: 3, Bytes: 180},
+	})
+	want := `# HELP containerboot_proxy_rule_packets Packets that matched a proxy rule. For NAT rules, only the first packet of each connection is counted.
+# TYPE containerboot_proxy_rule_packets counter
+containerboot_proxy_rule_packets{kind="dnat",rule="ts-proxy,dnat,100.64.0.1,10.0.0.1"} 3
+# HELP containerboot_proxy_rule_bytes Bytes of the packets that matched a proxy rule.
+# TYPE containerboot_proxy_rule_bytes counter
+containerboot_proxy_rule_bytes{kind="dnat",rule="ts-proxy,dnat,100.64.0.1,10.0.0.1"} 180
+`
+	if diff := cmp.Diff(buf.String(), want); diff != "" {
+		t.Errorf("metrics mismatch (-got +want):\n%s", diff)
+	}
+}
+
+func TestWaitForAuthKeyFileChange(t *testing.T) {
+	tstest.Replace(t, &authKeyFilePollInterval, time.Millisecond)
+	path := filepath.Join(t.TempDir(), "authkey")
+	if err := os.WriteFile(path, []byte("tskey-old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readAuthKeyFile(path); err != nil || got != "tskey-old" {
+		t.Fatalf("readAuthKeyFile = %q, %v; want tskey-old", got, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := waitForAuthKeyFileChange(ctx, path, "tskey-old"); err == nil {
+		t.Fatal("waitForAuthKeyFileChange returned without a change")
+	}
+
+	// An empty file, as seen while the Secret is being replaced, isn't a
+	// new key.
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- waitForAuthKeyFileChange(context.Background(), path, "tskey-old")
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("waitForAuthKeyFileChange = %v with an empty file; want it to keep waiting", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if err := os.WriteFile(path, []byte("tskey-new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("waitForAuthKeyFileChange = %v; want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for authkey file change")
+	}
+}
+
+func TestStaleSecretFields(t *testing.T) {
+	secret := func(data map[string]string) *kube.Secret {
+		s := &kube.Secret{Data: map[string][]byte{}}
+		for k, v := range data {
+			s.Data[k] = []byte(v)
+		}
+		return s
+	}
+	tests := []struct {
+		name     string
+		data     map[string]string
+		authOnce bool
+		want     []string
+	}{
+		{
+			name: "not_logged_in",
+			data: map[string]string{"authkey": "tskey-key", "profile-dead": ""},
+		},
+		{
+			name: "stale_profiles",
+			data: map[string]string{
+				"_profiles":    `{"abcd":{"ID":"abcd","Key":"profile-abcd"}}`,
+				"profile-abcd": "{}",
+				"profile-dead": "",
+				"profile-beef": "{}",
+				"authkey":      "tskey-key",
+			},
+			want: []string{"profile-beef", "profile-dead"},
+		},
+		{
+			name: "auth_once",
+			data: map[string]string{
+				"_profiles":    `{"abcd":{"ID":"abcd","Key":"profile-abcd"}}`,
+				"profile-abcd": "{}",
+				"authkey":      "tskey-key",
+			},
+			authOnce: true,
+			want:     []string{"authkey"},
+		},
+		{
+			name: "bad_profiles",
+			data: map[string]string{
+				"_profiles":    `{`,
+				"profile-dead": "",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := staleSecretFields(secret(tt.data), tt.authOnce)
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Errorf("staleSecretFields mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+
+	// tailscaled's state may be encrypted with the key from
+	// TS_STATE_ENCRYPTION_KEY, which containerboot shares.
+	t.Setenv("TEST_STATE_PASSPHRASE", "hunter2")
+	inner := new(mem.Store)
+	es, err := encstore.New(t.Logf, inner, "env:TEST_STATE_PASSPHRASE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := es.WriteState(ipn.KnownProfilesStateKey, []byte(`{"abcd":{"ID":"abcd","Key":"profile-abcd"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := es.WriteState("profile-abcd", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := inner.ExportToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &kube.Secret{}
+	if err := json.Unmarshal(data, &s.Data); err != nil {
+		t.Fatal(err)
+	}
+	s.Data["profile-dead"] = nil
+	for _, tt := range []struct {
+		name string
+		ks   string
+		want []string
+	}{
+		{name: "encrypted", ks: "env:TEST_STATE_PASSPHRASE", want: []string{"profile-dead"}},
+		{name: "encrypted_no_key", ks: ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TS_STATE_ENCRYPTION_KEY", tt.ks)
+			got := staleSecretFields(s, false)
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Errorf("staleSecretFields mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
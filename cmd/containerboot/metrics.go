@@ -0,0 +1,62 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"tailscale.com/util/linuxfw"
+)
+
+// runProxyMetrics serves the counters of the proxy rules installed by nfr
+// on addr, at /metrics in Prometheus format, so that operators can confirm
+// that traffic flows through the proxy. It doesn't return unless the
+// server fails to start.
+func runProxyMetrics(addr string, nfr linuxfw.NetfilterRunner) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		counters, err := nfr.ProxyRuleCounters()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeProxyRuleMetrics(w, counters)
+	})
+	log.Printf("Serving proxy rule metrics on %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Fatalf("serving proxy rule metrics: %v", err)
+	}
+}
+
+// writeProxyRuleMetrics writes counters to w in the Prometheus text format.
+func writeProxyRuleMetrics(w io.Writer, counters []linuxfw.ProxyRuleCounter) {
+	for _, m := range []struct {
+		name, help string
+		value      func(linuxfw.ProxyRuleCounter) uint64
+	}{
+		{
+			name:  "containerboot_proxy_rule_packets",
+			help:  "Packets that matched a proxy rule. For NAT rules, only the first packet of each connection is counted.",
+			value: func(c linuxfw.ProxyRuleCounter) uint64 { return c.Packets },
+		},
+		{
+			name:  "containerboot_proxy_rule_bytes",
+			help:  "Bytes of the packets that matched a proxy rule.",
+			value: func(c linuxfw.ProxyRuleCounter) uint64 { return c.Bytes },
+		},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(w, "# TYPE %s counter\n", m.name)
+		for _, c := range counters {
+			fmt.Fprintf(w, "%s{kind=%s,rule=%s} %d\n", m.name, strconv.Quote(c.Kind), strconv.Quote(c.ID), m.value(c))
+		}
+	}
+}
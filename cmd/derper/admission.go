@@ -0,0 +1,271 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"tailscale.com/client/tailscale"
+	"tailscale.com/derp"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/util/lru"
+)
+
+var admissionConfigPath = flag.String("admission-config", "", "if non-empty, path to a JSON file restricting which clients may connect, by node key or tailnet, and how often. It's reloaded when it changes. See the admissionConfig type for its format.")
+
+const (
+	// admissionReloadInterval is how often the admission config file is
+	// checked for changes.
+	admissionReloadInterval = 10 * time.Second
+
+	// maxAdmissionLimiters is the number of node keys whose connection
+	// rate is tracked. The least recently seen keys are forgotten beyond
+	// that, which only makes the limit more lenient for them.
+	maxAdmissionLimiters = 10000
+)
+
+// admissionConfig is the format of the --admission-config file. For example:
+//
+//	{
+//	  "DenyKeys": ["nodekey:..."],
+//	  "AllowTailnets": ["example.com"],
+//	  "ConnectsPerMinute": 6,
+//	  "ConnectBurst": 3
+//	}
+type admissionConfig struct {
+	// AllowKeys and AllowTailnets, if either is non-empty, are the only
+	// node keys and tailnets whose nodes may connect.
+	//
+	// A tailnet is identified by the domain of its users' login names,
+	// like "example.com" for "alice@example.com". It's only known for
+	// nodes visible to the local tailscaled, so rules by tailnet require
+	// --verify-clients.
+	AllowKeys     []key.NodePublic `json:",omitempty"`
+	AllowTailnets []string         `json:",omitempty"`
+
+	// DenyKeys and DenyTailnets are node keys and tailnets whose nodes may
+	// not connect, even if allowed by the above.
+	DenyKeys     []key.NodePublic `json:",omitempty"`
+	DenyTailnets []string         `json:",omitempty"`
+
+	// ConnectsPerMinute, if positive, is the rate at which each node key
+	// may connect, allowing bursts of ConnectBurst connections (1 if
+	// unset).
+	ConnectsPerMinute float64 `json:",omitempty"`
+	ConnectBurst      int     `json:",omitempty"`
+}
+
+func (c *admissionConfig) usesTailnets() bool {
+	return len(c.AllowTailnets) > 0 || len(c.DenyTailnets) > 0
+}
+
+func parseAdmissionConfig(b []byte) (*admissionConfig, error) {
+	var c admissionConfig
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	if c.ConnectsPerMinute < 0 || c.ConnectBurst < 0 {
+		return nil, errors.New("ConnectsPerMinute and ConnectBurst must not be negative")
+	}
+	return &c, nil
+}
+
+// admission decides which clients may connect to the DERP server according
+// to the --admission-config file.
+type admission struct {
+	path string
+	logf func(format string, args ...any)
+
+	// tailnetOf returns the tailnet of the node with key k, or the empty
+	// string if it's not known.
+	tailnetOf func(k key.NodePublic) string
+
+	mu       sync.Mutex
+	cfg      *admissionConfig
+	modTime  time.Time
+	limiters lru.Cache[key.NodePublic, *rate.Limiter]
+}
+
+func newAdmission(path string) *admission {
+	return &admission{
+		path:      path,
+		logf:      log.Printf,
+		tailnetOf: tailnetFromTailscaled,
+		limiters:  lru.Cache[key.NodePublic, *rate.Limiter]{MaxEntries: maxAdmissionLimiters},
+	}
+}
+
+// startAdmission sets up s to admit clients according to
+// --admission-config, if set.
+func startAdmission(ctx context.Context, s *derp.Server) error {
+	if *admissionConfigPath == "" {
+		return nil
+	}
+	a := newAdmission(*admissionConfigPath)
+	if err := a.reload(); err != nil {
+		return err
+	}
+	if a.cfg.usesTailnets() && !*verifyClients {
+		return errors.New("--admission-config with tailnet rules requires --verify-clients")
+	}
+	s.SetAdmitClient(a.admit)
+	go a.reloadLoop(ctx)
+	return nil
+}
+
+// reload reads the config file if it changed since it was last read. If the
+// file is invalid, the previous config is kept.
+func (a *admission) reload() error {
+	fi, err := os.Stat(a.path)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	unchanged := a.cfg != nil && fi.ModTime().Equal(a.modTime)
+	a.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+	b, err := os.ReadFile(a.path)
+	if err != nil {
+		return err
+	}
+	cfg, err := parseAdmissionConfig(b)
+	if err != nil {
+		return fmt.Errorf("%s: %w", a.path, err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	old := a.cfg
+	a.cfg = cfg
+	a.modTime = fi.ModTime()
+	if old != nil && (old.ConnectsPerMinute != cfg.ConnectsPerMinute || old.ConnectBurst != cfg.ConnectBurst) {
+		a.limiters = lru.Cache[key.NodePublic, *rate.Limiter]{MaxEntries: maxAdmissionLimiters}
+	}
+	a.logf("derper: loaded admission config %s", a.path)
+	return nil
+}
+
+func (a *admission) reloadLoop(ctx context.Context) {
+	t := time.NewTicker(admissionReloadInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if err := a.reload(); err != nil {
+			a.logf("derper: reloading admission config: %v; keeping previous config", err)
+		}
+	}
+}
+
+// admit implements the func for derp.Server.SetAdmitClient.
+func (a *admission) admit(k key.NodePublic, _ netip.AddrPort) error {
+	a.mu.Lock()
+	cfg := a.cfg
+	a.mu.Unlock()
+
+	var tailnet string
+	if cfg.usesTailnets() {
+		tailnet = a.tailnetOf(k)
+	}
+	inTailnets := func(tailnets []string) bool {
+		for _, t := range tailnets {
+			if tailnet != "" && strings.EqualFold(t, tailnet) {
+				return true
+			}
+		}
+		return false
+	}
+	keyIn := func(keys []key.NodePublic) bool {
+		for _, k2 := range keys {
+			if k2 == k {
+				return true
+			}
+		}
+		return false
+	}
+
+	if keyIn(cfg.DenyKeys) || inTailnets(cfg.DenyTailnets) {
+		return errors.New("denied by admission config")
+	}
+	if len(cfg.AllowKeys) > 0 || len(cfg.AllowTailnets) > 0 {
+		if !keyIn(cfg.AllowKeys) && !inTailnets(cfg.AllowTailnets) {
+			return errors.New("not allowed by admission config")
+		}
+	}
+	if cfg.ConnectsPerMinute > 0 && !a.allowConnect(cfg, k) {
+		return errors.New("connecting too often")
+	}
+	return nil
+}
+
+// allowConnect reports whether k may connect now within the rate limit of
+// cfg, counting the connection if so.
+func (a *admission) allowConnect(cfg *admissionConfig, k key.NodePublic) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	lim, ok := a.limiters.GetOk(k)
+	if !ok {
+		lim = rate.NewLimiter(rate.Limit(cfg.ConnectsPerMinute/60), max(cfg.ConnectBurst, 1))
+		a.limiters.Set(k, lim)
+	}
+	return lim.Allow()
+}
+
+// tailnetFromTailscaled returns the tailnet of the node with key k according
+// to the local tailscaled, or the empty string if it's not known.
+func tailnetFromTailscaled(k key.NodePublic) string {
+	_, up := peerFromTailscaled(k)
+	if up == nil {
+		return ""
+	}
+	return tailnetOfLogin(up.LoginName)
+}
+
+// tailnetOfLogin returns the tailnet of the user with the given login name,
+// which is the domain of the login name.
+func tailnetOfLogin(loginName string) string {
+	_, domain, _ := strings.Cut(loginName, "@")
+	return domain
+}
+
+// peerFromTailscaled returns the node with key k and its user according to
+// the local tailscaled, or nils if it's not known.
+func peerFromTailscaled(k key.NodePublic) (*ipnstate.PeerStatus, *tailcfg.UserProfile) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	st, err := tailscale.Status(ctx)
+	if err != nil {
+		return nil, nil
+	}
+	ps := st.Peer[k]
+	if ps == nil && st.Self != nil && st.Self.PublicKey == k {
+		ps = st.Self
+	}
+	if ps == nil {
+		return nil, nil
+	}
+	up, ok := st.User[ps.UserID]
+	if !ok {
+		return ps, nil
+	}
+	return ps, &up
+}
@@ -0,0 +1,82 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tailscale.com/types/key"
+)
+
+func TestAdmission(t *testing.T) {
+	alice := key.NewNode().Public() // in example.com
+	bob := key.NewNode().Public()   // in example.com, but denied
+	carol := key.NewNode().Public() // in other.com, but allowed by key
+	dave := key.NewNode().Public()  // in other.com
+	tailnets := map[key.NodePublic]string{
+		alice: "example.com",
+		bob:   "example.com",
+		carol: "other.com",
+		dave:  "other.com",
+	}
+
+	path := filepath.Join(t.TempDir(), "admission.json")
+	write := func(cfg string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(cfg), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(fmt.Sprintf(`{
+		"AllowKeys": [%q],
+		"AllowTailnets": ["EXAMPLE.com"],
+		"DenyKeys": [%q]
+	}`, carol, bob), time.Now().Add(-time.Hour))
+
+	a := newAdmission(path)
+	a.logf = t.Logf
+	a.tailnetOf = func(k key.NodePublic) string { return tailnets[k] }
+	if err := a.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(k key.NodePublic, wantOK bool) {
+		t.Helper()
+		err := a.admit(k, netip.AddrPort{})
+		if gotOK := err == nil; gotOK != wantOK {
+			t.Errorf("admit(%v) = %v; want ok=%v", tailnets[k], err, wantOK)
+		}
+	}
+	check(alice, true)
+	check(bob, false)
+	check(carol, true)
+	check(dave, false)
+	check(key.NewNode().Public(), false)
+
+	// Invalid configs are ignored.
+	write(`{"ConnectsPerMinute": -1}`, time.Now().Add(-time.Minute))
+	if err := a.reload(); err == nil {
+		t.Error("reload of invalid config succeeded")
+	}
+	check(alice, true)
+	check(dave, false)
+
+	write(`{"DenyTailnets": ["example.com"], "ConnectsPerMinute": 1, "ConnectBurst": 2}`, time.Now())
+	if err := a.reload(); err != nil {
+		t.Fatal(err)
+	}
+	check(alice, false)
+	check(dave, true)
+	check(dave, true)
+	check(dave, false) // burst used up
+	check(carol, true)
+}
@@ -47,6 +47,8 @@ func certProviderByCertMode(mode, dir, hostname string) (certProvider, error) {
 		return certManager, nil
 	case "manual":
 		return NewManualCertManager(dir, hostname)
+	case "dns01":
+		return newDNS01CertManager(dir, hostname, *dns01Hook, *acmeDir)
 	default:
 		return nil, fmt.Errorf("unsupport cert mode: %q", mode)
 	}
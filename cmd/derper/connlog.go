@@ -0,0 +1,208 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"flag"
+	"io"
+	"log"
+	"time"
+
+	"tailscale.com/derp"
+	"tailscale.com/logtail"
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/lru"
+)
+
+var (
+	connLogFile       = flag.String("conn-log-file", "", "if non-empty, path to a file to which to append a JSON record of each client connection and disconnection, with its key, source address, duration and traffic")
+	connLogCollection = flag.String("conn-log-collection", "", "if non-empty, the logtail collection to which to upload a record of each client connection and disconnection, like --conn-log-file. The log ID is kept in the -c config file.")
+	connLogResolve    = flag.Bool("conn-log-resolve", false, "whether to include the name, user and tailnet of each client's node in connection records, as known to the local tailscaled; requires --verify-clients")
+)
+
+const (
+	// connLogQueueSize is the number of connection events that can wait to
+	// be logged. Events beyond that are dropped, so that a burst of
+	// connections doesn't hold up serving them.
+	connLogQueueSize = 1024
+
+	// connLogIdentityCacheSize is the number of node identities kept to
+	// resolve their later connections and disconnections without asking
+	// tailscaled again.
+	connLogIdentityCacheSize = 10000
+
+	// connLogIdentityTTL is how long a node identity is cached.
+	connLogIdentityTTL = 10 * time.Minute
+)
+
+var connLogDropped = expvar.NewInt("counter_derper_conn_log_dropped")
+
+// connRecord is the record logged, with type "derp_conn", when a client
+// connects or disconnects.
+type connRecord struct {
+	Event string         // "connect" or "disconnect"
+	Key   key.NodePublic // of the client
+	Addr  string         `json:",omitempty"` // of the client, if known
+	Mesh  bool           `json:",omitempty"` // whether the client is a mesh peer
+
+	// The following are only set for disconnections.
+	DurationSec float64 `json:",omitempty"`
+	PacketsRecv int64   `json:",omitempty"` // from the client
+	BytesRecv   int64   `json:",omitempty"`
+	PacketsSent int64   `json:",omitempty"` // to the client
+	BytesSent   int64   `json:",omitempty"`
+
+	// The following are set with --conn-log-resolve, if the node is
+	// known.
+	Node    string `json:",omitempty"` // DNS name
+	User    string `json:",omitempty"` // login name
+	Tailnet string `json:",omitempty"`
+}
+
+// nodeIdentity is the identity of a node, as logged in connRecord.
+type nodeIdentity struct {
+	node, user, tailnet string
+	expires             time.Time
+}
+
+type connEvent struct {
+	cs        derp.ClientStats
+	connected bool
+	at        time.Time
+}
+
+// connLogger logs client connections and disconnections.
+type connLogger struct {
+	logf logger.Logf
+	q    chan connEvent
+
+	// resolve, if non-nil, returns the identity of the node with key k.
+	resolve    func(k key.NodePublic) nodeIdentity
+	identities lru.Cache[key.NodePublic, nodeIdentity] // used only by run
+}
+
+func newConnLogger(logf logger.Logf, resolve func(key.NodePublic) nodeIdentity) *connLogger {
+	return &connLogger{
+		logf:       logf,
+		q:          make(chan connEvent, connLogQueueSize),
+		resolve:    resolve,
+		identities: lru.Cache[key.NodePublic, nodeIdentity]{MaxEntries: connLogIdentityCacheSize},
+	}
+}
+
+// onClientConn implements the func for derp.Server.SetOnClientConn.
+func (cl *connLogger) onClientConn(cs derp.ClientStats, connected bool) {
+	select {
+	case cl.q <- connEvent{cs, connected, time.Now()}:
+	default:
+		connLogDropped.Add(1)
+	}
+}
+
+// run logs the queued events. It never returns, so that disconnections
+// are logged throughout a graceful shutdown.
+func (cl *connLogger) run() {
+	for e := range cl.q {
+		cl.logf.JSON(0, "derp_conn", cl.record(e))
+	}
+}
+
+func (cl *connLogger) record(e connEvent) *connRecord {
+	r := &connRecord{
+		Event: "connect",
+		Key:   e.cs.Key,
+		Mesh:  e.cs.Mesh,
+	}
+	if e.cs.RemoteAddr.IsValid() {
+		r.Addr = e.cs.RemoteAddr.String()
+	}
+	if !e.connected {
+		r.Event = "disconnect"
+		r.DurationSec = e.at.Sub(e.cs.ConnectedAt).Seconds()
+		r.PacketsRecv = e.cs.PacketsRecv
+		r.BytesRecv = e.cs.BytesRecv
+		r.PacketsSent = e.cs.PacketsSent
+		r.BytesSent = e.cs.BytesSent
+	}
+	if cl.resolve != nil && !e.cs.Mesh {
+		id, ok := cl.identities.GetOk(e.cs.Key)
+		if !ok || e.at.After(id.expires) {
+			id = cl.resolve(e.cs.Key)
+			id.expires = e.at.Add(connLogIdentityTTL)
+			cl.identities.Set(e.cs.Key, id)
+		}
+		r.Node, r.User, r.Tailnet = id.node, id.user, id.tailnet
+	}
+	return r
+}
+
+// identityFromTailscaled returns the identity of the node with key k
+// according to the local tailscaled, or the zero value if it's not known.
+func identityFromTailscaled(k key.NodePublic) nodeIdentity {
+	ps, up := peerFromTailscaled(k)
+	var id nodeIdentity
+	if ps != nil {
+		id.node = ps.DNSName
+	}
+	if up != nil {
+		id.user = up.LoginName
+		id.tailnet = tailnetOfLogin(up.LoginName)
+	}
+	return id
+}
+
+// startConnLog starts logging the client connections of s according to
+// --conn-log-file or --conn-log-collection, if either is set. The log ID for
+// the latter is cfg.ConnLogPrivateID. It returns a func that flushes the logs
+// on shutdown.
+func startConnLog(s *derp.Server, cfg config) (shutdown func(context.Context), err error) {
+	if *connLogFile == "" && *connLogCollection == "" {
+		if *connLogResolve {
+			return nil, errors.New("--conn-log-resolve requires --conn-log-file or --conn-log-collection")
+		}
+		return func(context.Context) {}, nil
+	}
+	if *connLogFile != "" && *connLogCollection != "" {
+		return nil, errors.New("--conn-log-file and --conn-log-collection are mutually exclusive")
+	}
+	if *connLogResolve && !*verifyClients {
+		return nil, errors.New("--conn-log-resolve requires --verify-clients")
+	}
+
+	ltc := logtail.Config{
+		Collection: *connLogCollection,
+		PrivateID:  cfg.ConnLogPrivateID,
+		Stderr:     io.Discard,
+	}
+	if *connLogFile != "" {
+		sink, err := logtail.NewFileSink(*connLogFile)
+		if err != nil {
+			return nil, err
+		}
+		ltc.Sink = sink
+	}
+	lt := logtail.NewLogger(ltc, log.Printf)
+
+	var resolve func(key.NodePublic) nodeIdentity
+	if *connLogResolve {
+		resolve = identityFromTailscaled
+	}
+	cl := newConnLogger(lt.Logf, resolve)
+	s.SetOnClientConn(cl.onClientConn)
+	go cl.run()
+	if *connLogFile != "" {
+		log.Printf("derper: logging client connections to %s", *connLogFile)
+	} else {
+		log.Printf("derper: logging client connections to logtail collection %s", *connLogCollection)
+	}
+	return func(ctx context.Context) {
+		if err := lt.Shutdown(ctx); err != nil {
+			log.Printf("derper: flushing connection logs: %v", err)
+		}
+	}, nil
+}
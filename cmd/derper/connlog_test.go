@@ -0,0 +1,69 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/derp"
+	"tailscale.com/types/key"
+)
+
+func TestConnLogger(t *testing.T) {
+	k := key.NewNode().Public()
+	resolves := 0
+	resolve := func(key.NodePublic) nodeIdentity {
+		resolves++
+		return nodeIdentity{node: "foo.example.ts.net.", user: "alice@example.com", tailnet: "example.com"}
+	}
+	var logged []string
+	cl := newConnLogger(func(format string, args ...any) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}, resolve)
+
+	connectedAt := time.Now()
+	cs := derp.ClientStats{
+		Key:         k,
+		RemoteAddr:  netip.MustParseAddrPort("1.2.3.4:567"),
+		ConnectedAt: connectedAt,
+	}
+	cl.onClientConn(cs, true)
+	cs.PacketsRecv, cs.BytesRecv = 3, 300
+	cl.onClientConn(cs, false)
+	close(cl.q)
+	cl.run()
+
+	if resolves != 1 {
+		t.Errorf("resolved %d times; want 1", resolves)
+	}
+	if len(logged) != 2 {
+		t.Fatalf("logged %d records; want 2", len(logged))
+	}
+	var got []connRecord
+	for _, l := range logged {
+		// Strip the prefix that marks a structured log entry.
+		_, js, ok := strings.Cut(l, "]0")
+		if !ok {
+			t.Fatalf("not a JSON record: %q", l)
+		}
+		var rec struct {
+			Rec connRecord `json:"derp_conn"`
+		}
+		if err := json.Unmarshal([]byte(js), &rec); err != nil {
+			t.Fatalf("%q: %v", js, err)
+		}
+		got = append(got, rec.Rec)
+	}
+	if r := got[0]; r.Event != "connect" || r.Key != k || r.Addr != "1.2.3.4:567" || r.User != "alice@example.com" || r.Tailnet != "example.com" || r.BytesRecv != 0 {
+		t.Errorf("connect record = %+v", r)
+	}
+	if r := got[1]; r.Event != "disconnect" || r.PacketsRecv != 3 || r.BytesRecv != 300 || r.DurationSec <= 0 || r.Node != "foo.example.ts.net." {
+		t.Errorf("disconnect record = %+v", r)
+	}
+}
@@ -22,34 +22,38 @@
 	"os/signal"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"go4.org/mem"
+	"golang.org/x/crypto/acme"
 	"golang.org/x/time/rate"
 	"tailscale.com/atomicfile"
 	"tailscale.com/derp"
 	"tailscale.com/derp/derphttp"
 	"tailscale.com/metrics"
-	"tailscale.com/net/stunserver"
 	"tailscale.com/tsweb"
 	"tailscale.com/types/key"
+	"tailscale.com/types/logid"
 )
 
 var (
 	dev        = flag.Bool("dev", false, "run in localhost development mode (overrides -a)")
-	addr       = flag.String("a", ":443", "server HTTP/HTTPS listen address, in form \":port\", \"ip:port\", or for IPv6 \"[ip]:port\". If the IP is omitted, it defaults to all interfaces. Serves HTTPS if the port is 443 and/or -certmode is manual, otherwise HTTP.")
+	addr       = flag.String("a", ":443", "server HTTP/HTTPS listen address, in form \":port\", \"ip:port\", or for IPv6 \"[ip]:port\". If the IP is omitted, it defaults to all interfaces. Serves HTTPS if the port is 443 and/or -certmode is manual or dns01, otherwise HTTP.")
 	httpPort   = flag.Int("http-port", 80, "The port on which to serve HTTP. Set to -1 to disable. The listener is bound to the same IP (if any) as specified in the -a flag.")
 	stunPort   = flag.Int("stun-port", 3478, "The UDP port on which to serve STUN. The listener is bound to the same IP (if any) as specified in the -a flag.")
 	configPath = flag.String("c", "", "config file path")
-	certMode   = flag.String("certmode", "letsencrypt", "mode for getting a cert. possible options: manual, letsencrypt")
+	certMode   = flag.String("certmode", "letsencrypt", "mode for getting a cert. possible options: manual, letsencrypt, dns01")
 	certDir    = flag.String("certdir", tsweb.DefaultCertDir("derper-certs"), "directory to store LetsEncrypt certs, if addr's port is :443")
 	hostname   = flag.String("hostname", "derp.tailscale.com", "LetsEncrypt host name, if addr's port is :443")
+	dns01Hook  = flag.String("dns01-hook", "", "for --certmode=dns01, the DNS provider that creates the TXT records of ACME DNS-01 challenges: an http(s) URL to POST {\"action\",\"fqdn\",\"value\"} JSON to, or the path of an executable to run as \"<path> present|cleanup <fqdn> <value>\"")
+	acmeDir    = flag.String("acme-directory", acme.LetsEncryptURL, "for --certmode=dns01, the directory URL of the ACME CA")
 	runSTUN    = flag.Bool("stun", true, "whether to run a STUN server. It will bind to the same IP (if any) as the --addr flag value.")
 	runDERP    = flag.Bool("derp", true, "whether to run a DERP server. The only reason to set this false is if you're decommissioning a server but want to keep its bootstrap DNS functionality still running.")
 
-	meshPSKFile    = flag.String("mesh-psk-file", defaultMeshPSKFile(), "if non-empty, path to file containing the mesh pre-shared key file. It should contain some hex string; whitespace is trimmed.")
+	meshPSKFile    = flag.String("mesh-psk-file", defaultMeshPSKFile(), "if non-empty, path to file containing the mesh pre-shared key file. It should contain some hex string; whitespace is trimmed. To rotate the key, list several keys, one per line: all are accepted from peers and the first is used to connect to them. The file is reloaded on SIGHUP.")
 	meshWith       = flag.String("mesh-with", "", "optional comma-separated list of hostnames to mesh with; the server's own hostname can be in the list")
 	bootstrapDNS   = flag.String("bootstrap-dns-names", "", "optional comma-separated list of hostnames to make available at /bootstrap-dns")
 	unpublishedDNS = flag.String("unpublished-bootstrap-dns-names", "", "optional comma-separated list of hostnames to make available at /bootstrap-dns and not publish in the list")
@@ -57,6 +61,14 @@
 
 	acceptConnLimit = flag.Float64("accept-connection-limit", math.Inf(+1), "rate limit for accepting new connection")
 	acceptConnBurst = flag.Int("accept-connection-burst", math.MaxInt, "burst limit for accepting new connection")
+
+	clientRateLimit = flag.Int("client-rate-limit", 0, "if positive, the bytes per second that each client may send through the server; packets over the limit are dropped")
+	clientRateBurst = flag.Int("client-rate-burst", 0, "burst limit in bytes for --client-rate-limit; at least one packet of the maximum size is always allowed")
+	totalRateLimit  = flag.Int("total-rate-limit", 0, "if positive, the bytes per second that all clients together may send through the server, not counting mesh traffic; packets over the limit are dropped")
+	totalRateBurst  = flag.Int("total-rate-burst", 0, "burst limit in bytes for --total-rate-limit; at least one packet of the maximum size is always allowed")
+
+	steerCapacity   = flag.Int("steer-capacity", 0, "if positive, the number of connected clients at which the server is fully loaded; clients are told the load when they connect, so that they can move to --steer-alternate-regions")
+	steerAlternates = flag.String("steer-alternate-regions", "", "optional comma-separated list of DERP region IDs that clients may move their home to when the server is heavily loaded")
 )
 
 var (
@@ -71,11 +83,15 @@ func init() {
 
 type config struct {
 	PrivateKey key.NodePrivate
+
+	// ConnLogPrivateID is the log ID for --conn-log-collection. It's
+	// added to existing configs when first needed.
+	ConnLogPrivateID logid.PrivateID
 }
 
 func loadConfig() config {
 	if *dev {
-		return config{PrivateKey: key.NewNode()}
+		return config{PrivateKey: key.NewNode(), ConnLogPrivateID: mustNewPrivateID()}
 	}
 	if *configPath == "" {
 		if os.Getuid() == 0 {
@@ -97,6 +113,10 @@ func loadConfig() config {
 		if err := json.Unmarshal(b, &cfg); err != nil {
 			log.Fatalf("derper: config: %v", err)
 		}
+		if cfg.ConnLogPrivateID.IsZero() && *connLogCollection != "" {
+			cfg.ConnLogPrivateID = mustNewPrivateID()
+			writeConfig(cfg)
+		}
 		return cfg
 	}
 }
@@ -107,8 +127,14 @@ func writeNewConfig() config {
 		log.Fatal(err)
 	}
 	cfg := config{
-		PrivateKey: k,
+		PrivateKey:       k,
+		ConnLogPrivateID: mustNewPrivateID(),
 	}
+	writeConfig(cfg)
+	return cfg
+}
+
+func writeConfig(cfg config) {
 	b, err := json.MarshalIndent(cfg, "", "\t")
 	if err != nil {
 		log.Fatal(err)
@@ -116,7 +142,14 @@ func writeNewConfig() config {
 	if err := atomicfile.WriteFile(*configPath, b, 0600); err != nil {
 		log.Fatal(err)
 	}
-	return cfg
+}
+
+func mustNewPrivateID() logid.PrivateID {
+	id, err := logid.NewPrivateID()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return id
 }
 
 func main() {
@@ -136,39 +169,57 @@ func main() {
 		log.Fatalf("invalid server address: %v", err)
 	}
 
+	var stunListeners []stunListener
 	if *runSTUN {
-		ss := stunserver.New(ctx)
-		go ss.ListenAndServe(net.JoinHostPort(listenHost, fmt.Sprint(*stunPort)))
+		stunListeners, err = startSTUN(ctx, listenHost)
+		if err != nil {
+			log.Fatalf("startSTUN: %v", err)
+		}
 	}
 
 	cfg := loadConfig()
 
-	serveTLS := tsweb.IsProd443(*addr) || *certMode == "manual"
+	serveTLS := tsweb.IsProd443(*addr) || *certMode == "manual" || *certMode == "dns01"
 
 	s := derp.NewServer(cfg.PrivateKey, log.Printf)
 	s.SetVerifyClient(*verifyClients)
+	s.SetClientRateLimit(*clientRateLimit, *clientRateBurst)
+	s.SetTotalRateLimit(*totalRateLimit, *totalRateBurst)
+	alternates, err := parseRegionIDs(*steerAlternates)
+	if err != nil {
+		log.Fatalf("--steer-alternate-regions: %v", err)
+	}
+	s.SetSteering(*steerCapacity, alternates)
 
 	if *meshPSKFile != "" {
-		b, err := os.ReadFile(*meshPSKFile)
+		keys, err := readMeshKeys(*meshPSKFile)
 		if err != nil {
 			log.Fatal(err)
 		}
-		key := strings.TrimSpace(string(b))
-		if matched, _ := regexp.MatchString(`(?i)^[0-9a-f]{64,}$`, key); !matched {
-			log.Fatalf("key in %s must contain 64+ hex digits", *meshPSKFile)
-		}
-		s.SetMeshKey(key)
+		s.SetMeshKeys(keys)
 		log.Printf("DERP mesh key configured")
+		go reloadMeshKeysOnSIGHUP(ctx, s)
 	}
 	if err := startMesh(s); err != nil {
 		log.Fatalf("startMesh: %v", err)
 	}
 	expvar.Publish("derp", s.ExpVar())
+	if err := startAdmission(ctx, s); err != nil {
+		log.Fatalf("startAdmission: %v", err)
+	}
+	if err := startMetrics(s); err != nil {
+		log.Fatalf("startMetrics: %v", err)
+	}
+	shutdownConnLog, err := startConnLog(s, cfg)
+	if err != nil {
+		log.Fatalf("startConnLog: %v", err)
+	}
 
 	mux := http.NewServeMux()
 	if *runDERP {
 		derpHandler := derphttp.Handler(s)
 		derpHandler = addWebSocketSupport(s, derpHandler)
+		derpHandler = countHTTP2(derpHandler)
 		mux.Handle("/derp", derpHandler)
 	} else {
 		mux.Handle("/derp", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -176,6 +227,7 @@ func main() {
 		}))
 	}
 	mux.HandleFunc("/derp/probe", probeHandler)
+	mux.HandleFunc("/health/stun", serveSTUNHealth(stunListeners))
 	go refreshBootstrapDNSLoop()
 	mux.HandleFunc("/bootstrap-dns", tsweb.BrowserHeaderHandlerFunc(handleBootstrapDNS))
 	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -215,6 +267,21 @@ func main() {
 		}
 	}))
 	debug.Handle("traffic", "Traffic check", http.HandlerFunc(s.ServeDebugTraffic))
+	debug.Handle("mesh-key-reload", "Reload mesh keys (POST)", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		if *meshPSKFile == "" {
+			http.Error(w, "no --mesh-psk-file", http.StatusBadRequest)
+			return
+		}
+		if err := reloadMeshKeys(s); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		io.WriteString(w, "mesh keys reloaded")
+	}))
 
 	quietLogger := log.New(logFilter{}, "", 0)
 	httpsrv := &http.Server{
@@ -232,11 +299,19 @@ func main() {
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
+	shutdownDone := make(chan struct{})
 	go func() {
+		defer close(shutdownDone)
 		<-ctx.Done()
+		if *drainTimeout > 0 {
+			drain(s, httpsrv, *drainTimeout)
+			return
+		}
 		httpsrv.Shutdown(ctx)
 	}()
 
+	configureHTTP2(httpsrv, serveTLS)
+
 	if serveTLS {
 		log.Printf("derper: serving on %s with TLS", *addr)
 		var certManager certProvider
@@ -308,6 +383,11 @@ func main() {
 	if err != nil && err != http.ErrServerClosed {
 		log.Fatalf("derper: %v", err)
 	}
+	<-shutdownDone
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	shutdownConnLog(flushCtx)
 }
 
 const (
@@ -368,6 +448,22 @@ func defaultMeshPSKFile() string {
 	return ""
 }
 
+// parseRegionIDs parses a comma-separated list of DERP region IDs.
+func parseRegionIDs(s string) ([]int, error) {
+	var ids []int
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		id, err := strconv.Atoi(f)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid region ID %q", f)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
 func rateLimitedListenAndServeTLS(srv *http.Server) error {
 	ln, err := net.Listen("tcp", cmp.Or(srv.Addr, ":https"))
 	if err != nil {
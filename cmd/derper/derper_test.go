@@ -4,13 +4,22 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
+	"tailscale.com/derp"
 	"tailscale.com/tstest/deptest"
+	"tailscale.com/types/key"
 )
 
 func TestProdAutocertHostPolicy(t *testing.T) {
@@ -96,6 +105,146 @@ func TestNoContent(t *testing.T) {
 	}
 }
 
+func TestMetricsAuth(t *testing.T) {
+	s := derp.NewServer(key.NewNode(), t.Logf)
+	defer s.Close()
+	h := &metricsHandler{s: s, token: "sekrit"}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		auth       string
+		wantCode   int
+	}{
+		{"no token", "1.2.3.4:1234", "", http.StatusUnauthorized},
+		{"wrong token", "1.2.3.4:1234", "Bearer nope", http.StatusUnauthorized},
+		{"not bearer", "1.2.3.4:1234", "Basic sekrit", http.StatusUnauthorized},
+		{"loopback needs token too", "127.0.0.1:1234", "", http.StatusUnauthorized},
+		{"token", "1.2.3.4:1234", "Bearer sekrit", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://derp/metrics", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d; want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestWriteClientMetrics(t *testing.T) {
+	k := key.NewNode().Public()
+	var buf bytes.Buffer
+	writeClientMetrics(&buf, []derp.ClientStats{{
+		Key:         k,
+		RemoteAddr:  netip.MustParseAddrPort("1.2.3.4:5678"),
+		ConnectedAt: time.Now(),
+		PacketsRecv: 1,
+		BytesRecv:   2,
+		PacketsSent: 3,
+		BytesSent:   4,
+	}})
+	got := buf.String()
+	labels := fmt.Sprintf(`{key=%q,addr="1.2.3.4:5678",mesh="false"}`, k.String())
+	for _, want := range []string{
+		"# TYPE derper_client_packets_received counter\n",
+		"derper_client_packets_received" + labels + " 1\n",
+		"derper_client_bytes_received" + labels + " 2\n",
+		"derper_client_packets_sent" + labels + " 3\n",
+		"derper_client_bytes_sent" + labels + " 4\n",
+		"derper_client_connected_seconds" + labels + " 0\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+}
+
+func TestReadMeshKeys(t *testing.T) {
+	k1, k2 := strings.Repeat("ab", 32), strings.Repeat("CD", 40)
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		wantErr bool
+	}{
+		{"one", k1 + "\n", []string{k1}, false},
+		{"two", " " + k1 + "\n" + k2 + "\n\n", []string{k1, k2}, false},
+		{"empty", "\n", nil, true},
+		{"short", "abcd\n", nil, true},
+		{"one bad", k1 + "\nxyz\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "mesh.key")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readMeshKeys(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v; want error: %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSTUNHealth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	oldPort, oldV4 := *stunPort, *stunBindV4
+	defer func() { *stunPort, *stunBindV4 = oldPort, oldV4 }()
+	*stunPort, *stunBindV4 = 0, "127.0.0.1"
+
+	ls, err := startSTUN(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ls) != 1 || ls[0].family != "ipv4" {
+		t.Fatalf("got %d listeners; want one for ipv4", len(ls))
+	}
+	h := serveSTUNHealth(ls)
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/health/stun", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d; want 200; body: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Body.String(); !strings.HasPrefix(got, "ipv4 127.0.0.1:") || !strings.Contains(got, ": ok; served 0, last never") {
+		t.Errorf("unexpected body: %q", got)
+	}
+
+	cancel() // closes the listener
+	rec = httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/health/stun", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status after shutdown = %d; want 503; body: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestParseRegionIDs(t *testing.T) {
+	if got, err := parseRegionIDs(""); err != nil || got != nil {
+		t.Errorf("parseRegionIDs(\"\") = %v, %v; want nil", got, err)
+	}
+	if got, err := parseRegionIDs("2, 3,"); err != nil || !slices.Equal(got, []int{2, 3}) {
+		t.Errorf("parseRegionIDs = %v, %v; want [2 3]", got, err)
+	}
+	for _, bad := range []string{"x", "1,0", "-1"} {
+		if _, err := parseRegionIDs(bad); err == nil {
+			t.Errorf("parseRegionIDs(%q) succeeded; want error", bad)
+		}
+	}
+}
+
 func TestDeps(t *testing.T) {
 	deptest.DepChecker{
 		BadDeps: map[string]string{
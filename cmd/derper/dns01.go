@@ -0,0 +1,428 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"tailscale.com/atomicfile"
+)
+
+// dns01Provider creates and removes the DNS TXT records of ACME DNS-01
+// challenges.
+type dns01Provider interface {
+	// Present creates a TXT record for fqdn with the given value.
+	Present(ctx context.Context, fqdn, value string) error
+	// CleanUp removes the TXT record for fqdn with the given value.
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// dns01Providers are the DNS providers that --dns01-hook can select, by the
+// scheme of its value. Providers for particular DNS services can register
+// themselves here from files of their own. A value without a known scheme
+// is the path of an executable, as with "exec:".
+var dns01Providers = map[string]func(arg string) (dns01Provider, error){
+	"exec":  newExecDNSProvider,
+	"http":  newWebhookDNSProvider,
+	"https": newWebhookDNSProvider,
+}
+
+// newDNS01Provider returns the DNS provider selected by the --dns01-hook
+// value hook.
+func newDNS01Provider(hook string) (dns01Provider, error) {
+	if hook == "" {
+		return nil, errors.New("--certmode=dns01 requires --dns01-hook")
+	}
+	scheme, rest, ok := strings.Cut(hook, ":")
+	newProvider, known := dns01Providers[scheme]
+	if !ok || !known {
+		return newExecDNSProvider(hook)
+	}
+	if scheme == "exec" {
+		return newProvider(rest)
+	}
+	return newProvider(hook)
+}
+
+// dns01HookTimeout is how long a DNS provider hook may take.
+const dns01HookTimeout = 2 * time.Minute
+
+// execDNSProvider is a dns01Provider that runs an executable as
+// "<path> present|cleanup <fqdn> <value>".
+type execDNSProvider struct {
+	path string
+}
+
+func newExecDNSProvider(path string) (dns01Provider, error) {
+	if path == "" {
+		return nil, errors.New("empty DNS hook path")
+	}
+	return &execDNSProvider{path: path}, nil
+}
+
+func (p *execDNSProvider) run(ctx context.Context, action, fqdn, value string) error {
+	ctx, cancel := context.WithTimeout(ctx, dns01HookTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, p.path, action, fqdn, value).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("DNS hook %s %s: %w; output: %s", p.path, action, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (p *execDNSProvider) Present(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "present", fqdn, value)
+}
+
+func (p *execDNSProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "cleanup", fqdn, value)
+}
+
+// webhookDNSProvider is a dns01Provider that POSTs a JSON webhookRequest to
+// a URL, which must respond with a 2xx status code.
+type webhookDNSProvider struct {
+	url string
+}
+
+// webhookRequest is the body of the requests of webhookDNSProvider.
+type webhookRequest struct {
+	Action string `json:"action"` // "present" or "cleanup"
+	FQDN   string `json:"fqdn"`   // like "_acme-challenge.derp.example.com"
+	Value  string `json:"value"`  // of the TXT record
+}
+
+func newWebhookDNSProvider(url string) (dns01Provider, error) {
+	return &webhookDNSProvider{url: url}, nil
+}
+
+func (p *webhookDNSProvider) call(ctx context.Context, action, fqdn, value string) error {
+	ctx, cancel := context.WithTimeout(ctx, dns01HookTimeout)
+	defer cancel()
+	body, err := json.Marshal(webhookRequest{action, fqdn, value})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("DNS webhook %s: %w", action, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return fmt.Errorf("DNS webhook %s: %s: %s", action, res.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func (p *webhookDNSProvider) Present(ctx context.Context, fqdn, value string) error {
+	return p.call(ctx, "present", fqdn, value)
+}
+
+func (p *webhookDNSProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.call(ctx, "cleanup", fqdn, value)
+}
+
+const (
+	// dns01RenewBefore is how long before its expiry a certificate is
+	// renewed.
+	dns01RenewBefore = 30 * 24 * time.Hour
+
+	// dns01CheckInterval is how often the certificate is checked for
+	// renewal, and how long to wait after a failure to get one.
+	dns01CheckInterval = 12 * time.Hour
+	dns01RetryInterval = 10 * time.Minute
+
+	// dns01PropagationTimeout is how long to wait for a challenge's TXT
+	// record to be visible before asking the CA to check it anyway.
+	dns01PropagationTimeout = 2 * time.Minute
+)
+
+// dns01CertManager is a certProvider that gets certificates from an ACME CA
+// with DNS-01 challenges, for servers that the CA can't reach on port 80 or
+// 443 for the challenges of autocert, such as ones behind load balancers.
+//
+// The certificate and key are kept in the cert dir under the same names as
+// for --certmode=manual.
+type dns01CertManager struct {
+	dir       string
+	hostname  string
+	dns       dns01Provider
+	directory string // ACME directory URL
+
+	mu   sync.Mutex
+	cert *tls.Certificate // or nil until there's one
+}
+
+func newDNS01CertManager(dir, hostname, hook, directory string) (*dns01CertManager, error) {
+	dns, err := newDNS01Provider(hook)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	m := &dns01CertManager{
+		dir:       dir,
+		hostname:  hostname,
+		dns:       dns,
+		directory: directory,
+	}
+	if cert, err := tls.LoadX509KeyPair(m.certPath(), m.keyPath()); err == nil {
+		m.cert = &cert
+	}
+	go m.renewLoop(context.Background())
+	return m, nil
+}
+
+func (m *dns01CertManager) certPath() string {
+	return filepath.Join(m.dir, unsafeHostnameCharacters.ReplaceAllString(m.hostname, "")+".crt")
+}
+
+func (m *dns01CertManager) keyPath() string {
+	return filepath.Join(m.dir, unsafeHostnameCharacters.ReplaceAllString(m.hostname, "")+".key")
+}
+
+func (m *dns01CertManager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		NextProtos: []string{
+			"http/1.1",
+		},
+		GetCertificate: m.getCertificate,
+	}
+}
+
+func (m *dns01CertManager) getCertificate(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cert == nil {
+		return nil, errors.New("no certificate yet; waiting for the ACME DNS-01 challenge")
+	}
+	// Return a shallow copy of the cert so the caller can append to its
+	// Certificate field.
+	certCopy := new(tls.Certificate)
+	*certCopy = *m.cert
+	certCopy.Certificate = certCopy.Certificate[:len(certCopy.Certificate):len(certCopy.Certificate)]
+	return certCopy, nil
+}
+
+func (m *dns01CertManager) HTTPHandler(fallback http.Handler) http.Handler {
+	return fallback
+}
+
+// needsRenewal reports whether the certificate should be obtained or
+// renewed at now.
+func (m *dns01CertManager) needsRenewal(now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cert == nil {
+		return true
+	}
+	leaf, err := x509.ParseCertificate(m.cert.Certificate[0])
+	if err != nil {
+		return true
+	}
+	return now.Add(dns01RenewBefore).After(leaf.NotAfter)
+}
+
+// renewLoop obtains the certificate if there's none, and renews it ahead of
+// its expiry, until ctx is done.
+func (m *dns01CertManager) renewLoop(ctx context.Context) {
+	for {
+		wait := dns01CheckInterval
+		if m.needsRenewal(time.Now()) {
+			log.Printf("derper: getting certificate for %q with DNS-01 challenge", m.hostname)
+			if err := m.obtain(ctx); err != nil {
+				log.Printf("derper: getting certificate for %q: %v", m.hostname, err)
+				wait = dns01RetryInterval
+			} else {
+				log.Printf("derper: got certificate for %q", m.hostname)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// accountKey returns the ACME account key, creating it if needed.
+func (m *dns01CertManager) accountKey() (crypto.Signer, error) {
+	name := filepath.Join(m.dir, "acme_account.key")
+	if b, err := os.ReadFile(name); err == nil {
+		block, _ := pem.Decode(b)
+		if block == nil {
+			return nil, fmt.Errorf("invalid ACME account key in %s", name)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(k)
+	if err != nil {
+		return nil, err
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := atomicfile.WriteFile(name, pemKey, 0600); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// obtain gets a new certificate from the ACME CA and starts using it.
+func (m *dns01CertManager) obtain(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	key, err := m.accountKey()
+	if err != nil {
+		return fmt.Errorf("ACME account key: %w", err)
+	}
+	ac := &acme.Client{Key: key, DirectoryURL: m.directory}
+	if _, err := ac.Register(ctx, new(acme.Account), acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+		return fmt.Errorf("acme.Register: %w", err)
+	}
+
+	order, err := ac.AuthorizeOrder(ctx, acme.DomainIDs(m.hostname))
+	if err != nil {
+		return fmt.Errorf("acme.AuthorizeOrder: %w", err)
+	}
+	for _, u := range order.AuthzURLs {
+		if err := m.authorize(ctx, ac, u); err != nil {
+			return err
+		}
+	}
+	order, err = ac.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return fmt.Errorf("acme.WaitOrder: %w", err)
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		DNSNames: []string{m.hostname},
+	}, certKey)
+	if err != nil {
+		return err
+	}
+	der, _, err := ac.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("acme.CreateOrderCert: %w", err)
+	}
+
+	var certPEM bytes.Buffer
+	for _, b := range der {
+		if err := pem.Encode(&certPEM, &pem.Block{Type: "CERTIFICATE", Bytes: b}); err != nil {
+			return err
+		}
+	}
+	keyDER, err := x509.MarshalECPrivateKey(certKey)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert, err := tls.X509KeyPair(certPEM.Bytes(), keyPEM)
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(m.keyPath(), keyPEM, 0600); err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(m.certPath(), certPEM.Bytes(), 0644); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.cert = &cert
+	m.mu.Unlock()
+	return nil
+}
+
+// authorize completes the DNS-01 challenge of the authorization at authzURL,
+// unless it's valid already.
+func (m *dns01CertManager) authorize(ctx context.Context, ac *acme.Client, authzURL string) error {
+	az, err := ac.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return fmt.Errorf("acme.GetAuthorization: %w", err)
+	}
+	if az.Status == acme.StatusValid {
+		return nil
+	}
+	i := slices.IndexFunc(az.Challenges, func(ch *acme.Challenge) bool { return ch.Type == "dns-01" })
+	if i < 0 {
+		return fmt.Errorf("no dns-01 challenge offered for %q", az.Identifier.Value)
+	}
+	ch := az.Challenges[i]
+	value, err := ac.DNS01ChallengeRecord(ch.Token)
+	if err != nil {
+		return err
+	}
+	fqdn := "_acme-challenge." + az.Identifier.Value
+	if err := m.dns.Present(ctx, fqdn, value); err != nil {
+		return err
+	}
+	defer func() {
+		if err := m.dns.CleanUp(context.WithoutCancel(ctx), fqdn, value); err != nil {
+			log.Printf("derper: cleaning up DNS-01 challenge: %v", err)
+		}
+	}()
+	if !waitTXT(ctx, fqdn, value, dns01PropagationTimeout) {
+		log.Printf("derper: TXT record for %q not visible yet; trying the challenge anyway", fqdn)
+	}
+	if _, err := ac.Accept(ctx, ch); err != nil {
+		return fmt.Errorf("acme.Accept: %w", err)
+	}
+	if _, err := ac.WaitAuthorization(ctx, az.URI); err != nil {
+		return fmt.Errorf("acme.WaitAuthorization: %w", err)
+	}
+	return nil
+}
+
+// waitTXT waits up to timeout for the TXT record of fqdn to have value,
+// reporting whether it did.
+func waitTXT(ctx context.Context, fqdn, value string, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		if txts, _ := net.DefaultResolver.LookupTXT(ctx, fqdn); slices.Contains(txts, value) {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
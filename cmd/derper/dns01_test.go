@@ -0,0 +1,172 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewDNS01Provider(t *testing.T) {
+	tests := []struct {
+		hook string
+		want any
+	}{
+		{"/usr/local/bin/dns-hook", &execDNSProvider{path: "/usr/local/bin/dns-hook"}},
+		{"exec:/usr/local/bin/dns-hook", &execDNSProvider{path: "/usr/local/bin/dns-hook"}},
+		{`C:\dns-hook.exe`, &execDNSProvider{path: `C:\dns-hook.exe`}},
+		{"https://dns.example.com/acme", &webhookDNSProvider{url: "https://dns.example.com/acme"}},
+	}
+	for _, tt := range tests {
+		got, err := newDNS01Provider(tt.hook)
+		if err != nil {
+			t.Errorf("newDNS01Provider(%q): %v", tt.hook, err)
+			continue
+		}
+		switch want := tt.want.(type) {
+		case *execDNSProvider:
+			if p, ok := got.(*execDNSProvider); !ok || *p != *want {
+				t.Errorf("newDNS01Provider(%q) = %#v; want %#v", tt.hook, got, want)
+			}
+		case *webhookDNSProvider:
+			if p, ok := got.(*webhookDNSProvider); !ok || *p != *want {
+				t.Errorf("newDNS01Provider(%q) = %#v; want %#v", tt.hook, got, want)
+			}
+		}
+	}
+	for _, bad := range []string{"", "exec:"} {
+		if _, err := newDNS01Provider(bad); err == nil {
+			t.Errorf("newDNS01Provider(%q) succeeded; want error", bad)
+		}
+	}
+}
+
+func TestExecDNSProvider(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script")
+	}
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	hook := filepath.Join(dir, "hook.sh")
+	if err := os.WriteFile(hook, []byte("#!/bin/sh\necho \"$@\" >> "+out+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	p, err := newDNS01Provider(hook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := p.Present(ctx, "_acme-challenge.derp.example.com", "token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CleanUp(ctx, "_acme-challenge.derp.example.com", "token"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "present _acme-challenge.derp.example.com token\ncleanup _acme-challenge.derp.example.com token\n"
+	if string(got) != want {
+		t.Errorf("hook ran with %q; want %q", got, want)
+	}
+
+	failing := filepath.Join(dir, "fail.sh")
+	if err := os.WriteFile(failing, []byte("#!/bin/sh\necho no such zone\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	p, _ = newDNS01Provider(failing)
+	if err := p.Present(ctx, "_acme-challenge.derp.example.com", "token"); err == nil || !strings.Contains(err.Error(), "no such zone") {
+		t.Errorf("failing hook: got error %v", err)
+	}
+}
+
+func TestWebhookDNSProvider(t *testing.T) {
+	var got []webhookRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req webhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Action == "cleanup" && req.Value == "stale" {
+			http.Error(w, "no such record", http.StatusNotFound)
+			return
+		}
+		got = append(got, req)
+	}))
+	defer ts.Close()
+
+	p, err := newDNS01Provider(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := p.Present(ctx, "_acme-challenge.derp.example.com", "token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CleanUp(ctx, "_acme-challenge.derp.example.com", "token"); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Action != "present" || got[1].Action != "cleanup" || got[0].FQDN != "_acme-challenge.derp.example.com" || got[0].Value != "token" {
+		t.Errorf("webhook got %+v", got)
+	}
+	if err := p.CleanUp(ctx, "_acme-challenge.derp.example.com", "stale"); err == nil || !strings.Contains(err.Error(), "no such record") {
+		t.Errorf("failing webhook: got error %v", err)
+	}
+}
+
+func TestDNS01NeedsRenewal(t *testing.T) {
+	now := time.Now()
+	certExpiring := func(notAfter time.Time) *tls.Certificate {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			DNSNames:     []string{"derp.example.com"},
+			NotBefore:    now.Add(-time.Hour),
+			NotAfter:     notAfter,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.PublicKey, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: k}
+	}
+
+	m := &dns01CertManager{hostname: "derp.example.com"}
+	if !m.needsRenewal(now) {
+		t.Error("needsRenewal without cert = false; want true")
+	}
+	if _, err := m.getCertificate(&tls.ClientHelloInfo{}); err == nil {
+		t.Error("getCertificate without cert succeeded; want error")
+	}
+	m.cert = certExpiring(now.Add(60 * 24 * time.Hour))
+	if m.needsRenewal(now) {
+		t.Error("needsRenewal with fresh cert = true; want false")
+	}
+	if c, err := m.getCertificate(&tls.ClientHelloInfo{}); err != nil || c == m.cert {
+		t.Errorf("getCertificate = %p, %v; want a copy of the cert", c, err)
+	}
+	m.cert = certExpiring(now.Add(10 * 24 * time.Hour))
+	if !m.needsRenewal(now) {
+		t.Error("needsRenewal with expiring cert = false; want true")
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"tailscale.com/derp"
+)
+
+var drainTimeout = flag.Duration("drain-timeout", 0, "if positive, on SIGINT or SIGTERM stop accepting new connections, tell clients that the server is restarting and fully loaded (moving them to --steer-alternate-regions, if set), and wait up to this long for them to go idle before exiting, rather than exiting right away")
+
+const (
+	// drainIdle is how long a client has to go without sending or
+	// receiving packets for its connection to be closed while draining.
+	drainIdle = 5 * time.Second
+
+	// drainReconnectIn and drainTryFor are sent to clients while
+	// draining, in the restarting frame. See derp.ServerRestartingMessage.
+	drainReconnectIn = 5 * time.Second
+	drainTryFor      = 5 * time.Second
+)
+
+// drain shuts down srv and s gracefully, waiting up to timeout for the
+// clients of s to go idle before closing their connections.
+func drain(s *derp.Server, srv *http.Server, timeout time.Duration) {
+	log.Printf("derper: draining for up to %v", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Stop accepting connections, so that clients reconnect to other
+	// servers (e.g. behind the same load balancer), but keep serving the
+	// hijacked DERP connections.
+	go srv.Shutdown(ctx)
+	s.StartDrain(drainReconnectIn, drainTryFor)
+
+	defer s.Close()
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		n := s.CloseIdleClients(drainIdle)
+		if n == 0 {
+			log.Printf("derper: drained")
+			return
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			log.Printf("derper: drain timed out with %d active clients", n)
+			return
+		}
+	}
+}
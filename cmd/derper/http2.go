@@ -0,0 +1,47 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"crypto/tls"
+	"expvar"
+	"flag"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+var serveHTTP2 = flag.Bool("http2", true, "whether to serve HTTP/2, including DERP over HTTP/2 for clients behind proxies that only forward HTTP/2, on the same listener as HTTP/1.1. Without TLS, HTTP/2 is accepted in cleartext (h2c), as spoken by TLS-terminating proxies to their backends.")
+
+var counterHTTP2Accepts = expvar.NewInt("derp_http2_accepts")
+
+// configureHTTP2 sets up srv to serve HTTP/2 alongside HTTP/1.1 if
+// --http2 is set, or to not serve it at all otherwise. Clients are served
+// whichever protocol they speak: over TLS, as negotiated with ALPN, and
+// without TLS, by looking for the HTTP/2 connection preface or an h2c
+// upgrade.
+//
+// It must be called after srv.Handler is set.
+func configureHTTP2(srv *http.Server, serveTLS bool) {
+	if !*serveHTTP2 {
+		// A non-nil empty map disables HTTP/2 over TLS.
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		return
+	}
+	if !serveTLS {
+		srv.Handler = h2c.NewHandler(srv.Handler, &http2.Server{})
+	}
+}
+
+// countHTTP2 returns a handler that counts the DERP connections made over
+// HTTP/2 before passing them to h.
+func countHTTP2(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && r.Method == "POST" {
+			counterHTTP2Accepts.Add(1)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
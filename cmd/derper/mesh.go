@@ -10,7 +10,12 @@
 	"log"
 	"net"
 	"net/netip"
+	"os"
+	"os/signal"
+	"regexp"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"tailscale.com/derp"
@@ -19,6 +24,88 @@
 	"tailscale.com/types/logger"
 )
 
+var (
+	meshMu      sync.Mutex
+	meshClients = map[string]*derphttp.Client{} // by host
+	meshHosts   []string                        // in --mesh-with order
+)
+
+// meshPeer is the status of a mesh peer that we connect to.
+type meshPeer struct {
+	host      string
+	connected bool
+}
+
+// meshPeerStatus returns the status of the mesh peers that we connect to, not
+// including ourselves if we're in --mesh-with.
+func meshPeerStatus() []meshPeer {
+	meshMu.Lock()
+	defer meshMu.Unlock()
+	var ret []meshPeer
+	for _, host := range meshHosts {
+		c := meshClients[host]
+		if c == nil {
+			continue
+		}
+		ret = append(ret, meshPeer{host: host, connected: c.Connected()})
+	}
+	return ret
+}
+
+var meshKeyRx = regexp.MustCompile(`(?i)^[0-9a-f]{64,}$`)
+
+// readMeshKeys returns the mesh keys in the named file, one per line.
+func readMeshKeys(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys := strings.Fields(string(b))
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no key in %s", path)
+	}
+	for _, k := range keys {
+		if !meshKeyRx.MatchString(k) {
+			return nil, fmt.Errorf("key in %s must contain 64+ hex digits", path)
+		}
+	}
+	return keys, nil
+}
+
+// reloadMeshKeys rereads --mesh-psk-file, updating the keys that s accepts
+// and the one that we use to connect to mesh peers.
+func reloadMeshKeys(s *derp.Server) error {
+	keys, err := readMeshKeys(*meshPSKFile)
+	if err != nil {
+		return err
+	}
+	s.SetMeshKeys(keys)
+
+	meshMu.Lock()
+	defer meshMu.Unlock()
+	for _, c := range meshClients {
+		c.SetMeshKey(keys[0])
+	}
+	log.Printf("derper: reloaded %d mesh keys", len(keys))
+	return nil
+}
+
+func reloadMeshKeysOnSIGHUP(ctx context.Context, s *derp.Server) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+		}
+		if err := reloadMeshKeys(s); err != nil {
+			log.Printf("derper: reloading mesh keys: %v", err)
+		}
+	}
+}
+
 func startMesh(s *derp.Server) error {
 	if *meshWith == "" {
 		return nil
@@ -71,6 +158,17 @@ func startMeshWithHost(s *derp.Server, host string) error {
 
 	add := func(k key.NodePublic, _ netip.AddrPort) { s.AddPacketForwarder(k, c) }
 	remove := func(k key.NodePublic) { s.RemovePacketForwarder(k, c) }
-	go c.RunWatchConnectionLoop(context.Background(), s.PublicKey(), logf, add, remove)
+
+	meshMu.Lock()
+	meshHosts = append(meshHosts, host)
+	meshClients[host] = c
+	meshMu.Unlock()
+	go func() {
+		c.RunWatchConnectionLoop(context.Background(), s.PublicKey(), logf, add, remove)
+		// RunWatchConnectionLoop only returns early if host is us.
+		meshMu.Lock()
+		delete(meshClients, host)
+		meshMu.Unlock()
+	}()
 	return nil
 }
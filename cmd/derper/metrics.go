@@ -0,0 +1,148 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"tailscale.com/derp"
+	"tailscale.com/tsweb"
+	"tailscale.com/tsweb/promvarz"
+)
+
+var (
+	metricsAddr      = flag.String("metrics-addr", "", "if non-empty, address on which to serve Prometheus metrics at /metrics, in the same form as -a. The listener is separate from the DERP one and never uses TLS.")
+	metricsTokenFile = flag.String("metrics-token-file", "", "if non-empty, path to a file containing a bearer token that /metrics requests must present; whitespace is trimmed. Otherwise /metrics is only served to the same clients as /debug/.")
+	metricsPerClient = flag.Bool("metrics-per-client", false, "whether /metrics includes the traffic of each connected client, labeled by its public key")
+)
+
+// startMetrics starts serving Prometheus metrics about s on --metrics-addr, if
+// set.
+func startMetrics(s *derp.Server) error {
+	if *metricsAddr == "" {
+		return nil
+	}
+	h := &metricsHandler{s: s, perClient: *metricsPerClient}
+	if *metricsTokenFile != "" {
+		b, err := os.ReadFile(*metricsTokenFile)
+		if err != nil {
+			return err
+		}
+		h.token = strings.TrimSpace(string(b))
+		if h.token == "" {
+			return fmt.Errorf("%s is empty", *metricsTokenFile)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", h)
+	srv := &http.Server{
+		Addr:         *metricsAddr,
+		Handler:      mux,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	log.Printf("derper: serving metrics on %s", *metricsAddr)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("derper: metrics: %v", err)
+		}
+	}()
+	return nil
+}
+
+// metricsHandler serves the metrics of the process, including those of the
+// DERP server, in the Prometheus text format.
+type metricsHandler struct {
+	s         *derp.Server
+	token     string // if non-empty, the bearer token required
+	perClient bool   // whether to include per-client traffic
+}
+
+func (h *metricsHandler) authorized(r *http.Request) bool {
+	if h.token == "" {
+		return tsweb.AllowDebugAccess(r)
+	}
+	tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(tok), []byte(h.token)) == 1
+}
+
+func (h *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		if h.token != "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	// The DERP server's own metrics (packets and bytes forwarded,
+	// current clients, accepts, disconnects, handshake failures, etc.)
+	// are published as the "derp" expvar.
+	promvarz.Handler(w, r)
+
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	writeMeshMetrics(bw, meshPeerStatus())
+	if h.perClient {
+		writeClientMetrics(bw, h.s.ClientStats())
+	}
+}
+
+// writeMeshMetrics writes whether each of the mesh peers that we connect to
+// is connected.
+func writeMeshMetrics(w io.Writer, peers []meshPeer) {
+	if len(peers) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# TYPE derper_mesh_peer_connected gauge\n")
+	for _, p := range peers {
+		v := 0
+		if p.connected {
+			v = 1
+		}
+		fmt.Fprintf(w, "derper_mesh_peer_connected{host=%q} %d\n", p.host, v)
+	}
+}
+
+// writeClientMetrics writes the traffic of each client in stats.
+func writeClientMetrics(w io.Writer, stats []derp.ClientStats) {
+	if len(stats) == 0 {
+		return
+	}
+	for _, m := range []struct {
+		name string
+		val  func(derp.ClientStats) int64
+	}{
+		{"derper_client_packets_received", func(cs derp.ClientStats) int64 { return cs.PacketsRecv }},
+		{"derper_client_bytes_received", func(cs derp.ClientStats) int64 { return cs.BytesRecv }},
+		{"derper_client_packets_sent", func(cs derp.ClientStats) int64 { return cs.PacketsSent }},
+		{"derper_client_bytes_sent", func(cs derp.ClientStats) int64 { return cs.BytesSent }},
+	} {
+		fmt.Fprintf(w, "# TYPE %s counter\n", m.name)
+		for _, cs := range stats {
+			fmt.Fprintf(w, "%s{key=%q,addr=%q,mesh=\"%v\"} %d\n", m.name, cs.Key.String(), addrLabel(cs), cs.Mesh, m.val(cs))
+		}
+	}
+	fmt.Fprintf(w, "# TYPE derper_client_connected_seconds gauge\n")
+	now := time.Now()
+	for _, cs := range stats {
+		fmt.Fprintf(w, "derper_client_connected_seconds{key=%q,addr=%q,mesh=\"%v\"} %d\n", cs.Key.String(), addrLabel(cs), cs.Mesh, int64(now.Sub(cs.ConnectedAt).Seconds()))
+	}
+}
+
+func addrLabel(cs derp.ClientStats) string {
+	if !cs.RemoteAddr.IsValid() {
+		return ""
+	}
+	return cs.RemoteAddr.String()
+}
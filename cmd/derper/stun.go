@@ -0,0 +1,112 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"tailscale.com/net/stunserver"
+)
+
+var (
+	stunBindV4 = flag.String("stun-bind-v4", "", "if non-empty, the IPv4 address on which to serve STUN, such as 0.0.0.0 for all. If this or --stun-bind-v6 is set, STUN is only served on the address families given, on separate sockets, instead of on the IP of the -a flag.")
+	stunBindV6 = flag.String("stun-bind-v6", "", "if non-empty, the IPv6 address on which to serve STUN, such as :: for all. See --stun-bind-v4.")
+)
+
+// stunProbeTimeout is how long the STUN health check waits for each
+// listener to answer.
+const stunProbeTimeout = 2 * time.Second
+
+// stunListener is a STUN server run by derper.
+type stunListener struct {
+	family string // "ipv4", "ipv6", or "" for both
+	ss     *stunserver.STUNServer
+}
+
+// startSTUN starts the STUN servers on --stun-port: one on listenHost for
+// both address families, or one on each of --stun-bind-v4 and --stun-bind-v6
+// if either is set.
+func startSTUN(ctx context.Context, listenHost string) ([]stunListener, error) {
+	type bind struct{ family, network, host string }
+	binds := []bind{{"", "udp", listenHost}}
+	if *stunBindV4 != "" || *stunBindV6 != "" {
+		binds = binds[:0]
+		if *stunBindV4 != "" {
+			binds = append(binds, bind{"ipv4", "udp4", *stunBindV4})
+		}
+		if *stunBindV6 != "" {
+			binds = append(binds, bind{"ipv6", "udp6", *stunBindV6})
+		}
+	}
+	var ls []stunListener
+	for _, b := range binds {
+		ss := stunserver.New(ctx)
+		if err := ss.ListenNetwork(b.network, net.JoinHostPort(b.host, fmt.Sprint(*stunPort))); err != nil {
+			return nil, fmt.Errorf("STUN %s: %w", b.network, err)
+		}
+		go ss.Serve()
+		ls = append(ls, stunListener{b.family, ss})
+	}
+	return ls, nil
+}
+
+// serveSTUNHealth returns a handler reporting whether each of the STUN
+// listeners in ls answers a binding request sent from this host, along with
+// how many requests it has answered. It responds 503 Service Unavailable if
+// any doesn't answer, so that load balancers can use it as a health check.
+func serveSTUNHealth(ls []stunListener) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), stunProbeTimeout)
+		defer cancel()
+
+		errs := make([]error, len(ls))
+		var wg sync.WaitGroup
+		for i, l := range ls {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = l.ss.Probe(ctx)
+			}()
+		}
+		wg.Wait()
+
+		status := http.StatusOK
+		for _, err := range errs {
+			if err != nil {
+				status = http.StatusServiceUnavailable
+			}
+		}
+		if len(ls) == 0 {
+			status = http.StatusNotFound
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		if len(ls) == 0 {
+			fmt.Fprintln(w, "STUN disabled")
+			return
+		}
+		for i, l := range ls {
+			family := l.family
+			if family == "" {
+				family = "any"
+			}
+			res := "ok"
+			if errs[i] != nil {
+				res = "error: " + errs[i].Error()
+			}
+			n, last := l.ss.Served()
+			lastStr := "never"
+			if !last.IsZero() {
+				lastStr = last.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s %v: %s; served %d, last %s\n", family, l.ss.LocalAddr(), res, n, lastStr)
+		}
+	}
+}
@@ -26,6 +26,8 @@
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	tsoperator "tailscale.com/k8s-operator"
 	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/net/netutil"
+	"tailscale.com/tailcfg"
 	"tailscale.com/tstime"
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/set"
@@ -39,8 +41,19 @@
 	reasonConnectorCleanupInProgress = "ConnectorCleanupInProgress"
 	reasonConnectorInvalid           = "ConnectorInvalid"
 
-	messageConnectorCreationFailed = "Failed creating Connector: %v"
-	messageConnectorInvalid        = "Connector is invalid: %v"
+	reasonProxyRunning             = "ProxyRunning"
+	reasonProxyNotRunning          = "ProxyNotRunning"
+	reasonProxyDeviceAuthorized    = "ProxyDeviceAuthorized"
+	reasonProxyDeviceNotAuthorized = "ProxyDeviceNotAuthorized"
+	reasonRoutesApproved           = "RoutesApproved"
+	reasonRoutesNotApproved        = "RoutesNotApproved"
+	reasonRoutesApprovalFailed     = "RoutesApprovalFailed"
+
+	messageConnectorCreationFailed  = "Failed creating Connector: %v"
+	messageConnectorInvalid         = "Connector is invalid: %v"
+	messageProxyDeviceNotAuthorized = "proxy has not logged in to the tailnet yet"
+	messageRoutesNotApproved        = "routes %v are pending approval in the admin panel"
+	messageRoutesApprovalFailed     = "failed to approve routes %v: %v"
 
 	shortRequeue = time.Second * 5
 )
@@ -56,6 +69,10 @@ type ConnectorReconciler struct {
 
 	clock tstime.Clock
 
+	// autoApproveRoutes is whether routes advertised by Connectors are
+	// approved via the Tailscale API once their devices are authorized.
+	autoApproveRoutes bool
+
 	mu sync.Mutex // protects following
 
 	subnetRouters set.Slice[types.UID] // for subnet routers gauge
@@ -147,6 +164,9 @@ func (a *ConnectorReconciler) Reconcile(ctx context.Context, req reconcile.Reque
 	}
 
 	logger.Info("Connector resources synced")
+	if err = a.setProxyHealthConditions(ctx, logger, cn); err != nil {
+		logger.Errorf("error checking Connector proxy health: %v", err)
+	}
 	cn.Status.IsExitNode = cn.Spec.ExitNode
 	if cn.Spec.SubnetRouter != nil {
 		cn.Status.SubnetRoutes = cn.Spec.SubnetRouter.AdvertiseRoutes.Stringify()
@@ -159,10 +179,6 @@ func (a *ConnectorReconciler) Reconcile(ctx context.Context, req reconcile.Reque
 // maybeProvisionConnector ensures that any new resources required for this
 // Connector instance are deployed to the cluster.
 func (a *ConnectorReconciler) maybeProvisionConnector(ctx context.Context, logger *zap.SugaredLogger, cn *tsapi.Connector) error {
-	hostname := cn.Name + "-connector"
-	if cn.Spec.Hostname != "" {
-		hostname = string(cn.Spec.Hostname)
-	}
 	crl := childResourceLabels(cn.Name, a.tsnamespace, "connector")
 
 	proxyClass := cn.Spec.ProxyClass
@@ -175,6 +191,14 @@ func (a *ConnectorReconciler) maybeProvisionConnector(ctx context.Context, logge
 		}
 	}
 
+	hostname := string(cn.Spec.Hostname)
+	if hostname == "" {
+		var err error
+		if hostname, err = a.ssr.deviceHostname(ctx, proxyClass, "", cn.Name, cn.Name+"-connector"); err != nil {
+			return fmt.Errorf("failed to determine hostname: %w", err)
+		}
+	}
+
 	sts := &tailscaleSTSConfig{
 		ParentResourceName:  cn.Name,
 		ParentResourceUID:   string(cn.UID),
@@ -214,6 +238,91 @@ func (a *ConnectorReconciler) maybeProvisionConnector(ctx context.Context, logge
 	return err
 }
 
+// setProxyHealthConditions sets the ProxyRunning, ProxyDeviceAuthorized and
+// RoutesAdvertised conditions of cn from the state of its proxy Pod and the
+// device info that the proxy stores in its state Secret.
+func (a *ConnectorReconciler) setProxyHealthConditions(ctx context.Context, logger *zap.SugaredLogger, cn *tsapi.Connector) error {
+	h, err := a.ssr.ProxyHealth(ctx, childResourceLabels(cn.Name, a.tsnamespace, "connector"))
+	if err != nil {
+		return fmt.Errorf("failed to get proxy health: %w", err)
+	}
+	setCondition := func(conditionType tsapi.ConnectorConditionType, status metav1.ConditionStatus, reason, message string) {
+		tsoperator.SetConnectorCondition(cn, conditionType, status, reason, message, cn.Generation, a.clock, logger)
+	}
+
+	if h.running {
+		setCondition(tsapi.ProxyRunning, metav1.ConditionTrue, reasonProxyRunning, reasonProxyRunning)
+	} else {
+		setCondition(tsapi.ProxyRunning, metav1.ConditionFalse, reasonProxyNotRunning, h.notRunningReason)
+	}
+
+	if h.deviceID == "" {
+		setCondition(tsapi.ProxyDeviceAuthorized, metav1.ConditionFalse, reasonProxyDeviceNotAuthorized, messageProxyDeviceNotAuthorized)
+		setCondition(tsapi.RoutesAdvertised, metav1.ConditionFalse, reasonProxyDeviceNotAuthorized, messageProxyDeviceNotAuthorized)
+		return nil
+	}
+	if h.lockedOutReason != "" {
+		message := fmt.Sprintf(messageProxyDeviceLockedOut, h.lockedOutReason)
+		setCondition(tsapi.ProxyDeviceAuthorized, metav1.ConditionFalse, reasonProxyDeviceLockedOut, message)
+		setCondition(tsapi.RoutesAdvertised, metav1.ConditionFalse, reasonProxyDeviceLockedOut, message)
+		return nil
+	}
+	setCondition(tsapi.ProxyDeviceAuthorized, metav1.ConditionTrue, reasonProxyDeviceAuthorized, reasonProxyDeviceAuthorized)
+
+	var routes string
+	if cn.Spec.SubnetRouter != nil {
+		routes = cn.Spec.SubnetRouter.AdvertiseRoutes.Stringify()
+	}
+	want, err := netutil.CalcAdvertiseRoutes(routes, cn.Spec.ExitNode)
+	if err != nil {
+		return fmt.Errorf("error calculating routes: %w", err)
+	}
+	unapproved := slices.DeleteFunc(want, func(p netip.Prefix) bool {
+		return slices.Contains(h.routes, p)
+	})
+	if len(unapproved) == 0 {
+		setCondition(tsapi.RoutesAdvertised, metav1.ConditionTrue, reasonRoutesApproved, reasonRoutesApproved)
+		return nil
+	}
+	if a.autoApproveRoutes {
+		if err := a.approveRoutes(ctx, logger, h.deviceID, unapproved); err != nil {
+			message := fmt.Sprintf(messageRoutesApprovalFailed, unapproved, err)
+			a.recorder.Eventf(cn, corev1.EventTypeWarning, reasonRoutesApprovalFailed, message)
+			setCondition(tsapi.RoutesAdvertised, metav1.ConditionFalse, reasonRoutesApprovalFailed, message)
+			return err
+		}
+	}
+	// Even if the routes were just approved, the condition is only set
+	// once the proxy reports them, as that's when they take effect.
+	setCondition(tsapi.RoutesAdvertised, metav1.ConditionFalse, reasonRoutesNotApproved, fmt.Sprintf(messageRoutesNotApproved, unapproved))
+	return nil
+}
+
+// approveRoutes enables those of routes that the device deviceID advertises
+// via the Tailscale API, keeping the routes that are already enabled for it.
+// Routes that the device doesn't advertise yet are left for a later
+// reconcile, as they would not be approved anyway.
+func (a *ConnectorReconciler) approveRoutes(ctx context.Context, logger *zap.SugaredLogger, deviceID tailcfg.StableNodeID, routes []netip.Prefix) error {
+	cur, err := a.ssr.tsClient.Routes(ctx, string(deviceID))
+	if err != nil {
+		return fmt.Errorf("failed to get device routes: %w", err)
+	}
+	enabled := slices.Clone(cur.EnabledRoutes)
+	for _, r := range routes {
+		if slices.Contains(cur.AdvertisedRoutes, r) && !slices.Contains(enabled, r) {
+			enabled = append(enabled, r)
+		}
+	}
+	if len(enabled) == len(cur.EnabledRoutes) {
+		return nil
+	}
+	logger.Infof("approving routes %v for device %s", enabled[len(cur.EnabledRoutes):], deviceID)
+	if _, err := a.ssr.tsClient.SetRoutes(ctx, string(deviceID), enabled); err != nil {
+		return fmt.Errorf("failed to set device routes: %w", err)
+	}
+	return nil
+}
+
 func (a *ConnectorReconciler) maybeCleanupConnector(ctx context.Context, logger *zap.SugaredLogger, cn *tsapi.Connector) (bool, error) {
 	if done, err := a.ssr.Cleanup(ctx, logger, childResourceLabels(cn.Name, a.tsnamespace, "connector")); err != nil {
 		return false, fmt.Errorf("failed to cleanup Connector resources: %w", err)
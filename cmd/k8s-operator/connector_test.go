@@ -7,6 +7,8 @@
 
 import (
 	"context"
+	"net/netip"
+	"slices"
 	"testing"
 
 	"go.uber.org/zap"
@@ -14,9 +16,13 @@
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"tailscale.com/client/tailscale"
 	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
 	"tailscale.com/tstest"
+	"tailscale.com/util/mak"
 )
 
 func TestConnector(t *testing.T) {
@@ -289,3 +295,186 @@ func TestConnectorWithProxyClass(t *testing.T) {
 	expectReconciled(t, cr, "", "test")
 	expectEqual(t, fc, expectedSTS(t, fc, opts))
 }
+
+func TestConnectorProxyHealth(t *testing.T) {
+	cn := &tsapi.Connector{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+			UID:  types.UID("1234-UID"),
+		},
+		TypeMeta: metav1.TypeMeta{
+			Kind:       tsapi.ConnectorKind,
+			APIVersion: "tailscale.io/v1alpha1",
+		},
+		Spec: tsapi.ConnectorSpec{
+			SubnetRouter: &tsapi.SubnetRouter{
+				AdvertiseRoutes: []tsapi.Route{"10.40.0.0/14"},
+			},
+		},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(cn).
+		WithStatusSubresource(cn).
+		Build()
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr := &ConnectorReconciler{
+		Client: fc,
+		clock:  tstest.NewClock(tstest.ClockOpts{}),
+		ssr: &tailscaleSTSReconciler{
+			Client:            fc,
+			tsClient:          &fakeTSClient{},
+			defaultTags:       []string{"tag:k8s"},
+			operatorNamespace: "operator-ns",
+			proxyImage:        "tailscale/tailscale",
+		},
+		logger: zl.Sugar(),
+	}
+	expectCondition := func(conditionType tsapi.ConnectorConditionType, status metav1.ConditionStatus, reason string) {
+		t.Helper()
+		expectConnectorCondition(t, fc, "test", conditionType, status, reason)
+	}
+
+	// 1. The proxy resources are created, but there's no Pod yet.
+	expectReconciled(t, cr, "", "test")
+	fullName, _ := findGenName(t, fc, "", "test", "connector")
+	expectCondition(tsapi.ConnectorReady, metav1.ConditionTrue, reasonConnectorCreated)
+	expectCondition(tsapi.ProxyRunning, metav1.ConditionFalse, reasonProxyNotRunning)
+	expectCondition(tsapi.ProxyDeviceAuthorized, metav1.ConditionFalse, reasonProxyDeviceNotAuthorized)
+	expectCondition(tsapi.RoutesAdvertised, metav1.ConditionFalse, reasonProxyDeviceNotAuthorized)
+
+	// 2. The proxy Pod is running and ready.
+	mustCreate(t, fc, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-0",
+			Namespace: "operator-ns",
+			Labels:    childResourceLabels("test", "", "connector"),
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{
+				Type:   corev1.PodReady,
+				Status: corev1.ConditionTrue,
+			}},
+		},
+	})
+	expectReconciled(t, cr, "", "test")
+	expectCondition(tsapi.ProxyRunning, metav1.ConditionTrue, reasonProxyRunning)
+	expectCondition(tsapi.ProxyDeviceAuthorized, metav1.ConditionFalse, reasonProxyDeviceNotAuthorized)
+
+	// 3. The proxy logs in, but its route isn't approved yet.
+	mustUpdate(t, fc, "operator-ns", fullName, func(s *corev1.Secret) {
+		mak.Set(&s.Data, "device_id", []byte("ts-id-1234"))
+		mak.Set(&s.Data, "device_fqdn", []byte("test-connector.tailnetxyz.ts.net."))
+		mak.Set(&s.Data, "device_routes", []byte(`[]`))
+	})
+	expectReconciled(t, cr, "", "test")
+	expectCondition(tsapi.ProxyDeviceAuthorized, metav1.ConditionTrue, reasonProxyDeviceAuthorized)
+	expectCondition(tsapi.RoutesAdvertised, metav1.ConditionFalse, reasonRoutesNotApproved)
+
+	// 4. The route is approved.
+	mustUpdate(t, fc, "operator-ns", fullName, func(s *corev1.Secret) {
+		mak.Set(&s.Data, "device_routes", []byte(`["10.40.0.0/14"]`))
+	})
+	expectReconciled(t, cr, "", "test")
+	expectCondition(tsapi.RoutesAdvertised, metav1.ConditionTrue, reasonRoutesApproved)
+}
+
+func TestConnectorAutoApproveRoutes(t *testing.T) {
+	cn := &tsapi.Connector{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+			UID:  types.UID("1234-UID"),
+		},
+		TypeMeta: metav1.TypeMeta{
+			Kind:       tsapi.ConnectorKind,
+			APIVersion: "tailscale.io/v1alpha1",
+		},
+		Spec: tsapi.ConnectorSpec{
+			SubnetRouter: &tsapi.SubnetRouter{
+				AdvertiseRoutes: []tsapi.Route{"10.40.0.0/14", "10.44.0.0/20"},
+			},
+		},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(cn).
+		WithStatusSubresource(cn).
+		Build()
+	ft := &fakeTSClient{}
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr := &ConnectorReconciler{
+		Client:   fc,
+		clock:    tstest.NewClock(tstest.ClockOpts{}),
+		recorder: record.NewFakeRecorder(10),
+		ssr: &tailscaleSTSReconciler{
+			Client:            fc,
+			tsClient:          ft,
+			defaultTags:       []string{"tag:k8s"},
+			operatorNamespace: "operator-ns",
+			proxyImage:        "tailscale/tailscale",
+		},
+		logger:            zl.Sugar(),
+		autoApproveRoutes: true,
+	}
+	expectReconciled(t, cr, "", "test")
+	fullName, _ := findGenName(t, fc, "", "test", "connector")
+
+	// The device only advertises one of the routes so far and has an
+	// unrelated route enabled, which must be kept.
+	other := netip.MustParsePrefix("192.168.0.0/24")
+	mak.Set(&ft.routes, "ts-id-1234", &tailscale.Routes{
+		AdvertisedRoutes: []netip.Prefix{netip.MustParsePrefix("10.40.0.0/14")},
+		EnabledRoutes:    []netip.Prefix{other},
+	})
+	mustUpdate(t, fc, "operator-ns", fullName, func(s *corev1.Secret) {
+		mak.Set(&s.Data, "device_id", []byte("ts-id-1234"))
+		mak.Set(&s.Data, "device_routes", []byte(`["192.168.0.0/24"]`))
+	})
+	expectReconciled(t, cr, "", "test")
+	want := []netip.Prefix{other, netip.MustParsePrefix("10.40.0.0/14")}
+	if got := ft.routes["ts-id-1234"].EnabledRoutes; !slices.Equal(got, want) {
+		t.Fatalf("enabled routes = %v, want %v", got, want)
+	}
+	// The routes only count as advertised once the proxy reports them.
+	expectConnectorCondition(t, fc, "test", tsapi.RoutesAdvertised, metav1.ConditionFalse, reasonRoutesNotApproved)
+
+	// The device advertises the other route too.
+	ft.routes["ts-id-1234"].AdvertisedRoutes = append(ft.routes["ts-id-1234"].AdvertisedRoutes, netip.MustParsePrefix("10.44.0.0/20"))
+	expectReconciled(t, cr, "", "test")
+	want = append(want, netip.MustParsePrefix("10.44.0.0/20"))
+	if got := ft.routes["ts-id-1234"].EnabledRoutes; !slices.Equal(got, want) {
+		t.Fatalf("enabled routes = %v, want %v", got, want)
+	}
+
+	mustUpdate(t, fc, "operator-ns", fullName, func(s *corev1.Secret) {
+		mak.Set(&s.Data, "device_routes", []byte(`["192.168.0.0/24","10.40.0.0/14","10.44.0.0/20"]`))
+	})
+	expectReconciled(t, cr, "", "test")
+	expectConnectorCondition(t, fc, "test", tsapi.RoutesAdvertised, metav1.ConditionTrue, reasonRoutesApproved)
+}
+
+// expectConnectorCondition fails the test if the condition conditionType of
+// the Connector name doesn't have the given status and reason.
+func expectConnectorCondition(t *testing.T, fc client.Client, name string, conditionType tsapi.ConnectorConditionType, status metav1.ConditionStatus, reason string) {
+	t.Helper()
+	cn := new(tsapi.Connector)
+	if err := fc.Get(context.Background(), types.NamespacedName{Name: name}, cn); err != nil {
+		t.Fatal(err)
+	}
+	for _, cond := range cn.Status.Conditions {
+		if cond.Type == conditionType {
+			if cond.Status != status || cond.Reason != reason {
+				t.Fatalf("condition %s is %s (%s), want %s (%s)", conditionType, cond.Status, cond.Reason, status, reason)
+			}
+			return
+		}
+	}
+	t.Fatalf("condition %s not set", conditionType)
+}
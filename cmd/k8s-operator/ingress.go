@@ -252,9 +252,15 @@ func (a *IngressReconciler) maybeProvision(ctx context.Context, logger *zap.Suga
 	if tstr, ok := ing.Annotations[AnnotationTags]; ok {
 		tags = strings.Split(tstr, ",")
 	}
-	hostname := ing.Namespace + "-" + ing.Name + "-ingress"
+	var hostname string
 	if tlsHost != "" {
 		hostname, _, _ = strings.Cut(tlsHost, ".")
+	} else {
+		var err error
+		hostname, err = a.ssr.deviceHostname(ctx, proxyClass, ing.Namespace, ing.Name, ing.Namespace+"-"+ing.Name+"-ingress")
+		if err != nil {
+			return fmt.Errorf("failed to determine hostname: %w", err)
+		}
 	}
 
 	sts := &tailscaleSTSConfig{
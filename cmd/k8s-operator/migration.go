@@ -0,0 +1,112 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const reasonLegacyConfigMigrated = "LegacyConfigMigrated"
+
+// annotationMigration describes a user-settable annotation that was renamed.
+type annotationMigration struct {
+	old, new string
+}
+
+// serviceAnnotationMigrations are the renamed annotations of Services. The
+// ServiceReconciler still reads the old names, so that proxies keep working
+// until the migration has happened.
+var serviceAnnotationMigrations = []annotationMigration{
+	{old: annotationTailnetTargetIPOld, new: AnnotationTailnetTargetIP},
+}
+
+// MigrationReconciler rewrites legacy configuration of the resources that the
+// operator manages to its current equivalent, so that clusters set up for
+// older operator versions don't depend on deprecated configuration.
+type MigrationReconciler struct {
+	client.Client
+
+	recorder record.EventRecorder
+	logger   *zap.SugaredLogger
+}
+
+func (a *MigrationReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	logger := a.logger.With("service-ns", req.Namespace, "service-name", req.Name)
+	logger.Debugf("starting reconcile")
+	defer logger.Debugf("reconcile finished")
+
+	svc := new(corev1.Service)
+	err := a.Get(ctx, req.NamespacedName, svc)
+	if apierrors.IsNotFound(err) {
+		logger.Debugf("service not found, assuming it was deleted")
+		return reconcile.Result{}, nil
+	} else if err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to get svc: %w", err)
+	}
+	if !svc.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	changes := migrateAnnotations(svc, serviceAnnotationMigrations)
+	if len(changes) == 0 {
+		return reconcile.Result{}, nil
+	}
+	// A conflicting update is retried by returning the error.
+	if err := a.Update(ctx, svc); err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to migrate legacy configuration: %w", err)
+	}
+	msg := "migrated legacy configuration: " + strings.Join(changes, "; ")
+	logger.Info(msg)
+	a.recorder.Event(svc, corev1.EventTypeNormal, reasonLegacyConfigMigrated, msg)
+	return reconcile.Result{}, nil
+}
+
+// hasLegacyServiceConfig reports whether o has configuration that
+// MigrationReconciler would rewrite.
+func hasLegacyServiceConfig(o client.Object) bool {
+	for _, m := range serviceAnnotationMigrations {
+		if _, ok := o.GetAnnotations()[m.old]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// migrateAnnotations renames the annotations of o according to migrations
+// and returns a description of each change. If both the old and the new
+// annotation are set, the new one is kept, as it's the one that takes
+// precedence.
+func migrateAnnotations(o client.Object, migrations []annotationMigration) (changes []string) {
+	annots := o.GetAnnotations()
+	for _, m := range migrations {
+		v, ok := annots[m.old]
+		if !ok {
+			continue
+		}
+		delete(annots, m.old)
+		if cur, ok := annots[m.new]; ok {
+			if cur != v {
+				changes = append(changes, fmt.Sprintf("removed annotation %s=%q, overridden by %s=%q", m.old, v, m.new, cur))
+			} else {
+				changes = append(changes, fmt.Sprintf("removed annotation %s, superseded by %s", m.old, m.new))
+			}
+			continue
+		}
+		annots[m.new] = v
+		changes = append(changes, fmt.Sprintf("renamed annotation %s to %s", m.old, m.new))
+	}
+	o.SetAnnotations(annots)
+	return changes
+}
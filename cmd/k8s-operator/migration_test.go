@@ -0,0 +1,86 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+)
+
+func TestMigrationReconciler(t *testing.T) {
+	svc := func(name string, annots map[string]string) *corev1.Service {
+		return &corev1.Service{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "Service",
+				APIVersion: "v1",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				Annotations: annots,
+			},
+			Spec: corev1.ServiceSpec{
+				Type:         corev1.ServiceTypeExternalName,
+				ExternalName: "unused",
+			},
+		}
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(
+			svc("old", map[string]string{
+				annotationTailnetTargetIPOld: "100.99.99.99",
+				"other":                      "annotation",
+			}),
+			svc("both", map[string]string{
+				annotationTailnetTargetIPOld: "100.99.99.99",
+				AnnotationTailnetTargetIP:    "100.88.88.88",
+			}),
+		).
+		Build()
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := record.NewFakeRecorder(2)
+	mr := &MigrationReconciler{
+		Client:   fc,
+		recorder: fr,
+		logger:   zl.Sugar(),
+	}
+
+	// The old annotation is renamed.
+	expectReconciled(t, mr, "default", "old")
+	expectEqual(t, fc, svc("old", map[string]string{
+		AnnotationTailnetTargetIP: "100.99.99.99",
+		"other":                   "annotation",
+	}))
+	wantEvent := "Normal LegacyConfigMigrated migrated legacy configuration: renamed annotation tailscale.com/ts-tailnet-target-ip to tailscale.com/tailnet-ip"
+	if got := <-fr.Events; got != wantEvent {
+		t.Errorf("got event %q, want %q", got, wantEvent)
+	}
+
+	// The new annotation takes precedence over the old one.
+	expectReconciled(t, mr, "default", "both")
+	expectEqual(t, fc, svc("both", map[string]string{
+		AnnotationTailnetTargetIP: "100.88.88.88",
+	}))
+	<-fr.Events
+
+	// Nothing else to do.
+	expectReconciled(t, mr, "default", "old")
+	select {
+	case ev := <-fr.Events:
+		t.Errorf("unexpected event %q", ev)
+	default:
+	}
+}
@@ -9,6 +9,7 @@
 
 import (
 	"context"
+	"net/netip"
 	"os"
 	"regexp"
 	"strings"
@@ -19,8 +20,12 @@
 	"go.uber.org/zap/zapcore"
 	"golang.org/x/oauth2/clientcredentials"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
@@ -32,7 +37,9 @@
 	kzap "sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 	"tailscale.com/client/tailscale"
 	"tailscale.com/hostinfo"
 	"tailscale.com/ipn"
@@ -203,9 +210,26 @@ func initTSNet(zlog *zap.SugaredLogger) (*tsnet.Server, *tailscale.Client) {
 func runReconcilers(zlog *zap.SugaredLogger, s *tsnet.Server, tsNamespace string, restConfig *rest.Config, tsClient *tailscale.Client, image, priorityClassName, tags, tsFirewallMode string) {
 	var (
 		isDefaultLoadBalancer = defaultBool("OPERATOR_DEFAULT_LOAD_BALANCER", false)
+		// autoApproveRoutes is whether the operator approves the routes
+		// advertised by Connectors via the Tailscale API. Its OAuth
+		// client needs the 'devices' scope for that.
+		autoApproveRoutes = defaultBool("OPERATOR_AUTO_APPROVE_ROUTES", false)
+		// hostnameTemplate is the template for the tailnet hostnames of
+		// proxies, see expandHostnameTemplate.
+		hostnameTemplate = defaultEnv("OPERATOR_HOSTNAME_TEMPLATE", "")
+		clusterName      = defaultEnv("OPERATOR_CLUSTER_NAME", "")
+		// migrateLegacyConfig is whether legacy configuration of the
+		// resources that the operator manages is rewritten to its
+		// current equivalent.
+		migrateLegacyConfig = defaultBool("OPERATOR_MIGRATE_LEGACY_CONFIG", true)
+		// tailnetLockKeyPath is the path of the tailnet lock key that
+		// proxies are signed with on tailnets with tailnet lock
+		// enabled.
+		tailnetLockKeyPath = defaultEnv("OPERATOR_TAILNET_LOCK_KEY_FILE", "")
 	)
 	startlog := zlog.Named("startReconcilers")
-	// For secrets and statefulsets, we only get permission to touch the objects
+	// For secrets, statefulsets, poddisruptionbudgets and
+	// horizontalpodautoscalers, we only get permission to touch the objects
 	// in the controller's own namespace. This cannot be expressed by
 	// .Watches(...) below, instead you have to add a per-type field selector to
 	// the cache that sits a few layers below the builder stuff, which will
@@ -220,8 +244,10 @@ func runReconcilers(zlog *zap.SugaredLogger, s *tsnet.Server, tsNamespace string
 		// resources that we GET via the controller manager's client.
 		Cache: cache.Options{
 			ByObject: map[client.Object]cache.ByObject{
-				&corev1.Secret{}:      nsFilter,
-				&appsv1.StatefulSet{}: nsFilter,
+				&corev1.Secret{}:                         nsFilter,
+				&appsv1.StatefulSet{}:                    nsFilter,
+				&policyv1.PodDisruptionBudget{}:          nsFilter,
+				&autoscalingv2.HorizontalPodAutoscaler{}: nsFilter,
 			},
 		},
 		Scheme: tsapi.GlobalScheme,
@@ -237,6 +263,17 @@ func runReconcilers(zlog *zap.SugaredLogger, s *tsnet.Server, tsNamespace string
 	// ProxyClass's name.
 	proxyClassFilterForSvc := handler.EnqueueRequestsFromMapFunc(proxyClassHandlerForSvc(mgr.GetClient(), startlog))
 
+	lc, err := s.LocalClient()
+	if err != nil {
+		startlog.Fatalf("could not get local client: %v", err)
+	}
+	tailnetLock := &tailnetLockSigner{lc: lc}
+	if tailnetLockKeyPath != "" {
+		if tailnetLock.key, err = readTailnetLockKey(tailnetLockKeyPath); err != nil {
+			startlog.Fatalf("could not read tailnet lock key: %v", err)
+		}
+	}
+
 	eventRecorder := mgr.GetEventRecorderFor("tailscale-operator")
 	ssr := &tailscaleSTSReconciler{
 		Client:                 mgr.GetClient(),
@@ -247,6 +284,12 @@ func runReconcilers(zlog *zap.SugaredLogger, s *tsnet.Server, tsNamespace string
 		proxyImage:             image,
 		proxyPriorityClassName: priorityClassName,
 		tsFirewallMode:         tsFirewallMode,
+		hostnameTemplate:       hostnameTemplate,
+		clusterName:            clusterName,
+		tailnetLock:            tailnetLock,
+	}
+	if err := validateHostnameTemplate(hostnameTemplate); err != nil {
+		startlog.Fatalf("invalid OPERATOR_HOSTNAME_TEMPLATE: %v", err)
 	}
 	err = builder.
 		ControllerManagedBy(mgr).
@@ -294,17 +337,43 @@ func runReconcilers(zlog *zap.SugaredLogger, s *tsnet.Server, tsNamespace string
 		For(&tsapi.Connector{}).
 		Watches(&appsv1.StatefulSet{}, connectorFilter).
 		Watches(&corev1.Secret{}, connectorFilter).
+		Watches(&corev1.Pod{}, connectorFilter).
 		Watches(&tsapi.ProxyClass{}, proxyClassFilterForConnector).
 		Complete(&ConnectorReconciler{
-			ssr:      ssr,
-			recorder: eventRecorder,
-			Client:   mgr.GetClient(),
-			logger:   zlog.Named("connector-reconciler"),
-			clock:    tstime.DefaultClock{},
+			ssr:               ssr,
+			recorder:          eventRecorder,
+			Client:            mgr.GetClient(),
+			logger:            zlog.Named("connector-reconciler"),
+			clock:             tstime.DefaultClock{},
+			autoApproveRoutes: autoApproveRoutes,
 		})
 	if err != nil {
 		startlog.Fatal("could not create connector reconciler: %v", err)
 	}
+	podGroupFilter := handler.EnqueueRequestsFromMapFunc(managedResourceHandlerForType("podgroup"))
+	// If a ProxyClassChanges, enqueue all PodGroups that have
+	// .spec.proxyClass set to the name of this ProxyClass.
+	proxyClassFilterForPodGroup := handler.EnqueueRequestsFromMapFunc(proxyClassHandlerForPodGroup(mgr.GetClient(), startlog))
+	// If a StatefulSet Pod changes, enqueue all PodGroups that expose its
+	// StatefulSet.
+	podFilterForPodGroup := handler.EnqueueRequestsFromMapFunc(podHandlerForPodGroup(mgr.GetClient(), startlog))
+	err = builder.ControllerManagedBy(mgr).
+		For(&tsapi.PodGroup{}).
+		Watches(&appsv1.StatefulSet{}, podGroupFilter).
+		Watches(&corev1.Secret{}, podGroupFilter).
+		Watches(&corev1.Pod{}, podFilterForPodGroup).
+		Watches(&tsapi.ProxyClass{}, proxyClassFilterForPodGroup).
+		Complete(&PodGroupReconciler{
+			ssr:       ssr,
+			recorder:  eventRecorder,
+			Client:    mgr.GetClient(),
+			apiReader: mgr.GetAPIReader(),
+			logger:    zlog.Named("podgroup-reconciler"),
+			clock:     tstime.DefaultClock{},
+		})
+	if err != nil {
+		startlog.Fatal("could not create podgroup reconciler: %v", err)
+	}
 	err = builder.ControllerManagedBy(mgr).
 		For(&tsapi.ProxyClass{}).
 		Complete(&ProxyClassReconciler{
@@ -316,6 +385,57 @@ func runReconcilers(zlog *zap.SugaredLogger, s *tsnet.Server, tsNamespace string
 	if err != nil {
 		startlog.Fatal("could not create proxyclass reconciler: %v", err)
 	}
+	if migrateLegacyConfig {
+		err = builder.ControllerManagedBy(mgr).
+			Named("migration-reconciler").
+			For(&corev1.Service{}, builder.WithPredicates(predicate.NewPredicateFuncs(hasLegacyServiceConfig))).
+			Complete(&MigrationReconciler{
+				Client:   mgr.GetClient(),
+				recorder: eventRecorder,
+				logger:   zlog.Named("migration-reconciler"),
+			})
+		if err != nil {
+			startlog.Fatalf("could not create migration reconciler: %v", err)
+		}
+	}
+	// The manager's cache only has the StatefulSets in the operator's
+	// namespace, so the workloads labeled to be exposed are watched via a
+	// separate cache that only has those.
+	workloadCache, err := cache.New(restConfig, cache.Options{
+		HTTPClient:           mgr.GetHTTPClient(),
+		Scheme:               mgr.GetScheme(),
+		Mapper:               mgr.GetRESTMapper(),
+		DefaultLabelSelector: labels.SelectorFromSet(labels.Set{LabelExpose: "true"}),
+	})
+	if err != nil {
+		startlog.Fatalf("could not create workload cache: %v", err)
+	}
+	if err := mgr.Add(workloadCache); err != nil {
+		startlog.Fatalf("could not add workload cache: %v", err)
+	}
+	for _, w := range []struct {
+		kind string
+		obj  client.Object
+	}{
+		{"Deployment", &appsv1.Deployment{}},
+		{"StatefulSet", &appsv1.StatefulSet{}},
+	} {
+		name := strings.ToLower(w.kind) + "-reconciler"
+		err = builder.ControllerManagedBy(mgr).
+			Named(name).
+			WatchesRawSource(source.Kind(workloadCache, w.obj), &handler.EnqueueRequestForObject{}).
+			Watches(&corev1.Service{}, handler.EnqueueRequestForOwner(mgr.GetScheme(), mgr.GetRESTMapper(), w.obj, handler.OnlyControllerOwner())).
+			Complete(&WorkloadReconciler{
+				Client:    mgr.GetClient(),
+				workloads: workloadCache,
+				kind:      w.kind,
+				recorder:  eventRecorder,
+				logger:    zlog.Named(name),
+			})
+		if err != nil {
+			startlog.Fatalf("could not create %s: %v", name, err)
+		}
+	}
 	startlog.Infof("Startup complete, operator running, version: %s", version.Long())
 	if err := mgr.Start(signals.SetupSignalHandler()); err != nil {
 		startlog.Fatalf("could not start manager: %v", err)
@@ -325,6 +445,8 @@ func runReconcilers(zlog *zap.SugaredLogger, s *tsnet.Server, tsNamespace string
 type tsClient interface {
 	CreateKey(ctx context.Context, caps tailscale.KeyCapabilities) (string, *tailscale.Key, error)
 	DeleteDevice(ctx context.Context, nodeStableID string) error
+	Routes(ctx context.Context, deviceID string) (*tailscale.Routes, error)
+	SetRoutes(ctx context.Context, deviceID string, subnets []netip.Prefix) (*tailscale.Routes, error)
 }
 
 func isManagedResource(o client.Object) bool {
@@ -419,6 +541,51 @@ func proxyClassHandlerForConnector(cl client.Client, logger *zap.SugaredLogger)
 	}
 }
 
+// proxyClassHandlerForPodGroup returns a handler that, for a given ProxyClass,
+// returns a list of reconcile requests for all PodGroups that have
+// .spec.proxyClass set.
+func proxyClassHandlerForPodGroup(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		pgList := new(tsapi.PodGroupList)
+		if err := cl.List(ctx, pgList); err != nil {
+			logger.Debugf("error listing PodGroups for ProxyClass: %v", err)
+			return nil
+		}
+		reqs := make([]reconcile.Request, 0)
+		proxyClassName := o.GetName()
+		for _, pg := range pgList.Items {
+			if pg.Spec.ProxyClass == proxyClassName {
+				reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&pg)})
+			}
+		}
+		return reqs
+	}
+}
+
+// podHandlerForPodGroup returns a handler that, for a given Pod that belongs
+// to a StatefulSet, returns a list of reconcile requests for all PodGroups in
+// the Pod's namespace that have .spec.statefulSet set to that StatefulSet.
+func podHandlerForPodGroup(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		owner := metav1.GetControllerOf(o)
+		if owner == nil || owner.Kind != "StatefulSet" {
+			return nil
+		}
+		pgList := new(tsapi.PodGroupList)
+		if err := cl.List(ctx, pgList, client.InNamespace(o.GetNamespace())); err != nil {
+			logger.Debugf("error listing PodGroups for Pod: %v", err)
+			return nil
+		}
+		reqs := make([]reconcile.Request, 0)
+		for _, pg := range pgList.Items {
+			if pg.Spec.StatefulSet == owner.Name {
+				reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&pg)})
+			}
+		}
+		return reqs
+	}
+}
+
 func serviceHandler(_ context.Context, o client.Object) []reconcile.Request {
 	if isManagedByType(o, "svc") {
 		// If this is a Service managed by a Service we want to enqueue its parent
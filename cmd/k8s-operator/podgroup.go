@@ -0,0 +1,330 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	xslices "golang.org/x/exp/slices"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	tsoperator "tailscale.com/k8s-operator"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/tstime"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/set"
+)
+
+const (
+	reasonPodGroupCreationFailed = "PodGroupCreationFailed"
+	reasonPodGroupCreated        = "PodGroupCreated"
+	reasonPodGroupInvalid        = "PodGroupInvalid"
+
+	messagePodGroupCreationFailed = "Failed creating PodGroup: %v"
+	messagePodGroupInvalid        = "PodGroup is invalid: %v"
+)
+
+// PodGroupReconciler reconciles PodGroup custom resources. For each Pod of
+// the StatefulSet referenced by a PodGroup it provisions a dedicated
+// Tailscale proxy that forwards all tailnet traffic to that Pod's IP.
+type PodGroupReconciler struct {
+	client.Client
+	// apiReader is used to read the StatefulSets referenced by PodGroups.
+	// The manager's cache only holds StatefulSets in the operator's own
+	// namespace, so these must be read directly from the API server.
+	apiReader client.Reader
+
+	recorder record.EventRecorder
+	ssr      *tailscaleSTSReconciler
+	logger   *zap.SugaredLogger
+
+	clock tstime.Clock
+
+	mu sync.Mutex // protects following
+
+	podGroups set.Slice[types.UID] // for podgroups gauge
+}
+
+var (
+	// gaugePodGroupResources tracks the number of PodGroups currently managed by this operator instance.
+	gaugePodGroupResources = clientmetric.NewGauge("k8s_podgroup_resources")
+)
+
+func (a *PodGroupReconciler) Reconcile(ctx context.Context, req reconcile.Request) (res reconcile.Result, err error) {
+	logger := a.logger.With("podgroup-ns", req.Namespace, "podgroup-name", req.Name)
+	logger.Debugf("starting reconcile")
+	defer logger.Debugf("reconcile finished")
+
+	pg := new(tsapi.PodGroup)
+	err = a.Get(ctx, req.NamespacedName, pg)
+	if apierrors.IsNotFound(err) {
+		logger.Debugf("PodGroup not found, assuming it was deleted")
+		return reconcile.Result{}, nil
+	} else if err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to get tailscale.com PodGroup: %w", err)
+	}
+	if !pg.DeletionTimestamp.IsZero() {
+		logger.Debugf("PodGroup is being deleted, cleaning up resources")
+		ix := xslices.Index(pg.Finalizers, FinalizerName)
+		if ix < 0 {
+			logger.Debugf("no finalizer, nothing to do")
+			return reconcile.Result{}, nil
+		}
+
+		if done, err := a.maybeCleanupPodGroup(ctx, logger, pg, nil); err != nil {
+			return reconcile.Result{}, err
+		} else if !done {
+			logger.Debugf("PodGroup resource cleanup not yet finished, will retry...")
+			return reconcile.Result{RequeueAfter: shortRequeue}, nil
+		}
+
+		pg.Finalizers = append(pg.Finalizers[:ix], pg.Finalizers[ix+1:]...)
+		if err := a.Update(ctx, pg); err != nil {
+			return reconcile.Result{}, err
+		}
+		a.mu.Lock()
+		a.podGroups.Remove(pg.UID)
+		a.mu.Unlock()
+		gaugePodGroupResources.Set(int64(a.podGroups.Len()))
+		logger.Infof("PodGroup resources cleaned up")
+		return reconcile.Result{}, nil
+	}
+
+	oldStatus := pg.Status.DeepCopy()
+	setStatus := func(pg *tsapi.PodGroup, status metav1.ConditionStatus, reason, message string) (reconcile.Result, error) {
+		tsoperator.SetPodGroupCondition(pg, tsapi.PodGroupReady, status, reason, message, pg.Generation, a.clock, logger)
+		if !apiequality.Semantic.DeepEqual(oldStatus, pg.Status) {
+			// An error encountered here should get returned by the Reconcile function.
+			if updateErr := a.Client.Status().Update(ctx, pg); updateErr != nil {
+				err = errors.Wrap(err, updateErr.Error())
+			}
+		}
+		return res, err
+	}
+
+	if !slices.Contains(pg.Finalizers, FinalizerName) {
+		// This log line is printed exactly once during initial provisioning,
+		// because once the finalizer is in place this block gets skipped. So,
+		// this is a nice place to tell the operator that the high level,
+		// multi-reconcile operation is underway.
+		logger.Infof("ensuring PodGroup is set up")
+		pg.Finalizers = append(pg.Finalizers, FinalizerName)
+		if err := a.Update(ctx, pg); err != nil {
+			logger.Errorf("error adding finalizer: %v", err)
+			return setStatus(pg, metav1.ConditionFalse, reasonPodGroupCreationFailed, reasonPodGroupCreationFailed)
+		}
+	}
+
+	if err := validatePodGroup(pg); err != nil {
+		logger.Errorf("error validating PodGroup spec: %v", err)
+		message := fmt.Sprintf(messagePodGroupInvalid, err)
+		a.recorder.Eventf(pg, corev1.EventTypeWarning, reasonPodGroupInvalid, message)
+		return setStatus(pg, metav1.ConditionFalse, reasonPodGroupInvalid, message)
+	}
+
+	a.mu.Lock()
+	a.podGroups.Add(pg.UID)
+	a.mu.Unlock()
+	gaugePodGroupResources.Set(int64(a.podGroups.Len()))
+
+	pods, err := a.maybeProvisionPodGroup(ctx, logger, pg)
+	if err != nil {
+		logger.Errorf("error creating PodGroup resources: %v", err)
+		message := fmt.Sprintf(messagePodGroupCreationFailed, err)
+		a.recorder.Eventf(pg, corev1.EventTypeWarning, reasonPodGroupCreationFailed, message)
+		return setStatus(pg, metav1.ConditionFalse, reasonPodGroupCreationFailed, message)
+	}
+
+	logger.Info("PodGroup resources synced")
+	pg.Status.Pods = pods
+	return setStatus(pg, metav1.ConditionTrue, reasonPodGroupCreated, reasonPodGroupCreated)
+}
+
+// maybeProvisionPodGroup ensures that there is a Tailscale proxy for each
+// running Pod of the PodGroup's StatefulSet and that proxies for ordinals that
+// the StatefulSet no longer has (i.e because it was scaled down) are cleaned
+// up. It returns the Pods that are currently exposed.
+func (a *PodGroupReconciler) maybeProvisionPodGroup(ctx context.Context, logger *zap.SugaredLogger, pg *tsapi.PodGroup) ([]tsapi.PodGroupPod, error) {
+	proxyClass := pg.Spec.ProxyClass
+	if proxyClass != "" {
+		if ready, err := proxyClassIsReady(ctx, proxyClass, a.Client); err != nil {
+			return nil, fmt.Errorf("error verifying ProxyClass for PodGroup: %w", err)
+		} else if !ready {
+			logger.Infof("ProxyClass %s specified for the PodGroup, but is not (yet) Ready, waiting..", proxyClass)
+			return pg.Status.Pods, nil
+		}
+	}
+
+	ss := new(appsv1.StatefulSet)
+	if err := a.apiReader.Get(ctx, types.NamespacedName{Namespace: pg.Namespace, Name: pg.Spec.StatefulSet}, ss); apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("StatefulSet %s/%s not found", pg.Namespace, pg.Spec.StatefulSet)
+	} else if err != nil {
+		return nil, fmt.Errorf("error getting StatefulSet %s/%s: %w", pg.Namespace, pg.Spec.StatefulSet, err)
+	}
+	// Proxies are kept for all ordinals that the StatefulSet is meant to
+	// have, even if the Pod does not currently exist, so that a Pod restart
+	// does not result in its Tailscale node being deleted.
+	ordinals := make(set.Set[int])
+	start, replicas := 0, 1
+	if ss.Spec.Ordinals != nil {
+		start = int(ss.Spec.Ordinals.Start)
+	}
+	if ss.Spec.Replicas != nil {
+		replicas = int(*ss.Spec.Replicas)
+	}
+	for i := start; i < start+replicas; i++ {
+		ordinals.Add(i)
+	}
+
+	podList := new(corev1.PodList)
+	if err := a.List(ctx, podList, client.InNamespace(pg.Namespace)); err != nil {
+		return nil, fmt.Errorf("error listing Pods: %w", err)
+	}
+	var exposed []tsapi.PodGroupPod
+	for _, pod := range podList.Items {
+		ordinal, ok := statefulSetPodOrdinal(&pod, ss.Name)
+		if !ok || !ordinals.Contains(ordinal) {
+			continue
+		}
+		if pod.Status.PodIP == "" {
+			// The Pod is not yet scheduled. We'll get another reconcile
+			// once its IP is known.
+			logger.Debugf("Pod %s has no IP address yet, waiting", pod.Name)
+			continue
+		}
+		hostname := podGroupHostname(pg, ordinal)
+		sts := &tailscaleSTSConfig{
+			ParentResourceName:  pod.Name,
+			ParentResourceUID:   fmt.Sprintf("%s-%d", pg.UID, ordinal),
+			Hostname:            hostname,
+			ChildResourceLabels: podGroupChildResourceLabels(pg, ordinal),
+			Tags:                pg.Spec.Tags.Stringify(),
+			ClusterTargetIP:     pod.Status.PodIP,
+			ProxyClass:          proxyClass,
+		}
+		if _, err := a.ssr.Provision(ctx, logger, sts); err != nil {
+			return nil, fmt.Errorf("error provisioning proxy for Pod %s: %w", pod.Name, err)
+		}
+		_, _, ips, err := a.ssr.DeviceInfo(ctx, sts.ChildResourceLabels)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving device info for Pod %s: %w", pod.Name, err)
+		}
+		exposed = append(exposed, tsapi.PodGroupPod{
+			Name:       pod.Name,
+			Hostname:   hostname,
+			TailnetIPs: ips,
+		})
+	}
+	slices.SortFunc(exposed, func(a, b tsapi.PodGroupPod) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	if _, err := a.maybeCleanupPodGroup(ctx, logger, pg, ordinals); err != nil {
+		return nil, err
+	}
+	return exposed, nil
+}
+
+// maybeCleanupPodGroup removes the proxies created for the PodGroup, apart
+// from those for the Pods whose ordinals are in keep. It returns true when all
+// resources have been removed, otherwise it returns false and the caller
+// should retry later.
+func (a *PodGroupReconciler) maybeCleanupPodGroup(ctx context.Context, logger *zap.SugaredLogger, pg *tsapi.PodGroup, keep set.Set[int]) (bool, error) {
+	// The proxy Secret is the last resource to be deleted by Cleanup, so
+	// list Secrets rather than StatefulSets to not lose track of proxies
+	// whose cleanup is still in progress.
+	secrets := new(corev1.SecretList)
+	if err := a.List(ctx, secrets, client.InNamespace(a.ssr.operatorNamespace), client.MatchingLabels(childResourceLabels(pg.Name, pg.Namespace, "podgroup"))); err != nil {
+		return false, fmt.Errorf("error listing PodGroup proxies: %w", err)
+	}
+	done := true
+	for _, sec := range secrets.Items {
+		ordinal, err := strconv.Atoi(sec.Labels[LabelPodOrdinal])
+		if err != nil {
+			logger.Infof("Secret %s has an invalid %s label, ignoring", sec.Name, LabelPodOrdinal)
+			continue
+		}
+		if keep.Contains(ordinal) {
+			continue
+		}
+		ok, err := a.ssr.Cleanup(ctx, logger, podGroupChildResourceLabels(pg, ordinal))
+		if err != nil {
+			return false, fmt.Errorf("failed to clean up proxy for Pod ordinal %d: %w", ordinal, err)
+		}
+		done = done && ok
+	}
+	if !done {
+		return false, nil
+	}
+	if keep == nil {
+		// Unlike most log entries in the reconcile loop, this will get
+		// printed exactly once at the very end of cleanup, because the
+		// final step of cleanup removes the tailscale finalizer, which will
+		// make all future reconciles exit early.
+		logger.Infof("cleaned up PodGroup resources")
+	}
+	return true, nil
+}
+
+func validatePodGroup(pg *tsapi.PodGroup) error {
+	// PodGroup fields are already validated at apply time with OpenAPI
+	// validation on custom resource fields. The checks here are a backup in
+	// case the validation breaks without us noticing.
+	if pg.Spec.StatefulSet == "" {
+		return errors.New("invalid spec: .spec.statefulSet must be set")
+	}
+	return nil
+}
+
+// statefulSetPodOrdinal reports whether pod belongs to the StatefulSet with
+// the given name and, if so, returns its ordinal.
+func statefulSetPodOrdinal(pod *corev1.Pod, stsName string) (int, bool) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || owner.Kind != "StatefulSet" || owner.Name != stsName {
+		return 0, false
+	}
+	suffix, ok := strings.CutPrefix(pod.Name, stsName+"-")
+	if !ok {
+		return 0, false
+	}
+	ordinal, err := strconv.Atoi(suffix)
+	if err != nil || ordinal < 0 {
+		return 0, false
+	}
+	return ordinal, true
+}
+
+// podGroupHostname returns the tailnet hostname for the PodGroup's Pod with
+// the given ordinal.
+func podGroupHostname(pg *tsapi.PodGroup, ordinal int) string {
+	prefix := pg.Spec.HostnamePrefix
+	if prefix == "" {
+		prefix = pg.Spec.StatefulSet
+	}
+	return fmt.Sprintf("%s-%d", prefix, ordinal)
+}
+
+// podGroupChildResourceLabels returns labels for the resources created to
+// expose the PodGroup's Pod with the given ordinal.
+func podGroupChildResourceLabels(pg *tsapi.PodGroup, ordinal int) map[string]string {
+	crl := childResourceLabels(pg.Name, pg.Namespace, "podgroup")
+	crl[LabelPodOrdinal] = strconv.Itoa(ordinal)
+	return crl
+}
@@ -0,0 +1,194 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/tstest"
+	"tailscale.com/types/ptr"
+)
+
+func TestPodGroup(t *testing.T) {
+	ss := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "db",
+			Namespace: "default",
+			UID:       types.UID("db-UID"),
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: ptr.To[int32](2),
+		},
+	}
+	pg := &tsapi.PodGroup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+			UID:       types.UID("1234-UID"),
+		},
+		TypeMeta: metav1.TypeMeta{
+			Kind:       tsapi.PodGroupKind,
+			APIVersion: "tailscale.com/v1alpha1",
+		},
+		Spec: tsapi.PodGroupSpec{
+			StatefulSet:    "db",
+			HostnamePrefix: "postgres",
+		},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(ss, pg, statefulSetPod(ss, 0, "10.0.0.1"), statefulSetPod(ss, 1, "10.0.0.2")).
+		WithStatusSubresource(pg).
+		Build()
+	ft := &fakeTSClient{}
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr := &PodGroupReconciler{
+		Client:    fc,
+		apiReader: fc,
+		ssr: &tailscaleSTSReconciler{
+			Client:            fc,
+			tsClient:          ft,
+			defaultTags:       []string{"tag:k8s"},
+			operatorNamespace: "operator-ns",
+			proxyImage:        "tailscale/tailscale",
+		},
+		clock:  tstest.NewClock(tstest.ClockOpts{}),
+		logger: zl.Sugar(),
+	}
+
+	expectReconciled(t, pr, "default", "test")
+	expectPodGroupProxy(t, fc, pg, 0, "postgres-0", "10.0.0.1")
+	expectPodGroupProxy(t, fc, pg, 1, "postgres-1", "10.0.0.2")
+	expectPodGroupPods(t, fc, []tsapi.PodGroupPod{
+		{Name: "db-0", Hostname: "postgres-0"},
+		{Name: "db-1", Hostname: "postgres-1"},
+	})
+
+	// Pod IP changes get propagated to the proxy.
+	mustUpdateStatus[corev1.Pod](t, fc, "default", "db-1", func(p *corev1.Pod) {
+		p.Status.PodIP = "10.0.0.3"
+	})
+	expectReconciled(t, pr, "default", "test")
+	expectPodGroupProxy(t, fc, pg, 1, "postgres-1", "10.0.0.3")
+
+	// A Pod that is temporarily missing, i.e because it is being
+	// restarted, keeps its proxy.
+	if err := fc.Delete(context.Background(), &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db-1", Namespace: "default"}}); err != nil {
+		t.Fatalf("deleting Pod: %v", err)
+	}
+	expectReconciled(t, pr, "default", "test")
+	expectPodGroupProxy(t, fc, pg, 1, "postgres-1", "10.0.0.3")
+	expectPodGroupPods(t, fc, []tsapi.PodGroupPod{
+		{Name: "db-0", Hostname: "postgres-0"},
+	})
+
+	// Scaling the StatefulSet down removes the proxy for the removed
+	// ordinal.
+	mustUpdate[appsv1.StatefulSet](t, fc, "default", "db", func(s *appsv1.StatefulSet) {
+		s.Spec.Replicas = ptr.To[int32](1)
+	})
+	expectReconciled(t, pr, "default", "test") // deletes the StatefulSet
+	expectReconciled(t, pr, "default", "test") // deletes the Secret and Service
+	expectPodGroupProxy(t, fc, pg, 0, "postgres-0", "10.0.0.1")
+	if sec, err := getSingleObject[corev1.Secret](context.Background(), fc, "operator-ns", podGroupChildResourceLabels(pg, 1)); err != nil {
+		t.Fatal(err)
+	} else if sec != nil {
+		t.Fatalf("proxy Secret for ordinal 1 still exists after scale down")
+	}
+
+	// Delete the PodGroup.
+	if err = fc.Delete(context.Background(), pg); err != nil {
+		t.Fatalf("error deleting PodGroup: %v", err)
+	}
+	expectRequeue(t, pr, "default", "test")
+	expectReconciled(t, pr, "default", "test")
+	if sec, err := getSingleObject[corev1.Secret](context.Background(), fc, "operator-ns", podGroupChildResourceLabels(pg, 0)); err != nil {
+		t.Fatal(err)
+	} else if sec != nil {
+		t.Fatalf("proxy Secret for ordinal 0 still exists after PodGroup deletion")
+	}
+}
+
+func TestStatefulSetPodOrdinal(t *testing.T) {
+	ss := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default", UID: "db-UID"}}
+	other := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "db-replica", Namespace: "default", UID: "db-replica-UID"}}
+	tests := []struct {
+		name        string
+		pod         *corev1.Pod
+		wantOrdinal int
+		wantOK      bool
+	}{
+		{"first", statefulSetPod(ss, 0, ""), 0, true},
+		{"tenth", statefulSetPod(ss, 10, ""), 10, true},
+		{"other_statefulset", statefulSetPod(other, 1, ""), 0, false},
+		{"no_owner", &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db-1"}}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotOrdinal, gotOK := statefulSetPodOrdinal(tt.pod, "db")
+			if gotOrdinal != tt.wantOrdinal || gotOK != tt.wantOK {
+				t.Errorf("statefulSetPodOrdinal = (%d, %v), want (%d, %v)", gotOrdinal, gotOK, tt.wantOrdinal, tt.wantOK)
+			}
+		})
+	}
+}
+
+func statefulSetPod(ss *appsv1.StatefulSet, ordinal int, ip string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            fmt.Sprintf("%s-%d", ss.Name, ordinal),
+			Namespace:       ss.Namespace,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(ss, appsv1.SchemeGroupVersion.WithKind("StatefulSet"))},
+		},
+		Status: corev1.PodStatus{PodIP: ip},
+	}
+}
+
+func expectPodGroupProxy(t *testing.T, cl client.Client, pg *tsapi.PodGroup, ordinal int, wantHostname, wantIP string) {
+	t.Helper()
+	sts, err := getSingleObject[appsv1.StatefulSet](context.Background(), cl, "operator-ns", podGroupChildResourceLabels(pg, ordinal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sts == nil {
+		t.Fatalf("no proxy StatefulSet found for ordinal %d", ordinal)
+	}
+	env := make(map[string]string)
+	for _, e := range sts.Spec.Template.Spec.Containers[0].Env {
+		env[e.Name] = e.Value
+	}
+	if env["TS_HOSTNAME"] != wantHostname {
+		t.Errorf("ordinal %d: TS_HOSTNAME = %q, want %q", ordinal, env["TS_HOSTNAME"], wantHostname)
+	}
+	if env["TS_DEST_IP"] != wantIP {
+		t.Errorf("ordinal %d: TS_DEST_IP = %q, want %q", ordinal, env["TS_DEST_IP"], wantIP)
+	}
+}
+
+func expectPodGroupPods(t *testing.T, cl client.Client, want []tsapi.PodGroupPod) {
+	t.Helper()
+	pg := new(tsapi.PodGroup)
+	if err := cl.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "test"}, pg); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(pg.Status.Pods, want); diff != "" {
+		t.Errorf("unexpected PodGroup status Pods (-got +want):\n%s", diff)
+	}
+}
@@ -99,6 +99,22 @@ func (a *ProxyClassReconciler) validate(pc *tsapi.ProxyClass) (violations field.
 				}
 			}
 		}
+		if pdb := sts.PodDisruptionBudget; pdb != nil && pdb.MinAvailable != nil && pdb.MaxUnavailable != nil {
+			violations = append(violations, field.Forbidden(field.NewPath(".spec.statefulSet.podDisruptionBudget"), "minAvailable and maxUnavailable cannot both be set"))
+		}
+		if as := sts.Autoscaling; as != nil {
+			if as.MinReplicas != nil && *as.MinReplicas > as.MaxReplicas {
+				violations = append(violations, field.Invalid(field.NewPath(".spec.statefulSet.autoscaling.maxReplicas"), as.MaxReplicas, "must not be less than minReplicas"))
+			}
+			// The autoscaler can't compute the CPU utilization of
+			// containers without a CPU request.
+			if sts.Pod == nil || sts.Pod.TailscaleContainer == nil || sts.Pod.TailscaleContainer.Resources.Requests.Cpu().IsZero() {
+				violations = append(violations, field.Required(field.NewPath(".spec.statefulSet.pod.tailscaleContainer.resources.requests.cpu"), "a CPU request is required for autoscaling"))
+			}
+		}
+	}
+	if err := validateHostnameTemplate(pc.Spec.HostnameTemplate); err != nil {
+		violations = append(violations, field.Invalid(field.NewPath(".spec.hostnameTemplate"), pc.Spec.HostnameTemplate, err.Error()))
 	}
 	// We do not validate embedded fields (security context, resource
 	// requirements etc) as we inherit upstream validation for those fields.
@@ -0,0 +1,258 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/ptr"
+)
+
+// defaultTargetCPUUtilization is the average CPU utilization, as a
+// percentage of the CPU request, that the HorizontalPodAutoscaler of an
+// autoscaled proxy aims for, unless its ProxyClass sets another.
+const defaultTargetCPUUtilization = 80
+
+// proxyReplicas returns the number of replicas that the StatefulSet of a
+// proxy that uses the ProxyClass pc, which may be nil, is created with, and
+// the maximum number of replicas that it can have. They differ if the proxy
+// is autoscaled.
+func proxyReplicas(pc *tsapi.ProxyClass) (replicas, maxReplicas int32) {
+	if pc == nil || pc.Spec.StatefulSet == nil {
+		return 1, 1
+	}
+	if as := pc.Spec.StatefulSet.Autoscaling; as != nil {
+		replicas = 1
+		if as.MinReplicas != nil {
+			replicas = *as.MinReplicas
+		}
+		return replicas, max(replicas, as.MaxReplicas)
+	}
+	if r := pc.Spec.StatefulSet.Replicas; r != nil && *r > 1 {
+		return *r, *r
+	}
+	return 1, 1
+}
+
+// reconcileReplicas makes sure that each replica of the proxy StatefulSet
+// ss has a state Secret, removes those of the replicas that ss can no longer
+// be scaled to, and creates, updates or deletes the PodDisruptionBudget and
+// HorizontalPodAutoscaler of the proxy, as its ProxyClass asks.
+//
+// The first replica's Secret is created by Provision before ss. The others'
+// are created once ss is scaled up, so that replicas that are never run
+// don't use up auth keys. They're kept when ss is scaled down, so that the
+// replicas keep their Tailscale nodes when it's scaled up again.
+func (a *tailscaleSTSReconciler) reconcileReplicas(ctx context.Context, logger *zap.SugaredLogger, stsC *tailscaleSTSConfig, hsvc *corev1.Service, ss *appsv1.StatefulSet) error {
+	var pc *tsapi.ProxyClass
+	if stsC.ProxyClass != "" {
+		pc = new(tsapi.ProxyClass)
+		if err := a.Get(ctx, types.NamespacedName{Name: stsC.ProxyClass}, pc); err != nil {
+			return fmt.Errorf("failed to get ProxyClass: %w", err)
+		}
+	}
+	_, maxReplicas := proxyReplicas(pc)
+	replicas := int32(1)
+	if ss.Spec.Replicas != nil {
+		replicas = *ss.Spec.Replicas
+	}
+	for i := 1; i < int(min(replicas, maxReplicas)); i++ {
+		if _, _, err := a.createOrGetSecret(ctx, logger, stsC, hsvc, i); err != nil {
+			return fmt.Errorf("failed to create or get state Secret of replica %d: %w", i, err)
+		}
+	}
+	if err := a.cleanupReplicas(ctx, logger, stsC.ChildResourceLabels, int(maxReplicas)); err != nil {
+		return err
+	}
+	if err := a.reconcilePDB(ctx, logger, stsC, ss, pc); err != nil {
+		return fmt.Errorf("failed to reconcile PodDisruptionBudget: %w", err)
+	}
+	if err := a.reconcileHPA(ctx, logger, stsC, ss, pc); err != nil {
+		return fmt.Errorf("failed to reconcile HorizontalPodAutoscaler: %w", err)
+	}
+	return nil
+}
+
+// cleanupReplicas deletes the state Secrets and Tailscale devices of the
+// replicas of the proxy with the given labels whose ordinals are keep or
+// more. Replicas whose Pods still exist are skipped, as their Pods would
+// recreate their Secrets; a later reconcile cleans them up.
+func (a *tailscaleSTSReconciler) cleanupReplicas(ctx context.Context, logger *zap.SugaredLogger, labels map[string]string, keep int) error {
+	secrets := new(corev1.SecretList)
+	if err := a.List(ctx, secrets, client.InNamespace(a.operatorNamespace), client.MatchingLabels(labels)); err != nil {
+		return fmt.Errorf("error listing proxy Secrets: %w", err)
+	}
+	for i := range secrets.Items {
+		sec := &secrets.Items[i]
+		ordinal, err := strconv.Atoi(sec.Labels[LabelProxyReplica])
+		if err != nil || ordinal < keep {
+			continue
+		}
+		// The Secret is named after the replica's Pod.
+		err = a.Get(ctx, types.NamespacedName{Namespace: a.operatorNamespace, Name: sec.Name}, new(corev1.Pod))
+		if err == nil {
+			logger.Debugf("waiting for Pod %s to be deleted before cleaning up its replica", sec.Name)
+			continue
+		}
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("error getting Pod %s: %w", sec.Name, err)
+		}
+		if err := a.deleteDevice(ctx, logger, tailcfg.StableNodeID(sec.Data["device_id"])); err != nil {
+			return err
+		}
+		logger.Debugf("deleting state Secret %s of proxy replica %d", sec.Name, ordinal)
+		if err := a.Delete(ctx, sec); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("error deleting Secret %s: %w", sec.Name, err)
+		}
+	}
+	return nil
+}
+
+// reconcilePDB creates or updates the PodDisruptionBudget of the proxy
+// StatefulSet ss if the ProxyClass pc, which may be nil, asks for one, and
+// deletes it otherwise.
+func (a *tailscaleSTSReconciler) reconcilePDB(ctx context.Context, logger *zap.SugaredLogger, stsC *tailscaleSTSConfig, ss *appsv1.StatefulSet, pc *tsapi.ProxyClass) error {
+	pdb := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ss.Name,
+			Namespace: a.operatorNamespace,
+			Labels:    stsC.ChildResourceLabels,
+		},
+	}
+	if pc == nil || pc.Spec.StatefulSet == nil || pc.Spec.StatefulSet.PodDisruptionBudget == nil {
+		return deleteIfExists(ctx, a.Client, pdb)
+	}
+	want := pc.Spec.StatefulSet.PodDisruptionBudget.DeepCopy()
+	pdb.Spec = policyv1.PodDisruptionBudgetSpec{
+		MinAvailable:   want.MinAvailable,
+		MaxUnavailable: want.MaxUnavailable,
+		Selector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{
+				"app": stsC.ParentResourceUID,
+			},
+		},
+	}
+	if pdb.Spec.MinAvailable == nil && pdb.Spec.MaxUnavailable == nil {
+		pdb.Spec.MaxUnavailable = ptr.To(intstr.FromInt32(1))
+	}
+	logger.Debugf("reconciling PodDisruptionBudget %s/%s", pdb.Namespace, pdb.Name)
+	_, err := createOrUpdate(ctx, a.Client, a.operatorNamespace, pdb, func(p *policyv1.PodDisruptionBudget) {
+		p.Labels = pdb.Labels
+		p.Spec = pdb.Spec
+	})
+	return err
+}
+
+// reconcileHPA creates or updates the HorizontalPodAutoscaler of the proxy
+// StatefulSet ss if the ProxyClass pc, which may be nil, asks for
+// autoscaling, and deletes it otherwise.
+func (a *tailscaleSTSReconciler) reconcileHPA(ctx context.Context, logger *zap.SugaredLogger, stsC *tailscaleSTSConfig, ss *appsv1.StatefulSet, pc *tsapi.ProxyClass) error {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ss.Name,
+			Namespace: a.operatorNamespace,
+			Labels:    stsC.ChildResourceLabels,
+		},
+	}
+	if pc == nil || pc.Spec.StatefulSet == nil || pc.Spec.StatefulSet.Autoscaling == nil {
+		return deleteIfExists(ctx, a.Client, hpa)
+	}
+	minReplicas, maxReplicas := proxyReplicas(pc)
+	target := int32(defaultTargetCPUUtilization)
+	if t := pc.Spec.StatefulSet.Autoscaling.TargetCPUUtilizationPercentage; t != nil {
+		target = *t
+	}
+	hpa.Spec = autoscalingv2.HorizontalPodAutoscalerSpec{
+		ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+			APIVersion: "apps/v1",
+			Kind:       "StatefulSet",
+			Name:       ss.Name,
+		},
+		MinReplicas: ptr.To(minReplicas),
+		MaxReplicas: maxReplicas,
+		Metrics: []autoscalingv2.MetricSpec{{
+			Type: autoscalingv2.ResourceMetricSourceType,
+			Resource: &autoscalingv2.ResourceMetricSource{
+				Name: corev1.ResourceCPU,
+				Target: autoscalingv2.MetricTarget{
+					Type:               autoscalingv2.UtilizationMetricType,
+					AverageUtilization: ptr.To(target),
+				},
+			},
+		}},
+	}
+	logger.Debugf("reconciling HorizontalPodAutoscaler %s/%s", hpa.Namespace, hpa.Name)
+	_, err := createOrUpdate(ctx, a.Client, a.operatorNamespace, hpa, func(h *autoscalingv2.HorizontalPodAutoscaler) {
+		h.Labels = hpa.Labels
+		h.Spec = hpa.Spec
+	})
+	return err
+}
+
+// deleteIfExists deletes obj, which is looked up by its name and namespace,
+// if it exists.
+func deleteIfExists(ctx context.Context, c client.Client, obj client.Object) error {
+	if err := c.Get(ctx, client.ObjectKeyFromObject(obj), obj); apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := c.Delete(ctx, obj); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// getProxySecret returns the state Secret of the first replica of the proxy
+// with the given labels, or nil if there isn't one.
+func getProxySecret(ctx context.Context, c client.Client, ns string, labels map[string]string) (*corev1.Secret, error) {
+	secrets := new(corev1.SecretList)
+	if err := c.List(ctx, secrets, client.InNamespace(ns), client.MatchingLabels(labels)); err != nil {
+		return nil, err
+	}
+	var ret *corev1.Secret
+	for i := range secrets.Items {
+		if _, ok := secrets.Items[i].Labels[LabelProxyReplica]; ok {
+			continue
+		}
+		if ret != nil {
+			return nil, fmt.Errorf("found multiple matching %T objects", ret)
+		}
+		ret = &secrets.Items[i]
+	}
+	return ret, nil
+}
+
+// getProxyPod returns the Pod of the first replica of the proxy with the
+// given labels, or nil if there isn't one.
+func getProxyPod(ctx context.Context, c client.Client, ns string, labels map[string]string) (*corev1.Pod, error) {
+	pods := new(corev1.PodList)
+	if err := c.List(ctx, pods, client.InNamespace(ns), client.MatchingLabels(labels)); err != nil {
+		return nil, err
+	}
+	for i := range pods.Items {
+		// StatefulSet Pods are named <StatefulSet name>-<ordinal>.
+		if strings.HasSuffix(pods.Items[i].Name, "-0") {
+			return &pods.Items[i], nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,248 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/types/ptr"
+)
+
+func TestProxyReplicas(t *testing.T) {
+	tests := []struct {
+		name        string
+		sts         *tsapi.StatefulSet
+		wantReplica int32
+		wantMax     int32
+	}{
+		{"no_statefulset", nil, 1, 1},
+		{"default", &tsapi.StatefulSet{}, 1, 1},
+		{"replicas", &tsapi.StatefulSet{Replicas: ptr.To[int32](3)}, 3, 3},
+		{"autoscaling", &tsapi.StatefulSet{Autoscaling: &tsapi.Autoscaling{MinReplicas: ptr.To[int32](2), MaxReplicas: 5}}, 2, 5},
+		{"autoscaling_default_min", &tsapi.StatefulSet{Autoscaling: &tsapi.Autoscaling{MaxReplicas: 5}}, 1, 5},
+		{"autoscaling_overrides_replicas", &tsapi.StatefulSet{Replicas: ptr.To[int32](3), Autoscaling: &tsapi.Autoscaling{MaxReplicas: 2}}, 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pc := &tsapi.ProxyClass{Spec: tsapi.ProxyClassSpec{StatefulSet: tt.sts}}
+			gotReplicas, gotMax := proxyReplicas(pc)
+			if gotReplicas != tt.wantReplica || gotMax != tt.wantMax {
+				t.Errorf("proxyReplicas = (%d, %d), want (%d, %d)", gotReplicas, gotMax, tt.wantReplica, tt.wantMax)
+			}
+		})
+	}
+	if r, m := proxyReplicas(nil); r != 1 || m != 1 {
+		t.Errorf("proxyReplicas(nil) = (%d, %d), want (1, 1)", r, m)
+	}
+}
+
+func TestMultiReplicaProxy(t *testing.T) {
+	pc := &tsapi.ProxyClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "ha"},
+		Spec: tsapi.ProxyClassSpec{StatefulSet: &tsapi.StatefulSet{
+			Replicas:            ptr.To[int32](2),
+			PodDisruptionBudget: &tsapi.PodDisruptionBudget{},
+		}},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(pc).
+		WithStatusSubresource(pc).
+		Build()
+	ft := &fakeTSClient{}
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr := &ServiceReconciler{
+		Client: fc,
+		ssr: &tailscaleSTSReconciler{
+			Client:            fc,
+			tsClient:          ft,
+			defaultTags:       []string{"tag:k8s"},
+			operatorNamespace: "operator-ns",
+			proxyImage:        "tailscale/tailscale",
+		},
+		logger: zl.Sugar(),
+	}
+	setProxyClassReady := func() {
+		t.Helper()
+		mustUpdateStatus(t, fc, "", "ha", func(pc *tsapi.ProxyClass) {
+			pc.Status = tsapi.ProxyClassStatus{
+				Conditions: []tsapi.ConnectorCondition{{
+					Status:             metav1.ConditionTrue,
+					Type:               tsapi.ProxyClassready,
+					ObservedGeneration: pc.Generation,
+				}}}
+		})
+	}
+	setProxyClassReady()
+
+	// 1. A Service whose ProxyClass asks for two replicas and a
+	// PodDisruptionBudget gets a proxy StatefulSet with two replicas, each
+	// with its own state Secret.
+	mustCreate(t, fc, &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+			UID:       types.UID("1234-UID"),
+			Labels:    map[string]string{LabelProxyClass: "ha"},
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP:         "10.20.30.40",
+			Type:              corev1.ServiceTypeLoadBalancer,
+			LoadBalancerClass: ptr.To("tailscale"),
+		},
+	})
+	expectReconciled(t, sr, "default", "test")
+	fullName, shortName := findGenName(t, fc, "default", "test", "svc")
+	if fullName != shortName+"-0" {
+		t.Fatalf("first replica Secret is %q, want %q", fullName, shortName+"-0")
+	}
+	expectReplicas(t, fc, shortName, 2)
+	if got := proxyEnv(t, fc, shortName)["TS_KUBE_SECRET"]; got != "$(POD_NAME)" {
+		t.Errorf("TS_KUBE_SECRET = %q, want $(POD_NAME)", got)
+	}
+	expectReplicaSecrets(t, fc, shortName, 1)
+	pdb := new(policyv1.PodDisruptionBudget)
+	mustGet(t, fc, shortName, pdb)
+	if got := pdb.Spec.MaxUnavailable; got == nil || *got != intstr.FromInt32(1) {
+		t.Errorf("PodDisruptionBudget maxUnavailable = %v, want 1", got)
+	}
+	if got := pdb.Spec.Selector.MatchLabels["app"]; got != "1234-UID" {
+		t.Errorf("PodDisruptionBudget selects app=%q, want 1234-UID", got)
+	}
+	expectMissing[autoscalingv2.HorizontalPodAutoscaler](t, fc, "operator-ns", shortName)
+
+	// 2. The ProxyClass switches to autoscaling. The operator creates a
+	// HorizontalPodAutoscaler and leaves the number of replicas to it.
+	mustUpdate(t, fc, "", "ha", func(pc *tsapi.ProxyClass) {
+		pc.Spec.StatefulSet.Replicas = nil
+		pc.Spec.StatefulSet.Autoscaling = &tsapi.Autoscaling{
+			MinReplicas: ptr.To[int32](2),
+			MaxReplicas: 4,
+		}
+		pc.Spec.StatefulSet.Pod = &tsapi.Pod{
+			TailscaleContainer: &tsapi.Container{
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+				},
+			},
+		}
+	})
+	setProxyClassReady()
+	expectReconciled(t, sr, "default", "test")
+	hpa := new(autoscalingv2.HorizontalPodAutoscaler)
+	mustGet(t, fc, shortName, hpa)
+	if hpa.Spec.ScaleTargetRef.Kind != "StatefulSet" || hpa.Spec.ScaleTargetRef.Name != shortName {
+		t.Errorf("HorizontalPodAutoscaler scales %+v, want StatefulSet %s", hpa.Spec.ScaleTargetRef, shortName)
+	}
+	if hpa.Spec.MinReplicas == nil || *hpa.Spec.MinReplicas != 2 || hpa.Spec.MaxReplicas != 4 {
+		t.Errorf("HorizontalPodAutoscaler replicas = %v-%d, want 2-4", hpa.Spec.MinReplicas, hpa.Spec.MaxReplicas)
+	}
+	if got := *hpa.Spec.Metrics[0].Resource.Target.AverageUtilization; got != defaultTargetCPUUtilization {
+		t.Errorf("HorizontalPodAutoscaler target utilization = %d, want %d", got, defaultTargetCPUUtilization)
+	}
+	mustUpdate(t, fc, "operator-ns", shortName, func(ss *appsv1.StatefulSet) {
+		ss.Spec.Replicas = ptr.To[int32](3) // as the HorizontalPodAutoscaler would
+	})
+	expectReconciled(t, sr, "default", "test")
+	expectReplicas(t, fc, shortName, 3)
+	expectReplicaSecrets(t, fc, shortName, 1, 2)
+
+	// 3. The ProxyClass goes back to a single replica. The
+	// PodDisruptionBudget, HorizontalPodAutoscaler and the other replicas'
+	// Secrets and devices are removed.
+	mustUpdate(t, fc, "operator-ns", shortName+"-1", func(s *corev1.Secret) {
+		if s.Data == nil {
+			s.Data = map[string][]byte{}
+		}
+		s.Data["device_id"] = []byte("replica-1-id")
+	})
+	mustUpdate(t, fc, "", "ha", func(pc *tsapi.ProxyClass) {
+		pc.Spec.StatefulSet = &tsapi.StatefulSet{}
+	})
+	setProxyClassReady()
+	expectReconciled(t, sr, "default", "test")
+	expectReplicas(t, fc, shortName, 1)
+	if got := proxyEnv(t, fc, shortName)["TS_KUBE_SECRET"]; got != fullName {
+		t.Errorf("TS_KUBE_SECRET = %q, want %q", got, fullName)
+	}
+	expectReplicaSecrets(t, fc, shortName)
+	expectMissing[policyv1.PodDisruptionBudget](t, fc, "operator-ns", shortName)
+	expectMissing[autoscalingv2.HorizontalPodAutoscaler](t, fc, "operator-ns", shortName)
+	if !slices.Contains(ft.Deleted(), "replica-1-id") {
+		t.Errorf("device of replica 1 not deleted, deleted devices: %v", ft.Deleted())
+	}
+}
+
+func mustGet(t *testing.T, cl client.Client, name string, obj client.Object) {
+	t.Helper()
+	if err := cl.Get(context.Background(), types.NamespacedName{Namespace: "operator-ns", Name: name}, obj); err != nil {
+		t.Fatalf("getting %T %s: %v", obj, name, err)
+	}
+}
+
+func expectReplicas(t *testing.T, cl client.Client, stsName string, want int32) {
+	t.Helper()
+	ss := new(appsv1.StatefulSet)
+	mustGet(t, cl, stsName, ss)
+	if ss.Spec.Replicas == nil || *ss.Spec.Replicas != want {
+		t.Errorf("StatefulSet replicas = %v, want %d", ss.Spec.Replicas, want)
+	}
+}
+
+func proxyEnv(t *testing.T, cl client.Client, stsName string) map[string]string {
+	t.Helper()
+	ss := new(appsv1.StatefulSet)
+	mustGet(t, cl, stsName, ss)
+	env := make(map[string]string)
+	for _, e := range ss.Spec.Template.Spec.Containers[0].Env {
+		env[e.Name] = e.Value
+	}
+	return env
+}
+
+// expectReplicaSecrets checks that the proxy StatefulSet stsName has state
+// Secrets, labeled with their ordinals, for exactly the given replicas apart
+// from the first one.
+func expectReplicaSecrets(t *testing.T, cl client.Client, stsName string, ordinals ...int) {
+	t.Helper()
+	secrets := new(corev1.SecretList)
+	if err := cl.List(context.Background(), secrets, client.InNamespace("operator-ns"), client.HasLabels{LabelProxyReplica}); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range secrets.Items {
+		got = append(got, s.Name+"="+s.Labels[LabelProxyReplica])
+		if len(s.StringData["authkey"]) == 0 && len(s.Data["authkey"]) == 0 {
+			t.Errorf("Secret %s has no auth key", s.Name)
+		}
+	}
+	var want []string
+	for _, o := range ordinals {
+		want = append(want, fmt.Sprintf("%s-%d=%d", stsName, o, o))
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("replica Secrets = %v, want %v", got, want)
+	}
+}
@@ -12,14 +12,19 @@
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"net/http"
+	"net/netip"
 	"os"
 	"slices"
+	"strconv"
 	"strings"
 
 	"go.uber.org/zap"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -34,6 +39,7 @@
 	"tailscale.com/net/netutil"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/opt"
+	"tailscale.com/types/ptr"
 	"tailscale.com/util/dnsname"
 	"tailscale.com/util/mak"
 )
@@ -46,6 +52,13 @@
 	LabelParentType      = "tailscale.com/parent-resource-type"
 	LabelParentName      = "tailscale.com/parent-resource"
 	LabelParentNamespace = "tailscale.com/parent-resource-ns"
+	// LabelPodOrdinal is set on resources created for a PodGroup to the
+	// ordinal of the StatefulSet Pod that they expose.
+	LabelPodOrdinal = "tailscale.com/pod-ordinal"
+	// LabelProxyReplica is set on the state Secrets of the replicas of a
+	// multi-replica proxy, other than the first one, to the replica's
+	// ordinal.
+	LabelProxyReplica = "tailscale.com/proxy-replica"
 
 	// LabelProxyClass can be set by users on Connectors, tailscale
 	// Ingresses and Services that define cluster ingress or cluster egress,
@@ -63,6 +76,12 @@
 	AnnotationTailnetTargetIP    = "tailscale.com/tailnet-ip"
 	//MagicDNS name of tailnet node.
 	AnnotationTailnetTargetFQDN = "tailscale.com/tailnet-fqdn"
+	// AnnotationPreserveSourceIP, if set to "true" on a Service exposed to
+	// the tailnet, makes its proxy forward traffic to the Service without
+	// SNAT, so that the backends see the tailnet IPs of the clients, like
+	// with externalTrafficPolicy: Local. Replies must be routed back via
+	// the proxy Pod, otherwise connections fail.
+	AnnotationPreserveSourceIP = "tailscale.com/preserve-source-ip"
 
 	// Annotations settable by users on ingresses.
 	AnnotationFunnel = "tailscale.com/funnel"
@@ -99,7 +118,7 @@
 
 var (
 	// tailscaleManagedLabels are label keys that tailscale operator sets on StatefulSets and Pods.
-	tailscaleManagedLabels = []string{LabelManaged, LabelParentType, LabelParentName, LabelParentNamespace, "app"}
+	tailscaleManagedLabels = []string{LabelManaged, LabelParentType, LabelParentName, LabelParentNamespace, LabelPodOrdinal, "app"}
 	// tailscaleManagedAnnotations are annotation keys that tailscale operator sets on StatefulSets and Pods.
 	tailscaleManagedAnnotations = []string{podAnnotationLastSetClusterIP, podAnnotationLastSetHostname, podAnnotationLastSetTailnetTargetIP, podAnnotationLastSetTailnetTargetFQDN, podAnnotationLastSetConfigFileHash}
 )
@@ -111,6 +130,9 @@ type tailscaleSTSConfig struct {
 
 	ServeConfig     *ipn.ServeConfig // if serve config is set, this is a proxy for Ingress
 	ClusterTargetIP string           // ingress target
+	// PreserveSourceIP is whether traffic to ClusterTargetIP is forwarded
+	// without SNAT.
+	PreserveSourceIP bool
 	// If set to true, operator should configure containerboot to forward
 	// cluster traffic via the proxy set up for Kubernetes Ingress.
 	ForwardClusterTrafficViaL7IngressProxy bool
@@ -148,12 +170,26 @@ type tailscaleSTSReconciler struct {
 	proxyImage             string
 	proxyPriorityClassName string
 	tsFirewallMode         string
+	// hostnameTemplate, if set, is the template for the tailnet
+	// hostnames of proxies that don't have one set explicitly. See
+	// expandHostnameTemplate.
+	hostnameTemplate string
+	// clusterName is the value of the {cluster} hostname template
+	// placeholder.
+	clusterName string
+	// tailnetLock signs proxies on tailnets with tailnet lock enabled and
+	// reports proxies that are locked out. The operator always sets it;
+	// it's only nil in tests that don't exercise tailnet lock.
+	tailnetLock *tailnetLockSigner
 }
 
 func (sts tailscaleSTSReconciler) validate() error {
 	if sts.tsFirewallMode != "" && !isValidFirewallMode(sts.tsFirewallMode) {
 		return fmt.Errorf("invalid proxy firewall mode %s, valid modes are iptables, nftables or unset", sts.tsFirewallMode)
 	}
+	if err := validateHostnameTemplate(sts.hostnameTemplate); err != nil {
+		return fmt.Errorf("invalid hostname template: %w", err)
+	}
 	return nil
 }
 
@@ -172,14 +208,21 @@ func (a *tailscaleSTSReconciler) Provision(ctx context.Context, logger *zap.Suga
 		return nil, fmt.Errorf("failed to reconcile headless service: %w", err)
 	}
 
-	secretName, tsConfigHash, err := a.createOrGetSecret(ctx, logger, sts, hsvc)
+	secretName, tsConfigHash, err := a.createOrGetSecret(ctx, logger, sts, hsvc, 0)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create or get API key secret: %w", err)
 	}
-	_, err = a.reconcileSTS(ctx, logger, sts, hsvc, secretName, tsConfigHash)
+	ss, err := a.reconcileSTS(ctx, logger, sts, hsvc, secretName, tsConfigHash)
 	if err != nil {
 		return nil, fmt.Errorf("failed to reconcile statefulset: %w", err)
 	}
+	if ss == nil {
+		// The ProxyClass isn't ready yet.
+		return hsvc, nil
+	}
+	if err := a.reconcileReplicas(ctx, logger, sts, hsvc, ss); err != nil {
+		return nil, fmt.Errorf("failed to reconcile proxy replicas: %w", err)
+	}
 
 	return hsvc, nil
 }
@@ -217,21 +260,16 @@ func (a *tailscaleSTSReconciler) Cleanup(ctx context.Context, logger *zap.Sugare
 	if err != nil {
 		return false, fmt.Errorf("getting device info: %w", err)
 	}
-	if id != "" {
-		logger.Debugf("deleting device %s from control", string(id))
-		if err := a.tsClient.DeleteDevice(ctx, string(id)); err != nil {
-			errResp := &tailscale.ErrResponse{}
-			if ok := errors.As(err, errResp); ok && errResp.Status == http.StatusNotFound {
-				logger.Debugf("device %s not found, likely because it has already been deleted from control", string(id))
-			} else {
-				return false, fmt.Errorf("deleting device: %w", err)
-			}
-		} else {
-			logger.Debugf("device %s deleted from control", string(id))
-		}
+	if err := a.deleteDevice(ctx, logger, id); err != nil {
+		return false, err
+	}
+	if err := a.cleanupReplicas(ctx, logger, labels, 0); err != nil {
+		return false, err
 	}
 
 	types := []client.Object{
+		&policyv1.PodDisruptionBudget{},
+		&autoscalingv2.HorizontalPodAutoscaler{},
 		&corev1.Service{},
 		&corev1.Secret{},
 	}
@@ -243,6 +281,26 @@ func (a *tailscaleSTSReconciler) Cleanup(ctx context.Context, logger *zap.Sugare
 	return true, nil
 }
 
+// deleteDevice deletes the Tailscale device id from control, unless id is
+// empty.
+func (a *tailscaleSTSReconciler) deleteDevice(ctx context.Context, logger *zap.SugaredLogger, id tailcfg.StableNodeID) error {
+	if id == "" {
+		return nil
+	}
+	logger.Debugf("deleting device %s from control", string(id))
+	if err := a.tsClient.DeleteDevice(ctx, string(id)); err != nil {
+		errResp := &tailscale.ErrResponse{}
+		if ok := errors.As(err, errResp); ok && errResp.Status == http.StatusNotFound {
+			logger.Debugf("device %s not found, likely because it has already been deleted from control", string(id))
+		} else {
+			return fmt.Errorf("deleting device: %w", err)
+		}
+	} else {
+		logger.Debugf("device %s deleted from control", string(id))
+	}
+	return nil
+}
+
 // maxStatefulSetNameLength is maximum length the StatefulSet name can
 // have to NOT result in a too long value for controller-revision-hash
 // label value (see https://github.com/kubernetes/kubernetes/issues/64023).
@@ -289,15 +347,23 @@ func (a *tailscaleSTSReconciler) reconcileHeadlessService(ctx context.Context, l
 	return createOrUpdate(ctx, a.Client, a.operatorNamespace, hsvc, func(svc *corev1.Service) { svc.Spec = hsvc.Spec })
 }
 
-func (a *tailscaleSTSReconciler) createOrGetSecret(ctx context.Context, logger *zap.SugaredLogger, stsC *tailscaleSTSConfig, hsvc *corev1.Service) (string, string, error) {
+// createOrGetSecret creates or updates the state Secret of the proxy
+// replica with the given ordinal, which is named after the replica's Pod. It
+// returns the name of the Secret and the hash of the tailscaled config in it,
+// if any.
+func (a *tailscaleSTSReconciler) createOrGetSecret(ctx context.Context, logger *zap.SugaredLogger, stsC *tailscaleSTSConfig, hsvc *corev1.Service, ordinal int) (string, string, error) {
+	labels := stsC.ChildResourceLabels
+	if ordinal > 0 {
+		// Other replicas' Secrets are labeled, so that DeviceInfo and
+		// ProxyHealth can tell the first replica's Secret apart.
+		labels = maps.Clone(labels)
+		labels[LabelProxyReplica] = strconv.Itoa(ordinal)
+	}
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			// Hardcode a -0 suffix so that in future, if we support
-			// multiple StatefulSet replicas, we can provision -N for
-			// those.
-			Name:      hsvc.Name + "-0",
+			Name:      fmt.Sprintf("%s-%d", hsvc.Name, ordinal),
 			Namespace: a.operatorNamespace,
-			Labels:    stsC.ChildResourceLabels,
+			Labels:    labels,
 		},
 	}
 	var orig *corev1.Secret // unmodified copy of secret
@@ -315,15 +381,19 @@ func (a *tailscaleSTSReconciler) createOrGetSecret(ctx context.Context, logger *
 		// Secret doesn't exist yet, create one. Initially it contains
 		// only the Tailscale authkey, but once Tailscale starts it'll
 		// also store the daemon state.
-		sts, err := getSingleObject[appsv1.StatefulSet](ctx, a.Client, a.operatorNamespace, stsC.ChildResourceLabels)
-		if err != nil {
-			return "", "", err
-		}
-		if sts != nil {
-			// StatefulSet exists, so we have already created the secret.
-			// If the secret is missing, they should delete the StatefulSet.
-			logger.Errorf("Tailscale proxy secret doesn't exist, but the corresponding StatefulSet %s/%s already does. Something is wrong, please delete the StatefulSet.", sts.GetNamespace(), sts.GetName())
-			return "", "", nil
+		// The first replica's Secret is created before the StatefulSet,
+		// the others' once it's scaled up.
+		if ordinal == 0 {
+			sts, err := getSingleObject[appsv1.StatefulSet](ctx, a.Client, a.operatorNamespace, stsC.ChildResourceLabels)
+			if err != nil {
+				return "", "", err
+			}
+			if sts != nil {
+				// StatefulSet exists, so we have already created the secret.
+				// If the secret is missing, they should delete the StatefulSet.
+				logger.Errorf("Tailscale proxy secret doesn't exist, but the corresponding StatefulSet %s/%s already does. Something is wrong, please delete the StatefulSet.", sts.GetNamespace(), sts.GetName())
+				return "", "", nil
+			}
 		}
 		// Create API Key secret which is going to be used by the statefulset
 		// to authenticate with Tailscale.
@@ -332,10 +402,16 @@ func (a *tailscaleSTSReconciler) createOrGetSecret(ctx context.Context, logger *
 		if len(tags) == 0 {
 			tags = a.defaultTags
 		}
+		var err error
 		authKey, err = a.newAuthKey(ctx, tags)
 		if err != nil {
 			return "", "", err
 		}
+		if a.tailnetLock != nil {
+			if authKey, err = a.tailnetLock.signAuthKey(ctx, authKey); err != nil {
+				return "", "", err
+			}
+		}
 	}
 	if !shouldDoTailscaledDeclarativeConfig(stsC) && authKey != "" {
 		mak.Set(&secret.StringData, "authkey", authKey)
@@ -371,9 +447,10 @@ func (a *tailscaleSTSReconciler) createOrGetSecret(ctx context.Context, logger *
 }
 
 // DeviceInfo returns the device ID and hostname for the Tailscale device
-// associated with the given labels.
+// associated with the given labels. For a multi-replica proxy, that's the
+// device of its first replica.
 func (a *tailscaleSTSReconciler) DeviceInfo(ctx context.Context, childLabels map[string]string) (id tailcfg.StableNodeID, hostname string, ips []string, err error) {
-	sec, err := getSingleObject[corev1.Secret](ctx, a.Client, a.operatorNamespace, childLabels)
+	sec, err := getProxySecret(ctx, a.Client, a.operatorNamespace, childLabels)
 	if err != nil {
 		return "", "", nil, err
 	}
@@ -398,6 +475,94 @@ func (a *tailscaleSTSReconciler) DeviceInfo(ctx context.Context, childLabels map
 	return id, hostname, ips, nil
 }
 
+// proxyHealth is the health of a proxy, as reported by its Pod and by
+// containerboot in its state Secret.
+type proxyHealth struct {
+	// running is whether the proxy Pod is running and ready.
+	running bool
+	// notRunningReason is a brief explanation of why the proxy Pod is not
+	// running, if it isn't.
+	notRunningReason string
+	// deviceID is the ID of the proxy's Tailscale device, or empty if
+	// the proxy hasn't logged in yet.
+	deviceID tailcfg.StableNodeID
+	// routes are the routes that have been approved for the proxy's
+	// device.
+	routes []netip.Prefix
+	// lockedOutReason is why the proxy's device is locked out by tailnet
+	// lock, or empty if it isn't.
+	lockedOutReason string
+}
+
+// ProxyHealth returns the health of the proxy associated with the given
+// labels. For a multi-replica proxy, that's the health of its first replica.
+func (a *tailscaleSTSReconciler) ProxyHealth(ctx context.Context, childLabels map[string]string) (*proxyHealth, error) {
+	h := new(proxyHealth)
+	pod, err := getProxyPod(ctx, a.Client, a.operatorNamespace, childLabels)
+	if err != nil {
+		return nil, err
+	}
+	h.running, h.notRunningReason = podRunning(pod)
+
+	sec, err := getProxySecret(ctx, a.Client, a.operatorNamespace, childLabels)
+	if err != nil {
+		return nil, err
+	}
+	if sec == nil {
+		return h, nil
+	}
+	h.deviceID = tailcfg.StableNodeID(sec.Data["device_id"])
+	if rawRoutes, ok := sec.Data["device_routes"]; ok {
+		var routes []string
+		if err := json.Unmarshal(rawRoutes, &routes); err != nil {
+			return nil, fmt.Errorf("error parsing device_routes: %w", err)
+		}
+		for _, r := range routes {
+			p, err := netip.ParsePrefix(r)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing device_routes: %w", err)
+			}
+			h.routes = append(h.routes, p)
+		}
+	}
+	if h.deviceID != "" && a.tailnetLock != nil {
+		if h.lockedOutReason, err = a.tailnetLock.lockedOutReason(ctx, h.deviceID); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// podRunning reports whether pod is running and ready and, if not, a brief
+// explanation why.
+func podRunning(pod *corev1.Pod) (bool, string) {
+	if pod == nil {
+		return false, "proxy Pod has not been created yet"
+	}
+	if pod.Status.Phase != corev1.PodRunning {
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.State.Waiting != nil && cs.State.Waiting.Reason != "" {
+				return false, fmt.Sprintf("proxy Pod is %s: container %s is %s", pod.Status.Phase, cs.Name, cs.State.Waiting.Reason)
+			}
+		}
+		return false, fmt.Sprintf("proxy Pod is %s", pod.Status.Phase)
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			if cond.Status == corev1.ConditionTrue {
+				return true, ""
+			}
+			break
+		}
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.State.Waiting != nil && cs.State.Waiting.Reason != "" {
+			return false, fmt.Sprintf("proxy Pod is not ready: container %s is %s", cs.Name, cs.State.Waiting.Reason)
+		}
+	}
+	return false, "proxy Pod is not ready"
+}
+
 func (a *tailscaleSTSReconciler) newAuthKey(ctx context.Context, tags []string) (string, error) {
 	caps := tailscale.KeyCapabilities{
 		Devices: tailscale.KeyDeviceCapabilities{
@@ -457,6 +622,8 @@ func (a *tailscaleSTSReconciler) reconcileSTS(ctx context.Context, logger *zap.S
 		Name:      headlessSvc.Name,
 		Namespace: a.operatorNamespace,
 	}
+	replicas, maxReplicas := proxyReplicas(proxyClass)
+	ss.Spec.Replicas = ptr.To(replicas)
 	for key, val := range sts.ChildResourceLabels {
 		mak.Set(&ss.ObjectMeta.Labels, key, val)
 	}
@@ -472,10 +639,16 @@ func (a *tailscaleSTSReconciler) reconcileSTS(ctx context.Context, logger *zap.S
 	}
 
 	// Generic containerboot configuration options.
+	kubeSecret := proxySecret
+	if maxReplicas > 1 {
+		// Each replica keeps its state in the Secret named after its
+		// Pod, see createOrGetSecret.
+		kubeSecret = "$(POD_NAME)"
+	}
 	container.Env = append(container.Env,
 		corev1.EnvVar{
 			Name:  "TS_KUBE_SECRET",
-			Value: proxySecret,
+			Value: kubeSecret,
 		},
 	)
 	if sts.ForwardClusterTrafficViaL7IngressProxy {
@@ -498,17 +671,44 @@ func (a *tailscaleSTSReconciler) reconcileSTS(ctx context.Context, logger *zap.S
 	// Configure containeboot to run tailscaled with a configfile read from the state Secret.
 	if shouldDoTailscaledDeclarativeConfig(sts) {
 		mak.Set(&ss.Spec.Template.Annotations, podAnnotationLastSetConfigFileHash, tsConfigHash)
-		pod.Spec.Volumes = append(ss.Spec.Template.Spec.Volumes, corev1.Volume{
-			Name: "tailscaledconfig",
-			VolumeSource: corev1.VolumeSource{
-				Secret: &corev1.SecretVolumeSource{
-					SecretName: proxySecret,
-					Items: []corev1.KeyToPath{{
-						Key:  tailscaledConfigKey,
-						Path: tailscaledConfigKey,
-					}},
-				},
+		configVolume := corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: proxySecret,
+				Items: []corev1.KeyToPath{{
+					Key:  tailscaledConfigKey,
+					Path: tailscaledConfigKey,
+				}},
 			},
+		}
+		configPath := "/etc/tsconfig/tailscaled"
+		if maxReplicas > 1 {
+			// The config contains the auth key, so each replica needs
+			// its own. Mount those of all the replicas that the
+			// StatefulSet can be scaled to, so that scaling doesn't
+			// change the Pod template, and have each replica read the
+			// one named after its Pod.
+			var sources []corev1.VolumeProjection
+			for i := 0; i < int(maxReplicas); i++ {
+				name := fmt.Sprintf("%s-%d", headlessSvc.Name, i)
+				sources = append(sources, corev1.VolumeProjection{
+					Secret: &corev1.SecretProjection{
+						LocalObjectReference: corev1.LocalObjectReference{Name: name},
+						Items: []corev1.KeyToPath{{
+							Key:  tailscaledConfigKey,
+							Path: name,
+						}},
+						Optional: ptr.To(true),
+					},
+				})
+			}
+			configVolume = corev1.VolumeSource{
+				Projected: &corev1.ProjectedVolumeSource{Sources: sources},
+			}
+			configPath = "/etc/tsconfig/$(POD_NAME)"
+		}
+		pod.Spec.Volumes = append(ss.Spec.Template.Spec.Volumes, corev1.Volume{
+			Name:         "tailscaledconfig",
+			VolumeSource: configVolume,
 		})
 		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
 			Name:      "tailscaledconfig",
@@ -517,7 +717,7 @@ func (a *tailscaleSTSReconciler) reconcileSTS(ctx context.Context, logger *zap.S
 		})
 		container.Env = append(container.Env, corev1.EnvVar{
 			Name:  "EXPERIMENTAL_TS_CONFIGFILE_PATH",
-			Value: "/etc/tsconfig/tailscaled",
+			Value: configPath,
 		})
 	}
 
@@ -536,6 +736,15 @@ func (a *tailscaleSTSReconciler) reconcileSTS(ctx context.Context, logger *zap.S
 			Value: sts.ClusterTargetIP,
 		})
 		mak.Set(&ss.Spec.Template.Annotations, podAnnotationLastSetClusterIP, sts.ClusterTargetIP)
+		if sts.PreserveSourceIP {
+			// Traffic forwarded from the tailnet is masqueraded by
+			// tailscaled's subnet route SNAT rule, which this
+			// disables.
+			container.Env = append(container.Env, corev1.EnvVar{
+				Name:  "TS_EXTRA_ARGS",
+				Value: "--snat-subnet-routes=false",
+			})
+		}
 	} else if sts.TailnetTargetIP != "" {
 		container.Env = append(container.Env, corev1.EnvVar{
 			Name:  "TS_TAILNET_TARGET_IP",
@@ -576,8 +785,15 @@ func (a *tailscaleSTSReconciler) reconcileSTS(ctx context.Context, logger *zap.S
 		logger.Debugf("configuring proxy resources with ProxyClass %s", sts.ProxyClass)
 		ss = applyProxyClassToStatefulSet(proxyClass, ss)
 	}
+	autoscaled := proxyClass.Spec.StatefulSet != nil && proxyClass.Spec.StatefulSet.Autoscaling != nil
 	updateSS := func(s *appsv1.StatefulSet) {
+		replicas := s.Spec.Replicas
 		s.Spec = ss.Spec
+		if autoscaled {
+			// The number of replicas is up to the
+			// HorizontalPodAutoscaler.
+			s.Spec.Replicas = replicas
+		}
 		s.ObjectMeta.Labels = ss.Labels
 		s.ObjectMeta.Annotations = ss.Annotations
 	}
@@ -634,6 +850,9 @@ func applyProxyClassToStatefulSet(pc *tsapi.ProxyClass, ss *appsv1.StatefulSet)
 	ss.Spec.Template.Spec.NodeName = wantsPod.NodeName
 	ss.Spec.Template.Spec.NodeSelector = wantsPod.NodeSelector
 	ss.Spec.Template.Spec.Tolerations = wantsPod.Tolerations
+	if wantsPod.PriorityClassName != "" {
+		ss.Spec.Template.Spec.PriorityClassName = wantsPod.PriorityClassName
+	}
 
 	// Update containers.
 	updateContainer := func(overlay *tsapi.Container, base corev1.Container) corev1.Container {
@@ -660,9 +879,55 @@ func applyProxyClassToStatefulSet(pc *tsapi.ProxyClass, ss *appsv1.StatefulSet)
 			}
 		}
 	}
+	if wantsPod.ReadOnlyRootFilesystem {
+		setReadOnlyRootFilesystem(&ss.Spec.Template.Spec)
+	}
 	return ss
 }
 
+const (
+	// proxyTmpDir and proxyStateDir are the paths that the proxy writes
+	// to. They get emptyDir volumes if its root filesystem is read-only.
+	proxyTmpDir   = "/tmp"
+	proxyStateDir = "/var/lib/tailscale"
+)
+
+// setReadOnlyRootFilesystem makes the root filesystem of the containers of
+// the proxy Pod spec read-only, mounting emptyDir volumes at the paths that
+// the tailscale container writes to.
+func setReadOnlyRootFilesystem(spec *corev1.PodSpec) {
+	readOnly := func(c *corev1.Container) {
+		// The SecurityContext may be shared with the ProxyClass.
+		sc := c.SecurityContext.DeepCopy()
+		if sc == nil {
+			sc = new(corev1.SecurityContext)
+		}
+		sc.ReadOnlyRootFilesystem = ptr.To(true)
+		c.SecurityContext = sc
+	}
+	for i := range spec.InitContainers {
+		readOnly(&spec.InitContainers[i])
+	}
+	for i, c := range spec.Containers {
+		readOnly(&spec.Containers[i])
+		if c.Name != "tailscale" {
+			continue
+		}
+		spec.Containers[i].VolumeMounts = append(spec.Containers[i].VolumeMounts,
+			corev1.VolumeMount{Name: "tmp", MountPath: proxyTmpDir},
+			corev1.VolumeMount{Name: "tailscale-state", MountPath: proxyStateDir},
+		)
+		spec.Containers[i].Env = append(spec.Containers[i].Env, corev1.EnvVar{
+			Name:  "TS_STATE_DIR",
+			Value: proxyStateDir,
+		})
+	}
+	spec.Volumes = append(spec.Volumes,
+		corev1.Volume{Name: "tmp", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		corev1.Volume{Name: "tailscale-state", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+	)
+}
+
 // tailscaledConfig takes a proxy config, a newly generated auth key if
 // generated and a Secret with the previous proxy state and auth key and
 // produces returns tailscaled configuration and a hash of that configuration.
@@ -815,14 +1080,86 @@ func defaultEnv(envName, defVal string) string {
 	return v
 }
 
-func nameForService(svc *corev1.Service) (string, error) {
+// nameForService returns the tailnet hostname for the proxy of svc, which
+// uses the ProxyClass proxyClass, if not empty.
+func (a *tailscaleSTSReconciler) nameForService(ctx context.Context, svc *corev1.Service, proxyClass string) (string, error) {
 	if h, ok := svc.Annotations[AnnotationHostname]; ok {
 		if err := dnsname.ValidLabel(h); err != nil {
 			return "", fmt.Errorf("invalid Tailscale hostname %q: %w", h, err)
 		}
 		return h, nil
 	}
-	return svc.Namespace + "-" + svc.Name, nil
+	return a.deviceHostname(ctx, proxyClass, svc.Namespace, svc.Name, svc.Namespace+"-"+svc.Name)
+}
+
+// deviceHostname returns the tailnet hostname for the proxy of the resource
+// namespace/name, which uses the ProxyClass proxyClass, if not empty. It's
+// the expansion of the ProxyClass's hostname template or else the
+// operator's, if either is set, or defaultName otherwise.
+func (a *tailscaleSTSReconciler) deviceHostname(ctx context.Context, proxyClass, namespace, name, defaultName string) (string, error) {
+	tmpl := a.hostnameTemplate
+	if proxyClass != "" {
+		pc := new(tsapi.ProxyClass)
+		if err := a.Get(ctx, types.NamespacedName{Name: proxyClass}, pc); err != nil {
+			return "", fmt.Errorf("failed to get ProxyClass: %w", err)
+		}
+		if pc.Spec.HostnameTemplate != "" {
+			tmpl = pc.Spec.HostnameTemplate
+		}
+	}
+	if tmpl == "" {
+		return defaultName, nil
+	}
+	return expandHostnameTemplate(tmpl, a.clusterName, namespace, name)
+}
+
+// hostnameTemplatePlaceholders are the placeholders that a hostname template
+// can contain.
+var hostnameTemplatePlaceholders = []string{"{namespace}", "{name}", "{cluster}"}
+
+// validateHostnameTemplate reports an error if tmpl contains braces other
+// than those of the known placeholders.
+func validateHostnameTemplate(tmpl string) error {
+	rest := tmpl
+	for _, p := range hostnameTemplatePlaceholders {
+		rest = strings.ReplaceAll(rest, p, "")
+	}
+	if strings.ContainsAny(rest, "{}") {
+		return fmt.Errorf("template %q contains an unknown placeholder; known placeholders are %s", tmpl, strings.Join(hostnameTemplatePlaceholders, ", "))
+	}
+	return nil
+}
+
+// expandHostnameTemplate returns the hostname that results from replacing
+// the placeholders in tmpl. Characters that aren't valid in a hostname are
+// replaced with dashes, runs of dashes are collapsed, and the result is
+// truncated to the maximum length of a DNS label.
+func expandHostnameTemplate(tmpl, cluster, namespace, name string) (string, error) {
+	if err := validateHostnameTemplate(tmpl); err != nil {
+		return "", err
+	}
+	if strings.Contains(tmpl, "{cluster}") && cluster == "" {
+		return "", errors.New("hostname template uses {cluster}, but OPERATOR_CLUSTER_NAME is not set")
+	}
+	expanded := strings.NewReplacer("{namespace}", namespace, "{name}", name, "{cluster}", cluster).Replace(tmpl)
+	var b strings.Builder
+	for _, r := range strings.ToLower(expanded) {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9') {
+			r = '-'
+		}
+		if r == '-' && strings.HasSuffix(b.String(), "-") {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	h := strings.Trim(b.String(), "-")
+	if len(h) > 63 {
+		h = strings.TrimRight(h[:63], "-")
+	}
+	if err := dnsname.ValidLabel(h); err != nil {
+		return "", fmt.Errorf("hostname template %q results in invalid hostname %q: %w", tmpl, h, err)
+	}
+	return h, nil
 }
 
 func isValidFirewallMode(m string) bool {
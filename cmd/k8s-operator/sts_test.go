@@ -63,10 +63,11 @@ func Test_applyProxyClassToStatefulSet(t *testing.T) {
 					SecurityContext: &corev1.PodSecurityContext{
 						RunAsUser: ptr.To(int64(0)),
 					},
-					ImagePullSecrets: []corev1.LocalObjectReference{{Name: "docker-creds"}},
-					NodeName:         "some-node",
-					NodeSelector:     map[string]string{"beta.kubernetes.io/os": "linux"},
-					Tolerations:      []corev1.Toleration{{Key: "", Operator: "Exists"}},
+					ImagePullSecrets:  []corev1.LocalObjectReference{{Name: "docker-creds"}},
+					NodeName:          "some-node",
+					NodeSelector:      map[string]string{"beta.kubernetes.io/os": "linux"},
+					PriorityClassName: "tailscale-proxies",
+					Tolerations:       []corev1.Toleration{{Key: "", Operator: "Exists"}},
 					TailscaleContainer: &tsapi.Container{
 						SecurityContext: &corev1.SecurityContext{
 							Privileged: ptr.To(true),
@@ -138,6 +139,7 @@ func Test_applyProxyClassToStatefulSet(t *testing.T) {
 	wantSS.Spec.Template.Spec.NodeName = proxyClassAllOpts.Spec.StatefulSet.Pod.NodeName
 	wantSS.Spec.Template.Spec.NodeSelector = proxyClassAllOpts.Spec.StatefulSet.Pod.NodeSelector
 	wantSS.Spec.Template.Spec.Tolerations = proxyClassAllOpts.Spec.StatefulSet.Pod.Tolerations
+	wantSS.Spec.Template.Spec.PriorityClassName = proxyClassAllOpts.Spec.StatefulSet.Pod.PriorityClassName
 	wantSS.Spec.Template.Spec.Containers[0].SecurityContext = proxyClassAllOpts.Spec.StatefulSet.Pod.TailscaleContainer.SecurityContext
 	wantSS.Spec.Template.Spec.InitContainers[0].SecurityContext = proxyClassAllOpts.Spec.StatefulSet.Pod.TailscaleInitContainer.SecurityContext
 	wantSS.Spec.Template.Spec.Containers[0].Resources = proxyClassAllOpts.Spec.StatefulSet.Pod.TailscaleContainer.Resources
@@ -173,6 +175,7 @@ func Test_applyProxyClassToStatefulSet(t *testing.T) {
 	wantSS.Spec.Template.Spec.NodeName = proxyClassAllOpts.Spec.StatefulSet.Pod.NodeName
 	wantSS.Spec.Template.Spec.NodeSelector = proxyClassAllOpts.Spec.StatefulSet.Pod.NodeSelector
 	wantSS.Spec.Template.Spec.Tolerations = proxyClassAllOpts.Spec.StatefulSet.Pod.Tolerations
+	wantSS.Spec.Template.Spec.PriorityClassName = proxyClassAllOpts.Spec.StatefulSet.Pod.PriorityClassName
 	wantSS.Spec.Template.Spec.Containers[0].SecurityContext = proxyClassAllOpts.Spec.StatefulSet.Pod.TailscaleContainer.SecurityContext
 	wantSS.Spec.Template.Spec.Containers[0].Resources = proxyClassAllOpts.Spec.StatefulSet.Pod.TailscaleContainer.Resources
 	gotSS = applyProxyClassToStatefulSet(proxyClassAllOpts, userspaceProxySS.DeepCopy())
@@ -191,6 +194,47 @@ func Test_applyProxyClassToStatefulSet(t *testing.T) {
 	if diff := cmp.Diff(gotSS, wantSS); diff != "" {
 		t.Fatalf("Unexpected result applying ProxyClass with custom labels and annotations to a StatefulSet for a userspace proxy (-got +want):\n%s", diff)
 	}
+
+	// 5. Test that a ProxyClass with a read-only root filesystem makes
+	// the containers' root filesystem read-only and mounts emptyDirs at
+	// the paths that the proxy writes to, without modifying the
+	// ProxyClass.
+	proxyClassReadOnly := &tsapi.ProxyClass{
+		Spec: tsapi.ProxyClassSpec{
+			StatefulSet: &tsapi.StatefulSet{
+				Pod: &tsapi.Pod{
+					ReadOnlyRootFilesystem: true,
+					TailscaleContainer: &tsapi.Container{
+						SecurityContext: &corev1.SecurityContext{
+							RunAsNonRoot: ptr.To(true),
+						},
+					},
+				},
+			},
+		},
+	}
+	wantSS = nonUserspaceProxySS.DeepCopy()
+	wantSS.Spec.Template.Spec.InitContainers[0].SecurityContext.ReadOnlyRootFilesystem = ptr.To(true)
+	wantSS.Spec.Template.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{
+		RunAsNonRoot:           ptr.To(true),
+		ReadOnlyRootFilesystem: ptr.To(true),
+	}
+	wantSS.Spec.Template.Spec.Containers[0].VolumeMounts = []corev1.VolumeMount{
+		{Name: "tmp", MountPath: "/tmp"},
+		{Name: "tailscale-state", MountPath: "/var/lib/tailscale"},
+	}
+	wantSS.Spec.Template.Spec.Containers[0].Env = append(wantSS.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "TS_STATE_DIR", Value: "/var/lib/tailscale"})
+	wantSS.Spec.Template.Spec.Volumes = []corev1.Volume{
+		{Name: "tmp", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		{Name: "tailscale-state", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+	}
+	gotSS = applyProxyClassToStatefulSet(proxyClassReadOnly, nonUserspaceProxySS.DeepCopy())
+	if diff := cmp.Diff(gotSS, wantSS); diff != "" {
+		t.Fatalf("Unexpected result applying ProxyClass with a read-only root filesystem to a StatefulSet for non-userspace proxy (-got +want):\n%s", diff)
+	}
+	if proxyClassReadOnly.Spec.StatefulSet.Pod.TailscaleContainer.SecurityContext.ReadOnlyRootFilesystem != nil {
+		t.Fatalf("applying ProxyClass modified its container SecurityContext")
+	}
 }
 
 func mergeMapKeys(a, b map[string]string) map[string]string {
@@ -291,3 +335,57 @@ func Test_mergeStatefulSetLabelsOrAnnots(t *testing.T) {
 		})
 	}
 }
+
+func Test_expandHostnameTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    string
+		cluster string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "namespace_and_name",
+			tmpl: "{namespace}-{name}",
+			want: "default-web",
+		},
+		{
+			name:    "cluster",
+			tmpl:    "{cluster}-{namespace}-{name}",
+			cluster: "prod-eu",
+			want:    "prod-eu-default-web",
+		},
+		{
+			name:    "cluster_unset",
+			tmpl:    "{cluster}-{name}",
+			wantErr: true,
+		},
+		{
+			name:    "unknown_placeholder",
+			tmpl:    "{kind}-{name}",
+			wantErr: true,
+		},
+		{
+			name:    "sanitized",
+			tmpl:    "{cluster}.{name}_proxy",
+			cluster: "Prod",
+			want:    "prod-web-proxy",
+		},
+		{
+			name: "truncated",
+			tmpl: strings.Repeat("a", 70) + "-{name}",
+			want: strings.Repeat("a", 63),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandHostnameTemplate(tt.tmpl, tt.cluster, "default", "web")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandHostnameTemplate(%q) error = %v, wantErr %v", tt.tmpl, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("expandHostnameTemplate(%q) = %q, want %q", tt.tmpl, got, tt.want)
+			}
+		})
+	}
+}
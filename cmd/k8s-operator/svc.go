@@ -168,7 +168,7 @@ func (a *ServiceReconciler) maybeProvision(ctx context.Context, logger *zap.Suga
 		}
 	}
 
-	hostname, err := nameForService(svc)
+	hostname, err := a.ssr.nameForService(ctx, svc, proxyClass)
 	if err != nil {
 		return err
 	}
@@ -202,6 +202,7 @@ func (a *ServiceReconciler) maybeProvision(ctx context.Context, logger *zap.Suga
 	a.mu.Lock()
 	if a.shouldExpose(svc) {
 		sts.ClusterTargetIP = svc.Spec.ClusterIP
+		sts.PreserveSourceIP = svc.Annotations[AnnotationPreserveSourceIP] == "true"
 		a.managedIngressProxies.Add(svc.UID)
 		gaugeIngressProxies.Set(int64(a.managedIngressProxies.Len()))
 	} else if ip := a.tailnetTargetAnnotation(svc); ip != "" {
@@ -0,0 +1,117 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+const (
+	reasonProxyDeviceLockedOut  = "ProxyDeviceLockedOut"
+	messageProxyDeviceLockedOut = "proxy is locked out by tailnet lock: %s"
+)
+
+// tailnetLockClient is the part of the operator's LocalAPI client that it
+// uses to sign proxies on tailnets with tailnet lock enabled.
+type tailnetLockClient interface {
+	NetworkLockStatus(ctx context.Context) (*ipnstate.NetworkLockStatus, error)
+	NetworkLockWrapPreauthKey(ctx context.Context, preauthKey string, tkaKey key.NLPrivate) (string, error)
+}
+
+// tailnetLockSigner signs the proxies that the operator creates on tailnets
+// with tailnet lock enabled, which would otherwise be locked out.
+//
+// The auth keys of proxies are wrapped with the signing key, like "tailscale
+// lock sign <auth-key>" does, so that proxies sign their node keys when they
+// log in.
+type tailnetLockSigner struct {
+	lc tailnetLockClient
+	// key is the tailnet lock key that proxies are signed with, or zero if
+	// none is configured.
+	key key.NLPrivate
+}
+
+// readTailnetLockKey reads a tailnet lock private key ("nlpriv:...") from the
+// named file.
+func readTailnetLockKey(name string) (key.NLPrivate, error) {
+	var k key.NLPrivate
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return k, err
+	}
+	if err := k.UnmarshalText(bytes.TrimSpace(b)); err != nil {
+		return k, fmt.Errorf("parsing tailnet lock key: %w", err)
+	}
+	return k, nil
+}
+
+// signAuthKey returns authKey wrapped with the signing key if tailnet lock is
+// enabled, or authKey as is if it isn't. It returns an error if tailnet lock
+// is enabled but proxies can't be signed.
+func (s *tailnetLockSigner) signAuthKey(ctx context.Context, authKey string) (string, error) {
+	st, err := s.lc.NetworkLockStatus(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error getting tailnet lock status: %w", err)
+	}
+	if !st.Enabled {
+		return authKey, nil
+	}
+	if err := s.checkKey(st); err != nil {
+		return "", err
+	}
+	wrapped, err := s.lc.NetworkLockWrapPreauthKey(ctx, authKey, s.key)
+	if err != nil {
+		return "", fmt.Errorf("error signing auth key: %w", err)
+	}
+	return wrapped, nil
+}
+
+// checkKey returns an error if proxies can't be signed with the signing key
+// under the tailnet lock described by st.
+func (s *tailnetLockSigner) checkKey(st *ipnstate.NetworkLockStatus) error {
+	if s.key.IsZero() {
+		return errors.New("tailnet lock is enabled, but the operator has no tailnet lock key to sign proxies with")
+	}
+	pub := s.key.Public()
+	for _, k := range st.TrustedKeys {
+		if k.Key == pub {
+			return nil
+		}
+	}
+	return fmt.Errorf("the operator's tailnet lock key %s is not trusted; add it with 'tailscale lock add'", pub.CLIString())
+}
+
+// lockedOutReason returns why the proxy device with the given ID is locked
+// out by tailnet lock, or "" if it isn't.
+func (s *tailnetLockSigner) lockedOutReason(ctx context.Context, id tailcfg.StableNodeID) (string, error) {
+	st, err := s.lc.NetworkLockStatus(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error getting tailnet lock status: %w", err)
+	}
+	if !st.Enabled {
+		return "", nil
+	}
+	for _, p := range st.FilteredPeers {
+		if p.StableID != id {
+			continue
+		}
+		if err := s.checkKey(st); err != nil {
+			return err.Error(), nil
+		}
+		// The proxy logged in with an auth key that wasn't signed,
+		// such as one created before tailnet lock was enabled.
+		return fmt.Sprintf("node key %v is not signed; delete the proxy's StatefulSet and state Secret to recreate it with a signed auth key", p.NodeKey), nil
+	}
+	return "", nil
+}
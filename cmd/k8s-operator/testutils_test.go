@@ -9,6 +9,7 @@
 	"context"
 	"encoding/json"
 	"net/netip"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
@@ -42,6 +43,7 @@ type configOpts struct {
 	tailnetTargetIP                                string
 	tailnetTargetFQDN                              string
 	clusterTargetIP                                string
+	preserveSourceIP                               bool
 	subnetRoutes                                   string
 	isExitNode                                     bool
 	shouldUseDeclarativeConfig                     bool // tailscaled in proxy should be configured using config file
@@ -60,6 +62,8 @@ func expectedSTS(t *testing.T, cl client.Client, opts configOpts) *appsv1.Statef
 			{Name: "TS_USERSPACE", Value: "false"},
 			{Name: "TS_AUTH_ONCE", Value: "true"},
 			{Name: "POD_IP", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{APIVersion: "", FieldPath: "status.podIP"}, ResourceFieldRef: nil, ConfigMapKeyRef: nil, SecretKeyRef: nil}},
+			{Name: "POD_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{APIVersion: "", FieldPath: "metadata.name"}, ResourceFieldRef: nil, ConfigMapKeyRef: nil, SecretKeyRef: nil}},
+			{Name: "POD_UID", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{APIVersion: "", FieldPath: "metadata.uid"}, ResourceFieldRef: nil, ConfigMapKeyRef: nil, SecretKeyRef: nil}},
 			{Name: "TS_KUBE_SECRET", Value: opts.secretName},
 		},
 		SecurityContext: &corev1.SecurityContext{
@@ -133,6 +137,12 @@ func expectedSTS(t *testing.T, cl client.Client, opts configOpts) *appsv1.Statef
 			Value: opts.clusterTargetIP,
 		})
 		annots["tailscale.com/operator-last-set-cluster-ip"] = opts.clusterTargetIP
+		if opts.preserveSourceIP {
+			tsContainer.Env = append(tsContainer.Env, corev1.EnvVar{
+				Name:  "TS_EXTRA_ARGS",
+				Value: "--snat-subnet-routes=false",
+			})
+		}
 	}
 	if opts.serveConfig != nil {
 		tsContainer.Env = append(tsContainer.Env, corev1.EnvVar{
@@ -217,6 +227,8 @@ func expectedSTSUserspace(t *testing.T, cl client.Client, opts configOpts) *apps
 		Env: []corev1.EnvVar{
 			{Name: "TS_USERSPACE", Value: "true"},
 			{Name: "TS_AUTH_ONCE", Value: "true"},
+			{Name: "POD_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{APIVersion: "", FieldPath: "metadata.name"}, ResourceFieldRef: nil, ConfigMapKeyRef: nil, SecretKeyRef: nil}},
+			{Name: "POD_UID", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{APIVersion: "", FieldPath: "metadata.uid"}, ResourceFieldRef: nil, ConfigMapKeyRef: nil, SecretKeyRef: nil}},
 			{Name: "TS_KUBE_SECRET", Value: opts.secretName},
 			{Name: "TS_HOSTNAME", Value: opts.hostname},
 			{Name: "TS_SERVE_CONFIG", Value: "/etc/tailscaled/serve-config"},
@@ -377,7 +389,7 @@ func findGenName(t *testing.T, client client.Client, ns, name, typ string) (full
 		LabelParentNamespace: ns,
 		LabelParentType:      typ,
 	}
-	s, err := getSingleObject[corev1.Secret](context.Background(), client, "operator-ns", labels)
+	s, err := getProxySecret(context.Background(), client, "operator-ns", labels)
 	if err != nil {
 		t.Fatalf("finding secret for %q: %v", name, err)
 	}
@@ -495,6 +507,7 @@ type fakeTSClient struct {
 	sync.Mutex
 	keyRequests []tailscale.KeyCapabilities
 	deleted     []string
+	routes      map[string]*tailscale.Routes // by device ID
 }
 type fakeTSNetServer struct {
 	certDomains []string
@@ -523,6 +536,34 @@ func (c *fakeTSClient) DeleteDevice(ctx context.Context, deviceID string) error
 	return nil
 }
 
+func (c *fakeTSClient) Routes(ctx context.Context, deviceID string) (*tailscale.Routes, error) {
+	c.Lock()
+	defer c.Unlock()
+	r, ok := c.routes[deviceID]
+	if !ok {
+		return &tailscale.Routes{}, nil
+	}
+	return &tailscale.Routes{
+		AdvertisedRoutes: slices.Clone(r.AdvertisedRoutes),
+		EnabledRoutes:    slices.Clone(r.EnabledRoutes),
+	}, nil
+}
+
+func (c *fakeTSClient) SetRoutes(ctx context.Context, deviceID string, subnets []netip.Prefix) (*tailscale.Routes, error) {
+	c.Lock()
+	defer c.Unlock()
+	r, ok := c.routes[deviceID]
+	if !ok {
+		r = &tailscale.Routes{}
+		mak.Set(&c.routes, deviceID, r)
+	}
+	r.EnabledRoutes = slices.Clone(subnets)
+	return &tailscale.Routes{
+		AdvertisedRoutes: slices.Clone(r.AdvertisedRoutes),
+		EnabledRoutes:    slices.Clone(r.EnabledRoutes),
+	}, nil
+}
+
 func (c *fakeTSClient) KeyRequests() []tailscale.KeyCapabilities {
 	c.Lock()
 	defer c.Unlock()
@@ -0,0 +1,251 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"tailscale.com/util/mak"
+)
+
+const (
+	// LabelExpose can be set to "true" by users on Deployments and
+	// StatefulSets to expose their Pods to the tailnet. The operator
+	// creates a Service for the workload, which is then exposed like a
+	// Service annotated with tailscale.com/expose. Unlike for Services,
+	// this is a label, so that the operator only needs to watch the
+	// workloads that have it.
+	LabelExpose = "tailscale.com/expose"
+
+	reasonWorkloadExposureFailed = "WorkloadExposureFailed"
+)
+
+// workloadServiceAnnotations are the annotations that are copied from an
+// exposed workload to its Service, to configure its proxy.
+var workloadServiceAnnotations = []string{AnnotationHostname, AnnotationTags, AnnotationPreserveSourceIP}
+
+// WorkloadReconciler exposes Deployments or StatefulSets labeled with
+// tailscale.com/expose to the tailnet. For each of them, it maintains a
+// ClusterIP Service that selects the workload's Pods, so that kube-proxy
+// keeps track of their endpoints, and that the ServiceReconciler exposes.
+type WorkloadReconciler struct {
+	client.Client
+
+	// workloads reads the exposed workloads. It's backed by a cache that
+	// only has the workloads labeled with tailscale.com/expose.
+	workloads client.Reader
+	// kind is the kind of workloads that are reconciled, "Deployment" or
+	// "StatefulSet".
+	kind string
+
+	recorder record.EventRecorder
+	logger   *zap.SugaredLogger
+}
+
+func (a *WorkloadReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	logger := a.logger.With("workload-kind", a.kind, "workload-ns", req.Namespace, "workload-name", req.Name)
+	logger.Debugf("starting reconcile")
+	defer logger.Debugf("reconcile finished")
+
+	w, err := a.newWorkload()
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	err = a.workloads.Get(ctx, req.NamespacedName, w)
+	if apierrors.IsNotFound(err) {
+		// The workload was deleted or is no longer labeled to be
+		// exposed.
+		return reconcile.Result{}, a.maybeCleanup(ctx, req.NamespacedName, logger)
+	} else if err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to get %s: %w", a.kind, err)
+	}
+	if w.GetLabels()[LabelExpose] != "true" {
+		return reconcile.Result{}, a.maybeCleanup(ctx, req.NamespacedName, logger)
+	}
+	if !w.GetDeletionTimestamp().IsZero() {
+		// The Service is garbage collected along with the workload.
+		return reconcile.Result{}, nil
+	}
+
+	svc, err := a.serviceForWorkload(w)
+	if err != nil {
+		msg := fmt.Sprintf("unable to expose %s: %v", a.kind, err)
+		logger.Info(msg)
+		a.recorder.Event(w, corev1.EventTypeWarning, reasonWorkloadExposureFailed, msg)
+		return reconcile.Result{}, nil
+	}
+	existing := new(corev1.Service)
+	err = a.Get(ctx, client.ObjectKeyFromObject(svc), existing)
+	if err == nil && !a.isServiceOf(existing, w.GetName()) {
+		msg := fmt.Sprintf("unable to expose %s: Service %s already exists and is not managed by the operator", a.kind, svc.Name)
+		logger.Info(msg)
+		a.recorder.Event(w, corev1.EventTypeWarning, reasonWorkloadExposureFailed, msg)
+		return reconcile.Result{}, nil
+	} else if err != nil && !apierrors.IsNotFound(err) {
+		return reconcile.Result{}, fmt.Errorf("failed to get Service: %w", err)
+	}
+	if _, err := createOrUpdate(ctx, a.Client, w.GetNamespace(), svc, func(s *corev1.Service) {
+		s.Spec.Selector = svc.Spec.Selector
+		s.Spec.Ports = svc.Spec.Ports
+		s.Labels = mergeManagedKeys(s.Labels, svc.Labels, []string{LabelProxyClass})
+		s.Annotations = mergeManagedKeys(s.Annotations, svc.Annotations, workloadServiceAnnotations)
+		s.Annotations[AnnotationExpose] = "true"
+	}); err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to create or update Service: %w", err)
+	}
+	logger.Debugf("Service %s for %s synced", svc.Name, a.kind)
+	return reconcile.Result{}, nil
+}
+
+// maybeCleanup deletes the Service of the workload nn, if there is one.
+func (a *WorkloadReconciler) maybeCleanup(ctx context.Context, nn types.NamespacedName, logger *zap.SugaredLogger) error {
+	svc := new(corev1.Service)
+	err := a.Get(ctx, types.NamespacedName{Namespace: nn.Namespace, Name: workloadServiceName(nn.Name)}, svc)
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get Service: %w", err)
+	}
+	if !a.isServiceOf(svc, nn.Name) {
+		return nil
+	}
+	// Deleting the Service makes the ServiceReconciler clean up its proxy.
+	if err := a.Delete(ctx, svc); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete Service: %w", err)
+	}
+	logger.Infof("%s is no longer exposed, deleted Service %s", a.kind, svc.Name)
+	return nil
+}
+
+// isServiceOf reports whether svc was created by a for the workload with
+// the given name.
+func (a *WorkloadReconciler) isServiceOf(svc *corev1.Service, name string) bool {
+	ref := metav1.GetControllerOf(svc)
+	return ref != nil && ref.APIVersion == appsv1.SchemeGroupVersion.String() && ref.Kind == a.kind && ref.Name == name
+}
+
+func (a *WorkloadReconciler) newWorkload() (client.Object, error) {
+	switch a.kind {
+	case "Deployment":
+		return new(appsv1.Deployment), nil
+	case "StatefulSet":
+		return new(appsv1.StatefulSet), nil
+	}
+	return nil, fmt.Errorf("unsupported workload kind %q", a.kind)
+}
+
+// serviceForWorkload returns the Service that exposes the Pods of w.
+func (a *WorkloadReconciler) serviceForWorkload(w client.Object) (*corev1.Service, error) {
+	var (
+		selector *metav1.LabelSelector
+		tmpl     corev1.PodTemplateSpec
+	)
+	switch w := w.(type) {
+	case *appsv1.Deployment:
+		selector, tmpl = w.Spec.Selector, w.Spec.Template
+	case *appsv1.StatefulSet:
+		selector, tmpl = w.Spec.Selector, w.Spec.Template
+	default:
+		return nil, fmt.Errorf("unsupported workload %T", w)
+	}
+	if selector == nil || len(selector.MatchLabels) == 0 {
+		return nil, fmt.Errorf("selector has no matchLabels")
+	}
+	if len(selector.MatchExpressions) > 0 {
+		return nil, fmt.Errorf("selector has matchExpressions, which a Service selector can't express")
+	}
+	ports := servicePortsForPodSpec(&tmpl.Spec)
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("no container declares a port")
+	}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            workloadServiceName(w.GetName()),
+			Namespace:       w.GetNamespace(),
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(w, appsv1.SchemeGroupVersion.WithKind(a.kind))},
+			Annotations:     map[string]string{AnnotationExpose: "true"},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeClusterIP,
+			Selector: selector.MatchLabels,
+			Ports:    ports,
+		},
+	}
+	if pc := w.GetLabels()[LabelProxyClass]; pc != "" {
+		mak.Set(&svc.Labels, LabelProxyClass, pc)
+	}
+	for _, k := range workloadServiceAnnotations {
+		if v, ok := w.GetAnnotations()[k]; ok {
+			svc.Annotations[k] = v
+		}
+	}
+	return svc, nil
+}
+
+// servicePortsForPodSpec returns a Service port for each port declared by
+// the containers of spec.
+func servicePortsForPodSpec(spec *corev1.PodSpec) []corev1.ServicePort {
+	var ports []corev1.ServicePort
+	seen := make(map[string]bool)
+	for _, c := range spec.Containers {
+		for _, p := range c.Ports {
+			proto := p.Protocol
+			if proto == "" {
+				proto = corev1.ProtocolTCP
+			}
+			key := fmt.Sprintf("%s/%d", proto, p.ContainerPort)
+			name := p.Name
+			if name == "" {
+				name = fmt.Sprintf("%s-%d", strings.ToLower(string(proto)), p.ContainerPort)
+			}
+			if seen[key] || seen[name] {
+				continue
+			}
+			seen[key], seen[name] = true, true
+			ports = append(ports, corev1.ServicePort{
+				Name:       name,
+				Protocol:   proto,
+				Port:       p.ContainerPort,
+				TargetPort: intstr.FromInt32(p.ContainerPort),
+			})
+		}
+	}
+	return ports
+}
+
+// workloadServiceName returns the name of the Service that exposes the
+// workload with the given name.
+func workloadServiceName(name string) string {
+	return name + "-tailscale"
+}
+
+// mergeManagedKeys returns cur with the keys in managed set to their value
+// in want, or removed if want doesn't have them.
+func mergeManagedKeys(cur, want map[string]string, managed []string) map[string]string {
+	if cur == nil {
+		cur = make(map[string]string)
+	}
+	for _, k := range managed {
+		if v, ok := want[k]; ok {
+			cur[k] = v
+		} else {
+			delete(cur, k)
+		}
+	}
+	return cur
+}
@@ -0,0 +1,153 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/util/mak"
+)
+
+func TestWorkloadReconciler(t *testing.T) {
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "default",
+			UID:       types.UID("1234-UID"),
+			Labels: map[string]string{
+				LabelExpose:     "true",
+				LabelProxyClass: "custom",
+			},
+			Annotations: map[string]string{
+				AnnotationHostname: "web",
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "web"},
+			},
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name: "web",
+							Ports: []corev1.ContainerPort{
+								{Name: "http", ContainerPort: 8080},
+								{ContainerPort: 9090},
+								{ContainerPort: 53, Protocol: corev1.ProtocolUDP},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(deploy).
+		Build()
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := record.NewFakeRecorder(1)
+	wr := &WorkloadReconciler{
+		Client:    fc,
+		workloads: fc,
+		kind:      "Deployment",
+		recorder:  fr,
+		logger:    zl.Sugar(),
+	}
+
+	// 1. A Service that selects the Deployment's Pods is created.
+	expectReconciled(t, wr, "default", "web")
+	want := &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "web-tailscale",
+			Namespace:       "default",
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(deploy, appsv1.SchemeGroupVersion.WithKind("Deployment"))},
+			Labels:          map[string]string{LabelProxyClass: "custom"},
+			Annotations: map[string]string{
+				AnnotationExpose:   "true",
+				AnnotationHostname: "web",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeClusterIP,
+			Selector: map[string]string{"app": "web"},
+			Ports: []corev1.ServicePort{
+				{Name: "http", Protocol: corev1.ProtocolTCP, Port: 8080, TargetPort: intstr.FromInt32(8080)},
+				{Name: "tcp-9090", Protocol: corev1.ProtocolTCP, Port: 9090, TargetPort: intstr.FromInt32(9090)},
+				{Name: "udp-53", Protocol: corev1.ProtocolUDP, Port: 53, TargetPort: intstr.FromInt32(53)},
+			},
+		},
+	}
+	expectEqual(t, fc, want)
+
+	// 2. Changes to the Deployment's configuration are applied to the
+	// Service, without clobbering the ServiceReconciler's changes.
+	mustUpdate(t, fc, "default", "web-tailscale", func(s *corev1.Service) {
+		s.Finalizers = append(s.Finalizers, FinalizerName)
+	})
+	mustUpdate(t, fc, "default", "web", func(d *appsv1.Deployment) {
+		delete(d.Labels, LabelProxyClass)
+		d.Annotations[AnnotationHostname] = "web-app"
+		d.Annotations[AnnotationTags] = "tag:web"
+	})
+	expectReconciled(t, wr, "default", "web")
+	want.Finalizers = []string{FinalizerName}
+	want.Labels = nil
+	want.Annotations[AnnotationHostname] = "web-app"
+	want.Annotations[AnnotationTags] = "tag:web"
+	expectEqual(t, fc, want)
+
+	// 3. The Service is deleted once the Deployment is no longer exposed.
+	mustUpdate(t, fc, "default", "web-tailscale", func(s *corev1.Service) {
+		s.Finalizers = nil
+	})
+	mustUpdate(t, fc, "default", "web", func(d *appsv1.Deployment) {
+		delete(d.Labels, LabelExpose)
+	})
+	expectReconciled(t, wr, "default", "web")
+	expectMissing[corev1.Service](t, fc, "default", "web-tailscale")
+
+	// 4. A Service not created by the operator is left alone.
+	mustCreate(t, fc, &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-tailscale",
+			Namespace: "default",
+		},
+	})
+	mustUpdate(t, fc, "default", "web", func(d *appsv1.Deployment) {
+		mak.Set(&d.Labels, LabelExpose, "true")
+	})
+	expectReconciled(t, wr, "default", "web")
+	wantEvent := "Warning WorkloadExposureFailed unable to expose Deployment: Service web-tailscale already exists and is not managed by the operator"
+	if got := <-fr.Events; got != wantEvent {
+		t.Errorf("got event %q, want %q", got, wantEvent)
+	}
+	svc := new(corev1.Service)
+	if err := fc.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "web-tailscale"}, svc); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.OwnerReferences) != 0 || svc.Annotations[AnnotationExpose] != "" {
+		t.Errorf("user Service was modified: %+v", svc)
+	}
+}
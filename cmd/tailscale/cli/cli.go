@@ -132,6 +132,7 @@ func Run(args []string) (err error) {
 			exitNodeCmd,
 			updateCmd,
 			whoisCmd,
+			reportCmd,
 		},
 		FlagSet:   rootfs,
 		Exec:      func(context.Context, []string) error { return flag.ErrHelp },
@@ -153,6 +154,12 @@ func Run(args []string) (err error) {
 	if runtime.GOOS == "linux" && distro.Get() == distro.Synology {
 		rootCmd.Subcommands = append(rootCmd.Subcommands, configureHostCmd)
 	}
+	completionCmd, completeCmd := completionCmds(rootCmd)
+	rootCmd.Subcommands = append(rootCmd.Subcommands, completionCmd)
+	if len(args) > 0 && args[0] == completeCmd.Name {
+		// Only used by the completion scripts.
+		rootCmd.Subcommands = append(rootCmd.Subcommands, completeCmd)
+	}
 
 	for _, c := range rootCmd.Subcommands {
 		if c.UsageFunc == nil {
@@ -1288,6 +1288,29 @@ func TestUpWorthWarning(t *testing.T) {
 	}
 }
 
+func TestCheckUpNotify(t *testing.T) {
+	prefs := ipn.NewPrefs()
+	prefs.AdvertiseRoutes = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}
+
+	// Routes waiting for approval don't stop "tailscale up".
+	warning, err := checkUpNotify(ipn.Notify{ErrorCode: ipn.ErrorCodeRoutesNotApproved}, prefs)
+	if err != nil {
+		t.Fatalf("unapproved routes: got error %v; want a warning", err)
+	}
+	if !strings.Contains(warning, prefs.AdminPageURL()) {
+		t.Errorf("unapproved routes: warning %q doesn't point to the admin console", warning)
+	}
+
+	msg := "something broke"
+	if _, err := checkUpNotify(ipn.Notify{ErrMessage: &msg, ErrorCode: ipn.ErrorCodeControl}, prefs); err == nil || err.Error() != msg {
+		t.Errorf("ErrMessage: got error %v; want %q", err, msg)
+	}
+
+	if warning, err := checkUpNotify(ipn.Notify{}, prefs); warning != "" || err != nil {
+		t.Errorf("empty Notify: got %q, %v; want nothing", warning, err)
+	}
+}
+
 func TestParseNLArgs(t *testing.T) {
 	tcs := []struct {
 		name              string
@@ -0,0 +1,225 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/util/dnsname"
+)
+
+const completionUsage = "completion <bash|zsh|fish>"
+
+// completionCmds returns the "completion" command, which prints the completion
+// script for a shell, and the hidden "__complete" command, which the scripts
+// run to complete the command line of root.
+func completionCmds(root *ffcli.Command) (completionCmd, completeCmd *ffcli.Command) {
+	completionCmd = &ffcli.Command{
+		Name:       "completion",
+		ShortUsage: completionUsage,
+		ShortHelp:  "Print a shell completion script",
+		LongHelp: strings.TrimSpace(`
+"tailscale completion" prints a script that makes the shell complete
+the subcommands and flags of tailscale, and the names of exit nodes and
+accounts, as they're typed.
+
+For bash, add this to ~/.bashrc:
+
+	source <(tailscale completion bash)
+
+For zsh, add this to ~/.zshrc:
+
+	source <(tailscale completion zsh)
+
+For fish, run:
+
+	tailscale completion fish > ~/.config/fish/completions/tailscale.fish
+`),
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("usage: tailscale %s", completionUsage)
+			}
+			script, ok := completionScripts[args[0]]
+			if !ok {
+				return fmt.Errorf("unsupported shell %q; want bash, zsh or fish", args[0])
+			}
+			outln(script)
+			return nil
+		},
+	}
+	completeCmd = &ffcli.Command{
+		Name:       "__complete",
+		ShortUsage: "__complete -- [<word>...] <current word>",
+		ShortHelp:  "Complete a command line",
+		Exec: func(ctx context.Context, args []string) error {
+			for _, c := range complete(ctx, root, args) {
+				outln(c)
+			}
+			return nil
+		},
+	}
+	return completionCmd, completeCmd
+}
+
+// completionScripts are the completion scripts for each shell. Each of them
+// runs "tailscale __complete" with the words of the command line up to the
+// cursor, without the leading "tailscale", and offers its output lines.
+var completionScripts = map[string]string{
+	"bash": `_tailscale() {
+	local IFS=$'\n'
+	COMPREPLY=($(tailscale __complete -- "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _tailscale tailscale`,
+
+	"zsh": `#compdef tailscale
+_tailscale() {
+	local -a completions
+	completions=("${(@f)$(tailscale __complete -- "${(@)words[2,$CURRENT]}" 2>/dev/null)}")
+	compadd -Q -- $completions
+}
+compdef _tailscale tailscale`,
+
+	"fish": `complete -c tailscale -f -a '(tailscale __complete -- (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)'`,
+}
+
+// dynamicCompletions complete values that depend on the state of tailscaled,
+// keyed by the path of the subcommand, followed by the name of a flag for
+// its values or nothing for its arguments.
+var dynamicCompletions = map[string]func(ctx context.Context) []string{
+	"up --exit-node":  completeExitNodes,
+	"set --exit-node": completeExitNodes,
+	"switch":          completeProfiles,
+}
+
+// complete returns the completions of the last of words, the command line
+// after the name of root's command, given the words before it.
+func complete(ctx context.Context, root *ffcli.Command, words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cur := words[len(words)-1]
+	cmd, path := root, []string(nil)
+	var valueOf string // name of the flag whose value is the next word
+	for _, w := range words[:len(words)-1] {
+		switch {
+		case valueOf != "" && w == "=":
+			// bash splits --flag=value into three words.
+		case valueOf != "":
+			valueOf = ""
+		case strings.HasPrefix(w, "-") && w != "-" && w != "--":
+			name, _, hasValue := strings.Cut(strings.TrimLeft(w, "-"), "=")
+			if f := lookupFlag(cmd, name); f != nil && !isBoolFlag(f) && !hasValue {
+				valueOf = name
+			}
+		default:
+			if sub := findSubcommand(cmd, w); sub != nil {
+				cmd = sub
+				path = append(path, sub.Name)
+			}
+		}
+	}
+
+	var cands []string
+	prefix := cur
+	switch {
+	case valueOf != "":
+		cands = dynamicValues(ctx, path, "--"+valueOf)
+		if cur == "=" {
+			prefix = ""
+		}
+	case strings.HasPrefix(cur, "-") && strings.Contains(cur, "="):
+		name, value, _ := strings.Cut(cur, "=")
+		for _, v := range dynamicValues(ctx, path, "--"+strings.TrimLeft(name, "-")) {
+			cands = append(cands, name+"="+v)
+		}
+		prefix = name + "=" + value
+	case strings.HasPrefix(cur, "-"):
+		if cmd.FlagSet != nil {
+			cmd.FlagSet.VisitAll(func(f *flag.Flag) {
+				if !strings.HasPrefix(f.Usage, "HIDDEN: ") {
+					cands = append(cands, "--"+f.Name)
+				}
+			})
+		}
+	default:
+		for _, sub := range cmd.Subcommands {
+			if !strings.HasPrefix(sub.ShortHelp, "HIDDEN: ") {
+				cands = append(cands, sub.Name)
+			}
+		}
+		cands = append(cands, dynamicValues(ctx, path, "")...)
+	}
+
+	var res []string
+	for _, c := range cands {
+		if strings.HasPrefix(c, prefix) && !slices.Contains(res, c) {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func lookupFlag(cmd *ffcli.Command, name string) *flag.Flag {
+	if cmd.FlagSet == nil {
+		return nil
+	}
+	return cmd.FlagSet.Lookup(name)
+}
+
+func findSubcommand(cmd *ffcli.Command, name string) *ffcli.Command {
+	for _, sub := range cmd.Subcommands {
+		if strings.EqualFold(sub.Name, name) {
+			return sub
+		}
+	}
+	return nil
+}
+
+// dynamicValues returns the values of the given flag of the subcommand at
+// path, or of its arguments if flagName is empty.
+func dynamicValues(ctx context.Context, path []string, flagName string) []string {
+	key := strings.Join(path, " ")
+	if flagName != "" {
+		key += " " + flagName
+	}
+	if fn, ok := dynamicCompletions[key]; ok {
+		return fn(ctx)
+	}
+	return nil
+}
+
+// completeExitNodes returns the base names of the peers that offer to be exit
+// nodes.
+func completeExitNodes(ctx context.Context) []string {
+	st, err := localClient.Status(ctx)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, ps := range st.Peer {
+		if ps.ExitNodeOption {
+			names = append(names, dnsname.TrimSuffix(ps.DNSName, st.MagicDNSSuffix))
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// completeProfiles returns the account names of the profiles.
+func completeProfiles(ctx context.Context) []string {
+	_, all, err := localClient.ProfileStatus(ctx)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, p := range all {
+		names = append(names, p.Name)
+	}
+	return names
+}
@@ -0,0 +1,63 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/tstest"
+)
+
+func TestComplete(t *testing.T) {
+	tstest.Replace(t, &dynamicCompletions, map[string]func(context.Context) []string{
+		"up --exit-node": func(context.Context) []string { return []string{"exit1", "exit2", "other"} },
+		"switch":         func(context.Context) []string { return []string{"alice@example.com"} },
+	})
+	rootfs := flag.NewFlagSet("tailscale", flag.ContinueOnError)
+	rootfs.String("socket", "", "")
+	upfs := flag.NewFlagSet("up", flag.ContinueOnError)
+	upfs.String("exit-node", "", "")
+	upfs.Bool("exit-node-allow-lan-access", false, "")
+	upfs.Bool("ssh", false, "")
+	upfs.String("secret", "", "HIDDEN: not offered")
+	root := &ffcli.Command{
+		Name:    "tailscale",
+		FlagSet: rootfs,
+		Subcommands: []*ffcli.Command{
+			{Name: "up", FlagSet: upfs},
+			{Name: "status"},
+			{Name: "switch"},
+		},
+	}
+
+	tests := []struct {
+		words string
+		want  []string
+	}{
+		{"", []string{"up", "status", "switch"}},
+		{"s", []string{"status", "switch"}},
+		{"--socket /tmp/sock s", []string{"status", "switch"}},
+		{"up --", []string{"--exit-node", "--exit-node-allow-lan-access", "--ssh"}},
+		{"up --ss", []string{"--ssh"}},
+		{"up --exit-node ", []string{"exit1", "exit2", "other"}},
+		{"up --exit-node e", []string{"exit1", "exit2"}},
+		{"up --exit-node=e", []string{"--exit-node=exit1", "--exit-node=exit2"}},
+		{"up --exit-node = e", []string{"exit1", "exit2"}},
+		{"up --exit-node =", []string{"exit1", "exit2", "other"}},
+		{"up --ssh e", nil},
+		{"switch a", []string{"alice@example.com"}},
+	}
+	for _, tt := range tests {
+		words := strings.Split(tt.words, " ")
+		got := complete(context.Background(), root, words)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("complete(%q) = %q, want %q", tt.words, got, tt.want)
+		}
+	}
+}
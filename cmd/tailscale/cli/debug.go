@@ -38,6 +38,8 @@
 	"tailscale.com/paths"
 	"tailscale.com/safesocket"
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/types/ipproto"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/must"
@@ -89,6 +91,27 @@
 				return fs
 			})(),
 		},
+		{
+			Name:      "dns-cache-flush",
+			Exec:      runDNSCacheFlush,
+			ShortHelp: "drop the DNS responses cached by tailscaled's DNS resolver",
+		},
+		{
+			Name:      "dns-failover",
+			Exec:      runDNSFailover,
+			ShortHelp: "print the health of the resolvers of split DNS failover routes",
+		},
+		{
+			Name:      "dns-query-log",
+			Exec:      runDNSQueryLog,
+			ShortHelp: "watch the DNS queries handled by tailscaled's DNS resolver",
+			LongHelp:  "Requires the query log to be enabled with 'tailscale set --dns-query-log'.",
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("dns-query-log")
+				fs.BoolVar(&dnsQueryLogArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
 		{
 			Name:      "metrics",
 			Exec:      runDaemonMetrics,
@@ -284,6 +307,17 @@
 				return fs
 			})(),
 		},
+		{
+			Name:       "check-access",
+			Exec:       runDebugCheckAccess,
+			ShortHelp:  "reports whether this node's packet filter permits the given incoming traffic",
+			ShortUsage: "tailscale debug check-access [--proto=tcp] <src-hostname-or-IP> <dst-IP> <port>",
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("check-access")
+				fs.StringVar(&debugCheckAccessArgs.proto, "proto", "tcp", `IP protocol name or number ("tcp", "udp", "icmp", etc.)`)
+				return fs
+			})(),
+		},
 	},
 }
 
@@ -609,6 +643,69 @@ func runDaemonLogs(ctx context.Context, args []string) error {
 	}
 }
 
+func runDNSCacheFlush(ctx context.Context, args []string) error {
+	n, err := localClient.FlushDNSCache(ctx)
+	if err != nil {
+		return err
+	}
+	printf("flushed %d cached DNS responses\n", n)
+	return nil
+}
+
+func runDNSFailover(ctx context.Context, args []string) error {
+	routes, err := localClient.DNSFailoverStatus(ctx)
+	if err != nil {
+		return err
+	}
+	if len(routes) == 0 {
+		outln("no split DNS failover routes")
+		return nil
+	}
+	for _, rt := range routes {
+		printf("%s (using %s)\n", rt.Suffix, rt.Active)
+		for _, r := range rt.Resolvers {
+			if r.Healthy {
+				printf("\t%s: healthy\n", r.Addr)
+				continue
+			}
+			printf("\t%s: unhealthy since %s: %s\n", r.Addr, r.DownSince.Local().Format(time.DateTime), r.LastError)
+		}
+	}
+	return nil
+}
+
+var dnsQueryLogArgs struct {
+	json bool
+}
+
+func runDNSQueryLog(ctx context.Context, args []string) error {
+	r, err := localClient.TailDNSQueryLog(ctx)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(r)
+	for {
+		var e dnstype.QueryLogEntry
+		if err := d.Decode(&e); err != nil {
+			return err
+		}
+		if dnsQueryLogArgs.json {
+			j, _ := json.Marshal(e)
+			outln(string(j))
+			continue
+		}
+		via := "local"
+		if e.Upstream != "" {
+			via = e.Upstream
+		}
+		result := e.RCode
+		if e.Error != "" {
+			result = "error: " + e.Error
+		}
+		printf("%s %-5s %s via %s in %v: %s\n", e.Time.Local().Format("15:04:05.000"), e.Type, e.Name, via, e.Latency.Round(time.Millisecond), result)
+	}
+}
+
 var metricsArgs struct {
 	watch bool
 }
@@ -1083,3 +1180,47 @@ func runDebugDialTypes(ctx context.Context, args []string) error {
 	fmt.Printf("%s", body)
 	return nil
 }
+
+var debugCheckAccessArgs struct {
+	proto string
+}
+
+func runDebugCheckAccess(ctx context.Context, args []string) error {
+	if len(args) != 3 {
+		return errors.New("usage: check-access <src-hostname-or-IP> <dst-IP> <port>")
+	}
+	srcStr, _, err := tailscaleIPFromArg(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	src, err := netip.ParseAddr(srcStr)
+	if err != nil {
+		return fmt.Errorf("invalid source %q: %w", args[0], err)
+	}
+	dst, err := netip.ParseAddr(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid destination %q: %w", args[1], err)
+	}
+	port, err := strconv.ParseUint(args[2], 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q: %w", args[2], err)
+	}
+	var proto ipproto.Proto
+	if err := proto.UnmarshalText([]byte(debugCheckAccessArgs.proto)); err != nil {
+		return err
+	}
+
+	res, err := localClient.DebugPacketFilterCheck(ctx, src, dst, uint16(port), proto)
+	if err != nil {
+		return err
+	}
+	if res.Allowed {
+		printf("allowed (%s)\n", res.Reason)
+	} else {
+		printf("denied (%s)\n", res.Reason)
+	}
+	if res.Rule != "" {
+		printf("matching rule: %s\n", res.Rule)
+	}
+	return nil
+}
@@ -13,9 +13,12 @@
 	"slices"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	xmaps "golang.org/x/exp/maps"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
 )
@@ -37,6 +40,26 @@
 				return fs
 			})(),
 		},
+		{
+			Name:       "suggest",
+			ShortUsage: "exit-node suggest [flags]",
+			ShortHelp:  "Suggest the best available exit nodes",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale exit-node suggest' command ranks the online exit nodes by
+their latency, measured with pings for the closest ones and estimated from
+their home DERP region for the rest. Of several exit nodes in the same city,
+only those with the highest priority are suggested.
+
+With --apply, the best exit node is also selected for use.
+`),
+			Exec: runExitNodeSuggest,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("suggest")
+				fs.BoolVar(&exitNodeArgs.apply, "apply", false, "use the best exit node")
+				fs.IntVar(&exitNodeArgs.limit, "limit", 5, "maximum number of exit nodes to show; 0 means all")
+				return fs
+			})(),
+		},
 	},
 	Exec: func(context.Context, []string) error {
 		return errors.New("exit-node subcommand required; run 'tailscale exit-node -h' for details")
@@ -45,6 +68,8 @@
 
 var exitNodeArgs struct {
 	filter string
+	apply  bool
+	limit  int
 }
 
 // runExitNodeList returns a formatted list of exit nodes for a tailnet.
@@ -102,6 +127,72 @@ func runExitNodeList(ctx context.Context, args []string) error {
 	return nil
 }
 
+// runExitNodeSuggest lists the exit nodes suggested by tailscaled, best
+// first, and optionally starts using the best one.
+func runExitNodeSuggest(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected non-flag arguments to 'tailscale exit-node suggest'")
+	}
+	sugs, err := localClient.SuggestExitNodes(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if len(sugs) == 0 {
+		return errors.New("no exit nodes found")
+	}
+
+	shown := sugs
+	if exitNodeArgs.limit > 0 && len(shown) > exitNodeArgs.limit {
+		shown = shown[:exitNodeArgs.limit]
+	}
+	w := tabwriter.NewWriter(Stdout, 10, 5, 5, ' ', 0)
+	fmt.Fprintf(w, "\n %s\t%s\t%s\t%s\t", "HOSTNAME", "COUNTRY", "CITY", "LATENCY")
+	for _, s := range shown {
+		country, city := noLocationData, noLocationData
+		if s.Location != nil {
+			country, city = s.Location.Country, s.Location.City
+		}
+		fmt.Fprintf(w, "\n %s\t%s\t%s\t%s\t", s.Name, country, city, suggestionLatency(s))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w)
+	w.Flush()
+
+	best := sugs[0]
+	if best.LatencySource == "unreachable" {
+		return errors.New("no reachable exit nodes found")
+	}
+	if !exitNodeArgs.apply {
+		outln("# To use the best exit node, run `tailscale set --exit-node=" + best.Name + "`")
+		return nil
+	}
+	if _, err := localClient.EditPrefs(ctx, &ipn.MaskedPrefs{
+		Prefs: ipn.Prefs{
+			ExitNodeID: best.ID,
+		},
+		ExitNodeIDSet: true,
+		ExitNodeIPSet: true,
+	}); err != nil {
+		return err
+	}
+	printf("Using exit node %s\n", best.Name)
+	return nil
+}
+
+// suggestionLatency formats the latency of an exit node suggestion, noting
+// when it's estimated rather than measured.
+func suggestionLatency(s apitype.ExitNodeSuggestion) string {
+	switch s.LatencySource {
+	case "ping":
+		return s.Latency.Round(time.Millisecond / 10).String()
+	case "derp":
+		return "~" + s.Latency.Round(time.Millisecond/10).String() + " (est.)"
+	case "unreachable":
+		return "unreachable"
+	}
+	return "-"
+}
+
 // peerStatus returns a string representing the current state of
 // a peer. If there is no notable state, a - is returned.
 func peerStatus(peer *ipnstate.PeerStatus) string {
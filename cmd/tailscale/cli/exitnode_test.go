@@ -5,9 +5,11 @@
 
 import (
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/key"
@@ -306,3 +308,20 @@ func TestSortByCityName(t *testing.T) {
 		t.Fatalf("sortByCityName did not order cities by alphabetical order, got %v, want %v", fc[0].Name, noLocationData)
 	}
 }
+
+func TestSuggestionLatency(t *testing.T) {
+	tests := []struct {
+		sug  apitype.ExitNodeSuggestion
+		want string
+	}{
+		{apitype.ExitNodeSuggestion{Latency: 12345678, LatencySource: "ping"}, "12.3ms"},
+		{apitype.ExitNodeSuggestion{Latency: 30 * time.Millisecond, LatencySource: "derp"}, "~30ms (est.)"},
+		{apitype.ExitNodeSuggestion{LatencySource: "unreachable"}, "unreachable"},
+		{apitype.ExitNodeSuggestion{}, "-"},
+	}
+	for _, tt := range tests {
+		if got := suggestionLatency(tt.sug); got != tt.want {
+			t.Errorf("suggestionLatency(%+v) = %q; want %q", tt.sug, got, tt.want)
+		}
+	}
+}
@@ -34,7 +34,9 @@
 	FlagSet: (func() *flag.FlagSet {
 		fs := newFlagSet("netcheck")
 		fs.StringVar(&netcheckArgs.format, "format", "", `output format; empty (for human-readable), "json" or "json-line"`)
+		fs.BoolVar(&netcheckArgs.json, "json", false, `output in JSON format; same as --format=json, or --format=json-line with --watch`)
 		fs.DurationVar(&netcheckArgs.every, "every", 0, "if non-zero, do an incremental report with the given frequency")
+		fs.BoolVar(&netcheckArgs.watch, "watch", false, fmt.Sprintf("keep running, doing an incremental report at the frequency of --every (default %v) and whenever the network changes; failed reports are logged rather than fatal", defaultNetcheckWatchEvery))
 		fs.BoolVar(&netcheckArgs.verbose, "verbose", false, "verbose logs")
 		return fs
 	})(),
@@ -42,10 +44,16 @@
 
 var netcheckArgs struct {
 	format  string
+	json    bool
 	every   time.Duration
+	watch   bool
 	verbose bool
 }
 
+// defaultNetcheckWatchEvery is how often "tailscale netcheck --watch" reports
+// if --every isn't set.
+const defaultNetcheckWatchEvery = time.Minute
+
 func runNetcheck(ctx context.Context, args []string) error {
 	logf := logger.WithPrefix(log.Printf, "portmap: ")
 	netMon, err := netmon.New(logf)
@@ -63,43 +71,177 @@ func runNetcheck(ctx context.Context, args []string) error {
 		c.Logf = logger.Discard
 	}
 
+	if netcheckArgs.json {
+		if netcheckArgs.format != "" && !strings.HasPrefix(netcheckArgs.format, "json") {
+			return fmt.Errorf("--json conflicts with --format=%s", netcheckArgs.format)
+		}
+		if netcheckArgs.format == "" {
+			netcheckArgs.format = "json"
+		}
+	}
+	every := netcheckArgs.every
+	if netcheckArgs.watch {
+		if every == 0 {
+			every = defaultNetcheckWatchEvery
+		}
+		// Emit one report per line, so they can be consumed as they come.
+		if netcheckArgs.format == "json" {
+			netcheckArgs.format = "json-line"
+		}
+	}
+
 	if strings.HasPrefix(netcheckArgs.format, "json") {
 		fmt.Fprintln(Stderr, "# Warning: this JSON format is not yet considered a stable interface")
 	}
 
-	if err := c.Standalone(ctx, envknob.String("TS_DEBUG_NETCHECK_UDP_BIND")); err != nil {
-		fmt.Fprintln(Stderr, "netcheck: UDP test failure:", err)
+	getDERPMap := func(ctx context.Context) (*tailcfg.DERPMap, error) {
+		dm, err := localClient.CurrentDERPMap(ctx)
+		noRegions := dm != nil && len(dm.Regions) == 0
+		if noRegions {
+			log.Printf("No DERP map from tailscaled; using default.")
+		}
+		if err != nil || noRegions {
+			hc := &http.Client{Transport: tlsdial.NewTransport()}
+			return prodDERPMap(ctx, hc)
+		}
+		return dm, nil
 	}
 
-	dm, err := localClient.CurrentDERPMap(ctx)
-	noRegions := dm != nil && len(dm.Regions) == 0
-	if noRegions {
-		log.Printf("No DERP map from tailscaled; using default.")
-	}
-	if err != nil || noRegions {
-		hc := &http.Client{Transport: tlsdial.NewTransport()}
-		dm, err = prodDERPMap(ctx, hc)
+	if !netcheckArgs.watch {
+		if err := c.Standalone(ctx, envknob.String("TS_DEBUG_NETCHECK_UDP_BIND")); err != nil {
+			fmt.Fprintln(Stderr, "netcheck: UDP test failure:", err)
+		}
+		dm, err := getDERPMap(ctx)
 		if err != nil {
 			return err
 		}
+		for {
+			t0 := time.Now()
+			report, err := c.GetReport(ctx, dm, nil)
+			d := time.Since(t0)
+			if netcheckArgs.verbose {
+				c.Logf("GetReport took %v; err=%v", d.Round(time.Millisecond), err)
+			}
+			if err != nil {
+				return fmt.Errorf("netcheck: %w", err)
+			}
+			if err := printReport(dm, report); err != nil {
+				return err
+			}
+			if every == 0 {
+				return nil
+			}
+			time.Sleep(every)
+		}
+	}
+
+	netMon.Start()
+	defer netMon.Close()
+	w := &netcheckWatcher{
+		c:            c,
+		netMon:       netMon,
+		logf:         c.Logf,
+		bindAddr:     envknob.String("TS_DEBUG_NETCHECK_UDP_BIND"),
+		every:        every,
+		derpMapEvery: netcheckDERPMapRefreshInterval,
+		getDERPMap:   getDERPMap,
+		report:       printReport,
+	}
+	return w.run(ctx)
+}
+
+// netcheckDERPMapRefreshInterval is how often "tailscale netcheck --watch"
+// fetches the DERP map again, so that long-running watches pick up changes
+// to it.
+const netcheckDERPMapRefreshInterval = 10 * time.Minute
+
+// netcheckClient is the part of *netcheck.Client used by netcheckWatcher.
+type netcheckClient interface {
+	Standalone(ctx context.Context, bindAddr string) error
+	GetReport(ctx context.Context, dm *tailcfg.DERPMap, opts *netcheck.GetReportOpts) (*netcheck.Report, error)
+	MakeNextReportFull()
+}
+
+// netcheckWatcher implements "tailscale netcheck --watch". It reports every
+// so often and whenever the network changes, in which case it binds new UDP
+// sockets and does a full report, as the previous one no longer applies.
+type netcheckWatcher struct {
+	c            netcheckClient
+	netMon       *netmon.Monitor
+	logf         logger.Logf
+	bindAddr     string
+	every        time.Duration // how often to report
+	derpMapEvery time.Duration // how often to fetch the DERP map again
+	getDERPMap   func(context.Context) (*tailcfg.DERPMap, error)
+	report       func(*tailcfg.DERPMap, *netcheck.Report) error
+}
+
+// run reports until ctx is done or report fails. Failures to get a report
+// or to refresh the DERP map are logged to Stderr rather than fatal.
+func (w *netcheckWatcher) run(ctx context.Context) error {
+	netChanged := make(chan struct{}, 1)
+	unregister := w.netMon.RegisterChangeCallback(func(delta *netmon.ChangeDelta) {
+		if !delta.Major {
+			return
+		}
+		select {
+		case netChanged <- struct{}{}:
+		default:
+		}
+	})
+	defer unregister()
+
+	// The UDP sockets are bound to the network as it was when they were
+	// created, so they're replaced when it changes.
+	var closeSockets context.CancelFunc
+	bind := func() {
+		if closeSockets != nil {
+			closeSockets()
+		}
+		var sctx context.Context
+		sctx, closeSockets = context.WithCancel(ctx)
+		if err := w.c.Standalone(sctx, w.bindAddr); err != nil {
+			fmt.Fprintln(Stderr, "netcheck: UDP test failure:", err)
+		}
+	}
+	bind()
+	defer func() { closeSockets() }()
+
+	dm, err := w.getDERPMap(ctx)
+	if err != nil {
+		return err
 	}
+	lastDERPMap := time.Now()
 	for {
-		t0 := time.Now()
-		report, err := c.GetReport(ctx, dm, nil)
-		d := time.Since(t0)
-		if netcheckArgs.verbose {
-			c.Logf("GetReport took %v; err=%v", d.Round(time.Millisecond), err)
+		if time.Since(lastDERPMap) >= w.derpMapEvery {
+			if newDM, err := w.getDERPMap(ctx); err != nil {
+				fmt.Fprintf(Stderr, "netcheck: refreshing DERP map: %v\n", err)
+			} else {
+				dm = newDM
+				lastDERPMap = time.Now()
+			}
 		}
+		t0 := time.Now()
+		report, err := w.c.GetReport(ctx, dm, nil)
+		w.logf("GetReport took %v; err=%v", time.Since(t0).Round(time.Millisecond), err)
 		if err != nil {
-			return fmt.Errorf("netcheck: %w", err)
-		}
-		if err := printReport(dm, report); err != nil {
+			fmt.Fprintf(Stderr, "netcheck: %v\n", err)
+		} else if err := w.report(dm, report); err != nil {
 			return err
 		}
-		if netcheckArgs.every == 0 {
+
+		timer := time.NewTimer(w.every)
+		select {
+		case <-timer.C:
+		case <-netChanged:
+			timer.Stop()
+			w.logf("network changed, doing a full report")
+			bind()
+			w.c.MakeNextReportFull()
+		case <-ctx.Done():
+			timer.Stop()
 			return nil
 		}
-		time.Sleep(netcheckArgs.every)
 	}
 }
 
@@ -0,0 +1,136 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/net/interfaces"
+	"tailscale.com/net/netcheck"
+	"tailscale.com/net/netmon"
+	"tailscale.com/tailcfg"
+)
+
+// fakeNetcheckClient is a netcheckClient that records what it's asked to do.
+type fakeNetcheckClient struct {
+	mu       sync.Mutex
+	binds    []context.Context // of each call to Standalone
+	nextFull bool
+	fulls    int
+}
+
+func (c *fakeNetcheckClient) Standalone(ctx context.Context, bindAddr string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.binds = append(c.binds, ctx)
+	return nil
+}
+
+func (c *fakeNetcheckClient) GetReport(ctx context.Context, dm *tailcfg.DERPMap, opts *netcheck.GetReportOpts) (*netcheck.Report, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.nextFull {
+		c.fulls++
+		c.nextFull = false
+	}
+	return &netcheck.Report{}, nil
+}
+
+func (c *fakeNetcheckClient) MakeNextReportFull() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextFull = true
+}
+
+func TestNetcheckWatch(t *testing.T) {
+	netMon := netmon.NewStatic(nil)
+	c := new(fakeNetcheckClient)
+	var (
+		mu       sync.Mutex
+		derpMaps int
+	)
+	reports := make(chan *tailcfg.DERPMap)
+	w := &netcheckWatcher{
+		c:            c,
+		netMon:       netMon,
+		logf:         t.Logf,
+		every:        time.Hour,
+		derpMapEvery: time.Hour,
+		getDERPMap: func(context.Context) (*tailcfg.DERPMap, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			derpMaps++
+			if derpMaps == 2 {
+				return nil, errors.New("no DERP map for you")
+			}
+			return &tailcfg.DERPMap{Regions: map[int]*tailcfg.DERPRegion{derpMaps: {}}}, nil
+		},
+		report: func(dm *tailcfg.DERPMap, _ *netcheck.Report) error {
+			reports <- dm
+			return nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.run(ctx) }()
+
+	nextReport := func() *tailcfg.DERPMap {
+		t.Helper()
+		select {
+		case dm := <-reports:
+			return dm
+		case <-time.After(10 * time.Second):
+			t.Fatal("timeout waiting for a report")
+			return nil
+		}
+	}
+	check := func(wantBinds, wantFulls int) {
+		t.Helper()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		open := 0
+		for _, ctx := range c.binds {
+			if ctx.Err() == nil {
+				open++
+			}
+		}
+		if len(c.binds) != wantBinds || open != 1 || c.fulls != wantFulls {
+			t.Errorf("got %d binds with %d sockets open and %d full reports; want %d binds with 1 socket open and %d full reports",
+				len(c.binds), open, c.fulls, wantBinds, wantFulls)
+		}
+	}
+
+	if dm := nextReport(); dm.Regions[1] == nil {
+		t.Errorf("first report used DERP map %v, want the first one", dm.Regions)
+	}
+	check(1, 0)
+
+	// A change of the network rebinds the sockets and forces a full
+	// report.
+	netMon.SetStateForTest(&interfaces.State{HaveV4: true})
+	nextReport()
+	check(2, 1)
+
+	// Once it's stale, the DERP map is fetched again before reporting,
+	// keeping the old one if that fails.
+	w.derpMapEvery = 0
+	netMon.SetStateForTest(&interfaces.State{HaveV6: true})
+	if dm := nextReport(); dm.Regions[1] == nil {
+		t.Errorf("report used DERP map %v after failing to refresh it, want the first one", dm.Regions)
+	}
+	netMon.SetStateForTest(&interfaces.State{})
+	if dm := nextReport(); dm.Regions[3] == nil {
+		t.Errorf("report used DERP map %v, want the refreshed one", dm.Regions)
+	}
+	check(4, 3)
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("run: %v", err)
+	}
+}
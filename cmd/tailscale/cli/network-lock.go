@@ -4,6 +4,7 @@
 package cli
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/rand"
@@ -12,6 +13,7 @@
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"strings"
@@ -20,6 +22,7 @@
 	"github.com/mattn/go-colorable"
 	"github.com/mattn/go-isatty"
 	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tka"
 	"tailscale.com/types/key"
@@ -435,17 +438,33 @@ func runNetworkLockModify(ctx context.Context, addArgs, removeArgs []string) err
 	return nil
 }
 
+var nlSignArgs struct {
+	fromFile string
+}
+
 var nlSignCmd = &ffcli.Command{
 	Name:       "sign",
-	ShortUsage: "sign <node-key> [<rotation-key>] or sign <auth-key>",
+	ShortUsage: "sign <node-key> [<rotation-key>] or sign <auth-key> or sign --from-file <file>",
 	ShortHelp:  "Signs a node or pre-approved auth key",
 	LongHelp: `Either:
   - signs a node key and transmits the signature to the coordination server, or
-  - signs a pre-approved auth key, printing it in a form that can be used to bring up nodes under tailnet lock`,
+  - signs a pre-approved auth key, printing it in a form that can be used to bring up nodes under tailnet lock, or
+  - signs the node keys listed in a file, one "<node-key> [<rotation-key>]" per line`,
 	Exec: runNetworkLockSign,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("lock sign")
+		fs.StringVar(&nlSignArgs.fromFile, "from-file", "", `sign the node keys listed in this file ("-" for stdin), continuing past failures`)
+		return fs
+	})(),
 }
 
 func runNetworkLockSign(ctx context.Context, args []string) error {
+	if nlSignArgs.fromFile != "" {
+		if len(args) > 0 {
+			return errors.New("usage: lock sign --from-file <file>")
+		}
+		return runNetworkLockSignFromFile(ctx, nlSignArgs.fromFile)
+	}
 	if len(args) > 0 && strings.HasPrefix(args[0], "tskey-auth-") {
 		return runTskeyWrapCmd(ctx, args)
 	}
@@ -479,6 +498,73 @@ func runNetworkLockSign(ctx context.Context, args []string) error {
 	return err
 }
 
+// runNetworkLockSignFromFile signs the node keys listed in the named file, or
+// in stdin if it's "-".
+func runNetworkLockSignFromFile(ctx context.Context, name string) error {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	reqs, err := parseNLSignList(r)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", name, err)
+	}
+	if len(reqs) == 0 {
+		return fmt.Errorf("no node keys in %s", name)
+	}
+
+	failed, err := localClient.NetworkLockSignBulk(ctx, reqs)
+	if err != nil {
+		return err
+	}
+	for _, req := range reqs {
+		if err, ok := failed[req.NodeKey]; ok {
+			fmt.Printf("%v\tfailed: %v\n", req.NodeKey, err)
+		} else {
+			fmt.Printf("%v\tsigned\n", req.NodeKey)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to sign %d of %d node keys", len(failed), len(reqs))
+	}
+	return nil
+}
+
+// parseNLSignList parses a list of node keys to sign, one per line in the
+// form "<node-key> [<rotation-key>]". Empty lines and lines starting with
+// '#' are ignored.
+func parseNLSignList(r io.Reader) ([]tailscale.NetworkLockSignRequest, error) {
+	var reqs []tailscale.NetworkLockSignRequest
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d: want <node-key> [<rotation-key>]", line)
+		}
+		var req tailscale.NetworkLockSignRequest
+		if err := req.NodeKey.UnmarshalText([]byte(fields[0])); err != nil {
+			return nil, fmt.Errorf("line %d: decoding node-key: %w", line, err)
+		}
+		if len(fields) > 1 {
+			var rotationKey key.NLPublic
+			if err := rotationKey.UnmarshalText([]byte(fields[1])); err != nil {
+				return nil, fmt.Errorf("line %d: decoding rotation-key: %w", line, err)
+			}
+			req.RotationPublic = []byte(rotationKey.Verifier())
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, sc.Err()
+}
+
 var nlDisableCmd = &ffcli.Command{
 	Name:       "disable",
 	ShortUsage: "disable <disablement-secret>",
@@ -0,0 +1,42 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"tailscale.com/types/key"
+)
+
+func TestParseNLSignList(t *testing.T) {
+	nk1, nk2 := key.NewNode().Public(), key.NewNode().Public()
+	rotation := key.NewNLPrivate().Public()
+
+	in := fmt.Sprintf("# nodes to sign\n%v\n\n  %v %s  \n", nk1, nk2, rotation.CLIString())
+	reqs, err := parseNLSignList(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 2 {
+		t.Fatalf("got %d requests, want 2", len(reqs))
+	}
+	if reqs[0].NodeKey != nk1 || reqs[0].RotationPublic != nil {
+		t.Errorf("reqs[0] = %+v, want node-key %v without rotation key", reqs[0], nk1)
+	}
+	if reqs[1].NodeKey != nk2 || string(reqs[1].RotationPublic) != string(rotation.Verifier()) {
+		t.Errorf("reqs[1] = %+v, want node-key %v with rotation key %v", reqs[1], nk2, rotation)
+	}
+
+	for _, bad := range []string{
+		"nodekey:foo\n",
+		fmt.Sprintf("%v nlpub:foo\n", nk1),
+		fmt.Sprintf("%v %s extra\n", nk1, rotation.CLIString()),
+	} {
+		if _, err := parseNLSignList(strings.NewReader(bad)); err == nil {
+			t.Errorf("parseNLSignList(%q) succeeded, want error", bad)
+		}
+	}
+}
@@ -39,6 +39,11 @@
 By default, 'tailscale ping' stops after 10 pings or once a direct
 (non-DERP) path has been established, whichever comes first.
 
+With --bench, 'tailscale ping' instead measures the quality of the path
+to the peer: its latency and packet loss, and its throughput in each
+direction. Measuring the throughput requires the peer to grant this node
+the "tailscale.com/cap/bench" capability in the tailnet policy file.
+
 The provided hostname must resolve to or be a Tailscale IP
 (e.g. 100.x.y.z) or a subnet IP advertised by a Tailscale
 relay node.
@@ -55,6 +60,8 @@
 		fs.IntVar(&pingArgs.num, "c", 10, "max number of pings to send. 0 for infinity.")
 		fs.DurationVar(&pingArgs.timeout, "timeout", 5*time.Second, "timeout before giving up on a ping")
 		fs.IntVar(&pingArgs.size, "size", 0, "size of the ping message (disco pings only). 0 for minimum size.")
+		fs.BoolVar(&pingArgs.bench, "bench", false, "measure the latency, loss and throughput of the path to the peer")
+		fs.DurationVar(&pingArgs.benchDuration, "bench-duration", 3*time.Second, "duration of the throughput measurement in each direction, with --bench")
 		return fs
 	})(),
 }
@@ -68,6 +75,9 @@
 	icmp        bool
 	peerAPI     bool
 	timeout     time.Duration
+
+	bench         bool
+	benchDuration time.Duration
 }
 
 func pingType() tailcfg.PingType {
@@ -113,6 +123,10 @@ func runPing(ctx context.Context, args []string) error {
 		log.Printf("lookup %q => %q", hostOrIP, ip)
 	}
 
+	if pingArgs.bench {
+		return runPingBench(ctx, netip.MustParseAddr(ip))
+	}
+
 	n := 0
 	anyPong := false
 	for {
@@ -180,6 +194,42 @@ func runPing(ctx context.Context, args []string) error {
 	}
 }
 
+func runPingBench(ctx context.Context, ip netip.Addr) error {
+	printf("benchmarking path to %v...\n", ip)
+	res, err := localClient.BenchPeer(ctx, ip, pingArgs.benchDuration)
+	if err != nil {
+		return err
+	}
+	via := res.Endpoint
+	if res.DERPRegionCode != "" {
+		via = fmt.Sprintf("DERP(%s)", res.DERPRegionCode)
+	}
+	if via == "" {
+		via = "unknown path"
+	}
+	seconds := func(s float64) time.Duration {
+		return time.Duration(s * float64(time.Second)).Round(10 * time.Microsecond)
+	}
+	printf("peer:       %s (%s) via %s\n", res.NodeName, res.IP, via)
+	printf("loss:       %.1f%% (%d/%d pongs)\n", res.Loss()*100, res.PongsReceived, res.PingsSent)
+	if res.PongsReceived > 0 {
+		printf("latency:    min %v, avg %v, max %v\n", seconds(res.LatencyMinSeconds), seconds(res.LatencyAvgSeconds), seconds(res.LatencyMaxSeconds))
+	}
+	if res.DownloadBytesPerSecond > 0 {
+		printf("download:   %.2f Mbit/s\n", res.DownloadBytesPerSecond*8/1e6)
+	}
+	if res.UploadBytesPerSecond > 0 {
+		printf("upload:     %.2f Mbit/s\n", res.UploadBytesPerSecond*8/1e6)
+	}
+	if res.Err != "" {
+		printf("throughput not measured: %s\n", res.Err)
+	}
+	if res.PongsReceived == 0 {
+		return errors.New("no reply")
+	}
+	return nil
+}
+
 func tailscaleIPFromArg(ctx context.Context, hostOrIP string) (ip string, self bool, err error) {
 	// If the argument is an IP address, use it directly without any resolution.
 	if net.ParseIP(hostOrIP) != nil {
@@ -0,0 +1,111 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var reportCmd = &ffcli.Command{
+	Name:       "report",
+	ShortUsage: "report [--last] [--json]",
+	ShortHelp:  "Show a local summary of this machine's tailnet usage this week",
+	LongHelp: strings.TrimSpace(`
+'tailscale report' shows a summary of this machine's tailnet usage over the
+current week: the peers it exchanged the most traffic with, the services it
+serves that were accessed, how many connections came in over Funnel, and the
+devices that joined the tailnet.
+
+Reports are only kept once enabled with 'tailscale set --usage-reports'. They
+are stored in tailscaled's state directory, one file per week, and are never
+sent anywhere.
+`),
+	UsageFunc: usageFunc,
+	Exec:      runReport,
+	FlagSet: func() *flag.FlagSet {
+		fs := newFlagSet("report")
+		fs.BoolVar(&reportArgs.last, "last", false, "show the report of the previous week instead of the current one")
+		fs.BoolVar(&reportArgs.json, "json", false, "output in JSON format")
+		fs.IntVar(&reportArgs.top, "top", 10, "number of peers to show, by traffic")
+		return fs
+	}(),
+}
+
+var reportArgs struct {
+	last bool // show the previous week's report
+	json bool // output in JSON format
+	top  int  // number of peers to show
+}
+
+func runReport(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected non-flag arguments to 'tailscale report'")
+	}
+	rep, err := localClient.UsageReport(ctx, reportArgs.last)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if reportArgs.json {
+		j, err := json.MarshalIndent(rep, "", "  ")
+		if err != nil {
+			return err
+		}
+		printf("%s\n", j)
+		return nil
+	}
+
+	const dateFmt = "Mon Jan 2 2006"
+	printf("Usage report for %s to %s", rep.Start.Format(dateFmt), rep.End.Add(-time.Second).Format(dateFmt))
+	if time.Now().Before(rep.End) {
+		printf(" (so far)")
+	}
+	printf("\n")
+
+	w := tabwriter.NewWriter(Stdout, 10, 5, 3, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "\nTop peers by traffic:\n")
+	if len(rep.Peers) == 0 {
+		fmt.Fprintf(w, "  (none)\n")
+	}
+	for i, p := range rep.Peers {
+		if i == reportArgs.top {
+			fmt.Fprintf(w, "  ... and %d more\n", len(rep.Peers)-i)
+			break
+		}
+		name := p.Name
+		if name == "" {
+			name = p.NodeKey.ShortString()
+		}
+		fmt.Fprintf(w, "  %s\t%s\tsent %s\treceived %s\n", name, p.TailscaleIP, formatIEC(float64(p.TxBytes), "B"), formatIEC(float64(p.RxBytes), "B"))
+	}
+
+	fmt.Fprintf(w, "\nServices accessed:\n")
+	if len(rep.Services) == 0 {
+		fmt.Fprintf(w, "  (none)\n")
+	}
+	for _, s := range rep.Services {
+		fmt.Fprintf(w, "  port %d\t%d connections\n", s.Port, s.Conns)
+	}
+
+	fmt.Fprintf(w, "\nFunnel connections: %d\n", rep.FunnelHits)
+
+	fmt.Fprintf(w, "\nNew devices:\n")
+	if len(rep.NewDevices) == 0 {
+		fmt.Fprintf(w, "  (none)\n")
+	}
+	for _, d := range rep.NewDevices {
+		fmt.Fprintf(w, "  %s\t%s\tjoined %s\n", d.Name, d.TailscaleIP, d.Created.Local().Format("Mon Jan 2 15:04"))
+	}
+	return nil
+}
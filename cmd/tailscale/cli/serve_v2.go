@@ -44,6 +44,8 @@ type commandInfo struct {
 <target> can be a file, directory, text, or most commonly the location to a service running on the
 local machine. The location to the location service can be expressed as a port number (e.g., 3000),
 a partial URL (e.g., localhost:3000), or a full URL including a path (e.g., http://localhost:3000/foo).
+It can also be a Unix socket (e.g., unix:/run/app.sock) or, on Windows, a named pipe
+(e.g., npipe:\\.\pipe\app), for services that don't listen on a TCP port.
 
 EXAMPLES
   - Expose an HTTP server running at 127.0.0.1:3000 in the foreground:
@@ -55,6 +57,9 @@ type commandInfo struct {
   - Expose an HTTPS server with invalid or self-signed certificates at https://localhost:8443
     $ tailscale %[1]s https+insecure://localhost:8443
 
+  - Expose an HTTP server listening on the Unix socket /run/gunicorn.sock:
+    $ tailscale %[1]s unix:/run/gunicorn.sock
+
 For more examples and use cases visit our docs site https://tailscale.com/kb/1247/funnel-serve-use-cases
 `)
 
@@ -487,7 +492,7 @@ func (e *serveEnv) messageForPort(sc *ipn.ServeConfig, st *ipnstate.Status, dnsN
 			ipp := net.JoinHostPort(a.String(), strconv.Itoa(int(srvPort)))
 			output.WriteString(fmt.Sprintf("|-- tcp://%s\n", ipp))
 		}
-		output.WriteString(fmt.Sprintf("|--> tcp://%s\n", h.TCPForward))
+		output.WriteString(fmt.Sprintf("|--> %s\n", tcpForwardTarget(h.TCPForward)))
 	}
 
 	if !e.bg {
@@ -534,6 +539,11 @@ func (e *serveEnv) applyWebServe(sc *ipn.ServeConfig, dnsName string, srvPort ui
 			mount += "/"
 		}
 		h.Path = target
+	case ipn.IsProxySocket(target):
+		if _, _, ok := ipn.ParseProxySocket(target); !ok {
+			return errInvalidSocketTarget(target)
+		}
+		h.Proxy = target
 	default:
 		t, err := expandProxyTargetDev(target, []string{"http", "https", "https+insecure"}, "http")
 		if err != nil {
@@ -585,14 +595,23 @@ func (e *serveEnv) applyTCPServe(sc *ipn.ServeConfig, dnsName string, srcType se
 		return fmt.Errorf("invalid TCP target %q", target)
 	}
 
-	targetURL, err := expandProxyTargetDev(target, []string{"tcp"}, "tcp")
-	if err != nil {
-		return fmt.Errorf("unable to expand target: %v", err)
-	}
+	var fwd string
+	if ipn.IsProxySocket(target) {
+		if _, _, ok := ipn.ParseProxySocket(target); !ok {
+			return errInvalidSocketTarget(target)
+		}
+		fwd = target
+	} else {
+		targetURL, err := expandProxyTargetDev(target, []string{"tcp"}, "tcp")
+		if err != nil {
+			return fmt.Errorf("unable to expand target: %v", err)
+		}
 
-	dstURL, err := url.Parse(targetURL)
-	if err != nil {
-		return fmt.Errorf("invalid TCP target %q: %v", target, err)
+		dstURL, err := url.Parse(targetURL)
+		if err != nil {
+			return fmt.Errorf("invalid TCP target %q: %v", target, err)
+		}
+		fwd = dstURL.Host
 	}
 
 	// TODO: needs to account for multiple configs from foreground mode
@@ -600,7 +619,7 @@ func (e *serveEnv) applyTCPServe(sc *ipn.ServeConfig, dnsName string, srcType se
 		return fmt.Errorf("cannot serve TCP; already serving web on %d", srcPort)
 	}
 
-	mak.Set(&sc.TCP, srcPort, &ipn.TCPPortHandler{TCPForward: dstURL.Host})
+	mak.Set(&sc.TCP, srcPort, &ipn.TCPPortHandler{TCPForward: fwd})
 
 	if terminateTLS {
 		sc.TCP[srcPort].TerminateTLS = dnsName
@@ -919,6 +938,21 @@ func expandProxyTargetDev(target string, supportedSchemes []string, defaultSchem
 	return u.String(), nil
 }
 
+// errInvalidSocketTarget returns the error for a serve target that's an
+// invalid local socket.
+func errInvalidSocketTarget(target string) error {
+	return fmt.Errorf(`invalid socket target %q; must be unix:/path/to/socket or npipe:\\.\pipe\name`, target)
+}
+
+// tcpForwardTarget returns the TCPPortHandler.TCPForward value fwd for
+// display, as a tcp:// URL or a local socket.
+func tcpForwardTarget(fwd string) string {
+	if ipn.IsProxySocket(fwd) {
+		return fwd
+	}
+	return "tcp://" + fwd
+}
+
 // cleanURLPath ensures the path is clean and has a leading "/".
 func cleanURLPath(urlPath string) (string, error) {
 	if urlPath == "" {
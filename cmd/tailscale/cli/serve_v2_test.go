@@ -431,6 +431,38 @@ type group struct {
 				},
 			}},
 		},
+		{
+			name: "unix_socket",
+			steps: []step{{
+				command: cmd("serve --bg unix:/run/app.sock"),
+				want: &ipn.ServeConfig{
+					TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+					Web: map[ipn.HostPort]*ipn.WebServerConfig{
+						"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+							"/": {Proxy: "unix:/run/app.sock"},
+						}},
+					},
+				},
+			}},
+		},
+		{
+			name: "tcp_unix_socket",
+			steps: []step{{
+				command: cmd("serve --tcp=5432 --bg unix:/run/postgresql/.s.PGSQL.5432"),
+				want: &ipn.ServeConfig{
+					TCP: map[uint16]*ipn.TCPPortHandler{
+						5432: {TCPForward: "unix:/run/postgresql/.s.PGSQL.5432"},
+					},
+				},
+			}},
+		},
+		{
+			name: "invalid_unix_socket",
+			steps: []step{{
+				command: cmd("serve --bg unix:app.sock"),
+				wantErr: anyErr(),
+			}},
+		},
 		{
 			name: "tls_terminated_tcp",
 			steps: []step{
@@ -10,6 +10,7 @@
 	"fmt"
 	"net/netip"
 	"os/exec"
+	"strings"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/client/web"
@@ -42,6 +43,7 @@ type setArgsT struct {
 	acceptDNS              bool
 	exitNodeIP             string
 	exitNodeAllowLANAccess bool
+	exitNodeLANRoutes      string
 	shieldsUp              bool
 	runSSH                 bool
 	runWebClient           bool
@@ -56,6 +58,9 @@ type setArgsT struct {
 	updateCheck            bool
 	updateApply            bool
 	postureChecking        bool
+	usageReports           bool
+	dnsQueryLog            bool
+	routeMTUs              string
 }
 
 func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
@@ -66,6 +71,7 @@ func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
 	setf.BoolVar(&setArgs.acceptDNS, "accept-dns", false, "accept DNS configuration from the admin panel")
 	setf.StringVar(&setArgs.exitNodeIP, "exit-node", "", "Tailscale exit node (IP or base name) for internet traffic, or empty string to not use an exit node")
 	setf.BoolVar(&setArgs.exitNodeAllowLANAccess, "exit-node-allow-lan-access", false, "Allow direct access to the local network when routing traffic via an exit node")
+	setf.StringVar(&setArgs.exitNodeLANRoutes, "exit-node-allowed-lan-routes", "", "local network routes to allow direct access to when routing traffic via an exit node (comma-separated, e.g. \"192.168.1.0/24\"), or empty string to route all of the local network via the exit node")
 	setf.BoolVar(&setArgs.shieldsUp, "shields-up", false, "don't allow incoming connections")
 	setf.BoolVar(&setArgs.runSSH, "ssh", false, "run an SSH server, permitting access per tailnet admin's declared policy")
 	setf.StringVar(&setArgs.hostname, "hostname", "", "hostname to use instead of the one provided by the OS")
@@ -76,11 +82,15 @@ func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
 	setf.BoolVar(&setArgs.updateApply, "auto-update", false, "automatically update to the latest available version")
 	setf.BoolVar(&setArgs.postureChecking, "posture-checking", false, "HIDDEN: allow management plane to gather device posture information")
 	setf.BoolVar(&setArgs.runWebClient, "webclient", false, "run a web interface for managing this node, served over Tailscale at port 5252")
+	setf.BoolVar(&setArgs.usageReports, "usage-reports", false, "keep a weekly local summary of tailnet usage, shown by \"tailscale report\"")
+	setf.BoolVar(&setArgs.dnsQueryLog, "dns-query-log", false, "keep an in-memory log of recent DNS queries, shown by \"tailscale debug dns-query-log\"")
 
 	if safesocket.GOOSUsesPeerCreds(goos) {
 		setf.StringVar(&setArgs.opUser, "operator", "", "Unix username to allow to operate on tailscaled without sudo")
 	}
 	switch goos {
+	case "linux":
+		setf.StringVar(&setArgs.routeMTUs, "route-mtus", "", "MTUs of routes through Tailscale (comma-separated route=MTU pairs, e.g. \"10.0.0.0/8=1280\"), or empty string to use the interface MTU for all routes")
 	case "windows":
 		setf.BoolVar(&setArgs.forceDaemon, "unattended", false, "run in \"Unattended Mode\" where Tailscale keeps running even after the current GUI user logs out (Windows-only)")
 	}
@@ -124,6 +134,8 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 				Advertise: setArgs.advertiseConnector,
 			},
 			PostureChecking: setArgs.postureChecking,
+			UsageReports:    setArgs.usageReports,
+			DNSQueryLog:     setArgs.dnsQueryLog,
 		},
 	}
 
@@ -162,6 +174,18 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 			return err
 		}
 	}
+	if maskedPrefs.ExitNodeAllowedLANRoutesSet {
+		maskedPrefs.ExitNodeAllowedLANRoutes, err = parseExitNodeLANRoutes(setArgs.exitNodeLANRoutes)
+		if err != nil {
+			return err
+		}
+	}
+	if maskedPrefs.RouteMTUsSet {
+		maskedPrefs.RouteMTUs, err = ipn.ParseRouteMTUs(setArgs.routeMTUs)
+		if err != nil {
+			return err
+		}
+	}
 
 	if maskedPrefs.RunSSHSet {
 		wantSSH, haveSSH := maskedPrefs.RunSSH, curPrefs.RunSSH
@@ -205,6 +229,29 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 	return nil
 }
 
+// parseExitNodeLANRoutes parses the comma-separated value of the
+// --exit-node-allowed-lan-routes flag.
+func parseExitNodeLANRoutes(s string) ([]netip.Prefix, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var routes []netip.Prefix
+	for _, r := range strings.Split(s, ",") {
+		ipp, err := netip.ParsePrefix(r)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid IP address or CIDR prefix", r)
+		}
+		if ipp != ipp.Masked() {
+			return nil, fmt.Errorf("%s has non-address bits set; expected %s", ipp, ipp.Masked())
+		}
+		if ipp.Bits() == 0 {
+			return nil, fmt.Errorf("%s is a default route; use --exit-node-allow-lan-access to allow access to the whole local network", ipp)
+		}
+		routes = append(routes, ipp)
+	}
+	return routes, nil
+}
+
 // calcAdvertiseRoutesForSet returns the new value for Prefs.AdvertiseRoutes based on the
 // current value, the flags passed to "tailscale set".
 // advertiseExitNodeSet is whether the --advertise-exit-node flag was set.
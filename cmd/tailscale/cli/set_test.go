@@ -129,3 +129,30 @@ func TestCalcAdvertiseRoutesForSet(t *testing.T) {
 		})
 	}
 }
+
+func TestParseExitNodeLANRoutes(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	tests := []struct {
+		in      string
+		want    []netip.Prefix
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "192.168.1.0/24", want: []netip.Prefix{pfx("192.168.1.0/24")}},
+		{in: "192.168.1.0/24,fd00::/64", want: []netip.Prefix{pfx("192.168.1.0/24"), pfx("fd00::/64")}},
+		{in: "192.168.1.1/24", wantErr: true},
+		{in: "0.0.0.0/0", wantErr: true},
+		{in: "foo", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			got, err := parseExitNodeLANRoutes(tc.in)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -6,18 +6,24 @@
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
+	"math"
 	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/tailfs"
 )
 
 const (
-	shareAddUsage    = "share add <name> <path>"
+	shareAddUsage    = "share add [--read-only-for=<peers>] [--read-write-for=<peers>] [--quota=<size>] [--download-limit=<rate>] [--upload-limit=<rate>] <name> <path>"
+	shareRenameUsage = "share rename <old name> <new name>"
 	shareRemoveUsage = "share remove <name>"
 	shareListUsage   = "share list"
+	shareUsageUsage  = "share usage"
 )
 
 var shareCmd = &ffcli.Command{
@@ -25,8 +31,10 @@
 	ShortHelp: "Share a directory with your tailnet",
 	ShortUsage: strings.Join([]string{
 		shareAddUsage,
+		shareRenameUsage,
 		shareRemoveUsage,
 		shareListUsage,
+		shareUsageUsage,
 	}, "\n  "),
 	LongHelp:  buildShareLongHelp(),
 	UsageFunc: usageFuncNoDefaultValues,
@@ -36,6 +44,21 @@
 			Exec:      runShareAdd,
 			ShortHelp: "[ALPHA] add a share",
 			UsageFunc: usageFunc,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("add")
+				fs.StringVar(&shareAddArgs.readOnlyFor, "read-only-for", "", "comma-separated stable node IDs or tags (like tag:server) of peers limited to read-only access")
+				fs.StringVar(&shareAddArgs.readWriteFor, "read-write-for", "", "comma-separated stable node IDs or tags (like tag:server) of peers allowed read/write access")
+				fs.StringVar(&shareAddArgs.quota, "quota", "", "most space that files in the share may take up, like 500M or 10G; peers can't write beyond it")
+				fs.StringVar(&shareAddArgs.downloadLimit, "download-limit", "", "most bytes per second that peers may read from the share in total, like 500K or 10M")
+				fs.StringVar(&shareAddArgs.uploadLimit, "upload-limit", "", "most bytes per second that peers may write to the share in total, like 500K or 10M")
+				return fs
+			})(),
+		},
+		{
+			Name:      "rename",
+			ShortHelp: "[ALPHA] rename a share",
+			Exec:      runShareRename,
+			UsageFunc: usageFunc,
 		},
 		{
 			Name:      "remove",
@@ -49,12 +72,26 @@
 			Exec:      runShareList,
 			UsageFunc: usageFunc,
 		},
+		{
+			Name:      "usage",
+			ShortHelp: "[ALPHA] show how much each share is used",
+			Exec:      runShareUsage,
+			UsageFunc: usageFunc,
+		},
 	},
 	Exec: func(context.Context, []string) error {
 		return errors.New("share subcommand required; run 'tailscale share -h' for details")
 	},
 }
 
+var shareAddArgs struct {
+	readOnlyFor   string
+	readWriteFor  string
+	quota         string
+	downloadLimit string
+	uploadLimit   string
+}
+
 // runShareAdd is the entry point for the "tailscale share add" command.
 func runShareAdd(ctx context.Context, args []string) error {
 	if len(args) != 2 {
@@ -63,16 +100,113 @@ func runShareAdd(ctx context.Context, args []string) error {
 
 	name, path := args[0], args[1]
 
-	err := localClient.TailFSShareAdd(ctx, &tailfs.Share{
-		Name: name,
-		Path: path,
-	})
+	var access []*tailfs.ShareAccess
+	if sa := parseShareAccess(shareAddArgs.readOnlyFor, "ro"); sa != nil {
+		access = append(access, sa)
+	}
+	if sa := parseShareAccess(shareAddArgs.readWriteFor, "rw"); sa != nil {
+		access = append(access, sa)
+	}
+
+	share := &tailfs.Share{
+		Name:   name,
+		Path:   path,
+		Access: access,
+	}
+	for _, f := range []struct {
+		flag string
+		val  string
+		dst  *int64
+	}{
+		{"quota", shareAddArgs.quota, &share.Quota},
+		{"download-limit", shareAddArgs.downloadLimit, &share.DownloadLimit},
+		{"upload-limit", shareAddArgs.uploadLimit, &share.UploadLimit},
+	} {
+		if f.val == "" {
+			continue
+		}
+		n, err := parseShareSize(f.val)
+		if err != nil {
+			return fmt.Errorf("invalid --%s: %w", f.flag, err)
+		}
+		*f.dst = n
+	}
+
+	err := localClient.TailFSShareAdd(ctx, share)
 	if err == nil {
 		fmt.Printf("Added share %q at %q\n", name, path)
 	}
 	return err
 }
 
+// parseShareAccess returns a ShareAccess with the given access level for the
+// comma-separated node IDs and tags in peers, or nil if peers is empty.
+func parseShareAccess(peers, access string) *tailfs.ShareAccess {
+	if peers == "" {
+		return nil
+	}
+	sa := &tailfs.ShareAccess{Access: access}
+	for _, p := range strings.Split(peers, ",") {
+		p = strings.TrimSpace(p)
+		switch {
+		case p == "":
+		case strings.HasPrefix(p, "tag:"):
+			sa.Tags = append(sa.Tags, p)
+		default:
+			sa.Nodes = append(sa.Nodes, p)
+		}
+	}
+	return sa
+}
+
+// parseShareSize parses a size in bytes with an optional K, M, G or T suffix,
+// optionally followed by "B" or "iB", for multiples of 1024.
+func parseShareSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+	var mult int64 = 1
+	if i := strings.LastIndexAny(num, "KMGT"); i >= 0 && i == len(num)-1 {
+		mult = 1 << (10 * (strings.IndexByte("KMGT", num[i]) + 1))
+		num = num[:i]
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(num), 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if n > math.MaxInt64/mult {
+		return 0, fmt.Errorf("size %q too large", s)
+	}
+	return n * mult, nil
+}
+
+// formatShareSize formats n bytes for display.
+func formatShareSize(n int64) string {
+	const units = "KMGT"
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	f := float64(n)
+	i := -1
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%ciB", f, units[i])
+}
+
+// runShareRename is the entry point for the "tailscale share rename" command.
+func runShareRename(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: tailscale %v", shareRenameUsage)
+	}
+	oldName, newName := args[0], args[1]
+
+	err := localClient.TailFSShareRename(ctx, oldName, newName)
+	if err == nil {
+		fmt.Printf("Renamed share %q to %q\n", oldName, newName)
+	}
+	return err
+}
+
 // runShareRemove is the entry point for the "tailscale share remove" command.
 func runShareRemove(ctx context.Context, args []string) error {
 	if len(args) != 1 {
@@ -120,16 +254,47 @@ func runShareList(ctx context.Context, args []string) error {
 			longestAs = len(share.As)
 		}
 	}
-	formatString := fmt.Sprintf("%%-%ds    %%-%ds    %%s\n", longestName, longestPath)
-	fmt.Printf(formatString, "name", "path", "as")
-	fmt.Printf(formatString, strings.Repeat("-", longestName), strings.Repeat("-", longestPath), strings.Repeat("-", longestAs))
+	formatString := fmt.Sprintf("%%-%ds    %%-%ds    %%-%ds    %%s\n", longestName, longestPath, longestAs)
+	fmt.Printf(formatString, "name", "path", "as", "access")
+	fmt.Printf(formatString, strings.Repeat("-", longestName), strings.Repeat("-", longestPath), strings.Repeat("-", longestAs), "------")
 	for _, share := range shares {
-		fmt.Printf(formatString, share.Name, share.Path, share.As)
+		access := share.AccessString()
+		if access == "" {
+			access = "-"
+		}
+		fmt.Printf(formatString, share.Name, share.Path, share.As, access)
 	}
 
 	return nil
 }
 
+// runShareUsage is the entry point for the "tailscale share usage" command.
+func runShareUsage(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: tailscale %v", shareUsageUsage)
+	}
+
+	usage, err := localClient.TailFSShareUsage(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(Stdout, 0, 0, 4, ' ', 0)
+	fmt.Fprintln(w, "name\tstored\tquota\tserved\twritten")
+	fmt.Fprintln(w, "----\t------\t-----\t------\t-------")
+	for _, u := range usage {
+		stored, quota := "?", "-"
+		if u.StoredBytes >= 0 {
+			stored = formatShareSize(u.StoredBytes)
+		}
+		if u.Quota > 0 {
+			quota = formatShareSize(u.Quota)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", u.Name, stored, quota, formatShareSize(u.BytesServed), formatShareSize(u.BytesWritten))
+	}
+	return w.Flush()
+}
+
 func buildShareLongHelp() string {
 	longHelpAs := ""
 	if tailfs.AllowShareAs() {
@@ -194,13 +359,35 @@ func buildShareLongHelp() string {
 
 Whenever either you or anyone in the group "home" connects to the share, they connect as if they are using your local machine user. They'll be able to read the same files as your user and if they create files, those files will be owned by your user.%s
 
+A share can further limit which of the peers granted access to it can use it, and how, with the --read-only-for and --read-write-for flags, which take comma-separated lists of stable node IDs and tags. Peers not listed in either have no access to the share. For example, to make the above share writable only from machines tagged "tag:laptop" and read-only from machines tagged "tag:tv", you would run:
+
+	$ tailscale share add --read-write-for=tag:laptop --read-only-for=tag:tv docs /Users/me/Documents
+
+To limit how much space the files in a share may take up, use the --quota flag. Peers can't write files that would make the share exceed it. For example:
+
+	$ tailscale share add --quota=10G docs /Users/me/Documents
+
+To keep peers from saturating your connection, you can limit how fast they may read files from and write files to a share in total, in bytes per second, with the --download-limit and --upload-limit flags. For example:
+
+	$ tailscale share add --download-limit=2M docs /Users/me/Documents
+
+You can see how much space each share takes up, and how much has been read from and written to it by peers, by running:
+
+	$ tailscale share usage
+
+You can rename a share without changing anything else about it, for example you could rename the above share to "documents" by running:
+
+	$ tailscale share rename docs documents
+
 You can remove shares by name, for example you could remove the above share by running:
 
 	$ tailscale share remove docs
 
 You can get a list of currently published shares by running:
 
-	$ tailscale share list`
+	$ tailscale share list
+
+Its access column shows how each share limits which peers can use it, or "-" if it doesn't.`
 
 var shareLongHelpAs = `
 
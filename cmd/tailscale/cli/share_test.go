@@ -0,0 +1,58 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import "testing"
+
+func TestParseShareSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "1000", want: 1000},
+		{in: "5B", want: 5},
+		{in: "2K", want: 2 << 10},
+		{in: "500M", want: 500 << 20},
+		{in: "10g", want: 10 << 30},
+		{in: "10GB", want: 10 << 30},
+		{in: "10GiB", want: 10 << 30},
+		{in: "1T", want: 1 << 40},
+		{in: "", wantErr: true},
+		{in: "0", wantErr: true},
+		{in: "-5M", wantErr: true},
+		{in: "G", wantErr: true},
+		{in: "10X", wantErr: true},
+		{in: "9999999999T", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseShareSize(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseShareSize(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseShareSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatShareSize(t *testing.T) {
+	tests := []struct {
+		in   int64
+		want string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1024, "1.0KiB"},
+		{1536, "1.5KiB"},
+		{10 << 30, "10.0GiB"},
+		{3 << 50, "3072.0TiB"},
+	}
+	for _, tt := range tests {
+		if got := formatShareSize(tt.in); got != tt.want {
+			t.Errorf("formatShareSize(%d) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
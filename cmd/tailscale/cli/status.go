@@ -29,7 +29,7 @@
 
 var statusCmd = &ffcli.Command{
 	Name:       "status",
-	ShortUsage: "status [--active] [--web] [--json]",
+	ShortUsage: "status [--active] [--web] [--json] [--history=<hostname-or-IP>]",
 	ShortHelp:  "Show state of tailscaled and its connections",
 	LongHelp: strings.TrimSpace(`
 
@@ -56,6 +56,7 @@
 		fs.BoolVar(&statusArgs.peers, "peers", true, "show status of peers")
 		fs.StringVar(&statusArgs.listen, "listen", "127.0.0.1:8384", "listen address for web mode; use port 0 for automatic")
 		fs.BoolVar(&statusArgs.browser, "browser", true, "Open a browser in web mode")
+		fs.StringVar(&statusArgs.history, "history", "", "show how the connection to the given peer (hostname or Tailscale IP) changed over the last hour")
 		return fs
 	})(),
 }
@@ -68,12 +69,16 @@
 	active  bool   // in CLI mode, filter output to only peers with active sessions
 	self    bool   // in CLI mode, show status of local machine
 	peers   bool   // in CLI mode, show status of peer machines
+	history string // if non-empty, show the connectivity history of this peer instead
 }
 
 func runStatus(ctx context.Context, args []string) error {
 	if len(args) > 0 {
 		return errors.New("unexpected non-flag arguments to 'tailscale status'")
 	}
+	if statusArgs.history != "" {
+		return runStatusHistory(ctx, statusArgs.history)
+	}
 	getStatus := localClient.Status
 	if !statusArgs.peers {
 		getStatus = localClient.StatusWithoutPeers
@@ -336,3 +341,48 @@ func firstIPString(v []netip.Addr) string {
 	}
 	return v[0].String()
 }
+
+// runStatusHistory prints the connectivity transitions of the peer hostOrIP
+// over the last hour, as kept by tailscaled.
+func runStatusHistory(ctx context.Context, hostOrIP string) error {
+	ipStr, self, err := tailscaleIPFromArg(ctx, hostOrIP)
+	if err != nil {
+		return err
+	}
+	if self {
+		return fmt.Errorf("%v is the local machine", hostOrIP)
+	}
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return err
+	}
+	evs, err := localClient.PeerConnHistory(ctx, ip)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if statusArgs.json {
+		j, err := json.MarshalIndent(evs, "", "  ")
+		if err != nil {
+			return err
+		}
+		printf("%s\n", j)
+		return nil
+	}
+	if len(evs) == 0 {
+		printf("No connectivity changes for %s in the last hour.\n", hostOrIP)
+		return nil
+	}
+	for _, ev := range evs {
+		var change string
+		switch {
+		case ev.From != "" && ev.To != "":
+			change = ev.From + " -> " + ev.To
+		case ev.To != "":
+			change = ev.To
+		case ev.From != "":
+			change = ev.From + " -> (none)"
+		}
+		printf("%s  %-18s %s\n", ev.When.Local().Format("15:04:05"), ev.What, change)
+	}
+	return nil
+}
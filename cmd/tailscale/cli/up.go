@@ -507,9 +507,12 @@ func runUp(ctx context.Context, cmd string, args []string, upArgs upArgsT) (retE
 				pumpErr <- err
 				return
 			}
-			if n.ErrMessage != nil {
-				msg := *n.ErrMessage
-				fatalf("backend error: %v\n", msg)
+			warning, err := checkUpNotify(n, prefs)
+			if err != nil {
+				fatalf("backend error: %v\n", err)
+			}
+			if warning != "" && !env.upArgs.json {
+				fmt.Fprintf(Stderr, "\n%s\n\n", warning)
 			}
 			if s := n.State; s != nil {
 				switch *s {
@@ -642,6 +645,19 @@ func runUp(ctx context.Context, cmd string, args []string, upArgs upArgsT) (retE
 	}
 }
 
+// checkUpNotify checks n, a notification received during "tailscale up",
+// for a critical backend error to exit with, or a warning to print, which
+// doesn't stop it. prefs are the prefs being brought up.
+func checkUpNotify(n ipn.Notify, prefs *ipn.Prefs) (warning string, err error) {
+	if n.ErrMessage != nil {
+		return "", errors.New(*n.ErrMessage)
+	}
+	if n.ErrorCode == ipn.ErrorCodeRoutesNotApproved {
+		return fmt.Sprintf("Some of the advertised routes are waiting for approval. Peers won't use them until an admin approves them at:\n\n\t%s", prefs.AdminPageURL()), nil
+	}
+	return "", nil
+}
+
 // upWorthWarning reports whether the health check message s is worth warning
 // about during "tailscale up". Many of the health checks are noisy or confusing
 // or very ephemeral and happen especially briefly at startup.
@@ -713,6 +729,7 @@ func init() {
 	addPrefFlagMapping("shields-up", "ShieldsUp")
 	addPrefFlagMapping("snat-subnet-routes", "NoSNAT")
 	addPrefFlagMapping("exit-node-allow-lan-access", "ExitNodeAllowLANAccess")
+	addPrefFlagMapping("exit-node-allowed-lan-routes", "ExitNodeAllowedLANRoutes")
 	addPrefFlagMapping("unattended", "ForceDaemon")
 	addPrefFlagMapping("operator", "OperatorUser")
 	addPrefFlagMapping("ssh", "RunSSH")
@@ -722,6 +739,9 @@ func init() {
 	addPrefFlagMapping("auto-update", "AutoUpdate.Apply")
 	addPrefFlagMapping("advertise-connector", "AppConnector")
 	addPrefFlagMapping("posture-checking", "PostureChecking")
+	addPrefFlagMapping("usage-reports", "UsageReports")
+	addPrefFlagMapping("dns-query-log", "DNSQueryLog")
+	addPrefFlagMapping("route-mtus", "RouteMTUs")
 }
 
 func addPrefFlagMapping(flagName string, prefNames ...string) {
@@ -0,0 +1,264 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/client/tailscale"
+	"tailscale.com/health"
+	"tailscale.com/net/netcheck"
+	"tailscale.com/net/netmon"
+	"tailscale.com/tsd"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/wgengine/magicsock"
+)
+
+const (
+	// superviseInterval is how often the engine supervisor checks the
+	// health signals of the engine.
+	superviseInterval = time.Minute
+
+	// minEngineResetInterval is the minimum time between two engine
+	// resets, so that a persistently broken network doesn't keep the
+	// engine busy resetting.
+	minEngineResetInterval = 5 * time.Minute
+
+	// stunFailuresBeforeReset is the number of consecutive netchecks
+	// without STUN responses, after STUN worked, that trigger a reset.
+	stunFailuresBeforeReset = 3
+
+	// localAPIProbeTimeout is how long a LocalAPI status request may take
+	// before the LocalAPI is considered blocked.
+	localAPIProbeTimeout = 30 * time.Second
+
+	// localAPIFailuresBeforeReset is the number of consecutive blocked
+	// LocalAPI probes that trigger a restart of the LocalAPI.
+	localAPIFailuresBeforeReset = 2
+)
+
+var (
+	metricEngineResets     = clientmetric.NewCounter("tailscaled_engine_resets")
+	metricLocalAPIRestarts = clientmetric.NewCounter("tailscaled_localapi_restarts")
+)
+
+// engineSupervisor restarts the parts of tailscaled that appear to be
+// wedged, so that it recovers without a restart of the service.
+// Diagnostics are logged (and so uploaded to logtail) before each restart.
+//
+// Stuck engine calls, such as a Reconfig that never returns, are detected by
+// the engine's watchdog, which calls reset before giving up and crashing the
+// process. The supervisor itself watches for STUN responses stopping, which
+// also leads to a reset. A reset rebinds the magicsock sockets, which
+// unblocks anything stuck sending or receiving on them, and starts a new
+// netcheck.
+//
+// The supervisor also watches for the LocalAPI no longer answering. It then
+// restarts the LocalAPI by cancelling its requests in flight, which
+// unblocks handlers waiting on something that's stuck, like a netcheck or a
+// control request. If the LocalAPI still doesn't answer, the backend
+// itself is stuck, which can't be fixed without restarting the process, so
+// the supervisor exits like the engine's watchdog does.
+type engineSupervisor struct {
+	logf   logger.Logf
+	fatalf func(format string, args ...any)
+	netMon *netmon.Monitor
+	ms     *magicsock.Conn
+
+	mu        sync.Mutex
+	lastReset time.Time // or zero if never reset
+
+	// Only accessed by run.
+	stun     stunCheck
+	localAPI localAPICheck
+}
+
+func newEngineSupervisor(logf logger.Logf, sys *tsd.System) *engineSupervisor {
+	return &engineSupervisor{
+		logf:   logger.WithPrefix(logf, "supervisor: "),
+		fatalf: log.Fatalf,
+		netMon: sys.NetMon.Get(),
+		ms:     sys.MagicSock.Get(),
+	}
+}
+
+// localAPIServer is the part of *ipnserver.Server used by engineSupervisor.
+type localAPIServer interface {
+	CancelRequests() int
+}
+
+// run checks the health signals of the engine every superviseInterval until
+// ctx is done. lc is used to probe the LocalAPI served by srv.
+func (s *engineSupervisor) run(ctx context.Context, lc *tailscale.LocalClient, srv localAPIServer) {
+	t := time.NewTicker(superviseInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		linkUp := s.netMon.InterfaceState().AnyInterfaceUp()
+		if s.stun.observe(s.ms.LastNetcheckReport(), linkUp) {
+			s.reset(fmt.Sprintf("no STUN responses in the last %d netchecks", stunFailuresBeforeReset), true)
+		}
+
+		switch s.localAPI.observe(s.localAPIBlocked(ctx, lc)) {
+		case localAPIRestart:
+			s.restartLocalAPI(srv)
+		case localAPIGiveUp:
+			s.logDiagnostics("LocalAPI still blocked after restarting it; exiting", true)
+			s.flushLogs()
+			s.fatalf("supervisor: LocalAPI blocked")
+		}
+	}
+}
+
+// restartLocalAPI restarts the LocalAPI served by srv, after logging
+// diagnostics.
+func (s *engineSupervisor) restartLocalAPI(srv localAPIServer) {
+	metricLocalAPIRestarts.Add(1)
+	s.logDiagnostics(fmt.Sprintf("restarting LocalAPI, blocked for %d consecutive probes", localAPIFailuresBeforeReset), true)
+	s.flushLogs()
+	n := srv.CancelRequests()
+	s.logf("LocalAPI restarted; cancelled %d requests", n)
+}
+
+// flushLogs starts uploading the logs, so that diagnostics make it to
+// logtail even if what follows wedges or exits.
+func (s *engineSupervisor) flushLogs() {
+	if logPol != nil {
+		logPol.Logtail.StartFlush()
+	}
+}
+
+// localAPIBlocked reports whether a LocalAPI status request doesn't complete
+// within localAPIProbeTimeout. Other errors don't count, as they mean that
+// the LocalAPI answered.
+func (s *engineSupervisor) localAPIBlocked(ctx context.Context, lc *tailscale.LocalClient) bool {
+	probeCtx, cancel := context.WithTimeout(ctx, localAPIProbeTimeout)
+	defer cancel()
+	_, err := lc.StatusWithoutPeers(probeCtx)
+	return err != nil && ctx.Err() == nil && probeCtx.Err() != nil
+}
+
+// reset resets the engine, after logging why along with diagnostics. If
+// stacks is true, the goroutine stacks are part of the diagnostics; the
+// watchdog logs them itself.
+//
+// Resets are rate limited to one per minEngineResetInterval.
+func (s *engineSupervisor) reset(why string, stacks bool) {
+	s.mu.Lock()
+	if !s.lastReset.IsZero() && time.Since(s.lastReset) < minEngineResetInterval {
+		since := time.Since(s.lastReset).Round(time.Second)
+		s.mu.Unlock()
+		s.logf("not resetting engine (%s); last reset was %v ago", why, since)
+		return
+	}
+	s.lastReset = time.Now()
+	s.mu.Unlock()
+
+	metricEngineResets.Add(1)
+	s.logDiagnostics("resetting engine: "+why, stacks)
+	s.flushLogs()
+	s.ms.Rebind()
+	s.ms.ReSTUN("supervisor-reset")
+	s.logf("engine reset done (%s)", why)
+}
+
+// logDiagnostics logs what tailscaled is about to do and why, along with
+// the state of the engine and, if stacks is true, the goroutine stacks.
+func (s *engineSupervisor) logDiagnostics(what string, stacks bool) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", what)
+	fmt.Fprintf(&b, "health: %v\n", health.OverallError())
+	if r := s.ms.LastNetcheckReport(); r != nil {
+		fmt.Fprintf(&b, "last netcheck: udp=%v v4=%v v6=%v derp=%v\n", r.UDP, r.IPv4, r.IPv6, r.PreferredDERP)
+	} else {
+		fmt.Fprintf(&b, "last netcheck: none\n")
+	}
+	fmt.Fprintf(&b, "interfaces: %v\n", s.netMon.InterfaceState())
+	if stacks {
+		b.WriteString("stacks:\n")
+		pprof.Lookup("goroutine").WriteTo(&b, 1)
+	}
+	// Log everything as a single string to avoid log rate limits.
+	s.logf("%s", b.String())
+}
+
+// stunCheck detects that STUN stopped working: STUN round trips completed
+// before, but the last few netchecks with an interface up got no responses,
+// as happens when the engine's UDP sockets wedge.
+type stunCheck struct {
+	last     *netcheck.Report // last report observed
+	sawUDP   bool             // whether a STUN round trip completed since the last reset
+	failures int              // consecutive reports without STUN responses
+}
+
+// observe notes the latest netcheck report r, which may be nil or the same as
+// the previous one, and reports whether the engine should be reset.
+func (c *stunCheck) observe(r *netcheck.Report, linkUp bool) bool {
+	if r == nil || r == c.last {
+		return false
+	}
+	c.last = r
+	switch {
+	case r.UDP:
+		c.sawUDP = true
+		c.failures = 0
+	case c.sawUDP && linkUp:
+		c.failures++
+	}
+	if c.failures < stunFailuresBeforeReset {
+		return false
+	}
+	// Require STUN to work again before the next reset, so that a network
+	// that starts blocking UDP doesn't get the engine reset over and over.
+	c.sawUDP = false
+	c.failures = 0
+	return true
+}
+
+// localAPICheckResult is what the supervisor should do about the LocalAPI.
+type localAPICheckResult int
+
+const (
+	localAPIOK      localAPICheckResult = iota
+	localAPIRestart                     // restart it
+	localAPIGiveUp                      // exit, as restarting it didn't help
+)
+
+// localAPICheck detects that the LocalAPI stopped answering, and that
+// restarting it didn't fix that.
+type localAPICheck struct {
+	failures  int  // consecutive blocked probes
+	restarted bool // whether it was restarted since it last answered
+}
+
+// observe notes the result of a LocalAPI probe and reports what to do.
+func (c *localAPICheck) observe(blocked bool) localAPICheckResult {
+	if !blocked {
+		c.failures = 0
+		c.restarted = false
+		return localAPIOK
+	}
+	c.failures++
+	if c.failures < localAPIFailuresBeforeReset {
+		return localAPIOK
+	}
+	c.failures = 0
+	if c.restarted {
+		return localAPIGiveUp
+	}
+	c.restarted = true
+	return localAPIRestart
+}
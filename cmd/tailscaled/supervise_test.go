@@ -0,0 +1,153 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"testing"
+
+	"tailscale.com/net/netcheck"
+)
+
+func TestSTUNCheck(t *testing.T) {
+	udp := func(ok bool) *netcheck.Report { return &netcheck.Report{UDP: ok} }
+
+	type step struct {
+		r      *netcheck.Report
+		linkUp bool
+		want   bool
+	}
+	noUDP := udp(false)
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "never_worked",
+			steps: []step{
+				{udp(false), true, false},
+				{udp(false), true, false},
+				{udp(false), true, false},
+				{udp(false), true, false},
+			},
+		},
+		{
+			name: "stopped_working",
+			steps: []step{
+				{udp(true), true, false},
+				{udp(false), true, false},
+				{udp(false), true, false},
+				{udp(false), true, true},
+				// Not again until STUN works again.
+				{udp(false), true, false},
+				{udp(false), true, false},
+				{udp(false), true, false},
+				{udp(true), true, false},
+				{udp(false), true, false},
+				{udp(false), true, false},
+				{udp(false), true, true},
+			},
+		},
+		{
+			name: "recovered",
+			steps: []step{
+				{udp(true), true, false},
+				{udp(false), true, false},
+				{udp(false), true, false},
+				{udp(true), true, false},
+				{udp(false), true, false},
+			},
+		},
+		{
+			name: "link_down",
+			steps: []step{
+				{udp(true), true, false},
+				{udp(false), false, false},
+				{udp(false), false, false},
+				{udp(false), false, false},
+			},
+		},
+		{
+			name: "same_report",
+			steps: []step{
+				{udp(true), true, false},
+				{noUDP, true, false},
+				{noUDP, true, false},
+				{noUDP, true, false},
+				{nil, true, false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c stunCheck
+			for i, s := range tt.steps {
+				if got := c.observe(s.r, s.linkUp); got != s.want {
+					t.Errorf("step %d: observe = %v, want %v", i, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLocalAPICheck(t *testing.T) {
+	const (
+		ok      = localAPIOK
+		restart = localAPIRestart
+		giveUp  = localAPIGiveUp
+	)
+	type step struct {
+		blocked bool
+		want    localAPICheckResult
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "answering",
+			steps: []step{
+				{false, ok},
+				{false, ok},
+			},
+		},
+		{
+			name: "blocked_once",
+			steps: []step{
+				{true, ok},
+				{false, ok},
+				{true, ok},
+				{false, ok},
+			},
+		},
+		{
+			name: "restart_fixed_it",
+			steps: []step{
+				{true, ok},
+				{true, restart},
+				{false, ok},
+				{true, ok},
+				{true, restart},
+			},
+		},
+		{
+			name: "restart_didnt_help",
+			steps: []step{
+				{true, ok},
+				{true, restart},
+				{true, ok},
+				{true, giveUp},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c localAPICheck
+			for i, s := range tt.steps {
+				if got := c.observe(s.blocked); got != s.want {
+					t.Errorf("step %d: observe = %v, want %v", i, got, s.want)
+				}
+			}
+		})
+	}
+}
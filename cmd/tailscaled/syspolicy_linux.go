@@ -0,0 +1,10 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import "tailscale.com/util/syspolicy"
+
+func init() {
+	registerSysPolicyHandler = syspolicy.RegisterFileHandler
+}
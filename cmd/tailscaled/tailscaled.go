@@ -131,9 +131,10 @@ func defaultPort() uint16 {
 }
 
 var (
-	installSystemDaemon   func([]string) error                      // non-nil on some platforms
-	uninstallSystemDaemon func([]string) error                      // non-nil on some platforms
-	createBIRDClient      func(string) (wgengine.BIRDClient, error) // non-nil on some platforms
+	installSystemDaemon      func([]string) error                      // non-nil on some platforms
+	uninstallSystemDaemon    func([]string) error                      // non-nil on some platforms
+	createBIRDClient         func(string) (wgengine.BIRDClient, error) // non-nil on some platforms
+	registerSysPolicyHandler func()                                    // non-nil on some platforms
 )
 
 // Note - we use function pointers for subcommands so that subcommands like
@@ -162,7 +163,7 @@ func main() {
 	flag.StringVar(&args.httpProxyAddr, "outbound-http-proxy-listen", "", `optional [ip]:port to run an outbound HTTP proxy (e.g. "localhost:8080")`)
 	flag.StringVar(&args.tunname, "tun", defaultTunName(), `tunnel interface name; use "userspace-networking" (beta) to not use TUN`)
 	flag.Var(flagtype.PortValue(&args.port, defaultPort()), "port", "UDP port to listen on for WireGuard and peer-to-peer traffic; 0 means automatically select")
-	flag.StringVar(&args.statepath, "state", "", "absolute path of state file; use 'kube:<secret-name>' to use Kubernetes secrets or 'arn:aws:ssm:...' to store in AWS SSM; use 'mem:' to not store state and register as an ephemeral node. If empty and --statedir is provided, the default is <statedir>/tailscaled.state. Default: "+paths.DefaultTailscaledStateFile())
+	flag.StringVar(&args.statepath, "state", "", "absolute path of state file; use 'kube:<secret-name>' to use Kubernetes secrets or 'arn:aws:ssm:...' to store in AWS SSM; use 'encrypted-file:<path>' or 'encrypted-kube:<secret-name>' to encrypt the state with the key from $TS_STATE_ENCRYPTION_KEY; use 'mem:' to not store state and register as an ephemeral node. If empty and --statedir is provided, the default is <statedir>/tailscaled.state. Default: "+paths.DefaultTailscaledStateFile())
 	flag.StringVar(&args.statedir, "statedir", "", "path to directory for storage of config state, TLS certs, temporary incoming Taildrop files, etc. If empty, it's derived from --state when possible.")
 	flag.StringVar(&args.socketpath, "socket", paths.DefaultTailscaledSocket(), "path of the service unix socket")
 	flag.StringVar(&args.birdSocketPath, "bird-socket", "", "path of the bird unix socket")
@@ -207,6 +208,10 @@ func main() {
 		os.Exit(0)
 	}
 
+	if registerSysPolicyHandler != nil {
+		registerSysPolicyHandler()
+	}
+
 	if runtime.GOOS == "darwin" && os.Getuid() != 0 && !strings.Contains(args.tunname, "userspace-networking") && !args.cleanup {
 		log.SetFlags(0)
 		log.Fatalf("tailscaled requires root; use sudo tailscaled (or use --tun=userspace-networking)")
@@ -331,6 +336,10 @@ func ipnServerOpts() (o serverOptions) {
 var logPol *logpolicy.Policy
 var debugMux *http.ServeMux
 
+// engineSup supervises the engine. It's nil until an engine is created, and
+// stays nil if the engine isn't wrapped in a watchdog.
+var engineSup *engineSupervisor
+
 func run() (err error) {
 	var logf logger.Logf = log.Printf
 
@@ -460,7 +469,7 @@ func startIPNServer(ctx context.Context, logf logger.Logf, logID logid.PublicID,
 				return
 			}
 		}
-		lb, err := getLocalBackend(ctx, logf, logID, sys)
+		lb, err := getLocalBackend(ctx, logf, logID, sys, srv)
 		if err == nil {
 			logf("got LocalBackend in %v", time.Since(t0).Round(time.Millisecond))
 			srv.SetLocalBackend(lb)
@@ -484,7 +493,7 @@ func startIPNServer(ctx context.Context, logf logger.Logf, logID logid.PublicID,
 	return nil
 }
 
-func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID, sys *tsd.System) (_ *ipnlocal.LocalBackend, retErr error) {
+func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID, sys *tsd.System, srv *ipnserver.Server) (_ *ipnlocal.LocalBackend, retErr error) {
 	if logPol != nil {
 		logPol.Logtail.SetNetMon(sys.NetMon.Get())
 	}
@@ -574,14 +583,18 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 	if root := lb.TailscaleVarRoot(); root != "" {
 		dnsfallback.SetCachePath(filepath.Join(root, "derpmap.cached.json"), logf)
 	}
-	lb.ConfigureWebClient(&tailscale.LocalClient{
+	lc := &tailscale.LocalClient{
 		Socket:        args.socketpath,
 		UseSocketOnly: args.socketpath != paths.DefaultTailscaledSocket(),
-	})
+	}
+	lb.ConfigureWebClient(lc)
 	configureTaildrop(logf, lb)
 	if err := ns.Start(lb); err != nil {
 		log.Fatalf("failed to start netstack: %v", err)
 	}
+	if engineSup != nil && !envknob.Bool("TS_DEBUG_DISABLE_WATCHDOG") {
+		go engineSup.run(ctx, lc, srv)
+	}
 	return lb, nil
 }
 
@@ -636,7 +649,7 @@ func tryEngine(logf logger.Logf, sys *tsd.System, name string) (onlyNetstack boo
 		Dialer:         sys.Dialer.Get(),
 		SetSubsystem:   sys.Set,
 		ControlKnobs:   sys.ControlKnobs(),
-		TailFSForLocal: tailfsimpl.NewFileSystemForLocal(logf),
+		TailFSForLocal: newTailFSForLocal(logf),
 	}
 
 	onlyNetstack = name == "userspace-networking"
@@ -704,7 +717,9 @@ func tryEngine(logf logger.Logf, sys *tsd.System, name string) (onlyNetstack boo
 	if err != nil {
 		return onlyNetstack, err
 	}
-	e = wgengine.NewWatchdog(e)
+	sup := newEngineSupervisor(logf, sys)
+	e = wgengine.NewWatchdogWithReset(e, func(why string) { sup.reset(why, false) })
+	engineSup = sup
 	sys.Set(e)
 	sys.NetstackRouter.Set(netstackSubnetRouter)
 
@@ -817,6 +832,43 @@ func beChild(args []string) error {
 	return f(args[1:])
 }
 
+// newTailFSForLocal returns the TailFS filesystem for local clients, keeping
+// its state in the state directory, where it also caches the contents of
+// remote files if enabled with TS_TAILFS_CONTENT_CACHE_MB. It's served over
+// WebDAV, or to virtual filesystem providers if TS_TAILFS_LOCAL_API is "vfs".
+func newTailFSForLocal(logf logger.Logf) *tailfsimpl.FileSystemForLocal {
+	var fs *tailfsimpl.FileSystemForLocal
+	switch api := envknob.String("TS_TAILFS_LOCAL_API"); api {
+	case "vfs":
+		fs = tailfsimpl.NewFileSystemForLocalVFS(logf)
+	default:
+		if api != "" && api != "webdav" {
+			logf("unknown TS_TAILFS_LOCAL_API %q; using webdav", api)
+		}
+		fs = tailfsimpl.NewFileSystemForLocal(logf)
+	}
+	varRoot := ipnServerOpts().VarRoot
+	if varRoot != "" {
+		if err := fs.SetStateDir(filepath.Join(varRoot, "tailfs")); err != nil {
+			logf("TailFS state directory: %v", err)
+		}
+	}
+	if mb := envknob.String("TS_TAILFS_CONTENT_CACHE_MB"); mb != "" {
+		n, err := strconv.ParseInt(mb, 10, 64)
+		switch {
+		case err != nil || n <= 0:
+			logf("invalid TS_TAILFS_CONTENT_CACHE_MB %q", mb)
+		case varRoot == "":
+			logf("not caching TailFS file contents without a state directory")
+		default:
+			if err := fs.EnableContentCache(filepath.Join(varRoot, "tailfs-cache"), n<<20); err != nil {
+				logf("TailFS content cache: %v", err)
+			}
+		}
+	}
+	return fs
+}
+
 var serveTailFSFunc = serveTailFS
 
 // serveTailFS serves one or more tailfs on localhost using the WebDAV
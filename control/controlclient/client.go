@@ -87,3 +87,12 @@ type Client interface {
 
 func (e UserVisibleError) Error() string            { return string(e) }
 func (e UserVisibleError) UserVisibleError() string { return string(e) }
+
+// AuthKeyError is a UserVisibleError from the control server refusing to
+// register a node with an auth key, such as because the key expired or was
+// already used.
+type AuthKeyError struct {
+	UserVisibleError
+}
+
+func (e AuthKeyError) Unwrap() error { return e.UserVisibleError }
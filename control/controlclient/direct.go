@@ -666,6 +666,9 @@ func (c *Direct) doLogin(ctx context.Context, opt loginOpt) (mustRegen bool, new
 		resp.NodeKeyExpired, resp.MachineAuthorized, resp.AuthURL != "")
 
 	if resp.Error != "" {
+		if authKey != "" {
+			return false, "", nil, AuthKeyError{UserVisibleError(resp.Error)}
+		}
 		return false, "", nil, UserVisibleError(resp.Error)
 	}
 	if len(resp.NodeKeySignature) > 0 {
@@ -525,6 +525,11 @@ func (ms *mapSession) patchifyPeersChanged(resp *tailcfg.MapResponse) {
 				patchifiedPeerEqual.Add(1)
 			}
 		} else {
+			if debugPatchifyPeer() {
+				if was, ok := ms.peers[n.ID]; ok {
+					ms.logf("debug: patchifyPeer[ID=%v]: not patchable; changed %q", n.ID, views.Diff(*was, n.View()))
+				}
+			}
 			filtered = append(filtered, n)
 		}
 	}
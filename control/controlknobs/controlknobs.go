@@ -73,6 +73,10 @@ type Knobs struct {
 	// ProbeUDPLifetime is whether the node should probe UDP path lifetime on
 	// the tail end of an active direct connection in magicsock.
 	ProbeUDPLifetime atomic.Bool
+
+	// PQHybridKeyExchange is whether the node should run the experimental
+	// post-quantum hybrid key exchange with peers that also have it enabled.
+	PQHybridKeyExchange atomic.Bool
 }
 
 // UpdateFromNodeAttributes updates k (if non-nil) based on the provided self
@@ -100,6 +104,7 @@ func (k *Knobs) UpdateFromNodeAttributes(selfNodeAttrs []tailcfg.NodeCapability,
 		forceNfTables                 = has(tailcfg.NodeAttrLinuxMustUseNfTables)
 		seamlessKeyRenewal            = has(tailcfg.NodeAttrSeamlessKeyRenewal)
 		probeUDPLifetime              = has(tailcfg.NodeAttrProbeUDPLifetime)
+		pqHybridKeyExchange           = has(tailcfg.NodeAttrPQHybridKeyExchange)
 	)
 
 	if has(tailcfg.NodeAttrOneCGNATEnable) {
@@ -122,6 +127,7 @@ func (k *Knobs) UpdateFromNodeAttributes(selfNodeAttrs []tailcfg.NodeCapability,
 	k.LinuxForceNfTables.Store(forceNfTables)
 	k.SeamlessKeyRenewal.Store(seamlessKeyRenewal)
 	k.ProbeUDPLifetime.Store(probeUDPLifetime)
+	k.PQHybridKeyExchange.Store(pqHybridKeyExchange)
 }
 
 // AsDebugJSON returns k as something that can be marshalled with json.Marshal
@@ -145,5 +151,6 @@ func (k *Knobs) AsDebugJSON() map[string]any {
 		"LinuxForceNfTables":            k.LinuxForceNfTables.Load(),
 		"SeamlessKeyRenewal":            k.SeamlessKeyRenewal.Load(),
 		"ProbeUDPLifetime":              k.ProbeUDPLifetime.Load(),
+		"PQHybridKeyExchange":           k.PQHybridKeyExchange.Load(),
 	}
 }
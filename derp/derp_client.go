@@ -388,6 +388,17 @@ type ServerInfoMessage struct {
 	// Zero means unspecified. There might be a limit, but the
 	// client need not try to respect it.
 	TokenBucketBytesBurst int
+
+	// Load is how loaded the server says it is, as the fraction of its
+	// capacity in use when the client connected. One or more means fully
+	// loaded. Zero means unspecified.
+	Load float64
+
+	// AlternateRegions are the IDs of the DERP regions that the server
+	// suggests clients use instead of its own when it's heavily loaded,
+	// such as other regions run by the same operator. See
+	// derphttp.SteerRegion.
+	AlternateRegions []int
 }
 
 func (ServerInfoMessage) msg() {}
@@ -523,6 +534,8 @@ func (c *Client) recvTimeout(timeout time.Duration) (m ReceivedMessage, err erro
 			sm := ServerInfoMessage{
 				TokenBucketBytesPerSecond: si.TokenBucketBytesPerSecond,
 				TokenBucketBytesBurst:     si.TokenBucketBytesBurst,
+				Load:                      si.Load,
+				AlternateRegions:          si.AlternateRegions,
 			}
 			c.setSendRateLimiter(sm)
 			return sm, nil
@@ -27,6 +27,7 @@
 	"net/netip"
 	"os/exec"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -35,6 +36,7 @@
 
 	"go4.org/mem"
 	"golang.org/x/sync/errgroup"
+	xrate "golang.org/x/time/rate"
 	"tailscale.com/client/tailscale"
 	"tailscale.com/disco"
 	"tailscale.com/envknob"
@@ -103,7 +105,6 @@ type Server struct {
 	publicKey   key.NodePublic
 	logf        logger.Logf
 	memSys0     uint64 // runtime.MemStats.Sys at start (or early-ish)
-	meshKey     string
 	limitedLogf logger.Logf
 	metaCert    []byte // the encoded x509 cert to send after LetsEncrypt cert+intermediate
 	dupPolicy   dupPolicy
@@ -130,6 +131,8 @@ type Server struct {
 	gotPing                      expvar.Int // number of ping frames from client
 	sentPong                     expvar.Int // number of pong frames enqueued to client
 	accepts                      expvar.Int
+	handshakeFailures            expvar.Int // accepted connections that failed to complete the DERP handshake
+	disconnects                  expvar.Int // registered clients that have since disconnected
 	curClients                   expvar.Int
 	curHomeClients               expvar.Int // ones with preferred
 	dupClientKeys                expvar.Int // current number of public keys we have 2+ connections for
@@ -148,6 +151,38 @@ type Server struct {
 	// known peer in the network, as specified by a running tailscaled's client's LocalAPI.
 	verifyClients bool
 
+	// meshKeys are the accepted mesh keys. The first is the one we use to
+	// connect to other servers in the region.
+	meshKeys syncs.AtomicValue[[]string]
+
+	// admitClient, if non-nil, decides whether a client that passed
+	// verifyClients may connect. See SetAdmitClient.
+	admitClient func(key.NodePublic, netip.AddrPort) error
+
+	// onClientConn, if non-nil, is called when a client connects or
+	// disconnects. See SetOnClientConn.
+	onClientConn func(cs ClientStats, connected bool)
+
+	// clientRate and clientBurst, if clientRate is positive, limit the
+	// bytes per second that each client may send. totalRate, if non-nil,
+	// limits the bytes per second that all clients together may send.
+	// Mesh peers are exempt from both. See SetClientRateLimit and
+	// SetTotalRateLimit.
+	clientRate  xrate.Limit
+	clientBurst int
+	totalRate   *xrate.Limiter
+
+	// steering is the region steering advice sent to clients in the
+	// server info frame. See SetSteering.
+	steering syncs.AtomicValue[steering]
+	// serverInfoInterval is how often clients are sent the server info
+	// again while steering is set, so that they follow the server's load.
+	serverInfoInterval time.Duration
+
+	// draining is whether the server is shutting down gracefully and not
+	// admitting new clients other than mesh peers. See StartDrain.
+	draining atomic.Bool
+
 	mu       sync.Mutex
 	closed   bool
 	netConns map[Conn]chan struct{} // chan is closed when conn closes
@@ -323,6 +358,7 @@ func NewServer(privateKey key.NodePrivate, logf logger.Logf) *Server {
 		tcpRtt:               metrics.LabelMap{Label: "le"},
 		keyOfAddr:            map[netip.AddrPort]key.NodePublic{},
 		clock:                tstime.StdClock{},
+		serverInfoInterval:   defaultServerInfoInterval,
 	}
 	s.initMetacert()
 	s.packetsRecvDisco = s.packetsRecvByKind.Get("disco")
@@ -335,6 +371,7 @@ func NewServer(privateKey key.NodePrivate, logf logger.Logf) *Server {
 		s.packetsDroppedReason.Get("queue_head"),
 		s.packetsDroppedReason.Get("queue_tail"),
 		s.packetsDroppedReason.Get("write_error"),
+		s.packetsDroppedReason.Get("rate_limited"),
 	}
 	s.packetsDroppedTypeDisco = s.packetsDroppedType.Get("disco")
 	s.packetsDroppedTypeOther = s.packetsDroppedType.Get("other")
@@ -346,7 +383,42 @@ func NewServer(privateKey key.NodePrivate, logf logger.Logf) *Server {
 //
 // It must be called before serving begins.
 func (s *Server) SetMeshKey(v string) {
-	s.meshKey = v
+	if v == "" {
+		s.meshKeys.Store(nil)
+		return
+	}
+	s.meshKeys.Store([]string{v})
+}
+
+// SetMeshKeys sets the pre-shared keys accepted from regional DERP servers
+// meshing with this one. The first is the one returned by MeshKey, for
+// connecting to the others. Having more than one lets a region rotate its
+// mesh key without restarting all its servers at once.
+//
+// Unlike SetMeshKey, it may be called while serving. Connected mesh peers
+// whose key is no longer accepted are disconnected.
+func (s *Server) SetMeshKeys(keys []string) {
+	keys = slices.DeleteFunc(slices.Clone(keys), func(k string) bool { return k == "" })
+	if len(keys) == 0 {
+		keys = nil
+	}
+	s.meshKeys.Store(keys)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, set := range s.clients {
+		set.ForeachClient(func(c *sclient) {
+			if c.canMesh && !slices.Contains(keys, c.info.MeshKey) {
+				c.logf("mesh key no longer accepted; disconnecting")
+				c.nc.Close()
+			}
+		})
+	}
+}
+
+// isMeshKey reports whether k is one of the accepted mesh keys.
+func (s *Server) isMeshKey(k string) bool {
+	return k != "" && slices.Contains(s.meshKeys.Load(), k)
 }
 
 // SetVerifyClients sets whether this DERP server verifies clients through tailscaled.
@@ -356,11 +428,100 @@ func (s *Server) SetVerifyClient(v bool) {
 	s.verifyClients = v
 }
 
+// SetAdmitClient sets a func that decides whether a client may connect,
+// called with its public key and remote address (zero if unknown) once it
+// has completed the handshake and been verified, if SetVerifyClient is
+// enabled. If f returns an error, the client is rejected. Mesh peers are
+// always admitted.
+//
+// It must be called before serving begins.
+func (s *Server) SetAdmitClient(f func(key.NodePublic, netip.AddrPort) error) {
+	s.admitClient = f
+}
+
+// SetOnClientConn sets a func to be called when a client, including a mesh
+// peer, has connected (with connected true) or disconnected (with connected
+// false), with the stats of the connection. It's called from the goroutine
+// serving the client, so it should return quickly.
+//
+// It must be called before serving begins.
+func (s *Server) SetOnClientConn(f func(cs ClientStats, connected bool)) {
+	s.onClientConn = f
+}
+
+// SetClientRateLimit limits the rate at which each client other than mesh
+// peers may send packets to bytesPerSec, allowing bursts of burst bytes.
+// Packets over the limit are dropped. Clients are told the limit when they
+// connect, so that they can drop such packets themselves rather than send
+// them. Zero bytesPerSec means no limit.
+//
+// The burst is raised if needed to fit a packet of the maximum size.
+//
+// It must be called before serving begins.
+func (s *Server) SetClientRateLimit(bytesPerSec, burst int) {
+	s.clientRate = xrate.Limit(bytesPerSec)
+	s.clientBurst = max(burst, maxSendFrameLen)
+}
+
+// SetTotalRateLimit limits the rate at which all clients other than mesh
+// peers together may send packets to bytesPerSec, allowing bursts of burst
+// bytes, so that a few busy clients can't use all of the server's bandwidth.
+// Packets over the limit are dropped. Zero bytesPerSec means no limit.
+//
+// The burst is raised if needed to fit a packet of the maximum size.
+//
+// It must be called before serving begins.
+func (s *Server) SetTotalRateLimit(bytesPerSec, burst int) {
+	if bytesPerSec <= 0 {
+		s.totalRate = nil
+		return
+	}
+	s.totalRate = xrate.NewLimiter(xrate.Limit(bytesPerSec), max(burst, maxSendFrameLen))
+}
+
+// defaultServerInfoInterval is how often clients are sent the server info
+// again while steering is set.
+const defaultServerInfoInterval = 5 * time.Minute
+
+// steering is the region steering advice of a Server.
+type steering struct {
+	capacity   int   // clients at full load, or 0 if unknown
+	alternates []int // DERP region IDs
+}
+
+// enabled reports whether there's any advice to give.
+func (st steering) enabled() bool {
+	return st.capacity > 0 || len(st.alternates) > 0
+}
+
+// SetSteering sets the advice that the server gives clients for rebalancing
+// traffic away from it in the server info frame: its load, as the fraction
+// of capacity clients that are connected, and the IDs of alternate DERP
+// regions that clients may move to when the load is high. Zero capacity
+// means the load isn't advertised.
+//
+// Clients get the server info when they connect, and again every few
+// minutes while steering is set, so that they follow the server's load. It
+// may be called at any time.
+func (s *Server) SetSteering(capacity int, alternates []int) {
+	s.steering.Store(steering{max(capacity, 0), slices.Clone(alternates)})
+}
+
+// maxSendFrameLen is the size of the largest frameSendPacket frame, as
+// counted by rate limits.
+const maxSendFrameLen = frameHeaderLen + keyLen + MaxPacketSize
+
 // HasMeshKey reports whether the server is configured with a mesh key.
-func (s *Server) HasMeshKey() bool { return s.meshKey != "" }
+func (s *Server) HasMeshKey() bool { return len(s.meshKeys.Load()) > 0 }
 
-// MeshKey returns the configured mesh key, if any.
-func (s *Server) MeshKey() string { return s.meshKey }
+// MeshKey returns the configured mesh key, if any. If there are several,
+// it's the first passed to SetMeshKeys.
+func (s *Server) MeshKey() string {
+	if keys := s.meshKeys.Load(); len(keys) > 0 {
+		return keys[0]
+	}
+	return ""
+}
 
 // PrivateKey returns the server's private key.
 func (s *Server) PrivateKey() key.NodePrivate { return s.privateKey }
@@ -394,6 +555,73 @@ func (s *Server) Close() error {
 	return nil
 }
 
+// StartDrain starts shutting down the server gracefully. New clients other
+// than mesh peers are rejected, and the connected ones are sent a frame
+// saying that the server is restarting, with ReconnectIn spread out over
+// [0, maxReconnectIn) so that they don't all reconnect at once. The server
+// keeps serving the connected clients until CloseIdleClients or Close closes
+// them.
+//
+// Just before that frame, clients are sent the server info again, saying
+// that the server is fully loaded, so that those that support region
+// steering move their home to one of the alternate regions set with
+// SetSteering. Without alternate regions, clients reconnect to the same
+// region, which only helps if other servers in it are up.
+func (s *Server) StartDrain(maxReconnectIn, tryFor time.Duration) {
+	if s.draining.Swap(true) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, set := range s.clients {
+		set.ForeachClient(func(c *sclient) {
+			if c.canMesh {
+				return
+			}
+			var reconnectIn time.Duration
+			if maxReconnectIn > 0 {
+				reconnectIn = time.Duration(rand.Int63n(int64(maxReconnectIn)))
+			}
+			select {
+			case c.restartingCh <- ServerRestartingMessage{ReconnectIn: reconnectIn, TryFor: tryFor}:
+			default:
+			}
+		})
+	}
+}
+
+// IsDraining reports whether StartDrain has been called.
+func (s *Server) IsDraining() bool {
+	return s.draining.Load()
+}
+
+// CloseIdleClients closes the connections of clients other than mesh peers
+// that haven't sent or received a data packet in the past idle duration,
+// nor since they connected. It returns the number of such clients left
+// connected.
+func (s *Server) CloseIdleClients(idle time.Duration) (remaining int) {
+	now := s.clock.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, set := range s.clients {
+		set.ForeachClient(func(c *sclient) {
+			if c.canMesh {
+				return
+			}
+			last := c.connectedAt
+			if ns := c.lastPacket.Load(); ns != 0 {
+				last = time.Unix(0, ns)
+			}
+			if now.Sub(last) >= idle {
+				c.nc.Close()
+				return
+			}
+			remaining++
+		})
+	}
+	return remaining
+}
+
 func (s *Server) isClosed() bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -601,6 +829,7 @@ func (s *Server) unregisterClient(c *sclient) {
 	delete(s.keyOfAddr, c.remoteIPPort)
 
 	s.curClients.Add(-1)
+	s.disconnects.Add(1)
 	if c.preferred {
 		s.curHomeClients.Add(-1)
 	}
@@ -684,14 +913,18 @@ func (s *Server) accept(ctx context.Context, nc Conn, brw *bufio.ReadWriter, rem
 	nc.SetDeadline(time.Now().Add(10 * time.Second))
 	bw := &lazyBufioWriter{w: nc, lbw: brw.Writer}
 	if err := s.sendServerKey(bw); err != nil {
+		s.handshakeFailures.Add(1)
 		return fmt.Errorf("send server key: %v", err)
 	}
 	nc.SetDeadline(time.Now().Add(10 * time.Second))
 	clientKey, clientInfo, err := s.recvClientKey(br)
 	if err != nil {
+		s.handshakeFailures.Add(1)
 		return fmt.Errorf("receive client key: %v", err)
 	}
-	if err := s.verifyClient(clientKey, clientInfo); err != nil {
+	remoteIPPort, _ := netip.ParseAddrPort(remoteAddr)
+	if err := s.verifyClient(clientKey, clientInfo, remoteIPPort); err != nil {
+		s.handshakeFailures.Add(1)
 		return fmt.Errorf("client %x rejected: %v", clientKey, err)
 	}
 
@@ -701,8 +934,6 @@ func (s *Server) accept(ctx context.Context, nc Conn, brw *bufio.ReadWriter, rem
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	remoteIPPort, _ := netip.ParseAddrPort(remoteAddr)
-
 	c := &sclient{
 		connNum:        connNum,
 		s:              s,
@@ -717,13 +948,16 @@ func (s *Server) accept(ctx context.Context, nc Conn, brw *bufio.ReadWriter, rem
 		sendQueue:      make(chan pkt, perClientSendQueueDepth),
 		discoSendQueue: make(chan pkt, perClientSendQueueDepth),
 		sendPongCh:     make(chan [8]byte, 1),
+		restartingCh:   make(chan ServerRestartingMessage, 1),
 		peerGone:       make(chan peerGoneMsg),
-		canMesh:        clientInfo.MeshKey != "" && clientInfo.MeshKey == s.meshKey,
+		canMesh:        s.isMeshKey(clientInfo.MeshKey),
 		peerGoneLim:    rate.NewLimiter(rate.Every(time.Second), 3),
 	}
 
 	if c.canMesh {
 		c.meshUpdate = make(chan struct{})
+	} else if s.clientRate > 0 {
+		c.sendRate = xrate.NewLimiter(s.clientRate, s.clientBurst)
 	}
 	if clientInfo != nil {
 		c.info = *clientInfo
@@ -737,8 +971,12 @@ func (s *Server) accept(ctx context.Context, nc Conn, brw *bufio.ReadWriter, rem
 
 	s.registerClient(c)
 	defer s.unregisterClient(c)
+	if f := s.onClientConn; f != nil {
+		f(c.stats(), true)
+		defer func() { f(c.stats(), false) }()
+	}
 
-	err = s.sendServerInfo(c.bw, clientKey)
+	err = s.sendServerInfo(c.bw, clientKey, c.sendRate)
 	if err != nil {
 		return fmt.Errorf("send server info: %v", err)
 	}
@@ -962,6 +1200,14 @@ func (c *sclient) handleFrameSendPacket(ft frameType, fl uint32) error {
 	if err != nil {
 		return fmt.Errorf("client %x: recvPacket: %v", c.key, err)
 	}
+	c.packetsRecv.Add(1)
+	c.bytesRecv.Add(int64(len(contents)))
+	c.lastPacket.Store(s.clock.Now().UnixNano())
+	if !c.allowSend(frameHeaderLen + int(fl)) {
+		s.recordDrop(contents, c.key, dstKey, dropReasonRateLimited)
+		c.debugLogf("SendPacket for %s, dropping with reason=%s", dstKey.ShortString(), dropReasonRateLimited)
+		return nil
+	}
 
 	var fwd PacketForwarder
 	var dstLen int
@@ -1010,6 +1256,22 @@ func (c *sclient) handleFrameSendPacket(ft frameType, fl uint32) error {
 	return c.sendPkt(dst, p)
 }
 
+// allowSend reports whether the client may send a frame of n bytes now,
+// within its own rate limit and that of all clients, if any.
+func (c *sclient) allowSend(n int) bool {
+	if c.canMesh {
+		return true
+	}
+	now := c.s.clock.Now()
+	if c.sendRate != nil && !c.sendRate.AllowN(now, n) {
+		return false
+	}
+	if r := c.s.totalRate; r != nil && !r.AllowN(now, n) {
+		return false
+	}
+	return true
+}
+
 func (c *sclient) debugLogf(format string, v ...any) {
 	if c.debug {
 		c.logf(format, v...)
@@ -1029,6 +1291,7 @@ func (c *sclient) debugLogf(format string, v ...any) {
 	dropReasonQueueTail                          // destination queue is full, dropped packet at queue tail
 	dropReasonWriteError                         // OS write() failed
 	dropReasonDupClient                          // the public key is connected 2+ times (active/active, fighting)
+	dropReasonRateLimited                        // the sender exceeded its rate limit or the server's
 )
 
 func (s *Server) recordDrop(packetBytes []byte, srcKey, dstKey key.NodePublic, reason dropReason) {
@@ -1116,21 +1379,28 @@ func (c *sclient) requestMeshUpdate() {
 	}
 }
 
-func (s *Server) verifyClient(clientKey key.NodePublic, info *clientInfo) error {
-	if !s.verifyClients {
-		return nil
+func (s *Server) verifyClient(clientKey key.NodePublic, info *clientInfo, remoteIPPort netip.AddrPort) error {
+	isMesh := info != nil && s.isMeshKey(info.MeshKey)
+	if s.draining.Load() && !isMesh {
+		return errors.New("server is draining")
 	}
-	status, err := tailscale.Status(context.TODO())
-	if err != nil {
-		return fmt.Errorf("failed to query local tailscaled status: %w", err)
-	}
-	if clientKey == status.Self.PublicKey {
-		return nil
+	if s.verifyClients {
+		status, err := tailscale.Status(context.TODO())
+		if err != nil {
+			return fmt.Errorf("failed to query local tailscaled status: %w", err)
+		}
+		if clientKey != status.Self.PublicKey {
+			if _, exists := status.Peer[clientKey]; !exists {
+				return fmt.Errorf("client %v not in set of peers", clientKey)
+			}
+		}
+		// TODO(bradfitz): add policy for configurable bandwidth rate per client?
 	}
-	if _, exists := status.Peer[clientKey]; !exists {
-		return fmt.Errorf("client %v not in set of peers", clientKey)
+	if s.admitClient != nil && !isMesh {
+		if err := s.admitClient(clientKey, remoteIPPort); err != nil {
+			return err
+		}
 	}
-	// TODO(bradfitz): add policy for configurable bandwidth rate per client?
 	return nil
 }
 
@@ -1199,10 +1469,27 @@ type serverInfo struct {
 
 	TokenBucketBytesPerSecond int `json:",omitempty"`
 	TokenBucketBytesBurst     int `json:",omitempty"`
+
+	Load             float64 `json:",omitempty"`
+	AlternateRegions []int   `json:",omitempty"`
 }
 
-func (s *Server) sendServerInfo(bw *lazyBufioWriter, clientKey key.NodePublic) error {
-	msg, err := json.Marshal(serverInfo{Version: ProtocolVersion})
+func (s *Server) sendServerInfo(bw *lazyBufioWriter, clientKey key.NodePublic, sendRate *xrate.Limiter) error {
+	si := serverInfo{Version: ProtocolVersion}
+	if sendRate != nil {
+		si.TokenBucketBytesPerSecond = int(sendRate.Limit())
+		si.TokenBucketBytesBurst = sendRate.Burst()
+	}
+	st := s.steering.Load()
+	switch {
+	case s.draining.Load():
+		// Clients should move away before the server goes down.
+		si.Load = 1
+	case st.capacity > 0:
+		si.Load = float64(s.curClients.Value()) / float64(st.capacity)
+	}
+	si.AlternateRegions = st.alternates
+	msg, err := json.Marshal(si)
 	if err != nil {
 		return err
 	}
@@ -1315,17 +1602,18 @@ type sclient struct {
 	key            key.NodePublic
 	info           clientInfo
 	logf           logger.Logf
-	done           <-chan struct{}  // closed when connection closes
-	remoteIPPort   netip.AddrPort   // zero if remoteAddr is not ip:port.
-	sendQueue      chan pkt         // packets queued to this client; never closed
-	discoSendQueue chan pkt         // important packets queued to this client; never closed
-	sendPongCh     chan [8]byte     // pong replies to send to the client; never closed
-	peerGone       chan peerGoneMsg // write request that a peer is not at this server (not used by mesh peers)
-	meshUpdate     chan struct{}    // write request to write peerStateChange
-	canMesh        bool             // clientInfo had correct mesh token for inter-region routing
-	isDup          atomic.Bool      // whether more than 1 sclient for key is connected
-	isDisabled     atomic.Bool      // whether sends to this peer are disabled due to active/active dups
-	debug          bool             // turn on for verbose logging
+	done           <-chan struct{}              // closed when connection closes
+	remoteIPPort   netip.AddrPort               // zero if remoteAddr is not ip:port.
+	sendQueue      chan pkt                     // packets queued to this client; never closed
+	discoSendQueue chan pkt                     // important packets queued to this client; never closed
+	sendPongCh     chan [8]byte                 // pong replies to send to the client; never closed
+	restartingCh   chan ServerRestartingMessage // restarting frame to send to the client; never closed
+	peerGone       chan peerGoneMsg             // write request that a peer is not at this server (not used by mesh peers)
+	meshUpdate     chan struct{}                // write request to write peerStateChange
+	canMesh        bool                         // clientInfo had correct mesh token for inter-region routing
+	isDup          atomic.Bool                  // whether more than 1 sclient for key is connected
+	isDisabled     atomic.Bool                  // whether sends to this peer are disabled due to active/active dups
+	debug          bool                         // turn on for verbose logging
 
 	// Owned by run, not thread-safe.
 	br          *bufio.Reader
@@ -1335,6 +1623,13 @@ type sclient struct {
 	// Owned by sender, not thread-safe.
 	bw *lazyBufioWriter
 
+	// Per-client traffic stats, for ClientStats.
+	packetsRecv atomic.Int64 // data packets received from the client
+	bytesRecv   atomic.Int64
+	packetsSent atomic.Int64 // data packets sent to the client
+	bytesSent   atomic.Int64
+	lastPacket  atomic.Int64 // unix nanos of the last data packet sent or received
+
 	// Guarded by s.mu
 	//
 	// peerStateChange is used by mesh peers (a set of regional
@@ -1343,6 +1638,10 @@ type sclient struct {
 	// to this node.
 	peerStateChange []peerConnState
 
+	// sendRate, if non-nil, limits the bytes per second that the client
+	// may send. See Server.SetClientRateLimit.
+	sendRate *xrate.Limiter
+
 	// peerGoneLimiter limits how often the server will inform a
 	// client that it's trying to establish a direct connection
 	// through us with a peer we have no record of.
@@ -1443,6 +1742,8 @@ func (c *sclient) sendLoop(ctx context.Context) error {
 	jitter := time.Duration(rand.Intn(5000)) * time.Millisecond
 	keepAliveTick, keepAliveTickChannel := c.s.clock.NewTicker(keepAlive + jitter)
 	defer keepAliveTick.Stop()
+	serverInfoTick, serverInfoTickChannel := c.s.clock.NewTicker(c.s.serverInfoInterval + jitter)
+	defer serverInfoTick.Stop()
 
 	var werr error // last write error
 	for {
@@ -1471,9 +1772,15 @@ func (c *sclient) sendLoop(ctx context.Context) error {
 		case msg := <-c.sendPongCh:
 			werr = c.sendPong(msg)
 			continue
+		case msg := <-c.restartingCh:
+			werr = c.sendDraining(msg)
+			continue
 		case <-keepAliveTickChannel:
 			werr = c.sendKeepAlive()
 			continue
+		case <-serverInfoTickChannel:
+			werr = c.sendServerInfoUpdate()
+			continue
 		default:
 			// Flush any writes from the 3 sends above, or from
 			// the blocking loop below.
@@ -1500,8 +1807,13 @@ func (c *sclient) sendLoop(ctx context.Context) error {
 		case msg := <-c.sendPongCh:
 			werr = c.sendPong(msg)
 			continue
+		case msg := <-c.restartingCh:
+			werr = c.sendDraining(msg)
+			continue
 		case <-keepAliveTickChannel:
 			werr = c.sendKeepAlive()
+		case <-serverInfoTickChannel:
+			werr = c.sendServerInfoUpdate()
 		}
 	}
 }
@@ -1516,6 +1828,16 @@ func (c *sclient) sendKeepAlive() error {
 	return writeFrameHeader(c.bw.bw(), frameKeepAlive, 0)
 }
 
+// sendServerInfoUpdate sends the server info again, with the current load,
+// if steering is set and c isn't a mesh peer, which doesn't need it.
+func (c *sclient) sendServerInfoUpdate() error {
+	if c.canMesh || !c.s.steering.Load().enabled() {
+		return nil
+	}
+	c.setWriteDeadline()
+	return c.s.sendServerInfo(c.bw, c.key, c.sendRate)
+}
+
 // sendPong sends a pong reply, without flushing.
 func (c *sclient) sendPong(data [8]byte) error {
 	c.s.sentPong.Add(1)
@@ -1527,6 +1849,31 @@ func (c *sclient) sendPong(data [8]byte) error {
 	return err
 }
 
+// sendDraining tells the client that the server is draining: first with
+// the server info frame, which then says that the server is fully loaded, so
+// that clients that were told of alternate regions move there, then with
+// the restarting frame m.
+func (c *sclient) sendDraining(m ServerRestartingMessage) error {
+	c.setWriteDeadline()
+	if err := c.s.sendServerInfo(c.bw, c.key, c.sendRate); err != nil {
+		return err
+	}
+	return c.sendRestarting(m)
+}
+
+// sendRestarting sends a restarting frame, without flushing.
+func (c *sclient) sendRestarting(m ServerRestartingMessage) error {
+	c.setWriteDeadline()
+	if err := writeFrameHeader(c.bw.bw(), frameRestarting, 8); err != nil {
+		return err
+	}
+	var b [8]byte
+	binary.BigEndian.PutUint32(b[:4], uint32(m.ReconnectIn.Milliseconds()))
+	binary.BigEndian.PutUint32(b[4:], uint32(m.TryFor.Milliseconds()))
+	_, err := c.bw.Write(b[:])
+	return err
+}
+
 // sendPeerGone sends a peerGone frame, without flushing.
 func (c *sclient) sendPeerGone(peer key.NodePublic, reason PeerGoneReasonType) error {
 	switch reason {
@@ -1630,6 +1977,9 @@ func (c *sclient) sendPacket(srcKey key.NodePublic, contents []byte) (err error)
 		} else {
 			c.s.packetsSent.Add(1)
 			c.s.bytesSent.Add(int64(len(contents)))
+			c.packetsSent.Add(1)
+			c.bytesSent.Add(int64(len(contents)))
+			c.lastPacket.Store(c.s.clock.Now().UnixNano())
 		}
 		c.debugLogf("sendPacket from %s: %v", srcKey.ShortString(), err)
 	}()
@@ -1819,6 +2169,8 @@ func (s *Server) ExpVar() expvar.Var {
 	m.Set("gauge_current_dup_client_conns", &s.dupClientConns)
 	m.Set("counter_total_dup_client_conns", &s.dupClientConnTotal)
 	m.Set("accepts", &s.accepts)
+	m.Set("handshake_failures", &s.handshakeFailures)
+	m.Set("disconnects", &s.disconnects)
 	m.Set("bytes_received", &s.bytesRecv)
 	m.Set("bytes_sent", &s.bytesSent)
 	m.Set("packets_dropped", &s.packetsDropped)
@@ -1849,6 +2201,47 @@ func (s *Server) ExpVar() expvar.Var {
 	return m
 }
 
+// ClientStats are the traffic stats of a client connection, as returned by
+// Server.ClientStats.
+type ClientStats struct {
+	Key         key.NodePublic
+	RemoteAddr  netip.AddrPort // zero if unknown
+	ConnectedAt time.Time
+	Mesh        bool // whether the client is a trusted mesh peer
+
+	PacketsRecv int64 // data packets received from the client
+	BytesRecv   int64
+	PacketsSent int64 // data packets sent to the client
+	BytesSent   int64
+}
+
+// ClientStats returns the traffic stats of the currently connected clients,
+// including each duplicate connection of a key.
+func (s *Server) ClientStats() []ClientStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]ClientStats, 0, len(s.clients))
+	for _, set := range s.clients {
+		set.ForeachClient(func(c *sclient) {
+			ret = append(ret, c.stats())
+		})
+	}
+	return ret
+}
+
+func (c *sclient) stats() ClientStats {
+	return ClientStats{
+		Key:         c.key,
+		RemoteAddr:  c.remoteIPPort,
+		ConnectedAt: c.connectedAt,
+		Mesh:        c.canMesh,
+		PacketsRecv: c.packetsRecv.Load(),
+		BytesRecv:   c.bytesRecv.Load(),
+		PacketsSent: c.packetsSent.Load(),
+		BytesSent:   c.bytesSent.Load(),
+	}
+}
+
 func (s *Server) ConsistencyCheck() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -16,10 +16,12 @@
 	"io"
 	"log"
 	"net"
+	"net/netip"
 	"os"
 	"reflect"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -723,6 +725,267 @@ func pubAll(b byte) (ret key.NodePublic) {
 	return key.NodePublicFromRaw32(mem.B(bs[:]))
 }
 
+func TestClientStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts := newTestServer(t, ctx)
+	defer ts.close(t)
+
+	c1 := newRegularClient(t, ts, "c1")
+	c2 := newRegularClient(t, ts, "c2")
+
+	msg := []byte("hello c1->c2")
+	if err := c1.c.Send(c2.pub, msg); err != nil {
+		t.Fatal(err)
+	}
+	m, err := c2.c.recvTimeout(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := m.(ReceivedPacket); !ok || !bytes.Equal(p.Data, msg) {
+		t.Fatalf("c2 got %#v; want packet %q", m, msg)
+	}
+
+	stats := map[key.NodePublic]ClientStats{}
+	for _, cs := range ts.s.ClientStats() {
+		stats[cs.Key] = cs
+	}
+	if len(stats) != 2 {
+		t.Fatalf("got stats for %d clients; want 2", len(stats))
+	}
+	n := int64(len(msg))
+	if got := stats[c1.pub]; got.PacketsRecv != 1 || got.BytesRecv != n || got.PacketsSent != 0 {
+		t.Errorf("c1 stats = %+v; want 1 packet of %d bytes received", got, n)
+	}
+	if got := stats[c2.pub]; got.PacketsSent != 1 || got.BytesSent != n || got.PacketsRecv != 0 {
+		t.Errorf("c2 stats = %+v; want 1 packet of %d bytes sent", got, n)
+	}
+
+	c1.close(t)
+	// A connection that hangs up without completing the handshake.
+	nc, err := net.Dial("tcp", ts.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ts.s.disconnects.Value() != 1 || ts.s.handshakeFailures.Value() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("disconnects=%d, handshake failures=%d; want 1, 1", ts.s.disconnects.Value(), ts.s.handshakeFailures.Value())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := len(ts.s.ClientStats()); got != 1 {
+		t.Errorf("got stats for %d clients after disconnect; want 1", got)
+	}
+}
+
+func TestOnClientConn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts := newTestServer(t, ctx)
+	defer ts.close(t)
+
+	type event struct {
+		cs        ClientStats
+		connected bool
+	}
+	events := make(chan event, 10)
+	ts.s.SetOnClientConn(func(cs ClientStats, connected bool) {
+		events <- event{cs, connected}
+	})
+	next := func() event {
+		t.Helper()
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for event")
+			panic("unreachable")
+		}
+	}
+
+	c1 := newRegularClient(t, ts, "c1")
+	if e := next(); !e.connected || e.cs.Key != c1.pub || e.cs.ConnectedAt.IsZero() {
+		t.Fatalf("got %+v; want c1 connected", e)
+	}
+	c2 := newRegularClient(t, ts, "c2")
+	next()
+
+	msg := []byte("hello c1->c2")
+	if err := c1.c.Send(c2.pub, msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c2.c.recvTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c1.close(t)
+	e := next()
+	if e.connected || e.cs.Key != c1.pub {
+		t.Fatalf("got %+v; want c1 disconnected", e)
+	}
+	if e.cs.PacketsRecv != 1 || e.cs.BytesRecv != int64(len(msg)) {
+		t.Errorf("c1 disconnect stats = %+v; want 1 packet of %d bytes received", e.cs, len(msg))
+	}
+}
+
+func TestAdmitClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts := newTestServer(t, ctx)
+	defer ts.close(t)
+
+	var rejected atomic.Int64
+	ts.s.SetAdmitClient(func(k key.NodePublic, _ netip.AddrPort) error {
+		rejected.Add(1)
+		return errors.New("nope")
+	})
+
+	// Mesh peers are always admitted.
+	newTestWatcher(t, ts, "mesh")
+	if got := rejected.Load(); got != 0 {
+		t.Fatalf("admit func called %d times for mesh peer; want 0", got)
+	}
+
+	nc, err := net.Dial("tcp", ts.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	c, err := NewClient(key.NewNode(), nc, bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc)), t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, err := c.recvTimeout(5 * time.Second); err == nil {
+		t.Fatalf("rejected client got %T; want error", m)
+	}
+	if got := rejected.Load(); got != 1 {
+		t.Errorf("admit func called %d times; want 1", got)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts := newTestServer(t, ctx)
+	defer ts.close(t)
+
+	mesh := newTestWatcher(t, ts, "mesh")
+	c1 := newRegularClient(t, ts, "c1")
+	c2 := newRegularClient(t, ts, "c2")
+	mesh.wantPresent(t, mesh.pub, c1.pub, c2.pub)
+
+	ts.s.SetSteering(100, []int{2})
+	ts.s.StartDrain(time.Second, 5*time.Second)
+	if !ts.s.IsDraining() {
+		t.Fatal("IsDraining = false after StartDrain")
+	}
+	for _, c := range []*testClient{c1, c2} {
+		// The server info comes first, saying the server is fully
+		// loaded, to steer clients to the alternate regions.
+		m, err := c.c.recvTimeout(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		si, ok := m.(ServerInfoMessage)
+		if !ok {
+			t.Fatalf("%s got %T; want ServerInfoMessage", c.name, m)
+		}
+		if si.Load != 1 || !reflect.DeepEqual(si.AlternateRegions, []int{2}) {
+			t.Errorf("%s got load %v, alternates %v; want 1, [2]", c.name, si.Load, si.AlternateRegions)
+		}
+
+		m, err = c.c.recvTimeout(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rm, ok := m.(ServerRestartingMessage)
+		if !ok {
+			t.Fatalf("%s got %T; want ServerRestartingMessage", c.name, m)
+		}
+		if rm.ReconnectIn < 0 || rm.ReconnectIn >= time.Second || rm.TryFor != 5*time.Second {
+			t.Errorf("%s got %+v", c.name, rm)
+		}
+	}
+
+	// New clients are rejected, but not mesh peers.
+	nc, err := net.Dial("tcp", ts.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	c3, err := NewClient(key.NewNode(), nc, bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc)), t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, err := c3.recvTimeout(5 * time.Second); err == nil {
+		t.Fatalf("new client got %T while draining; want error", m)
+	}
+	mesh2 := newTestWatcher(t, ts, "mesh2")
+	mesh.wantPresent(t, mesh2.pub)
+
+	if got := ts.s.CloseIdleClients(time.Hour); got != 2 {
+		t.Errorf("CloseIdleClients(1h) = %d; want 2", got)
+	}
+	if got := ts.s.CloseIdleClients(0); got != 0 {
+		t.Errorf("CloseIdleClients(0) = %d; want 0", got)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for ts.s.IsClientConnectedForTest(c1.pub) || ts.s.IsClientConnectedForTest(c2.pub) {
+		if time.Now().After(deadline) {
+			t.Fatal("idle clients still connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !ts.s.IsClientConnectedForTest(mesh.pub) {
+		t.Error("mesh peer disconnected")
+	}
+}
+
+func TestSetMeshKeys(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts := newTestServer(t, ctx)
+	defer ts.close(t)
+
+	old := newTestWatcher(t, ts, "old") // uses "mesh-key"
+	old.wantPresent(t, old.pub)
+
+	ts.s.SetMeshKeys([]string{"new-key", "mesh-key"})
+	if got := ts.s.MeshKey(); got != "new-key" {
+		t.Errorf("MeshKey = %q; want new-key", got)
+	}
+	newWatcher := newTestClient(t, ts, "new", func(nc net.Conn, priv key.NodePrivate, logf logger.Logf) (*Client, error) {
+		brw := bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))
+		c, err := NewClient(priv, nc, brw, logf, MeshKey("new-key"))
+		if err != nil {
+			return nil, err
+		}
+		waitConnect(t, c)
+		if err := c.WatchConnectionChanges(); err != nil {
+			return nil, err
+		}
+		return c, nil
+	})
+	newWatcher.wantPresent(t, old.pub, newWatcher.pub)
+	if !ts.s.IsClientConnectedForTest(old.pub) {
+		t.Fatal("peer with old key disconnected while still accepted")
+	}
+
+	ts.s.SetMeshKeys([]string{"new-key"})
+	deadline := time.Now().Add(5 * time.Second)
+	for ts.s.IsClientConnectedForTest(old.pub) {
+		if time.Now().After(deadline) {
+			t.Fatal("peer with old key still connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !ts.s.IsClientConnectedForTest(newWatcher.pub) {
+		t.Error("peer with new key disconnected")
+	}
+}
+
 func TestForwarderRegistration(t *testing.T) {
 	s := &Server{
 		clients:     make(map[key.NodePublic]clientSet),
@@ -1487,6 +1750,56 @@ func TestClientSendRateLimiting(t *testing.T) {
 	}
 }
 
+func TestServerRateLimits(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		set  func(*Server)
+	}{
+		{"per-client", func(s *Server) { s.SetClientRateLimit(1, 0) }},
+		{"total", func(s *Server) { s.SetTotalRateLimit(1, 0) }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ts := newTestServer(t, ctx)
+			defer ts.close(t)
+			tt.set(ts.s)
+
+			alice := newRegularClient(t, ts, "alice")
+			bob := newRegularClient(t, ts, "bob")
+
+			// Ignore the limit advertised to clients, to check that the
+			// server enforces it.
+			alice.c.setSendRateLimiter(ServerInfoMessage{})
+
+			// The burst fits one packet of the maximum size.
+			big := make([]byte, MaxPacketSize)
+			for i := 0; i < 2; i++ {
+				if err := alice.c.Send(bob.pub, big); err != nil {
+					t.Fatal(err)
+				}
+			}
+			small := []byte("hello")
+			if err := alice.c.Send(bob.pub, small); err != nil {
+				t.Fatal(err)
+			}
+			m, err := bob.c.recvTimeout(time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p, ok := m.(ReceivedPacket); !ok || len(p.Data) != MaxPacketSize {
+				t.Fatalf("bob got %T; want packet of %d bytes", m, MaxPacketSize)
+			}
+			if m, err := bob.c.recvTimeout(100 * time.Millisecond); err == nil {
+				t.Fatalf("bob got %T; want nothing", m)
+			}
+			if got := ts.s.packetsDroppedReasonCounters[dropReasonRateLimited].Value(); got != 2 {
+				t.Errorf("rate limited drops = %d; want 2", got)
+			}
+		})
+	}
+}
+
 func TestServerRepliesToPing(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -1516,3 +1829,67 @@ func TestServerRepliesToPing(t *testing.T) {
 		}
 	}
 }
+
+func TestServerInfoSteering(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts := newTestServer(t, ctx)
+	defer ts.close(t)
+	ts.s.serverInfoInterval = 10 * time.Millisecond
+
+	connect := func(name string) (ServerInfoMessage, *testClient) {
+		t.Helper()
+		var si ServerInfoMessage
+		tc := newTestClient(t, ts, name, func(nc net.Conn, priv key.NodePrivate, logf logger.Logf) (*Client, error) {
+			brw := bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))
+			c, err := NewClient(priv, nc, brw, logf)
+			if err != nil {
+				return nil, err
+			}
+			m, err := c.Recv()
+			if err != nil {
+				return nil, err
+			}
+			si = m.(ServerInfoMessage)
+			return c, nil
+		})
+		return si, tc
+	}
+
+	if si, _ := connect("a"); si.Load != 0 || len(si.AlternateRegions) != 0 {
+		t.Errorf("without steering: got load %v, alternates %v; want none", si.Load, si.AlternateRegions)
+	}
+	ts.s.SetSteering(4, []int{2, 3})
+	if si, _ := connect("b"); si.Load != 0.5 || !reflect.DeepEqual(si.AlternateRegions, []int{2, 3}) {
+		t.Errorf("with steering: got load %v, alternates %v; want 0.5, [2 3]", si.Load, si.AlternateRegions)
+	}
+
+	// Connected clients are sent the server info again, with the
+	// current load.
+	_, tc := connect("c")
+	nextInfo := func() ServerInfoMessage {
+		t.Helper()
+		for {
+			m, err := tc.c.recvTimeout(10 * time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if si, ok := m.(ServerInfoMessage); ok {
+				return si
+			}
+		}
+	}
+	if si := nextInfo(); si.Load != 0.75 {
+		t.Errorf("server info update: got load %v; want 0.75", si.Load)
+	}
+	ts.s.SetSteering(6, []int{2})
+	for {
+		si := nextInfo()
+		if si.Load == 0.5 && reflect.DeepEqual(si.AlternateRegions, []int{2}) {
+			break
+		}
+		if si.Load != 0.75 {
+			t.Fatalf("server info update: got load %v, alternates %v; want 0.5, [2]", si.Load, si.AlternateRegions)
+		}
+	}
+}
@@ -56,6 +56,12 @@ type Client struct {
 	MeshKey   string             // optional; for trusted clients
 	IsProber  bool               // optional; for probers to optional declare themselves as such
 
+	// HTTP2, if true, makes the client offer HTTP/2 when connecting over
+	// TLS, and carry the DERP connection in an HTTP/2 request if the server
+	// picks it, for networks whose proxies only forward HTTP/2. If DERP
+	// over HTTP/2 then fails, the client falls back to HTTP/1.1.
+	HTTP2 bool
+
 	// WatchConnectionChanges is whether the client wishes to subscribe to
 	// notifications about clients connecting & disconnecting.
 	//
@@ -90,6 +96,7 @@ type Client struct {
 	preferred    bool
 	canAckPings  bool
 	closed       bool
+	noHTTP2      bool // DERP over HTTP/2 failed, so don't try it again
 	netConn      io.Closer
 	client       *derp.Client
 	connGen      int // incremented once per new connection; valid values are >0
@@ -297,6 +304,17 @@ func useWebsockets() bool {
 	return false
 }
 
+// debugDERPHTTP2 makes clients try DERP over HTTP/2, as if Client.HTTP2 were
+// set.
+var debugDERPHTTP2 = envknob.RegisterBool("TS_DEBUG_DERP_HTTP2_CLIENT")
+
+// useHTTP2Locked reports whether to offer HTTP/2 to the server.
+//
+// c.mu must be held.
+func (c *Client) useHTTP2Locked() bool {
+	return (c.HTTP2 || debugDERPHTTP2()) && !c.noHTTP2
+}
+
 func (c *Client) connect(ctx context.Context, caller string) (client *derp.Client, connGen int, err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -335,23 +353,16 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 		}
 	}
 
-	var tcpConn net.Conn
-
 	defer func() {
 		if err != nil {
 			if ctx.Err() != nil {
 				err = fmt.Errorf("%v: %v", ctx.Err(), err)
 			}
 			err = fmt.Errorf("%s connect to %v: %v", caller, c.targetString(reg), err)
-			if tcpConn != nil {
-				go tcpConn.Close()
-			}
 		}
 	}()
 
-	var node *tailcfg.DERPNode // nil when using c.url to dial
-	switch {
-	case useWebsockets():
+	if useWebsockets() {
 		var urlStr string
 		if c.url != nil {
 			urlStr = c.url.String()
@@ -384,15 +395,58 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 		c.netConn = conn
 		c.connGen++
 		return c.client, c.connGen, nil
-	case c.url != nil:
+	}
+
+	derpClient, netConn, tlsState, err := c.dialDERPLocked(ctx, caller, reg, c.useHTTP2Locked())
+	if errors.Is(err, errHTTP2Failed) && ctx.Err() == nil {
+		// The server, or a proxy on the way, speaks HTTP/2 but not DERP
+		// over it. Use HTTP/1.1 from now on.
+		c.logf("%s: %v; falling back to HTTP/1.1", caller, err)
+		c.noHTTP2 = true
+		derpClient, netConn, tlsState, err = c.dialDERPLocked(ctx, caller, reg, false)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	c.serverPubKey = derpClient.ServerPublicKey()
+	c.client = derpClient
+	c.netConn = netConn
+	c.tlsState = tlsState
+	c.connGen++
+	return c.client, c.connGen, nil
+}
+
+// errHTTP2Failed is returned by dialDERPLocked, wrapped, when it negotiated
+// HTTP/2 with the server but couldn't start a DERP connection over it.
+var errHTTP2Failed = errors.New("DERP over HTTP/2 failed")
+
+// dialDERPLocked dials the DERP server of region reg, or of c.url if reg is
+// nil, and starts a DERP connection to it, returning the DERP client, the
+// connection to close to end it, and the TLS state, if using TLS.
+//
+// If allowHTTP2 is set, HTTP/2 is offered during the TLS handshake, and if
+// the server picks it, the DERP connection is carried by an HTTP/2 request
+// instead of an HTTP/1.1 connection upgrade. See connectHTTP2.
+//
+// c.mu must be held.
+func (c *Client) dialDERPLocked(ctx context.Context, caller string, reg *tailcfg.DERPRegion, allowHTTP2 bool) (_ *derp.Client, _ io.Closer, _ *tls.ConnectionState, err error) {
+	var tcpConn net.Conn
+	defer func() {
+		if err != nil && tcpConn != nil {
+			go tcpConn.Close()
+		}
+	}()
+
+	var node *tailcfg.DERPNode // nil when using c.url to dial
+	if c.url != nil {
 		c.logf("%s: connecting to %v", caller, c.url)
 		tcpConn, err = c.dialURL(ctx)
-	default:
+	} else {
 		c.logf("%s: connecting to derp-%d (%v)", caller, reg.RegionID, reg.RegionCode)
 		tcpConn, node, err = c.dialRegion(ctx, reg)
 	}
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, nil, err
 	}
 
 	// Now that we have a TCP connection, force close it if the
@@ -423,15 +477,21 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 	var serverPub key.NodePublic // or zero if unknown (if not using TLS or TLS middlebox eats it)
 	var serverProtoVersion int
 	var tlsState *tls.ConnectionState
+	var brw *bufio.ReadWriter
+	var derpConn derp.Conn // what the derp.Client speaks DERP over
 	if c.useHTTPS() {
-		tlsConn := c.tlsClient(tcpConn, node)
+		var nextProtos []string
+		if allowHTTP2 {
+			nextProtos = []string{"h2", "http/1.1"}
+		}
+		tlsConn := c.tlsClient(tcpConn, node, nextProtos...)
 		httpConn = tlsConn
 
 		// Force a handshake now (instead of waiting for it to
 		// be done implicitly on read/write) so we can check
 		// the ConnectionState.
 		if err := tlsConn.Handshake(); err != nil {
-			return nil, 0, err
+			return nil, nil, nil, err
 		}
 
 		// We expect to be using TLS 1.3 to our own servers, and only
@@ -450,81 +510,92 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 		if cs.Version >= tls.VersionTLS13 {
 			serverPub, serverProtoVersion = parseMetaCert(cs.PeerCertificates)
 		}
+
+		if cs.NegotiatedProtocol == "h2" {
+			hc, err := c.connectHTTP2(tlsConn, node)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("%w: %v", errHTTP2Failed, err)
+			}
+			httpConn = hc
+			derpConn = hc
+			brw = bufio.NewReadWriter(bufio.NewReader(hc), bufio.NewWriter(hc))
+		}
 	} else {
 		httpConn = tcpConn
 	}
 
-	brw := bufio.NewReadWriter(bufio.NewReader(httpConn), bufio.NewWriter(httpConn))
-	var derpClient *derp.Client
+	if derpConn == nil {
+		derpConn = httpConn
+		brw = bufio.NewReadWriter(bufio.NewReader(httpConn), bufio.NewWriter(httpConn))
 
-	req, err := http.NewRequest("GET", c.urlString(node), nil)
-	if err != nil {
-		return nil, 0, err
-	}
-	req.Header.Set("Upgrade", "DERP")
-	req.Header.Set("Connection", "Upgrade")
-
-	if !serverPub.IsZero() && serverProtoVersion != 0 {
-		// parseMetaCert found the server's public key (no TLS
-		// middlebox was in the way), so skip the HTTP upgrade
-		// exchange.  See https://github.com/tailscale/tailscale/issues/693
-		// for an overview. We still send the HTTP request
-		// just to get routed into the server's HTTP Handler so it
-		// can Hijack the request, but we signal with a special header
-		// that we don't want to deal with its HTTP response.
-		req.Header.Set(fastStartHeader, "1") // suppresses the server's HTTP response
-		if err := req.Write(brw); err != nil {
-			return nil, 0, err
-		}
-		// No need to flush the HTTP request. the derp.Client's initial
-		// client auth frame will flush it.
-	} else {
-		if err := req.Write(brw); err != nil {
-			return nil, 0, err
-		}
-		if err := brw.Flush(); err != nil {
-			return nil, 0, err
-		}
-
-		resp, err := http.ReadResponse(brw.Reader, req)
+		req, err := http.NewRequest("GET", c.urlString(node), nil)
 		if err != nil {
-			return nil, 0, err
+			return nil, nil, nil, err
 		}
-		if resp.StatusCode != http.StatusSwitchingProtocols {
-			b, _ := io.ReadAll(resp.Body)
-			resp.Body.Close()
-			return nil, 0, fmt.Errorf("GET failed: %v: %s", err, b)
+		req.Header.Set("Upgrade", "DERP")
+		req.Header.Set("Connection", "Upgrade")
+
+		if !serverPub.IsZero() && serverProtoVersion != 0 {
+			// parseMetaCert found the server's public key (no TLS
+			// middlebox was in the way), so skip the HTTP upgrade
+			// exchange.  See https://github.com/tailscale/tailscale/issues/693
+			// for an overview. We still send the HTTP request
+			// just to get routed into the server's HTTP Handler so it
+			// can Hijack the request, but we signal with a special header
+			// that we don't want to deal with its HTTP response.
+			req.Header.Set(fastStartHeader, "1") // suppresses the server's HTTP response
+			if err := req.Write(brw); err != nil {
+				return nil, nil, nil, err
+			}
+			// No need to flush the HTTP request. the derp.Client's initial
+			// client auth frame will flush it.
+		} else {
+			if err := req.Write(brw); err != nil {
+				return nil, nil, nil, err
+			}
+			if err := brw.Flush(); err != nil {
+				return nil, nil, nil, err
+			}
+
+			resp, err := http.ReadResponse(brw.Reader, req)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if resp.StatusCode != http.StatusSwitchingProtocols {
+				b, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				return nil, nil, nil, fmt.Errorf("GET failed: %v: %s", err, b)
+			}
 		}
 	}
-	derpClient, err = derp.NewClient(c.privateKey, httpConn, brw, c.logf,
+	derpClient, err := derp.NewClient(c.privateKey, derpConn, brw, c.logf,
 		derp.MeshKey(c.MeshKey),
 		derp.ServerPublicKey(serverPub),
 		derp.CanAckPings(c.canAckPings),
 		derp.IsProber(c.IsProber),
 	)
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, nil, err
 	}
 	if c.preferred {
 		if err := derpClient.NotePreferred(true); err != nil {
 			go httpConn.Close()
-			return nil, 0, err
+			return nil, nil, nil, err
 		}
 	}
 
 	if c.WatchConnectionChanges {
 		if err := derpClient.WatchConnectionChanges(); err != nil {
 			go httpConn.Close()
-			return nil, 0, err
+			return nil, nil, nil, err
 		}
 	}
-
-	c.serverPubKey = derpClient.ServerPublicKey()
-	c.client = derpClient
-	c.netConn = tcpConn
-	c.tlsState = tlsState
-	c.connGen++
-	return c.client, c.connGen, nil
+	if hc, ok := httpConn.(*http2ClientConn); ok {
+		// Closing the TCP connection alone would leave the HTTP/2
+		// transport behind.
+		return derpClient, hc, tlsState, nil
+	}
+	return derpClient, tcpConn, tlsState, nil
 }
 
 // SetURLDialer sets the dialer to use for dialing URLs.
@@ -590,8 +661,13 @@ func (c *Client) dialRegion(ctx context.Context, reg *tailcfg.DERPRegion) (net.C
 	return nil, nil, firstErr
 }
 
-func (c *Client) tlsClient(nc net.Conn, node *tailcfg.DERPNode) *tls.Conn {
+// tlsClient returns a TLS client connection over nc to node, or to c.url if
+// node is nil, offering the application protocols nextProtos, if any.
+func (c *Client) tlsClient(nc net.Conn, node *tailcfg.DERPNode, nextProtos ...string) *tls.Conn {
 	tlsConf := tlsdial.Config(c.tlsServerName(node), c.TLSConfig)
+	if len(nextProtos) > 0 {
+		tlsConf.NextProtos = nextProtos
+	}
 	if node != nil {
 		node.InsecureForTests=true  // 取消https签名的校验
 		if node.InsecureForTests {
@@ -1028,6 +1104,22 @@ func (c *Client) RecvDetail() (m derp.ReceivedMessage, connGen int, err error) {
 	}
 }
 
+// SetMeshKey sets c.MeshKey, for use the next time c connects to the
+// server. Unlike setting the field directly, it's safe to do while c is in
+// use.
+func (c *Client) SetMeshKey(k string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.MeshKey = k
+}
+
+// Connected reports whether c currently has a connection to the server.
+func (c *Client) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.closed && c.client != nil
+}
+
 func (c *Client) isClosed() bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -4,10 +4,16 @@
 package derphttp
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
 	"strings"
+	"sync"
+	"time"
 
 	"tailscale.com/derp"
 )
@@ -18,8 +24,18 @@
 // following its HTTP request.
 const fastStartHeader = "Derp-Fast-Start"
 
+// Handler returns an http.Handler that serves DERP connections to s.
+//
+// Over HTTP/1.1, clients upgrade the connection to DERP. HTTP/2 has no
+// connection upgrades, so there, clients instead send a POST request whose
+// body carries their side of the DERP connection, and the response body
+// carries the server's. See serveHTTP2.
 func Handler(s *derp.Server) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 {
+			serveHTTP2(s, w, r)
+			return
+		}
 		up := strings.ToLower(r.Header.Get("Upgrade"))
 		if up != "websocket" && up != "derp" {
 			if up != "" {
@@ -58,3 +74,94 @@ func Handler(s *derp.Server) http.Handler {
 		s.Accept(r.Context(), netConn, conn, netConn.RemoteAddr().String())
 	})
 }
+
+// serveHTTP2 serves a DERP connection over the HTTP/2 request r, a full
+// duplex stream. This lets clients behind proxies that only forward HTTP/2
+// reach the server on the same port as other clients.
+func serveHTTP2(s *derp.Server, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "DERP over HTTP/2 requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Derp-Version", fmt.Sprint(derp.ProtocolVersion))
+	w.Header().Set("Derp-Public-Key", s.PublicKey().UntypedHexString())
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		log.Printf("derphttp: HTTP/2 flush failed: %v", err)
+		return
+	}
+	nc := &http2Conn{
+		body:       r.Body,
+		w:          w,
+		rc:         rc,
+		remoteAddr: r.RemoteAddr,
+	}
+	brw := bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))
+	s.Accept(r.Context(), nc, brw, r.RemoteAddr)
+}
+
+// http2Conn is a derp.Conn over an HTTP/2 stream, reading from the request
+// body and writing to the response.
+type http2Conn struct {
+	body       io.ReadCloser
+	w          io.Writer
+	rc         *http.ResponseController
+	remoteAddr string
+
+	mu     sync.Mutex // serializes writes and Close
+	closed bool
+}
+
+func (c *http2Conn) Read(p []byte) (int, error) {
+	return c.body.Read(p)
+}
+
+// Write writes p to the response and flushes it, as the derp.Server
+// buffers writes itself.
+func (c *http2Conn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.rc.Flush()
+}
+
+// Close closes the request body, which unblocks any Read. The stream
+// itself ends when the handler returns.
+func (c *http2Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	c.closed = true
+	return c.body.Close()
+}
+
+// LocalAddr returns an unspecified address, as the local address of the
+// underlying connection isn't exposed to handlers.
+func (c *http2Conn) LocalAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+// RemoteAddr returns the address of the client.
+func (c *http2Conn) RemoteAddr() net.Addr {
+	ap, _ := netip.ParseAddrPort(c.remoteAddr)
+	return net.TCPAddrFromAddrPort(ap)
+}
+
+func (c *http2Conn) SetDeadline(t time.Time) error {
+	if err := c.rc.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.rc.SetWriteDeadline(t)
+}
+
+func (c *http2Conn) SetReadDeadline(t time.Time) error  { return c.rc.SetReadDeadline(t) }
+func (c *http2Conn) SetWriteDeadline(t time.Time) error { return c.rc.SetWriteDeadline(t) }
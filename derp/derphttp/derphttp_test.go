@@ -9,12 +9,15 @@
 	"crypto/tls"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/netip"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"tailscale.com/derp"
+	"tailscale.com/tailcfg"
 	"tailscale.com/types/key"
 )
 
@@ -447,3 +450,177 @@ func TestRunWatchConnectionLoopServeConnect(t *testing.T) {
 	}
 	watcher.RunWatchConnectionLoop(ctx, key.NodePublic{}, t.Logf, noopAdd, noopRemove)
 }
+
+// newHTTP2TestServer returns a TLS test server serving DERP connections to
+// s with h, or Handler(s) if h is nil, with HTTP/2 enabled, along with a
+// client of it that offers HTTP/2.
+func newHTTP2TestServer(t *testing.T, s *derp.Server, h http.Handler) (*httptest.Server, func() *Client) {
+	if h == nil {
+		h = Handler(s)
+	}
+	ts := httptest.NewUnstartedServer(h)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	// Cleanups run in reverse, so ts.Close, which waits for the
+	// connections to end, runs last.
+	t.Cleanup(ts.Close)
+	t.Cleanup(func() { s.Close() })
+
+	port := ts.Listener.Addr().(*net.TCPAddr).Port
+	region := &tailcfg.DERPRegion{
+		RegionID:   1,
+		RegionCode: "test",
+		Nodes: []*tailcfg.DERPNode{{
+			Name:             "1a",
+			RegionID:         1,
+			HostName:         "localhost",
+			IPv4:             "127.0.0.1",
+			IPv6:             "none",
+			DERPPort:         port,
+			InsecureForTests: true,
+		}},
+	}
+	newClient := func() *Client {
+		c := NewRegionClient(key.NewNode(), t.Logf, nil, func() *tailcfg.DERPRegion { return region })
+		c.HTTP2 = true
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	return ts, newClient
+}
+
+func TestHTTP2(t *testing.T) {
+	s := derp.NewServer(key.NewNode(), t.Logf)
+	ts, newClient := newHTTP2TestServer(t, s, nil)
+
+	connect := func() *Client {
+		t.Helper()
+		c := newClient()
+		if err := c.Connect(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		c.mu.Lock()
+		_, isHTTP2 := c.netConn.(*http2ClientConn)
+		c.mu.Unlock()
+		if !isHTTP2 {
+			t.Fatal("not connected over HTTP/2")
+		}
+		if got, want := c.ServerPublicKey(), s.PublicKey(); got != want {
+			t.Errorf("server key = %v; want %v", got, want)
+		}
+		return c
+	}
+	c1 := connect()
+	c2 := connect()
+	recvPacket := func(c *Client) derp.ReceivedPacket {
+		t.Helper()
+		for {
+			m, err := c.Recv()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p, ok := m.(derp.ReceivedPacket); ok {
+				return p
+			}
+		}
+	}
+
+	// Wait for c2 to be registered before sending to it.
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.IsClientConnectedForTest(c2.SelfPublicKey()) {
+		if time.Now().After(deadline) {
+			t.Fatal("c2 not connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	msg := []byte("hello over h2")
+	if err := c1.Send(c2.SelfPublicKey(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if p := recvPacket(c2); !bytes.Equal(p.Data, msg) {
+		t.Errorf("got %q; want %q", p.Data, msg)
+	}
+
+	// Other methods are rejected.
+	res, err := ts.Client().Get(ts.URL + "/derp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET over HTTP/2: got %v; want 405", res.Status)
+	}
+}
+
+func TestHTTP2Fallback(t *testing.T) {
+	s := derp.NewServer(key.NewNode(), t.Logf)
+	var http2Requests atomic.Int32
+	_, newClient := newHTTP2TestServer(t, s, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 {
+			// Like a server predating DERP over HTTP/2.
+			http2Requests.Add(1)
+			http.Error(w, "DERP requires connection upgrade", http.StatusUpgradeRequired)
+			return
+		}
+		Handler(s).ServeHTTP(w, r)
+	}))
+
+	c := newClient()
+	for i := range 2 {
+		if err := c.Connect(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		c.mu.Lock()
+		dc := c.client
+		_, isHTTP2 := c.netConn.(*http2ClientConn)
+		c.mu.Unlock()
+		if isHTTP2 {
+			t.Fatal("connected over HTTP/2")
+		}
+		if got, want := c.ServerPublicKey(), s.PublicKey(); got != want {
+			t.Errorf("server key = %v; want %v", got, want)
+		}
+		if i == 0 {
+			c.closeForReconnect(dc)
+		}
+	}
+	if got := http2Requests.Load(); got != 1 {
+		t.Errorf("got %d HTTP/2 requests; want 1, before falling back", got)
+	}
+}
+
+func TestSteerRegion(t *testing.T) {
+	latency := map[int]time.Duration{
+		1: 20 * time.Millisecond,
+		2: 60 * time.Millisecond,
+		3: 40 * time.Millisecond,
+		4: 200 * time.Millisecond,
+	}
+	tests := []struct {
+		name    string
+		current int
+		si      derp.ServerInfoMessage
+		want    int
+	}{
+		{"no-hint", 1, derp.ServerInfoMessage{}, 1},
+		{"not-loaded", 1, derp.ServerInfoMessage{Load: 0.5, AlternateRegions: []int{2, 3}}, 1},
+		{"loaded-no-alternates", 1, derp.ServerInfoMessage{Load: 1}, 1},
+		{"loaded", 1, derp.ServerInfoMessage{Load: 0.95, AlternateRegions: []int{2, 3}}, 3},
+		{"alternates-too-far", 1, derp.ServerInfoMessage{Load: 1.2, AlternateRegions: []int{4}}, 1},
+		{"alternates-unreachable", 1, derp.ServerInfoMessage{Load: 1.2, AlternateRegions: []int{5}}, 1},
+		{"home-below-steering", 1, derp.ServerInfoMessage{Load: 0.8, AlternateRegions: []int{2, 3}}, 1},
+		{"stay-on-alternate", 2, derp.ServerInfoMessage{Load: 0.8, AlternateRegions: []int{2, 3}}, 2},
+		{"stay-on-alternate-loaded", 2, derp.ServerInfoMessage{Load: 0.95, AlternateRegions: []int{2, 3}}, 2},
+		{"return-home", 2, derp.ServerInfoMessage{Load: 0.7, AlternateRegions: []int{2, 3}}, 1},
+		{"return-home-no-hint", 3, derp.ServerInfoMessage{}, 1},
+		{"alternate-withdrawn", 2, derp.ServerInfoMessage{Load: 0.95, AlternateRegions: []int{3}}, 3},
+		{"alternate-too-far", 4, derp.ServerInfoMessage{Load: 0.8, AlternateRegions: []int{4}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SteerRegion(1, tt.current, tt.si, latency); got != tt.want {
+				t.Errorf("SteerRegion = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derphttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+// connectHTTP2 starts a DERP connection over tlsConn, on which HTTP/2 was
+// negotiated, to node, or to c.url if node is nil. The connection is
+// carried by a POST request whose body is our side of it, and the
+// response body the server's. See serveHTTP2 for the server side.
+//
+// The request lives as long as c, not ctx; a connect timeout closes
+// tlsConn instead.
+func (c *Client) connectHTTP2(tlsConn *tls.Conn, node *tailcfg.DERPNode) (*http2ClientConn, error) {
+	var dialed atomic.Bool
+	tr := &http.Transport{
+		ForceAttemptHTTP2: true,
+		DialTLSContext: func(context.Context, string, string) (net.Conn, error) {
+			if dialed.Swap(true) {
+				return nil, errors.New("HTTP/2 connection to DERP server already used")
+			}
+			return tlsConn, nil
+		},
+	}
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(c.ctx, "POST", c.urlString(node), pr)
+	if err != nil {
+		return nil, err
+	}
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		pw.Close()
+		return nil, err
+	}
+	if res.ProtoMajor != 2 || res.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		res.Body.Close()
+		pw.Close()
+		tr.CloseIdleConnections()
+		return nil, fmt.Errorf("POST failed: %v %v: %s", res.Proto, res.Status, bytes.TrimSpace(b))
+	}
+	return &http2ClientConn{
+		tlsConn: tlsConn,
+		tr:      tr,
+		body:    res.Body,
+		pw:      pw,
+	}, nil
+}
+
+// http2ClientConn is the client side of a DERP connection over HTTP/2,
+// writing to the request body and reading from the response body. Its
+// deadlines and local address are those of the TLS connection, which
+// carries no other requests.
+type http2ClientConn struct {
+	tlsConn *tls.Conn
+	tr      *http.Transport
+	body    io.ReadCloser  // the response body
+	pw      *io.PipeWriter // writes the request body
+
+	closeOnce sync.Once
+}
+
+func (c *http2ClientConn) Read(p []byte) (int, error)  { return c.body.Read(p) }
+func (c *http2ClientConn) Write(p []byte) (int, error) { return c.pw.Write(p) }
+
+// Close ends the request and closes the TLS connection.
+func (c *http2ClientConn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		c.pw.Close()
+		c.body.Close()
+		c.tr.CloseIdleConnections()
+		err = c.tlsConn.Close()
+	})
+	return err
+}
+
+func (c *http2ClientConn) LocalAddr() net.Addr                { return c.tlsConn.LocalAddr() }
+func (c *http2ClientConn) RemoteAddr() net.Addr               { return c.tlsConn.RemoteAddr() }
+func (c *http2ClientConn) SetDeadline(t time.Time) error      { return c.tlsConn.SetDeadline(t) }
+func (c *http2ClientConn) SetReadDeadline(t time.Time) error  { return c.tlsConn.SetReadDeadline(t) }
+func (c *http2ClientConn) SetWriteDeadline(t time.Time) error { return c.tlsConn.SetWriteDeadline(t) }
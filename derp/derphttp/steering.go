@@ -0,0 +1,76 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derphttp
+
+import (
+	"slices"
+	"time"
+
+	"tailscale.com/derp"
+)
+
+const (
+	// steerMinLoad is the server load at or above which SteerRegion moves
+	// clients to an alternate region.
+	steerMinLoad = 0.9
+
+	// steerReturnLoad is the server load below which SteerRegion moves
+	// clients that it moved to an alternate region back home. The gap
+	// between it and steerMinLoad keeps clients from flapping between
+	// regions while the load hovers around steerMinLoad.
+	steerReturnLoad = 0.75
+
+	// steerMaxExtraLatency is how much slower to reach than the home
+	// region an alternate region may be for SteerRegion to move clients
+	// to it.
+	steerMaxExtraLatency = 50 * time.Millisecond
+)
+
+// SteerRegion returns the DERP region that a client should use as its home
+// when it would otherwise use region home, given the ServerInfoMessage that
+// a server in home sent it, its latency to each region and the region
+// current that it uses now.
+//
+// That's home, unless the server is heavily loaded and suggests alternate
+// regions, at least one of which the client can reach without much more
+// latency; then it's the alternate with the lowest latency. This lets the
+// operators of DERP servers rebalance clients across regions without
+// changing the DERP map or DNS.
+//
+// A client that was already moved to an alternate region stays there until
+// the load of home drops well below the point at which it was moved.
+func SteerRegion(home, current int, si derp.ServerInfoMessage, latency map[int]time.Duration) int {
+	homeLatency, ok := latency[home]
+	if !ok {
+		return home
+	}
+	reachable := func(rid int) bool {
+		d, ok := latency[rid]
+		return ok && rid != home && d <= homeLatency+steerMaxExtraLatency
+	}
+	if current != home && si.Load >= steerReturnLoad && slices.Contains(si.AlternateRegions, current) && reachable(current) {
+		return current
+	}
+	if !SteeringAdvised(si) {
+		return home
+	}
+	best, bestLatency := home, time.Duration(0)
+	for _, rid := range si.AlternateRegions {
+		if !reachable(rid) {
+			continue
+		}
+		if d := latency[rid]; best == home || d < bestLatency {
+			best, bestLatency = rid, d
+		}
+	}
+	return best
+}
+
+// SteeringAdvised reports whether si, sent by a DERP server, advises clients
+// to move to an alternate region, as the server is heavily loaded or
+// draining. If so, clients should pick their home region again with
+// SteerRegion.
+func SteeringAdvised(si derp.ServerInfoMessage) bool {
+	return si.Load >= steerMinLoad && len(si.AlternateRegions) > 0
+}
@@ -20,6 +20,7 @@
 package disco
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -44,6 +45,10 @@
 	TypePing        = MessageType(0x01)
 	TypePong        = MessageType(0x02)
 	TypeCallMeMaybe = MessageType(0x03)
+
+	TypeHybridKEMInit     = MessageType(0x04)
+	TypeHybridKEMResponse = MessageType(0x05)
+	TypeHybridKEMAck      = MessageType(0x06)
 )
 
 const v0 = byte(0)
@@ -83,6 +88,12 @@ func Parse(p []byte) (Message, error) {
 		return parsePong(ver, p)
 	case TypeCallMeMaybe:
 		return parseCallMeMaybe(ver, p)
+	case TypeHybridKEMInit:
+		return parseHybridKEMInit(ver, p)
+	case TypeHybridKEMResponse:
+		return parseHybridKEMResponse(ver, p)
+	case TypeHybridKEMAck:
+		return parseHybridKEMAck(ver, p)
 	default:
 		return nil, fmt.Errorf("unknown message type 0x%02x", byte(t))
 	}
@@ -257,6 +268,89 @@ func parsePong(ver uint8, p []byte) (m *Pong, err error) {
 	return m, nil
 }
 
+// HybridKEMInit starts the experimental post-quantum hybrid key exchange
+// with a peer (see tailcfg.NodeAttrPQHybridKeyExchange). It's sent only over
+// DERP, by the peer with the lower node key.
+type HybridKEMInit struct {
+	// TxID is a random client-generated per-exchange transaction ID,
+	// echoed in the HybridKEMResponse.
+	TxID [12]byte
+
+	// Public is the sender's ephemeral hybrid KEM public key.
+	Public key.HybridKEMPublic
+}
+
+func (m *HybridKEMInit) AppendMarshal(b []byte) []byte {
+	ret, d := appendMsgHeader(b, TypeHybridKEMInit, v0, 12+key.HybridKEMPublicLen)
+	n := copy(d, m.TxID[:])
+	m.Public.AppendTo(d[:n])
+	return ret
+}
+
+func parseHybridKEMInit(ver uint8, p []byte) (m *HybridKEMInit, err error) {
+	if len(p) < 12+key.HybridKEMPublicLen {
+		return nil, errShort
+	}
+	m = new(HybridKEMInit)
+	p = p[copy(m.TxID[:], p):]
+	m.Public, err = key.HybridKEMPublicFromRaw(p[:key.HybridKEMPublicLen])
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// HybridKEMResponse is the reply to a HybridKEMInit, carrying the
+// ciphertext encapsulating the shared secret to the initiator's public key.
+type HybridKEMResponse struct {
+	TxID [12]byte
+
+	// Ciphertext is the output of HybridKEMPublic.Encapsulate; it's
+	// key.HybridKEMCiphertextLen bytes if valid.
+	Ciphertext []byte
+}
+
+func (m *HybridKEMResponse) AppendMarshal(b []byte) []byte {
+	ret, d := appendMsgHeader(b, TypeHybridKEMResponse, v0, 12+len(m.Ciphertext))
+	d = d[copy(d, m.TxID[:]):]
+	copy(d, m.Ciphertext)
+	return ret
+}
+
+func parseHybridKEMResponse(ver uint8, p []byte) (m *HybridKEMResponse, err error) {
+	if len(p) < 12 {
+		return nil, errShort
+	}
+	m = new(HybridKEMResponse)
+	p = p[copy(m.TxID[:], p):]
+	m.Ciphertext = bytes.Clone(p)
+	return m, nil
+}
+
+// HybridKEMAck confirms that its sender derived the shared secret of the
+// hybrid key exchange with transaction TxID. The initiator sends it once
+// it's decapsulated the HybridKEMResponse, and the responder, which only
+// then starts using the resulting preshared key, echoes it back so that
+// the initiator does too.
+type HybridKEMAck struct {
+	TxID [12]byte
+}
+
+func (m *HybridKEMAck) AppendMarshal(b []byte) []byte {
+	ret, d := appendMsgHeader(b, TypeHybridKEMAck, v0, 12)
+	copy(d, m.TxID[:])
+	return ret
+}
+
+func parseHybridKEMAck(ver uint8, p []byte) (m *HybridKEMAck, err error) {
+	if len(p) < 12 {
+		return nil, errShort
+	}
+	m = new(HybridKEMAck)
+	copy(m.TxID[:], p)
+	return m, nil
+}
+
 // MessageSummary returns a short summary of m for logging purposes.
 func MessageSummary(m Message) string {
 	switch m := m.(type) {
@@ -266,6 +360,12 @@ func MessageSummary(m Message) string {
 		return fmt.Sprintf("pong tx=%x", m.TxID[:6])
 	case *CallMeMaybe:
 		return "call-me-maybe"
+	case *HybridKEMInit:
+		return fmt.Sprintf("hybrid-kem-init tx=%x", m.TxID[:6])
+	case *HybridKEMResponse:
+		return fmt.Sprintf("hybrid-kem-response tx=%x", m.TxID[:6])
+	case *HybridKEMAck:
+		return fmt.Sprintf("hybrid-kem-ack tx=%x", m.TxID[:6])
 	default:
 		return fmt.Sprintf("%#v", m)
 	}
@@ -83,6 +83,21 @@ func TestMarshalAndParse(t *testing.T) {
 			},
 			want: "03 00 00 00 00 00 00 00 00 00 00 00 ff ff 01 02 03 04 02 37 20 01 00 00 00 00 00 00 00 00 00 00 00 00 34 56 03 15",
 		},
+		{
+			name: "hybrid_kem_response",
+			m: &HybridKEMResponse{
+				TxID:       [12]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12},
+				Ciphertext: []byte{0xaa, 0xbb, 0xcc},
+			},
+			want: "05 00 01 02 03 04 05 06 07 08 09 0a 0b 0c aa bb cc",
+		},
+		{
+			name: "hybrid_kem_ack",
+			m: &HybridKEMAck{
+				TxID: [12]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12},
+			},
+			want: "06 00 01 02 03 04 05 06 07 08 09 0a 0b 0c",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -109,6 +124,32 @@ func TestMarshalAndParse(t *testing.T) {
 	}
 }
 
+func TestHybridKEMInit(t *testing.T) {
+	raw := make([]byte, key.HybridKEMPublicLen)
+	for i := range raw {
+		raw[i] = byte(i)
+	}
+	pub, err := key.HybridKEMPublicFromRaw(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &HybridKEMInit{TxID: [12]byte{1, 2, 3}, Public: pub}
+	b := m.AppendMarshal(nil)
+	if want := MessageHeaderLen + 12 + key.HybridKEMPublicLen; len(b) != want {
+		t.Fatalf("marshaled to %d bytes, want %d", len(b), want)
+	}
+	back, err := Parse(b)
+	if err != nil {
+		t.Fatalf("parse back: %v", err)
+	}
+	if !reflect.DeepEqual(back, m) {
+		t.Errorf("message in %+v doesn't match Parse back result %+v", m, back)
+	}
+	if _, err := Parse(b[:len(b)-1]); err == nil {
+		t.Error("truncated message parsed")
+	}
+}
+
 func mustIPPort(s string) netip.AddrPort {
 	ipp, err := netip.ParseAddrPort(s)
 	if err != nil {
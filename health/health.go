@@ -74,6 +74,11 @@
 
 	// SysTKA is the name of the tailnet key authority subsystem.
 	SysTKA = Subsystem("tailnet-lock")
+
+	// SysCaptivePortal is the name of the captive portal detection
+	// subsystem. It is unhealthy while the network the node is on appears
+	// to be behind a captive portal.
+	SysCaptivePortal = Subsystem("captive-portal")
 )
 
 // NewWarnable returns a new warnable item that the caller can mark
@@ -204,6 +209,24 @@ func SetTKAHealth(err error) { setErr(SysTKA, err) }
 // TKAHealth returns the tailnet key authority error state.
 func TKAHealth() error { return get(SysTKA) }
 
+// errCaptivePortal is the SysCaptivePortal error state used while a captive
+// portal is detected.
+var errCaptivePortal = errors.New("network is behind a captive portal; log in to it to connect to Tailscale")
+
+// SetCaptivePortalDetected sets whether the network the node is on appears to
+// be behind a captive portal.
+func SetCaptivePortalDetected(detected bool) {
+	if detected {
+		setErr(SysCaptivePortal, errCaptivePortal)
+	} else {
+		setErr(SysCaptivePortal, nil)
+	}
+}
+
+// CaptivePortalDetected reports whether the network the node is on was last
+// found to be behind a captive portal.
+func CaptivePortalDetected() bool { return get(SysCaptivePortal) != nil }
+
 // SetLocalLogConfigHealth sets the error state of this client's local log configuration.
 func SetLocalLogConfigHealth(err error) {
 	mu.Lock()
@@ -34,6 +34,34 @@ func TestAppendWarnableDebugFlags(t *testing.T) {
 	}
 }
 
+func TestCaptivePortalDetected(t *testing.T) {
+	defer SetCaptivePortalDetected(false)
+
+	got := make(chan error, 1)
+	unregister := RegisterWatcher(func(sys Subsystem, err error) {
+		if sys == SysCaptivePortal {
+			got <- err
+		}
+	})
+	defer unregister()
+
+	SetCaptivePortalDetected(true)
+	if !CaptivePortalDetected() {
+		t.Fatal("CaptivePortalDetected = false after SetCaptivePortalDetected(true)")
+	}
+	if err := <-got; err == nil {
+		t.Fatal("watcher got nil error for detected captive portal")
+	}
+
+	SetCaptivePortalDetected(false)
+	if CaptivePortalDetected() {
+		t.Fatal("CaptivePortalDetected = true after SetCaptivePortalDetected(false)")
+	}
+	if err := <-got; err != nil {
+		t.Fatalf("watcher got error %v after captive portal cleared", err)
+	}
+}
+
 func resetWarnables() {
 	mu.Lock()
 	defer mu.Unlock()
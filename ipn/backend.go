@@ -69,6 +69,43 @@ type EngineStatus struct {
 	NotifyInitialTailFSShares // if set, the first Notify message (sent immediately) will contain the current TailFS Shares
 )
 
+// ErrorCode is a stable, machine-readable reason for an error or state
+// change reported in a Notify. New codes may be added over time, so
+// clients should treat unknown codes like ErrorCodeControl.
+type ErrorCode string
+
+const (
+	// ErrorCodeControl is a failure reported by the control server that
+	// has no more specific code. ErrMessage has the details.
+	ErrorCodeControl ErrorCode = "control-error"
+
+	// ErrorCodeAuthKeyRejected means the control server refused to
+	// register the node with its auth key, such as because the key
+	// expired, was revoked, or was already used.
+	ErrorCodeAuthKeyRejected ErrorCode = "authkey-rejected"
+
+	// ErrorCodeMachineNotApproved means the node is registered but waits
+	// for a tailnet admin to approve it (State NeedsMachineAuth).
+	ErrorCodeMachineNotApproved ErrorCode = "machine-not-approved"
+
+	// ErrorCodeNodeKeyExpired means the node key expired, so the node
+	// needs to log in again (State NeedsLogin).
+	ErrorCodeNodeKeyExpired ErrorCode = "node-key-expired"
+
+	// ErrorCodeRoutesNotApproved means some of the routes advertised by
+	// the node, such as subnet routes or an exit node, aren't approved by
+	// the control server, so peers won't use them.
+	ErrorCodeRoutesNotApproved ErrorCode = "routes-not-approved"
+
+	// ErrorCodeLoggingRequired means the tailnet requires logging, which
+	// is disabled on this node, so the node was stopped.
+	ErrorCodeLoggingRequired ErrorCode = "logging-required"
+
+	// ErrorCodeInUseOtherUser means the backend is in use by another
+	// user (State InUseOtherUser).
+	ErrorCodeInUseOtherUser ErrorCode = "in-use-other-user"
+)
+
 // Notify is a communication from a backend (e.g. tailscaled) to a frontend
 // (cmd/tailscale, iOS, macOS, Win Tasktray).
 // In any given notification, any or all of these may be nil, meaning
@@ -88,6 +125,13 @@ type Notify struct {
 	// For State InUseOtherUser, ErrMessage is not critical and just contains the details.
 	ErrMessage *string
 
+	// ErrorCode, if non-empty, is the machine-readable cause of ErrMessage
+	// or of the new State, so that clients can act on it without matching
+	// on the message text. It may be set without ErrMessage, such as when
+	// the State changes to NeedsMachineAuth, or for ErrorCodeRoutesNotApproved,
+	// which isn't critical.
+	ErrorCode ErrorCode `json:",omitempty"`
+
 	LoginFinished *empty.Message     // non-nil when/if the login process succeeded
 	State         *State             // if non-nil, the new or current IPN state
 	Prefs         *PrefsView         // if non-nil && Valid, the new or current preferences
@@ -129,6 +173,20 @@ type Notify struct {
 	// the application.
 	TailFSShares map[string]string `json:",omitempty"`
 
+	// TailFSChanged, if non-empty, lists the paths of the files and
+	// directories that changed on remote TailFS shares, as seen by local
+	// clients, like "/example.ts.net/host/share/file.txt". Clients that
+	// present the shares, like the macOS and Windows clients, can use this
+	// to refresh their views of them. A path that's a prefix of others means
+	// that anything under it may have changed.
+	TailFSChanged []string `json:",omitempty"`
+
+	// CaptivePortalDetected, if non-nil, reports whether the network the
+	// node is on appears to be behind a captive portal. GUI clients can use
+	// this to prompt the user to log in to the network, rather than just
+	// showing the node as offline.
+	CaptivePortalDetected *bool `json:",omitempty"`
+
 	// type is mirrored in xcode/Shared/IPN.swift
 }
 
@@ -138,6 +196,9 @@ func (n Notify) String() string {
 	if n.ErrMessage != nil {
 		fmt.Fprintf(&sb, "err=%q ", *n.ErrMessage)
 	}
+	if n.ErrorCode != "" {
+		fmt.Fprintf(&sb, "code=%v ", n.ErrorCode)
+	}
 	if n.LoginFinished != nil {
 		sb.WriteString("LoginFinished ")
 	}
@@ -168,6 +229,9 @@ func (n Notify) String() string {
 	if n.LocalTCPPort != nil {
 		fmt.Fprintf(&sb, "tcpport=%v ", n.LocalTCPPort)
 	}
+	if n.CaptivePortalDetected != nil {
+		fmt.Fprintf(&sb, "captiveportal=%v ", *n.CaptivePortalDetected)
+	}
 	s := sb.String()
 	return s[0:len(s)-1] + "}"
 }
@@ -4,6 +4,7 @@
 package ipn
 
 import (
+	"errors"
 	"net/netip"
 
 	"tailscale.com/tailcfg"
@@ -41,6 +42,13 @@ type ConfigVAlpha struct {
 	AutoUpdate      *AutoUpdatePrefs `json:",omitempty"`
 	ServeConfigTemp *ServeConfig     `json:",omitempty"` // TODO(bradfitz,maisem): make separate stable type for this
 
+	// ExitNodeID and ExitNodeIP select the exit node by its stable ID or
+	// by its Tailscale IP, like ExitNode does with either. At most one of
+	// ExitNode, ExitNodeID and ExitNodeIP may be set; an empty ExitNodeID
+	// clears the exit node.
+	ExitNodeID *tailcfg.StableNodeID `json:",omitempty"`
+	ExitNodeIP *netip.Addr           `json:",omitempty"`
+
 	// TODO(bradfitz,maisem): future something like:
 	// Profile map[string]*Config // keyed by alice@gmail.com, corp.com (TailnetSID)
 }
@@ -76,6 +84,20 @@ func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 		mp.RouteAll = c.AcceptRoutes.EqualBool(true)
 		mp.RouteAllSet = true
 	}
+	if n := btoi(c.ExitNode != nil) + btoi(c.ExitNodeID != nil) + btoi(c.ExitNodeIP != nil); n > 1 {
+		return mp, errors.New("only one of exitNode, ExitNodeID and ExitNodeIP may be set")
+	}
+	if c.ExitNodeID != nil {
+		mp.ExitNodeID = *c.ExitNodeID
+		mp.ExitNodeIDSet = true
+	}
+	if c.ExitNodeIP != nil {
+		if !c.ExitNodeIP.IsValid() {
+			return mp, errors.New("invalid ExitNodeIP")
+		}
+		mp.ExitNodeIP = *c.ExitNodeIP
+		mp.ExitNodeIPSet = true
+	}
 	if c.ExitNode != nil {
 		ip, err := netip.ParseAddr(*c.ExitNode)
 		if err == nil {
@@ -96,7 +118,7 @@ func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 	}
 	if c.DisableSNAT != "" {
 		mp.NoSNAT = c.DisableSNAT.EqualBool(true)
-		mp.NoSNAT = true
+		mp.NoSNATSet = true
 	}
 	if c.NetfilterMode != nil {
 		m, err := preftype.ParseNetfilterMode(*c.NetfilterMode)
@@ -128,3 +150,10 @@ func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
 	}
 	return mp, nil
 }
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/opt"
+	"tailscale.com/types/ptr"
+)
+
+func TestConfigVAlphaToPrefs(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       ConfigVAlpha
+		check   func(t *testing.T, mp MaskedPrefs)
+		wantErr bool
+	}{
+		{
+			name: "exit_node_ip",
+			c:    ConfigVAlpha{ExitNode: ptr.To("100.64.0.1")},
+			check: func(t *testing.T, mp MaskedPrefs) {
+				if !mp.ExitNodeIPSet || mp.ExitNodeIP != netip.MustParseAddr("100.64.0.1") {
+					t.Errorf("ExitNodeIP = %v, set %v", mp.ExitNodeIP, mp.ExitNodeIPSet)
+				}
+			},
+		},
+		{
+			name: "exit_node_id_field",
+			c:    ConfigVAlpha{ExitNodeID: ptr.To(tailcfg.StableNodeID("nABC"))},
+			check: func(t *testing.T, mp MaskedPrefs) {
+				if !mp.ExitNodeIDSet || mp.ExitNodeID != "nABC" {
+					t.Errorf("ExitNodeID = %q, set %v", mp.ExitNodeID, mp.ExitNodeIDSet)
+				}
+			},
+		},
+		{
+			name: "exit_node_ip_field",
+			c:    ConfigVAlpha{ExitNodeIP: ptr.To(netip.MustParseAddr("fd7a:115c:a1e0::1"))},
+			check: func(t *testing.T, mp MaskedPrefs) {
+				if !mp.ExitNodeIPSet || mp.ExitNodeIP != netip.MustParseAddr("fd7a:115c:a1e0::1") {
+					t.Errorf("ExitNodeIP = %v, set %v", mp.ExitNodeIP, mp.ExitNodeIPSet)
+				}
+			},
+		},
+		{
+			name: "exit_node_conflict",
+			c: ConfigVAlpha{
+				ExitNode:   ptr.To("nABC"),
+				ExitNodeIP: ptr.To(netip.MustParseAddr("100.64.0.1")),
+			},
+			wantErr: true,
+		},
+		{
+			name: "shields_up_operator_snat",
+			c: ConfigVAlpha{
+				ShieldsUp:    "true",
+				OperatorUser: ptr.To("alice"),
+				DisableSNAT:  opt.Bool("true"),
+			},
+			check: func(t *testing.T, mp MaskedPrefs) {
+				if !mp.ShieldsUpSet || !mp.ShieldsUp {
+					t.Errorf("ShieldsUp = %v, set %v", mp.ShieldsUp, mp.ShieldsUpSet)
+				}
+				if !mp.OperatorUserSet || mp.OperatorUser != "alice" {
+					t.Errorf("OperatorUser = %q, set %v", mp.OperatorUser, mp.OperatorUserSet)
+				}
+				if !mp.NoSNATSet || !mp.NoSNAT {
+					t.Errorf("NoSNAT = %v, set %v", mp.NoSNAT, mp.NoSNATSet)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mp, err := tt.c.ToPrefs()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ToPrefs error = %v; wantErr %v", err, tt.wantErr)
+			}
+			if tt.check != nil {
+				tt.check(t, mp)
+			}
+		})
+	}
+}
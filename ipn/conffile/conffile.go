@@ -10,6 +10,9 @@
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/tailscale/hujson"
 	"tailscale.com/ipn"
@@ -19,7 +22,7 @@
 type Config struct {
 	Path    string // disk path of HuJSON
 	Raw     []byte // raw bytes from disk, in HuJSON form
-	Std     []byte // standardized JSON form
+	Std     []byte // standardized JSON form, with includes merged and environment variables expanded
 	Version string // "alpha0" for now
 
 	// Parsed is the parsed config, converted from its on-disk version to the
@@ -36,6 +39,17 @@ func (c *Config) WantRunning() bool {
 }
 
 // Load reads and parses the config file at the provided path on disk.
+//
+// The file may include other config files, with a top-level "include"
+// field listing their paths, relative to the directory of the including
+// file. The included files are merged in order, and then the including
+// file on top of them: JSON objects are merged field by field, and any
+// other value replaces the included one. This lets a base config be shared
+// by several nodes, each with its own overrides.
+//
+// References to environment variables, like "${TS_AUTHKEY}", are expanded
+// in string values, including the "include" paths. A reference to an
+// unset variable is an error, and "$$" is a literal "$".
 func Load(path string) (*Config, error) {
 	var c Config
 	c.Path = path
@@ -45,9 +59,16 @@ func Load(path string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	c.Std, err = hujson.Standardize(c.Raw)
+	merged, err := loadMerged(path, c.Raw, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing config file %s HuJSON/JSON: %w", path, err)
+		return nil, err
+	}
+	if err := expandEnv(merged); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	c.Std, err = json.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
 	}
 	var ver struct {
 		Version string `json:"version"`
@@ -75,3 +96,211 @@ func Load(path string) (*Config, error) {
 	}
 	return &c, nil
 }
+
+// includeField is the name of the top-level field listing the files to
+// include.
+const includeField = "include"
+
+// loadMerged parses raw, the contents of the config file at path, and
+// returns it merged on top of the files it includes. stack is the chain of
+// files including path, to detect include cycles.
+func loadMerged(path string, raw []byte, stack []string) (map[string]any, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(stack, abs) {
+		return nil, fmt.Errorf("error parsing config file %s: include cycle: %s", path, strings.Join(append(stack, abs), " -> "))
+	}
+	stack = append(stack, abs)
+
+	std, err := hujson.Standardize(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file %s HuJSON/JSON: %w", path, err)
+	}
+	var m map[string]any
+	jd := json.NewDecoder(bytes.NewReader(std))
+	jd.UseNumber()
+	if err := jd.Decode(&m); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	if jd.More() {
+		return nil, fmt.Errorf("error parsing config file %s: trailing data after JSON object", path)
+	}
+	if m == nil {
+		return nil, fmt.Errorf("error parsing config file %s: not a JSON object", path)
+	}
+
+	k, ok := findKey(m, includeField)
+	if !ok {
+		return m, nil
+	}
+	includes, err := includePaths(m[k])
+	delete(m, k)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	merged := map[string]any{}
+	for _, inc := range includes {
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(filepath.Dir(path), inc)
+		}
+		incRaw, err := os.ReadFile(inc)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+		}
+		incMerged, err := loadMerged(inc, incRaw, stack)
+		if err != nil {
+			return nil, err
+		}
+		mergeInto(merged, incMerged)
+	}
+	mergeInto(merged, m)
+	return merged, nil
+}
+
+// includePaths returns the paths of the "include" field value v, with
+// environment variables expanded.
+func includePaths(v any) ([]string, error) {
+	list, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%q must be a list of paths", includeField)
+	}
+	paths := make([]string, 0, len(list))
+	for _, e := range list {
+		p, ok := e.(string)
+		if !ok || p == "" {
+			return nil, fmt.Errorf("%q must be a list of paths", includeField)
+		}
+		p, err := expandEnvString(p)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// findKey returns the key of m matching name case-insensitively, as
+// encoding/json matches field names.
+func findKey(m map[string]any, name string) (string, bool) {
+	if _, ok := m[name]; ok {
+		return name, true
+	}
+	for k := range m {
+		if strings.EqualFold(k, name) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// mergeInto merges src into dst, recursively for JSON objects. Other
+// values in src replace those in dst.
+func mergeInto(dst, src map[string]any) {
+	for k, v := range src {
+		dk, ok := findKey(dst, k)
+		if ok {
+			dm, dok := dst[dk].(map[string]any)
+			sm, sok := v.(map[string]any)
+			if dok && sok {
+				mergeInto(dm, sm)
+				continue
+			}
+			delete(dst, dk)
+		}
+		dst[k] = v
+	}
+}
+
+// expandEnv expands the environment variable references in the string
+// values of v, a decoded JSON value, in place.
+func expandEnv(v any) error {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			if s, ok := e.(string); ok {
+				x, err := expandEnvString(s)
+				if err != nil {
+					return fmt.Errorf("field %q: %w", k, err)
+				}
+				v[k] = x
+			} else if err := expandEnv(e); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for i, e := range v {
+			if s, ok := e.(string); ok {
+				x, err := expandEnvString(s)
+				if err != nil {
+					return err
+				}
+				v[i] = x
+			} else if err := expandEnv(e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// expandEnvString returns s with its references to environment variables,
+// like "${NAME}", replaced by their values, and "$$" by "$". Unlike
+// os.Expand, it's strict: it returns an error for a reference to an unset
+// variable, or a "$" that doesn't start a well-formed reference.
+func expandEnvString(s string) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+	var b strings.Builder
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '$')
+		if i < 0 {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+		switch {
+		case strings.HasPrefix(s, "$$"):
+			b.WriteByte('$')
+			s = s[2:]
+		case strings.HasPrefix(s, "${"):
+			end := strings.IndexByte(s, '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable reference in %q", s)
+			}
+			name := s[2:end]
+			if !validEnvName(name) {
+				return "", fmt.Errorf("invalid variable name %q", name)
+			}
+			val, ok := os.LookupEnv(name)
+			if !ok {
+				return "", fmt.Errorf("environment variable %q is not set", name)
+			}
+			b.WriteString(val)
+			s = s[end+1:]
+		default:
+			return "", fmt.Errorf("invalid \"$\" in %q; use \"$$\" for a literal \"$\"", s)
+		}
+	}
+	return b.String(), nil
+}
+
+// validEnvName reports whether name is a valid environment variable name:
+// letters, digits and underscores, not starting with a digit.
+func validEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_', 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+		case '0' <= r && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
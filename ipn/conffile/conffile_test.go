@@ -0,0 +1,149 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package conffile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, contents := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadInclude(t *testing.T) {
+	t.Setenv("TEST_NODE", "node1")
+	t.Setenv("TEST_AUTHKEY", "tskey-abc")
+	dir := writeFiles(t, map[string]string{
+		"base.hujson": `{
+			// Shared by all nodes.
+			"version": "alpha0",
+			"ServerURL": "https://control.example.com",
+			"AuthKey": "${TEST_AUTHKEY}",
+			"acceptDNS": false,
+			"AdvertiseRoutes": ["10.0.0.0/8"],
+		}`,
+		"nodes/node1.hujson": `{
+			"Hostname": "$${literal}-${TEST_NODE}",
+			"AdvertiseRoutes": ["192.168.0.0/24"],
+		}`,
+		"node.hujson": `{
+			"include": ["base.hujson", "nodes/${TEST_NODE}.hujson"],
+			"acceptdns": true,
+		}`,
+	})
+
+	c, err := Load(filepath.Join(dir, "node.hujson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := c.Parsed
+	if c.Version != "alpha0" {
+		t.Errorf("Version = %q; want alpha0", c.Version)
+	}
+	if p.ServerURL == nil || *p.ServerURL != "https://control.example.com" {
+		t.Errorf("ServerURL = %v; want from base", p.ServerURL)
+	}
+	if p.AuthKey == nil || *p.AuthKey != "tskey-abc" {
+		t.Errorf("AuthKey = %v; want expanded", p.AuthKey)
+	}
+	if p.Hostname == nil || *p.Hostname != "${literal}-node1" {
+		t.Errorf("Hostname = %v; want ${literal}-node1", p.Hostname)
+	}
+	if !p.AcceptDNS.EqualBool(true) {
+		t.Errorf("AcceptDNS = %v; want true, overridden case-insensitively", p.AcceptDNS)
+	}
+	if len(p.AdvertiseRoutes) != 1 || p.AdvertiseRoutes[0].String() != "192.168.0.0/24" {
+		t.Errorf("AdvertiseRoutes = %v; want replaced by the later include", p.AdvertiseRoutes)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			name: "unset_env",
+			files: map[string]string{
+				"c.hujson": `{"version": "alpha0", "Hostname": "${TEST_CONFFILE_UNSET}"}`,
+			},
+			wantErr: `"TEST_CONFFILE_UNSET" is not set`,
+		},
+		{
+			name: "bare_dollar",
+			files: map[string]string{
+				"c.hujson": `{"version": "alpha0", "Hostname": "a$b"}`,
+			},
+			wantErr: `invalid "$"`,
+		},
+		{
+			name: "unterminated",
+			files: map[string]string{
+				"c.hujson": `{"version": "alpha0", "Hostname": "${FOO"}`,
+			},
+			wantErr: "unterminated",
+		},
+		{
+			name: "bad_name",
+			files: map[string]string{
+				"c.hujson": `{"version": "alpha0", "Hostname": "${1FOO}"}`,
+			},
+			wantErr: "invalid variable name",
+		},
+		{
+			name: "cycle",
+			files: map[string]string{
+				"c.hujson": `{"version": "alpha0", "include": ["d.hujson"]}`,
+				"d.hujson": `{"include": ["c.hujson"]}`,
+			},
+			wantErr: "include cycle",
+		},
+		{
+			name: "include_not_list",
+			files: map[string]string{
+				"c.hujson": `{"version": "alpha0", "include": "d.hujson"}`,
+			},
+			wantErr: "must be a list of paths",
+		},
+		{
+			name: "missing_include",
+			files: map[string]string{
+				"c.hujson": `{"version": "alpha0", "include": ["nope.hujson"]}`,
+			},
+			wantErr: "nope.hujson",
+		},
+		{
+			name: "unknown_field_in_include",
+			files: map[string]string{
+				"c.hujson": `{"version": "alpha0", "include": ["d.hujson"]}`,
+				"d.hujson": `{"NoSuchField": true}`,
+			},
+			wantErr: "NoSuchField",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeFiles(t, tt.files)
+			_, err := Load(filepath.Join(dir, "c.hujson"))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load error = %v; want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// FunnelWindow is a recurring window of time during which Funnel traffic is
+// allowed, such as business hours. See ServeConfig.FunnelSchedule.
+type FunnelWindow struct {
+	// Days are the days of the week on which the window opens, as a
+	// comma-separated list of day names and ranges of them, such as
+	// "Mon-Fri" or "Sat,Sun". Days are matched by their first three
+	// letters, case-insensitively. Empty means every day.
+	Days string `json:",omitempty"`
+
+	// Start and End are the times of day at which the window opens and
+	// closes, as "15:04". End may be "24:00". If End is not after Start, the
+	// window closes on the following day.
+	Start string
+	End   string
+
+	// TimeZone is the IANA name of the time zone that Start and End are in,
+	// such as "Europe/Berlin". Empty means the local time zone of the
+	// machine.
+	TimeZone string `json:",omitempty"`
+}
+
+// funnelWindow is a parsed FunnelWindow.
+type funnelWindow struct {
+	days      [7]bool // indexed by time.Weekday
+	startH    int
+	startM    int
+	endH      int
+	endM      int
+	overnight bool // End is not after Start
+	loc       *time.Location
+}
+
+var weekdayNames = [7]string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) >= 3 {
+		for i, n := range weekdayNames {
+			if strings.HasPrefix(s, n) {
+				return time.Weekday(i), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid day %q", s)
+}
+
+func parseTimeOfDay(s string) (h, m int, err error) {
+	if s == "24:00" {
+		return 24, 0, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid time of day %q, want HH:MM", s)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+func (w FunnelWindow) parse() (*funnelWindow, error) {
+	var fw funnelWindow
+	if strings.TrimSpace(w.Days) == "" {
+		for i := range fw.days {
+			fw.days[i] = true
+		}
+	} else {
+		for _, f := range strings.Split(w.Days, ",") {
+			from, to, isRange := strings.Cut(f, "-")
+			d1, err := parseWeekday(from)
+			if err != nil {
+				return nil, err
+			}
+			d2 := d1
+			if isRange {
+				if d2, err = parseWeekday(to); err != nil {
+					return nil, err
+				}
+			}
+			// Ranges may wrap around the end of the week, as in "Fri-Mon".
+			for d := d1; ; d = (d + 1) % 7 {
+				fw.days[d] = true
+				if d == d2 {
+					break
+				}
+			}
+		}
+	}
+	var err error
+	if fw.startH, fw.startM, err = parseTimeOfDay(w.Start); err != nil {
+		return nil, err
+	}
+	if fw.startH == 24 {
+		return nil, errors.New("window can't start at 24:00")
+	}
+	if fw.endH, fw.endM, err = parseTimeOfDay(w.End); err != nil {
+		return nil, err
+	}
+	fw.overnight = fw.endH*60+fw.endM <= fw.startH*60+fw.startM
+	if w.TimeZone == "" {
+		fw.loc = time.Local
+	} else if fw.loc, err = time.LoadLocation(w.TimeZone); err != nil {
+		return nil, fmt.Errorf("invalid time zone %q: %w", w.TimeZone, err)
+	}
+	return &fw, nil
+}
+
+// Check reports whether w is a valid window.
+func (w FunnelWindow) Check() error {
+	if _, err := w.parse(); err != nil {
+		return fmt.Errorf("funnel window %v: %w", w, err)
+	}
+	return nil
+}
+
+// String returns w in the form "Mon-Fri 09:00-17:00 Europe/Berlin".
+func (w FunnelWindow) String() string {
+	var sb strings.Builder
+	if w.Days != "" {
+		sb.WriteString(w.Days)
+		sb.WriteByte(' ')
+	}
+	sb.WriteString(w.Start)
+	sb.WriteByte('-')
+	sb.WriteString(w.End)
+	if w.TimeZone != "" {
+		sb.WriteByte(' ')
+		sb.WriteString(w.TimeZone)
+	}
+	return sb.String()
+}
+
+// rangeOccurrences calls f with the start and end of each occurrence of w
+// that starts between the day before now and a week after it.
+func (w *funnelWindow) rangeOccurrences(now time.Time, f func(start, end time.Time)) {
+	y, m, d := now.In(w.loc).Date()
+	for i := -1; i <= 7; i++ {
+		start := time.Date(y, m, d+i, w.startH, w.startM, 0, 0, w.loc)
+		if !w.days[start.Weekday()] {
+			continue
+		}
+		endDay := d + i
+		if w.overnight {
+			endDay++
+		}
+		f(start, time.Date(y, m, endDay, w.endH, w.endM, 0, 0, w.loc))
+	}
+}
+
+func funnelWindowsOpenAt(ws []*funnelWindow, t time.Time) bool {
+	for _, w := range ws {
+		open := false
+		w.rangeOccurrences(t, func(start, end time.Time) {
+			if !t.Before(start) && t.Before(end) {
+				open = true
+			}
+		})
+		if open {
+			return true
+		}
+	}
+	return false
+}
+
+// FunnelScheduleAt reports whether the Funnel schedule made of windows is
+// open at now, and when it next opens or closes. A schedule without windows
+// is always open. Invalid windows are ignored; see FunnelWindow.Check.
+//
+// The returned next time is zero if the schedule doesn't change in the
+// coming week.
+func FunnelScheduleAt(windows []FunnelWindow, now time.Time) (open bool, next time.Time) {
+	if len(windows) == 0 {
+		return true, time.Time{}
+	}
+	var ws []*funnelWindow
+	for _, w := range windows {
+		if fw, err := w.parse(); err == nil {
+			ws = append(ws, fw)
+		}
+	}
+	open = funnelWindowsOpenAt(ws, now)
+
+	// Overlapping windows may have boundaries at which the schedule
+	// doesn't change, so check each one in order.
+	var bounds []time.Time
+	for _, w := range ws {
+		w.rangeOccurrences(now, func(start, end time.Time) {
+			if start.After(now) {
+				bounds = append(bounds, start)
+			}
+			if end.After(now) {
+				bounds = append(bounds, end)
+			}
+		})
+	}
+	slices.SortFunc(bounds, func(a, b time.Time) int { return a.Compare(b) })
+	for _, t := range bounds {
+		if funnelWindowsOpenAt(ws, t) != open {
+			return open, t
+		}
+	}
+	return open, time.Time{}
+}
+
+// CheckFunnelSchedule reports whether the Funnel schedules of sc and its
+// foreground configs are valid.
+func (sc *ServeConfig) CheckFunnelSchedule() error {
+	if sc == nil {
+		return nil
+	}
+	for _, w := range sc.FunnelSchedule {
+		if err := w.Check(); err != nil {
+			return err
+		}
+	}
+	for _, fg := range sc.Foreground {
+		if err := fg.CheckFunnelSchedule(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FunnelOpenForTarget reports whether Funnel traffic to target is allowed at
+// now, by either the background config or any of the foreground configs,
+// taking their Funnel schedules into account.
+func (v ServeConfigView) FunnelOpenForTarget(target HostPort, now time.Time) bool {
+	if v.AllowFunnel().Get(target) {
+		if open, _ := FunnelScheduleAt(v.ж.FunnelSchedule, now); open {
+			return true
+		}
+	}
+	var exists bool
+	v.Foreground().Range(func(_ string, v ServeConfigView) (cont bool) {
+		exists = v.FunnelOpenForTarget(target, now)
+		return !exists
+	})
+	return exists
+}
+
+// HasFunnelSchedule reports whether the background config or any of the
+// foreground configs allow Funnel traffic only part-time.
+func (v ServeConfigView) HasFunnelSchedule() bool {
+	var exists bool
+	v.rangeFunnelSchedules(func(ServeConfigView) { exists = true })
+	return exists
+}
+
+// rangeFunnelSchedules calls f with the background config and each of the
+// foreground configs that allow Funnel traffic only part-time.
+func (v ServeConfigView) rangeFunnelSchedules(f func(ServeConfigView)) {
+	if v.FunnelSchedule().Len() > 0 && v.AllowFunnel().Len() > 0 {
+		f(v)
+	}
+	v.Foreground().Range(func(_ string, v ServeConfigView) (cont bool) {
+		v.rangeFunnelSchedules(f)
+		return true
+	})
+}
+
+// NextFunnelScheduleChange reports when the Funnel schedule of the background
+// config or any of the foreground configs next opens or closes. It returns
+// the zero time if no schedule changes in the coming week.
+func (v ServeConfigView) NextFunnelScheduleChange(now time.Time) (next time.Time) {
+	v.rangeFunnelSchedules(func(v ServeConfigView) {
+		_, t := FunnelScheduleAt(v.ж.FunnelSchedule, now)
+		if !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	})
+	return next
+}
+
+// NextFunnelOpening reports when the next currently closed Funnel schedule
+// of the background config or any of the foreground configs opens, and the
+// targets that it allows Funnel traffic to. It returns the zero time if no
+// schedule opens in the coming week.
+func (v ServeConfigView) NextFunnelOpening(now time.Time) (next time.Time, targets []HostPort) {
+	v.rangeFunnelSchedules(func(v ServeConfigView) {
+		open, t := FunnelScheduleAt(v.ж.FunnelSchedule, now)
+		if open || t.IsZero() {
+			return
+		}
+		if next.IsZero() || t.Before(next) {
+			next, targets = t, nil
+		}
+		if !t.Equal(next) {
+			return
+		}
+		v.AllowFunnel().Range(func(hp HostPort, on bool) (cont bool) {
+			if on && !slices.Contains(targets, hp) {
+				targets = append(targets, hp)
+			}
+			return true
+		})
+	})
+	slices.Sort(targets)
+	return next, targets
+}
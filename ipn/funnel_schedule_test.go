@@ -0,0 +1,142 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFunnelWindowCheck(t *testing.T) {
+	for _, w := range []FunnelWindow{
+		{Start: "09:00", End: "17:00"},
+		{Days: "Mon-Fri", Start: "09:00", End: "17:00", TimeZone: "UTC"},
+		{Days: "sat, sunday", Start: "22:00", End: "02:00"},
+		{Days: "Fri-Mon", Start: "00:00", End: "24:00"},
+	} {
+		if err := w.Check(); err != nil {
+			t.Errorf("Check(%v) = %v, want nil", w, err)
+		}
+	}
+	for _, w := range []FunnelWindow{
+		{Start: "9am", End: "17:00"},
+		{Start: "09:00", End: "25:00"},
+		{Start: "24:00", End: "01:00"},
+		{Days: "Mo", Start: "09:00", End: "17:00"},
+		{Days: "Mon-", Start: "09:00", End: "17:00"},
+		{Start: "09:00", End: "17:00", TimeZone: "Nowhere/Special"},
+	} {
+		if err := w.Check(); err == nil {
+			t.Errorf("Check(%v) = nil, want error", w)
+		}
+	}
+}
+
+func TestFunnelScheduleAt(t *testing.T) {
+	// 2024-03-04 is a Monday.
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2024, 3, day, hour, min, 0, 0, time.UTC)
+	}
+	businessHours := FunnelWindow{Days: "Mon-Fri", Start: "09:00", End: "17:00", TimeZone: "UTC"}
+	overnight := FunnelWindow{Days: "Sat", Start: "22:00", End: "02:00", TimeZone: "UTC"}
+
+	tests := []struct {
+		name     string
+		windows  []FunnelWindow
+		now      time.Time
+		wantOpen bool
+		wantNext time.Time
+	}{
+		{
+			name:     "unscheduled",
+			now:      at(4, 12, 0),
+			wantOpen: true,
+		},
+		{
+			name:     "before-hours",
+			windows:  []FunnelWindow{businessHours},
+			now:      at(4, 8, 0),
+			wantNext: at(4, 9, 0),
+		},
+		{
+			name:     "during-hours",
+			windows:  []FunnelWindow{businessHours},
+			now:      at(4, 9, 0),
+			wantOpen: true,
+			wantNext: at(4, 17, 0),
+		},
+		{
+			name:     "friday-evening",
+			windows:  []FunnelWindow{businessHours},
+			now:      at(8, 17, 0),
+			wantNext: at(11, 9, 0),
+		},
+		{
+			name:     "overnight-after-midnight",
+			windows:  []FunnelWindow{overnight},
+			now:      at(10, 1, 0),
+			wantOpen: true,
+			wantNext: at(10, 2, 0),
+		},
+		{
+			name:     "overlapping",
+			windows:  []FunnelWindow{businessHours, {Days: "Mon", Start: "16:00", End: "20:00", TimeZone: "UTC"}},
+			now:      at(4, 10, 0),
+			wantOpen: true,
+			wantNext: at(4, 20, 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			open, next := FunnelScheduleAt(tt.windows, tt.now)
+			if open != tt.wantOpen || !next.Equal(tt.wantNext) {
+				t.Errorf("FunnelScheduleAt = %v, %v; want %v, %v", open, next, tt.wantOpen, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestServeConfigFunnelSchedule(t *testing.T) {
+	now := time.Date(2024, 3, 4, 8, 0, 0, 0, time.UTC) // Monday
+	sc := &ServeConfig{
+		AllowFunnel: map[HostPort]bool{"foo.test.ts.net:443": true},
+		FunnelSchedule: []FunnelWindow{
+			{Days: "Mon-Fri", Start: "09:00", End: "17:00", TimeZone: "UTC"},
+		},
+		Foreground: map[string]*ServeConfig{
+			"session": {AllowFunnel: map[HostPort]bool{"foo.test.ts.net:8443": true}},
+		},
+	}
+	v := sc.View()
+	if !v.HasFunnelSchedule() {
+		t.Error("HasFunnelSchedule = false, want true")
+	}
+	if v.FunnelOpenForTarget("foo.test.ts.net:443", now) {
+		t.Error("scheduled target open before hours")
+	}
+	if !v.FunnelOpenForTarget("foo.test.ts.net:443", now.Add(time.Hour)) {
+		t.Error("scheduled target closed during hours")
+	}
+	if !v.FunnelOpenForTarget("foo.test.ts.net:8443", now) {
+		t.Error("unscheduled foreground target closed")
+	}
+	if v.FunnelOpenForTarget("bar.test.ts.net:443", now.Add(time.Hour)) {
+		t.Error("unknown target open")
+	}
+	if got, want := v.NextFunnelScheduleChange(now), now.Add(time.Hour); !got.Equal(want) {
+		t.Errorf("NextFunnelScheduleChange = %v, want %v", got, want)
+	}
+	opening, targets := v.NextFunnelOpening(now)
+	if !opening.Equal(now.Add(time.Hour)) || len(targets) != 1 || targets[0] != "foo.test.ts.net:443" {
+		t.Errorf("NextFunnelOpening = %v, %v", opening, targets)
+	}
+	if opening, _ := v.NextFunnelOpening(now.Add(10 * time.Hour)); !opening.Equal(now.Add(25 * time.Hour)) {
+		t.Errorf("NextFunnelOpening after hours = %v, want next morning", opening)
+	}
+
+	sc.Foreground["session"].FunnelSchedule = []FunnelWindow{{Start: "9:00"}}
+	if err := sc.CheckFunnelSchedule(); err == nil {
+		t.Error("CheckFunnelSchedule accepted invalid foreground window")
+	}
+}
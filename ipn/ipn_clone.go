@@ -22,41 +22,47 @@ func (src *Prefs) Clone() *Prefs {
 	}
 	dst := new(Prefs)
 	*dst = *src
+	dst.ExitNodeAllowedLANRoutes = append(src.ExitNodeAllowedLANRoutes[:0:0], src.ExitNodeAllowedLANRoutes...)
 	dst.AdvertiseTags = append(src.AdvertiseTags[:0:0], src.AdvertiseTags...)
 	dst.AdvertiseRoutes = append(src.AdvertiseRoutes[:0:0], src.AdvertiseRoutes...)
+	dst.RouteMTUs = maps.Clone(src.RouteMTUs)
 	dst.Persist = src.Persist.Clone()
 	return dst
 }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _PrefsCloneNeedsRegeneration = Prefs(struct {
-	ControlURL             string
-	RouteAll               bool
-	AllowSingleHosts       bool
-	ExitNodeID             tailcfg.StableNodeID
-	ExitNodeIP             netip.Addr
-	ExitNodeAllowLANAccess bool
-	CorpDNS                bool
-	RunSSH                 bool
-	RunWebClient           bool
-	WantRunning            bool
-	LoggedOut              bool
-	ShieldsUp              bool
-	AdvertiseTags          []string
-	Hostname               string
-	NotepadURLs            bool
-	ForceDaemon            bool
-	Egg                    bool
-	AdvertiseRoutes        []netip.Prefix
-	NoSNAT                 bool
-	NetfilterMode          preftype.NetfilterMode
-	OperatorUser           string
-	ProfileName            string
-	AutoUpdate             AutoUpdatePrefs
-	AppConnector           AppConnectorPrefs
-	PostureChecking        bool
-	NetfilterKind          string
-	Persist                *persist.Persist
+	ControlURL               string
+	RouteAll                 bool
+	AllowSingleHosts         bool
+	ExitNodeID               tailcfg.StableNodeID
+	ExitNodeIP               netip.Addr
+	ExitNodeAllowLANAccess   bool
+	ExitNodeAllowedLANRoutes []netip.Prefix
+	CorpDNS                  bool
+	RunSSH                   bool
+	RunWebClient             bool
+	WantRunning              bool
+	LoggedOut                bool
+	ShieldsUp                bool
+	AdvertiseTags            []string
+	Hostname                 string
+	NotepadURLs              bool
+	ForceDaemon              bool
+	Egg                      bool
+	AdvertiseRoutes          []netip.Prefix
+	NoSNAT                   bool
+	NetfilterMode            preftype.NetfilterMode
+	OperatorUser             string
+	ProfileName              string
+	AutoUpdate               AutoUpdatePrefs
+	AppConnector             AppConnectorPrefs
+	PostureChecking          bool
+	NetfilterKind            string
+	UsageReports             bool
+	DNSQueryLog              bool
+	RouteMTUs                map[netip.Prefix]int
+	Persist                  *persist.Persist
 }{})
 
 // Clone makes a deep copy of ServeConfig.
@@ -80,6 +86,7 @@ func (src *ServeConfig) Clone() *ServeConfig {
 		}
 	}
 	dst.AllowFunnel = maps.Clone(src.AllowFunnel)
+	dst.FunnelSchedule = append(src.FunnelSchedule[:0:0], src.FunnelSchedule...)
 	if dst.Foreground != nil {
 		dst.Foreground = map[string]*ServeConfig{}
 		for k, v := range src.Foreground {
@@ -91,11 +98,12 @@ func (src *ServeConfig) Clone() *ServeConfig {
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _ServeConfigCloneNeedsRegeneration = ServeConfig(struct {
-	TCP         map[uint16]*TCPPortHandler
-	Web         map[HostPort]*WebServerConfig
-	AllowFunnel map[HostPort]bool
-	Foreground  map[string]*ServeConfig
-	ETag        string
+	TCP            map[uint16]*TCPPortHandler
+	Web            map[HostPort]*WebServerConfig
+	AllowFunnel    map[HostPort]bool
+	FunnelSchedule []FunnelWindow
+	Foreground     map[string]*ServeConfig
+	ETag           string
 }{})
 
 // Clone makes a deep copy of TCPPortHandler.
@@ -106,6 +114,7 @@ func (src *TCPPortHandler) Clone() *TCPPortHandler {
 	}
 	dst := new(TCPPortHandler)
 	*dst = *src
+	dst.Allow = src.Allow.Clone()
 	return dst
 }
 
@@ -115,6 +124,7 @@ func (src *TCPPortHandler) Clone() *TCPPortHandler {
 	HTTP         bool
 	TCPForward   string
 	TerminateTLS string
+	Allow        *ServeAccess
 }{})
 
 // Clone makes a deep copy of HTTPHandler.
@@ -125,6 +135,7 @@ func (src *HTTPHandler) Clone() *HTTPHandler {
 	}
 	dst := new(HTTPHandler)
 	*dst = *src
+	dst.Allow = src.Allow.Clone()
 	return dst
 }
 
@@ -133,6 +144,7 @@ func (src *HTTPHandler) Clone() *HTTPHandler {
 	Path  string
 	Proxy string
 	Text  string
+	Allow *ServeAccess
 }{})
 
 // Clone makes a deep copy of WebServerConfig.
@@ -156,3 +168,22 @@ func (src *WebServerConfig) Clone() *WebServerConfig {
 var _WebServerConfigCloneNeedsRegeneration = WebServerConfig(struct {
 	Handlers map[string]*HTTPHandler
 }{})
+
+// Clone makes a deep copy of ServeAccess.
+// The result aliases no memory with the original.
+func (src *ServeAccess) Clone() *ServeAccess {
+	if src == nil {
+		return nil
+	}
+	dst := new(ServeAccess)
+	*dst = *src
+	dst.Users = append(src.Users[:0:0], src.Users...)
+	dst.Tags = append(src.Tags[:0:0], src.Tags...)
+	return dst
+}
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _ServeAccessCloneNeedsRegeneration = ServeAccess(struct {
+	Users []string
+	Tags  []string
+}{})
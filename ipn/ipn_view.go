@@ -16,7 +16,7 @@
 	"tailscale.com/types/views"
 )
 
-//go:generate go run tailscale.com/cmd/cloner  -clonefunc=false -type=Prefs,ServeConfig,TCPPortHandler,HTTPHandler,WebServerConfig
+//go:generate go run tailscale.com/cmd/cloner  -clonefunc=false -type=Prefs,ServeConfig,TCPPortHandler,HTTPHandler,WebServerConfig,ServeAccess
 
 // View returns a readonly view of Prefs.
 func (p *Prefs) View() PrefsView {
@@ -63,12 +63,15 @@ func (v *PrefsView) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (v PrefsView) ControlURL() string                 { return v.ж.ControlURL }
-func (v PrefsView) RouteAll() bool                     { return v.ж.RouteAll }
-func (v PrefsView) AllowSingleHosts() bool             { return v.ж.AllowSingleHosts }
-func (v PrefsView) ExitNodeID() tailcfg.StableNodeID   { return v.ж.ExitNodeID }
-func (v PrefsView) ExitNodeIP() netip.Addr             { return v.ж.ExitNodeIP }
-func (v PrefsView) ExitNodeAllowLANAccess() bool       { return v.ж.ExitNodeAllowLANAccess }
+func (v PrefsView) ControlURL() string               { return v.ж.ControlURL }
+func (v PrefsView) RouteAll() bool                   { return v.ж.RouteAll }
+func (v PrefsView) AllowSingleHosts() bool           { return v.ж.AllowSingleHosts }
+func (v PrefsView) ExitNodeID() tailcfg.StableNodeID { return v.ж.ExitNodeID }
+func (v PrefsView) ExitNodeIP() netip.Addr           { return v.ж.ExitNodeIP }
+func (v PrefsView) ExitNodeAllowLANAccess() bool     { return v.ж.ExitNodeAllowLANAccess }
+func (v PrefsView) ExitNodeAllowedLANRoutes() views.Slice[netip.Prefix] {
+	return views.SliceOf(v.ж.ExitNodeAllowedLANRoutes)
+}
 func (v PrefsView) CorpDNS() bool                      { return v.ж.CorpDNS }
 func (v PrefsView) RunSSH() bool                       { return v.ж.RunSSH }
 func (v PrefsView) RunWebClient() bool                 { return v.ж.RunWebClient }
@@ -91,37 +94,46 @@ func (v PrefsView) AutoUpdate() AutoUpdatePrefs           { return v.ж.AutoUpda
 func (v PrefsView) AppConnector() AppConnectorPrefs       { return v.ж.AppConnector }
 func (v PrefsView) PostureChecking() bool                 { return v.ж.PostureChecking }
 func (v PrefsView) NetfilterKind() string                 { return v.ж.NetfilterKind }
-func (v PrefsView) Persist() persist.PersistView          { return v.ж.Persist.View() }
+func (v PrefsView) UsageReports() bool                    { return v.ж.UsageReports }
+func (v PrefsView) DNSQueryLog() bool                     { return v.ж.DNSQueryLog }
+func (v PrefsView) RouteMTUs() views.Map[netip.Prefix, int] {
+	return views.MapOf(v.ж.RouteMTUs)
+}
+func (v PrefsView) Persist() persist.PersistView { return v.ж.Persist.View() }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _PrefsViewNeedsRegeneration = Prefs(struct {
-	ControlURL             string
-	RouteAll               bool
-	AllowSingleHosts       bool
-	ExitNodeID             tailcfg.StableNodeID
-	ExitNodeIP             netip.Addr
-	ExitNodeAllowLANAccess bool
-	CorpDNS                bool
-	RunSSH                 bool
-	RunWebClient           bool
-	WantRunning            bool
-	LoggedOut              bool
-	ShieldsUp              bool
-	AdvertiseTags          []string
-	Hostname               string
-	NotepadURLs            bool
-	ForceDaemon            bool
-	Egg                    bool
-	AdvertiseRoutes        []netip.Prefix
-	NoSNAT                 bool
-	NetfilterMode          preftype.NetfilterMode
-	OperatorUser           string
-	ProfileName            string
-	AutoUpdate             AutoUpdatePrefs
-	AppConnector           AppConnectorPrefs
-	PostureChecking        bool
-	NetfilterKind          string
-	Persist                *persist.Persist
+	ControlURL               string
+	RouteAll                 bool
+	AllowSingleHosts         bool
+	ExitNodeID               tailcfg.StableNodeID
+	ExitNodeIP               netip.Addr
+	ExitNodeAllowLANAccess   bool
+	ExitNodeAllowedLANRoutes []netip.Prefix
+	CorpDNS                  bool
+	RunSSH                   bool
+	RunWebClient             bool
+	WantRunning              bool
+	LoggedOut                bool
+	ShieldsUp                bool
+	AdvertiseTags            []string
+	Hostname                 string
+	NotepadURLs              bool
+	ForceDaemon              bool
+	Egg                      bool
+	AdvertiseRoutes          []netip.Prefix
+	NoSNAT                   bool
+	NetfilterMode            preftype.NetfilterMode
+	OperatorUser             string
+	ProfileName              string
+	AutoUpdate               AutoUpdatePrefs
+	AppConnector             AppConnectorPrefs
+	PostureChecking          bool
+	NetfilterKind            string
+	UsageReports             bool
+	DNSQueryLog              bool
+	RouteMTUs                map[netip.Prefix]int
+	Persist                  *persist.Persist
 }{})
 
 // View returns a readonly view of ServeConfig.
@@ -185,6 +197,10 @@ func (v ServeConfigView) AllowFunnel() views.Map[HostPort, bool] {
 	return views.MapOf(v.ж.AllowFunnel)
 }
 
+func (v ServeConfigView) FunnelSchedule() views.Slice[FunnelWindow] {
+	return views.SliceOf(v.ж.FunnelSchedule)
+}
+
 func (v ServeConfigView) Foreground() views.MapFn[string, *ServeConfig, ServeConfigView] {
 	return views.MapFnOf(v.ж.Foreground, func(t *ServeConfig) ServeConfigView {
 		return t.View()
@@ -194,11 +210,12 @@ func (v ServeConfigView) ETag() string { return v.ж.ETag }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _ServeConfigViewNeedsRegeneration = ServeConfig(struct {
-	TCP         map[uint16]*TCPPortHandler
-	Web         map[HostPort]*WebServerConfig
-	AllowFunnel map[HostPort]bool
-	Foreground  map[string]*ServeConfig
-	ETag        string
+	TCP            map[uint16]*TCPPortHandler
+	Web            map[HostPort]*WebServerConfig
+	AllowFunnel    map[HostPort]bool
+	FunnelSchedule []FunnelWindow
+	Foreground     map[string]*ServeConfig
+	ETag           string
 }{})
 
 // View returns a readonly view of TCPPortHandler.
@@ -246,10 +263,11 @@ func (v *TCPPortHandlerView) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (v TCPPortHandlerView) HTTPS() bool          { return v.ж.HTTPS }
-func (v TCPPortHandlerView) HTTP() bool           { return v.ж.HTTP }
-func (v TCPPortHandlerView) TCPForward() string   { return v.ж.TCPForward }
-func (v TCPPortHandlerView) TerminateTLS() string { return v.ж.TerminateTLS }
+func (v TCPPortHandlerView) HTTPS() bool            { return v.ж.HTTPS }
+func (v TCPPortHandlerView) HTTP() bool             { return v.ж.HTTP }
+func (v TCPPortHandlerView) TCPForward() string     { return v.ж.TCPForward }
+func (v TCPPortHandlerView) TerminateTLS() string   { return v.ж.TerminateTLS }
+func (v TCPPortHandlerView) Allow() ServeAccessView { return v.ж.Allow.View() }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _TCPPortHandlerViewNeedsRegeneration = TCPPortHandler(struct {
@@ -257,6 +275,7 @@ func (v TCPPortHandlerView) TerminateTLS() string { return v.ж.TerminateTLS }
 	HTTP         bool
 	TCPForward   string
 	TerminateTLS string
+	Allow        *ServeAccess
 }{})
 
 // View returns a readonly view of HTTPHandler.
@@ -304,15 +323,17 @@ func (v *HTTPHandlerView) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (v HTTPHandlerView) Path() string  { return v.ж.Path }
-func (v HTTPHandlerView) Proxy() string { return v.ж.Proxy }
-func (v HTTPHandlerView) Text() string  { return v.ж.Text }
+func (v HTTPHandlerView) Path() string           { return v.ж.Path }
+func (v HTTPHandlerView) Proxy() string          { return v.ж.Proxy }
+func (v HTTPHandlerView) Text() string           { return v.ж.Text }
+func (v HTTPHandlerView) Allow() ServeAccessView { return v.ж.Allow.View() }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HTTPHandlerViewNeedsRegeneration = HTTPHandler(struct {
 	Path  string
 	Proxy string
 	Text  string
+	Allow *ServeAccess
 }{})
 
 // View returns a readonly view of WebServerConfig.
@@ -370,3 +391,57 @@ func (v WebServerConfigView) Handlers() views.MapFn[string, *HTTPHandler, HTTPHa
 var _WebServerConfigViewNeedsRegeneration = WebServerConfig(struct {
 	Handlers map[string]*HTTPHandler
 }{})
+
+// View returns a readonly view of ServeAccess.
+func (p *ServeAccess) View() ServeAccessView {
+	return ServeAccessView{ж: p}
+}
+
+// ServeAccessView provides a read-only view over ServeAccess.
+//
+// Its methods should only be called if `Valid()` returns true.
+type ServeAccessView struct {
+	// ж is the underlying mutable value, named with a hard-to-type
+	// character that looks pointy like a pointer.
+	// It is named distinctively to make you think of how dangerous it is to escape
+	// to callers. You must not let callers be able to mutate it.
+	ж *ServeAccess
+}
+
+// Valid reports whether underlying value is non-nil.
+func (v ServeAccessView) Valid() bool { return v.ж != nil }
+
+// AsStruct returns a clone of the underlying value which aliases no memory with
+// the original.
+func (v ServeAccessView) AsStruct() *ServeAccess {
+	if v.ж == nil {
+		return nil
+	}
+	return v.ж.Clone()
+}
+
+func (v ServeAccessView) MarshalJSON() ([]byte, error) { return json.Marshal(v.ж) }
+
+func (v *ServeAccessView) UnmarshalJSON(b []byte) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	var x ServeAccess
+	if err := json.Unmarshal(b, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+func (v ServeAccessView) Users() views.Slice[string] { return views.SliceOf(v.ж.Users) }
+func (v ServeAccessView) Tags() views.Slice[string]  { return views.SliceOf(v.ж.Tags) }
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _ServeAccessViewNeedsRegeneration = ServeAccess(struct {
+	Users []string
+	Tags  []string
+}{})
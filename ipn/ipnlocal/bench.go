@@ -0,0 +1,277 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+// The peerapi bench endpoint, "/v0/bench", measures the throughput of the
+// path between two nodes. A GET streams data to the caller for the
+// requested duration, and a POST reads the caller's data and reports how
+// much was received and how long it took.
+
+const (
+	// maxBenchDuration is the longest a peerapi bench transfer may run
+	// in each direction.
+	maxBenchDuration = 10 * time.Second
+
+	// benchPings is the number of disco pings sent by BenchPeer to
+	// measure the latency and loss of the path.
+	benchPings = 20
+
+	// benchPingInterval is the interval between the pings of BenchPeer.
+	benchPingInterval = 100 * time.Millisecond
+
+	// benchPingTimeout is how long BenchPeer waits for a pong before
+	// counting a ping as lost.
+	benchPingTimeout = 2 * time.Second
+)
+
+// benchUploadResult is the response to a peerapi bench POST.
+type benchUploadResult struct {
+	Bytes   int64
+	Seconds float64
+}
+
+// canBench reports whether h can run a throughput bench against this node.
+func (h *peerAPIHandler) canBench() bool {
+	if h.peerNode.UnsignedPeerAPIOnly() {
+		return false
+	}
+	return h.isSelf || h.peerHasCap(tailcfg.PeerCapabilityBench) || h.peerHasCap(tailcfg.PeerCapabilityDebugPeer)
+}
+
+func (h *peerAPIHandler) handleServeBench(w http.ResponseWriter, r *http.Request) {
+	if !h.canBench() {
+		http.Error(w, "bench access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "want GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	// Only one bench at a time, so peers can't use it to saturate the
+	// node's uplink.
+	if !h.ps.benchMu.TryLock() {
+		http.Error(w, "bench already in progress", http.StatusTooManyRequests)
+		return
+	}
+	defer h.ps.benchMu.Unlock()
+	metricBenchCalls.Add(1)
+
+	if r.Method == "POST" {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*maxBenchDuration)
+		defer cancel()
+		t0 := time.Now()
+		n, err := io.Copy(io.Discard, &ctxReader{ctx, r.Body})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(benchUploadResult{
+			Bytes:   n,
+			Seconds: time.Since(t0).Seconds(),
+		})
+		return
+	}
+
+	d, err := time.ParseDuration(r.FormValue("duration"))
+	if err != nil || d <= 0 || d > maxBenchDuration {
+		http.Error(w, fmt.Sprintf("invalid duration; want up to %v", maxBenchDuration), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	writeBenchData(w, time.Now().Add(d))
+}
+
+// writeBenchData writes data to w until the deadline, or until a write
+// fails.
+func writeBenchData(w io.Writer, deadline time.Time) {
+	buf := make([]byte, 32<<10)
+	for time.Now().Before(deadline) {
+		if _, err := w.Write(buf); err != nil {
+			return
+		}
+	}
+}
+
+// ctxReader is an io.Reader that fails once its context is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// benchReader is an io.Reader of zeros that ends at its deadline.
+type benchReader struct {
+	deadline time.Time
+}
+
+func (r benchReader) Read(p []byte) (int, error) {
+	if !time.Now().Before(r.deadline) {
+		return 0, io.EOF
+	}
+	clear(p)
+	return len(p), nil
+}
+
+// BenchPeer measures the quality of the path to the peer with Tailscale IP
+// ip: its latency and loss, with disco pings, and its throughput in each
+// direction, with transfers of duration d to and from the peer's peerapi.
+//
+// The peer must grant this node tailcfg.PeerCapabilityBench (or
+// tailcfg.PeerCapabilityDebugPeer) for the throughput to be measured;
+// otherwise the result has only the latency and loss, and Err says why.
+func (b *LocalBackend) BenchPeer(ctx context.Context, ip netip.Addr, d time.Duration) (*ipnstate.BenchResult, error) {
+	if d <= 0 || d > maxBenchDuration {
+		return nil, fmt.Errorf("bench duration must be between 0 and %v", maxBenchDuration)
+	}
+	peer, base, err := b.peerAPIBaseForIP(ip)
+	if err != nil {
+		return nil, err
+	}
+	res := &ipnstate.BenchResult{
+		IP:       ip.String(),
+		NodeName: peer.Name(),
+	}
+	if err := b.benchPing(ctx, ip, res); err != nil {
+		return nil, err
+	}
+
+	tr := b.Dialer().PeerAPITransport()
+	down, err := benchDownload(ctx, tr, base, d)
+	if err != nil {
+		res.Err = fmt.Sprintf("download: %v", err)
+		return res, nil
+	}
+	res.DownloadBytesPerSecond = down
+	up, err := benchUpload(ctx, tr, base, d)
+	if err != nil {
+		res.Err = fmt.Sprintf("upload: %v", err)
+		return res, nil
+	}
+	res.UploadBytesPerSecond = up
+	return res, nil
+}
+
+// benchPing sends benchPings disco pings to ip, recording their latency
+// and loss in res.
+func (b *LocalBackend) benchPing(ctx context.Context, ip netip.Addr, res *ipnstate.BenchResult) error {
+	var total time.Duration
+	for i := range benchPings {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(benchPingInterval):
+			}
+		}
+		res.PingsSent++
+		pctx, cancel := context.WithTimeout(ctx, benchPingTimeout)
+		pr, err := b.Ping(pctx, ip, tailcfg.PingDisco, 0)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue // lost
+		}
+		if pr.Err != "" {
+			continue
+		}
+		lat := time.Duration(pr.LatencySeconds * float64(time.Second))
+		if res.PongsReceived == 0 || lat.Seconds() < res.LatencyMinSeconds {
+			res.LatencyMinSeconds = lat.Seconds()
+		}
+		if lat.Seconds() > res.LatencyMaxSeconds {
+			res.LatencyMaxSeconds = lat.Seconds()
+		}
+		res.PongsReceived++
+		total += lat
+		res.Endpoint = pr.Endpoint
+		res.DERPRegionCode = pr.DERPRegionCode
+	}
+	if res.PongsReceived > 0 {
+		res.LatencyAvgSeconds = (total / time.Duration(res.PongsReceived)).Seconds()
+	}
+	return nil
+}
+
+// benchDownload streams data from the peerapi at base for d and returns
+// the throughput in bytes per second.
+func benchDownload(ctx context.Context, tr http.RoundTripper, base string, d time.Duration) (float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, d+maxBenchDuration)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", base+"/v0/bench?duration="+d.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	t0 := time.Now()
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, benchHTTPError(res)
+	}
+	n, err := io.Copy(io.Discard, res.Body)
+	if err != nil {
+		return 0, err
+	}
+	return float64(n) / time.Since(t0).Seconds(), nil
+}
+
+// benchUpload streams data to the peerapi at base for d and returns the
+// throughput in bytes per second, as measured by the peer.
+func benchUpload(ctx context.Context, tr http.RoundTripper, base string, d time.Duration) (float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, d+maxBenchDuration)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", base+"/v0/bench", benchReader{time.Now().Add(d)})
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, benchHTTPError(res)
+	}
+	var up benchUploadResult
+	if err := json.NewDecoder(res.Body).Decode(&up); err != nil {
+		return 0, err
+	}
+	if up.Seconds <= 0 {
+		return 0, errors.New("peer reported no transfer time")
+	}
+	return float64(up.Bytes) / up.Seconds, nil
+}
+
+// benchHTTPError returns an error for the non-200 response res.
+func benchHTTPError(res *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+	return fmt.Errorf("HTTP %v: %s", res.Status, strings.TrimSpace(string(msg)))
+}
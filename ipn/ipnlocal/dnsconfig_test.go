@@ -18,6 +18,7 @@
 	"tailscale.com/types/netmap"
 	"tailscale.com/util/cloudenv"
 	"tailscale.com/util/dnsname"
+	"tailscale.com/util/set"
 )
 
 func ipps(ippStrs ...string) (ipps []netip.Prefix) {
@@ -327,6 +328,31 @@ func TestDNSConfigForNetmap(t *testing.T) {
 				Routes: map[dnsname.FQDN][]*dnstype.Resolver{},
 			},
 		},
+		{
+			name: "failover_routes",
+			nm: &netmap.NetworkMap{
+				DNS: tailcfg.DNSConfig{
+					Routes: map[string][]*dnstype.Resolver{
+						"corp.example":  {{Addr: "10.0.0.1"}, {Addr: "10.0.0.2"}},
+						"lab.example":   {{Addr: "10.0.1.1"}},
+						"empty.example": nil,
+					},
+					FailoverRoutes: []string{"corp.example", "empty.example", "missing.example"},
+				},
+			},
+			prefs: &ipn.Prefs{
+				CorpDNS: true,
+			},
+			want: &dns.Config{
+				Hosts: map[dnsname.FQDN][]netip.Addr{},
+				Routes: map[dnsname.FQDN][]*dnstype.Resolver{
+					"corp.example.":  {{Addr: "10.0.0.1"}, {Addr: "10.0.0.2"}},
+					"lab.example.":   {{Addr: "10.0.1.1"}},
+					"empty.example.": {},
+				},
+				FailoverRoutes: set.SetOf([]dnsname.FQDN{"corp.example."}),
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,108 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"tailscale.com/ipn"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/ringbuffer"
+	"tailscale.com/util/set"
+)
+
+// dnsQueryLogEntries is the number of recent DNS queries kept in the DNS
+// query log.
+const dnsQueryLogEntries = 1000
+
+// dnsQueryLog is an in-memory log of the recent DNS queries handled by the
+// DNS resolver, kept when the DNSQueryLog pref is enabled. It is safe for
+// concurrent use.
+type dnsQueryLog struct {
+	mu       sync.Mutex
+	ents     *ringbuffer.RingBuffer[dnstype.QueryLogEntry] // lazily created
+	watchers set.HandleSet[chan<- dnstype.QueryLogEntry]
+}
+
+// add records e. It's registered as the resolver's query log func, so it
+// must not block: watchers that fall behind miss entries.
+func (l *dnsQueryLog) add(e dnstype.QueryLogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ents == nil {
+		l.ents = ringbuffer.New[dnstype.QueryLogEntry](dnsQueryLogEntries)
+	}
+	l.ents.Add(e)
+	for _, ch := range l.watchers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// clear forgets all logged queries.
+func (l *dnsQueryLog) clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ents = nil
+}
+
+// watch returns the queries logged so far, oldest first, and registers ch to
+// be sent the ones logged from now on until the returned func is called.
+func (l *dnsQueryLog) watch(ch chan<- dnstype.QueryLogEntry) (past []dnstype.QueryLogEntry, stop func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ents != nil {
+		past = l.ents.GetAll()
+	}
+	h := l.watchers.Add(ch)
+	return past, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.watchers, h)
+	}
+}
+
+// setDNSQueryLogLocked starts or stops logging the queries handled by the
+// DNS resolver, according to prefs. Stopping forgets the logged queries.
+//
+// b.mu must be held.
+func (b *LocalBackend) setDNSQueryLogLocked(prefs ipn.PrefsView) {
+	dm, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return
+	}
+	if prefs.Valid() && prefs.DNSQueryLog() {
+		dm.Resolver().SetQueryLogFunc(b.dnsQueryLog.add)
+		return
+	}
+	dm.Resolver().SetQueryLogFunc(nil)
+	b.dnsQueryLog.clear()
+}
+
+// WatchDNSQueryLog calls fn with each DNS query in the DNS query log, oldest
+// first, and then with each new query as it is logged, until ctx is done. It
+// returns an error if the DNSQueryLog pref is not enabled.
+func (b *LocalBackend) WatchDNSQueryLog(ctx context.Context, fn func(dnstype.QueryLogEntry)) error {
+	if !b.Prefs().DNSQueryLog() {
+		return errors.New(`DNS query log is not enabled; run "tailscale set --dns-query-log" first`)
+	}
+	ch := make(chan dnstype.QueryLogEntry, 64)
+	past, stop := b.dnsQueryLog.watch(ch)
+	defer stop()
+	for _, e := range past {
+		fn(e)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e := <-ch:
+			fn(e)
+		}
+	}
+}
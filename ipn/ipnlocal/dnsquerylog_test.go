@@ -0,0 +1,50 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"testing"
+
+	"tailscale.com/types/dnstype"
+)
+
+func TestDNSQueryLog(t *testing.T) {
+	var l dnsQueryLog
+	for i := 0; i < dnsQueryLogEntries+10; i++ {
+		l.add(dnstype.QueryLogEntry{Name: "old.example.com."})
+	}
+
+	ch := make(chan dnstype.QueryLogEntry, 1)
+	past, stop := l.watch(ch)
+	if len(past) != dnsQueryLogEntries {
+		t.Errorf("got %d past entries, want %d", len(past), dnsQueryLogEntries)
+	}
+
+	l.add(dnstype.QueryLogEntry{Name: "new.example.com."})
+	if e := <-ch; e.Name != "new.example.com." {
+		t.Errorf("watcher got %q, want new.example.com.", e.Name)
+	}
+
+	// A watcher that falls behind misses entries rather than blocking.
+	l.add(dnstype.QueryLogEntry{Name: "a.example.com."})
+	l.add(dnstype.QueryLogEntry{Name: "b.example.com."})
+	if e := <-ch; e.Name != "a.example.com." {
+		t.Errorf("watcher got %q, want a.example.com.", e.Name)
+	}
+
+	stop()
+	l.add(dnstype.QueryLogEntry{Name: "c.example.com."})
+	select {
+	case e := <-ch:
+		t.Errorf("stopped watcher got %q", e.Name)
+	default:
+	}
+
+	l.clear()
+	if past, stop := l.watch(ch); len(past) != 0 {
+		t.Errorf("got %d entries after clear, want 0", len(past))
+	} else {
+		stop()
+	}
+}
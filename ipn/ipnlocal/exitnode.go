@@ -0,0 +1,166 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"cmp"
+	"context"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+)
+
+const (
+	// maxExitNodePings is how many of the exit nodes that look closest,
+	// going by their home DERP regions, SuggestExitNodes pings to measure
+	// their latency.
+	maxExitNodePings = 8
+
+	// exitNodePingTimeout is how long SuggestExitNodes waits for the pings.
+	exitNodePingTimeout = 2 * time.Second
+)
+
+// exitNodeCandidate is an exit node being ranked by SuggestExitNodes.
+type exitNodeCandidate struct {
+	node     tailcfg.NodeView
+	ip       netip.Addr        // to ping
+	location *tailcfg.Location // or nil
+	region   int               // home DERP region, or 0
+
+	latency time.Duration // or 0 if unknown
+	source  string        // of latency: "ping", "derp", "" or "unreachable"
+}
+
+// rank returns the group of exit node candidates that c is ranked in, by
+// how its latency was determined: measured latencies aren't comparable with
+// estimates, so exit nodes that answered a ping come first, then those whose
+// latency is estimated, those whose latency is unknown and, last, those that
+// didn't answer a ping.
+func (c *exitNodeCandidate) rank() int {
+	switch c.source {
+	case "ping":
+		return 0
+	case "derp":
+		return 1
+	case "":
+		return 2
+	}
+	return 3
+}
+
+// SuggestExitNodes returns the online exit nodes, best first, for users who
+// don't know which one to pick. It returns an empty list if there are none.
+//
+// Exit nodes are ranked by latency. The latency to the exit nodes whose home
+// DERP regions are closest to us is measured with disco pings; that of the
+// others is estimated as our latency to their home DERP region, which is a
+// hint of where they are. Exit nodes with measured latencies are ranked ahead
+// of those with estimates, and those that don't answer the ping are ranked
+// last, as they may well be unreachable. Of the exit nodes in the same city,
+// only those with the highest location priority are suggested, as control
+// uses that priority to spread the load across them.
+func (b *LocalBackend) SuggestExitNodes(ctx context.Context) ([]apitype.ExitNodeSuggestion, error) {
+	b.mu.Lock()
+	peers := b.peers.all()
+	b.mu.Unlock()
+
+	var regionLatency map[int]time.Duration
+	if mc := b.MagicConn(); mc != nil {
+		if r := mc.LastNetcheckReport(); r != nil {
+			regionLatency = r.RegionLatency
+		}
+	}
+	cands := exitNodeCandidates(peers, regionLatency)
+	if len(cands) == 0 {
+		return []apitype.ExitNodeSuggestion{}, nil
+	}
+
+	// Measure the latency to the candidates that look closest.
+	slices.SortStableFunc(cands, compareExitNodeCandidates)
+	ctx, cancel := context.WithTimeout(ctx, exitNodePingTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, c := range cands[:min(len(cands), maxExitNodePings)] {
+		wg.Add(1)
+		go func(c *exitNodeCandidate) {
+			defer wg.Done()
+			pr, err := b.Ping(ctx, c.ip, tailcfg.PingDisco, 0)
+			if err != nil || pr.Err != "" || pr.LatencySeconds <= 0 {
+				c.latency, c.source = 0, "unreachable"
+				return
+			}
+			c.latency = time.Duration(pr.LatencySeconds * float64(time.Second))
+			c.source = "ping"
+		}(c)
+	}
+	wg.Wait()
+	slices.SortStableFunc(cands, compareExitNodeCandidates)
+
+	ret := make([]apitype.ExitNodeSuggestion, len(cands))
+	for i, c := range cands {
+		ret[i] = apitype.ExitNodeSuggestion{
+			ID:            c.node.StableID(),
+			Name:          strings.TrimSuffix(c.node.Name(), "."),
+			Location:      c.location,
+			DERPRegion:    c.region,
+			Latency:       c.latency,
+			LatencySource: c.source,
+		}
+	}
+	return ret, nil
+}
+
+// exitNodeCandidates returns the online exit nodes among peers that are
+// worth suggesting, with their latency estimated from regionLatency, our
+// latency to each DERP region.
+func exitNodeCandidates(peers []tailcfg.NodeView, regionLatency map[int]time.Duration) []*exitNodeCandidate {
+	var cands []*exitNodeCandidate
+	bestPriority := map[string]int{} // by city code
+	for _, p := range peers {
+		if online := p.Online(); online == nil || !*online {
+			continue
+		}
+		if !tsaddr.ContainsExitRoutes(p.AllowedIPs()) || p.Addresses().Len() == 0 {
+			continue
+		}
+		c := &exitNodeCandidate{
+			node: p,
+			ip:   p.Addresses().At(0).Addr(),
+		}
+		if p.Hostinfo().Valid() {
+			c.location = p.Hostinfo().Location()
+		}
+		if c.location != nil && c.location.CityCode != "" {
+			if prio, ok := bestPriority[c.location.CityCode]; !ok || c.location.Priority > prio {
+				bestPriority[c.location.CityCode] = c.location.Priority
+			}
+		}
+		if ipp, err := netip.ParseAddrPort(p.DERP()); err == nil && ipp.Addr() == tailcfg.DerpMagicIPAddr {
+			c.region = int(ipp.Port())
+		}
+		if d, ok := regionLatency[c.region]; ok && c.region != 0 {
+			c.latency, c.source = d, "derp"
+		}
+		cands = append(cands, c)
+	}
+	return slices.DeleteFunc(cands, func(c *exitNodeCandidate) bool {
+		return c.location != nil && c.location.CityCode != "" && c.location.Priority < bestPriority[c.location.CityCode]
+	})
+}
+
+// compareExitNodeCandidates orders exit node candidates by their rank, then
+// by latency, lowest first, and then by name.
+func compareExitNodeCandidates(a, b *exitNodeCandidate) int {
+	return cmp.Or(
+		cmp.Compare(a.rank(), b.rank()),
+		cmp.Compare(a.latency, b.latency),
+		strings.Compare(a.node.Name(), b.node.Name()),
+	)
+}
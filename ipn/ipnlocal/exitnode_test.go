@@ -0,0 +1,101 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/ptr"
+)
+
+func TestExitNodeCandidates(t *testing.T) {
+	exitNode := func(id tailcfg.NodeID, name string, online bool, derpRegion int, loc *tailcfg.Location) tailcfg.NodeView {
+		addr := netip.PrefixFrom(netip.AddrFrom4([4]byte{100, 64, 0, byte(id)}), 32)
+		n := &tailcfg.Node{
+			ID:         id,
+			StableID:   tailcfg.StableNodeID(name),
+			Name:       name + ".example.ts.net.",
+			Online:     ptr.To(online),
+			Addresses:  []netip.Prefix{addr},
+			AllowedIPs: append([]netip.Prefix{addr}, tsaddr.ExitRoutes()...),
+			Hostinfo:   (&tailcfg.Hostinfo{Location: loc}).View(),
+		}
+		if derpRegion != 0 {
+			n.DERP = netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, uint16(derpRegion)).String()
+		}
+		return n.View()
+	}
+	notExitNode := (&tailcfg.Node{
+		ID:        100,
+		Name:      "peer.example.ts.net.",
+		Online:    ptr.To(true),
+		Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.100/32")},
+	}).View()
+	city := func(code string, prio int) *tailcfg.Location {
+		return &tailcfg.Location{Country: "Country", CountryCode: "CC", City: code, CityCode: code, Priority: prio}
+	}
+
+	peers := []tailcfg.NodeView{
+		exitNode(1, "far", true, 2, nil),
+		exitNode(2, "near", true, 1, nil),
+		exitNode(3, "offline", false, 1, nil),
+		exitNode(4, "unknown-region", true, 0, nil),
+		exitNode(5, "city-low", true, 1, city("ABC", 10)),
+		exitNode(6, "city-high", true, 2, city("ABC", 20)),
+		notExitNode,
+	}
+	regionLatency := map[int]time.Duration{
+		1: 10 * time.Millisecond,
+		2: 80 * time.Millisecond,
+	}
+	cands := exitNodeCandidates(peers, regionLatency)
+	slices.SortStableFunc(cands, compareExitNodeCandidates)
+
+	var got []string
+	for _, c := range cands {
+		got = append(got, string(c.node.StableID()))
+	}
+	want := []string{"near", "city-high", "far", "unknown-region"}
+	if !slices.Equal(got, want) {
+		t.Errorf("candidates = %q; want %q", got, want)
+	}
+	if c := cands[0]; c.region != 1 || c.latency != 10*time.Millisecond || c.source != "derp" {
+		t.Errorf("near candidate = region %d, latency %v from %q; want region 1, 10ms from derp", c.region, c.latency, c.source)
+	}
+	if c := cands[len(cands)-1]; c.latency != 0 || c.source != "" {
+		t.Errorf("unknown-region candidate has latency %v from %q; want none", c.latency, c.source)
+	}
+
+	// A measured latency moves an exit node up.
+	cands[2].latency, cands[2].source = time.Millisecond, "ping"
+	slices.SortStableFunc(cands, compareExitNodeCandidates)
+	if got := cands[0].node.StableID(); got != "far" {
+		t.Errorf("best after ping = %q; want far", got)
+	}
+
+	// Measured latencies rank ahead of lower estimates, and exit nodes
+	// that didn't answer a ping rank last.
+	for _, c := range cands {
+		switch c.node.StableID() {
+		case "far":
+			c.latency, c.source = 0, "unreachable"
+		case "city-high":
+			c.latency, c.source = 90*time.Millisecond, "ping"
+		}
+	}
+	slices.SortStableFunc(cands, compareExitNodeCandidates)
+	got = got[:0]
+	for _, c := range cands {
+		got = append(got, string(c.node.StableID()))
+	}
+	want = []string{"city-high", "near", "unknown-region", "far"}
+	if !slices.Equal(got, want) {
+		t.Errorf("candidates after pings = %q; want %q", got, want)
+	}
+}
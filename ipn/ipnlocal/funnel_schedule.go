@@ -0,0 +1,140 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"cmp"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"slices"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/util/mak"
+)
+
+// funnelCertLead is how long before a Funnel schedule opens that the
+// certificates of its domains are provisioned, so that the first connections
+// of the window don't wait on the ACME CA.
+const funnelCertLead = time.Hour
+
+// funnelScheduleRecheck is how long the Funnel schedule timer waits when no
+// schedule changes in the coming week.
+const funnelScheduleRecheck = 24 * time.Hour
+
+// updateFunnelScheduleLocked arms b.funnelSchedTimer for the next time the
+// Funnel schedules of b.serveConfig open or close, or the certificates of a
+// schedule that's about to open need provisioning. It starts provisioning
+// them if that time has come.
+//
+// b.mu must be held.
+func (b *LocalBackend) updateFunnelScheduleLocked() {
+	if b.funnelSchedTimer != nil {
+		b.funnelSchedTimer.Stop()
+		b.funnelSchedTimer = nil
+	}
+	sc := b.serveConfig
+	if !sc.Valid() || !sc.HasFunnelSchedule() {
+		return
+	}
+	now := b.clock.Now()
+	next := sc.NextFunnelScheduleChange(now)
+	if opening, targets := sc.NextFunnelOpening(now); !opening.IsZero() {
+		provisionAt := opening.Add(-funnelCertLead)
+		if !provisionAt.After(now) {
+			if !b.funnelCertsOpening.Equal(opening) {
+				b.funnelCertsOpening = opening
+				go b.provisionFunnelCerts(targets)
+			}
+		} else if next.IsZero() || provisionAt.Before(next) {
+			next = provisionAt
+		}
+	}
+	d := funnelScheduleRecheck
+	if !next.IsZero() {
+		d = next.Sub(now)
+	}
+	b.funnelSchedTimer = b.clock.AfterFunc(d, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.updateFunnelScheduleLocked()
+	})
+}
+
+// provisionFunnelCerts gets the certificates of the domains of targets, so
+// that they're issued or renewed before Funnel traffic to them is allowed.
+func (b *LocalBackend) provisionFunnelCerts(targets []ipn.HostPort) {
+	var domains []string
+	for _, hp := range targets {
+		host, _, err := net.SplitHostPort(string(hp))
+		if err == nil && !slices.Contains(domains, host) {
+			domains = append(domains, host)
+		}
+	}
+	for _, domain := range domains {
+		ctx, cancel := context.WithTimeout(b.ctx, funnelCertLead)
+		st := &ipnstate.FunnelCertStatus{Domain: domain}
+		pair, err := b.GetCertPEM(ctx, domain)
+		cancel()
+		st.Provisioned = b.clock.Now()
+		if err != nil {
+			b.logf("serve: provisioning funnel cert for %q: %v", domain, err)
+			st.Error = err.Error()
+		} else if block, _ := pem.Decode(pair.CertPEM); block != nil {
+			if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+				st.NotAfter = cert.NotAfter
+			}
+		}
+		b.mu.Lock()
+		mak.Set(&b.funnelCerts, domain, st)
+		b.mu.Unlock()
+	}
+}
+
+// FunnelScheduleStatus returns the state of the Funnel schedules of the
+// serve config.
+func (b *LocalBackend) FunnelScheduleStatus() *ipnstate.FunnelScheduleStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := &ipnstate.FunnelScheduleStatus{}
+	sc := b.serveConfig
+	if !sc.Valid() {
+		return st
+	}
+	now := b.clock.Now()
+	addTargets := func(v ipn.ServeConfigView) {
+		v.AllowFunnel().Range(func(hp ipn.HostPort, on bool) (cont bool) {
+			t := string(hp)
+			if !on || slices.Contains(st.Open, t) || slices.Contains(st.Closed, t) {
+				return true
+			}
+			if sc.FunnelOpenForTarget(hp, now) {
+				st.Open = append(st.Open, t)
+			} else {
+				st.Closed = append(st.Closed, t)
+			}
+			return true
+		})
+	}
+	addTargets(sc)
+	sc.Foreground().Range(func(_ string, v ipn.ServeConfigView) (cont bool) {
+		addTargets(v)
+		return true
+	})
+	slices.Sort(st.Open)
+	slices.Sort(st.Closed)
+	st.NextChange = sc.NextFunnelScheduleChange(now)
+	st.NextOpening, _ = sc.NextFunnelOpening(now)
+	for _, cs := range b.funnelCerts {
+		c := *cs
+		st.Certs = append(st.Certs, &c)
+	}
+	slices.SortFunc(st.Certs, func(a, b *ipnstate.FunnelCertStatus) int {
+		return cmp.Compare(a.Domain, b.Domain)
+	})
+	return st
+}
@@ -20,6 +20,7 @@
 	"os"
 	"os/user"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"slices"
 	"sort"
@@ -31,7 +32,6 @@
 
 	"go4.org/mem"
 	"go4.org/netipx"
-	xmaps "golang.org/x/exp/maps"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"tailscale.com/appc"
 	"tailscale.com/client/tailscale/apitype"
@@ -73,6 +73,7 @@
 	"tailscale.com/types/appctype"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/types/empty"
+	"tailscale.com/types/ipproto"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
@@ -167,6 +168,8 @@ type LocalBackend struct {
 	backendLogID          logid.PublicID
 	unregisterNetMon      func()
 	unregisterHealthWatch func()
+	unregisterSysPolicy   func()
+	unregisterTailFS      func()           // or nil
 	portpoll              *portlist.Poller // may be nil
 	portpollOnce          sync.Once        // guards starting readPoller
 	gotPortPollRes        chan struct{}    // closed upon first readPoller result
@@ -178,6 +181,10 @@ type LocalBackend struct {
 	shutdownCalled        bool // if Shutdown has been called
 	debugSink             *capture.Sink
 	sockstatLogger        *sockstatlog.Logger
+	peerHistory           peerHistory    // recent connectivity transitions of peers
+	usage                 *usageReporter // weekly usage report, if the UsageReports pref is on
+	dnsQueryLog           dnsQueryLog    // recent DNS queries, if the DNSQueryLog pref is on
+	pathHints             pathHintsSaver // saves magicsock's path hints
 
 	// getTCPHandlerForFunnelFlow returns a handler for an incoming TCP flow for
 	// the provided srcAddr and dstPort if one exists.
@@ -231,9 +238,10 @@ type LocalBackend struct {
 	// as of 2023-09-17.
 	netMap *netmap.NetworkMap
 	// peers is the set of current peers and their current values after applying
-	// delta node mutations as they come in (with mu held). The map values can
-	// be given out to callers, but the map itself must not escape the LocalBackend.
-	peers            map[tailcfg.NodeID]tailcfg.NodeView
+	// delta node mutations as they come in (with mu held). The views it returns
+	// can be given out to callers, but the store itself must not escape the
+	// LocalBackend.
+	peers            peerStore
 	nodeByAddr       map[netip.Addr]tailcfg.NodeID
 	nmExpiryTimer    tstime.TimerController // for updating netMap on node expiry; can be nil
 	activeLogin      string                 // last logged LoginName from netMap
@@ -241,6 +249,7 @@ type LocalBackend struct {
 	endpoints        []tailcfg.Endpoint
 	blocked          bool
 	keyExpired       bool
+	routesUnapproved bool      // some advertised routes aren't in the self node's AllowedIPs
 	authURL          string    // cleared on Notify
 	authURLSticky    string    // not cleared on Notify
 	authURLTime      time.Time // when the authURL was received from the control server
@@ -281,11 +290,22 @@ type LocalBackend struct {
 	serveConfig         ipn.ServeConfigView // or !Valid if none
 	activeWatchSessions set.Set[string]     // of WatchIPN SessionID
 
+	// Funnel schedule fields. (also guarded by mu)
+	funnelSchedTimer   tstime.TimerController                // for the next Funnel schedule change; can be nil
+	funnelCertsOpening time.Time                             // schedule opening that certs were last provisioned for
+	funnelCerts        map[string]*ipnstate.FunnelCertStatus // by domain
+
+	// tailFSChanged holds the paths of the files that changed on remote
+	// TailFS shares, to be sent in the next TailFSChanged notification.
+	// (also guarded by mu)
+	tailFSChanged set.Set[string]
+
 	webClient          webClient
 	webClientListeners map[netip.AddrPort]*localListener // listeners for local web client traffic
 
 	serveListeners     map[netip.AddrPort]*localListener // listeners for local serve traffic
 	serveProxyHandlers sync.Map                          // string (HTTPHandler.Proxy) => *reverseProxy
+	serveStats         serveStats                        // request counters of the serve web handlers
 
 	// statusLock must be held before calling statusChanged.Wait() or
 	// statusChanged.Broadcast().
@@ -307,6 +327,11 @@ type LocalBackend struct {
 
 	// Last ClientVersion received in MapResponse, guarded by mu.
 	lastClientVersion *tailcfg.ClientVersion
+
+	// autoUpdateFromTailnetDefault is whether the AutoUpdate.Apply pref was
+	// last set from the tailnet default rather than by the user. It's not
+	// persisted, so after a restart the pref is reported as set by the user.
+	autoUpdateFromTailnetDefault bool
 }
 
 type updateStatus struct {
@@ -403,6 +428,13 @@ func NewLocalBackend(logf logger.Logf, logID logid.PublicID, sys *tsd.System, lo
 
 	b.statusChanged = sync.NewCond(&b.statusLock)
 	b.e.SetStatusCallback(b.setWgengineStatus)
+	b.e.SetPeerConnEventCallback(b.peerHistory.add)
+	b.usage = newUsageReporter(logf, b.TailscaleVarRoot)
+
+	// Resume the direct paths to peers from before the daemon restarted,
+	// rather than waiting for discovery to find them again.
+	b.loadPathHints()
+	go b.savePathHintsPeriodically()
 
 	b.prevIfState = netMon.InterfaceState()
 	// Call our linkChange code once with the current state, and
@@ -411,6 +443,10 @@ func NewLocalBackend(logf logger.Logf, logID logid.PublicID, sys *tsd.System, lo
 	b.unregisterNetMon = netMon.RegisterChangeCallback(b.linkChange)
 
 	b.unregisterHealthWatch = health.RegisterWatcher(b.onHealthChange)
+	b.unregisterSysPolicy = syspolicy.RegisterChangeCallback(b.sysPolicyChanged)
+	if fs, ok := b.sys.TailFSForLocal.GetOK(); ok {
+		b.unregisterTailFS = fs.WatchChanges(b.tailFSChangedPath)
+	}
 
 	if tunWrap, ok := b.sys.Tun.GetOK(); ok {
 		tunWrap.PeerAPIPort = b.GetPeerAPIPort
@@ -550,22 +586,33 @@ func (b *LocalBackend) SetDirectFileRoot(dir string) {
 	b.directFileRoot = dir
 }
 
-// ReloadConfig reloads the backend's config from disk.
+// ReloadConfig reloads the backend's config from disk, and applies its
+// prefs and serve config.
 //
 // It returns (false, nil) if not running in declarative mode, (true, nil) on
 // success, or (false, error) on failure.
 func (b *LocalBackend) ReloadConfig() (ok bool, err error) {
 	b.mu.Lock()
-	defer b.mu.Unlock()
 	if b.conf == nil {
+		b.mu.Unlock()
 		return false, nil
 	}
 	conf, err := conffile.Load(b.conf.Path)
 	if err != nil {
+		b.mu.Unlock()
+		return false, err
+	}
+	mp, err := conf.Parsed.ToPrefs()
+	if err != nil {
+		b.mu.Unlock()
 		return false, err
 	}
 	b.conf = conf
-	// TODO(bradfitz): apply things
+	p := b.pm.CurrentPrefs().AsStruct()
+	p.ApplyEdits(&mp)
+	// Force the serve config to be reloaded from the new config.
+	b.lastServeConfJSON = mem.B(nil)
+	b.setPrefsLockedOnEntry("ReloadConfig", p) // does a b.mu.Unlock
 	return true, nil
 }
 
@@ -592,6 +639,12 @@ func (b *LocalBackend) linkChange(delta *netmon.ChangeDelta) {
 	b.prevIfState = ifst
 	b.pauseOrResumeControlClientLocked()
 
+	// A captive portal detected on the previous network doesn't apply to a
+	// new one; the next netcheck report re-checks for it.
+	if delta.Major && health.CaptivePortalDetected() {
+		health.SetCaptivePortalDetected(false)
+	}
+
 	// If the PAC-ness of the network changed, reconfig wireguard+route to
 	// add/remove subnets.
 	if hadPAC != ifst.HasPAC() {
@@ -623,6 +676,9 @@ func (b *LocalBackend) onHealthChange(sys health.Subsystem, err error) {
 	} else {
 		b.logf("health(%q): error: %v", sys, err)
 	}
+	if sys == health.SysCaptivePortal {
+		b.send(ipn.Notify{CaptivePortalDetected: ptr.To(err != nil)})
+	}
 }
 
 // Shutdown halts the backend and all its sub-components. The backend
@@ -660,6 +716,10 @@ func (b *LocalBackend) Shutdown() {
 		b.debugSink.Close()
 		b.debugSink = nil
 	}
+	if b.funnelSchedTimer != nil {
+		b.funnelSchedTimer.Stop()
+		b.funnelSchedTimer = nil
+	}
 	b.mu.Unlock()
 	b.webClientShutdown()
 
@@ -672,10 +732,16 @@ func (b *LocalBackend) Shutdown() {
 
 	b.unregisterNetMon()
 	b.unregisterHealthWatch()
+	b.unregisterSysPolicy()
+	if b.unregisterTailFS != nil {
+		b.unregisterTailFS()
+	}
 	if cc != nil {
 		cc.Shutdown()
 	}
 	b.ctxCancel()
+	b.savePathHints()
+	b.usage.save()
 	b.e.Close()
 	b.e.Wait()
 }
@@ -704,6 +770,56 @@ func (b *LocalBackend) sanitizedPrefsLocked() ipn.PrefsView {
 	return stripKeysFromPrefs(b.pm.CurrentPrefs())
 }
 
+// PrefsProvenance returns, for each preference, where its current value comes
+// from. Prefs nested in structs are named with dots, as in "AutoUpdate.Apply".
+// Whether the node advertises itself as an exit node, which is part of the
+// AdvertiseRoutes pref but can be enforced separately by policy, is reported
+// as "AdvertiseExitNode".
+func (b *LocalBackend) PrefsProvenance() map[string]ipn.PrefSource {
+	b.mu.Lock()
+	prefs := b.pm.CurrentPrefs().AsStruct()
+	autoUpdateFromTailnetDefault := b.autoUpdateFromTailnetDefault
+	b.mu.Unlock()
+
+	enforced := make(set.Set[string])
+	applySysPolicy(prefs, enforced)
+
+	ret := make(map[string]ipn.PrefSource)
+	for _, name := range prefNames() {
+		ret[name] = ipn.PrefSourceUser
+	}
+	ret["AdvertiseExitNode"] = ipn.PrefSourceUser
+	if autoUpdateFromTailnetDefault {
+		ret["AutoUpdate.Apply"] = ipn.PrefSourceTailnetDefault
+	}
+	for name := range enforced {
+		ret[name] = ipn.PrefSourceSysPolicy
+	}
+	return ret
+}
+
+// prefNames returns the names of the fields of ipn.Prefs that are
+// preferences, with the fields of nested ipn structs such as AutoUpdatePrefs
+// named with dots.
+var prefNames = sync.OnceValue(func() []string {
+	var names []string
+	t := reflect.TypeFor[ipn.Prefs]()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Name == "Persist" {
+			continue // not a pref
+		}
+		if f.Type.Kind() == reflect.Struct && f.Type.PkgPath() == t.PkgPath() {
+			for j := 0; j < f.Type.NumField(); j++ {
+				names = append(names, f.Name+"."+f.Type.Field(j).Name)
+			}
+			continue
+		}
+		names = append(names, f.Name)
+	}
+	return names
+})
+
 // Status returns the latest status of the backend and its
 // sub-components.
 func (b *LocalBackend) Status() *ipnstate.Status {
@@ -849,7 +965,7 @@ func (b *LocalBackend) populatePeerStatusLocked(sb *ipnstate.StatusBuilder) {
 		sb.AddUser(id, up)
 	}
 	exitNodeID := b.pm.CurrentPrefs().ExitNodeID()
-	for _, p := range b.peers {
+	for _, p := range b.peers.all() {
 		var lastSeen time.Time
 		if p.LastSeen() != nil {
 			lastSeen = *p.LastSeen()
@@ -944,7 +1060,7 @@ func (b *LocalBackend) WhoIs(ipp netip.AddrPort) (n tailcfg.NodeView, u tailcfg.
 	if b.netMap == nil {
 		return zero, u, false
 	}
-	n, ok = b.peers[nid]
+	n, ok = b.peers.get(nid)
 	if !ok {
 		// Check if this the self-node, which would not appear in peers.
 		if !b.netMap.SelfNode.Valid() || nid != b.netMap.SelfNode.ID() {
@@ -1009,7 +1125,11 @@ func (b *LocalBackend) SetControlClientStatus(c controlclient.Client, st control
 		var uerr controlclient.UserVisibleError
 		if errors.As(st.Err, &uerr) {
 			s := uerr.UserVisibleError()
-			b.send(ipn.Notify{ErrMessage: &s})
+			code := ipn.ErrorCodeControl
+			if errors.As(st.Err, new(controlclient.AuthKeyError)) {
+				code = ipn.ErrorCodeAuthKeyRejected
+			}
+			b.send(ipn.Notify{ErrMessage: &s, ErrorCode: code})
 		}
 		return
 	}
@@ -1067,8 +1187,23 @@ func (b *LocalBackend) SetControlClientStatus(c controlclient.Client, st control
 		}
 		b.keyExpired = isExpired
 	}
+	var newlyUnapproved []netip.Prefix
+	if st.NetMap != nil {
+		unapproved := unapprovedRoutes(b.pm.CurrentPrefs(), st.NetMap.SelfNode)
+		if len(unapproved) > 0 && !b.routesUnapproved {
+			newlyUnapproved = unapproved
+		}
+		b.routesUnapproved = len(unapproved) > 0
+	}
 	b.mu.Unlock()
 
+	if len(newlyUnapproved) > 0 {
+		b.logf("advertised routes %v are not approved by the control server", newlyUnapproved)
+		// Not an ErrMessage, which clients treat as a critical error:
+		// the node works, peers just don't use the routes yet.
+		b.send(ipn.Notify{ErrorCode: ipn.ErrorCodeRoutesNotApproved})
+	}
+
 	if keyExpiryExtended && wasBlocked {
 		// Key extended, unblock the engine
 		b.blockEngineUpdates(false)
@@ -1124,7 +1259,7 @@ func (b *LocalBackend) SetControlClientStatus(c controlclient.Client, st control
 	if setExitNodeID(prefs, st.NetMap) {
 		prefsChanged = true
 	}
-	if applySysPolicy(prefs) {
+	if applySysPolicy(prefs, nil) {
 		prefsChanged = true
 	}
 
@@ -1203,7 +1338,7 @@ func (b *LocalBackend) SetControlClientStatus(c controlclient.Client, st control
 				b.logf("Failed to save new controlclient state: %v", err)
 			}
 			b.mu.Unlock()
-			b.send(ipn.Notify{ErrMessage: &msg, Prefs: &p})
+			b.send(ipn.Notify{ErrMessage: &msg, ErrorCode: ipn.ErrorCodeLoggingRequired, Prefs: &p})
 			return
 		}
 		if netMap != nil {
@@ -1216,10 +1351,12 @@ func (b *LocalBackend) SetControlClientStatus(c controlclient.Client, st control
 		}
 
 		b.e.SetNetworkMap(st.NetMap)
-		b.MagicConn().SetDERPMap(st.NetMap.DERPMap)
+		b.MagicConn().SetDERPMap(b.derpMapOf(st.NetMap))
 
-		// Update our cached DERP map
-		dnsfallback.UpdateCache(st.NetMap.DERPMap, b.logf)
+		// Update our cached DERP map, unless it's overridden.
+		if _, ok := b.sys.DERPMap.GetOK(); !ok {
+			dnsfallback.UpdateCache(st.NetMap.DERPMap, b.logf)
+		}
 
 		b.send(ipn.Notify{NetMap: st.NetMap})
 	}
@@ -1236,63 +1373,80 @@ func (b *LocalBackend) SetControlClientStatus(c controlclient.Client, st control
 }
 
 type preferencePolicyInfo struct {
-	key syspolicy.Key
-	get func(ipn.PrefsView) bool
-	set func(*ipn.Prefs, bool)
+	key  syspolicy.Key
+	name string // of the pref in ipn.Prefs, dotted for nested fields
+	get  func(ipn.PrefsView) bool
+	set  func(*ipn.Prefs, bool)
 }
 
 var preferencePolicies = []preferencePolicyInfo{
 	{
-		key: syspolicy.EnableIncomingConnections,
+		key:  syspolicy.EnableIncomingConnections,
+		name: "ShieldsUp",
 		// Allow Incoming (used by the UI) is the negation of ShieldsUp (used by the
 		// backend), so this has to convert between the two conventions.
 		get: func(p ipn.PrefsView) bool { return !p.ShieldsUp() },
 		set: func(p *ipn.Prefs, v bool) { p.ShieldsUp = !v },
 	},
 	{
-		key: syspolicy.EnableServerMode,
-		get: func(p ipn.PrefsView) bool { return p.ForceDaemon() },
-		set: func(p *ipn.Prefs, v bool) { p.ForceDaemon = v },
+		key:  syspolicy.EnableServerMode,
+		name: "ForceDaemon",
+		get:  func(p ipn.PrefsView) bool { return p.ForceDaemon() },
+		set:  func(p *ipn.Prefs, v bool) { p.ForceDaemon = v },
 	},
 	{
-		key: syspolicy.ExitNodeAllowLANAccess,
-		get: func(p ipn.PrefsView) bool { return p.ExitNodeAllowLANAccess() },
-		set: func(p *ipn.Prefs, v bool) { p.ExitNodeAllowLANAccess = v },
+		key:  syspolicy.ExitNodeAllowLANAccess,
+		name: "ExitNodeAllowLANAccess",
+		get:  func(p ipn.PrefsView) bool { return p.ExitNodeAllowLANAccess() },
+		set:  func(p *ipn.Prefs, v bool) { p.ExitNodeAllowLANAccess = v },
 	},
 	{
-		key: syspolicy.EnableTailscaleDNS,
-		get: func(p ipn.PrefsView) bool { return p.CorpDNS() },
-		set: func(p *ipn.Prefs, v bool) { p.CorpDNS = v },
+		key:  syspolicy.EnableTailscaleDNS,
+		name: "CorpDNS",
+		get:  func(p ipn.PrefsView) bool { return p.CorpDNS() },
+		set:  func(p *ipn.Prefs, v bool) { p.CorpDNS = v },
 	},
 	{
-		key: syspolicy.EnableTailscaleSubnets,
-		get: func(p ipn.PrefsView) bool { return p.RouteAll() },
-		set: func(p *ipn.Prefs, v bool) { p.RouteAll = v },
+		key:  syspolicy.EnableTailscaleSubnets,
+		name: "RouteAll",
+		get:  func(p ipn.PrefsView) bool { return p.RouteAll() },
+		set:  func(p *ipn.Prefs, v bool) { p.RouteAll = v },
 	},
 	{
-		key: syspolicy.CheckUpdates,
-		get: func(p ipn.PrefsView) bool { return p.AutoUpdate().Check },
-		set: func(p *ipn.Prefs, v bool) { p.AutoUpdate.Check = v },
+		key:  syspolicy.CheckUpdates,
+		name: "AutoUpdate.Check",
+		get:  func(p ipn.PrefsView) bool { return p.AutoUpdate().Check },
+		set:  func(p *ipn.Prefs, v bool) { p.AutoUpdate.Check = v },
 	},
 	{
-		key: syspolicy.ApplyUpdates,
-		get: func(p ipn.PrefsView) bool { v, _ := p.AutoUpdate().Apply.Get(); return v },
-		set: func(p *ipn.Prefs, v bool) { p.AutoUpdate.Apply.Set(v) },
+		key:  syspolicy.ApplyUpdates,
+		name: "AutoUpdate.Apply",
+		get:  func(p ipn.PrefsView) bool { v, _ := p.AutoUpdate().Apply.Get(); return v },
+		set:  func(p *ipn.Prefs, v bool) { p.AutoUpdate.Apply.Set(v) },
 	},
 	{
-		key: syspolicy.EnableRunExitNode,
-		get: func(p ipn.PrefsView) bool { return p.AdvertisesExitNode() },
-		set: func(p *ipn.Prefs, v bool) { p.SetAdvertiseExitNode(v) },
+		key:  syspolicy.EnableRunExitNode,
+		name: "AdvertiseExitNode",
+		get:  func(p ipn.PrefsView) bool { return p.AdvertisesExitNode() },
+		set:  func(p *ipn.Prefs, v bool) { p.SetAdvertiseExitNode(v) },
 	},
 }
 
 // applySysPolicy overwrites configured preferences with policies that may be
 // configured by the system administrator in an OS-specific way.
-func applySysPolicy(prefs *ipn.Prefs) (anyChange bool) {
+//
+// If enforced is non-nil, the names of the prefs whose values are dictated by
+// policy are added to it, whether or not they changed.
+func applySysPolicy(prefs *ipn.Prefs, enforced set.Set[string]) (anyChange bool) {
 	if controlURL, err := syspolicy.GetString(syspolicy.ControlURL, prefs.ControlURL); err == nil && prefs.ControlURL != controlURL {
 		prefs.ControlURL = controlURL
 		anyChange = true
 	}
+	if enforced != nil {
+		if controlURL, err := syspolicy.GetString(syspolicy.ControlURL, ""); err == nil && controlURL != "" {
+			enforced.Add("ControlURL")
+		}
+	}
 
 	for _, opt := range preferencePolicies {
 		if po, err := syspolicy.GetPreferenceOption(opt.key); err == nil {
@@ -1302,12 +1456,75 @@ func applySysPolicy(prefs *ipn.Prefs) (anyChange bool) {
 				opt.set(prefs, newVal)
 				anyChange = true
 			}
+			if enforced != nil && !po.Show() {
+				enforced.Add(opt.name)
+			}
+		}
+	}
+
+	// The LAN routes allowlist replaces ExitNodeAllowLANAccess: if it's
+	// set, access to the rest of the LAN can't be allowed, whatever the
+	// ExitNodeAllowLANAccess pref or policy say.
+	if lanRoutes, ok := exitNodeAllowedLANRoutesPolicy(); ok {
+		if !slices.Equal(prefs.ExitNodeAllowedLANRoutes, lanRoutes) {
+			prefs.ExitNodeAllowedLANRoutes = lanRoutes
+			anyChange = true
+		}
+		if prefs.ExitNodeAllowLANAccess {
+			prefs.ExitNodeAllowLANAccess = false
+			anyChange = true
+		}
+		if enforced != nil {
+			enforced.Add("ExitNodeAllowedLANRoutes")
+			enforced.Add("ExitNodeAllowLANAccess")
 		}
 	}
 
 	return anyChange
 }
 
+// sysPolicyChanged is called when the system policies may have changed. If
+// the policies now dictate different prefs, it applies them as if they'd been
+// set by the user, which notifies IPN bus watchers of the new prefs.
+func (b *LocalBackend) sysPolicyChanged() {
+	b.mu.Lock()
+	if b.shutdownCalled || !b.pm.CurrentPrefs().Valid() {
+		b.mu.Unlock()
+		return
+	}
+	prefs := b.pm.CurrentPrefs().AsStruct()
+	anyChange := setExitNodeID(prefs, b.netMap)
+	if applySysPolicy(prefs, nil) {
+		anyChange = true
+	}
+	if !anyChange {
+		b.mu.Unlock()
+		return
+	}
+	b.logf("system policy changed; updating prefs")
+	b.setPrefsLockedOnEntry("sysPolicyChanged", prefs)
+}
+
+// exitNodeAllowedLANRoutesPolicy returns the local network prefixes that the
+// ExitNodeAllowedLANRoutes policy allows direct access to while using an exit
+// node, and whether the policy is set. Entries that aren't valid non-default
+// prefixes are ignored, which errs towards routing more traffic via the exit
+// node.
+func exitNodeAllowedLANRoutesPolicy() (routes []netip.Prefix, ok bool) {
+	v, err := syspolicy.GetString(syspolicy.ExitNodeAllowedLANRoutes, "")
+	if err != nil || strings.TrimSpace(v) == "" {
+		return nil, false
+	}
+	for _, s := range strings.Split(v, ",") {
+		ipp, err := netip.ParsePrefix(strings.TrimSpace(s))
+		if err != nil || ipp.Bits() == 0 {
+			continue
+		}
+		routes = append(routes, ipp.Masked())
+	}
+	return routes, true
+}
+
 var _ controlclient.NetmapDeltaUpdater = (*LocalBackend)(nil)
 
 // UpdateNetmapDelta implements controlclient.NetmapDeltaUpdater.
@@ -1331,10 +1548,7 @@ func (b *LocalBackend) UpdateNetmapDelta(muts []netmap.NodeMutation) (handled bo
 
 	if b.netMap != nil && mutationsAreWorthyOfTellingIPNBus(muts) {
 		nm := ptr.To(*b.netMap) // shallow clone
-		nm.Peers = make([]tailcfg.NodeView, 0, len(b.peers))
-		for _, p := range b.peers {
-			nm.Peers = append(nm.Peers, p)
-		}
+		nm.Peers = b.peers.all()
 		slices.SortFunc(nm.Peers, func(a, b tailcfg.NodeView) int {
 			return cmp.Compare(a.ID(), b.ID())
 		})
@@ -1365,32 +1579,13 @@ func mutationsAreWorthyOfTellingIPNBus(muts []netmap.NodeMutation) bool {
 }
 
 func (b *LocalBackend) updateNetmapDeltaLocked(muts []netmap.NodeMutation) (handled bool) {
-	if b.netMap == nil || len(b.peers) == 0 {
+	if b.netMap == nil || b.peers.Len() == 0 {
 		return false
 	}
-
-	// Locally cloned mutable nodes, to avoid calling AsStruct (clone)
-	// multiple times on a node if it's mutated multiple times in this
-	// call (e.g. its endpoints + online status both change)
-	var mutableNodes map[tailcfg.NodeID]*tailcfg.Node
-
-	for _, m := range muts {
-		n, ok := mutableNodes[m.NodeIDBeingMutated()]
-		if !ok {
-			nv, ok := b.peers[m.NodeIDBeingMutated()]
-			if !ok {
-				// TODO(bradfitz): unexpected metric?
-				return false
-			}
-			n = nv.AsStruct()
-			mak.Set(&mutableNodes, nv.ID(), n)
-		}
-		m.Apply(n)
-	}
-	for nid, n := range mutableNodes {
-		b.peers[nid] = n.View()
-	}
-	return true
+	// The mutations are only applied to a peer's node once it's looked up,
+	// ranged over or has active flows; see peerStore.
+	// TODO(bradfitz): unexpected metric if this fails?
+	return b.peers.mutate(muts)
 }
 
 // setExitNodeID updates prefs to reference an exit node by ID, rather
@@ -1477,8 +1672,19 @@ func (b *LocalBackend) setWgengineStatus(s *wgengine.Status, err error) {
 	if needUpdateEndpoints {
 		b.endpoints = append([]tailcfg.Endpoint{}, s.LocalAddrs...)
 	}
+	usageReports := b.pm.CurrentPrefs().UsageReports()
+	if usageReports {
+		b.usage.notePeerStats(s.AsOf, s.Peers, b.peers.getByKey)
+	} else {
+		b.usage.noteBaseline(s.Peers)
+	}
+	b.peers.setActive(s.AsOf, s.Peers)
 	b.mu.Unlock()
 
+	if usageReports {
+		b.usage.maybeSave(s.AsOf)
+	}
+
 	if cc != nil {
 		if needUpdateEndpoints {
 			cc.UpdateEndpoints(s.LocalAddrs)
@@ -1542,8 +1748,7 @@ func (b *LocalBackend) SetControlClientGetterForTesting(newControlClient func(co
 func (b *LocalBackend) NodeViewByIDForTest(id tailcfg.NodeID) (_ tailcfg.NodeView, ok bool) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	n, ok := b.peers[id]
-	return n, ok
+	return b.peers.get(id)
 }
 
 // PeersForTest returns all the current peers, sorted by Node.ID,
@@ -1551,7 +1756,7 @@ func (b *LocalBackend) NodeViewByIDForTest(id tailcfg.NodeID) (_ tailcfg.NodeVie
 func (b *LocalBackend) PeersForTest() []tailcfg.NodeView {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	ret := xmaps.Values(b.peers)
+	ret := b.peers.all()
 	slices.SortFunc(ret, func(a, b tailcfg.NodeView) int {
 		return cmp.Compare(a.ID(), b.ID())
 	})
@@ -1565,8 +1770,12 @@ func (b *LocalBackend) getNewControlClientFunc() clientGen {
 		// Initialize it rather than just returning the
 		// default to make any future call to
 		// SetControlClientGetterForTesting panic.
-		b.ccGen = func(opts controlclient.Options) (controlclient.Client, error) {
-			return controlclient.New(opts)
+		if newCC, ok := b.sys.NewControlClient.GetOK(); ok {
+			b.ccGen = clientGen(newCC)
+		} else {
+			b.ccGen = func(opts controlclient.Options) (controlclient.Client, error) {
+				return controlclient.New(opts)
+			}
 		}
 	}
 	return b.ccGen
@@ -1881,7 +2090,7 @@ func (b *LocalBackend) updateFilterLocked(netMap *netmap.NetworkMap, prefs ipn.P
 		}
 		packetFilter = netMap.PacketFilter
 
-		if packetFilterPermitsUnlockedNodes(b.peers, packetFilter) {
+		if packetFilterPermitsUnlockedNodes(b.peers.static(), packetFilter) {
 			err := errors.New("server sent invalid packet filter permitting traffic to unlocked nodes; rejecting all packets for safety")
 			warnInvalidUnsignedNodes.Set(err)
 			packetFilter = nil
@@ -1972,6 +2181,26 @@ func (b *LocalBackend) updateFilterLocked(netMap *netmap.NetworkMap, prefs ipn.P
 	}
 }
 
+// CheckPacketFilter evaluates the currently installed packet filter for an
+// incoming packet from src to dst:port using protocol proto, without sending
+// any packets.
+func (b *LocalBackend) CheckPacketFilter(src, dst netip.Addr, port uint16, proto ipproto.Proto) (*apitype.PacketFilterCheckResponse, error) {
+	f := b.e.GetFilter()
+	if f == nil {
+		return nil, errors.New("no packet filter installed")
+	}
+	r, why, rule := f.Explain(src, dst, port, proto)
+	res := &apitype.PacketFilterCheckResponse{
+		Allowed: r == filter.Accept,
+		Verdict: r.String(),
+		Reason:  why,
+	}
+	if rule != nil {
+		res.Rule = rule.String()
+	}
+	return res, nil
+}
+
 // packetFilterPermitsUnlockedNodes reports any peer in peers with the
 // UnsignedPeerAPIOnly bool set true has any of its allowed IPs in the packet
 // filter.
@@ -2275,6 +2504,9 @@ func (b *LocalBackend) WatchNotifications(ctx context.Context, mask ipn.NotifyWa
 			if b.state == ipn.NeedsLogin {
 				ini.BrowseToURL = ptr.To(b.authURLSticky)
 			}
+			if health.CaptivePortalDetected() {
+				ini.CaptivePortalDetected = ptr.To(true)
+			}
 		}
 		if mask&ipn.NotifyInitialPrefs != 0 {
 			ini.Prefs = ptr.To(b.sanitizedPrefsLocked())
@@ -2382,7 +2614,7 @@ func (b *LocalBackend) DebugForceNetmapUpdate() {
 	nm := b.netMap
 	b.e.SetNetworkMap(nm)
 	if nm != nil {
-		b.MagicConn().SetDERPMap(nm.DERPMap)
+		b.MagicConn().SetDERPMap(b.derpMapOf(nm))
 	}
 	b.setNetMapLocked(nm)
 }
@@ -2543,6 +2775,9 @@ func (b *LocalBackend) onTailnetDefaultAutoUpdate(au bool) {
 		b.logf("failed to apply tailnet-wide default for auto-updates (%v): %v", au, err)
 		return
 	}
+	b.mu.Lock()
+	b.autoUpdateFromTailnetDefault = true
+	b.mu.Unlock()
 }
 
 // For testing lazy machine key generation.
@@ -2698,11 +2933,13 @@ func (b *LocalBackend) setTCPPortsIntercepted(ports []uint16) {
 	b.shouldInterceptTCPPortAtomic.Store(f)
 }
 
-// setAtomicValuesFromPrefsLocked populates sshAtomicBool, containsViaIPFuncAtomic
-// and shouldInterceptTCPPortAtomic from the prefs p, which may be !Valid().
+// setAtomicValuesFromPrefsLocked populates sshAtomicBool, containsViaIPFuncAtomic,
+// shouldInterceptTCPPortAtomic and the resolver's query log func from the prefs
+// p, which may be !Valid().
 func (b *LocalBackend) setAtomicValuesFromPrefsLocked(p ipn.PrefsView) {
 	b.sshAtomicBool.Store(p.Valid() && p.RunSSH() && envknob.CanSSHD())
 	b.setWebClientAtomicBoolLocked(b.netMap, p)
+	b.setDNSQueryLogLocked(p)
 
 	if !p.Valid() {
 		b.containsViaIPFuncAtomic.Store(tsaddr.FalseContainsIPFunc())
@@ -2865,6 +3102,30 @@ func (b *LocalBackend) pingPeerAPI(ctx context.Context, ip netip.Addr) (peer tai
 	var zero tailcfg.NodeView
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
+	peer, base, err := b.peerAPIBaseForIP(ip)
+	if err != nil {
+		return zero, "", err
+	}
+	outReq, err := http.NewRequestWithContext(ctx, "HEAD", base, nil)
+	if err != nil {
+		return zero, "", err
+	}
+	tr := b.Dialer().PeerAPITransport()
+	res, err := tr.RoundTrip(outReq)
+	if err != nil {
+		return zero, "", err
+	}
+	defer res.Body.Close() // but unnecessary on HEAD responses
+	if res.StatusCode != http.StatusOK {
+		return zero, "", fmt.Errorf("HTTP status %v", res.Status)
+	}
+	return peer, base, nil
+}
+
+// peerAPIBaseForIP returns the peer with Tailscale IP ip and the
+// "http://ip:port" URL base to reach its peerAPI.
+func (b *LocalBackend) peerAPIBaseForIP(ip netip.Addr) (peer tailcfg.NodeView, peerBase string, err error) {
+	var zero tailcfg.NodeView
 	nm := b.NetMap()
 	if nm == nil {
 		return zero, "", errors.New("no netmap")
@@ -2880,19 +3141,6 @@ func (b *LocalBackend) pingPeerAPI(ctx context.Context, ip netip.Addr) (peer tai
 	if base == "" {
 		return zero, "", fmt.Errorf("no PeerAPI base found for peer %v (%v)", peer.ID(), ip)
 	}
-	outReq, err := http.NewRequestWithContext(ctx, "HEAD", base, nil)
-	if err != nil {
-		return zero, "", err
-	}
-	tr := b.Dialer().PeerAPITransport()
-	res, err := tr.RoundTrip(outReq)
-	if err != nil {
-		return zero, "", err
-	}
-	defer res.Body.Close() // but unnecessary on HEAD responses
-	if res.StatusCode != http.StatusOK {
-		return zero, "", fmt.Errorf("HTTP status %v", res.Status)
-	}
 	return peer, base, nil
 }
 
@@ -3123,6 +3371,9 @@ func (b *LocalBackend) EditPrefs(mp *ipn.MaskedPrefs) (ipn.PrefsView, error) {
 		b.mu.Unlock()
 		return stripKeysFromPrefs(p0), nil
 	}
+	if mp.AutoUpdateSet.ApplySet {
+		b.autoUpdateFromTailnetDefault = false
+	}
 	b.logf("EditPrefs: %v", mp.Pretty())
 	newPrefs := b.setPrefsLockedOnEntry("EditPrefs", p1) // does a b.mu.Unlock
 
@@ -3191,7 +3442,7 @@ func (b *LocalBackend) setPrefsLockedOnEntry(caller string, newp *ipn.Prefs) ipn
 	// anyway. No-op if no exit node resolution is needed.
 	setExitNodeID(newp, netMap)
 	// applySysPolicy does likewise so we can also ignore its return value.
-	applySysPolicy(newp)
+	applySysPolicy(newp, nil)
 	// We do this to avoid holding the lock while doing everything else.
 
 	oldHi := b.hostinfo
@@ -3246,7 +3497,7 @@ func (b *LocalBackend) setPrefsLockedOnEntry(caller string, newp *ipn.Prefs) ipn
 	}
 
 	if netMap != nil {
-		b.MagicConn().SetDERPMap(netMap.DERPMap)
+		b.MagicConn().SetDERPMap(b.derpMapOf(netMap))
 	}
 
 	if !oldp.WantRunning() && newp.WantRunning {
@@ -3459,12 +3710,18 @@ func (b *LocalBackend) reconfigAppConnectorLocked(nm *netmap.NetworkMap, prefs i
 	}()
 
 	if !prefs.AppConnector().Advertise {
+		if b.appConnector != nil {
+			b.appConnector.Close()
+		}
 		b.appConnector = nil
 		return
 	}
 
 	if b.appConnector == nil {
 		b.appConnector = appc.NewAppConnector(b.logf, b)
+		// Routes learned before a restart are only in the prefs; track
+		// them so that they can expire.
+		b.appConnector.RestoreRoutes(prefs.AdvertiseRoutes().AsSlice())
 	}
 	if nm == nil {
 		return
@@ -3487,13 +3744,34 @@ func (b *LocalBackend) reconfigAppConnectorLocked(nm *netmap.NetworkMap, prefs i
 	}
 
 	var (
-		domains []string
-		routes  []netip.Prefix
+		domains     []string
+		routes      []netip.Prefix
+		routeMaxAge time.Duration
 	)
 	for _, attr := range attrs {
-		if slices.Contains(attr.Connectors, "*") || selfHasTag(attr.Connectors) {
+		if !slices.Contains(attr.Connectors, "*") && !selfHasTag(attr.Connectors) {
+			continue
+		}
+		if d := time.Duration(attr.RouteMaxAgeSeconds) * time.Second; d > 0 && (routeMaxAge == 0 || d < routeMaxAge) {
+			routeMaxAge = d
+		}
+		if attr.Partitions < 2 {
 			domains = append(domains, attr.Domains...)
 			routes = append(routes, attr.Routes...)
+			continue
+		}
+		// The domains and routes are split across several connectors;
+		// only serve the partitions control assigned to this one.
+		owned := attr.PartitionOwners[nm.SelfNode.StableID()]
+		for _, d := range attr.Domains {
+			if slices.Contains(owned, appc.Partition(d, attr.Partitions)) {
+				domains = append(domains, d)
+			}
+		}
+		for _, r := range attr.Routes {
+			if slices.Contains(owned, appc.Partition(r.String(), attr.Partitions)) {
+				routes = append(routes, r)
+			}
 		}
 	}
 	slices.Sort(domains)
@@ -3501,6 +3779,7 @@ func (b *LocalBackend) reconfigAppConnectorLocked(nm *netmap.NetworkMap, prefs i
 	domains = slices.Compact(domains)
 	routes = slices.Compact(routes)
 	b.appConnector.UpdateDomainsAndRoutes(domains, routes)
+	b.appConnector.SetRouteMaxAge(routeMaxAge)
 }
 
 // authReconfig pushes a new configuration into wgengine, if engine
@@ -3513,8 +3792,8 @@ func (b *LocalBackend) authReconfig() {
 	nm := b.netMap
 	hasPAC := b.prevIfState.HasPAC()
 	disableSubnetsIfPAC := hasCapability(nm, tailcfg.NodeAttrDisableSubnetsIfPAC)
-	dohURL, dohURLOK := exitNodeCanProxyDNS(nm, b.peers, prefs.ExitNodeID())
-	dcfg := dnsConfigForNetmap(nm, b.peers, prefs, b.logf, version.OS())
+	dohURL, dohURLOK := exitNodeCanProxyDNS(nm, b.peers.static(), prefs.ExitNodeID())
+	dcfg := dnsConfigForNetmap(nm, b.peers.static(), prefs, b.logf, version.OS())
 	// If the current node is an app connector, ensure the app connector machine is started
 	b.reconfigAppConnectorLocked(nm, prefs)
 	b.mu.Unlock()
@@ -3751,6 +4030,17 @@ func dnsConfigForNetmap(nm *netmap.NetworkMap, peers map[tailcfg.NodeID]tailcfg.
 		dcfg.Routes[fqdn] = make([]*dnstype.Resolver, 0, len(resolvers))
 		dcfg.Routes[fqdn] = append(dcfg.Routes[fqdn], resolvers...)
 	}
+	for _, suffix := range nm.DNS.FailoverRoutes {
+		fqdn, err := dnsname.ToFQDN(suffix)
+		if err != nil {
+			logf("[unexpected] non-FQDN failover route suffix %q", suffix)
+			continue
+		}
+		if len(dcfg.Routes[fqdn]) == 0 {
+			continue
+		}
+		mak.Set(&dcfg.FailoverRoutes, fqdn, struct{}{})
+	}
 
 	// Set FallbackResolvers as the default resolvers in the
 	// scenarios that can't handle a purely split-DNS config. See
@@ -3781,6 +4071,27 @@ func dnsConfigForNetmap(nm *netmap.NetworkMap, peers map[tailcfg.NodeID]tailcfg.
 	return dcfg
 }
 
+// DNSFailoverStatus returns the state of the split DNS routes whose resolvers
+// are used in order, failing over from one to the next, including which
+// resolver each currently uses.
+func (b *LocalBackend) DNSFailoverStatus() []dnstype.FailoverRouteStatus {
+	dm, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return nil
+	}
+	return dm.Resolver().FailoverStatus()
+}
+
+// FlushDNSCache drops the responses from upstream resolvers cached by
+// Tailscale's DNS resolver and returns how many there were.
+func (b *LocalBackend) FlushDNSCache() int {
+	dm, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return 0
+	}
+	return dm.Resolver().FlushCache()
+}
+
 // SetTCPHandlerForFunnelFlow sets the TCP handler for Funnel flows.
 // It should only be called before the LocalBackend is used.
 func (b *LocalBackend) SetTCPHandlerForFunnelFlow(h func(src netip.AddrPort, dstPort uint16) (handler func(net.Conn))) {
@@ -4114,19 +4425,17 @@ func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs ipn.PrefsView, oneC
 		}
 		switch runtime.GOOS {
 		case "linux", "windows", "darwin", "ios":
-			rs.LocalRoutes = internalIPs // unconditionally allow access to guest VM networks
-			if prefs.ExitNodeAllowLANAccess() {
-				rs.LocalRoutes = append(rs.LocalRoutes, externalIPs...)
-			} else {
-				// Explicitly add routes to the local network so that we do not
-				// leak any traffic.
-				rs.Routes = append(rs.Routes, externalIPs...)
-			}
+			var tunneled []netip.Prefix
+			rs.LocalRoutes, tunneled = exitNodeLANRoutes(internalIPs, externalIPs, prefs.ExitNodeAllowLANAccess(), prefs.ExitNodeAllowedLANRoutes())
+			rs.Routes = append(rs.Routes, tunneled...)
 			b.logf("allowing exit node access to local IPs: %v", rs.LocalRoutes)
 		default:
 			if prefs.ExitNodeAllowLANAccess() {
 				b.logf("warning: ExitNodeAllowLANAccess has no effect on " + runtime.GOOS)
 			}
+			if prefs.ExitNodeAllowedLANRoutes().Len() > 0 {
+				b.logf("warning: ExitNodeAllowedLANRoutes has no effect on " + runtime.GOOS)
+			}
 		}
 	}
 
@@ -4134,9 +4443,95 @@ func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs ipn.PrefsView, oneC
 		rs.Routes = append(rs.Routes, netip.PrefixFrom(tsaddr.TailscaleServiceIP(), 32))
 	}
 
+	if mtus := prefs.RouteMTUs(); mtus.Len() > 0 {
+		var extra []netip.Prefix
+		rs.RouteMTUs, extra = routeMTUs(rs.Routes, mtus)
+		rs.Routes = append(rs.Routes, extra...)
+	}
+
 	return rs
 }
 
+// routeMTUs returns the MTUs of routes given mtus, the RouteMTUs pref. A
+// route gets the MTU of the most specific prefix in mtus that covers it.
+// The prefixes in mtus that are more specific than one of routes are
+// returned in extra, with their MTUs, to be installed as routes of their
+// own, so that packets to them use their MTU.
+func routeMTUs(routes []netip.Prefix, mtus views.Map[netip.Prefix, int]) (m map[netip.Prefix]int, extra []netip.Prefix) {
+	isRoute := make(map[netip.Prefix]bool, len(routes))
+	for _, r := range routes {
+		isRoute[r] = true
+		bestBits := -1
+		mtus.Range(func(p netip.Prefix, mtu int) bool {
+			if p.Addr().BitLen() == r.Addr().BitLen() && p.Bits() <= r.Bits() && p.Contains(r.Addr()) && p.Bits() > bestBits {
+				bestBits = p.Bits()
+				mak.Set(&m, r, mtu)
+			}
+			return true
+		})
+	}
+	mtus.Range(func(p netip.Prefix, mtu int) bool {
+		if isRoute[p] {
+			return true
+		}
+		for _, r := range routes {
+			if r.Bits() < p.Bits() && r.Contains(p.Addr()) {
+				extra = append(extra, p)
+				mak.Set(&m, p, mtu)
+				break
+			}
+		}
+		return true
+	})
+	slices.SortFunc(extra, func(a, b netip.Prefix) int {
+		return cmp.Or(a.Addr().Compare(b.Addr()), cmp.Compare(a.Bits(), b.Bits()))
+	})
+	return m, extra
+}
+
+// exitNodeLANRoutes returns the routes that should be routed directly
+// (localRoutes) and the local network routes that must be explicitly routed
+// via the exit node so as to not leak any traffic (tunneled), given the
+// internal and external interface prefixes of the machine.
+//
+// Internal (guest VM and container) networks are always routed directly. If
+// allowLAN is true, so are all external networks. Otherwise only the parts of
+// the prefixes in allowed that are on an external network are routed
+// directly; being at least as specific as the routes to the external
+// networks, they take precedence over them. Allowed prefixes elsewhere, such
+// as public ones, are ignored so that they can't be used to bypass the exit
+// node.
+func exitNodeLANRoutes(internal, external []netip.Prefix, allowLAN bool, allowed views.Slice[netip.Prefix]) (localRoutes, tunneled []netip.Prefix) {
+	localRoutes = internal // unconditionally allow access to guest VM networks
+	if allowLAN {
+		return append(localRoutes, external...), nil
+	}
+	if allowed.Len() == 0 {
+		return localRoutes, external
+	}
+
+	var lanb, directb netipx.IPSetBuilder
+	for _, pfx := range external {
+		lanb.AddPrefix(pfx)
+	}
+	for i := range allowed.Len() {
+		directb.AddPrefix(allowed.At(i))
+	}
+	// IPSet only fails on invalid prefixes, which it skips.
+	lan, _ := lanb.IPSet()
+	directb.Intersect(lan)
+	directSet, _ := directb.IPSet()
+	direct := directSet.Prefixes()
+
+	localRoutes = append(localRoutes, direct...)
+	for _, pfx := range external {
+		if !slices.Contains(direct, pfx) {
+			tunneled = append(tunneled, pfx)
+		}
+	}
+	return localRoutes, tunneled
+}
+
 func unmapIPPrefix(ipp netip.Prefix) netip.Prefix {
 	return netip.PrefixFrom(ipp.Addr().Unmap(), ipp.Bits())
 }
@@ -4204,6 +4599,7 @@ func (b *LocalBackend) enterStateLockedOnEntry(newState ipn.State) {
 	netMap := b.netMap
 	activeLogin := b.activeLogin
 	authURL := b.authURL
+	keyExpired := b.keyExpired
 	if newState == ipn.Running {
 		b.authURL = ""
 		b.authURLSticky = ""
@@ -4223,7 +4619,14 @@ func (b *LocalBackend) enterStateLockedOnEntry(newState ipn.State) {
 	}
 	b.logf("Switching ipn state %v -> %v (WantRunning=%v, nm=%v)",
 		oldState, newState, prefs.WantRunning(), netMap != nil)
-	b.send(ipn.Notify{State: &newState})
+	n := ipn.Notify{State: &newState}
+	switch {
+	case newState == ipn.NeedsMachineAuth:
+		n.ErrorCode = ipn.ErrorCodeMachineNotApproved
+	case newState == ipn.NeedsLogin && keyExpired:
+		n.ErrorCode = ipn.ErrorCodeNodeKeyExpired
+	}
+	b.send(n)
 
 	switch newState {
 	case ipn.NeedsLogin:
@@ -4260,6 +4663,22 @@ func (b *LocalBackend) enterStateLockedOnEntry(newState ipn.State) {
 	}
 }
 
+// unapprovedRoutes returns the routes advertised in prefs that the control
+// server hasn't approved for self, which are missing from its AllowedIPs.
+func unapprovedRoutes(prefs ipn.PrefsView, self tailcfg.NodeView) []netip.Prefix {
+	if !prefs.Valid() || !self.Valid() {
+		return nil
+	}
+	var ret []netip.Prefix
+	ar := prefs.AdvertiseRoutes()
+	for i := range ar.LenIter() {
+		if r := ar.At(i); !views.SliceContains(self.AllowedIPs(), r) {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
 // hasNodeKey reports whether a non-zero node key is present in the current
 // prefs.
 func (b *LocalBackend) hasNodeKey() bool {
@@ -4448,6 +4867,7 @@ func (b *LocalBackend) ResetForClientDisconnect() {
 		b.currentUser = nil
 	}
 	b.keyExpired = false
+	b.routesUnapproved = false
 	b.authURL = ""
 	b.authURLSticky = ""
 	b.authURLTime = time.Time{}
@@ -4603,6 +5023,7 @@ func (b *LocalBackend) setNetMapLocked(nm *netmap.NetworkMap) {
 
 	b.MagicConn().SetSilentDisco(b.ControlKnobs().SilentDisco.Load())
 	b.MagicConn().SetProbeUDPLifetime(b.ControlKnobs().ProbeUDPLifetime.Load())
+	b.MagicConn().SetPQHybridKeyExchange(b.ControlKnobs().PQHybridKeyExchange.Load())
 
 	b.setDebugLogsByCapabilityLocked(nm)
 
@@ -4615,6 +5036,9 @@ func (b *LocalBackend) setNetMapLocked(nm *netmap.NetworkMap) {
 		b.nodeByAddr = nil
 		return
 	}
+	if b.pm.CurrentPrefs().UsageReports() {
+		b.usage.notePeers(b.clock.Now(), nm.Peers)
+	}
 
 	// Update the nodeByAddr index.
 	if b.nodeByAddr == nil {
@@ -4652,26 +5076,10 @@ func (b *LocalBackend) setNetMapLocked(nm *netmap.NetworkMap) {
 
 func (b *LocalBackend) updatePeersFromNetmapLocked(nm *netmap.NetworkMap) {
 	if nm == nil {
-		b.peers = nil
+		b.peers.setPeers(nil)
 		return
 	}
-
-	// First pass, mark everything unwanted.
-	for k := range b.peers {
-		b.peers[k] = tailcfg.NodeView{}
-	}
-
-	// Second pass, add everything wanted.
-	for _, p := range nm.Peers {
-		mak.Set(&b.peers, p.ID(), p)
-	}
-
-	// Third pass, remove deleted things.
-	for k, v := range b.peers {
-		if !v.Valid() {
-			delete(b.peers, k)
-		}
-	}
+	b.peers.setPeers(nm.Peers)
 }
 
 // tailFSTransport is an http.RoundTripper that uses the latest value of
@@ -4721,6 +5129,13 @@ func (b *LocalBackend) reloadServeConfigLocked(prefs ipn.PrefsView) {
 		return
 	}
 
+	if sc := b.confServeConfigLocked(); sc != nil {
+		// The config file's serve config replaces the one in the store.
+		b.lastServeConfJSON = mem.B(nil)
+		b.serveConfig = sc.View()
+		return
+	}
+
 	confKey := ipn.ServeConfigKey(b.pm.CurrentProfile().ID)
 	// TODO(maisem,bradfitz): prevent reading the config from disk
 	// if the profile has not changed.
@@ -4749,6 +5164,17 @@ func (b *LocalBackend) reloadServeConfigLocked(prefs ipn.PrefsView) {
 	b.serveConfig = conf.View()
 }
 
+// confServeConfigLocked returns the serve config set by the config file, or
+// nil if not running in declarative mode or the config file doesn't set one.
+//
+// b.mu must be held.
+func (b *LocalBackend) confServeConfigLocked() *ipn.ServeConfig {
+	if b.conf == nil {
+		return nil
+	}
+	return b.conf.Parsed.ServeConfigTemp
+}
+
 // setTCPPortsInterceptedFromNetmapAndPrefsLocked calls setTCPPortsIntercepted with
 // the ports that tailscaled should handle as a function of b.netMap and b.prefs.
 //
@@ -4769,6 +5195,7 @@ func (b *LocalBackend) setTCPPortsInterceptedFromNetmapAndPrefsLocked(prefs ipn.
 	}
 
 	b.reloadServeConfigLocked(prefs)
+	b.updateFunnelScheduleLocked()
 	if b.serveConfig.Valid() {
 		servePorts := make([]uint16, 0, 3)
 		b.serveConfig.RangeOverTCPs(func(port uint16, _ ipn.TCPPortHandlerView) bool {
@@ -4977,7 +5404,7 @@ func (b *LocalBackend) FileTargets() ([]*apitype.FileTarget, error) {
 	if !b.capFileSharing {
 		return nil, errors.New("file sharing not enabled by Tailscale admin")
 	}
-	for _, p := range b.peers {
+	for _, p := range b.peers.all() {
 		if !b.peerIsTaildropTargetLocked(p) {
 			continue
 		}
@@ -5185,7 +5612,16 @@ func (b *LocalBackend) DERPMap() *tailcfg.DERPMap {
 	if b.netMap == nil {
 		return nil
 	}
-	return b.netMap.DERPMap
+	return b.derpMapOf(b.netMap)
+}
+
+// derpMapOf returns the DERP map to use with the netmap nm, which is
+// nm's unless the System overrides it.
+func (b *LocalBackend) derpMapOf(nm *netmap.NetworkMap) *tailcfg.DERPMap {
+	if dm, ok := b.sys.DERPMap.GetOK(); ok {
+		return dm
+	}
+	return nm.DERPMap
 }
 
 // OfferingExitNode reports whether b is currently offering exit node
@@ -5660,6 +6096,7 @@ func (b *LocalBackend) resetForProfileChangeLockedOnEntry() error {
 	}
 	b.lastServeConfJSON = mem.B(nil)
 	b.serveConfig = ipn.ServeConfigView{}
+	b.autoUpdateFromTailnetDefault = false
 	b.enterStateLockedOnEntry(ipn.NoState) // Reset state; releases b.mu
 	health.SetLocalLogConfigHealth(nil)
 	return b.Start(ipn.Options{})
@@ -5705,6 +6142,35 @@ func (b *LocalBackend) ListProfiles() []ipn.LoginProfile {
 	return b.pm.Profiles()
 }
 
+// ListAllProfiles returns the LoginProfiles of all local users, not just the
+// current one, along with information about who owns them.
+func (b *LocalBackend) ListAllProfiles() []ipn.ProfileOwnership {
+	b.mu.Lock()
+	all := b.pm.AllProfiles()
+	uid := b.pm.CurrentUserID()
+	cur := b.pm.CurrentProfile().ID
+	b.mu.Unlock()
+
+	names := map[ipn.WindowsUserID]string{}
+	out := make([]ipn.ProfileOwnership, 0, len(all))
+	for _, p := range all {
+		name, ok := names[p.LocalUserID]
+		if !ok && p.LocalUserID != "" {
+			if u, err := ipnauth.LookupUserFromID(b.logf, string(p.LocalUserID)); err == nil {
+				name = u.Username
+			}
+			names[p.LocalUserID] = name
+		}
+		out = append(out, ipn.ProfileOwnership{
+			Profile:            p,
+			OwnerName:          name,
+			OwnedByCurrentUser: p.LocalUserID == uid,
+			Current:            cur != "" && p.ID == cur,
+		})
+	}
+	return out
+}
+
 // ResetAuth resets the authentication state, including persisted keys. Also
 // has the side effect of removing all profiles and reseting preferences. The
 // backend is left with a new profile, ready for StartLoginInterative to be
@@ -5956,11 +6422,15 @@ func (b *LocalBackend) seamlessRenewalEnabled() bool {
 		netip.MustParseAddr("::1"),
 		netip.MustParseAddr("::"),
 		netip.MustParseAddr("0.0.0.0"),
+		tsaddr.TailscaleServiceIP(),
 	}
 	disallowedRanges = []netip.Prefix{
 		netip.MustParsePrefix("127.0.0.0/8"),
+		netip.MustParsePrefix("169.254.0.0/16"),
 		netip.MustParsePrefix("224.0.0.0/4"),
+		netip.MustParsePrefix("fe80::/10"),
 		netip.MustParsePrefix("ff00::/8"),
+		tsaddr.TailscaleULARange(),
 	}
 )
 
@@ -5977,7 +6447,6 @@ func allowedAutoRoute(ipp netip.Prefix) bool {
 			return false
 		}
 	}
-	// TODO(raggi): exclude tailscale service IPs and so on as well.
 	return true
 }
 
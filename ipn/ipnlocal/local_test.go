@@ -5,6 +5,7 @@
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
@@ -23,10 +24,12 @@
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/net/interfaces"
+	"tailscale.com/net/netmon"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tsd"
 	"tailscale.com/tstest"
+	"tailscale.com/types/appctype"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
@@ -34,8 +37,8 @@
 	"tailscale.com/types/netmap"
 	"tailscale.com/types/opt"
 	"tailscale.com/types/ptr"
+	"tailscale.com/types/views"
 	"tailscale.com/util/dnsname"
-	"tailscale.com/util/mak"
 	"tailscale.com/util/must"
 	"tailscale.com/util/set"
 	"tailscale.com/util/syspolicy"
@@ -268,6 +271,37 @@ func TestPeerRoutes(t *testing.T) {
 	}
 }
 
+func TestRouteMTUs(t *testing.T) {
+	pp := netip.MustParsePrefix
+	routes := []netip.Prefix{
+		pp("10.0.0.0/16"),
+		pp("10.1.0.0/16"),
+		pp("192.168.1.0/24"),
+		pp("fd7a:115c:a1e0::/48"),
+	}
+	mtus := views.MapOf(map[netip.Prefix]int{
+		pp("10.0.0.0/8"):     1400, // covers both 10.x routes
+		pp("10.1.0.0/16"):    1300, // more specific than 10.0.0.0/8
+		pp("192.168.1.8/29"): 1280, // within 192.168.1.0/24
+		pp("172.16.0.0/12"):  1280, // not routed through Tailscale
+		pp("::/0"):           1350, // covers the IPv6 route only
+	})
+	gotMTUs, gotExtra := routeMTUs(routes, mtus)
+	wantMTUs := map[netip.Prefix]int{
+		pp("10.0.0.0/16"):         1400,
+		pp("10.1.0.0/16"):         1300,
+		pp("192.168.1.8/29"):      1280,
+		pp("fd7a:115c:a1e0::/48"): 1350,
+	}
+	wantExtra := []netip.Prefix{pp("192.168.1.8/29")}
+	if !reflect.DeepEqual(gotMTUs, wantMTUs) {
+		t.Errorf("MTUs = %v; want %v", gotMTUs, wantMTUs)
+	}
+	if !reflect.DeepEqual(gotExtra, wantExtra) {
+		t.Errorf("extra routes = %v; want %v", gotExtra, wantExtra)
+	}
+}
+
 func TestPeerAPIBase(t *testing.T) {
 	tests := []struct {
 		name string
@@ -477,16 +511,11 @@ func TestFileTargets(t *testing.T) {
 		t.Fatalf("unexpected %d peers", len(got))
 	}
 
-	var peerMap map[tailcfg.NodeID]tailcfg.NodeView
-	mak.NonNil(&peerMap)
-	var nodeID tailcfg.NodeID
-	nodeID = 1234
 	peer := &tailcfg.Node{
 		ID:       1234,
 		Hostinfo: (&tailcfg.Hostinfo{OS: "tvOS"}).View(),
 	}
-	peerMap[nodeID] = peer.View()
-	b.peers = peerMap
+	b.peers.setPeers([]tailcfg.NodeView{peer.View()})
 	got, err = b.FileTargets()
 	if err != nil {
 		t.Fatal(err)
@@ -597,6 +626,77 @@ type interfacePrefix struct {
 	}
 }
 
+func TestExitNodeLANRoutes(t *testing.T) {
+	pfxs := func(ss ...string) (ret []netip.Prefix) {
+		for _, s := range ss {
+			ret = append(ret, netip.MustParsePrefix(s))
+		}
+		return ret
+	}
+	internal := pfxs("127.0.0.0/8")
+	external := pfxs("10.20.0.0/16", "192.168.0.0/16")
+
+	tests := []struct {
+		name         string
+		allowLAN     bool
+		allowed      []netip.Prefix
+		wantLocal    []netip.Prefix
+		wantTunneled []netip.Prefix
+	}{
+		{
+			name:         "no-lan-access",
+			wantLocal:    pfxs("127.0.0.0/8"),
+			wantTunneled: pfxs("10.20.0.0/16", "192.168.0.0/16"),
+		},
+		{
+			name:      "lan-access",
+			allowLAN:  true,
+			wantLocal: pfxs("127.0.0.0/8", "10.20.0.0/16", "192.168.0.0/16"),
+		},
+		{
+			name:         "allowed-lan-routes",
+			allowed:      pfxs("192.168.1.0/24"),
+			wantLocal:    pfxs("127.0.0.0/8", "192.168.1.0/24"),
+			wantTunneled: pfxs("10.20.0.0/16", "192.168.0.0/16"),
+		},
+		{
+			name:         "allowed-whole-lan",
+			allowed:      pfxs("192.168.0.0/16"),
+			wantLocal:    pfxs("127.0.0.0/8", "192.168.0.0/16"),
+			wantTunneled: pfxs("10.20.0.0/16"),
+		},
+		{
+			name:         "allowed-public-prefix-limited-to-lan",
+			allowed:      pfxs("0.0.0.0/1"),
+			wantLocal:    pfxs("127.0.0.0/8", "10.20.0.0/16"),
+			wantTunneled: pfxs("192.168.0.0/16"),
+		},
+		{
+			name:         "allowed-public-prefix-ignored",
+			allowed:      pfxs("8.8.8.0/24", "2001:db8::/32"),
+			wantLocal:    pfxs("127.0.0.0/8"),
+			wantTunneled: pfxs("10.20.0.0/16", "192.168.0.0/16"),
+		},
+		{
+			name:      "lan-access-ignores-allowed-lan-routes",
+			allowLAN:  true,
+			allowed:   pfxs("192.168.1.0/24"),
+			wantLocal: pfxs("127.0.0.0/8", "10.20.0.0/16", "192.168.0.0/16"),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			gotLocal, gotTunneled := exitNodeLANRoutes(slices.Clone(internal), external, tc.allowLAN, views.SliceOf(tc.allowed))
+			if !reflect.DeepEqual(gotLocal, tc.wantLocal) {
+				t.Errorf("local routes: got %v, want %v", gotLocal, tc.wantLocal)
+			}
+			if !reflect.DeepEqual(gotTunneled, tc.wantTunneled) {
+				t.Errorf("tunneled routes: got %v, want %v", gotTunneled, tc.wantTunneled)
+			}
+		})
+	}
+}
+
 func TestPacketFilterPermitsUnlockedNodes(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -730,6 +830,56 @@ func TestStatusWithoutPeers(t *testing.T) {
 	}
 }
 
+func TestSystemTestHooks(t *testing.T) {
+	var logf logger.Logf = logger.Discard
+	sys := new(tsd.System)
+	sys.Set(new(mem.Store))
+	netMon := netmon.NewStatic(nil)
+	eng, err := wgengine.NewFakeUserspaceEngine(logf, sys.Set, netMon)
+	if err != nil {
+		t.Fatalf("NewFakeUserspaceEngine: %v", err)
+	}
+	t.Cleanup(eng.Close)
+	sys.Set(eng)
+	if got := sys.NetMon.Get(); got != netMon {
+		t.Fatalf("NetMon = %p; want static monitor %p", got, netMon)
+	}
+
+	fakeDERP := &tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{
+			900: {RegionID: 900, RegionCode: "fake"},
+		},
+	}
+	sys.Set(fakeDERP)
+	var cc *mockControl
+	sys.Set(tsd.NewControlClientFunc(func(opts controlclient.Options) (controlclient.Client, error) {
+		cc = newClient(t, opts)
+		return cc, nil
+	}))
+
+	b, err := NewLocalBackend(logf, logid.PublicID{}, sys, 0)
+	if err != nil {
+		t.Fatalf("NewLocalBackend: %v", err)
+	}
+	if err := b.Start(ipn.Options{}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if cc == nil {
+		t.Fatal("control client not created by System.NewControlClient")
+	}
+	cc.send(nil, "", true, &netmap.NetworkMap{
+		SelfNode: (&tailcfg.Node{MachineAuthorized: true}).View(),
+		DERPMap: &tailcfg.DERPMap{
+			Regions: map[int]*tailcfg.DERPRegion{
+				1: {RegionID: 1, RegionCode: "real"},
+			},
+		},
+	})
+	if got := b.DERPMap(); got != fakeDERP {
+		t.Errorf("DERPMap = %v; want the System's", got)
+	}
+}
+
 // legacyBackend was the interface between Tailscale frontends
 // (e.g. cmd/tailscale, iOS/MacOS/Windows GUIs) and the tailscale
 // backend (e.g. cmd/tailscaled) running on the same machine.
@@ -862,7 +1012,7 @@ func TestUpdateNetmapDelta(t *testing.T) {
 		},
 	}
 	for _, want := range wants {
-		gotv, ok := b.peers[want.ID]
+		gotv, ok := b.peers.get(want.ID)
 		if !ok {
 			t.Errorf("netmap.Peer %v missing from b.peers", want.ID)
 			continue
@@ -1216,6 +1366,41 @@ func TestObserveDNSResponse(t *testing.T) {
 	if !slices.Equal(rc.Routes(), wantRoutes) {
 		t.Fatalf("got routes %v, want %v", rc.Routes(), wantRoutes)
 	}
+
+	b.ObserveDNSResponse(dnsResponse("example.com.", "2001:db8::8"))
+	b.appConnector.Wait(context.Background())
+	wantRoutes = append(wantRoutes, netip.MustParsePrefix("2001:db8::8/128"))
+	if !slices.Equal(rc.Routes(), wantRoutes) {
+		t.Fatalf("got routes %v, want %v", rc.Routes(), wantRoutes)
+	}
+}
+
+func TestAllowedAutoRoute(t *testing.T) {
+	tests := []struct {
+		route string
+		want  bool
+	}{
+		{"192.0.2.1/32", true},
+		{"192.0.2.0/24", true},
+		{"2001:db8::1/128", true},
+		{"2001:db8::/64", true},
+		{"0.0.0.0/32", false},
+		{"::/128", false},
+		{"::1/128", false},
+		{"127.0.0.1/32", false},
+		{"169.254.169.254/32", false},
+		{"224.0.0.251/32", false},
+		{"fe80::1/128", false},
+		{"ff02::fb/128", false},
+		{"100.100.100.100/32", false},
+		{"fd7a:115c:a1e0::53/128", false},
+		{"fd7a:115c:a1e0:ab12::1/128", false},
+	}
+	for _, tt := range tests {
+		if got := allowedAutoRoute(netip.MustParsePrefix(tt.route)); got != tt.want {
+			t.Errorf("allowedAutoRoute(%v) = %v; want %v", tt.route, got, tt.want)
+		}
+	}
 }
 
 func TestCoveredRouteRangeNoDefault(t *testing.T) {
@@ -1327,6 +1512,56 @@ func TestReconfigureAppConnector(t *testing.T) {
 	}
 }
 
+func TestReconfigureAppConnectorPartitioned(t *testing.T) {
+	b := newTestBackend(t)
+	b.EditPrefs(&ipn.MaskedPrefs{
+		Prefs: ipn.Prefs{
+			AppConnector: ipn.AppConnectorPrefs{
+				Advertise: true,
+			},
+		},
+		AppConnectorSet: true,
+	})
+
+	allDomains := []string{"a.example.com", "b.example.com", "c.example.com", "d.example.com", "e.example.com"}
+	var want []string
+	for _, d := range allDomains {
+		if appc.Partition(d, 3) == 1 {
+			want = append(want, d)
+		}
+	}
+	attr, err := json.Marshal(appctype.AppConnectorAttr{
+		Name:       "example",
+		Domains:    allDomains,
+		Connectors: []string{"tag:example"},
+		Partitions: 3,
+		PartitionOwners: map[tailcfg.StableNodeID][]int{
+			"self":  {1},
+			"other": {0, 2},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.netMap.SelfNode = (&tailcfg.Node{
+		StableID: "self",
+		Name:     "example.ts.net",
+		Tags:     []string{"tag:example"},
+		CapMap: (tailcfg.NodeCapMap)(map[tailcfg.NodeCapability][]tailcfg.RawMessage{
+			"tailscale.com/app-connectors": {tailcfg.RawMessage(attr)},
+		}),
+	}).View()
+
+	b.reconfigAppConnectorLocked(b.netMap, b.pm.prefs)
+	b.appConnector.Wait(context.Background())
+
+	got := b.appConnector.Domains().AsSlice()
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Fatalf("got domains %v, want %v", got, want)
+	}
+}
+
 func resolversEqual(t *testing.T, a, b []*dnstype.Resolver) bool {
 	if a == nil && b == nil {
 		return true
@@ -1466,6 +1701,31 @@ func (h *mockSyspolicyHandler) ReadBoolean(key string) (bool, error) {
 	return false, syspolicy.ErrNoSuchKey
 }
 
+func TestSysPolicyChanged(t *testing.T) {
+	msh := &mockSyspolicyHandler{
+		t:              t,
+		stringPolicies: map[syspolicy.Key]*string{},
+	}
+	syspolicy.SetHandlerForTest(t, msh)
+
+	b := newTestBackend(t)
+	b.SetPrefs(&ipn.Prefs{ShieldsUp: false})
+
+	// Nothing changed.
+	b.sysPolicyChanged()
+	if b.Prefs().ShieldsUp() {
+		t.Fatal("ShieldsUp set without a policy")
+	}
+
+	// A new policy takes effect on the next change notification.
+	never := "never"
+	msh.stringPolicies[syspolicy.EnableIncomingConnections] = &never
+	b.sysPolicyChanged()
+	if !b.Prefs().ShieldsUp() {
+		t.Error("ShieldsUp not set after the policy changed to never allow incoming connections")
+	}
+}
+
 func TestSetExitNodeIDPolicy(t *testing.T) {
 	pfx := netip.MustParsePrefix
 	tests := []struct {
@@ -1841,6 +2101,58 @@ func TestApplySysPolicy(t *testing.T) {
 				syspolicy.ControlURL: "set",
 			},
 		},
+		{
+			name: "ExitNodeAllowedLANRoutes",
+			prefs: ipn.Prefs{
+				ExitNodeAllowedLANRoutes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			},
+			wantPrefs: ipn.Prefs{
+				ExitNodeAllowedLANRoutes: []netip.Prefix{
+					netip.MustParsePrefix("192.168.10.0/24"),
+					netip.MustParsePrefix("fd00:10::/64"),
+				},
+			},
+			wantAnyChange: true,
+			stringPolicies: map[syspolicy.Key]string{
+				syspolicy.ExitNodeAllowedLANRoutes: "192.168.10.0/24, fd00:10::/64",
+			},
+		},
+		{
+			name: "ExitNodeAllowedLANRoutes matching",
+			prefs: ipn.Prefs{
+				ExitNodeAllowedLANRoutes: []netip.Prefix{netip.MustParsePrefix("192.168.10.0/24")},
+			},
+			wantPrefs: ipn.Prefs{
+				ExitNodeAllowedLANRoutes: []netip.Prefix{netip.MustParsePrefix("192.168.10.0/24")},
+			},
+			stringPolicies: map[syspolicy.Key]string{
+				syspolicy.ExitNodeAllowedLANRoutes: "192.168.10.0/24",
+			},
+		},
+		{
+			name: "ExitNodeAllowedLANRoutes overrides ExitNodeAllowLANAccess",
+			prefs: ipn.Prefs{
+				ExitNodeAllowLANAccess: true,
+			},
+			wantPrefs: ipn.Prefs{
+				ExitNodeAllowedLANRoutes: []netip.Prefix{netip.MustParsePrefix("192.168.10.0/24")},
+			},
+			wantAnyChange: true,
+			stringPolicies: map[syspolicy.Key]string{
+				syspolicy.ExitNodeAllowedLANRoutes: "192.168.10.0/24",
+				syspolicy.ExitNodeAllowLANAccess:   "always",
+			},
+		},
+		{
+			name: "ExitNodeAllowedLANRoutes skips invalid entries",
+			wantPrefs: ipn.Prefs{
+				ExitNodeAllowedLANRoutes: []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")},
+			},
+			wantAnyChange: true,
+			stringPolicies: map[syspolicy.Key]string{
+				syspolicy.ExitNodeAllowedLANRoutes: "bogus,0.0.0.0/0,10.1.2.3/16",
+			},
+		},
 		{
 			name: "enable AutoUpdate apply does not unset check",
 			prefs: ipn.Prefs{
@@ -1934,7 +2246,7 @@ func TestApplySysPolicy(t *testing.T) {
 			t.Run("unit", func(t *testing.T) {
 				prefs := tt.prefs.Clone()
 
-				gotAnyChange := applySysPolicy(prefs)
+				gotAnyChange := applySysPolicy(prefs, nil)
 
 				if gotAnyChange && prefs.Equals(&tt.prefs) {
 					t.Errorf("anyChange but prefs is unchanged: %v", prefs.Pretty())
@@ -2079,8 +2391,9 @@ func TestPreferencePolicyInfo(t *testing.T) {
 				t.Run(string(pp.key), func(t *testing.T) {
 					var h syspolicy.Handler
 
-					allPolicies := make(map[syspolicy.Key]*string, len(preferencePolicies)+1)
+					allPolicies := make(map[syspolicy.Key]*string, len(preferencePolicies)+2)
 					allPolicies[syspolicy.ControlURL] = nil
+					allPolicies[syspolicy.ExitNodeAllowedLANRoutes] = nil
 					for _, pp := range preferencePolicies {
 						allPolicies[pp.key] = nil
 					}
@@ -2106,7 +2419,7 @@ func TestPreferencePolicyInfo(t *testing.T) {
 					prefs := defaultPrefs.AsStruct()
 					pp.set(prefs, tt.initialValue)
 
-					gotAnyChange := applySysPolicy(prefs)
+					gotAnyChange := applySysPolicy(prefs, nil)
 
 					if gotAnyChange != tt.wantChange {
 						t.Errorf("anyChange=%v, want %v", gotAnyChange, tt.wantChange)
@@ -2173,3 +2486,87 @@ func TestOnTailnetDefaultAutoUpdate(t *testing.T) {
 		})
 	}
 }
+
+func TestPrefsProvenance(t *testing.T) {
+	always := "always"
+	userDecides := "user-decides"
+	controlURL := "https://control.example.com"
+	policies := map[syspolicy.Key]*string{
+		syspolicy.ControlURL: &controlURL,
+	}
+	for _, pp := range preferencePolicies {
+		policies[pp.key] = nil
+	}
+	policies[syspolicy.EnableTailscaleDNS] = &always
+	policies[syspolicy.EnableTailscaleSubnets] = &userDecides
+	policies[syspolicy.EnableRunExitNode] = &always
+	syspolicy.SetHandlerForTest(t, &mockSyspolicyHandler{
+		t:              t,
+		stringPolicies: policies,
+	})
+
+	b := newTestBackend(t)
+	if err := b.pm.setPrefsLocked(ipn.NewPrefs().View()); err != nil {
+		t.Fatal(err)
+	}
+	b.onTailnetDefaultAutoUpdate(true)
+
+	got := b.PrefsProvenance()
+	for name, want := range map[string]ipn.PrefSource{
+		"ControlURL":       ipn.PrefSourceSysPolicy,
+		"CorpDNS":          ipn.PrefSourceSysPolicy,
+		"RouteAll":         ipn.PrefSourceUser,
+		"Hostname":         ipn.PrefSourceUser,
+		"AutoUpdate.Apply": ipn.PrefSourceTailnetDefault,
+		"AutoUpdate.Check": ipn.PrefSourceUser,
+
+		"AdvertiseExitNode": ipn.PrefSourceSysPolicy,
+		"AdvertiseRoutes":   ipn.PrefSourceUser,
+	} {
+		if got[name] != want {
+			t.Errorf("source of %s = %q; want %q", name, got[name], want)
+		}
+	}
+	if _, ok := got["Persist"]; ok {
+		t.Errorf("Persist reported as a pref")
+	}
+
+	// Once the user sets it, the pref no longer comes from the tailnet
+	// default.
+	if _, err := b.EditPrefs(&ipn.MaskedPrefs{
+		Prefs:         ipn.Prefs{AutoUpdate: ipn.AutoUpdatePrefs{Apply: opt.NewBool(false)}},
+		AutoUpdateSet: ipn.AutoUpdatePrefsMask{ApplySet: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.PrefsProvenance()["AutoUpdate.Apply"]; got != ipn.PrefSourceUser {
+		t.Errorf("source of AutoUpdate.Apply after EditPrefs = %q; want %q", got, ipn.PrefSourceUser)
+	}
+}
+
+func TestUnapprovedRoutes(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	self := (&tailcfg.Node{
+		AllowedIPs: []netip.Prefix{pfx("100.64.0.1/32"), pfx("10.0.0.0/24")},
+	}).View()
+	tests := []struct {
+		name   string
+		routes []netip.Prefix
+		want   []netip.Prefix
+	}{
+		{"none", nil, nil},
+		{"approved", []netip.Prefix{pfx("10.0.0.0/24")}, nil},
+		{"unapproved", []netip.Prefix{pfx("10.0.0.0/24"), pfx("10.1.0.0/16")}, []netip.Prefix{pfx("10.1.0.0/16")}},
+		{"exit-node", tsaddr.ExitRoutes(), tsaddr.ExitRoutes()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefs := ipn.NewPrefs()
+			prefs.AdvertiseRoutes = tt.routes
+			got := unapprovedRoutes(prefs.View(), self)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unapprovedRoutes = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -754,6 +754,62 @@ func (b *LocalBackend) NetworkLockDisable(secret []byte) error {
 	return err
 }
 
+// maxGeneratedDisablements is the maximum number of disablement secrets that
+// NetworkLockGenerateDisablements generates at once.
+const maxGeneratedDisablements = 32
+
+// NetworkLockGenerateDisablements generates n random disablement secrets. It
+// returns them along with their disablement values, which are what
+// NetworkLockInit needs to initialize the tailnet's key authority.
+func (b *LocalBackend) NetworkLockGenerateDisablements(n int) (secrets, values [][]byte, err error) {
+	if n < 1 || n > maxGeneratedDisablements {
+		return nil, nil, fmt.Errorf("number of disablement secrets must be between 1 and %d", maxGeneratedDisablements)
+	}
+	for i := 0; i < n; i++ {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, nil, err
+		}
+		secrets = append(secrets, secret)
+		values = append(values, tka.DisablementKDF(secret))
+	}
+	return secrets, values, nil
+}
+
+// NetworkLockCheckDisablements reports, for each of the given disablement
+// secrets, whether it disables the tailnet's key authority. This lets the
+// secrets held by several administrators be combined to find one that
+// NetworkLockDisable accepts, without consuming any of them.
+func (b *LocalBackend) NetworkLockCheckDisablements(secrets [][]byte) ([]bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tka == nil {
+		return nil, errNetworkLockNotActive
+	}
+
+	valid := make([]bool, len(secrets))
+	for i, secret := range secrets {
+		valid[i] = b.tka.authority.ValidDisablement(secret)
+	}
+	return valid, nil
+}
+
+// NetworkLockPendingSigs returns the peers which are locked out by network
+// lock, and so are pending a signature of their node-key.
+func (b *LocalBackend) NetworkLockPendingSigs() ([]*ipnstate.TKAFilteredPeer, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tka == nil {
+		return nil, errNetworkLockNotActive
+	}
+
+	pending := make([]*ipnstate.TKAFilteredPeer, len(b.tka.filtered))
+	for i := range b.tka.filtered {
+		pending[i] = b.tka.filtered[i].Clone()
+	}
+	return pending, nil
+}
+
 // NetworkLockLog returns the changelog of TKA state up to maxEntries in size.
 func (b *LocalBackend) NetworkLockLog(maxEntries int) ([]ipnstate.NetworkLockUpdate, error) {
 	b.mu.Lock()
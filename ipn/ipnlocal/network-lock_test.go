@@ -13,6 +13,7 @@
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -590,6 +591,19 @@ func TestTKAFilterNetmap(t *testing.T) {
 	if diff := cmp.Diff(nm.Peers, want, nodePubComparer); diff != "" {
 		t.Errorf("filtered netmap differs (-want, +got):\n%s", diff)
 	}
+
+	pending, err := b.NetworkLockPendingSigs()
+	if err != nil {
+		t.Fatalf("NetworkLockPendingSigs() failed: %v", err)
+	}
+	var pendingKeys []key.NodePublic
+	for _, p := range pending {
+		pendingKeys = append(pendingKeys, p.NodeKey)
+	}
+	wantKeys := []key.NodePublic{n2.Public(), n3.Public(), n4.Public()}
+	if diff := cmp.Diff(pendingKeys, wantKeys, nodePubComparer); diff != "" {
+		t.Errorf("pending signatures differ (-got, +want):\n%s", diff)
+	}
 }
 
 func TestTKADisable(t *testing.T) {
@@ -680,11 +694,43 @@ func TestTKADisable(t *testing.T) {
 	if err := b.NetworkLockDisable([]byte{1, 2, 3, 4}); err == nil || err.Error() != "incorrect disablement secret" {
 		t.Errorf("NetworkLockDisable(<bad secret>).err = %v, want 'incorrect disablement secret'", err)
 	}
+	valid, err := b.NetworkLockCheckDisablements([][]byte{{1, 2, 3, 4}, disablementSecret})
+	if err != nil {
+		t.Fatalf("NetworkLockCheckDisablements() failed: %v", err)
+	}
+	if want := []bool{false, true}; !reflect.DeepEqual(valid, want) {
+		t.Errorf("NetworkLockCheckDisablements() = %v, want %v", valid, want)
+	}
 	if err := b.NetworkLockDisable(disablementSecret); err != nil {
 		t.Errorf("NetworkLockDisable() failed: %v", err)
 	}
 }
 
+func TestTKAGenerateDisablements(t *testing.T) {
+	var b LocalBackend
+	for _, n := range []int{0, maxGeneratedDisablements + 1} {
+		if _, _, err := b.NetworkLockGenerateDisablements(n); err == nil {
+			t.Errorf("NetworkLockGenerateDisablements(%d) succeeded, want error", n)
+		}
+	}
+
+	secrets, values, err := b.NetworkLockGenerateDisablements(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 3 || len(values) != 3 {
+		t.Fatalf("got %d secrets and %d values, want 3 of each", len(secrets), len(values))
+	}
+	for i, secret := range secrets {
+		if !bytes.Equal(tka.DisablementKDF(secret), values[i]) {
+			t.Errorf("values[%d] isn't the disablement value of secrets[%d]", i, i)
+		}
+		if i > 0 && bytes.Equal(secret, secrets[0]) {
+			t.Errorf("secrets[%d] = secrets[0]", i)
+		}
+	}
+}
+
 func TestTKASign(t *testing.T) {
 	nodePriv := key.NewNode()
 	toSign := key.NewNode()
@@ -0,0 +1,110 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/netip"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/types/key"
+	"tailscale.com/wgengine/magicsock"
+)
+
+// pathHintsStateKey is the state store key under which the magicsock path
+// hints are saved, for the next run of the daemon to resume its direct paths
+// to peers.
+const pathHintsStateKey = ipn.StateKey("_path-hints")
+
+// pathHintsSaveInterval is how often the path hints are saved if the paths
+// changed, so that they're not lost if the daemon crashes or is killed
+// before it can save them on shutdown.
+const pathHintsSaveInterval = 5 * time.Minute
+
+// pathHintsSaver tracks the path hints last saved to the state store.
+type pathHintsSaver struct {
+	mu    sync.Mutex
+	saved map[key.NodePublic]netip.AddrPort // paths last saved, or nil
+}
+
+// loadPathHints passes the magicsock path hints saved by the previous run of
+// the daemon, if any, to magicsock.
+func (b *LocalBackend) loadPathHints() {
+	data, err := b.store.ReadState(pathHintsStateKey)
+	if err != nil {
+		if !errors.Is(err, ipn.ErrStateNotExist) {
+			b.logf("reading path hints: %v", err)
+		}
+		return
+	}
+	var hints []magicsock.PathHint
+	if err := json.Unmarshal(data, &hints); err != nil {
+		b.logf("decoding path hints: %v", err)
+		return
+	}
+	b.MagicConn().SetPathHints(hints)
+}
+
+// savePathHintsPeriodically saves the path hints every pathHintsSaveInterval
+// if the paths changed since they were last saved, until b is shut down.
+func (b *LocalBackend) savePathHintsPeriodically() {
+	t, tc := b.clock.NewTicker(pathHintsSaveInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-tc:
+			b.savePathHintsIfChanged()
+		}
+	}
+}
+
+// savePathHints saves magicsock's current path hints to the state store, for
+// the next run of the daemon to load with loadPathHints.
+func (b *LocalBackend) savePathHints() {
+	b.pathHints.mu.Lock()
+	defer b.pathHints.mu.Unlock()
+	b.savePathHintsLocked(b.MagicConn().PathHints())
+}
+
+// savePathHintsIfChanged is like savePathHints, but only saves the path hints
+// if they're for different paths than the ones last saved.
+func (b *LocalBackend) savePathHintsIfChanged() {
+	b.pathHints.mu.Lock()
+	defer b.pathHints.mu.Unlock()
+	hints := b.MagicConn().PathHints()
+	if b.pathHints.saved != nil && maps.Equal(b.pathHints.saved, pathHintAddrs(hints)) {
+		return
+	}
+	b.savePathHintsLocked(hints)
+}
+
+// b.pathHints.mu must be held.
+func (b *LocalBackend) savePathHintsLocked(hints []magicsock.PathHint) {
+	data, err := json.Marshal(hints)
+	if err != nil {
+		b.logf("encoding path hints: %v", err)
+		return
+	}
+	if err := ipn.WriteState(b.store, pathHintsStateKey, data); err != nil {
+		b.logf("saving path hints: %v", err)
+		return
+	}
+	b.pathHints.saved = pathHintAddrs(hints)
+}
+
+// pathHintAddrs returns the direct address of each peer in hints, ignoring
+// when they were last used, which changes on every call to PathHints.
+func pathHintAddrs(hints []magicsock.PathHint) map[key.NodePublic]netip.AddrPort {
+	m := make(map[key.NodePublic]netip.AddrPort, len(hints))
+	for _, h := range hints {
+		m[h.Node] = h.Addr
+	}
+	return m
+}
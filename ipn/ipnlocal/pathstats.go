@@ -0,0 +1,43 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+// PathStats returns the statistics of the paths to each peer.
+func (b *LocalBackend) PathStats() *ipnstate.PathStats {
+	ret := &ipnstate.PathStats{Version: ipnstate.PathStatsVersion}
+	mc, ok := b.sys.MagicSock.GetOK()
+	if !ok {
+		return ret
+	}
+	st := b.Status()
+	now := time.Now()
+	for _, ps := range mc.PathStats() {
+		if peer, ok := st.Peer[ps.NodeKey]; ok {
+			ps.DNSName = peer.DNSName
+			ps.TailscaleIPs = peer.TailscaleIPs
+			if !peer.LastHandshake.IsZero() {
+				ps.LastHandshake = peer.LastHandshake
+				ps.HandshakeAgeSeconds = now.Sub(peer.LastHandshake).Seconds()
+			}
+		}
+		for _, ev := range b.peerHistory.get(ps.NodeKey, now) {
+			if ev.What == "path" {
+				ps.Migrations = append(ps.Migrations, ev)
+			}
+		}
+		ret.Peers = append(ret.Peers, ps)
+	}
+	slices.SortFunc(ret.Peers, func(a, b *ipnstate.PeerPathStats) int {
+		return cmp.Or(cmp.Compare(a.DNSName, b.DNSName), cmp.Compare(a.NodeKey.String(), b.NodeKey.String()))
+	})
+	return ret
+}
@@ -65,6 +65,8 @@ type peerAPIServer struct {
 	resolver peerDNSQueryHandler
 
 	taildrop *taildrop.Manager
+
+	benchMu sync.Mutex // held while serving a bench; see handleServeBench
 }
 
 func (s *peerAPIServer) listen(ip netip.Addr, ifState *interfaces.State) (ln net.Listener, err error) {
@@ -358,6 +360,9 @@ func (h *peerAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		metricIngressCalls.Add(1)
 		h.handleServeIngress(w, r)
 		return
+	case "/v0/bench":
+		h.handleServeBench(w, r)
+		return
 	}
 	who := h.peerUser.DisplayName
 	fmt.Fprintf(w, `<html>
@@ -1132,8 +1137,14 @@ func (h *peerAPIHandler) handleServeTailFS(w http.ResponseWriter, r *http.Reques
 		http.Error(w, "tailfs not enabled", http.StatusNotFound)
 		return
 	}
+	principal := tailfs.Principal{
+		NodeID: string(h.peerNode.StableID()),
+		Tags:   h.peerNode.Tags().AsSlice(),
+		Name:   h.peerNode.DisplayName(false),
+		User:   h.peerUser.LoginName,
+	}
 	r.URL.Path = strings.TrimPrefix(r.URL.Path, tailFSPrefix)
-	fs.ServeHTTPWithPerms(p, w, r)
+	fs.ServeHTTPWithPerms(principal, p, w, r)
 }
 
 // newFakePeerAPIListener creates a new net.Listener that acts like
@@ -1190,4 +1201,5 @@ func (fl *fakePeerAPIListener) Addr() net.Addr { return fl.addr }
 	metricDNSCalls       = clientmetric.NewCounter("peerapi_dns")
 	metricWakeOnLANCalls = clientmetric.NewCounter("peerapi_wol")
 	metricIngressCalls   = clientmetric.NewCounter("peerapi_ingress")
+	metricBenchCalls     = clientmetric.NewCounter("peerapi_bench")
 )
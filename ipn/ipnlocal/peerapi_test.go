@@ -513,6 +513,40 @@ func(t *testing.T, env *peerAPITestEnv) {
 				},
 			),
 		},
+		{
+			name:   "bench/deny-nonself",
+			isSelf: false,
+			reqs:   []*http.Request{httptest.NewRequest("GET", "/v0/bench?duration=10ms", nil)},
+			checks: checks(httpStatus(http.StatusForbidden)),
+		},
+		{
+			name:   "bench/bad-duration",
+			isSelf: true,
+			reqs:   []*http.Request{httptest.NewRequest("GET", "/v0/bench?duration=1h", nil)},
+			checks: checks(httpStatus(http.StatusBadRequest)),
+		},
+		{
+			name:   "bench/download",
+			isSelf: true,
+			reqs:   []*http.Request{httptest.NewRequest("GET", "/v0/bench?duration=10ms", nil)},
+			checks: checks(
+				httpStatus(200),
+				func(t *testing.T, env *peerAPITestEnv) {
+					if env.rr.Body.Len() == 0 {
+						t.Error("no bench data")
+					}
+				},
+			),
+		},
+		{
+			name:   "bench/upload",
+			isSelf: true,
+			reqs:   []*http.Request{httptest.NewRequest("POST", "/v0/bench", strings.NewReader("fizzbuzz"))},
+			checks: checks(
+				httpStatus(200),
+				bodyContains(`"Bytes":8`),
+			),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
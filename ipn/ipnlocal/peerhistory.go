@@ -0,0 +1,89 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/ringbuffer"
+)
+
+// peerHistoryWindow is how far back the connectivity history kept for each
+// peer goes.
+const peerHistoryWindow = time.Hour
+
+// peerHistoryEntries is the maximum number of transitions kept per peer, to
+// bound memory use for peers whose connection flaps a lot.
+const peerHistoryEntries = 128
+
+// peerHistory is an in-memory record of the recent connectivity transitions
+// of each peer, as reported by the engine. It is safe for concurrent use.
+type peerHistory struct {
+	mu sync.Mutex
+	m  map[key.NodePublic]*peerHistoryEntry
+}
+
+type peerHistoryEntry struct {
+	events   *ringbuffer.RingBuffer[ipnstate.PeerConnEvent]
+	lastSeen time.Time // of the most recent event; guarded by peerHistory.mu
+}
+
+// add records ev for the peer with node key nk. It's registered as the
+// engine's PeerConnEventCallback, so it must not block.
+func (h *peerHistory) add(nk key.NodePublic, ev ipnstate.PeerConnEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, ok := h.m[nk]
+	if !ok {
+		// Forget peers that haven't had any transitions within the window
+		// so the map doesn't grow with every peer ever seen.
+		for k, e := range h.m {
+			if ev.When.Sub(e.lastSeen) > peerHistoryWindow {
+				delete(h.m, k)
+			}
+		}
+		e = &peerHistoryEntry{events: ringbuffer.New[ipnstate.PeerConnEvent](peerHistoryEntries)}
+		mak.Set(&h.m, nk, e)
+	}
+	e.lastSeen = ev.When
+	e.events.Add(ev)
+}
+
+// get returns the transitions recorded for the peer with node key nk that
+// happened within peerHistoryWindow before now, oldest first.
+func (h *peerHistory) get(nk key.NodePublic, now time.Time) []ipnstate.PeerConnEvent {
+	h.mu.Lock()
+	e, ok := h.m[nk]
+	h.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	var ret []ipnstate.PeerConnEvent
+	for _, ev := range e.events.GetAll() {
+		if now.Sub(ev.When) <= peerHistoryWindow {
+			ret = append(ret, ev)
+		}
+	}
+	return ret
+}
+
+// PeerConnHistory returns the connectivity transitions of the peer with
+// Tailscale IP ip over the last hour, oldest first.
+func (b *LocalBackend) PeerConnHistory(ctx context.Context, ip netip.Addr) ([]ipnstate.PeerConnEvent, error) {
+	pip, ok := b.e.PeerForIP(ip)
+	if !ok {
+		return nil, fmt.Errorf("no matching peer")
+	}
+	if pip.IsSelf {
+		return nil, fmt.Errorf("%v is local Tailscale IP", ip)
+	}
+	return b.peerHistory.get(pip.Node.Key(), time.Now()), nil
+}
@@ -0,0 +1,49 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+)
+
+func TestPeerHistory(t *testing.T) {
+	var h peerHistory
+	k1 := key.NewNode().Public()
+	k2 := key.NewNode().Public()
+	now := time.Now()
+
+	h.add(k1, ipnstate.PeerConnEvent{When: now.Add(-2 * time.Hour), What: "path", From: "derp", To: "udp:1.2.3.4:41641"})
+	h.add(k1, ipnstate.PeerConnEvent{When: now.Add(-time.Minute), What: "path", From: "udp:1.2.3.4:41641", To: "derp"})
+	h.add(k1, ipnstate.PeerConnEvent{When: now, What: "handshake-timeout", To: "100.64.0.2:22"})
+
+	got := h.get(k1, now)
+	if len(got) != 2 {
+		t.Fatalf("got %d events, want 2 (older than window dropped): %+v", len(got), got)
+	}
+	if got[0].What != "path" || got[1].What != "handshake-timeout" {
+		t.Errorf("events out of order: %+v", got)
+	}
+	if got := h.get(k2, now); len(got) != 0 {
+		t.Errorf("unknown peer has history: %+v", got)
+	}
+
+	for i := 0; i < peerHistoryEntries+10; i++ {
+		h.add(k2, ipnstate.PeerConnEvent{When: now.Add(time.Duration(i) * time.Millisecond), What: "derp-home"})
+	}
+	if got := h.get(k2, now.Add(time.Second)); len(got) != peerHistoryEntries {
+		t.Errorf("got %d events, want at most %d", len(got), peerHistoryEntries)
+	}
+
+	// A new peer showing up forgets peers that have been quiet for longer
+	// than the window.
+	k3 := key.NewNode().Public()
+	h.add(k3, ipnstate.PeerConnEvent{When: now.Add(2 * time.Hour), What: "path"})
+	if _, ok := h.m[k1]; ok {
+		t.Errorf("quiet peer's history was not forgotten")
+	}
+}
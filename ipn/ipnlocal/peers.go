@@ -0,0 +1,221 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"reflect"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+	"tailscale.com/util/mak"
+)
+
+// peerActiveWithin is how recently a peer must have completed a WireGuard
+// handshake for its flows to be considered active. WireGuard rekeys active
+// sessions every two minutes, so this leaves a little slack.
+const peerActiveWithin = 3 * time.Minute
+
+// peerStore is the set of current peers of a LocalBackend, as of the last
+// full netmap plus the delta node mutations received since.
+//
+// On large tailnets, control sends a steady stream of delta mutations (mostly
+// peers coming online, going offline, and changing endpoints or DERP home).
+// Applying each one as it arrives used to mean cloning the whole
+// tailcfg.Node, most of which (Hostinfo, capabilities, addresses) never
+// changes, even for peers nothing looks at before their next mutation.
+// Instead, peerStore records the mutations for each peer, coalesced so that
+// only the latest mutation of each kind is kept, and only applies them, to
+// a clone of the peer's node, when the peer is looked up, ranged over or has
+// active flows. The clone then replaces the peer's node, so each mutation is
+// applied at most once, and handling a delta followed by a call to Range
+// only clones the peers that the delta changed.
+//
+// The zero value is an empty store ready for use. It is not safe for
+// concurrent use; in LocalBackend it's guarded by LocalBackend.mu.
+type peerStore struct {
+	// nodes are the current peers, with all but the mutations in muts
+	// applied. The values of peers that haven't been mutated since the
+	// last full netmap are shared with netmap.NetworkMap.Peers.
+	nodes map[tailcfg.NodeID]tailcfg.NodeView
+	// fromNetmap are the peers as of the last full netmap, to tell which
+	// peers the next one changes.
+	fromNetmap map[tailcfg.NodeID]tailcfg.NodeView
+	// byKey maps each peer's node key to its ID.
+	byKey map[key.NodePublic]tailcfg.NodeID
+	// muts are the delta mutations not yet applied to nodes, keyed by the
+	// ID of the mutated peer. At most one mutation of each type is kept
+	// per peer.
+	muts map[tailcfg.NodeID][]netmap.NodeMutation
+}
+
+// Len reports the number of peers.
+func (s *peerStore) Len() int {
+	return len(s.nodes)
+}
+
+// setPeers replaces the set of peers with peers, typically those of a new
+// full netmap.
+//
+// The update is incremental: a peer whose view is unchanged from the
+// previous call keeps its mutations, and only added,
+// changed and removed peers are otherwise touched.
+func (s *peerStore) setPeers(peers []tailcfg.NodeView) {
+	if len(peers) == 0 {
+		*s = peerStore{}
+		return
+	}
+	seen := make(map[tailcfg.NodeID]bool, len(peers))
+	for _, p := range peers {
+		id := p.ID()
+		seen[id] = true
+		old, ok := s.fromNetmap[id]
+		if ok && old == p {
+			continue
+		}
+		if ok && old.Key() != p.Key() {
+			delete(s.byKey, old.Key())
+		}
+		mak.Set(&s.fromNetmap, id, p)
+		mak.Set(&s.nodes, id, p)
+		mak.Set(&s.byKey, p.Key(), id)
+		// A full netmap's nodes already reflect every mutation sent
+		// before it.
+		delete(s.muts, id)
+	}
+	if len(seen) == len(s.fromNetmap) {
+		return
+	}
+	for id, p := range s.fromNetmap {
+		if !seen[id] {
+			s.remove(id, p)
+		}
+	}
+}
+
+func (s *peerStore) remove(id tailcfg.NodeID, p tailcfg.NodeView) {
+	delete(s.nodes, id)
+	delete(s.fromNetmap, id)
+	if s.byKey[p.Key()] == id {
+		delete(s.byKey, p.Key())
+	}
+	delete(s.muts, id)
+}
+
+// mutate records muts. It reports false, without recording any of them, if
+// any of muts is for an unknown peer.
+func (s *peerStore) mutate(muts []netmap.NodeMutation) (ok bool) {
+	for _, m := range muts {
+		if _, ok := s.nodes[m.NodeIDBeingMutated()]; !ok {
+			return false
+		}
+	}
+	for _, m := range muts {
+		id := m.NodeIDBeingMutated()
+		mak.Set(&s.muts, id, coalesceMutation(s.muts[id], m))
+	}
+	return true
+}
+
+// coalesceMutation returns pending with m added, replacing any earlier
+// mutation of the same type. Each type of mutation sets a different field,
+// so the order in which they're applied doesn't matter.
+func coalesceMutation(pending []netmap.NodeMutation, m netmap.NodeMutation) []netmap.NodeMutation {
+	t := reflect.TypeOf(m)
+	for i, old := range pending {
+		if reflect.TypeOf(old) == t {
+			pending[i] = m
+			return pending
+		}
+	}
+	return append(pending, m)
+}
+
+// fold applies the pending mutations of peer id, which must exist, to a
+// clone of its node, which replaces it in s.nodes, and returns its current
+// view.
+func (s *peerStore) fold(id tailcfg.NodeID) tailcfg.NodeView {
+	nv := s.nodes[id]
+	muts, ok := s.muts[id]
+	if !ok {
+		return nv
+	}
+	n := nv.AsStruct()
+	for _, m := range muts {
+		m.Apply(n)
+	}
+	nv = n.View()
+	s.nodes[id] = nv
+	delete(s.muts, id)
+	return nv
+}
+
+// get returns the current view of peer id, if it exists.
+func (s *peerStore) get(id tailcfg.NodeID) (_ tailcfg.NodeView, ok bool) {
+	if _, ok := s.nodes[id]; !ok {
+		return tailcfg.NodeView{}, false
+	}
+	return s.fold(id), true
+}
+
+// getByKey is like get, but looks up the peer by its node key.
+func (s *peerStore) getByKey(k key.NodePublic) (_ tailcfg.NodeView, ok bool) {
+	id, ok := s.byKey[k]
+	if !ok {
+		return tailcfg.NodeView{}, false
+	}
+	return s.get(id)
+}
+
+// Range calls fn with the current view of each peer, in undefined order,
+// until fn returns false.
+func (s *peerStore) Range(fn func(tailcfg.NodeView) bool) {
+	for id, nv := range s.nodes {
+		if _, ok := s.muts[id]; ok {
+			nv = s.fold(id)
+		}
+		if !fn(nv) {
+			return
+		}
+	}
+}
+
+// all returns the current views of all peers, in undefined order.
+func (s *peerStore) all() []tailcfg.NodeView {
+	ret := make([]tailcfg.NodeView, 0, s.Len())
+	s.Range(func(nv tailcfg.NodeView) bool {
+		ret = append(ret, nv)
+		return true
+	})
+	return ret
+}
+
+// static returns the peers without their pending mutations applied. It's
+// cheaper than Range for callers that only use fields that delta mutations
+// never change; that is, anything but DERP, Endpoints, Online and LastSeen.
+//
+// The returned map must not be modified or retained past the next call to a
+// method that updates s.
+func (s *peerStore) static() map[tailcfg.NodeID]tailcfg.NodeView {
+	return s.nodes
+}
+
+// setActive applies the pending mutations of the peers with active flows
+// according to the WireGuard peer status in peers, as of now, so that
+// looking them up on the data path doesn't have to.
+func (s *peerStore) setActive(now time.Time, peers []ipnstate.PeerStatusLite) {
+	if len(s.muts) == 0 {
+		return
+	}
+	for _, ps := range peers {
+		if ps.LastHandshake.IsZero() || now.Sub(ps.LastHandshake) > peerActiveWithin {
+			continue
+		}
+		if id, ok := s.byKey[ps.NodeKey]; ok {
+			s.fold(id)
+		}
+	}
+}
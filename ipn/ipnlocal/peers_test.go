@@ -0,0 +1,292 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+)
+
+func makePeers(n int) []tailcfg.NodeView {
+	peers := make([]tailcfg.NodeView, n)
+	for i := range peers {
+		id := tailcfg.NodeID(i + 1)
+		peers[i] = (&tailcfg.Node{
+			ID:       id,
+			StableID: tailcfg.StableNodeID(fmt.Sprintf("stable%d", id)),
+			Name:     fmt.Sprintf("peer%d.example.ts.net.", id),
+			Key:      key.NewNode().Public(),
+			Addresses: []netip.Prefix{
+				netip.PrefixFrom(netip.AddrFrom4([4]byte{100, 64, byte(i >> 8), byte(i)}), 32),
+			},
+			Hostinfo: (&tailcfg.Hostinfo{Hostname: fmt.Sprintf("peer%d", id), OS: "linux"}).View(),
+		}).View()
+	}
+	return peers
+}
+
+func TestPeerStore(t *testing.T) {
+	var s peerStore
+	peers := makePeers(3)
+	s.setPeers(peers)
+	if s.Len() != 3 {
+		t.Fatalf("Len = %d; want 3", s.Len())
+	}
+
+	// NodeMutations can't be constructed directly outside package netmap,
+	// as their node ID field is unexported.
+	unknown, _ := netmap.NodeMutationsFromPatch(&tailcfg.PeerChange{NodeID: 99, DERPRegion: 1})
+	if s.mutate(unknown) {
+		t.Fatal("mutate of unknown peer succeeded")
+	}
+
+	online := true
+	ep := []netip.AddrPort{netip.MustParseAddrPort("192.0.2.1:41641")}
+	m1, ok := netmap.NodeMutationsFromPatch(&tailcfg.PeerChange{NodeID: 2, Online: &online, Endpoints: ep})
+	if !ok {
+		t.Fatal("NodeMutationsFromPatch failed")
+	}
+	if !s.mutate(m1) {
+		t.Fatal("mutate failed")
+	}
+	offline := false
+	m2, _ := netmap.NodeMutationsFromPatch(&tailcfg.PeerChange{NodeID: 2, Online: &offline})
+	if !s.mutate(m2) {
+		t.Fatal("mutate failed")
+	}
+	if got := len(s.muts[2]); got != 2 {
+		t.Errorf("pending mutations = %d; want 2 after coalescing", got)
+	}
+	if s.static()[2] != peers[1] {
+		t.Error("static view changed by mutation")
+	}
+
+	nv, ok := s.get(2)
+	if !ok {
+		t.Fatal("get(2) failed")
+	}
+	if o := nv.Online(); o == nil || *o {
+		t.Errorf("Online = %v; want false", o)
+	}
+	if nv.Endpoints().Len() != 1 || nv.Endpoints().At(0) != ep[0] {
+		t.Errorf("Endpoints = %v; want %v", nv.Endpoints(), ep)
+	}
+	if _, ok := s.muts[2]; ok {
+		t.Error("looked up peer still has pending mutations")
+	}
+	if got, _ := s.get(2); got != nv {
+		t.Error("second get returned a different view; mutations applied twice")
+	}
+	if got, _ := s.getByKey(peers[1].Key()); got != nv {
+		t.Error("getByKey returned a different view than get")
+	}
+
+	// Further mutations are applied to the view that already has the
+	// earlier ones.
+	m3, _ := netmap.NodeMutationsFromPatch(&tailcfg.PeerChange{NodeID: 2, DERPRegion: 7})
+	if !s.mutate(m3) {
+		t.Fatal("mutate failed")
+	}
+	if nv, _ := s.get(2); nv.DERP() != "127.3.3.40:7" || nv.Online() == nil || *nv.Online() {
+		t.Errorf("get(2) = %v; want DERP region 7 and offline", nv)
+	}
+
+	// Ranging over peers applies pending mutations once, too.
+	m4, _ := netmap.NodeMutationsFromPatch(&tailcfg.PeerChange{NodeID: 3, DERPRegion: 7})
+	if !s.mutate(m4) {
+		t.Fatal("mutate failed")
+	}
+	var nv3 tailcfg.NodeView
+	for _, nv := range s.all() {
+		if nv.ID() == 3 {
+			nv3 = nv
+		}
+	}
+	if nv3.DERP() != "127.3.3.40:7" {
+		t.Errorf("DERP of peer 3 = %q; want region 7", nv3.DERP())
+	}
+	if len(s.muts) != 0 {
+		t.Errorf("muts = %v after Range; want none", s.muts)
+	}
+	s.Range(func(nv tailcfg.NodeView) bool {
+		if nv.ID() == 3 && nv != nv3 {
+			t.Error("second Range returned a different view; mutations applied twice")
+		}
+		return true
+	})
+
+	// Peers with active flows have their mutations applied; idle ones
+	// don't.
+	m5, _ := netmap.NodeMutationsFromPatch(&tailcfg.PeerChange{NodeID: 2, DERPRegion: 8})
+	m6, _ := netmap.NodeMutationsFromPatch(&tailcfg.PeerChange{NodeID: 3, DERPRegion: 8})
+	if !s.mutate(append(m5, m6...)) {
+		t.Fatal("mutate failed")
+	}
+	now := time.Now()
+	s.setActive(now, []ipnstate.PeerStatusLite{
+		{NodeKey: peers[1].Key(), LastHandshake: now.Add(-time.Minute)},
+		{NodeKey: peers[2].Key(), LastHandshake: now.Add(-time.Hour)},
+	})
+	if _, ok := s.muts[2]; ok {
+		t.Error("active peer still has pending mutations")
+	}
+	if _, ok := s.muts[3]; !ok {
+		t.Error("idle peer's mutations were applied")
+	}
+	if nv, _ := s.get(3); nv.DERP() != "127.3.3.40:8" {
+		t.Errorf("DERP of peer 3 = %q; want region 8", nv.DERP())
+	}
+
+	// An unchanged peer keeps its mutations across a new full netmap;
+	// changed and removed ones don't.
+	changed := peers[0].AsStruct()
+	changed.Name = "renamed.example.ts.net."
+	s.setPeers([]tailcfg.NodeView{changed.View(), peers[1]})
+	if s.Len() != 2 {
+		t.Fatalf("Len = %d; want 2", s.Len())
+	}
+	if nv, _ := s.get(2); nv.Online() == nil || *nv.Online() {
+		t.Error("unchanged peer lost its mutations")
+	}
+	if nv, _ := s.get(1); nv.Name() != changed.Name {
+		t.Errorf("Name = %q; want %q", nv.Name(), changed.Name)
+	}
+	if _, ok := s.getByKey(peers[2].Key()); ok {
+		t.Error("removed peer still found by key")
+	}
+
+	s.setPeers(nil)
+	if s.Len() != 0 {
+		t.Errorf("Len = %d; want 0", s.Len())
+	}
+}
+
+func benchmarkPeerStoreMutate(b *testing.B, numPeers int) {
+	peers := makePeers(numPeers)
+	var s peerStore
+	s.setPeers(peers)
+	muts := make([][]netmap.NodeMutation, numPeers)
+	for i, p := range peers {
+		online := i%2 == 0
+		muts[i], _ = netmap.NodeMutationsFromPatch(&tailcfg.PeerChange{
+			NodeID:   p.ID(),
+			Online:   &online,
+			LastSeen: &time.Time{},
+		})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range b.N {
+		if !s.mutate(muts[i%numPeers]) {
+			b.Fatal("mutate failed")
+		}
+	}
+}
+
+func BenchmarkPeerStoreMutate(b *testing.B) {
+	for _, n := range []int{100, 10_000} {
+		b.Run(fmt.Sprintf("peers=%d", n), func(b *testing.B) {
+			benchmarkPeerStoreMutate(b, n)
+		})
+	}
+}
+
+// deltaAllocs returns the average number of allocations of handling a delta
+// mutating numMuts peers followed by a call to all, as LocalBackend does for
+// the deltas it tells the IPN bus about, in a store of numPeers peers.
+func deltaAllocs(numPeers, numMuts int) float64 {
+	peers := makePeers(numPeers)
+	var s peerStore
+	s.setPeers(peers)
+	var muts []netmap.NodeMutation
+	for i := range numMuts {
+		online := true
+		m, _ := netmap.NodeMutationsFromPatch(&tailcfg.PeerChange{NodeID: peers[i].ID(), Online: &online})
+		muts = append(muts, m...)
+	}
+	return testing.AllocsPerRun(100, func() {
+		s.mutate(muts)
+		s.all()
+	})
+}
+
+func TestPeerStoreDeltaAllocs(t *testing.T) {
+	// Handling a delta only clones the peers it changed, however many
+	// other peers there are.
+	for _, m := range []int{1, 10} {
+		small, large := deltaAllocs(m, m), deltaAllocs(10_000, m)
+		if large != small {
+			t.Errorf("delta mutating %d peers: %v allocs with 10000 peers; want %v, as with %d peers", m, large, small, m)
+		}
+	}
+}
+
+// BenchmarkPeerStoreMutateAll measures handling a delta mutating a few peers
+// followed by a call to all, as LocalBackend does for the deltas it tells the
+// IPN bus about.
+func BenchmarkPeerStoreMutateAll(b *testing.B) {
+	for _, n := range []int{100, 10_000} {
+		for _, m := range []int{1, 10} {
+			b.Run(fmt.Sprintf("peers=%d/muts=%d", n, m), func(b *testing.B) {
+				// The work per delta is O(changed peers), plus
+				// copying the views of all peers.
+				if got, want := deltaAllocs(n, m), deltaAllocs(m, m); got != want {
+					b.Fatalf("%v allocs per delta; want %v, as with %d peers", got, want, m)
+				}
+				peers := makePeers(n)
+				var s peerStore
+				s.setPeers(peers)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := range b.N {
+					for j := range m {
+						online := i%2 == 0
+						muts, _ := netmap.NodeMutationsFromPatch(&tailcfg.PeerChange{
+							NodeID: peers[(i*m+j)%n].ID(),
+							Online: &online,
+						})
+						if !s.mutate(muts) {
+							b.Fatal("mutate failed")
+						}
+					}
+					if len(s.all()) != n {
+						b.Fatal("wrong number of peers")
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkPeerStoreSetPeers(b *testing.B) {
+	for _, n := range []int{100, 10_000} {
+		b.Run(fmt.Sprintf("peers=%d", n), func(b *testing.B) {
+			peers := makePeers(n)
+			next := make([]tailcfg.NodeView, n)
+			copy(next, peers)
+			// Each new netmap changes one peer.
+			changed := peers[0].AsStruct()
+			changed.Name = "changed.example.ts.net."
+			next[0] = changed.View()
+
+			var s peerStore
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := range b.N {
+				if i%2 == 0 {
+					s.setPeers(peers)
+				} else {
+					s.setPeers(next)
+				}
+			}
+		})
+	}
+}
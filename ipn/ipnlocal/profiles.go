@@ -314,6 +314,31 @@ func (pm *profileManager) Profiles() []ipn.LoginProfile {
 	return out
 }
 
+// AllProfiles returns the profiles of all local users, sorted by the ID of
+// the user that owns them and then by Name. Unlike Profiles, it includes
+// profiles that don't belong to the current user, such as the profile of a
+// user who left tailscaled running in unattended mode on Windows.
+func (pm *profileManager) AllProfiles() []ipn.LoginProfile {
+	out := make([]ipn.LoginProfile, 0, len(pm.knownProfiles))
+	for _, p := range pm.knownProfiles {
+		out = append(out, *p)
+	}
+	slices.SortFunc(out, func(a, b ipn.LoginProfile) int {
+		return cmp.Or(cmp.Compare(a.LocalUserID, b.LocalUserID), cmp.Compare(a.Name, b.Name))
+	})
+	return out
+}
+
+// checkProfileOwner returns an error if kp belongs to a local user other than
+// the current one. Profiles of other users must not be switched to or
+// modified by whoever is currently connected.
+func (pm *profileManager) checkProfileOwner(kp *ipn.LoginProfile) error {
+	if kp.LocalUserID != pm.currentUserID {
+		return fmt.Errorf("profile %q is not owned by current user", kp.ID)
+	}
+	return nil
+}
+
 // SwitchProfile switches to the profile with the given id.
 // If the profile is not known, it returns an errProfileNotFound.
 func (pm *profileManager) SwitchProfile(id ipn.ProfileID) error {
@@ -327,8 +352,8 @@ func (pm *profileManager) SwitchProfile(id ipn.ProfileID) error {
 	if pm.currentProfile != nil && kp.ID == pm.currentProfile.ID && pm.prefs.Valid() {
 		return nil
 	}
-	if kp.LocalUserID != pm.currentUserID {
-		return fmt.Errorf("profile %q is not owned by current user", id)
+	if err := pm.checkProfileOwner(kp); err != nil {
+		return err
 	}
 	prefs, err := pm.loadSavedPrefs(kp.Key)
 	if err != nil {
@@ -396,6 +421,9 @@ func (pm *profileManager) DeleteProfile(id ipn.ProfileID) error {
 	if !ok {
 		return errProfileNotFound
 	}
+	if err := pm.checkProfileOwner(kp); err != nil {
+		return err
+	}
 	if kp.ID == pm.currentProfile.ID {
 		pm.NewProfile()
 	}
@@ -6,6 +6,7 @@
 import (
 	"fmt"
 	"os/user"
+	"slices"
 	"strconv"
 	"testing"
 
@@ -136,6 +137,21 @@ func TestProfileList(t *testing.T) {
 
 	pm.SetCurrentUserID("user2")
 	checkProfiles(t, "carol")
+
+	pm.SetCurrentUserID("user1")
+	if err := pm.SwitchProfile(carol.ID); err == nil {
+		t.Errorf("switched to profile owned by user2 as user1")
+	}
+	if err := pm.DeleteProfile(carol.ID); err == nil {
+		t.Errorf("deleted profile owned by user2 as user1")
+	}
+	var all []string
+	for _, p := range pm.AllProfiles() {
+		all = append(all, string(p.LocalUserID)+"/"+p.Name)
+	}
+	if want := []string{"user1/alice", "user1/bob", "user2/carol"}; !slices.Equal(all, want) {
+		t.Errorf("AllProfiles = %q, want %q", all, want)
+	}
 }
 
 func TestProfileDupe(t *testing.T) {
@@ -252,9 +252,15 @@ func (b *LocalBackend) setServeConfigLocked(config *ipn.ServeConfig, etag string
 	if config.IsFunnelOn() && prefs.ShieldsUp() {
 		return errors.New("Unable to turn on Funnel while shields-up is enabled")
 	}
+	if err := config.CheckFunnelSchedule(); err != nil {
+		return err
+	}
 	if b.isConfigLocked_Locked() {
 		return errors.New("can't reconfigure tailscaled when using a config file; config file is locked")
 	}
+	if b.confServeConfigLocked() != nil {
+		return errors.New("can't change the serve config when it's set by the config file")
+	}
 
 	nm := b.netMap
 	if nm == nil {
@@ -355,6 +361,7 @@ func (b *LocalBackend) DeleteForegroundSession(sessionID string) error {
 func (b *LocalBackend) HandleIngressTCPConn(ingressPeer tailcfg.NodeView, target ipn.HostPort, srcAddr netip.AddrPort, getConnOrReset func() (net.Conn, bool), sendRST func()) {
 	b.mu.Lock()
 	sc := b.serveConfig
+	usageReports := b.pm.CurrentPrefs().UsageReports()
 	b.mu.Unlock()
 
 	// TODO(maisem,bradfitz): make this not alloc for every conn.
@@ -371,6 +378,11 @@ func (b *LocalBackend) HandleIngressTCPConn(ingressPeer tailcfg.NodeView, target
 		sendRST()
 		return
 	}
+	if !sc.FunnelOpenForTarget(target, b.clock.Now()) {
+		logf("got ingress conn for %q outside of its funnel schedule; rejecting", target)
+		sendRST()
+		return
+	}
 
 	_, port, err := net.SplitHostPort(string(target))
 	if err != nil {
@@ -385,6 +397,9 @@ func (b *LocalBackend) HandleIngressTCPConn(ingressPeer tailcfg.NodeView, target
 		return
 	}
 	dport := uint16(port16)
+	if usageReports {
+		b.usage.noteFunnelConn(b.clock.Now())
+	}
 	if b.getTCPHandlerForFunnelFlow != nil {
 		handler := b.getTCPHandlerForFunnelFlow(srcAddr, dport)
 		if handler != nil {
@@ -418,6 +433,7 @@ func (b *LocalBackend) HandleIngressTCPConn(ingressPeer tailcfg.NodeView, target
 func (b *LocalBackend) tcpHandlerForServe(dport uint16, srcAddr netip.AddrPort) (handler func(net.Conn) error) {
 	b.mu.Lock()
 	sc := b.serveConfig
+	usageReports := b.pm.CurrentPrefs().UsageReports()
 	b.mu.Unlock()
 
 	if !sc.Valid() {
@@ -428,6 +444,9 @@ func (b *LocalBackend) tcpHandlerForServe(dport uint16, srcAddr netip.AddrPort)
 	if !ok {
 		return nil
 	}
+	if usageReports {
+		b.usage.noteServeConn(b.clock.Now(), dport)
+	}
 
 	if tcph.HTTPS() || tcph.HTTP() {
 		hs := &http.Server{
@@ -454,10 +473,16 @@ func (b *LocalBackend) tcpHandlerForServe(dport uint16, srcAddr netip.AddrPort)
 	}
 
 	if backDst := tcph.TCPForward(); backDst != "" {
+		if !b.servePermits(tcph.Allow(), srcAddr) {
+			return func(conn net.Conn) error {
+				b.logf("localbackend: denied TCP proxy of port %v from %v: not in allow list", dport, srcAddr)
+				return conn.Close()
+			}
+		}
 		return func(conn net.Conn) error {
 			defer conn.Close()
 			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			backConn, err := b.dialer.SystemDial(ctx, "tcp", backDst)
+			backConn, err := b.dialServeBackend(ctx, backDst)
 			cancel()
 			if err != nil {
 				b.logf("localbackend: failed to TCP proxy port %v (from %v) to %s: %v", dport, srcAddr, backDst, err)
@@ -500,22 +525,49 @@ func (b *LocalBackend) tcpHandlerForServe(dport uint16, srcAddr netip.AddrPort)
 	return nil
 }
 
-func (b *LocalBackend) getServeHandler(r *http.Request) (_ ipn.HTTPHandlerView, at string, ok bool) {
-	var z ipn.HTTPHandlerView // zero value
+// dialServeBackend dials target, a TCPPortHandler.TCPForward value, which
+// is either an IP:port or a local socket.
+func (b *LocalBackend) dialServeBackend(ctx context.Context, target string) (net.Conn, error) {
+	if network, path, ok := ipn.ParseProxySocket(target); ok {
+		return dialProxySocket(ctx, network, path)
+	}
+	return b.dialer.SystemDial(ctx, "tcp", target)
+}
+
+// dialProxySocket dials the local socket at path, as returned by
+// ipn.ParseProxySocket.
+func dialProxySocket(ctx context.Context, network, path string) (net.Conn, error) {
+	switch network {
+	case "unix":
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", path)
+	case "npipe":
+		return dialNamedPipe(ctx, path)
+	}
+	return nil, fmt.Errorf("unsupported socket network %q", network)
+}
 
+// serveHostname returns the hostname of the serve web server that r was
+// sent to.
+func (b *LocalBackend) serveHostname(r *http.Request) string {
+	if r.TLS != nil {
+		return r.TLS.ServerName
+	}
 	hostname := r.Host
-	if r.TLS == nil {
-		tcd := "." + b.Status().CurrentTailnet.MagicDNSSuffix
-		if host, _, err := net.SplitHostPort(hostname); err == nil {
-			hostname = host
-		}
-		if !strings.HasSuffix(hostname, tcd) {
-			hostname += tcd
-		}
-	} else {
-		hostname = r.TLS.ServerName
+	tcd := "." + b.Status().CurrentTailnet.MagicDNSSuffix
+	if host, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = host
+	}
+	if !strings.HasSuffix(hostname, tcd) {
+		hostname += tcd
 	}
+	return hostname
+}
 
+func (b *LocalBackend) getServeHandler(r *http.Request) (_ ipn.HTTPHandlerView, at string, ok bool) {
+	var z ipn.HTTPHandlerView // zero value
+
+	hostname := b.serveHostname(r)
 	sctx, ok := serveHTTPContextKey.ValueOk(r.Context())
 	if !ok {
 		b.logf("[unexpected] localbackend: no serveHTTPContext in request")
@@ -548,6 +600,16 @@ func (b *LocalBackend) getServeHandler(r *http.Request) (_ ipn.HTTPHandlerView,
 // proxyHandlerForBackend creates a new HTTP reverse proxy for a particular backend that
 // we serve requests for. `backend` is a HTTPHandler.Proxy string (url, hostport or just port).
 func (b *LocalBackend) proxyHandlerForBackend(backend string) (http.Handler, error) {
+	if network, path, ok := ipn.ParseProxySocket(backend); ok {
+		return &reverseProxy{
+			logf:          b.logf,
+			url:           &url.URL{Scheme: "http", Host: "localhost"},
+			backend:       backend,
+			socketNetwork: network,
+			socketPath:    path,
+			lb:            b,
+		}, nil
+	}
 	targetURL, insecure := expandProxyArg(backend)
 	u, err := url.Parse(targetURL)
 	if err != nil {
@@ -574,8 +636,13 @@ type reverseProxy struct {
 	url  *url.URL
 	// insecure tracks whether the connection to an https backend should be
 	// insecure (i.e because we cannot verify its CA).
-	insecure      bool
-	backend       string
+	insecure bool
+	backend  string
+	// socketNetwork and socketPath, if set, are the local socket that
+	// the backend listens on, as returned by ipn.ParseProxySocket, in
+	// which case url only has a placeholder host.
+	socketNetwork string
+	socketPath    string
 	lb            *LocalBackend
 	httpTransport lazy.SyncValue[*http.Transport]  // transport for non-h2c backends
 	h2cTransport  lazy.SyncValue[*http2.Transport] // transport for h2c backends
@@ -643,7 +710,7 @@ func (rp *reverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (rp *reverseProxy) getTransport() *http.Transport {
 	return rp.httpTransport.Get(func() *http.Transport {
 		return &http.Transport{
-			DialContext: rp.lb.dialer.SystemDial,
+			DialContext: rp.dialBackend,
 			TLSClientConfig: &tls.Config{
 				InsecureSkipVerify: rp.insecure,
 			},
@@ -664,17 +731,26 @@ func (rp *reverseProxy) getH2CTransport() *http2.Transport {
 		return &http2.Transport{
 			AllowHTTP: true,
 			DialTLSContext: func(ctx context.Context, network string, addr string, _ *tls.Config) (net.Conn, error) {
-				return rp.lb.dialer.SystemDial(ctx, "tcp", rp.url.Host)
+				return rp.dialBackend(ctx, "tcp", rp.url.Host)
 			},
 		}
 	})
 }
 
+// dialBackend dials the backend, for the transports of rp.
+func (rp *reverseProxy) dialBackend(ctx context.Context, network, addr string) (net.Conn, error) {
+	if rp.socketNetwork != "" {
+		return dialProxySocket(ctx, rp.socketNetwork, rp.socketPath)
+	}
+	return rp.lb.dialer.SystemDial(ctx, network, addr)
+}
+
 // This is not a generally reliable way how to determine whether a request is
 // for a h2c server, but sufficient for our particular use case.
 func (rp *reverseProxy) shouldProxyViaH2C(r *http.Request) bool {
 	contentType := r.Header.Get(contentTypeHeader)
-	return r.ProtoMajor == 2 && strings.HasPrefix(rp.backend, "http://") && isGRPCContentType(contentType)
+	plaintext := strings.HasPrefix(rp.backend, "http://") || rp.socketNetwork != ""
+	return r.ProtoMajor == 2 && plaintext && isGRPCContentType(contentType)
 }
 
 // isGRPC accepts an HTTP request's content type header value and determines
@@ -723,6 +799,23 @@ func (b *LocalBackend) addTailscaleIdentityHeaders(r *httputil.ProxyRequest) {
 	r.Out.Header.Set("Tailscale-Headers-Info", "https://tailscale.com/s/serve-headers")
 }
 
+// servePermits reports whether the caller at src is permitted to use a
+// serve handler with the allow list allow. Callers from outside the
+// tailnet, like Funnel's, are only permitted if there's no allow list.
+func (b *LocalBackend) servePermits(allow ipn.ServeAccessView, src netip.AddrPort) bool {
+	if !allow.Valid() {
+		return true
+	}
+	node, user, ok := b.WhoIs(src)
+	if !ok {
+		return false
+	}
+	if node.IsTagged() {
+		return allow.Permits("", node.Tags().AsSlice())
+	}
+	return allow.Permits(user.LoginName, nil)
+}
+
 // serveWebHandler is an http.HandlerFunc that maps incoming requests to the
 // correct *http.
 func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
@@ -731,6 +824,19 @@ func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	if c, ok := serveHTTPContextKey.ValueOk(r.Context()); ok {
+		hp := ipn.HostPort(net.JoinHostPort(b.serveHostname(r), strconv.Itoa(int(c.DestPort))))
+		sw := &serveStatusResponseWriter{ResponseWriter: w}
+		defer b.logServeRequest(r, hp, mountPoint, sw, time.Now())
+		w = sw
+	}
+	if allow := h.Allow(); allow.Valid() {
+		c, ok := serveHTTPContextKey.ValueOk(r.Context())
+		if !ok || !b.servePermits(allow, c.SrcAddr) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
 	if s := h.Text(); s != "" {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		io.WriteString(w, s)
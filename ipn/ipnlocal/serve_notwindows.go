@@ -0,0 +1,18 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !windows
+
+package ipnlocal
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// dialNamedPipe dials the Windows named pipe at path, for serve proxies.
+// Named pipes are only supported on Windows.
+func dialNamedPipe(ctx context.Context, path string) (net.Conn, error) {
+	return nil, errors.New("named pipes are only supported on Windows")
+}
@@ -0,0 +1,174 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"tailscale.com/envknob"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/util/ringbuffer"
+)
+
+// serveAccessLog is whether each request served by a serve web handler is
+// logged. It's off by default, as the paths of the requests may be
+// sensitive and the logs are uploaded.
+var serveAccessLog = envknob.RegisterBool("TS_SERVE_ACCESS_LOG")
+
+// maxServeRecent is the number of recent requests kept by serveStats.
+const maxServeRecent = 100
+
+// serveStats are the request counters of the serve web handlers and their
+// most recent requests. The zero value is ready for use.
+type serveStats struct {
+	mu       sync.Mutex
+	handlers map[serveHandlerKey]*ipnstate.ServeHandlerStats
+	recent   *ringbuffer.RingBuffer[*ipnstate.ServeAccessLogEntry] // or nil until the first request
+}
+
+// serveHandlerKey identifies a serve web handler.
+type serveHandlerKey struct {
+	hp    ipn.HostPort
+	mount string
+}
+
+// add records the request e.
+func (s *serveStats) add(e *ipnstate.ServeAccessLogEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.recent == nil {
+		s.recent = ringbuffer.New[*ipnstate.ServeAccessLogEntry](maxServeRecent)
+	}
+	s.recent.Add(e)
+	k := serveHandlerKey{ipn.HostPort(e.HostPort), e.Mount}
+	hs, ok := s.handlers[k]
+	if !ok {
+		if s.handlers == nil {
+			s.handlers = make(map[serveHandlerKey]*ipnstate.ServeHandlerStats)
+		}
+		hs = &ipnstate.ServeHandlerStats{HostPort: e.HostPort, Mount: e.Mount}
+		s.handlers[k] = hs
+	}
+	hs.Requests++
+	if e.Funnel {
+		hs.FunnelRequests++
+	}
+	switch {
+	case e.Status >= 500:
+		hs.ServerErrors++
+	case e.Status >= 400:
+		hs.ClientErrors++
+	}
+	hs.LatencySeconds += e.LatencySeconds
+}
+
+// get returns a copy of the stats.
+func (s *serveStats) get() *ipnstate.ServeStats {
+	s.mu.Lock()
+	st := &ipnstate.ServeStats{
+		Recent: s.recent.GetAll(),
+	}
+	for _, hs := range s.handlers {
+		hs := *hs
+		st.Handlers = append(st.Handlers, &hs)
+	}
+	s.mu.Unlock()
+	slices.SortFunc(st.Handlers, func(a, b *ipnstate.ServeHandlerStats) int {
+		return cmp.Or(cmp.Compare(a.HostPort, b.HostPort), cmp.Compare(a.Mount, b.Mount))
+	})
+	return st
+}
+
+// ServeStats returns the request counters of the serve web handlers and
+// their most recent requests.
+func (b *LocalBackend) ServeStats() *ipnstate.ServeStats {
+	return b.serveStats.get()
+}
+
+// logServeRequest records the request r, served by the handler at
+// mountPoint of hp since start, in b's serve stats, and logs it if
+// serveAccessLog is set.
+func (b *LocalBackend) logServeRequest(r *http.Request, hp ipn.HostPort, mountPoint string, w *serveStatusResponseWriter, start time.Time) {
+	e := &ipnstate.ServeAccessLogEntry{
+		Time:           start,
+		HostPort:       string(hp),
+		Mount:          mountPoint,
+		Method:         r.Method,
+		Path:           r.URL.Path,
+		Status:         w.status(),
+		LatencySeconds: time.Since(start).Seconds(),
+	}
+	if c, ok := serveHTTPContextKey.ValueOk(r.Context()); ok {
+		e.Src = c.SrcAddr.String()
+		if node, user, ok := b.WhoIs(c.SrcAddr); ok {
+			e.Node = node.Name()
+			if !node.IsTagged() {
+				e.User = user.LoginName
+			}
+		} else {
+			e.Funnel = true
+		}
+	}
+	b.serveStats.add(e)
+
+	if serveAccessLog() {
+		who := e.User
+		if e.Funnel {
+			who = "funnel"
+		} else if who == "" {
+			who = e.Node
+		}
+		b.logf("serve: %s %s %s from %s (%s): %d in %v", hp, e.Method, e.Path, e.Src, who, e.Status, time.Duration(e.LatencySeconds*float64(time.Second)).Round(time.Millisecond))
+	}
+}
+
+// serveStatusResponseWriter is an http.ResponseWriter wrapper that
+// records the status of the response.
+type serveStatusResponseWriter struct {
+	http.ResponseWriter
+	code int // or 0 if not yet written
+}
+
+func (w *serveStatusResponseWriter) WriteHeader(code int) {
+	if w.code == 0 && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *serveStatusResponseWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush implements http.Flusher, for streaming responses from proxied
+// backends.
+func (w *serveStatusResponseWriter) Flush() {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying http.ResponseWriter, for use by
+// http.ResponseController.
+func (w *serveStatusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// status returns the status of the response, which is 200 if nothing was
+// written.
+func (w *serveStatusResponseWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
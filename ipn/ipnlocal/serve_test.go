@@ -13,6 +13,8 @@
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
@@ -20,11 +22,13 @@
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
 
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tsd"
@@ -549,6 +553,169 @@ type headerCheck struct {
 	}
 }
 
+func TestServeHTTPProxyUnixSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no Unix sockets on Windows")
+	}
+	b := newTestBackend(t)
+
+	sock := filepath.Join(t.TempDir(), "app.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testServ := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello from "+r.URL.Path)
+	})}
+	go testServ.Serve(ln)
+	defer testServ.Close()
+
+	conf := &ipn.ServeConfig{
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/": {Proxy: "unix:" + sock},
+			}},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &http.Request{
+		URL: &url.URL{Path: "/foo"},
+		TLS: &tls.ConnectionState{ServerName: "example.ts.net"},
+	}
+	req = req.WithContext(serveHTTPContextKey.WithValue(req.Context(), &serveHTTPContext{
+		DestPort: 443,
+		SrcAddr:  netip.MustParseAddrPort("100.150.151.152:1234"),
+	}))
+	w := httptest.NewRecorder()
+	b.serveWebHandler(w, req)
+	if got, want := w.Body.String(), "hello from /foo"; got != want {
+		t.Errorf("got body %q; want %q", got, want)
+	}
+}
+
+func TestServeHTTPAllow(t *testing.T) {
+	b := newTestBackend(t)
+
+	conf := &ipn.ServeConfig{
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/":       {Text: "public"},
+				"/admin/": {Text: "admin", Allow: &ipn.ServeAccess{Users: []string{"someone@example.com"}}},
+				"/tags/":  {Text: "tags", Allow: &ipn.ServeAccess{Tags: []string{"tag:server"}}},
+			}},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		srcIP    string
+		path     string
+		wantCode int
+	}{
+		{"public-from-user", "100.150.151.152", "/", 200},
+		{"public-from-outside-tailnet", "100.160.161.162", "/", 200},
+		{"admin-from-user", "100.150.151.152", "/admin/", 200},
+		{"admin-from-tagged-node", "100.150.151.153", "/admin/", 403},
+		{"admin-from-outside-tailnet", "100.160.161.162", "/admin/", 403},
+		{"tags-from-tagged-node", "100.150.151.153", "/tags/", 200},
+		{"tags-from-user", "100.150.151.152", "/tags/", 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &http.Request{
+				URL: &url.URL{Path: tt.path},
+				TLS: &tls.ConnectionState{ServerName: "example.ts.net"},
+			}
+			req = req.WithContext(serveHTTPContextKey.WithValue(req.Context(), &serveHTTPContext{
+				DestPort: 443,
+				SrcAddr:  netip.MustParseAddrPort(tt.srcIP + ":1234"),
+			}))
+			w := httptest.NewRecorder()
+			b.serveWebHandler(w, req)
+			if w.Code != tt.wantCode {
+				t.Errorf("got status %v; want %v", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestServeStats(t *testing.T) {
+	b := newTestBackend(t)
+
+	conf := &ipn.ServeConfig{
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/":       {Text: "public"},
+				"/admin/": {Text: "admin", Allow: &ipn.ServeAccess{Users: []string{"someone@example.com"}}},
+			}},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rq := range []struct {
+		srcIP, path string
+	}{
+		{"100.150.151.152", "/"},
+		{"100.160.161.162", "/foo"},
+		{"100.150.151.152", "/admin/"},
+		{"100.150.151.153", "/admin/x"},
+	} {
+		req := &http.Request{
+			Method: "GET",
+			URL:    &url.URL{Path: rq.path},
+			TLS:    &tls.ConnectionState{ServerName: "example.ts.net"},
+		}
+		req = req.WithContext(serveHTTPContextKey.WithValue(req.Context(), &serveHTTPContext{
+			DestPort: 443,
+			SrcAddr:  netip.MustParseAddrPort(rq.srcIP + ":1234"),
+		}))
+		b.serveWebHandler(httptest.NewRecorder(), req)
+	}
+
+	st := b.ServeStats()
+	var got []ipnstate.ServeHandlerStats
+	for _, hs := range st.Handlers {
+		hs := *hs
+		hs.LatencySeconds = 0
+		got = append(got, hs)
+	}
+	want := []ipnstate.ServeHandlerStats{
+		{HostPort: "example.ts.net:443", Mount: "/", Requests: 2, FunnelRequests: 1},
+		{HostPort: "example.ts.net:443", Mount: "/admin/", Requests: 2, ClientErrors: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("handler stats = %+v; want %+v", got, want)
+	}
+
+	if len(st.Recent) != 4 {
+		t.Fatalf("got %d recent requests; want 4", len(st.Recent))
+	}
+	for i, want := range []struct {
+		path   string
+		user   string
+		funnel bool
+		status int
+	}{
+		{"/", "someone@example.com", false, 200},
+		{"/foo", "", true, 200},
+		{"/admin/", "someone@example.com", false, 200},
+		{"/admin/x", "", false, 403},
+	} {
+		e := st.Recent[i]
+		if e.Path != want.path || e.User != want.user || e.Funnel != want.funnel || e.Status != want.status {
+			t.Errorf("recent[%d] = %+v; want %+v", i, e, want)
+		}
+	}
+}
+
 func Test_reverseProxyConfiguration(t *testing.T) {
 	b := newTestBackend(t)
 	type test struct {
@@ -694,19 +861,19 @@ func newTestBackend(t *testing.T) *LocalBackend {
 			},
 		},
 	}
-	b.peers = map[tailcfg.NodeID]tailcfg.NodeView{
-		152: (&tailcfg.Node{
+	b.peers.setPeers([]tailcfg.NodeView{
+		(&tailcfg.Node{
 			ID:           152,
 			ComputedName: "some-peer",
 			User:         tailcfg.UserID(1),
 		}).View(),
-		153: (&tailcfg.Node{
+		(&tailcfg.Node{
 			ID:           153,
 			ComputedName: "some-tagged-peer",
 			Tags:         []string{"tag:server", "tag:test"},
 			User:         tailcfg.UserID(1),
 		}).View(),
-	}
+	})
 	b.nodeByAddr = map[netip.Addr]tailcfg.NodeID{
 		netip.MustParseAddr("100.150.151.152"): 152,
 		netip.MustParseAddr("100.150.151.153"): 153,
@@ -0,0 +1,16 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"net"
+
+	"github.com/tailscale/go-winio"
+)
+
+// dialNamedPipe dials the Windows named pipe at path, for serve proxies.
+func dialNamedPipe(ctx context.Context, path string) (net.Conn, error) {
+	return winio.DialPipeContext(ctx, path)
+}
@@ -4,17 +4,21 @@
 package ipnlocal
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	"tailscale.com/ipn"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tailfs"
 	"tailscale.com/types/netmap"
+	"tailscale.com/util/set"
 )
 
 const (
@@ -23,6 +27,15 @@
 	TailFSLocalPort = 8080
 
 	tailfsSharesStateKey = ipn.StateKey("_tailfs-shares")
+
+	// tailFSChangedDelay is how long changes to remote shares are collected
+	// before notifying IPN bus listeners of them, so that a burst of
+	// changes, as when a directory is copied, results in one notification.
+	tailFSChangedDelay = time.Second
+
+	// maxTailFSChanged is the most changed paths sent in one notification.
+	// If there are more, only the common prefix of the paths is sent.
+	maxTailFSChanged = 100
 )
 
 var (
@@ -74,14 +87,15 @@ func (b *LocalBackend) TailFSSetFileServerAddr(addr string) error {
 // To avoid potential incompatibilities across file systems, share names are
 // limited to alphanumeric characters and the underscore _.
 func (b *LocalBackend) TailFSAddShare(share *tailfs.Share) error {
-	var err error
-	share.Name, err = normalizeShareName(share.Name)
-	if err != nil {
+	if err := validateShare(share); err != nil {
 		return err
 	}
 
 	b.mu.Lock()
-	shares, err := b.tailfsAddShareLocked(share)
+	shares, err := b.tailfsModifySharesLocked(func(shares map[string]*tailfs.Share) error {
+		shares[share.Name] = share
+		return nil
+	})
 	b.mu.Unlock()
 	if err != nil {
 		return err
@@ -91,6 +105,26 @@ func (b *LocalBackend) TailFSAddShare(share *tailfs.Share) error {
 	return nil
 }
 
+// validateShare normalizes the name of the given share and returns an error
+// if the share is invalid.
+func validateShare(share *tailfs.Share) error {
+	var err error
+	share.Name, err = normalizeShareName(share.Name)
+	if err != nil {
+		return err
+	}
+	if err := share.ValidateAccess(); err != nil {
+		return err
+	}
+	if share.Quota < 0 {
+		return fmt.Errorf("invalid quota %d", share.Quota)
+	}
+	if share.DownloadLimit < 0 || share.UploadLimit < 0 {
+		return errors.New("invalid negative bandwidth limit")
+	}
+	return nil
+}
+
 // normalizeShareName normalizes the given share name and returns an error if
 // it contains any disallowed characters.
 func normalizeShareName(name string) (string, error) {
@@ -108,28 +142,45 @@ func normalizeShareName(name string) (string, error) {
 	return name, nil
 }
 
-func (b *LocalBackend) tailfsAddShareLocked(share *tailfs.Share) (map[string]string, error) {
-	fs, ok := b.sys.TailFSForRemote.GetOK()
-	if !ok {
-		return nil, errors.New("tailfs not enabled")
-	}
-
-	shares, err := b.tailFSGetSharesLocked()
+// TailFSRenameShare renames the share oldName to newName, keeping the rest of
+// its configuration. It returns an error satisfying os.IsNotExist if there's
+// no share named oldName and one satisfying os.IsExist if there's already a
+// share named newName. Share names are forced to lowercase.
+func (b *LocalBackend) TailFSRenameShare(oldName, newName string) error {
+	var err error
+	oldName, err = normalizeShareName(oldName)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	shares[share.Name] = share
-	data, err := json.Marshal(shares)
+	newName, err = normalizeShareName(newName)
 	if err != nil {
-		return nil, fmt.Errorf("marshal: %w", err)
+		return err
 	}
-	err = b.store.WriteState(tailfsSharesStateKey, data)
+
+	b.mu.Lock()
+	shares, err := b.tailfsModifySharesLocked(func(shares map[string]*tailfs.Share) error {
+		share, ok := shares[oldName]
+		if !ok {
+			return os.ErrNotExist
+		}
+		if oldName == newName {
+			return nil
+		}
+		if _, ok := shares[newName]; ok {
+			return os.ErrExist
+		}
+		delete(shares, oldName)
+		share.Name = newName
+		shares[newName] = share
+		return nil
+	})
+	b.mu.Unlock()
 	if err != nil {
-		return nil, fmt.Errorf("write state: %w", err)
+		return err
 	}
-	fs.SetShares(shares)
 
-	return shareNameMap(shares), nil
+	b.tailfsNotifyShares(shares)
+	return nil
 }
 
 // TailFSRemoveShare removes the named share. Share names are forced to
@@ -144,7 +195,13 @@ func (b *LocalBackend) TailFSRemoveShare(name string) error {
 	}
 
 	b.mu.Lock()
-	shares, err := b.tailfsRemoveShareLocked(name)
+	shares, err := b.tailfsModifySharesLocked(func(shares map[string]*tailfs.Share) error {
+		if _, ok := shares[name]; !ok {
+			return os.ErrNotExist
+		}
+		delete(shares, name)
+		return nil
+	})
 	b.mu.Unlock()
 	if err != nil {
 		return err
@@ -154,7 +211,11 @@ func (b *LocalBackend) TailFSRemoveShare(name string) error {
 	return nil
 }
 
-func (b *LocalBackend) tailfsRemoveShareLocked(name string) (map[string]string, error) {
+// tailfsModifySharesLocked calls modify with the current set of shares and,
+// if it succeeds, stores the modified set and starts serving it, so that
+// either all of the modifications take effect or none. It returns the
+// resulting shares as a map of name -> directory.
+func (b *LocalBackend) tailfsModifySharesLocked(modify func(shares map[string]*tailfs.Share) error) (map[string]string, error) {
 	fs, ok := b.sys.TailFSForRemote.GetOK()
 	if !ok {
 		return nil, errors.New("tailfs not enabled")
@@ -164,11 +225,9 @@ func (b *LocalBackend) tailfsRemoveShareLocked(name string) (map[string]string,
 	if err != nil {
 		return nil, err
 	}
-	_, shareExists := shares[name]
-	if !shareExists {
-		return nil, os.ErrNotExist
+	if err := modify(shares); err != nil {
+		return nil, err
 	}
-	delete(shares, name)
 	data, err := json.Marshal(shares)
 	if err != nil {
 		return nil, fmt.Errorf("marshal: %w", err)
@@ -208,6 +267,95 @@ func (b *LocalBackend) tailFSNotifyCurrentSharesLocked() {
 	go b.tailfsNotifyShares(shareNameMap(shares))
 }
 
+// tailFSChangedPath is called with the path of each file or directory that
+// changes on a remote share, to notify IPN bus listeners of it after
+// tailFSChangedDelay.
+func (b *LocalBackend) tailFSChangedPath(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tailFSChanged == nil {
+		b.tailFSChanged = make(set.Set[string])
+		b.clock.AfterFunc(tailFSChangedDelay, b.tailFSNotifyChanged)
+	}
+	b.tailFSChanged.Add(name)
+}
+
+// tailFSNotifyChanged sends the changed paths collected by tailFSChangedPath
+// to IPN bus listeners.
+func (b *LocalBackend) tailFSNotifyChanged() {
+	b.mu.Lock()
+	changed := b.tailFSChanged.Slice()
+	b.tailFSChanged = nil
+	b.mu.Unlock()
+	if len(changed) == 0 {
+		return
+	}
+	slices.Sort(changed)
+	if len(changed) > maxTailFSChanged {
+		changed = []string{commonPathPrefix(changed[0], changed[len(changed)-1])}
+	}
+	b.send(ipn.Notify{TailFSChanged: changed})
+}
+
+// commonPathPrefix returns the longest path that contains both a and b,
+// which are clean absolute paths.
+func commonPathPrefix(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	if i == len(a) && (i == len(b) || b[i] == '/') || i == len(b) && a[i] == '/' {
+		return a[:i]
+	}
+	i = strings.LastIndexByte(a[:i], '/')
+	if i <= 0 {
+		return "/"
+	}
+	return a[:i]
+}
+
+// TailFSShareUsage reports how much each share is being used by remote nodes.
+func (b *LocalBackend) TailFSShareUsage(ctx context.Context) ([]*tailfs.ShareUsage, error) {
+	fs, ok := b.sys.TailFSForRemote.GetOK()
+	if !ok {
+		return nil, errors.New("tailfs not enabled")
+	}
+	return fs.Usage(ctx), nil
+}
+
+// TailFSAuditLog returns the recorded operations of remote nodes on this
+// node's shares that finished after since, oldest first.
+func (b *LocalBackend) TailFSAuditLog(since time.Time) ([]*tailfs.AuditEntry, error) {
+	fs, ok := b.sys.TailFSForRemote.GetOK()
+	if !ok {
+		return nil, errors.New("tailfs not enabled")
+	}
+	return fs.AuditLog(since), nil
+}
+
+// TailFSTransfers returns the files being read or written on remote shares
+// by local clients.
+func (b *LocalBackend) TailFSTransfers() ([]*tailfs.Transfer, error) {
+	fs, ok := b.sys.TailFSForLocal.GetOK()
+	if !ok {
+		return nil, errors.New("tailfs not enabled")
+	}
+	return fs.Transfers(), nil
+}
+
+// TailFSCancelTransfer cancels the transfer with the given ID. It returns an
+// error satisfying os.IsNotExist if there's no such transfer.
+func (b *LocalBackend) TailFSCancelTransfer(id int64) error {
+	fs, ok := b.sys.TailFSForLocal.GetOK()
+	if !ok {
+		return errors.New("tailfs not enabled")
+	}
+	if !fs.CancelTransfer(id) {
+		return os.ErrNotExist
+	}
+	return nil
+}
+
 // TailFSGetShares returns the current set of shares from the state store,
 // stored under ipn.StateKey("_tailfs-shares").
 func (b *LocalBackend) TailFSGetShares() (map[string]*tailfs.Share, error) {
@@ -250,6 +398,9 @@ func (b *LocalBackend) updateTailFSPeersLocked(nm *netmap.NetworkMap) {
 		tailfsRemotes = append(tailfsRemotes, &tailfs.Remote{
 			Name: p.DisplayName(false),
 			URL:  url,
+			Permissions: func(ctx context.Context) (tailfs.Permissions, error) {
+				return tailfs.FetchPermissions(ctx, &tailFSTransport{b: b}, url)
+			},
 			Available: func() bool {
 				// TODO(oxtoacart): need to figure out a performant and reliable way to only
 				// show the peers that have shares to which we have access
@@ -5,7 +5,11 @@
 
 import (
 	"fmt"
+	"os"
+	"reflect"
 	"testing"
+
+	"tailscale.com/tailfs"
 )
 
 func TestNormalizeShareName(t *testing.T) {
@@ -38,3 +42,85 @@ func TestNormalizeShareName(t *testing.T) {
 		})
 	}
 }
+
+func TestCommonPathPrefix(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"/a.ts.net/host/share/x", "/a.ts.net/host/share/y", "/a.ts.net/host/share"},
+		{"/a.ts.net/host/share", "/a.ts.net/host/share/y", "/a.ts.net/host/share"},
+		{"/a.ts.net/host/share/fo", "/a.ts.net/host/share/foo", "/a.ts.net/host/share"},
+		{"/a.ts.net/host/share/foo", "/a.ts.net/host/share/foo", "/a.ts.net/host/share/foo"},
+		{"/a.ts.net/host1/x", "/a.ts.net/host2/x", "/a.ts.net"},
+		{"/a", "/b", "/"},
+	}
+	for _, tt := range tests {
+		if got := commonPathPrefix(tt.a, tt.b); got != tt.want {
+			t.Errorf("commonPathPrefix(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// fakeTailFSForRemote is a tailfs.FileSystemForRemote that records the shares
+// it's given. Its other methods panic.
+type fakeTailFSForRemote struct {
+	tailfs.FileSystemForRemote
+	shares map[string]*tailfs.Share
+}
+
+func (fs *fakeTailFSForRemote) SetShares(shares map[string]*tailfs.Share) {
+	fs.shares = shares
+}
+
+func TestTailFSManageShares(t *testing.T) {
+	b := newTestLocalBackend(t)
+	fs := new(fakeTailFSForRemote)
+	b.sys.TailFSForRemote.Set(fs)
+
+	wantShares := func(want map[string]string) {
+		t.Helper()
+		shares, err := b.TailFSGetShares()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := shareNameMap(shares); !reflect.DeepEqual(got, want) {
+			t.Errorf("stored shares = %v, want %v", got, want)
+		}
+		if got := shareNameMap(fs.shares); !reflect.DeepEqual(got, want) {
+			t.Errorf("served shares = %v, want %v", got, want)
+		}
+	}
+
+	if err := b.TailFSAddShare(&tailfs.Share{Name: "Docs", Path: "/docs"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.TailFSAddShare(&tailfs.Share{Name: "pics", Path: "/pics"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.TailFSAddShare(&tailfs.Share{Name: "bad.name", Path: "/bad"}); err != errInvalidShareName {
+		t.Errorf("adding share with invalid name: got error %v, want %v", err, errInvalidShareName)
+	}
+	wantShares(map[string]string{"docs": "/docs", "pics": "/pics"})
+
+	if err := b.TailFSRenameShare("docs", "Documents"); err != nil {
+		t.Fatal(err)
+	}
+	wantShares(map[string]string{"documents": "/docs", "pics": "/pics"})
+
+	if err := b.TailFSRenameShare("docs", "other"); !os.IsNotExist(err) {
+		t.Errorf("renaming missing share: got error %v, want not exist", err)
+	}
+	if err := b.TailFSRenameShare("documents", "pics"); !os.IsExist(err) {
+		t.Errorf("renaming to existing share: got error %v, want exist", err)
+	}
+	wantShares(map[string]string{"documents": "/docs", "pics": "/pics"})
+
+	if err := b.TailFSRemoveShare("pics"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.TailFSRemoveShare("pics"); !os.IsNotExist(err) {
+		t.Errorf("removing missing share: got error %v, want not exist", err)
+	}
+	wantShares(map[string]string{"documents": "/docs"})
+}
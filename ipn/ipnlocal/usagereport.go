@@ -0,0 +1,365 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/atomicfile"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/mak"
+)
+
+// usageReportsDir is the directory under the Tailscale var root in which the
+// weekly usage reports are saved, one file per week named after the date the
+// week starts on.
+const usageReportsDir = "usage-reports"
+
+// usageReportSaveInterval is how often the report of the week in progress is
+// saved to disk, so that little is lost if the daemon is killed.
+const usageReportSaveInterval = 15 * time.Minute
+
+// usageReporter accumulates the weekly usage report kept when the
+// UsageReports pref is enabled. It is safe for concurrent use.
+//
+// Its methods must not call back into LocalBackend, as some of them are
+// called with LocalBackend.mu held.
+type usageReporter struct {
+	logf    logger.Logf
+	varRoot func() string // returns the Tailscale var root, or empty if none
+
+	mu         sync.Mutex
+	start      time.Time // of the week in progress; zero until first use
+	peers      map[key.NodePublic]*ipnstate.UsageReportPeer
+	services   map[uint16]int64 // serve port => connections
+	funnelHits int64
+	newDevices map[tailcfg.StableNodeID]ipnstate.UsageReportDevice
+	lastSave   time.Time
+	dirty      bool // whether there's anything unsaved
+
+	// lastBytes are the WireGuard byte counters of each peer as of the last
+	// engine status, to turn the running totals into per-week amounts.
+	lastBytes map[key.NodePublic][2]int64 // [tx, rx]
+}
+
+func newUsageReporter(logf logger.Logf, varRoot func() string) *usageReporter {
+	return &usageReporter{
+		logf:    logger.WithPrefix(logf, "usagereport: "),
+		varRoot: varRoot,
+	}
+}
+
+// usageWeekStart returns the start of the week t is in: midnight local time
+// on the preceding Monday.
+func usageWeekStart(t time.Time) time.Time {
+	daysSinceMonday := (int(t.Weekday()) + 6) % 7
+	y, m, d := t.Date()
+	return time.Date(y, m, d-daysSinceMonday, 0, 0, 0, 0, t.Location())
+}
+
+// dir returns the directory reports are saved in, or the empty string if
+// there's no var root and reports are only kept in memory.
+func (u *usageReporter) dir() string {
+	root := u.varRoot()
+	if root == "" {
+		return ""
+	}
+	return filepath.Join(root, usageReportsDir)
+}
+
+func (u *usageReporter) reportPath(start time.Time) string {
+	dir := u.dir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, start.Format(time.DateOnly)+".json")
+}
+
+// advanceLocked makes sure the week in progress is the one now is in. On
+// first use it resumes the report saved by a previous run of the daemon, if
+// any. If the previous week has ended, its report is saved and a new one is
+// started.
+func (u *usageReporter) advanceLocked(now time.Time) {
+	ws := usageWeekStart(now)
+	if u.start.Equal(ws) {
+		return
+	}
+	if !u.start.IsZero() {
+		if err := u.saveLocked(); err != nil {
+			u.logf("saving report for week of %v: %v", u.start.Format(time.DateOnly), err)
+		}
+	}
+	u.start = ws
+	u.peers = nil
+	u.services = nil
+	u.funnelHits = 0
+	u.newDevices = nil
+	u.dirty = false
+	u.lastSave = now
+
+	r, err := u.readReport(ws)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			u.logf("resuming report for week of %v: %v", ws.Format(time.DateOnly), err)
+		}
+		return
+	}
+	for _, p := range r.Peers {
+		mak.Set(&u.peers, p.NodeKey, &p)
+	}
+	for _, s := range r.Services {
+		mak.Set(&u.services, s.Port, s.Conns)
+	}
+	u.funnelHits = r.FunnelHits
+	for _, d := range r.NewDevices {
+		mak.Set(&u.newDevices, d.ID, d)
+	}
+}
+
+// readReport reads the saved report of the week starting at start.
+func (u *usageReporter) readReport(start time.Time) (*ipnstate.UsageReport, error) {
+	path := u.reportPath(start)
+	if path == "" {
+		return nil, fs.ErrNotExist
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := new(ipnstate.UsageReport)
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// notePeerStats adds the traffic reported by the engine for peers to the
+// week's totals. lookup returns the peer with the given node key, if known,
+// to name it in the report.
+func (u *usageReporter) notePeerStats(now time.Time, peers []ipnstate.PeerStatusLite, lookup func(key.NodePublic) (tailcfg.NodeView, bool)) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.advanceLocked(now)
+	for _, ps := range peers {
+		last := u.lastBytes[ps.NodeKey]
+		mak.Set(&u.lastBytes, ps.NodeKey, [2]int64{ps.TxBytes, ps.RxBytes})
+		tx, rx := ps.TxBytes-last[0], ps.RxBytes-last[1]
+		if tx < 0 || rx < 0 {
+			// The engine reset its counters for this peer, e.g. because
+			// it was removed and re-added.
+			tx, rx = ps.TxBytes, ps.RxBytes
+		}
+		if tx == 0 && rx == 0 {
+			continue
+		}
+		p, ok := u.peers[ps.NodeKey]
+		if !ok {
+			p = &ipnstate.UsageReportPeer{NodeKey: ps.NodeKey}
+			mak.Set(&u.peers, ps.NodeKey, p)
+		}
+		if p.Name == "" {
+			if n, ok := lookup(ps.NodeKey); ok {
+				p.Name = usageReportNodeName(n)
+				if n.Addresses().Len() > 0 {
+					p.TailscaleIP = n.Addresses().At(0).Addr()
+				}
+			}
+		}
+		p.TxBytes += tx
+		p.RxBytes += rx
+		u.dirty = true
+	}
+}
+
+// noteBaseline records the WireGuard byte counters of peers without adding
+// them to the week's totals. It's called while the UsageReports pref is off,
+// so that once it's turned on, only the traffic from then on is counted.
+func (u *usageReporter) noteBaseline(peers []ipnstate.PeerStatusLite) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	clear(u.lastBytes)
+	for _, ps := range peers {
+		mak.Set(&u.lastBytes, ps.NodeKey, [2]int64{ps.TxBytes, ps.RxBytes})
+	}
+}
+
+// noteServeConn records a connection to the serve handler for port.
+func (u *usageReporter) noteServeConn(now time.Time, port uint16) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.advanceLocked(now)
+	mak.Set(&u.services, port, u.services[port]+1)
+	u.dirty = true
+}
+
+// noteFunnelConn records a connection that came in over Funnel.
+func (u *usageReporter) noteFunnelConn(now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.advanceLocked(now)
+	u.funnelHits++
+	u.dirty = true
+}
+
+// notePeers records which of peers joined the tailnet during the week.
+func (u *usageReporter) notePeers(now time.Time, peers []tailcfg.NodeView) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.advanceLocked(now)
+	for _, n := range peers {
+		if n.Created().Before(u.start) {
+			continue
+		}
+		if _, ok := u.newDevices[n.StableID()]; ok {
+			continue
+		}
+		d := ipnstate.UsageReportDevice{
+			ID:      n.StableID(),
+			Name:    usageReportNodeName(n),
+			Created: n.Created(),
+		}
+		if n.Addresses().Len() > 0 {
+			d.TailscaleIP = n.Addresses().At(0).Addr()
+		}
+		mak.Set(&u.newDevices, n.StableID(), d)
+		u.dirty = true
+	}
+}
+
+// usageReportNodeName returns the name of n to use in a usage report.
+func usageReportNodeName(n tailcfg.NodeView) string {
+	if name := strings.TrimSuffix(n.Name(), "."); name != "" {
+		return name
+	}
+	return n.Hostinfo().Hostname()
+}
+
+// reportLocked returns the report of the week in progress.
+func (u *usageReporter) reportLocked() *ipnstate.UsageReport {
+	r := &ipnstate.UsageReport{
+		Start:      u.start,
+		End:        u.start.AddDate(0, 0, 7),
+		FunnelHits: u.funnelHits,
+	}
+	for _, p := range u.peers {
+		r.Peers = append(r.Peers, *p)
+	}
+	slices.SortFunc(r.Peers, func(a, b ipnstate.UsageReportPeer) int {
+		return cmp.Or(
+			cmp.Compare(b.TxBytes+b.RxBytes, a.TxBytes+a.RxBytes),
+			cmp.Compare(a.Name, b.Name),
+		)
+	})
+	for port, n := range u.services {
+		r.Services = append(r.Services, ipnstate.UsageReportService{Port: port, Conns: n})
+	}
+	slices.SortFunc(r.Services, func(a, b ipnstate.UsageReportService) int {
+		return cmp.Or(cmp.Compare(b.Conns, a.Conns), cmp.Compare(a.Port, b.Port))
+	})
+	for _, d := range u.newDevices {
+		r.NewDevices = append(r.NewDevices, d)
+	}
+	slices.SortFunc(r.NewDevices, func(a, b ipnstate.UsageReportDevice) int {
+		return cmp.Or(a.Created.Compare(b.Created), cmp.Compare(a.Name, b.Name))
+	})
+	return r
+}
+
+// report returns the report of the week now is in.
+func (u *usageReporter) report(now time.Time) *ipnstate.UsageReport {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.advanceLocked(now)
+	return u.reportLocked()
+}
+
+// lastReport returns the saved report of the week before the one now is in.
+func (u *usageReporter) lastReport(now time.Time) (*ipnstate.UsageReport, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	// Make sure the previous week is saved if the daemon was running when
+	// it ended.
+	u.advanceLocked(now)
+	r, err := u.readReport(usageWeekStart(now).AddDate(0, 0, -7))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errors.New("no usage report for last week")
+	}
+	return r, err
+}
+
+// maybeSave saves the report of the week in progress if it has changed and
+// wasn't saved within usageReportSaveInterval.
+func (u *usageReporter) maybeSave(now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.dirty || now.Sub(u.lastSave) < usageReportSaveInterval {
+		return
+	}
+	u.lastSave = now
+	if err := u.saveLocked(); err != nil {
+		u.logf("saving: %v", err)
+	}
+}
+
+// save saves the report of the week in progress, if it has changed.
+func (u *usageReporter) save() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err := u.saveLocked(); err != nil {
+		u.logf("saving: %v", err)
+	}
+}
+
+func (u *usageReporter) saveLocked() error {
+	if !u.dirty {
+		return nil
+	}
+	path := u.reportPath(u.start)
+	if path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(u.reportLocked(), "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	u.dirty = false
+	return nil
+}
+
+// UsageReport returns the usage report of the current week, or of the
+// previous week if last is true. It returns an error if the UsageReports pref
+// is not enabled.
+func (b *LocalBackend) UsageReport(last bool) (*ipnstate.UsageReport, error) {
+	if !b.Prefs().UsageReports() {
+		return nil, errors.New(`usage reports are not enabled; run "tailscale set --usage-reports" first`)
+	}
+	now := b.clock.Now()
+	if last {
+		r, err := b.usage.lastReport(now)
+		if err != nil {
+			return nil, fmt.Errorf("reading last week's usage report: %w", err)
+		}
+		return r, nil
+	}
+	return b.usage.report(now), nil
+}
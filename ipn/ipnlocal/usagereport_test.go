@@ -0,0 +1,129 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+func TestUsageWeekStart(t *testing.T) {
+	tests := []struct {
+		in   time.Time
+		want time.Time
+	}{
+		{time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)},    // Monday
+		{time.Date(2024, 3, 6, 13, 5, 0, 0, time.UTC), time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)},   // Wednesday
+		{time.Date(2024, 3, 10, 23, 59, 0, 0, time.UTC), time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)}, // Sunday
+		{time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), time.Date(2024, 2, 26, 0, 0, 0, 0, time.UTC)},  // across months
+	}
+	for _, tt := range tests {
+		if got := usageWeekStart(tt.in); !got.Equal(tt.want) {
+			t.Errorf("usageWeekStart(%v) = %v; want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestUsageReporter(t *testing.T) {
+	dir := t.TempDir()
+	logf := func(format string, args ...any) { t.Logf(format, args...) }
+	u := newUsageReporter(logf, func() string { return dir })
+
+	k1 := key.NewNode().Public()
+	k2 := key.NewNode().Public()
+	n1 := (&tailcfg.Node{
+		Key:       k1,
+		Name:      "one.example.ts.net.",
+		Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+	}).View()
+	lookup := func(nk key.NodePublic) (tailcfg.NodeView, bool) {
+		if nk == k1 {
+			return n1, true
+		}
+		return tailcfg.NodeView{}, false
+	}
+
+	now := time.Date(2024, 3, 6, 12, 0, 0, 0, time.UTC)
+	u.notePeerStats(now, []ipnstate.PeerStatusLite{
+		{NodeKey: k1, TxBytes: 100, RxBytes: 1000},
+		{NodeKey: k2, TxBytes: 10, RxBytes: 10},
+	}, lookup)
+	u.notePeerStats(now.Add(time.Minute), []ipnstate.PeerStatusLite{
+		{NodeKey: k1, TxBytes: 150, RxBytes: 1500},
+		{NodeKey: k2, TxBytes: 5, RxBytes: 5}, // counters were reset
+	}, lookup)
+	u.noteServeConn(now, 443)
+	u.noteServeConn(now, 443)
+	u.noteServeConn(now, 22)
+	u.noteFunnelConn(now)
+	u.notePeers(now, []tailcfg.NodeView{
+		n1, // zero Created, so it's not new
+		(&tailcfg.Node{StableID: "n3", Name: "three.example.ts.net.", Created: now.Add(-time.Hour)}).View(),
+	})
+
+	r := u.report(now)
+	if len(r.Peers) != 2 {
+		t.Fatalf("got %d peers; want 2", len(r.Peers))
+	}
+	if p := r.Peers[0]; p.NodeKey != k1 || p.Name != "one.example.ts.net" || p.TxBytes != 150 || p.RxBytes != 1500 {
+		t.Errorf("top peer = %+v", p)
+	}
+	if p := r.Peers[1]; p.NodeKey != k2 || p.TxBytes != 15 || p.RxBytes != 15 {
+		t.Errorf("second peer = %+v", p)
+	}
+	if len(r.Services) != 2 || r.Services[0].Port != 443 || r.Services[0].Conns != 2 {
+		t.Errorf("services = %+v", r.Services)
+	}
+	if r.FunnelHits != 1 {
+		t.Errorf("FunnelHits = %d; want 1", r.FunnelHits)
+	}
+	if len(r.NewDevices) != 1 || r.NewDevices[0].Name != "three.example.ts.net" {
+		t.Errorf("NewDevices = %+v", r.NewDevices)
+	}
+
+	// A restarted daemon resumes the week's report.
+	u.save()
+	u2 := newUsageReporter(logf, func() string { return dir })
+	if got := u2.report(now); len(got.Peers) != 2 || got.FunnelHits != 1 {
+		t.Errorf("resumed report = %+v", got)
+	}
+
+	// The next week starts empty and the previous one can still be read.
+	next := now.AddDate(0, 0, 7)
+	if got := u2.report(next); len(got.Peers) != 0 || !got.Start.Equal(usageWeekStart(next)) {
+		t.Errorf("next week's report = %+v", got)
+	}
+	last, err := u2.lastReport(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(last.Peers) != 2 || last.Peers[0].TxBytes != 150 {
+		t.Errorf("last week's report = %+v", last)
+	}
+}
+
+func TestUsageReporterBaseline(t *testing.T) {
+	u := newUsageReporter(t.Logf, func() string { return "" })
+	k1 := key.NewNode().Public()
+	lookup := func(key.NodePublic) (tailcfg.NodeView, bool) { return tailcfg.NodeView{}, false }
+
+	// Traffic while the UsageReports pref is off isn't counted once it's
+	// turned on.
+	now := time.Date(2024, 3, 6, 12, 0, 0, 0, time.UTC)
+	u.noteBaseline([]ipnstate.PeerStatusLite{{NodeKey: k1, TxBytes: 5000, RxBytes: 7000}})
+	u.notePeerStats(now, []ipnstate.PeerStatusLite{{NodeKey: k1, TxBytes: 5100, RxBytes: 7200}}, lookup)
+
+	r := u.report(now)
+	if len(r.Peers) != 1 {
+		t.Fatalf("got %d peers; want 1", len(r.Peers))
+	}
+	if p := r.Peers[0]; p.TxBytes != 100 || p.RxBytes != 200 {
+		t.Errorf("peer = %+v; want 100 bytes sent and 200 received", p)
+	}
+}
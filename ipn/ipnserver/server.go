@@ -12,6 +12,7 @@
 	"net"
 	"net/http"
 	"os/user"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -19,6 +20,7 @@
 	"time"
 	"unicode"
 
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/envknob"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnauth"
@@ -53,11 +55,19 @@ type Server struct {
 	// lock order: mu, then LocalBackend.mu
 	mu            sync.Mutex
 	lastUserID    ipn.WindowsUserID // tracks last userid; on change, Reset state for paranoia
-	activeReqs    map[*http.Request]*ipnauth.ConnIdentity
+	activeReqs    map[*http.Request]*activeReq
 	backendWaiter waiterSet // of LocalBackend waiters
 	zeroReqWaiter waiterSet // of blockUntilZeroConnections waiters
 }
 
+// activeReq is a LocalAPI request in flight. The active requests make up the
+// sessions of the clients currently connected to the server.
+type activeReq struct {
+	ci     *ipnauth.ConnIdentity
+	start  time.Time
+	cancel context.CancelFunc // cancels the request, detaching its client
+}
+
 func (s *Server) mustBackend() *ipnlocal.LocalBackend {
 	lb := s.lb.Load()
 	if lb == nil {
@@ -186,7 +196,19 @@ func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	onDone, err := s.addActiveHTTPRequest(r, ci)
+	// Session management must keep working while tailscaled is in use by
+	// another user, so it's served before this request becomes active,
+	// which would fail in that case.
+	if strings.HasPrefix(r.URL.Path, "/localapi/v0/sessions") {
+		s.serveSessions(w, r, ci)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	onDone, err := s.addActiveHTTPRequest(r, ci, cancel)
 	if err != nil {
 		if ou, ok := err.(inUseOtherUserError); ok && localapi.InUseOtherUserIPNStream(w, r, ou.Unwrap()) {
 			w.(http.Flusher).Flush()
@@ -240,7 +262,8 @@ func (s *Server) checkConnIdentityLocked(ci *ipnauth.ConnIdentity) error {
 	// This mostly matters on Windows at the moment.
 	if len(s.activeReqs) > 0 {
 		var active *ipnauth.ConnIdentity
-		for _, active = range s.activeReqs {
+		for _, ar := range s.activeReqs {
+			active = ar.ci
 			break
 		}
 		if active != nil {
@@ -371,11 +394,13 @@ func (s *Server) connCanFetchCerts(ci *ipnauth.ConnIdentity) bool {
 }
 
 // addActiveHTTPRequest adds c to the server's list of active HTTP requests.
+// cancel cancels the request's context; it's called if the request's session
+// is detached.
 //
 // If the returned error may be of type inUseOtherUserError.
 //
 // onDone must be called when the HTTP request is done.
-func (s *Server) addActiveHTTPRequest(req *http.Request, ci *ipnauth.ConnIdentity) (onDone func(), err error) {
+func (s *Server) addActiveHTTPRequest(req *http.Request, ci *ipnauth.ConnIdentity, cancel context.CancelFunc) (onDone func(), err error) {
 	if ci == nil {
 		return nil, errors.New("internal error: nil connIdentity")
 	}
@@ -399,7 +424,7 @@ func (s *Server) addActiveHTTPRequest(req *http.Request, ci *ipnauth.ConnIdentit
 		return nil, err
 	}
 
-	mak.Set(&s.activeReqs, req, ci)
+	mak.Set(&s.activeReqs, req, &activeReq{ci: ci, start: time.Now(), cancel: cancel})
 
 	if len(s.activeReqs) == 1 {
 		token, err := ci.WindowsToken()
@@ -451,6 +476,110 @@ func (s *Server) addActiveHTTPRequest(req *http.Request, ci *ipnauth.ConnIdentit
 	return onDone, nil
 }
 
+// canManageSessions reports whether ci may list and detach the sessions of
+// other clients. On Windows, that is SYSTEM and elevated administrators, who
+// may need to take over from another user that is still connected after a
+// fast user switch. Elsewhere, it's whoever has LocalAPI write access.
+//
+// s.mu must not be held.
+func (s *Server) canManageSessions(ci *ipnauth.ConnIdentity) bool {
+	if envknob.GOOS() != "windows" {
+		_, write := s.localAPIPermissions(ci)
+		return write
+	}
+	tok, err := ci.WindowsToken()
+	if err != nil {
+		return false
+	}
+	defer tok.Close()
+	return tok.IsLocalSystem() || tok.IsElevated()
+}
+
+// serveSessions serves the LocalAPI "sessions" endpoints, which list the
+// clients with LocalAPI requests in flight and detach them.
+func (s *Server) serveSessions(w http.ResponseWriter, r *http.Request, ci *ipnauth.ConnIdentity) {
+	if !s.canManageSessions(ci) {
+		http.Error(w, "sessions access denied", http.StatusForbidden)
+		return
+	}
+	switch r.URL.Path {
+	case "/localapi/v0/sessions":
+		if r.Method != "GET" {
+			http.Error(w, "use GET", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.sessions())
+	case "/localapi/v0/sessions/detach":
+		if r.Method != "POST" {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		n := s.detachSessions()
+		s.logf("detached %d LocalAPI sessions at the request of pid %d", n, ci.Pid())
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(apitype.DetachSessionsResponse{Detached: n})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// sessions returns the clients with LocalAPI requests in flight, oldest
+// first.
+func (s *Server) sessions() []apitype.LocalSession {
+	s.mu.Lock()
+	ret := make([]apitype.LocalSession, 0, len(s.activeReqs))
+	cis := make([]*ipnauth.ConnIdentity, 0, len(s.activeReqs))
+	for req, ar := range s.activeReqs {
+		ret = append(ret, apitype.LocalSession{
+			PID:   ar.ci.Pid(),
+			Path:  req.URL.Path,
+			Since: ar.start,
+		})
+		cis = append(cis, ar.ci)
+	}
+	s.mu.Unlock()
+
+	// Look up the users without s.mu held, as it may be slow.
+	for i, ci := range cis {
+		uid := string(ci.WindowsUserID())
+		if uid == "" && ci.Creds() != nil {
+			uid, _ = ci.Creds().UserID()
+		}
+		if uid == "" {
+			continue
+		}
+		ret[i].UserID = uid
+		if u, err := ipnauth.LookupUserFromID(s.logf, uid); err == nil {
+			ret[i].Username = u.Username
+		}
+	}
+	slices.SortFunc(ret, func(a, b apitype.LocalSession) int {
+		return a.Since.Compare(b.Since)
+	})
+	return ret
+}
+
+// detachSessions cancels all LocalAPI requests in flight, disconnecting their
+// clients, and returns how many there were. Once they're gone, the server
+// behaves as if those clients disconnected on their own, including resetting
+// the backend unless it's in server mode.
+func (s *Server) detachSessions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ar := range s.activeReqs {
+		ar.cancel()
+	}
+	return len(s.activeReqs)
+}
+
+// CancelRequests cancels all LocalAPI requests in flight and returns how
+// many there were, like detaching all sessions does. It's used to restart
+// a LocalAPI whose handlers are stuck.
+func (s *Server) CancelRequests() int {
+	return s.detachSessions()
+}
+
 // New returns a new Server.
 //
 // To start it, use the Server.Run method.
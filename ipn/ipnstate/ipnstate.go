@@ -198,6 +198,141 @@ type PeerStatusLite struct {
 	NodeKey key.NodePublic
 }
 
+// PeerConnEvent is a transition in how the local node connects to a peer,
+// as kept in the peer's connectivity history. It is intended for debugging
+// and its What values are not a stable interface.
+type PeerConnEvent struct {
+	When time.Time // when the transition occurred
+
+	// What is the kind of transition:
+	//   - "path": the path used to reach the peer changed. From and To
+	//     are "udp:<ip:port>" or "derp".
+	//   - "derp-home": the peer's home DERP region changed. From and To
+	//     are "derp-<regionID>".
+	//   - "handshake-timeout": a connection to the peer could not be
+	//     opened. To is the destination of the failed attempt.
+	What string
+
+	From string `json:",omitempty"`
+	To   string `json:",omitempty"`
+}
+
+// PathStatsVersion is the version of the PathStats JSON schema. It's
+// incremented when a field is removed or changes meaning; fields may be
+// added without incrementing it.
+const PathStatsVersion = 1
+
+// PathStats are the statistics of the paths to the local node's peers, for
+// monitoring. Unlike Status, its JSON schema is a stable interface,
+// versioned by PathStatsVersion.
+type PathStats struct {
+	Version int // PathStatsVersion
+	Peers   []*PeerPathStats
+}
+
+// PeerPathStats are the statistics of the path to a peer.
+type PeerPathStats struct {
+	NodeKey      key.NodePublic
+	DNSName      string
+	TailscaleIPs []netip.Addr
+
+	// Path is how packets are currently sent to the peer: "direct" over
+	// UDP, "derp" through a DERP relay, "udp+derp" over both while the
+	// direct path is being re-validated, or "none" if no packets have
+	// been sent to the peer recently.
+	Path string
+
+	// Endpoint is the ip:port of the direct path to the peer, if any. It
+	// may be set when Path isn't "direct", if the direct path is being
+	// re-validated.
+	Endpoint string `json:",omitempty"`
+
+	// RTTSeconds is the round-trip time of the direct path to the peer,
+	// as measured by disco, or zero if there's no direct path.
+	RTTSeconds float64 `json:",omitempty"`
+
+	// PathMTU is the largest packet size, including IP and UDP headers,
+	// known to fit on the direct path to the peer. It's the safe wire MTU
+	// unless peer path MTU discovery, which probes the path again every
+	// few minutes, found a larger one, or zero if there's no direct path.
+	PathMTU int `json:",omitempty"`
+
+	// DERPRegionCode is the code of the peer's home DERP region, through
+	// which packets are relayed when Path is "derp" or "udp+derp".
+	DERPRegionCode string `json:",omitempty"`
+
+	// Endpoints are the peer's candidate endpoints for a direct path.
+	Endpoints []*PathEndpointStats `json:",omitempty"`
+
+	// LastHandshake is the time of the last WireGuard handshake with the
+	// peer, and HandshakeAgeSeconds is how long ago it was. Both are zero
+	// if there has been no handshake.
+	LastHandshake       time.Time
+	HandshakeAgeSeconds float64 `json:",omitempty"`
+
+	// Migrations are the recent changes of the path to the peer, oldest
+	// first. Their What is "path".
+	Migrations []PeerConnEvent `json:",omitempty"`
+}
+
+// PathEndpointStats are the statistics of a candidate endpoint of a peer.
+type PathEndpointStats struct {
+	Addr netip.AddrPort
+
+	// Pongs is the number of recent disco pings to the endpoint that
+	// were answered, and LatestRTTSeconds and MinRTTSeconds are their
+	// most recent and lowest round-trip times.
+	Pongs            int
+	LatestRTTSeconds float64 `json:",omitempty"`
+	MinRTTSeconds    float64 `json:",omitempty"`
+}
+
+// UsageReport is a summary of the local node's tailnet usage over one week,
+// kept when the UsageReports pref is enabled. It never leaves the machine.
+type UsageReport struct {
+	// Start and End bound the week the report covers. For the current
+	// week, End is in the future.
+	Start, End time.Time
+
+	// Peers are the peers data was exchanged with during the week, sorted
+	// by total bytes, most first.
+	Peers []UsageReportPeer `json:",omitempty"`
+
+	// Services are the local serve ports that were accessed during the
+	// week, sorted by number of connections, most first.
+	Services []UsageReportService `json:",omitempty"`
+
+	// FunnelHits is the number of connections that came in over Funnel.
+	FunnelHits int64 `json:",omitempty"`
+
+	// NewDevices are the devices that joined the tailnet during the week,
+	// oldest first.
+	NewDevices []UsageReportDevice `json:",omitempty"`
+}
+
+// UsageReportPeer is the traffic exchanged with a peer in a UsageReport.
+type UsageReportPeer struct {
+	NodeKey     key.NodePublic
+	Name        string // the peer's DNS name, or hostname if unknown
+	TailscaleIP netip.Addr
+	TxBytes     int64
+	RxBytes     int64
+}
+
+// UsageReportService is a local serve port in a UsageReport.
+type UsageReportService struct {
+	Port  uint16
+	Conns int64 // number of connections accepted, including over Funnel
+}
+
+// UsageReportDevice is a newly joined device in a UsageReport.
+type UsageReportDevice struct {
+	ID          tailcfg.StableNodeID
+	Name        string
+	TailscaleIP netip.Addr
+	Created     time.Time
+}
+
 // PeerStatus describes a peer node and its current state.
 type PeerStatus struct {
 	ID        tailcfg.StableNodeID
@@ -668,6 +803,123 @@ type PingResult struct {
 	// TODO(bradfitz): details like whether port mapping was used on either side? (Once supported)
 }
 
+// BenchResult is the result of LocalBackend.BenchPeer, which measures the
+// quality of the path to a peer.
+type BenchResult struct {
+	IP       string // bench destination
+	NodeName string // DNS name base or (possibly not unique) hostname
+
+	// PingsSent and PongsReceived are the number of disco pings sent to
+	// the peer and answered, which give the loss of the path.
+	PingsSent     int
+	PongsReceived int
+
+	// LatencyMinSeconds, LatencyAvgSeconds and LatencyMaxSeconds are
+	// the round-trip times of the answered pings.
+	LatencyMinSeconds float64
+	LatencyAvgSeconds float64
+	LatencyMaxSeconds float64
+
+	// Endpoint is the ip:port of the direct path, if the last answered
+	// ping used one. Otherwise DERPRegionCode is the region code of the
+	// DERP relay it went through.
+	Endpoint       string `json:",omitempty"`
+	DERPRegionCode string `json:",omitempty"`
+
+	// UploadBytesPerSecond and DownloadBytesPerSecond are the
+	// throughput to and from the peer, measured over its peerapi. They
+	// are zero if it couldn't be measured, in which case Err says why.
+	UploadBytesPerSecond   float64
+	DownloadBytesPerSecond float64
+
+	Err string `json:",omitempty"`
+}
+
+// Loss returns the fraction of the pings of r that weren't answered.
+func (r *BenchResult) Loss() float64 {
+	if r.PingsSent == 0 {
+		return 0
+	}
+	return 1 - float64(r.PongsReceived)/float64(r.PingsSent)
+}
+
+// ServeStats are the request counters of the tailscale serve and funnel
+// web handlers, and a log of their most recent requests.
+type ServeStats struct {
+	// Handlers are the counters of each handler that has served a
+	// request since tailscaled started, sorted by HostPort and Mount.
+	Handlers []*ServeHandlerStats
+
+	// Recent are the most recent requests, oldest first.
+	Recent []*ServeAccessLogEntry
+}
+
+// ServeHandlerStats are the request counters of a serve web handler.
+type ServeHandlerStats struct {
+	HostPort string // like "foo.tail1234.ts.net:443"
+	Mount    string // mount point of the handler, like "/" or "/admin/"
+
+	Requests       int64
+	FunnelRequests int64 // requests from outside the tailnet
+	ClientErrors   int64 // 4xx responses
+	ServerErrors   int64 // 5xx responses
+
+	// LatencySeconds is the total time spent serving the requests.
+	LatencySeconds float64
+}
+
+// ServeAccessLogEntry is the record of a request served by a serve web
+// handler.
+type ServeAccessLogEntry struct {
+	Time     time.Time
+	HostPort string
+	Mount    string
+	Method   string
+	Path     string
+
+	// Src is the ip:port of the client. For requests from the tailnet,
+	// User is the login name of the node's user, or empty for tagged
+	// nodes, and Node is the node's name. Funnel is true for requests
+	// from outside the tailnet.
+	Src    string
+	User   string `json:",omitempty"`
+	Node   string `json:",omitempty"`
+	Funnel bool   `json:",omitempty"`
+
+	Status         int
+	LatencySeconds float64
+}
+
+// FunnelScheduleStatus is the state of the Funnel schedules of the serve
+// config, which allow Funnel traffic only part-time.
+type FunnelScheduleStatus struct {
+	// Open and Closed are the Funnel targets, like
+	// "foo.tail1234.ts.net:443", that traffic is currently allowed and not
+	// allowed to, respectively, sorted.
+	Open   []string `json:",omitempty"`
+	Closed []string `json:",omitempty"`
+
+	// NextChange is when a schedule next opens or closes, and NextOpening
+	// is when a currently closed schedule next opens. They are zero if
+	// that doesn't happen in the coming week.
+	NextChange  time.Time `json:",omitempty"`
+	NextOpening time.Time `json:",omitempty"`
+
+	// Certs are the certificates provisioned ahead of the schedules
+	// opening, sorted by domain.
+	Certs []*FunnelCertStatus `json:",omitempty"`
+}
+
+// FunnelCertStatus is the result of provisioning the certificate of a
+// Funnel domain ahead of its schedule opening.
+type FunnelCertStatus struct {
+	Domain      string
+	Provisioned time.Time // when the certificate was last provisioned
+
+	NotAfter time.Time `json:",omitempty"` // expiry of the certificate
+	Error    string    `json:",omitempty"` // non-empty if provisioning failed
+}
+
 func (pr *PingResult) ToPingResponse(pingType tailcfg.PingType) *tailcfg.PingResponse {
 	return &tailcfg.PingResponse{
 		Type:           pingType,
@@ -46,6 +46,8 @@
 	"tailscale.com/tailfs"
 	"tailscale.com/tka"
 	"tailscale.com/tstime"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/types/ipproto"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
@@ -76,6 +78,7 @@
 
 	// The other /localapi/v0/NAME handlers are exact matches and contain only NAME
 	// without a trailing slash:
+	"bench-peer":                  (*Handler).serveBenchPeer,
 	"bugreport":                   (*Handler).serveBugReport,
 	"check-ip-forwarding":         (*Handler).serveCheckIPForwarding,
 	"check-udp-gro-forwarding":    (*Handler).serveCheckUDPGROForwarding,
@@ -84,6 +87,7 @@
 	"debug":                       (*Handler).serveDebug,
 	"debug-derp-region":           (*Handler).serveDebugDERPRegion,
 	"debug-dial-types":            (*Handler).serveDebugDialTypes,
+	"debug-packet-filter-check":   (*Handler).serveDebugPacketFilterCheck,
 	"debug-packet-filter-matches": (*Handler).serveDebugPacketFilterMatches,
 	"debug-packet-filter-rules":   (*Handler).serveDebugPacketFilterRules,
 	"debug-portmap":               (*Handler).serveDebugPortmap,
@@ -91,6 +95,9 @@
 	"debug-capture":               (*Handler).serveDebugCapture,
 	"debug-log":                   (*Handler).serveDebugLog,
 	"derpmap":                     (*Handler).serveDERPMap,
+	"dns-cache-flush":             (*Handler).serveDNSCacheFlush,
+	"dns-failover-status":         (*Handler).serveDNSFailoverStatus,
+	"dns-query-log":               (*Handler).serveDNSQueryLog,
 	"dev-set-state-store":         (*Handler).serveDevSetStateStore,
 	"set-push-device-token":       (*Handler).serveSetPushDeviceToken,
 	"handle-push-message":         (*Handler).serveHandlePushMessage,
@@ -102,18 +109,27 @@
 	"logout":                      (*Handler).serveLogout,
 	"logtap":                      (*Handler).serveLogTap,
 	"metrics":                     (*Handler).serveMetrics,
+	"path-stats":                  (*Handler).servePathStats,
+	"peer-history":                (*Handler).servePeerHistory,
 	"ping":                        (*Handler).servePing,
 	"prefs":                       (*Handler).servePrefs,
+	"prefs-provenance":            (*Handler).servePrefsProvenance,
 	"pprof":                       (*Handler).servePprof,
 	"reload-config":               (*Handler).reloadConfig,
 	"reset-auth":                  (*Handler).serveResetAuth,
 	"serve-config":                (*Handler).serveServeConfig,
+	"serve-stats":                 (*Handler).serveServeStats,
+	"serve-funnel-schedule":       (*Handler).serveFunnelSchedule,
 	"set-dns":                     (*Handler).serveSetDNS,
 	"set-expiry-sooner":           (*Handler).serveSetExpirySooner,
 	"tailfs/fileserver-address":   (*Handler).serveTailFSFileServerAddr,
 	"tailfs/shares":               (*Handler).serveShares,
+	"tailfs/usage":                (*Handler).serveShareUsage,
+	"tailfs/audit":                (*Handler).serveShareAudit,
+	"tailfs/transfers":            (*Handler).serveTailFSTransfers,
 	"start":                       (*Handler).serveStart,
 	"status":                      (*Handler).serveStatus,
+	"suggest-exit-node":           (*Handler).serveSuggestExitNode,
 	"tka/init":                    (*Handler).serveTKAInit,
 	"tka/log":                     (*Handler).serveTKALog,
 	"tka/modify":                  (*Handler).serveTKAModify,
@@ -127,7 +143,12 @@
 	"tka/generate-recovery-aum":   (*Handler).serveTKAGenerateRecoveryAUM,
 	"tka/cosign-recovery-aum":     (*Handler).serveTKACosignRecoveryAUM,
 	"tka/submit-recovery-aum":     (*Handler).serveTKASubmitRecoveryAUM,
+	"tka/generate-disablements":   (*Handler).serveTKAGenerateDisablements,
+	"tka/check-disablements":      (*Handler).serveTKACheckDisablements,
+	"tka/pending-sigs":            (*Handler).serveTKAPendingSigs,
+	"tka/sign-bulk":               (*Handler).serveTKASignBulk,
 	"upload-client-metrics":       (*Handler).serveUploadClientMetrics,
+	"usage-report":                (*Handler).serveUsageReport,
 	"watch-ipn-bus":               (*Handler).serveWatchIPNBus,
 	"whois":                       (*Handler).serveWhoIs,
 	"query-feature":               (*Handler).serveQueryFeature,
@@ -537,6 +558,71 @@ func (h *Handler) serveLogTap(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// serveDNSQueryLog streams the DNS query log as a sequence of JSON
+// dnstype.QueryLogEntry values: first the queries logged so far, then new
+// ones as they are logged.
+func (h *Handler) serveDNSQueryLog(w http.ResponseWriter, r *http.Request) {
+	// Require write access (~root) as the queried names reveal what
+	// the machine's users are doing.
+	if !h.PermitWrite {
+		http.Error(w, "dns query log access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	if !h.b.Prefs().DNSQueryLog() {
+		http.Error(w, `DNS query log is not enabled; run "tailscale set --dns-query-log" first`, http.StatusPreconditionFailed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+	enc := json.NewEncoder(w)
+	h.b.WatchDNSQueryLog(r.Context(), func(e dnstype.QueryLogEntry) {
+		enc.Encode(e)
+		f.Flush()
+	})
+}
+
+// serveDNSCacheFlush drops the upstream responses cached by the DNS resolver.
+func (h *Handler) serveDNSCacheFlush(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "dns cache flush access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "want POST", http.StatusBadRequest)
+		return
+	}
+	n := h.b.FlushDNSCache()
+	h.logf("flushed %d cached DNS responses", n)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(n)
+}
+
+// serveDNSFailoverStatus returns the state of the split DNS routes whose
+// resolvers fail over from one to the next, as JSON
+// []dnstype.FailoverRouteStatus.
+func (h *Handler) serveDNSFailoverStatus(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "dns failover status access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.b.DNSFailoverStatus())
+}
+
 func (h *Handler) serveMetrics(w http.ResponseWriter, r *http.Request) {
 	// Require write access out of paranoia that the metrics
 	// might contain something sensitive.
@@ -660,6 +746,49 @@ func (h *Handler) serveDebugPacketFilterRules(w http.ResponseWriter, r *http.Req
 	enc.Encode(nm.PacketFilterRules)
 }
 
+// serveDebugPacketFilterCheck evaluates the node's current packet filter for a
+// hypothetical incoming packet described by the "src", "dst", "port" and
+// "proto" (default "tcp") query parameters, without sending any packets.
+func (h *Handler) serveDebugPacketFilterCheck(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	src, err := netip.ParseAddr(r.FormValue("src"))
+	if err != nil {
+		http.Error(w, "invalid src: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	dst, err := netip.ParseAddr(r.FormValue("dst"))
+	if err != nil {
+		http.Error(w, "invalid dst: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var port uint16
+	if v := r.FormValue("port"); v != "" {
+		p, err := strconv.ParseUint(v, 10, 16)
+		if err != nil {
+			http.Error(w, "invalid port: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		port = uint16(p)
+	}
+	proto := ipproto.TCP
+	if v := r.FormValue("proto"); v != "" {
+		if err := proto.UnmarshalText([]byte(v)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	res, err := h.b.CheckPacketFilter(src, dst, port, proto)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
 func (h *Handler) serveDebugPacketFilterMatches(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "debug access denied", http.StatusForbidden)
@@ -971,6 +1100,51 @@ func (h *Handler) serveResetAuth(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// servePathStats returns the statistics of the paths to each peer, in the
+// stable ipnstate.PathStats schema.
+func (h *Handler) servePathStats(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "path stats access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.b.PathStats())
+}
+
+// serveServeStats returns the request counters and recent requests of the
+// serve and funnel web handlers.
+func (h *Handler) serveServeStats(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "serve stats access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.b.ServeStats())
+}
+
+// serveFunnelSchedule returns the state of the Funnel schedules of the serve
+// config and the certificates provisioned ahead of them opening.
+func (h *Handler) serveFunnelSchedule(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "funnel schedule access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.b.FunnelScheduleStatus())
+}
+
 func (h *Handler) serveServeConfig(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
@@ -1187,6 +1361,26 @@ func (h *Handler) serveCheckUDPGROForwarding(w http.ResponseWriter, r *http.Requ
 	})
 }
 
+// serveSuggestExitNode returns the available exit nodes, best first, as
+// determined by LocalBackend.SuggestExitNodes.
+func (h *Handler) serveSuggestExitNode(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "suggest-exit-node access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusBadRequest)
+		return
+	}
+	res, err := h.b.SuggestExitNodes(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
 func (h *Handler) serveStatus(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitRead {
 		http.Error(w, "status access denied", http.StatusForbidden)
@@ -1232,6 +1426,53 @@ func (h *Handler) serveDebugPeerEndpointChanges(w http.ResponseWriter, r *http.R
 	e.Encode(chs)
 }
 
+// servePeerHistory returns the connectivity transitions of the peer with the
+// Tailscale IP in the "ip" parameter over the last hour.
+func (h *Handler) servePeerHistory(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "status access denied", http.StatusForbidden)
+		return
+	}
+	ipStr := r.FormValue("ip")
+	if ipStr == "" {
+		http.Error(w, "missing 'ip' parameter", http.StatusBadRequest)
+		return
+	}
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		http.Error(w, "invalid IP", http.StatusBadRequest)
+		return
+	}
+	evs, err := h.b.PeerConnHistory(r.Context(), ip)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(evs)
+}
+
+// serveUsageReport returns the local usage report of the current week, or
+// of the previous week if the "last" parameter is true.
+func (h *Handler) serveUsageReport(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "usage report access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusMethodNotAllowed)
+		return
+	}
+	last, _ := strconv.ParseBool(r.FormValue("last"))
+	rep, err := h.b.UsageReport(last)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
+
 // InUseOtherUserIPNStream reports whether r is a request for the watch-ipn-bus
 // handler. If so, it writes an ipn.Notify InUseOtherUser message to the user
 // and returns true. Otherwise it returns false, in which case it doesn't write
@@ -1248,6 +1489,7 @@ func InUseOtherUserIPNStream(w http.ResponseWriter, r *http.Request, err error)
 		Version:    version.Long(),
 		State:      ptr.To(ipn.InUseOtherUser),
 		ErrMessage: ptr.To(err.Error()),
+		ErrorCode:  ipn.ErrorCodeInUseOtherUser,
 	})
 	if err != nil {
 		return false
@@ -1398,6 +1640,23 @@ type resJSON struct {
 	Error string `json:",omitempty"`
 }
 
+// servePrefsProvenance returns, for each preference, where its current value
+// comes from: the user, a system policy, or the tailnet default.
+func (h *Handler) servePrefsProvenance(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "prefs access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(h.b.PrefsProvenance())
+}
+
 func (h *Handler) serveCheckPrefs(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "checkprefs access denied", http.StatusForbidden)
@@ -1742,6 +2001,34 @@ func (h *Handler) servePing(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
+func (h *Handler) serveBenchPeer(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "bench access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "want POST", http.StatusBadRequest)
+		return
+	}
+	ip, err := netip.ParseAddr(r.FormValue("ip"))
+	if err != nil {
+		http.Error(w, "invalid or missing 'ip' parameter", http.StatusBadRequest)
+		return
+	}
+	d, err := time.ParseDuration(r.FormValue("duration"))
+	if err != nil {
+		http.Error(w, "invalid or missing 'duration' parameter", http.StatusBadRequest)
+		return
+	}
+	res, err := h.b.BenchPeer(r.Context(), ip, d)
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
 func (h *Handler) serveDial(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "POST required", http.StatusMethodNotAllowed)
@@ -1932,6 +2219,45 @@ type signRequest struct {
 	w.WriteHeader(http.StatusOK)
 }
 
+func (h *Handler) serveTKASignBulk(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "lock sign access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	type signRequest struct {
+		NodeKey        key.NodePublic
+		RotationPublic []byte
+	}
+	type signResult struct {
+		NodeKey key.NodePublic
+		Error   string `json:",omitempty"`
+	}
+	var req []signRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	// Each node is signed separately, so that a node that fails to be
+	// signed (for example because it's already signed by a key that was
+	// since removed) doesn't prevent the others from being signed.
+	res := make([]signResult, len(req))
+	for i, sr := range req {
+		res[i].NodeKey = sr.NodeKey
+		if err := h.b.NetworkLockSign(sr.NodeKey, sr.RotationPublic); err != nil {
+			res[i].Error = err.Error()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
 func (h *Handler) serveTKAInit(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "lock init access denied", http.StatusForbidden)
@@ -2081,6 +2407,87 @@ func (h *Handler) serveTKADisable(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+func (h *Handler) serveTKAGenerateDisablements(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "network-lock modify access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Count int
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 512)).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	secrets, values, err := h.b.NetworkLockGenerateDisablements(req.Count)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Secrets [][]byte
+		Values  [][]byte
+	}{secrets, values})
+}
+
+func (h *Handler) serveTKACheckDisablements(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "network-lock modify access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Secrets [][]byte
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	valid, err := h.b.NetworkLockCheckDisablements(req.Secrets)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(valid)
+}
+
+func (h *Handler) serveTKAPendingSigs(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "lock status access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pending, err := h.b.NetworkLockPendingSigs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	j, err := json.MarshalIndent(pending, "", "\t")
+	if err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
+
 func (h *Handler) serveTKALocalDisable(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "network-lock modify access denied", http.StatusForbidden)
@@ -2300,6 +2707,21 @@ func (h *Handler) serveProfiles(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad profile ID", http.StatusBadRequest)
 		return
 	}
+	if suffix == "all" {
+		switch r.Method {
+		case httpm.GET:
+			// Other users' profiles are none of a regular user's business.
+			if !h.connIsLocalAdmin() {
+				http.Error(w, "listing the profiles of all users requires administrator access", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(h.b.ListAllProfiles())
+		default:
+			http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		}
+		return
+	}
 	if suffix == "current" {
 		switch r.Method {
 		case httpm.GET:
@@ -2587,6 +3009,27 @@ func (h *Handler) serveShares(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		w.WriteHeader(http.StatusCreated)
+	case "POST":
+		// Rename the share named names[0] to names[1].
+		var names [2]string
+		err := json.NewDecoder(r.Body).Decode(&names)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = h.b.TailFSRenameShare(names[0], names[1])
+		if err != nil {
+			switch {
+			case os.IsNotExist(err):
+				http.Error(w, "share not found", http.StatusNotFound)
+			case os.IsExist(err):
+				http.Error(w, "share already exists", http.StatusConflict)
+			default:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
 	case "DELETE":
 		var share tailfs.Share
 		err := json.NewDecoder(r.Body).Decode(&share)
@@ -2620,6 +3063,99 @@ func (h *Handler) serveShares(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// serveShareUsage reports how much each tailfs share is being used.
+func (h *Handler) serveShareUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.b.TailFSSharingEnabled() {
+		http.Error(w, `tailfs sharing not enabled, please add the attribute "tailfs:share" to this node in your ACLs' "nodeAttrs" section`, http.StatusInternalServerError)
+		return
+	}
+	usage, err := h.b.TailFSShareUsage(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
+
+// serveShareAudit returns the audit log of remote operations on tailfs
+// shares, optionally only those after the RFC 3339 time in the "since" query
+// parameter.
+func (h *Handler) serveShareAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "only GET allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.PermitRead {
+		http.Error(w, "audit log access denied", http.StatusForbidden)
+		return
+	}
+	var since time.Time
+	if v := r.FormValue("since"); v != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			http.Error(w, "invalid since: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	entries, err := h.b.TailFSAuditLog(since)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []*tailfs.AuditEntry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// serveTailFSTransfers lists the files being read or written on remote tailfs
+// shares by local clients, or, for DELETE, cancels the transfer whose ID is
+// in the "id" query parameter.
+func (h *Handler) serveTailFSTransfers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if !h.PermitRead {
+			http.Error(w, "transfers access denied", http.StatusForbidden)
+			return
+		}
+		transfers, err := h.b.TailFSTransfers()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(transfers)
+	case "DELETE":
+		if !h.PermitWrite {
+			http.Error(w, "transfers access denied", http.StatusForbidden)
+			return
+		}
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+		if err := h.b.TailFSCancelTransfer(id); err != nil {
+			if os.IsNotExist(err) {
+				http.Error(w, "transfer not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+	}
+}
+
 var (
 	metricInvalidRequests = clientmetric.NewCounter("localapi_invalid_requests")
 
@@ -5,15 +5,19 @@
 
 import (
 	"bytes"
+	"cmp"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"net/netip"
 	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 
 	"tailscale.com/atomicfile"
@@ -107,6 +111,14 @@ type Prefs struct {
 	// routed directly or via the exit node.
 	ExitNodeAllowLANAccess bool
 
+	// ExitNodeAllowedLANRoutes are local network prefixes that should be
+	// routed directly rather than via the exit node, even when
+	// ExitNodeAllowLANAccess is false. This allows access to e.g. a LAN
+	// printer subnet without exposing the rest of the local network.
+	// It has no effect if ExitNodeAllowLANAccess is true, as all local
+	// networks are then routed directly.
+	ExitNodeAllowedLANRoutes []netip.Prefix `json:",omitempty"`
+
 	// CorpDNS specifies whether to install the Tailscale network's
 	// DNS configuration, if it exists.
 	CorpDNS bool
@@ -222,6 +234,30 @@ type Prefs struct {
 	// Linux-only.
 	NetfilterKind string
 
+	// UsageReports specifies whether to keep a weekly local summary of the
+	// node's tailnet usage (top peers by traffic, services accessed, Funnel
+	// hits and new devices seen), for self-audit with "tailscale report".
+	// Nothing is sent off the machine.
+	UsageReports bool
+
+	// DNSQueryLog specifies whether to keep an in-memory log of the
+	// recent DNS queries handled by Tailscale's DNS resolver, for
+	// debugging DNS routing with "tailscale debug dns-query-log".
+	DNSQueryLog bool
+
+	// RouteMTUs are the MTUs to use for specific routes through Tailscale,
+	// like subnet routes to networks with a smaller MTU than the
+	// Tailscale interface's. The OS then uses smaller packets, and TCP a
+	// smaller MSS, for traffic to those routes, which avoids fragmentation
+	// and the stalls it can cause. A route through Tailscale uses the MTU
+	// of the most specific prefix listed that covers it, or the interface
+	// MTU if there's none. A listed prefix that is more specific than a
+	// route through Tailscale is routed through Tailscale on its own, with
+	// its MTU. Prefixes that aren't routed through Tailscale are ignored.
+	//
+	// Linux-only.
+	RouteMTUs map[netip.Prefix]int `json:",omitempty"`
+
 	// The Persist field is named 'Config' in the file for backward
 	// compatibility with earlier versions.
 	// TODO(apenwarr): We should move this out of here, it's not a pref.
@@ -230,6 +266,22 @@ type Prefs struct {
 	Persist *persist.Persist `json:"Config"`
 }
 
+// PrefSource describes where the current value of a preference comes from,
+// so that GUIs can explain, and not offer to change, enforced settings.
+type PrefSource string
+
+const (
+	// PrefSourceUser means the value was set by the user, or is the
+	// default.
+	PrefSourceUser PrefSource = "user"
+	// PrefSourceSysPolicy means the value is enforced by a system policy,
+	// such as one set by MDM, and can't be changed by the user.
+	PrefSourceSysPolicy PrefSource = "syspolicy"
+	// PrefSourceTailnetDefault means the value is the tailnet-wide default
+	// sent by the control plane. The user may still change it.
+	PrefSourceTailnetDefault PrefSource = "tailnet-default"
+)
+
 // AutoUpdatePrefs are the auto update settings for the node agent.
 type AutoUpdatePrefs struct {
 	// Check specifies whether background checks for updates are enabled. When
@@ -267,32 +319,36 @@ type AppConnectorPrefs struct {
 type MaskedPrefs struct {
 	Prefs
 
-	ControlURLSet             bool                `json:",omitempty"`
-	RouteAllSet               bool                `json:",omitempty"`
-	AllowSingleHostsSet       bool                `json:",omitempty"`
-	ExitNodeIDSet             bool                `json:",omitempty"`
-	ExitNodeIPSet             bool                `json:",omitempty"`
-	ExitNodeAllowLANAccessSet bool                `json:",omitempty"`
-	CorpDNSSet                bool                `json:",omitempty"`
-	RunSSHSet                 bool                `json:",omitempty"`
-	RunWebClientSet           bool                `json:",omitempty"`
-	WantRunningSet            bool                `json:",omitempty"`
-	LoggedOutSet              bool                `json:",omitempty"`
-	ShieldsUpSet              bool                `json:",omitempty"`
-	AdvertiseTagsSet          bool                `json:",omitempty"`
-	HostnameSet               bool                `json:",omitempty"`
-	NotepadURLsSet            bool                `json:",omitempty"`
-	ForceDaemonSet            bool                `json:",omitempty"`
-	EggSet                    bool                `json:",omitempty"`
-	AdvertiseRoutesSet        bool                `json:",omitempty"`
-	NoSNATSet                 bool                `json:",omitempty"`
-	NetfilterModeSet          bool                `json:",omitempty"`
-	OperatorUserSet           bool                `json:",omitempty"`
-	ProfileNameSet            bool                `json:",omitempty"`
-	AutoUpdateSet             AutoUpdatePrefsMask `json:",omitempty"`
-	AppConnectorSet           bool                `json:",omitempty"`
-	PostureCheckingSet        bool                `json:",omitempty"`
-	NetfilterKindSet          bool                `json:",omitempty"`
+	ControlURLSet               bool                `json:",omitempty"`
+	RouteAllSet                 bool                `json:",omitempty"`
+	AllowSingleHostsSet         bool                `json:",omitempty"`
+	ExitNodeIDSet               bool                `json:",omitempty"`
+	ExitNodeIPSet               bool                `json:",omitempty"`
+	ExitNodeAllowLANAccessSet   bool                `json:",omitempty"`
+	ExitNodeAllowedLANRoutesSet bool                `json:",omitempty"`
+	CorpDNSSet                  bool                `json:",omitempty"`
+	RunSSHSet                   bool                `json:",omitempty"`
+	RunWebClientSet             bool                `json:",omitempty"`
+	WantRunningSet              bool                `json:",omitempty"`
+	LoggedOutSet                bool                `json:",omitempty"`
+	ShieldsUpSet                bool                `json:",omitempty"`
+	AdvertiseTagsSet            bool                `json:",omitempty"`
+	HostnameSet                 bool                `json:",omitempty"`
+	NotepadURLsSet              bool                `json:",omitempty"`
+	ForceDaemonSet              bool                `json:",omitempty"`
+	EggSet                      bool                `json:",omitempty"`
+	AdvertiseRoutesSet          bool                `json:",omitempty"`
+	NoSNATSet                   bool                `json:",omitempty"`
+	NetfilterModeSet            bool                `json:",omitempty"`
+	OperatorUserSet             bool                `json:",omitempty"`
+	ProfileNameSet              bool                `json:",omitempty"`
+	AutoUpdateSet               AutoUpdatePrefsMask `json:",omitempty"`
+	AppConnectorSet             bool                `json:",omitempty"`
+	PostureCheckingSet          bool                `json:",omitempty"`
+	NetfilterKindSet            bool                `json:",omitempty"`
+	UsageReportsSet             bool                `json:",omitempty"`
+	DNSQueryLogSet              bool                `json:",omitempty"`
+	RouteMTUsSet                bool                `json:",omitempty"`
 }
 
 type AutoUpdatePrefsMask struct {
@@ -472,6 +528,9 @@ func (p *Prefs) pretty(goos string) string {
 	} else if !p.ExitNodeID.IsZero() {
 		fmt.Fprintf(&sb, "exit=%v lan=%t ", p.ExitNodeID, p.ExitNodeAllowLANAccess)
 	}
+	if (p.ExitNodeIP.IsValid() || !p.ExitNodeID.IsZero()) && len(p.ExitNodeAllowedLANRoutes) > 0 {
+		fmt.Fprintf(&sb, "lanroutes=%v ", p.ExitNodeAllowedLANRoutes)
+	}
 	if len(p.AdvertiseRoutes) > 0 || goos == "linux" {
 		fmt.Fprintf(&sb, "routes=%v ", p.AdvertiseRoutes)
 	}
@@ -481,6 +540,9 @@ func (p *Prefs) pretty(goos string) string {
 	if len(p.AdvertiseTags) > 0 {
 		fmt.Fprintf(&sb, "tags=%s ", strings.Join(p.AdvertiseTags, ","))
 	}
+	if len(p.RouteMTUs) > 0 {
+		fmt.Fprintf(&sb, "routemtus=%s ", FormatRouteMTUs(p.RouteMTUs))
+	}
 	if goos == "linux" {
 		fmt.Fprintf(&sb, "nf=%v ", p.NetfilterMode)
 	}
@@ -537,6 +599,7 @@ func (p *Prefs) Equals(p2 *Prefs) bool {
 		p.ExitNodeID == p2.ExitNodeID &&
 		p.ExitNodeIP == p2.ExitNodeIP &&
 		p.ExitNodeAllowLANAccess == p2.ExitNodeAllowLANAccess &&
+		compareIPNets(p.ExitNodeAllowedLANRoutes, p2.ExitNodeAllowedLANRoutes) &&
 		p.CorpDNS == p2.CorpDNS &&
 		p.RunSSH == p2.RunSSH &&
 		p.RunWebClient == p2.RunWebClient &&
@@ -556,9 +619,73 @@ func (p *Prefs) Equals(p2 *Prefs) bool {
 		p.AutoUpdate.Equals(p2.AutoUpdate) &&
 		p.AppConnector == p2.AppConnector &&
 		p.PostureChecking == p2.PostureChecking &&
-		p.NetfilterKind == p2.NetfilterKind
+		p.NetfilterKind == p2.NetfilterKind &&
+		p.UsageReports == p2.UsageReports &&
+		p.DNSQueryLog == p2.DNSQueryLog &&
+		maps.Equal(p.RouteMTUs, p2.RouteMTUs)
+}
+
+// FormatRouteMTUs formats m, a Prefs.RouteMTUs value, as a comma-separated
+// list of route=MTU pairs sorted by route, like
+// "10.0.0.0/16=1280,192.168.1.0/24=1400". It's the inverse of
+// ParseRouteMTUs.
+func FormatRouteMTUs(m map[netip.Prefix]int) string {
+	routes := make([]netip.Prefix, 0, len(m))
+	for r := range m {
+		routes = append(routes, r)
+	}
+	slices.SortFunc(routes, func(a, b netip.Prefix) int {
+		return cmp.Or(a.Addr().Compare(b.Addr()), cmp.Compare(a.Bits(), b.Bits()))
+	})
+	var sb strings.Builder
+	for i, r := range routes {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, "%v=%d", r, m[r])
+	}
+	return sb.String()
 }
 
+// ParseRouteMTUs parses s, a comma-separated list of route=MTU pairs like
+// "10.0.0.0/16=1280", as a Prefs.RouteMTUs value. The empty string parses
+// as no route MTUs.
+func ParseRouteMTUs(s string) (map[netip.Prefix]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	m := make(map[netip.Prefix]int)
+	for _, kv := range strings.Split(s, ",") {
+		rs, mtus, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not of the form route=MTU", kv)
+		}
+		r, err := netip.ParsePrefix(rs)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid CIDR prefix", rs)
+		}
+		if r != r.Masked() {
+			return nil, fmt.Errorf("%s has non-address bits set; expected %s", r, r.Masked())
+		}
+		mtu, err := strconv.Atoi(mtus)
+		if err != nil || mtu < minRouteMTU || mtu > maxRouteMTU {
+			return nil, fmt.Errorf("invalid MTU %q for %s; want a number between %d and %d", mtus, r, minRouteMTU, maxRouteMTU)
+		}
+		if _, dup := m[r]; dup {
+			return nil, fmt.Errorf("duplicate MTU for %s", r)
+		}
+		m[r] = mtu
+	}
+	return m, nil
+}
+
+// minRouteMTU and maxRouteMTU bound the MTUs of Prefs.RouteMTUs. The
+// minimum is IPv6's minimum MTU, and the maximum is the largest IP packet.
+const (
+	minRouteMTU = 1280
+	maxRouteMTU = 65535
+)
+
 func (au AutoUpdatePrefs) Pretty() string {
 	if au.Apply.EqualBool(true) {
 		return "update=on "
@@ -944,3 +1071,24 @@ type LoginProfile struct {
 	// into.
 	ControlURL string
 }
+
+// ProfileOwnership describes a LoginProfile and the local user that owns it.
+// It is returned when listing the profiles of all local users, which is only
+// meaningful on Windows where multiple users share one tailscaled.
+type ProfileOwnership struct {
+	Profile LoginProfile
+
+	// OwnerName is the username of Profile.LocalUserID, if it could be
+	// looked up.
+	OwnerName string `json:",omitempty"`
+
+	// OwnedByCurrentUser is whether the profile belongs to the local user
+	// currently using tailscaled. Only those profiles can be switched to
+	// or modified.
+	OwnedByCurrentUser bool
+
+	// Current is whether the profile is the one tailscaled is currently
+	// running with. If it's not owned by the current user, tailscaled is
+	// running it in the background in unattended mode.
+	Current bool
+}
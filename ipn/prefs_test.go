@@ -42,6 +42,7 @@ func TestPrefsEqual(t *testing.T) {
 		"ExitNodeID",
 		"ExitNodeIP",
 		"ExitNodeAllowLANAccess",
+		"ExitNodeAllowedLANRoutes",
 		"CorpDNS",
 		"RunSSH",
 		"RunWebClient",
@@ -62,6 +63,9 @@ func TestPrefsEqual(t *testing.T) {
 		"AppConnector",
 		"PostureChecking",
 		"NetfilterKind",
+		"UsageReports",
+		"DNSQueryLog",
+		"RouteMTUs",
 		"Persist",
 	}
 	if have := fieldsOf(reflect.TypeFor[Prefs]()); !reflect.DeepEqual(have, prefsHandles) {
@@ -164,6 +168,16 @@ func TestPrefsEqual(t *testing.T) {
 			&Prefs{ExitNodeAllowLANAccess: true},
 			true,
 		},
+		{
+			&Prefs{ExitNodeAllowedLANRoutes: []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")}},
+			&Prefs{ExitNodeAllowedLANRoutes: []netip.Prefix{netip.MustParsePrefix("192.168.2.0/24")}},
+			false,
+		},
+		{
+			&Prefs{ExitNodeAllowedLANRoutes: []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")}},
+			&Prefs{ExitNodeAllowedLANRoutes: []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")}},
+			true,
+		},
 
 		{
 			&Prefs{CorpDNS: true},
@@ -329,6 +343,26 @@ func TestPrefsEqual(t *testing.T) {
 			&Prefs{PostureChecking: false},
 			false,
 		},
+		{
+			&Prefs{UsageReports: true},
+			&Prefs{UsageReports: false},
+			false,
+		},
+		{
+			&Prefs{DNSQueryLog: true},
+			&Prefs{DNSQueryLog: false},
+			false,
+		},
+		{
+			&Prefs{RouteMTUs: map[netip.Prefix]int{netip.MustParsePrefix("10.0.0.0/16"): 1280}},
+			&Prefs{RouteMTUs: map[netip.Prefix]int{netip.MustParsePrefix("10.0.0.0/16"): 1400}},
+			false,
+		},
+		{
+			&Prefs{RouteMTUs: map[netip.Prefix]int{netip.MustParsePrefix("10.0.0.0/16"): 1280}},
+			&Prefs{RouteMTUs: map[netip.Prefix]int{netip.MustParsePrefix("10.0.0.0/16"): 1280}},
+			true,
+		},
 		{
 			&Prefs{NetfilterKind: "iptables"},
 			&Prefs{NetfilterKind: "iptables"},
@@ -505,6 +539,24 @@ func TestPrefsPretty(t *testing.T) {
 			"linux",
 			`Prefs{ra=false mesh=false dns=false want=false exit=myNodeABC lan=true routes=[] nf=off update=off Persist=nil}`,
 		},
+		{
+			Prefs{
+				ExitNodeID:               tailcfg.StableNodeID("myNodeABC"),
+				ExitNodeAllowedLANRoutes: []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")},
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false exit=myNodeABC lan=false lanroutes=[192.168.1.0/24] routes=[] nf=off update=off Persist=nil}`,
+		},
+		{
+			Prefs{
+				RouteMTUs: map[netip.Prefix]int{
+					netip.MustParsePrefix("192.168.1.0/24"): 1400,
+					netip.MustParsePrefix("10.0.0.0/16"):    1280,
+				},
+			},
+			"linux",
+			`Prefs{ra=false mesh=false dns=false want=false routes=[] routemtus=10.0.0.0/16=1280,192.168.1.0/24=1400 nf=off update=off Persist=nil}`,
+		},
 		{
 			Prefs{
 				ExitNodeAllowLANAccess: true,
@@ -580,6 +632,41 @@ func TestPrefsPretty(t *testing.T) {
 	}
 }
 
+func TestParseRouteMTUs(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[netip.Prefix]int
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "10.0.0.0/16=1280", want: map[netip.Prefix]int{netip.MustParsePrefix("10.0.0.0/16"): 1280}},
+		{in: "10.0.0.0/16=1280,fd00::/64=1400", want: map[netip.Prefix]int{
+			netip.MustParsePrefix("10.0.0.0/16"): 1280,
+			netip.MustParsePrefix("fd00::/64"):   1400,
+		}},
+		{in: "10.0.0.0/16", wantErr: true},
+		{in: "10.0.0.1/16=1280", wantErr: true},
+		{in: "10.0.0.0/16=576", wantErr: true},
+		{in: "10.0.0.0/16=big", wantErr: true},
+		{in: "10.0.0.0/16=1280,10.0.0.0/16=1400", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRouteMTUs(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRouteMTUs(%q) error = %v; want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseRouteMTUs(%q) = %v; want %v", tt.in, got, tt.want)
+		}
+		if err == nil {
+			if s := FormatRouteMTUs(got); s != tt.in {
+				t.Errorf("FormatRouteMTUs(ParseRouteMTUs(%q)) = %q", tt.in, s)
+			}
+		}
+	}
+}
+
 func TestLoadPrefsNotExist(t *testing.T) {
 	bogusFile := fmt.Sprintf("/tmp/not-exist-%d", time.Now().UnixNano())
 
@@ -9,6 +9,7 @@
 	"net"
 	"net/netip"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -37,6 +38,11 @@ type ServeConfig struct {
 	// traffic is allowed, from trusted ingress peers.
 	AllowFunnel map[HostPort]bool `json:",omitempty"`
 
+	// FunnelSchedule, if non-empty, restricts the Funnel traffic allowed by
+	// AllowFunnel to the union of these windows of time. Certificates for
+	// the Funnel domains are provisioned ahead of each window opening.
+	FunnelSchedule []FunnelWindow `json:",omitempty"`
+
 	// Foreground is a map of an IPN Bus session ID to an alternate foreground
 	// serve config that's valid for the life of that WatchIPNBus session ID.
 	// This. This allows the config to specify ephemeral configs that are
@@ -111,7 +117,8 @@ type TCPPortHandler struct {
 	// It is mutually exclusive with TCPForward.
 	HTTP bool `json:",omitempty"`
 
-	// TCPForward is the IP:port to forward TCP connections to.
+	// TCPForward is the IP:port to forward TCP connections to, or a local
+	// socket as described by ParseProxySocket.
 	// Whether or not TLS is terminated by tailscaled depends on
 	// TerminateTLS.
 	//
@@ -123,6 +130,11 @@ type TCPPortHandler struct {
 	// SNI name with this value. It is only used if TCPForward is non-empty.
 	// (the HTTPS mode uses ServeConfig.Web)
 	TerminateTLS string `json:",omitempty"`
+
+	// Allow, if non-nil, restricts the connections forwarded to TCPForward
+	// to those from the tailnet identities it lists. Other connections,
+	// including those from Funnel, are closed.
+	Allow *ServeAccess `json:",omitempty"`
 }
 
 // HTTPHandler is either a path or a proxy to serve.
@@ -130,14 +142,89 @@ type HTTPHandler struct {
 	// Exactly one of the following may be set.
 
 	Path  string `json:",omitempty"` // absolute path to directory or file to serve
-	Proxy string `json:",omitempty"` // http://localhost:3000/, localhost:3030, 3030
+	Proxy string `json:",omitempty"` // http://localhost:3000/, localhost:3030, 3030, unix:/run/app.sock
 
 	Text string `json:",omitempty"` // plaintext to serve (primarily for testing)
 
+	// Allow, if non-nil, restricts the requests served by this handler to
+	// those from the tailnet identities it lists. Other requests,
+	// including those from Funnel, get a 403 Forbidden.
+	Allow *ServeAccess `json:",omitempty"`
+
 	// TODO(bradfitz): bool to not enumerate directories? TTL on mapping for
 	// temporary ones? Error codes? Redirects?
 }
 
+// ServeAccess is an allow list of the tailnet identities permitted to use a
+// serve handler. A caller is permitted if it's one of Users or if its node
+// has one of Tags.
+//
+// A nil *ServeAccess permits everyone; a non-nil one with no entries
+// permits no one.
+type ServeAccess struct {
+	// Users are the login names of the permitted users, like
+	// "alice@example.com". They're compared case-insensitively.
+	Users []string `json:",omitempty"`
+
+	// Tags are the permitted node tags, like "tag:monitoring".
+	Tags []string `json:",omitempty"`
+}
+
+// Permits reports whether a caller with the given login name and node tags
+// is permitted by a. The login name of tagged nodes should be empty.
+func (a *ServeAccess) Permits(login string, tags []string) bool {
+	if a == nil {
+		return true
+	}
+	if login != "" {
+		for _, u := range a.Users {
+			if strings.EqualFold(u, login) {
+				return true
+			}
+		}
+	}
+	for _, t := range tags {
+		if slices.Contains(a.Tags, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Permits reports whether a caller with the given login name and node tags
+// is permitted by v. An invalid view permits everyone.
+func (v ServeAccessView) Permits(login string, tags []string) bool { return v.ж.Permits(login, tags) }
+
+// ParseProxySocket reports whether target, an HTTPHandler.Proxy or
+// TCPPortHandler.TCPForward value, is a local socket rather than a TCP
+// address: "unix:/path/to/socket" for a Unix socket, or
+// `npipe:\\.\pipe\name` (also written "npipe:////./pipe/name") for a
+// Windows named pipe. It returns the network, "unix" or "npipe", and the
+// path of the socket.
+func ParseProxySocket(target string) (network, path string, ok bool) {
+	if p, ok := strings.CutPrefix(target, "unix:"); ok {
+		if !strings.HasPrefix(p, "/") {
+			return "", "", false
+		}
+		return "unix", p, true
+	}
+	if p, ok := strings.CutPrefix(target, "npipe:"); ok {
+		p = `\\` + strings.TrimLeft(strings.ReplaceAll(p, "/", `\`), `\`)
+		name, ok := strings.CutPrefix(p, `\\.\pipe\`)
+		if !ok || name == "" {
+			return "", "", false
+		}
+		return "npipe", p, true
+	}
+	return "", "", false
+}
+
+// IsProxySocket reports whether target looks like a local socket for
+// ParseProxySocket, even if it's invalid.
+func IsProxySocket(target string) bool {
+	return strings.HasPrefix(target, "unix:") || strings.HasPrefix(target, "npipe:")
+}
+
 // WebHandlerExists reports whether if the ServeConfig Web handler exists for
 // the given host:port and mount point.
 func (sc *ServeConfig) WebHandlerExists(hp HostPort, mount string) bool {
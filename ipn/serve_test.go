@@ -126,3 +126,61 @@ func TestHasPathHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestParseProxySocket(t *testing.T) {
+	tests := []struct {
+		target      string
+		wantNetwork string
+		wantPath    string
+		wantOK      bool
+	}{
+		{"unix:/run/app.sock", "unix", "/run/app.sock", true},
+		{"unix:app.sock", "", "", false},
+		{"unix:", "", "", false},
+		{`npipe:\\.\pipe\app`, "npipe", `\\.\pipe\app`, true},
+		{"npipe:////./pipe/docker_engine", "npipe", `\\.\pipe\docker_engine`, true},
+		{`npipe:\\.\pipe\`, "", "", false},
+		{`npipe:\\server\share`, "", "", false},
+		{"http://localhost:3000", "", "", false},
+		{"127.0.0.1:3000", "", "", false},
+	}
+	for _, tt := range tests {
+		network, path, ok := ParseProxySocket(tt.target)
+		if network != tt.wantNetwork || path != tt.wantPath || ok != tt.wantOK {
+			t.Errorf("ParseProxySocket(%q) = %q, %q, %v; want %q, %q, %v", tt.target, network, path, ok, tt.wantNetwork, tt.wantPath, tt.wantOK)
+		}
+	}
+}
+
+func TestServeAccessPermits(t *testing.T) {
+	allow := &ServeAccess{
+		Users: []string{"alice@example.com"},
+		Tags:  []string{"tag:monitoring"},
+	}
+	tests := []struct {
+		name  string
+		allow *ServeAccess
+		login string
+		tags  []string
+		want  bool
+	}{
+		{"nil-permits-all", nil, "", nil, true},
+		{"empty-permits-none", &ServeAccess{}, "alice@example.com", nil, false},
+		{"user", allow, "alice@example.com", nil, true},
+		{"user-case-insensitive", allow, "Alice@Example.com", nil, true},
+		{"other-user", allow, "bob@example.com", nil, false},
+		{"tag", allow, "", []string{"tag:web", "tag:monitoring"}, true},
+		{"other-tag", allow, "", []string{"tag:web"}, false},
+		{"no-identity", allow, "", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.allow.Permits(tt.login, tt.tags); got != tt.want {
+				t.Errorf("Permits(%q, %q) = %v; want %v", tt.login, tt.tags, got, tt.want)
+			}
+			if got := tt.allow.View().Permits(tt.login, tt.tags); got != tt.want {
+				t.Errorf("view Permits(%q, %q) = %v; want %v", tt.login, tt.tags, got, tt.want)
+			}
+		})
+	}
+}
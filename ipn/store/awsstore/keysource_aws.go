@@ -0,0 +1,59 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux && !ts_omit_aws
+
+package awsstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"tailscale.com/ipn/store/encstore"
+)
+
+func init() {
+	encstore.RegisterKeySource("awsssm", func(ctx context.Context, paramARN string) ([]byte, error) {
+		return loadSSMSecret(ctx, paramARN, nil)
+	})
+}
+
+// loadSSMSecret returns the value of the AWS SSM parameter paramARN, which
+// is meant to be a SecureString, so that AWS KMS decrypts it for us and
+// access to the state encryption key can be limited with KMS key policies.
+// If client is nil, one is made for the parameter's region.
+func loadSSMSecret(ctx context.Context, paramARN string, client awsSSMClient) ([]byte, error) {
+	a, err := arn.Parse(paramARN)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the ARN correctly: %v", err)
+	}
+	if a.Service != "ssm" {
+		return nil, fmt.Errorf("invalid service %q, expected 'ssm'", a.Service)
+	}
+	m := parameterNameRx.FindStringSubmatch(a.Resource)
+	if m == nil {
+		return nil, fmt.Errorf("invalid resource %q, expected to match %v", a.Resource, parameterNameRxStr)
+	}
+	if client == nil {
+		cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(a.Region))
+		if err != nil {
+			return nil, err
+		}
+		client = ssm.NewFromConfig(cfg)
+	}
+	param, err := client.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(m[1]),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if param.Parameter == nil || param.Parameter.Value == nil {
+		return nil, fmt.Errorf("parameter %q has no value", m[1])
+	}
+	return []byte(*param.Parameter.Value), nil
+}
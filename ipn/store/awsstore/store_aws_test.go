@@ -162,3 +162,25 @@ func testStoreSemantics(t *testing.T, store ipn.StateStore) {
 		}
 	}
 }
+
+func TestLoadSSMSecret(t *testing.T) {
+	mc := &mockedAWSSSMClient{value: "hunter2"}
+	got, err := loadSSMSecret(context.Background(), "arn:aws:ssm:eu-west-1:123456789:parameter/ts-state-key", mc)
+	if err != nil || string(got) != "hunter2" {
+		t.Errorf("loadSSMSecret = %q, %v; want hunter2", got, err)
+	}
+
+	for _, bad := range []string{
+		"not-an-arn",
+		"arn:aws:s3:eu-west-1:123456789:parameter/ts-state-key",
+		"arn:aws:ssm:eu-west-1:123456789:document/ts-state-key",
+	} {
+		if _, err := loadSSMSecret(context.Background(), bad, mc); err == nil {
+			t.Errorf("loadSSMSecret(%q) succeeded; want error", bad)
+		}
+	}
+
+	if _, err := loadSSMSecret(context.Background(), "arn:aws:ssm:eu-west-1:123456789:parameter/missing", &mockedAWSSSMClient{}); err == nil {
+		t.Error("loadSSMSecret of a missing parameter succeeded; want error")
+	}
+}
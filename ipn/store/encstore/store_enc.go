@@ -0,0 +1,249 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package encstore contains an ipn.StateStore that encrypts the state of
+// another ipn.StateStore at rest.
+package encstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"tailscale.com/ipn"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/mak"
+)
+
+// magic prefixes the encrypted values written by Store, followed by the
+// nonce and the AES-GCM ciphertext.
+const magic = "tsenc1:"
+
+// saltKey is the key under which the Argon2id salt of a passphrase is kept
+// in the underlying store. It isn't secret.
+const saltKey ipn.StateKey = "_encstore-salt"
+
+// Argon2id parameters for deriving a key from a passphrase, as
+// recommended by RFC 9106 for memory-constrained environments.
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024 // KiB
+	argonThreads = 4
+)
+
+// Store is an ipn.StateStore that encrypts the values of another
+// ipn.StateStore with AES-256-GCM. The state keys aren't encrypted, but
+// each value is bound to its key, so values can't be swapped between keys.
+type Store struct {
+	// MigratePlaintext is whether plaintext values are read, and encrypted.
+	// It's meant to be set while migrating state that was written without
+	// encryption, such as before encryption was enabled or by an older
+	// tailscaled after that. Otherwise plaintext values are refused, as
+	// anyone able to modify the underlying store could use them to
+	// replace the encrypted state.
+	MigratePlaintext bool
+
+	logf  logger.Logf
+	inner ipn.StateStore
+	aead  cipher.AEAD
+}
+
+// New returns a Store that encrypts the state kept in inner with the
+// secret from keySource, a spec of the form "scheme:arg":
+//
+//   - "file:PATH": the contents of the file at PATH, without a trailing
+//     newline.
+//   - "env:NAME": the value of the environment variable NAME.
+//   - "awsssm:ARN": on Linux, the SecureString AWS SSM parameter ARN,
+//     decrypted with its AWS KMS key. See package awsstore.
+//   - any scheme registered with RegisterKeySource.
+//
+// A secret of 64 hex digits is used as the key. Any other secret is
+// treated as a passphrase and stretched into a key with Argon2id, using a
+// random salt kept in inner.
+//
+// Reading a value in inner that isn't encrypted, such as one written
+// before encryption was enabled, fails unless MigratePlaintext is set.
+func New(logf logger.Logf, inner ipn.StateStore, keySource string) (*Store, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	secret, err := LoadSecret(ctx, keySource)
+	if err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(inner, secret)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		logf:  logf,
+		inner: inner,
+		aead:  aead,
+	}, nil
+}
+
+// IsEncrypted reports whether bs is a value encrypted by a Store, as kept
+// in its underlying store.
+func IsEncrypted(bs []byte) bool {
+	return bytes.HasPrefix(bs, []byte(magic))
+}
+
+func (s *Store) String() string { return fmt.Sprintf("encstore.Store(%v)", s.inner) }
+
+// ReadState implements the StateStore interface.
+func (s *Store) ReadState(id ipn.StateKey) ([]byte, error) {
+	bs, err := s.inner.ReadState(id)
+	if err != nil {
+		return nil, err
+	}
+	if !IsEncrypted(bs) {
+		if !s.MigratePlaintext {
+			return nil, fmt.Errorf("state %q is not encrypted; refusing to read it without plaintext migration enabled", id)
+		}
+		// Written without encryption; encrypt it now.
+		s.logf("encstore: encrypting plaintext state %q", id)
+		if err := s.writeEncrypted(id, bs); err != nil {
+			return nil, fmt.Errorf("encrypting plaintext state %q: %w", id, err)
+		}
+		return bs, nil
+	}
+	bs = bs[len(magic):]
+	ns := s.aead.NonceSize()
+	if len(bs) < ns {
+		return nil, fmt.Errorf("encrypted state %q is truncated", id)
+	}
+	pt, err := s.aead.Open(nil, bs[:ns], bs[ns:], []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting state %q: wrong key or corrupt state", id)
+	}
+	return pt, nil
+}
+
+// WriteState implements the StateStore interface.
+func (s *Store) WriteState(id ipn.StateKey, bs []byte) error {
+	return s.writeEncrypted(id, bs)
+}
+
+// writeEncrypted writes bs, encrypted, to the underlying store.
+func (s *Store) writeEncrypted(id ipn.StateKey, bs []byte) error {
+	ns := s.aead.NonceSize()
+	out := make([]byte, len(magic)+ns, len(magic)+ns+len(bs)+s.aead.Overhead())
+	copy(out, magic)
+	nonce := out[len(magic):]
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	out = s.aead.Seal(out, nonce, bs, []byte(id))
+	return s.inner.WriteState(id, out)
+}
+
+// SetDialer implements ipn.StateStoreDialerSetter, if the underlying
+// store does.
+func (s *Store) SetDialer(d func(ctx context.Context, network, address string) (net.Conn, error)) {
+	if sds, ok := s.inner.(ipn.StateStoreDialerSetter); ok {
+		sds.SetDialer(d)
+	}
+}
+
+// deriveKey returns the AES-256 key for secret, creating the salt of a
+// passphrase in inner if needed.
+func deriveKey(inner ipn.StateStore, secret []byte) ([]byte, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("empty state encryption key")
+	}
+	if len(secret) == 64 {
+		if key, err := hex.DecodeString(string(secret)); err == nil {
+			return key, nil
+		}
+	}
+	salt, err := inner.ReadState(saltKey)
+	if errors.Is(err, ipn.ErrStateNotExist) {
+		salt = make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		err = inner.WriteState(saltKey, salt)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("state encryption salt: %w", err)
+	}
+	return argon2.IDKey(secret, salt, argonTime, argonMemory, argonThreads, 32), nil
+}
+
+// KeySource returns the secret to encrypt state with, given the arg of a
+// key source spec "scheme:arg".
+type KeySource func(ctx context.Context, arg string) ([]byte, error)
+
+var (
+	keySourcesMu sync.Mutex
+	keySources   map[string]KeySource
+)
+
+func init() {
+	RegisterKeySource("file", func(_ context.Context, path string) ([]byte, error) {
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		bs = bytes.TrimSuffix(bs, []byte("\n"))
+		return bytes.TrimSuffix(bs, []byte("\r")), nil
+	})
+	RegisterKeySource("env", func(_ context.Context, name string) ([]byte, error) {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %q is not set", name)
+		}
+		return []byte(v), nil
+	})
+}
+
+// RegisterKeySource registers ks for key source specs with the given
+// scheme, such as a KMS that decrypts a data key. It panics if the scheme
+// is already registered.
+func RegisterKeySource(scheme string, ks KeySource) {
+	keySourcesMu.Lock()
+	defer keySourcesMu.Unlock()
+	if _, ok := keySources[scheme]; ok {
+		panic(fmt.Sprintf("key source %q already registered", scheme))
+	}
+	mak.Set(&keySources, scheme, ks)
+}
+
+// LoadSecret returns the secret from the key source spec, of the form
+// "scheme:arg". See New for the supported schemes.
+func LoadSecret(ctx context.Context, spec string) ([]byte, error) {
+	scheme, arg, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid state encryption key source %q; want scheme:arg", spec)
+	}
+	keySourcesMu.Lock()
+	ks := keySources[scheme]
+	keySourcesMu.Unlock()
+	if ks == nil {
+		return nil, fmt.Errorf("unknown state encryption key source scheme %q", scheme)
+	}
+	secret, err := ks(ctx, arg)
+	if err != nil {
+		return nil, fmt.Errorf("loading state encryption key from %s: %w", scheme, err)
+	}
+	return secret, nil
+}
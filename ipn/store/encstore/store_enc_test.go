@@ -0,0 +1,157 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package encstore
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/mem"
+)
+
+const testHexKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func TestStore(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte(testHexKey+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_STATE_PASSPHRASE", "hunter2")
+
+	for _, keySource := range []string{"file:" + keyFile, "env:TEST_STATE_PASSPHRASE"} {
+		t.Run(keySource, func(t *testing.T) {
+			inner := new(mem.Store)
+			s, err := New(t.Logf, inner, keySource)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.WriteState("foo", []byte("bar")); err != nil {
+				t.Fatal(err)
+			}
+			raw, err := inner.ReadState("foo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(raw, []byte("bar")) || !bytes.HasPrefix(raw, []byte(magic)) {
+				t.Errorf("inner state = %q; want encrypted", raw)
+			}
+			if bs, err := s.ReadState("foo"); err != nil || string(bs) != "bar" {
+				t.Errorf("ReadState = %q, %v; want bar", bs, err)
+			}
+			if _, err := s.ReadState("missing"); err != ipn.ErrStateNotExist {
+				t.Errorf("ReadState(missing) error = %v; want ErrStateNotExist", err)
+			}
+
+			// A value moved to another key doesn't decrypt.
+			inner.WriteState("other", raw)
+			if _, err := s.ReadState("other"); err == nil {
+				t.Error("ReadState of a value moved from another key succeeded")
+			}
+
+			// A second store with the same key source reads the state.
+			s2, err := New(t.Logf, inner, keySource)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bs, err := s2.ReadState("foo"); err != nil || string(bs) != "bar" {
+				t.Errorf("second store ReadState = %q, %v; want bar", bs, err)
+			}
+		})
+	}
+}
+
+func TestStoreWrongKey(t *testing.T) {
+	inner := new(mem.Store)
+	t.Setenv("TEST_STATE_PASSPHRASE", "hunter2")
+	s, err := New(t.Logf, inner, "env:TEST_STATE_PASSPHRASE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteState("foo", []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_STATE_PASSPHRASE", "hunter3")
+	s, err = New(t.Logf, inner, "env:TEST_STATE_PASSPHRASE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadState("foo"); err == nil || !strings.Contains(err.Error(), "wrong key") {
+		t.Errorf("ReadState with the wrong key error = %v; want wrong key", err)
+	}
+}
+
+func TestStorePlaintextMigration(t *testing.T) {
+	inner := new(mem.Store)
+	inner.WriteState("foo", []byte("bar"))
+	t.Setenv("TEST_STATE_KEY", testHexKey)
+	s, err := New(t.Logf, inner, "env:TEST_STATE_KEY")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Plaintext state is refused, even if the store was never written to.
+	if _, err := s.ReadState("foo"); err == nil {
+		t.Error("ReadState of plaintext without MigratePlaintext succeeded")
+	}
+	if raw, _ := inner.ReadState("foo"); string(raw) != "bar" {
+		t.Errorf("refused plaintext state was modified: %q", raw)
+	}
+
+	// Unless migration is asked for, in which case it's encrypted on read.
+	s.MigratePlaintext = true
+	if bs, err := s.ReadState("foo"); err != nil || string(bs) != "bar" {
+		t.Errorf("ReadState with MigratePlaintext = %q, %v; want bar", bs, err)
+	}
+	if raw, _ := inner.ReadState("foo"); !IsEncrypted(raw) {
+		t.Errorf("plaintext state wasn't encrypted on read: %q", raw)
+	}
+
+	// Plaintext replacing encrypted state is refused too.
+	s, err = New(t.Logf, inner, "env:TEST_STATE_KEY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bs, err := s.ReadState("foo"); err != nil || string(bs) != "bar" {
+		t.Errorf("ReadState of migrated state = %q, %v; want bar", bs, err)
+	}
+	inner.WriteState("foo", []byte("downgraded"))
+	if _, err := s.ReadState("foo"); err == nil {
+		t.Error("ReadState of plaintext replacing encrypted state succeeded")
+	}
+}
+
+func TestLoadSecret(t *testing.T) {
+	RegisterKeySource("test-kms", func(_ context.Context, arg string) ([]byte, error) {
+		return []byte("kms-" + arg), nil
+	})
+	t.Setenv("TEST_STATE_KEY", "secret")
+
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{spec: "env:TEST_STATE_KEY", want: "secret"},
+		{spec: "test-kms://key/1", want: "kms-//key/1"},
+		{spec: "env:TEST_STATE_KEY_UNSET", wantErr: true},
+		{spec: "file:/nonexistent/key", wantErr: true},
+		{spec: "bogus:foo", wantErr: true},
+		{spec: "nocolon", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := LoadSecret(context.Background(), tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("LoadSecret(%q) error = %v; wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("LoadSecret(%q) = %q; want %q", tt.spec, got, tt.want)
+		}
+	}
+}
@@ -7,6 +7,7 @@
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -15,7 +16,9 @@
 	"sync"
 
 	"tailscale.com/atomicfile"
+	"tailscale.com/envknob"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/encstore"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/paths"
 	"tailscale.com/types/logger"
@@ -32,6 +35,13 @@
 
 func registerDefaultStores() {
 	Register("mem:", mem.New)
+	Register("encrypted-file:", func(logf logger.Logf, path string) (ipn.StateStore, error) {
+		fs, err := NewFileStore(logf, strings.TrimPrefix(path, "encrypted-file:"))
+		if err != nil {
+			return nil, err
+		}
+		return NewEncrypted(logf, fs)
+	})
 
 	if registerAvailableExternalStores != nil {
 		registerAvailableExternalStores()
@@ -53,6 +63,11 @@ func registerDefaultStores() {
 //     the suffix an AWS ARN for an SSM.
 //   - (Linux-only) if the string begins with "kube:",
 //     the suffix is a Kubernetes secret name
+//   - if the string begins with "encrypted-file:", the suffix is a
+//     filepath whose state is encrypted by NewEncrypted.
+//   - (Linux-only) if the string begins with "encrypted-kube:", the
+//     suffix is a Kubernetes secret name whose state is encrypted by
+//     NewEncrypted.
 //   - In all other cases, the path is treated as a filepath.
 func New(logf logger.Logf, path string) (ipn.StateStore, error) {
 	regOnce.Do(registerDefaultStores)
@@ -84,6 +99,27 @@ func Register(prefix string, fn Provider) {
 	mak.Set(&knownStores, prefix, fn)
 }
 
+// NewEncrypted returns a StateStore that encrypts the state kept in inner
+// at rest, with the key from the key source in the TS_STATE_ENCRYPTION_KEY
+// environment variable, like "file:/run/secrets/ts-state-key". See
+// encstore.New for the supported key sources.
+//
+// Plaintext state in inner, such as that written before encryption was
+// enabled, is only read (and encrypted) if TS_STATE_ENCRYPTION_MIGRATE is
+// set to true.
+func NewEncrypted(logf logger.Logf, inner ipn.StateStore) (ipn.StateStore, error) {
+	ks := envknob.String("TS_STATE_ENCRYPTION_KEY")
+	if ks == "" {
+		return nil, errors.New("TS_STATE_ENCRYPTION_KEY must be set to use an encrypted state store")
+	}
+	s, err := encstore.New(logf, inner, ks)
+	if err != nil {
+		return nil, err
+	}
+	s.MigratePlaintext = envknob.Bool("TS_STATE_ENCRYPTION_MIGRATE")
+	return s, nil
+}
+
 // TryWindowsAppDataMigration attempts to copy the Windows state file
 // from its old location to the new location. (Issue 2856)
 //
@@ -21,5 +21,12 @@ func registerExternalStores() {
 		secretName := strings.TrimPrefix(path, "kube:")
 		return kubestore.New(logf, secretName)
 	})
+	Register("encrypted-kube:", func(logf logger.Logf, path string) (ipn.StateStore, error) {
+		ks, err := kubestore.New(logf, strings.TrimPrefix(path, "encrypted-kube:"))
+		if err != nil {
+			return nil, err
+		}
+		return NewEncrypted(logf, ks)
+	})
 	Register("arn:", awsstore.New)
 }
@@ -4,7 +4,10 @@
 package store
 
 import (
+	"encoding/base64"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"tailscale.com/ipn"
@@ -177,3 +180,37 @@ func TestFileStore(t *testing.T) {
 		}
 	}
 }
+
+func TestEncryptedFileStore(t *testing.T) {
+	t.Setenv("TS_STATE_ENCRYPTION_KEY", "env:TEST_STATE_PASSPHRASE")
+	t.Setenv("TEST_STATE_PASSPHRASE", "correct horse battery staple")
+
+	path := filepath.Join(t.TempDir(), "test-file-store.conf")
+	store, err := New(t.Logf, "encrypted-file:"+path)
+	if err != nil {
+		t.Fatalf("creating encrypted file store failed: %v", err)
+	}
+	testStoreSemantics(t, store)
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(bs), base64.StdEncoding.EncodeToString([]byte("quux"))) {
+		t.Errorf("state file contains plaintext state: %s", bs)
+	}
+
+	// A new store with the same passphrase reads the state back.
+	store, err = New(t.Logf, "encrypted-file:"+path)
+	if err != nil {
+		t.Fatalf("creating second encrypted file store failed: %v", err)
+	}
+	if bs, err := store.ReadState("baz"); err != nil || string(bs) != "quux" {
+		t.Errorf("reading baz (2nd store) = %q, %v; want quux", bs, err)
+	}
+
+	t.Setenv("TS_STATE_ENCRYPTION_KEY", "")
+	if _, err := New(t.Logf, "encrypted-file:"+path); err == nil {
+		t.Error("creating encrypted file store without a key succeeded")
+	}
+}
@@ -23,6 +23,9 @@
 // +kubebuilder:printcolumn:name="SubnetRoutes",type="string",JSONPath=`.status.subnetRoutes`,description="CIDR ranges exposed to tailnet by a subnet router defined via this Connector instance."
 // +kubebuilder:printcolumn:name="IsExitNode",type="string",JSONPath=`.status.isExitNode`,description="Whether this Connector instance defines an exit node."
 // +kubebuilder:printcolumn:name="Status",type="string",JSONPath=`.status.conditions[?(@.type == "ConnectorReady")].reason`,description="Status of the deployed Connector resources."
+// +kubebuilder:printcolumn:name="Running",type="string",JSONPath=`.status.conditions[?(@.type == "ProxyRunning")].status`,description="Whether the proxy Pod of this Connector instance is running and ready."
+// +kubebuilder:printcolumn:name="Authorized",type="string",JSONPath=`.status.conditions[?(@.type == "ProxyDeviceAuthorized")].status`,description="Whether the proxy of this Connector instance has logged in to the tailnet."
+// +kubebuilder:printcolumn:name="RoutesAdvertised",type="string",JSONPath=`.status.conditions[?(@.type == "RoutesAdvertised")].status`,description="Whether the routes advertised by this Connector instance have been approved."
 
 type Connector struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -136,7 +139,8 @@ func (routes Routes) Stringify() string {
 // ConnectorStatus defines the observed state of the Connector.
 type ConnectorStatus struct {
 	// List of status conditions to indicate the status of the Connector.
-	// Known condition types are `ConnectorReady`.
+	// Known condition types are `ConnectorReady`, `ProxyRunning`,
+	// `ProxyDeviceAuthorized` and `RoutesAdvertised`.
 	// +listType=map
 	// +listMapKey=type
 	// +optional
@@ -188,4 +192,14 @@ type ConnectorCondition struct {
 const (
 	ConnectorReady  ConnectorConditionType = `ConnectorReady`
 	ProxyClassready ConnectorConditionType = `ProxyClassReady`
+
+	// ProxyRunning is set on a Connector when its proxy Pod is running and
+	// ready.
+	ProxyRunning ConnectorConditionType = `ProxyRunning`
+	// ProxyDeviceAuthorized is set on a Connector when its proxy has
+	// logged in and stored its device info in its state Secret.
+	ProxyDeviceAuthorized ConnectorConditionType = `ProxyDeviceAuthorized`
+	// RoutesAdvertised is set on a Connector when all the routes that it
+	// advertises have been approved for its device.
+	RoutesAdvertised ConnectorConditionType = `RoutesAdvertised`
 )
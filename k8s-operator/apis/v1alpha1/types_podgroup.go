@@ -0,0 +1,114 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Code comments on these types should be treated as user facing documentation-
+// they will appear on the PodGroup CRD i.e if someone runs kubectl explain podgroup.
+
+var PodGroupKind = "PodGroup"
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Namespaced,shortName=pg
+// +kubebuilder:printcolumn:name="StatefulSet",type="string",JSONPath=`.spec.statefulSet`,description="Name of the StatefulSet whose Pods are exposed to tailnet."
+// +kubebuilder:printcolumn:name="Status",type="string",JSONPath=`.status.conditions[?(@.type == "PodGroupReady")].reason`,description="Status of the deployed PodGroup resources."
+
+// PodGroup exposes each Pod of a StatefulSet to tailnet as its own Tailscale
+// node. This is useful for workloads such as replicated databases whose
+// clients need to reach a specific replica directly.
+type PodGroup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// PodGroupSpec describes the Pods that should be exposed to tailnet.
+	Spec PodGroupSpec `json:"spec"`
+
+	// PodGroupStatus describes the status of the PodGroup. This is set
+	// and managed by the Tailscale operator.
+	// +optional
+	Status PodGroupStatus `json:"status"`
+}
+
+// +kubebuilder:object:root=true
+
+type PodGroupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []PodGroup `json:"items"`
+}
+
+// PodGroupSpec describes a set of Pods to be exposed to tailnet, one
+// Tailscale node per Pod.
+type PodGroupSpec struct {
+	// StatefulSet is the name of a StatefulSet in the same namespace as the
+	// PodGroup. Each of its Pods will be exposed to tailnet via a dedicated
+	// Tailscale proxy.
+	// +kubebuilder:validation:MinLength=1
+	StatefulSet string `json:"statefulSet"`
+	// HostnamePrefix is the prefix for the tailnet hostnames of the Pods'
+	// Tailscale nodes. A Pod with ordinal N is given the hostname
+	// <hostnamePrefix>-N, so that i.e for hostnamePrefix 'db' the first
+	// replica is reachable at db-0.<tailnet name>.ts.net. If unset,
+	// defaults to the name of the StatefulSet. HostnamePrefix can contain
+	// lower case letters, numbers and dashes, it must not start or end
+	// with a dash and must be between 2 and 56 characters long.
+	// +kubebuilder:validation:Type=string
+	// +kubebuilder:validation:Pattern=`^[a-z0-9][a-z0-9-]{0,54}[a-z0-9]$`
+	// +optional
+	HostnamePrefix string `json:"hostnamePrefix,omitempty"`
+	// Tags that the Tailscale nodes will be tagged with.
+	// Defaults to [tag:k8s].
+	// If you specify custom tags here, you must also make the operator an owner of these tags.
+	// See  https://tailscale.com/kb/1236/kubernetes-operator/#setting-up-the-kubernetes-operator.
+	// Tags cannot be changed once the Tailscale nodes have been created.
+	// Tag values must be in form ^tag:[a-zA-Z][a-zA-Z0-9-]*$.
+	// +optional
+	Tags Tags `json:"tags,omitempty"`
+	// ProxyClass is the name of the ProxyClass custom resource that
+	// contains configuration options that should be applied to the
+	// resources created for this PodGroup. If unset, the operator will
+	// create resources with the default configuration.
+	// +optional
+	ProxyClass string `json:"proxyClass,omitempty"`
+}
+
+// PodGroupStatus defines the observed state of the PodGroup.
+type PodGroupStatus struct {
+	// List of status conditions to indicate the status of the PodGroup.
+	// Known condition types are `PodGroupReady`.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []ConnectorCondition `json:"conditions"`
+	// Pods are the Pods currently exposed to tailnet via this PodGroup.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Pods []PodGroupPod `json:"pods,omitempty"`
+}
+
+// PodGroupPod describes a single Pod exposed to tailnet via a PodGroup.
+type PodGroupPod struct {
+	// Name is the name of the Pod.
+	Name string `json:"name"`
+	// Hostname is the tailnet hostname of the Tailscale node for the Pod.
+	// +optional
+	Hostname string `json:"hostname,omitempty"`
+	// TailnetIPs are the tailnet IP addresses of the Tailscale node for
+	// the Pod. They are only set once the node has successfully
+	// authenticated.
+	// +optional
+	TailnetIPs []string `json:"tailnetIPs,omitempty"`
+}
+
+const (
+	PodGroupReady ConnectorConditionType = `PodGroupReady`
+)
@@ -8,6 +8,7 @@
 import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 var ProxyClassKind = "ProxyClass"
@@ -38,6 +39,15 @@ type ProxyClassList struct {
 type ProxyClassSpec struct {
 	// Proxy's StatefulSet spec.
 	StatefulSet *StatefulSet `json:"statefulSet"`
+	// HostnameTemplate is the template for the tailnet hostnames of the
+	// proxies that use this ProxyClass, overriding the operator's
+	// OPERATOR_HOSTNAME_TEMPLATE. It can contain the placeholders
+	// {namespace}, {name} and {cluster}, which are replaced with the
+	// namespace and name of the resource that the proxy is created for and
+	// the cluster name set in OPERATOR_CLUSTER_NAME. Hostnames set
+	// explicitly for a resource take precedence.
+	// +optional
+	HostnameTemplate string `json:"hostnameTemplate,omitempty"`
 }
 
 type StatefulSet struct {
@@ -62,6 +72,58 @@ type StatefulSet struct {
 	// Configuration for the proxy Pod.
 	// +optional
 	Pod *Pod `json:"pod,omitempty"`
+	// Number of proxy replicas. Each replica is a separate Tailscale node
+	// with its own state Secret, so that the proxy keeps working while
+	// some of its replicas are down. Ignored if autoscaling is set.
+	// Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+	// If set, the operator creates a PodDisruptionBudget for the proxy
+	// Pods, so that voluntary disruptions such as node drains don't take
+	// down too many replicas at once. This is only useful for proxies with
+	// more than one replica.
+	// https://kubernetes.io/docs/concepts/workloads/pods/disruptions/
+	// +optional
+	PodDisruptionBudget *PodDisruptionBudget `json:"podDisruptionBudget,omitempty"`
+	// If set, the operator creates a HorizontalPodAutoscaler that scales
+	// the proxy StatefulSet on the CPU utilization of the tailscale
+	// container, which requires pod.tailscaleContainer.resources.requests
+	// to set a CPU request.
+	// https://kubernetes.io/docs/tasks/run-application/horizontal-pod-autoscale/
+	// +optional
+	Autoscaling *Autoscaling `json:"autoscaling,omitempty"`
+}
+
+type PodDisruptionBudget struct {
+	// Minimum number or percentage of proxy Pods that must remain
+	// available during a voluntary disruption. At most one of
+	// minAvailable and maxUnavailable can be set. If neither is set,
+	// maxUnavailable defaults to 1.
+	// +optional
+	MinAvailable *intstr.IntOrString `json:"minAvailable,omitempty"`
+	// Maximum number or percentage of proxy Pods that can be unavailable
+	// during a voluntary disruption.
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+type Autoscaling struct {
+	// Minimum number of proxy replicas.
+	// Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+	// Maximum number of proxy replicas. It must not be less than
+	// minReplicas.
+	// +kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+	// Average CPU utilization of the tailscale containers, as a
+	// percentage of their CPU request, that the autoscaler aims for.
+	// Defaults to 80.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TargetCPUUtilizationPercentage *int32 `json:"targetCPUUtilizationPercentage,omitempty"`
 }
 
 type Pod struct {
@@ -105,6 +167,19 @@ type Pod struct {
 	// https://kubernetes.io/docs/reference/kubernetes-api/workload-resources/pod-v1/#scheduling
 	// +optional
 	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Proxy Pod's priority class name, overriding the operator's default
+	// priority class for proxies (PROXY_PRIORITY_CLASS_NAME).
+	// https://kubernetes.io/docs/concepts/scheduling-eviction/pod-priority-preemption/
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// Whether to run the proxy's containers with a read-only root
+	// filesystem, as required by some PodSecurity policies. If true, the
+	// operator mounts emptyDir volumes at /tmp and at the proxy's state
+	// directory, /var/lib/tailscale, which are the only paths that the
+	// proxy writes to.
+	// Defaults to false.
+	// +optional
+	ReadOnlyRootFilesystem bool `json:"readOnlyRootFilesystem,omitempty"`
 	// Proxy Pod's tolerations.
 	// By default Tailscale Kubernetes operator does not apply any
 	// tolerations.
@@ -185,3 +185,91 @@ type Status struct {
 func (s *Status) Error() string {
 	return s.Message
 }
+
+// ObjectReference contains enough information to let you inspect or modify
+// the referred object.
+type ObjectReference struct {
+	// Kind of the referent.
+	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds
+	// +optional
+	Kind string `json:"kind,omitempty"`
+
+	// Namespace of the referent.
+	// More info: https://kubernetes.io/docs/concepts/overview/working-with-objects/namespaces/
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Name of the referent.
+	// More info: https://kubernetes.io/docs/concepts/overview/working-with-objects/names/#names
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// UID of the referent.
+	// More info: https://kubernetes.io/docs/concepts/overview/working-with-objects/names/#uids
+	// +optional
+	UID string `json:"uid,omitempty"`
+
+	// API version of the referent.
+	// +optional
+	APIVersion string `json:"apiVersion,omitempty"`
+}
+
+// EventSource contains information for an event.
+type EventSource struct {
+	// Component from which the event is generated.
+	// +optional
+	Component string `json:"component,omitempty"`
+}
+
+// Event types.
+const (
+	// EventTypeNormal is for information only and will not cause any
+	// problems.
+	EventTypeNormal = "Normal"
+	// EventTypeWarning indicates that something might go wrong, but it is
+	// not an error.
+	EventTypeWarning = "Warning"
+)
+
+// Event is a report of an event somewhere in the cluster.
+type Event struct {
+	TypeMeta `json:",inline"`
+	// Standard object's metadata.
+	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#metadata
+	ObjectMeta `json:"metadata"`
+
+	// The object that this event is about.
+	InvolvedObject ObjectReference `json:"involvedObject"`
+
+	// This should be a short, machine understandable string that gives the
+	// reason for the transition into the object's current status.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// A human-readable description of the status of this operation.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// The component reporting this event. Should be a short machine
+	// understandable string.
+	// +optional
+	Source EventSource `json:"source,omitempty"`
+
+	// The time at which the event was first recorded.
+	// +optional
+	FirstTimestamp time.Time `json:"firstTimestamp,omitempty"`
+
+	// The time at which the most recent occurrence of this event was
+	// recorded.
+	// +optional
+	LastTimestamp time.Time `json:"lastTimestamp,omitempty"`
+
+	// The number of times this event has occurred.
+	// +optional
+	Count int32 `json:"count,omitempty"`
+
+	// Type of this event (Normal, Warning), new types could be added in the
+	// future.
+	// +optional
+	Type string `json:"type,omitempty"`
+}
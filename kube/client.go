@@ -127,6 +127,10 @@ func (c *Client) secretURL(name string) string {
 	return fmt.Sprintf("%s/api/v1/namespaces/%s/secrets/%s", c.url, c.ns, name)
 }
 
+func (c *Client) eventURL() string {
+	return fmt.Sprintf("%s/api/v1/namespaces/%s/events", c.url, c.ns)
+}
+
 func getError(resp *http.Response) error {
 	if resp.StatusCode == 200 || resp.StatusCode == 201 {
 		// These are the only success codes returned by the Kubernetes API.
@@ -228,6 +232,16 @@ func (c *Client) UpdateSecret(ctx context.Context, s *Secret) error {
 	return c.doRequest(ctx, "PUT", c.secretURL(s.Name), s, nil)
 }
 
+// CreateEvent creates an Event in the Client's namespace. The involved object
+// defaults to being in the same namespace.
+func (c *Client) CreateEvent(ctx context.Context, e *Event) error {
+	e.Namespace = c.ns
+	if e.InvolvedObject.Namespace == "" {
+		e.InvolvedObject.Namespace = c.ns
+	}
+	return c.doRequest(ctx, "POST", c.eventURL(), e, nil)
+}
+
 // JSONPatch is a JSON patch operation.
 // It currently (2023-03-02) only supports the "remove" operation.
 //
@@ -239,10 +253,10 @@ type JSONPatch struct {
 }
 
 // JSONPatchSecret updates a secret in the Kubernetes API using a JSON patch.
-// It currently (2023-03-02) only supports the "remove" operation.
+// It currently only supports the "add", "remove" and "test" operations.
 func (c *Client) JSONPatchSecret(ctx context.Context, name string, patch []JSONPatch) error {
 	for _, p := range patch {
-		if p.Op != "remove" && p.Op != "add" {
+		if p.Op != "remove" && p.Op != "add" && p.Op != "test" {
 			panic(fmt.Errorf("unsupported JSON patch operation: %q", p.Op))
 		}
 	}
@@ -278,7 +292,7 @@ func (c *Client) StrategicMergePatchSecret(ctx context.Context, name string, s *
 func (c *Client) CheckSecretPermissions(ctx context.Context, secretName string) (canPatch bool, err error) {
 	var errs []error
 	for _, verb := range []string{"get", "update"} {
-		ok, err := c.checkPermission(ctx, verb, secretName)
+		ok, err := c.checkPermission(ctx, verb, "secrets", secretName)
 		if err != nil {
 			log.Printf("error checking %s permission on secret %s: %v", verb, secretName, err)
 		} else if !ok {
@@ -288,7 +302,7 @@ func (c *Client) CheckSecretPermissions(ctx context.Context, secretName string)
 	if len(errs) > 0 {
 		return false, multierr.New(errs...)
 	}
-	ok, err := c.checkPermission(ctx, "patch", secretName)
+	ok, err := c.checkPermission(ctx, "patch", "secrets", secretName)
 	if err != nil {
 		log.Printf("error checking patch permission on secret %s: %v", secretName, err)
 		return false, nil
@@ -296,9 +310,17 @@ func (c *Client) CheckSecretPermissions(ctx context.Context, secretName string)
 	return ok, nil
 }
 
+// CheckEventPermissions reports whether the current pod has permission to
+// create Events in its namespace.
+func (c *Client) CheckEventPermissions(ctx context.Context) (bool, error) {
+	return c.checkPermission(ctx, "create", "events", "")
+}
+
 // checkPermission reports whether the current pod has permission to use the
-// given verb (e.g. get, update, patch) on secretName.
-func (c *Client) checkPermission(ctx context.Context, verb, secretName string) (bool, error) {
+// given verb (e.g. get, update, patch) on the named object of the given
+// resource type. An empty name checks the permission for all objects of that
+// type.
+func (c *Client) checkPermission(ctx context.Context, verb, resource, name string) (bool, error) {
 	sar := map[string]any{
 		"apiVersion": "authorization.k8s.io/v1",
 		"kind":       "SelfSubjectAccessReview",
@@ -306,8 +328,8 @@ func (c *Client) checkPermission(ctx context.Context, verb, secretName string) (
 			"resourceAttributes": map[string]any{
 				"namespace": c.ns,
 				"verb":      verb,
-				"resource":  "secrets",
-				"name":      secretName,
+				"resource":  resource,
+				"name":      name,
 			},
 		},
 	}
@@ -25,11 +25,13 @@
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/term"
 	"tailscale.com/atomicfile"
 	"tailscale.com/envknob"
+	"tailscale.com/health"
 	"tailscale.com/log/filelogger"
 	"tailscale.com/logtail"
 	"tailscale.com/logtail/filch"
@@ -68,6 +70,37 @@ func getLogTarget() string {
 	return getLogTargetOnce.v
 }
 
+// newLogSink returns the logtail sink selected by the TS_LOG_SINK
+// environment variable, or nil if it's unset. It can be:
+//
+//   - "file:PATH", to append logs to the file at PATH
+//   - "syslog", to send logs to the local syslog daemon
+//   - "otlp:URL", to export logs to the OpenTelemetry collector logs
+//     endpoint at URL (e.g. "otlp:http://localhost:4318/v1/logs")
+func newLogSink(cmdName string) (logtail.Sink, error) {
+	v := envknob.String("TS_LOG_SINK")
+	if v == "" {
+		return nil, nil
+	}
+	kind, arg, _ := strings.Cut(v, ":")
+	switch kind {
+	case "file":
+		if arg == "" {
+			return nil, errors.New("TS_LOG_SINK: missing file path")
+		}
+		return logtail.NewFileSink(arg)
+	case "syslog":
+		return logtail.NewSyslogSink(cmdName)
+	case "otlp":
+		u, err := url.Parse(arg)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("TS_LOG_SINK: invalid OTLP URL %q", arg)
+		}
+		return logtail.NewOTLPSink(arg, nil, cmdName), nil
+	}
+	return nil, fmt.Errorf("TS_LOG_SINK: unknown sink %q", kind)
+}
+
 // LogURL is the base URL for the configured logtail server, or the default.
 // It is guaranteed to not terminate with any forward slashes.
 func LogURL() string {
@@ -561,12 +594,27 @@ func NewWithConfigPath(collection, dir, cmdName string, netMon *netmon.Monitor,
 			}
 			return w
 		},
+		ZstdDict: &logtail.ZstdDictConfig{
+			Path:  filepath.Join(dir, cmdName+".log.zdict"),
+			Build: smallzstd.BuildDict,
+			NewEncoder: func(dict []byte) (logtail.Encoder, error) {
+				return smallzstd.NewDictEncoder(dict)
+			},
+		},
 		HTTPC: &http.Client{Transport: NewLogtailTransport(logtail.DefaultHost, netMon, logf)},
 	}
 	if collection == logtail.CollectionNode {
 		conf.MetricsDelta = clientmetric.EncodeLogTailMetricsDelta
+		conf.MetricsUploaded = clientmetric.AckLogTailUpload
 		conf.IncludeProcID = true
 		conf.IncludeProcSequence = true
+		conf.OnDropping = setLogsDroppingHealth
+		if envknob.Bool("TS_LOGTAIL_METRICS_BATCH") {
+			// For environments where the metrics in log lines
+			// don't make it to the log server.
+			conf.MetricsBatchInterval = time.Minute
+			conf.BatchClientMetrics = true
+		}
 	}
 
 	if envknob.NoLogsNoSupport() || testenv.InTest() {
@@ -579,6 +627,13 @@ func NewWithConfigPath(collection, dir, cmdName string, netMon *netmon.Monitor,
 		conf.HTTPC = &http.Client{Transport: NewLogtailTransport(u.Host, netMon, logf)}
 	}
 
+	if sink, err := newLogSink(cmdName); err != nil {
+		logf("%v; uploading logs as usual", err)
+	} else if sink != nil {
+		logf("Sending logs to TS_LOG_SINK %q instead of uploading them.", envknob.String("TS_LOG_SINK"))
+		conf.Sink = sink
+	}
+
 	filchOptions := filch.Options{
 		ReplaceStderr: redirectStderrToLogPanics(),
 	}
@@ -604,7 +659,17 @@ func NewWithConfigPath(collection, dir, cmdName string, netMon *netmon.Monitor,
 			conf.Stderr = filchBuf.OrigStderr
 		}
 	}
+	if conf.MetricsDelta != nil {
+		// Keep the metrics deltas not yet uploaded with the logs, so
+		// they aren't lost to a restart while offline.
+		if err := clientmetric.SetStateFile(filchPrefix + ".metrics.json"); err != nil {
+			logf("clientmetric.SetStateFile: %v", err)
+		}
+	}
 	lw := logtail.NewLogger(conf, logf)
+	if conf.Collection == logtail.CollectionNode {
+		nodeLogtail.Store(lw)
+	}
 
 	var logOutput io.Writer = lw
 
@@ -641,6 +706,30 @@ func NewWithConfigPath(collection, dir, cmdName string, netMon *netmon.Monitor,
 	}
 }
 
+// warnLogsDropping is unhealthy while tailscaled's logtail buffer is full and
+// dropping logs, typically because it's been unable to upload them.
+var warnLogsDropping = health.NewWarnable()
+
+// nodeLogtail is the logger of tailscaled's node logs, once created. How
+// full its buffer is is exported as a client metric; the lines it drops are
+// counted by logtail itself.
+var nodeLogtail atomic.Pointer[logtail.Logger]
+
+var _ = clientmetric.NewGaugeFunc("logtail_buffer_fill_percent", func() int64 {
+	if lw := nodeLogtail.Load(); lw != nil {
+		return int64(lw.BufferStats().Fill() * 100)
+	}
+	return 0
+})
+
+func setLogsDroppingHealth(dropping bool) {
+	if dropping {
+		warnLogsDropping.Set(errors.New("some logs are being dropped because they could not be uploaded in time"))
+	} else {
+		warnLogsDropping.Set(nil)
+	}
+}
+
 // dialLog is used by NewLogtailTransport to log the happy path of its
 // own dialing.
 //
@@ -24,6 +24,17 @@ type Buffer interface {
 	Write([]byte) (int, error)
 }
 
+// A CommitBuffer is a Buffer that keeps the lines read from it until they're
+// committed, so that they aren't lost if the process exits before uploading
+// them.
+type CommitBuffer interface {
+	Buffer
+
+	// Commit records that all lines returned by TryReadLine so far
+	// have been uploaded.
+	Commit() error
+}
+
 func NewMemoryBuffer(numEntries int) Buffer {
 	return &memBuffer{
 		pending: make(chan qentry, numEntries),
@@ -36,6 +47,7 @@ type memBuffer struct {
 
 	dropMu    sync.Mutex
 	dropCount int
+	dropTotal int64
 }
 
 func (m *memBuffer) TryReadLine() ([]byte, error) {
@@ -71,10 +83,22 @@ func (m *memBuffer) Write(b []byte) (int, error) {
 		return len(b), nil
 	default:
 		m.dropCount++
+		m.dropTotal++
 		return 0, errBufferFull
 	}
 }
 
+// Stats implements the StatsBuffer interface. Its sizes are in lines.
+func (m *memBuffer) Stats() BufferStats {
+	m.dropMu.Lock()
+	defer m.dropMu.Unlock()
+	return BufferStats{
+		Used:     int64(len(m.pending)),
+		Capacity: int64(cap(m.pending)),
+		Dropped:  m.dropTotal,
+	}
+}
+
 type qentry struct {
 	msg       []byte
 	dropCount int
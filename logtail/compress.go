@@ -0,0 +1,109 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"slices"
+	"strings"
+
+	"tailscale.com/util/clientmetric"
+)
+
+// Content encodings that uploads can be compressed with.
+const (
+	encodingZstd = "zstd"
+	encodingGzip = "gzip"
+)
+
+var (
+	metricUploadBytes           = clientmetric.NewCounter("logtail_upload_bytes")
+	metricUploadCompressedBytes = clientmetric.NewCounter("logtail_upload_compressed_bytes")
+)
+
+// errEncodingRefused is returned by Logger.upload when the log server
+// refused the content encoding of the upload. The upload can be retried
+// right away, as the encoding won't be used again.
+var errEncodingRefused = errors.New("log server refused content encoding")
+
+// initEncodings sets l.encodings, the content encodings l may compress
+// uploads with, in order of preference.
+func (l *Logger) initEncodings(gzipUploads bool) {
+	if l.zstdEncoder != nil {
+		l.encodings = append(l.encodings, encodingZstd)
+	}
+	if gzipUploads {
+		l.encodings = append(l.encodings, encodingGzip)
+	}
+}
+
+// compressBody returns body compressed with l's preferred content encoding,
+// along with that encoding and the zstdDictHeader value of the dictionary it
+// was compressed with, if any. It returns body as is, with an empty
+// encoding, if compressing it isn't worthwhile.
+func (l *Logger) compressBody(body []byte) (encoding, dict string, wire []byte) {
+	// Don't attempt to compress tiny bodies; not worth the CPU cycles.
+	if len(l.encodings) == 0 || len(body) <= 256 {
+		return "", "", body
+	}
+	encoding = l.encodings[0]
+	switch encoding {
+	case encodingZstd:
+		if l.zdict.enc != nil {
+			wire = l.zdict.enc.EncodeAll(body, nil)
+			dict = l.zdict.file.header()
+		} else {
+			wire = l.zstdEncoder.EncodeAll(body, nil)
+		}
+	case encodingGzip:
+		var buf bytes.Buffer
+		if l.gzipWriter == nil {
+			l.gzipWriter, _ = gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+		} else {
+			l.gzipWriter.Reset(&buf)
+		}
+		l.gzipWriter.Write(body)
+		l.gzipWriter.Close()
+		wire = buf.Bytes()
+	}
+	// Only send it compressed if the bandwidth savings are sufficient.
+	// Just the extra headers associated with enabling compression
+	// are 50 bytes by themselves.
+	if len(body)-len(wire) <= 64 {
+		return "", "", body
+	}
+	return encoding, dict, wire
+}
+
+// refuseEncoding stops l from compressing uploads with encoding, after the
+// log server refused it.
+func (l *Logger) refuseEncoding(encoding string) {
+	l.encodings = slices.DeleteFunc(l.encodings, func(e string) bool { return e == encoding })
+}
+
+// noteAcceptEncoding limits the content encodings l compresses uploads
+// with to those listed in the log server's Accept-Encoding response header,
+// if it sent one.
+func (l *Logger) noteAcceptEncoding(accept string) {
+	if accept == "" {
+		return
+	}
+	var accepted []string
+	for _, e := range strings.Split(accept, ",") {
+		e, _, _ = strings.Cut(e, ";") // ignore any q-value
+		accepted = append(accepted, strings.TrimSpace(e))
+	}
+	l.encodings = slices.DeleteFunc(l.encodings, func(e string) bool {
+		return !slices.Contains(accepted, e)
+	})
+}
+
+// noteUploaded updates the upload metrics for a successful upload of body,
+// sent as wire.
+func noteUploaded(body, wire []byte) {
+	metricUploadBytes.Add(int64(len(body)))
+	metricUploadCompressedBytes.Add(int64(len(wire)))
+}
@@ -0,0 +1,101 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeZstdEncoder is an Encoder that pretends to compress anything to a
+// single byte.
+type fakeZstdEncoder struct{}
+
+func (fakeZstdEncoder) EncodeAll(src, dst []byte) []byte { return append(dst, 'z') }
+func (fakeZstdEncoder) Close() error                     { return nil }
+
+func TestUploadEncodingNegotiation(t *testing.T) {
+	type upload struct {
+		encoding string
+		body     string
+	}
+	uploads := make(chan upload, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc := r.Header.Get("Content-Encoding")
+		var body io.Reader = r.Body
+		switch enc {
+		case "zstd":
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		case "gzip":
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("bad gzip body: %v", err)
+				return
+			}
+			body = zr
+		}
+		b, _ := io.ReadAll(body)
+		uploads <- upload{enc, string(b)}
+	}))
+	defer ts.Close()
+
+	bytesBefore := metricUploadBytes.Value()
+	compressedBefore := metricUploadCompressedBytes.Value()
+
+	l := NewLogger(Config{
+		BaseURL:        ts.URL,
+		FlushDelayFn:   func() time.Duration { return time.Hour },
+		NewZstdEncoder: func() Encoder { return fakeZstdEncoder{} },
+		GzipUploads:    true,
+	}, t.Logf)
+
+	// Log enough compressible text to be worth compressing.
+	l.Logf("%s", strings.Repeat("compress me ", 100))
+	l.StartFlush()
+
+	select {
+	case u := <-uploads:
+		if u.encoding != "gzip" {
+			t.Errorf("upload Content-Encoding = %q; want gzip after zstd was refused", u.encoding)
+		}
+		if !strings.Contains(u.body, "compress me compress me") {
+			t.Errorf("upload body = %q; missing log line", u.body)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for upload")
+	}
+	if got := l.encodings; len(got) != 1 || got[0] != "gzip" {
+		t.Errorf("encodings = %q; want [gzip]", got)
+	}
+
+	// Wait for the uploads to finish before checking the metrics.
+	if err := l.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	raw := metricUploadBytes.Value() - bytesBefore
+	compressed := metricUploadCompressedBytes.Value() - compressedBefore
+	if raw == 0 || compressed == 0 || compressed >= raw {
+		t.Errorf("upload metrics: %d bytes compressed to %d; want compression", raw, compressed)
+	}
+}
+
+func TestNoteAcceptEncoding(t *testing.T) {
+	l := &Logger{zstdEncoder: fakeZstdEncoder{}}
+	l.initEncodings(true)
+	l.noteAcceptEncoding("")
+	if len(l.encodings) != 2 {
+		t.Fatalf("encodings = %q; want both", l.encodings)
+	}
+	l.noteAcceptEncoding("gzip;q=1.0, identity")
+	if len(l.encodings) != 1 || l.encodings[0] != "gzip" {
+		t.Errorf("encodings = %q; want [gzip]", l.encodings)
+	}
+}
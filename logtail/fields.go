@@ -0,0 +1,135 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"tailscale.com/tailcfg"
+)
+
+// Names of fields commonly set with FieldLogger.
+const (
+	// FieldComponent is the name of the field holding the name of the
+	// component that logged an entry, like "magicsock" or "netcheck".
+	FieldComponent = "component"
+
+	// FieldPeer is the name of the field holding the stable node ID of
+	// the peer that an entry is about.
+	FieldPeer = "peer"
+)
+
+// A FieldLogger logs to a Logger with a set of structured fields added to
+// each entry, so they can be analyzed without parsing them out of the log
+// text. FieldLoggers are immutable and safe for concurrent use.
+//
+// A FieldLogger is created by Logger.With or one of its helpers, and fields
+// are added by calling the same methods on it. A nil *Logger yields
+// FieldLoggers that discard everything logged to them.
+type FieldLogger struct {
+	l      *Logger
+	fields []field // later fields override earlier ones with the same key
+}
+
+type field struct {
+	key   string
+	value any
+}
+
+// isReservedField reports whether key is one of the fields used by the
+// logging system itself, which FieldLogger ignores.
+func isReservedField(key string) bool {
+	switch strings.ToLower(key) {
+	case "logtail", "v", "text", "metrics", FieldMetricsBatch:
+		return true
+	}
+	return false
+}
+
+// With returns a FieldLogger logging to l with the field key set to value
+// in each entry. The value is encoded with encoding/json, or if that fails,
+// formatted with fmt's %v verb.
+//
+// The keys "logtail", "v", "text", "metrics" and "metrics_batch" (in any
+// case) are reserved for the logging system, and fields with them are
+// ignored.
+func (l *Logger) With(key string, value any) *FieldLogger {
+	return (&FieldLogger{l: l}).With(key, value)
+}
+
+// WithComponent is shorthand for l.With(FieldComponent, name).
+func (l *Logger) WithComponent(name string) *FieldLogger {
+	return l.With(FieldComponent, name)
+}
+
+// WithPeer is shorthand for l.With(FieldPeer, id).
+func (l *Logger) WithPeer(id tailcfg.StableNodeID) *FieldLogger {
+	return l.With(FieldPeer, id)
+}
+
+// With returns a FieldLogger logging with fl's fields plus key set to
+// value. See Logger.With.
+func (fl *FieldLogger) With(key string, value any) *FieldLogger {
+	if isReservedField(key) {
+		return fl
+	}
+	return &FieldLogger{
+		l:      fl.l,
+		fields: append(slices.Clip(fl.fields), field{key, value}),
+	}
+}
+
+// WithComponent is shorthand for fl.With(FieldComponent, name).
+func (fl *FieldLogger) WithComponent(name string) *FieldLogger {
+	return fl.With(FieldComponent, name)
+}
+
+// WithPeer is shorthand for fl.With(FieldPeer, id).
+func (fl *FieldLogger) WithPeer(id tailcfg.StableNodeID) *FieldLogger {
+	return fl.With(FieldPeer, id)
+}
+
+// Logf logs a message with fl's fields, using the provided fmt-style format
+// and optional arguments. Like with Logger.Logf, a "[v1] " or "[v2] " prefix
+// in the message sets its verbosity level.
+//
+// Only the message is written to stderr; the fields are only uploaded.
+func (fl *FieldLogger) Logf(format string, args ...any) {
+	l := fl.l
+	if l == nil {
+		return
+	}
+	level, text := parseAndRemoveLogLevel(fmt.Appendf(nil, format, args...))
+	l.writeStderr(level, text)
+
+	buf := l.redact(fl.encode(text))
+
+	l.writeLock.Lock()
+	defer l.writeLock.Unlock()
+	l.sendLocked(l.encodeLocked(buf, level))
+}
+
+// encode returns the JSON object holding fl's fields and text.
+func (fl *FieldLogger) encode(text []byte) []byte {
+	obj := make(map[string]any, len(fl.fields)+1)
+	for _, f := range fl.fields {
+		obj[f.key] = f.value
+	}
+	obj["text"] = strings.TrimSuffix(string(text), "\n")
+	b, err := json.Marshal(obj)
+	if err != nil {
+		// Some value can't be encoded as JSON. Fall back to
+		// formatting them all.
+		for k, v := range obj {
+			if k != "text" {
+				obj[k] = fmt.Sprint(v)
+			}
+		}
+		b, _ = json.Marshal(obj)
+	}
+	return b
+}
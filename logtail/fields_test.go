@@ -0,0 +1,87 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/tstest"
+)
+
+func TestFieldLogger(t *testing.T) {
+	type unencodable struct{ C chan int }
+
+	tests := []struct {
+		name string
+		log  func(*Logger)
+		want string
+	}{
+		{
+			name: "fields",
+			log: func(l *Logger) {
+				l.WithComponent("magicsock").WithPeer("nABC123").With("n", 3).Logf("hello %s", "world")
+			},
+			want: `{"component":"magicsock","logtail":{"client_time":"1970-01-01T00:02:03Z"},"n":3,"peer":"nABC123","text":"hello world"}`,
+		},
+		{
+			name: "verbose",
+			log: func(l *Logger) {
+				l.WithComponent("netcheck").Logf("[v1] report: %d", 1)
+			},
+			want: `{"component":"netcheck","logtail":{"client_time":"1970-01-01T00:02:03Z"},"text":"report: 1","v":1}`,
+		},
+		{
+			name: "override_and_reserved",
+			log: func(l *Logger) {
+				l.With("k", 1).With("k", 2).With("text", "ignored").With("V", 9).Logf("x")
+			},
+			want: `{"k":2,"logtail":{"client_time":"1970-01-01T00:02:03Z"},"text":"x"}`,
+		},
+		{
+			name: "unencodable",
+			log: func(l *Logger) {
+				l.With("bad", unencodable{}).Logf("x")
+			},
+			want: `{"bad":"{\u003cnil\u003e}","logtail":{"client_time":"1970-01-01T00:02:03Z"},"text":"x"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(simpleMemBuf)
+			var stderr bytes.Buffer
+			l := &Logger{
+				clock:  tstest.NewClock(tstest.ClockOpts{Start: time.Unix(123, 0).UTC()}),
+				buffer: buf,
+				stderr: &stderr,
+			}
+			tt.log(l)
+			if got := strings.TrimSpace(buf.buf.String()); got != tt.want {
+				t.Errorf("got:  %s\nwant: %s", got, tt.want)
+			}
+			if strings.Contains(stderr.String(), "{") {
+				t.Errorf("fields written to stderr: %q", stderr.String())
+			}
+		})
+	}
+}
+
+func TestFieldLoggerImmutable(t *testing.T) {
+	buf := new(simpleMemBuf)
+	l := &Logger{
+		clock:  tstest.NewClock(tstest.ClockOpts{Start: time.Unix(123, 0).UTC()}),
+		buffer: buf,
+	}
+	base := l.With("a", 1)
+	base.With("b", 2)
+	base.With("c", 3).Logf("x")
+	if got := buf.buf.String(); strings.Contains(got, `"b"`) {
+		t.Errorf("With modified its receiver: %s", got)
+	}
+
+	var nilLogger *Logger
+	nilLogger.WithComponent("x").Logf("discarded") // must not panic
+}
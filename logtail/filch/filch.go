@@ -36,6 +36,14 @@ type Filch struct {
 	maxFileSize  int64
 	writeCounter int
 
+	// Accounting for Stats. The sizes don't include what's written to
+	// the files directly as stderr until the next size check in Write.
+	curSize      int64 // bytes in cur
+	altSize      int64 // bytes in alt
+	altRead      int64 // bytes of alt already read
+	altLinesRead int64 // lines of alt already read
+	dropped      int64 // lines thrown away to limit the size of the files
+
 	// buf is an initial buffer for altscan.
 	// As of August 2021, 99.96% of all log lines
 	// are below 4096 bytes in length.
@@ -44,7 +52,30 @@ type Filch struct {
 	// so that the whole struct takes 4096 bytes
 	// (less on 32 bit platforms).
 	// This reduces allocation waste.
-	buf [4096 - 64]byte
+	buf [4096 - 104]byte
+}
+
+// Stats describes how full a Filch is.
+type Stats struct {
+	// Used is approximately how many bytes of logs are waiting to be
+	// read, and Capacity how many can be waiting before old ones are
+	// thrown away.
+	Used, Capacity int64
+
+	// Dropped is the number of log lines thrown away, because they
+	// weren't read before the files reached their maximum size.
+	Dropped int64
+}
+
+// Stats returns f's current statistics.
+func (f *Filch) Stats() Stats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return Stats{
+		Used:     max(f.curSize+f.altSize-f.altRead, 0),
+		Capacity: 2 * f.maxFileSize,
+		Dropped:  f.dropped,
+	}
 }
 
 // TryReadline implements the logtail.Buffer interface.
@@ -59,6 +90,8 @@ func (f *Filch) TryReadLine() ([]byte, error) {
 	}
 
 	f.cur, f.alt = f.alt, f.cur
+	f.curSize, f.altSize = f.altSize-f.altRead, f.curSize
+	f.altRead, f.altLinesRead = 0, 0
 	if f.OrigStderr != nil {
 		if err := dup2Stderr(f.cur); err != nil {
 			return nil, err
@@ -67,20 +100,22 @@ func (f *Filch) TryReadLine() ([]byte, error) {
 	if _, err := f.alt.Seek(0, io.SeekStart); err != nil {
 		return nil, err
 	}
-	f.altscan = bufio.NewScanner(f.alt)
-	f.altscan.Buffer(f.buf[:], bufio.MaxScanTokenSize)
-	f.altscan.Split(splitLines)
+	f.altscan = f.newScanner(f.alt)
 	return f.scan()
 }
 
 func (f *Filch) scan() ([]byte, error) {
 	if f.altscan.Scan() {
-		return f.altscan.Bytes(), nil
+		b := f.altscan.Bytes()
+		f.altRead += int64(len(b))
+		f.altLinesRead++
+		return b, nil
 	}
 	err := f.altscan.Err()
 	err2 := f.alt.Truncate(0)
 	_, err3 := f.alt.Seek(0, io.SeekStart)
 	f.altscan = nil
+	f.altSize, f.altRead, f.altLinesRead = 0, 0, 0
 	if err != nil {
 		return nil, err
 	}
@@ -104,12 +139,25 @@ func (f *Filch) Write(b []byte) (int, error) {
 		if err != nil {
 			return 0, err
 		}
+		f.curSize = fi.Size()
 		if fi.Size() >= f.maxFileSize {
 			// This most likely means we are not draining.
 			// To limit the amount of space we use, throw away the old logs.
+			lines, err := countLines(f.alt)
+			if err != nil {
+				return 0, err
+			}
+			f.dropped += max(lines-f.altLinesRead, 0)
 			if err := moveContents(f.alt, f.cur); err != nil {
 				return 0, err
 			}
+			f.curSize, f.altSize = 0, f.curSize
+			f.altRead, f.altLinesRead = 0, 0
+			if f.altscan != nil {
+				// Don't return what the scanner buffered of
+				// the logs thrown away.
+				f.altscan = f.newScanner(f.alt)
+			}
 		}
 	}
 	f.writeCounter++
@@ -118,9 +166,44 @@ func (f *Filch) Write(b []byte) (int, error) {
 		bnl := make([]byte, len(b)+1)
 		copy(bnl, b)
 		bnl[len(bnl)-1] = '\n'
-		return f.cur.Write(bnl)
+		b = bnl
 	}
-	return f.cur.Write(b)
+	n, err := f.cur.Write(b)
+	f.curSize += int64(n)
+	return n, err
+}
+
+func (f *Filch) newScanner(r io.Reader) *bufio.Scanner {
+	s := bufio.NewScanner(r)
+	s.Buffer(f.buf[:], bufio.MaxScanTokenSize)
+	s.Split(splitLines)
+	return s
+}
+
+// countLines returns the number of lines in file, counting a final line
+// without a trailing newline.
+func countLines(file *os.File) (int64, error) {
+	var buf [32 << 10]byte
+	var n, off int64
+	var last byte
+	for {
+		m, err := file.ReadAt(buf[:], off)
+		n += int64(bytes.Count(buf[:m], []byte{'\n'}))
+		if m > 0 {
+			last = buf[m-1]
+		}
+		off += int64(m)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if off > 0 && last != '\n' {
+		n++
+	}
+	return n, nil
 }
 
 // Close closes the Filch, releasing all os resources.
@@ -224,9 +307,13 @@ func New(filePrefix string, opts Options) (f *Filch, err error) {
 		f.cur, f.alt = f1, f2 // does not matter
 	}
 	if f.recovered > 0 {
-		f.altscan = bufio.NewScanner(f.alt)
-		f.altscan.Buffer(f.buf[:], bufio.MaxScanTokenSize)
-		f.altscan.Split(splitLines)
+		f.altscan = f.newScanner(f.alt)
+	}
+	if fi, err := f.cur.Stat(); err == nil {
+		f.curSize = fi.Size()
+	}
+	if fi, err := f.alt.Stat(); err == nil {
+		f.altSize = fi.Size()
 	}
 
 	f.OrigStderr = nil
@@ -78,6 +78,13 @@ func TestDropOldLogs(t *testing.T) {
 			for i := 0; i < tc.write; i++ {
 				f.write(t, line1)
 			}
+			st := f.Stats()
+			if want := int64(tc.write - tc.read); st.Dropped != want {
+				t.Errorf("Dropped = %d; want %d", st.Dropped, want)
+			}
+			if want := int64(tc.read * len(line1+"\n")); st.Used != want || st.Capacity != 2000 {
+				t.Errorf("Used, Capacity = %d, %d; want %d, 2000", st.Used, st.Capacity, want)
+			}
 			// We should only be able to read the last 150 lines
 			for i := 0; i < tc.read; i++ {
 				f.read(t, line1)
@@ -87,6 +94,9 @@ func TestDropOldLogs(t *testing.T) {
 				}
 			}
 			f.readEOF(t)
+			if st := f.Stats(); st.Used != 0 {
+				t.Errorf("Used = %d after reading everything; want 0", st.Used)
+			}
 		})
 	}
 }
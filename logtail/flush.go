@@ -0,0 +1,117 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// flushState tracks which logs have been uploaded, for FlushWithContext.
+//
+// Each log line successfully written to the buffer is numbered in order.
+// Whenever the uploading goroutine finds the buffer empty, all lines written
+// so far have been read, and are uploaded with the batch it's building.
+type flushState struct {
+	written atomic.Uint64 // number of lines written; only changed with Logger.writeLock held
+	drained uint64        // written as of the last time the buffer was found empty; only used by uploading
+
+	now chan struct{} // a FlushWithContext call is waiting
+
+	mu       sync.Mutex
+	uploaded uint64        // number of lines known to be uploaded
+	changed  chan struct{} // closed when uploaded changes
+}
+
+// FlushWithContext starts uploading the logs written so far, skipping any
+// flush delay or wait before retrying a failed upload, and waits for them to
+// be accepted by the log server (or Config.Sink). It returns ctx.Err() if
+// ctx is done first, or an error if the logger was shut down first. Logs
+// written concurrently might or might not be waited for.
+//
+// It's meant for callers that want best-effort delivery of their final log
+// lines before exiting.
+//
+// If l is nil, FlushWithContext does nothing and returns nil.
+func (l *Logger) FlushWithContext(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	f := &l.flush
+	target := f.written.Load()
+	for {
+		f.mu.Lock()
+		done, changed := f.uploaded >= target, f.changed
+		f.mu.Unlock()
+		if done {
+			return nil
+		}
+
+		select {
+		case f.now <- struct{}{}:
+		default:
+		}
+		l.tryDrainWake()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-l.shutdownDone:
+			f.mu.Lock()
+			done = f.uploaded >= target
+			f.mu.Unlock()
+			if done {
+				return nil
+			}
+			return errors.New("logtail: logger shut down before flushing")
+		}
+	}
+}
+
+// noteWrittenLocked records that a line was written to l.buffer.
+// l.writeLock must be held.
+func (l *Logger) noteWrittenLocked() {
+	l.flush.written.Add(1)
+}
+
+// noteDrained records that l.buffer was found empty while building a batch
+// to upload. It's only called by the uploading goroutine, with the number of
+// lines written as of before the buffer was last read.
+func (l *Logger) noteDrained(written uint64) {
+	l.flush.drained = written
+}
+
+// noteBatchUploaded records that the last batch built by drainPending was
+// uploaded, waking up any FlushWithContext calls waiting on it.
+func (l *Logger) noteBatchUploaded() {
+	f := &l.flush
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.drained <= f.uploaded {
+		return
+	}
+	f.uploaded = f.drained
+	if f.changed != nil {
+		close(f.changed)
+	}
+	f.changed = make(chan struct{})
+}
+
+// sleepRetry waits for d before retrying an upload, or until ctx is done or
+// FlushWithContext is called. It reports whether ctx is still active.
+func (l *Logger) sleepRetry(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-l.flush.now:
+	case <-ctx.Done():
+		return false
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlushWithContext(t *testing.T) {
+	var fail atomic.Bool
+	fail.Store(true)
+	var lastBody atomic.Value
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if fail.Load() {
+			// Ask for a long wait before retrying, which
+			// FlushWithContext skips.
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		lastBody.Store(string(b))
+	}))
+	defer ts.Close()
+
+	l := NewLogger(Config{
+		BaseURL:      ts.URL,
+		FlushDelayFn: func() time.Duration { return time.Hour },
+	}, t.Logf)
+	defer l.Shutdown(context.Background())
+
+	l.Logf("final words")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := l.FlushWithContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("FlushWithContext while uploads fail = %v; want deadline exceeded", err)
+	}
+
+	fail.Store(false)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := l.FlushWithContext(ctx); err != nil {
+		t.Fatalf("FlushWithContext = %v", err)
+	}
+	if body, _ := lastBody.Load().(string); !strings.Contains(body, "final words") {
+		t.Errorf("uploaded %q; want final log line", body)
+	}
+
+	// Nothing more to wait for.
+	if err := l.FlushWithContext(ctx); err != nil {
+		t.Errorf("second FlushWithContext = %v", err)
+	}
+}
+
+func TestFlushWithContextAfterShutdown(t *testing.T) {
+	var nilLogger *Logger
+	if err := nilLogger.FlushWithContext(context.Background()); err != nil {
+		t.Errorf("nil Logger: %v", err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	l := NewLogger(Config{BaseURL: ts.URL}, t.Logf)
+	l.Logf("hello")
+	if err := l.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// It mustn't wait for uploads that will never happen.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := l.FlushWithContext(ctx); errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("FlushWithContext after shutdown = %v", err)
+	}
+}
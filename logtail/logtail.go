@@ -6,18 +6,18 @@
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	mrand "math/rand"
 	"net/http"
-	"net/netip"
 	"os"
-	"regexp"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -26,7 +26,6 @@
 	"tailscale.com/envknob"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/sockstats"
-	"tailscale.com/net/tsaddr"
 	"tailscale.com/tstime"
 	tslogger "tailscale.com/types/logger"
 	"tailscale.com/types/logid"
@@ -64,12 +63,56 @@ type Config struct {
 	Buffer         Buffer          // temp storage, if nil a MemoryBuffer
 	NewZstdEncoder func() Encoder  // if set, used to compress logs for transmission
 
+	// ZstdDict, if non-nil and NewZstdEncoder is set, compresses uploads
+	// with a zstd dictionary learned from earlier uploads, if the log
+	// server supports it. See ZstdDictConfig.
+	ZstdDict *ZstdDictConfig
+
+	// GzipUploads, if true, allows compressing uploads with gzip if
+	// NewZstdEncoder is nil or the log server doesn't accept zstd.
+	GzipUploads bool
+
+	// Sink, if non-nil, is where logs are sent instead of being uploaded
+	// to the log server at BaseURL.
+	Sink Sink
+
+	// SpoolDir, if non-empty and Buffer is nil, is a directory in which
+	// logs are kept until they're uploaded, so that they survive process
+	// restarts and long offline periods. See NewSpool.
+	SpoolDir string
+	// SpoolMaxSize is the maximum total size in bytes of the files in
+	// SpoolDir. If zero, DefaultSpoolMaxSize is used.
+	SpoolMaxSize int64
+
+	// CompressedBufferSize, if positive and Buffer is nil (and SpoolDir
+	// unused), keeps logs waiting to be uploaded compressed in memory,
+	// using about this many bytes at most. See NewCompressedMemoryBuffer.
+	CompressedBufferSize int
+
 	// MetricsDelta, if non-nil, is a func that returns an encoding
 	// delta in clientmetrics to upload alongside existing logs.
 	// It can return either an empty string (for nothing) or a string
 	// that's safe to embed in a JSON string literal without further escaping.
 	MetricsDelta func() string
 
+	// MetricsUploaded, if non-nil, is called with each batch of logs, a
+	// JSON array of log entries, once it's been uploaded, so that the
+	// deltas from MetricsDelta in it can be acknowledged. It must not
+	// retain the batch. See clientmetric.AckLogTailUpload.
+	MetricsUploaded func(body []byte)
+
+	// MetricsBatchInterval, if positive, enables uploading the counters
+	// and gauges set with Logger.AddCounter and Logger.SetGauge as a
+	// separate record in a batch of logs being uploaded anyway, at most
+	// once per interval. Only the values changed since the previous
+	// record are included. See FieldMetricsBatch.
+	MetricsBatchInterval time.Duration
+
+	// BatchClientMetrics, if true and MetricsBatchInterval is positive,
+	// includes the values of all clientmetrics in metrics batch records,
+	// for environments where they can't be uploaded otherwise.
+	BatchClientMetrics bool
+
 	// FlushDelayFn, if non-nil is a func that returns how long to wait to
 	// accumulate logs before uploading them. 0 or negative means to upload
 	// immediately.
@@ -77,6 +120,18 @@ type Config struct {
 	// If nil, a default value is used. (currently 2 seconds)
 	FlushDelayFn func() time.Duration
 
+	// OnDropping, if non-nil, is called with true when the Logger starts
+	// dropping logs because its buffer is full, such as when it has been
+	// unable to upload for a long time, and with false once it has
+	// uploaded successfully without dropping any more. It's called from a
+	// new goroutine and mustn't block for long. See also
+	// Logger.BufferStats.
+	OnDropping func(dropping bool)
+
+	// Redaction configures what sensitive data is removed from logs
+	// before they're buffered and uploaded.
+	Redaction RedactionPolicy
+
 	// IncludeProcID, if true, results in an ephemeral process identifier being
 	// included in logs. The ID is random and not guaranteed to be globally
 	// unique, but it can be used to distinguish between different instances
@@ -102,6 +157,16 @@ func NewLogger(cfg Config, logf tslogger.Logf) *Logger {
 	if cfg.Stderr == nil {
 		cfg.Stderr = os.Stderr
 	}
+	if cfg.Buffer == nil && cfg.SpoolDir != "" {
+		if sp, err := NewSpool(cfg.SpoolDir, cfg.SpoolMaxSize); err != nil {
+			fmt.Fprintf(cfg.Stderr, "logtail: opening spool, using memory buffer instead: %v\n", err)
+		} else {
+			cfg.Buffer = sp
+		}
+	}
+	if cfg.Buffer == nil && cfg.CompressedBufferSize > 0 {
+		cfg.Buffer = NewCompressedMemoryBuffer(cfg.CompressedBufferSize)
+	}
 	if cfg.Buffer == nil {
 		pendingSize := 256
 		if cfg.LowMemory {
@@ -130,6 +195,11 @@ func NewLogger(cfg Config, logf tslogger.Logf) *Logger {
 		cfg.FlushDelayFn = func() time.Duration { return 0 }
 	}
 
+	redaction, err := cfg.Redaction.withEnv()
+	if err != nil {
+		fmt.Fprintf(cfg.Stderr, "logtail: %v\n", err)
+	}
+
 	var urlSuffix string
 	if !cfg.CopyPrivateID.IsZero() {
 		urlSuffix = "?copyId=" + cfg.CopyPrivateID.String()
@@ -142,12 +212,25 @@ func NewLogger(cfg Config, logf tslogger.Logf) *Logger {
 		url:            cfg.BaseURL + "/c/" + cfg.Collection + "/" + cfg.PrivateID.String() + urlSuffix,
 		lowMem:         cfg.LowMemory,
 		buffer:         cfg.Buffer,
+		sink:           cfg.Sink,
 		skipClientTime: cfg.SkipClientTime,
 		drainWake:      make(chan struct{}, 1),
 		sentinel:       make(chan int32, 16),
 		flushDelayFn:   cfg.FlushDelayFn,
 		clock:          cfg.Clock,
 		metricsDelta:   cfg.MetricsDelta,
+		redaction:      redaction,
+		drops:          dropState{notify: cfg.OnDropping},
+		flush: flushState{
+			now:     make(chan struct{}, 1),
+			changed: make(chan struct{}),
+		},
+		metricsBatch: metricsBatch{
+			interval:      cfg.MetricsBatchInterval,
+			clientMetrics: cfg.BatchClientMetrics,
+		},
+
+		metricsUploaded: cfg.MetricsUploaded,
 
 		procID:              procID,
 		includeProcSequence: cfg.IncludeProcSequence,
@@ -159,6 +242,8 @@ func NewLogger(cfg Config, logf tslogger.Logf) *Logger {
 	if cfg.NewZstdEncoder != nil {
 		l.zstdEncoder = cfg.NewZstdEncoder()
 	}
+	l.initEncodings(cfg.GzipUploads)
+	l.initZstdDict(cfg.ZstdDict)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	l.uploadCancel = cancel
@@ -179,19 +264,29 @@ type Logger struct {
 	skipClientTime bool
 	netMonitor     *netmon.Monitor
 	buffer         Buffer
+	sink           Sink                 // or nil to upload to url
 	drainWake      chan struct{}        // signal to speed up drain
 	flushDelayFn   func() time.Duration // negative or zero return value to upload aggressively, or >0 to batch at this delay
 	flushPending   atomic.Bool
 	sentinel       chan int32
 	clock          tstime.Clock
 	zstdEncoder    Encoder
+	zdict          zstdDict     // only used by uploading
+	encodings      []string     // content encodings to compress uploads with, most preferred first; only used by uploading
+	gzipWriter     *gzip.Writer // reused by compressBody; only used by uploading
 	uploadCancel   func()
 	explainedRaw   bool
 	metricsDelta   func() string // or nil
+	metricsBatch   metricsBatch
+	redaction      RedactionPolicy
+	drops          dropState
+	flush          flushState
 	privateID      logid.PrivateID
 	httpDoCalls    atomic.Int32
 	sockstatsLabel atomicSocktatsLabel
 
+	metricsUploaded func([]byte) // or nil
+
 	procID              uint32
 	includeProcSequence bool
 
@@ -265,10 +360,17 @@ func (l *Logger) Shutdown(ctx context.Context) error {
 	io.WriteString(l, "logger closing down\n")
 	<-done
 
+	var errs []error
+	if c, ok := l.sink.(io.Closer); ok {
+		errs = append(errs, c.Close())
+	}
 	if l.zstdEncoder != nil {
-		return l.zstdEncoder.Close()
+		errs = append(errs, l.zstdEncoder.Close())
 	}
-	return nil
+	if l.zdict.enc != nil {
+		errs = append(errs, l.zdict.enc.Close())
+	}
+	return errors.Join(errs...)
 }
 
 // Close shuts down this logger object, the background log uploader
@@ -302,22 +404,26 @@ func (l *Logger) drainPending(scratch []byte) (res []byte) {
 	buf := bytes.NewBuffer(scratch[:0])
 	buf.WriteByte('[')
 	entries := 0
+	written := l.flush.written.Load()
 
 	var batchDone bool
 	const maxLen = 256 << 10
 	for buf.Len() < maxLen && !batchDone {
 		b, err := l.buffer.TryReadLine()
 		if err == io.EOF {
+			l.noteDrained(written)
 			break
 		} else if err != nil {
 			b = fmt.Appendf(nil, "reading ringbuffer: %v", err)
 			batchDone = true
 		} else if b == nil {
 			if entries > 0 {
+				l.noteDrained(written)
 				break
 			}
 
 			batchDone = l.drainBlock()
+			written = l.flush.written.Load()
 			continue
 		}
 
@@ -348,6 +454,12 @@ func (l *Logger) drainPending(scratch []byte) (res []byte) {
 		entries++
 	}
 
+	if entries > 0 {
+		if b := l.encodeMetricsBatch(); b != nil {
+			buf.WriteByte(',')
+			buf.Write(b)
+		}
+	}
 	buf.WriteByte(']')
 	if buf.Len() <= len("[]") {
 		return nil
@@ -360,31 +472,54 @@ func (l *Logger) uploading(ctx context.Context) {
 	defer close(l.shutdownDone)
 
 	scratch := make([]byte, 4096) // reusable buffer to write into
+	var dropped int64             // BufferStats().Dropped as of the last successful upload
 	for {
 		body := l.drainPending(scratch)
-		origlen := -1 // sentinel value: uncompressed
-		// Don't attempt to compress tiny bodies; not worth the CPU cycles.
-		if l.zstdEncoder != nil && len(body) > 256 {
-			zbody := l.zstdEncoder.EncodeAll(body, nil)
-			// Only send it compressed if the bandwidth savings are sufficient.
-			// Just the extra headers associated with enabling compression
-			// are 50 bytes by themselves.
-			if len(body)-len(zbody) > 64 {
-				origlen = len(body)
-				body = zbody
-			}
-		}
-
+		var encoding string // content encoding of wire, or empty if uncompressed
+		var dict string     // zstd dictionary that wire is compressed with, or empty
+		var wire []byte     // body as uploaded; nil until compressed
 		var lastError string
 		var numFailures int
 		var firstFailure time.Time
 		for len(body) > 0 && ctx.Err() == nil {
-			retryAfter, err := l.upload(ctx, body, origlen)
+			var retryAfter time.Duration
+			var err error
+			if l.sink != nil {
+				retryAfter, err = l.sink.Upload(ctx, body)
+			} else {
+				if wire == nil {
+					encoding, dict, wire = l.compressBody(body)
+				}
+				retryAfter, err = l.upload(ctx, wire, encoding, dict, len(body))
+				if err == nil {
+					noteUploaded(body, wire)
+					if dict != "" {
+						l.zdict.noteZstdDictUsed()
+					}
+					l.updateZstdDict(ctx, body)
+				}
+			}
+			if errors.Is(err, errEncodingRefused) {
+				fmt.Fprintf(l.stderr, "logtail: log server refused %s uploads; retrying\n", encoding)
+				wire = nil
+				continue
+			}
+			if errors.Is(err, errZstdDictUnknown) {
+				// Send the dictionary again, right away the
+				// first time and otherwise after a backoff,
+				// uploading without it in the meantime. A log
+				// server that keeps asking for it doesn't want
+				// it.
+				l.zstdDictFailed(err, true)
+				l.resendZstdDict(ctx)
+				wire = nil
+				continue
+			}
 			if err != nil {
 				numFailures++
 				firstFailure = l.clock.Now()
 
-				if !l.internetUp() {
+				if l.sink == nil && !l.internetUp() {
 					fmt.Fprintf(l.stderr, "logtail: internet down; waiting\n")
 					l.awaitInternetUp(ctx)
 					continue
@@ -401,12 +536,22 @@ func (l *Logger) uploading(ctx context.Context) {
 				if retryAfter <= 0 {
 					retryAfter = time.Duration(30+mrand.Intn(30)) * time.Second
 				}
-				tstime.Sleep(ctx, retryAfter)
+				l.sleepRetry(ctx, retryAfter)
 			} else {
 				// Only print a success message after recovery.
 				if numFailures > 0 {
 					fmt.Fprintf(l.stderr, "logtail: upload succeeded after %d failures and %s\n", numFailures, l.clock.Since(firstFailure).Round(time.Second))
 				}
+				if cb, ok := l.buffer.(CommitBuffer); ok {
+					if err := cb.Commit(); err != nil {
+						fmt.Fprintf(l.stderr, "logtail: committing uploaded logs: %v\n", err)
+					}
+				}
+				if l.metricsUploaded != nil {
+					l.metricsUploaded(body)
+				}
+				dropped = l.noteUploadSucceeded(dropped)
+				l.noteBatchUploaded()
 				break
 			}
 		}
@@ -447,10 +592,10 @@ func (l *Logger) awaitInternetUp(ctx context.Context) {
 	}
 }
 
-// upload uploads body to the log server.
-// origlen indicates the pre-compression body length.
-// origlen of -1 indicates that the body is not compressed.
-func (l *Logger) upload(ctx context.Context, body []byte, origlen int) (retryAfter time.Duration, err error) {
+// upload uploads body, which is compressed with the given content encoding
+// (if non-empty) and zstd dictionary (if non-empty) from origlen bytes, to
+// the log server.
+func (l *Logger) upload(ctx context.Context, body []byte, encoding, dict string, origlen int) (retryAfter time.Duration, err error) {
 	const maxUploadTime = 45 * time.Second
 	ctx = sockstats.WithSockStats(ctx, l.sockstatsLabel.Load(), l.Logf)
 	ctx, cancel := context.WithTimeout(ctx, maxUploadTime)
@@ -463,15 +608,18 @@ func (l *Logger) upload(ctx context.Context, body []byte, origlen int) (retryAft
 		// TODO record logs to disk
 		panic("logtail: cannot build http request: " + err.Error())
 	}
-	if origlen != -1 {
-		req.Header.Add("Content-Encoding", "zstd")
+	if encoding != "" {
+		req.Header.Add("Content-Encoding", encoding)
 		req.Header.Add("Orig-Content-Length", strconv.Itoa(origlen))
 	}
+	if dict != "" {
+		req.Header.Add(zstdDictHeader, dict)
+	}
 	req.Header["User-Agent"] = nil // not worth writing one; save some bytes
 
 	compressedNote := "not-compressed"
-	if origlen != -1 {
-		compressedNote = "compressed"
+	if encoding != "" {
+		compressedNote = encoding + "-compressed"
 	}
 
 	l.httpDoCalls.Add(1)
@@ -480,7 +628,16 @@ func (l *Logger) upload(ctx context.Context, body []byte, origlen int) (retryAft
 		return 0, fmt.Errorf("log upload of %d bytes %s failed: %v", len(body), compressedNote, err)
 	}
 	defer resp.Body.Close()
+	l.noteAcceptEncoding(resp.Header.Get("Accept-Encoding"))
+	l.zdict.noteZstdDictVersions(resp.Header)
 
+	if resp.StatusCode == http.StatusUnsupportedMediaType && encoding != "" {
+		l.refuseEncoding(encoding)
+		return 0, errEncodingRefused
+	}
+	if resp.StatusCode == http.StatusPreconditionFailed && dict != "" {
+		return 0, errZstdDictUnknown
+	}
 	if resp.StatusCode != http.StatusOK {
 		n, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
 		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
@@ -539,6 +696,10 @@ func (l *Logger) sendLocked(jsonBlob []byte) (int, error) {
 	}
 
 	n, err := l.buffer.Write(jsonBlob)
+	l.noteSendLocked(err)
+	if err == nil {
+		l.noteWrittenLocked()
+	}
 
 	flushDelay := defaultFlushDelay
 	if l.flushDelayFn != nil {
@@ -728,8 +889,6 @@ func (l *Logger) Logf(format string, args ...any) {
 	fmt.Fprintf(l, format, args...)
 }
 
-var obscureIPs = envknob.RegisterBool("TS_OBSCURE_LOGGED_IPS")
-
 // Write logs an encoded JSON blob.
 //
 // If the []byte passed to Write is not an encoded JSON blob,
@@ -743,20 +902,9 @@ func (l *Logger) Write(buf []byte) (int, error) {
 	inLen := len(buf) // length as provided to us, before modifications to downstream writers
 
 	level, buf := parseAndRemoveLogLevel(buf)
-	if l.stderr != nil && l.stderr != io.Discard && int64(level) <= atomic.LoadInt64(&l.stderrLevel) {
-		if buf[len(buf)-1] == '\n' {
-			l.stderr.Write(buf)
-		} else {
-			// The log package always line-terminates logs,
-			// so this is an uncommon path.
-			withNL := append(buf[:len(buf):len(buf)], '\n')
-			l.stderr.Write(withNL)
-		}
-	}
+	l.writeStderr(level, buf)
 
-	if obscureIPs() {
-		buf = redactIPs(buf)
-	}
+	buf = l.redact(buf)
 
 	l.writeLock.Lock()
 	defer l.writeLock.Unlock()
@@ -766,38 +914,20 @@ func (l *Logger) Write(buf []byte) (int, error) {
 	return inLen, err
 }
 
-var (
-	regexMatchesIPv6 = regexp.MustCompile(`([0-9a-fA-F]{1,4}):([0-9a-fA-F]{1,4}):([0-9a-fA-F:]{1,4})*`)
-	regexMatchesIPv4 = regexp.MustCompile(`(\d{1,3})\.(\d{1,3})\.\d{1,3}\.\d{1,3}`)
-)
-
-// redactIPs is a helper function used in Write() to redact IPs (other than tailscale IPs).
-// This function takes a log line as a byte slice and
-// uses regex matching to parse and find IP addresses. Based on if the IP address is IPv4 or
-// IPv6, it parses and replaces the end of the addresses with an "x". This function returns the
-// log line with the IPs redacted.
-func redactIPs(buf []byte) []byte {
-	out := regexMatchesIPv6.ReplaceAllFunc(buf, func(b []byte) []byte {
-		ip, err := netip.ParseAddr(string(b))
-		if err != nil || tsaddr.IsTailscaleIP(ip) {
-			return b // don't change this one
-		}
-
-		prefix := bytes.Split(b, []byte(":"))
-		return bytes.Join(append(prefix[:2], []byte("x")), []byte(":"))
-	})
-
-	out = regexMatchesIPv4.ReplaceAllFunc(out, func(b []byte) []byte {
-		ip, err := netip.ParseAddr(string(b))
-		if err != nil || tsaddr.IsTailscaleIP(ip) {
-			return b // don't change this one
-		}
-
-		prefix := bytes.Split(b, []byte("."))
-		return bytes.Join(append(prefix[:2], []byte("x.x")), []byte("."))
-	})
-
-	return []byte(out)
+// writeStderr writes buf, a log line at the given verbosity level, to
+// l.stderr, if l logs that level there.
+func (l *Logger) writeStderr(level int, buf []byte) {
+	if l.stderr == nil || l.stderr == io.Discard || int64(level) > atomic.LoadInt64(&l.stderrLevel) {
+		return
+	}
+	if len(buf) > 0 && buf[len(buf)-1] == '\n' {
+		l.stderr.Write(buf)
+	} else {
+		// The log package always line-terminates logs,
+		// so this is an uncommon path.
+		withNL := append(buf[:len(buf):len(buf)], '\n')
+		l.stderr.Write(withNL)
+	}
 }
 
 var (
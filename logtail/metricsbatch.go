@@ -0,0 +1,128 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/mak"
+)
+
+// FieldMetricsBatch is the name of the field holding the counters and
+// gauges of a metrics batch record. See Config.MetricsBatchInterval.
+const FieldMetricsBatch = "metrics_batch"
+
+// metricsBatch accumulates counters and gauges to upload with logs.
+type metricsBatch struct {
+	interval      time.Duration // zero if disabled
+	clientMetrics bool          // whether to include clientmetric values
+
+	mu       sync.Mutex
+	counters map[string]int64 // deltas not yet uploaded
+	gauges   map[string]int64 // values not yet uploaded
+
+	// Only used by the uploading goroutine:
+	last        time.Time        // when the last batch was encoded
+	lastClients map[string]int64 // clientmetric values as of the last batch
+}
+
+// AddCounter adds delta to the counter with the given name, to be uploaded
+// in the next metrics batch. It does nothing unless l was configured with a
+// positive Config.MetricsBatchInterval.
+func (l *Logger) AddCounter(name string, delta int64) {
+	mb := &l.metricsBatch
+	if mb.interval <= 0 || delta == 0 {
+		return
+	}
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mak.Set(&mb.counters, name, mb.counters[name]+delta)
+}
+
+// SetGauge sets the gauge with the given name to value, to be uploaded in
+// the next metrics batch. It does nothing unless l was configured with a
+// positive Config.MetricsBatchInterval.
+func (l *Logger) SetGauge(name string, value int64) {
+	mb := &l.metricsBatch
+	if mb.interval <= 0 {
+		return
+	}
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mak.Set(&mb.gauges, name, value)
+}
+
+// takeClientMetricsLocked adds the changes in clientmetric values since the
+// last batch to mb's counters and gauges.
+func (mb *metricsBatch) takeClientMetricsLocked() {
+	for _, m := range clientmetric.Metrics() {
+		name, v := m.Name(), m.Value()
+		last, ok := mb.lastClients[name]
+		if ok && v == last {
+			continue
+		}
+		mak.Set(&mb.lastClients, name, v)
+		switch m.Type() {
+		case clientmetric.TypeCounter:
+			if d := v - last; d != 0 {
+				mak.Set(&mb.counters, name, mb.counters[name]+d)
+			}
+		case clientmetric.TypeGauge:
+			mak.Set(&mb.gauges, name, v)
+		}
+	}
+}
+
+// encodeMetricsBatch returns a metrics batch record holding the counters
+// and gauges changed since the last one, or nil if there are none or it's
+// not yet time for another. It's only called by the uploading goroutine.
+func (l *Logger) encodeMetricsBatch() []byte {
+	mb := &l.metricsBatch
+	if mb.interval <= 0 {
+		return nil
+	}
+	now := l.clock.Now()
+	if !mb.last.IsZero() && now.Sub(mb.last) < mb.interval {
+		return nil
+	}
+
+	mb.mu.Lock()
+	if mb.clientMetrics {
+		mb.takeClientMetricsLocked()
+	}
+	counters, gauges := mb.counters, mb.gauges
+	mb.counters, mb.gauges = nil, nil
+	mb.mu.Unlock()
+	if len(counters) == 0 && len(gauges) == 0 {
+		return nil
+	}
+	mb.last = now
+
+	type batch struct {
+		Counters map[string]int64 `json:"counters,omitempty"`
+		Gauges   map[string]int64 `json:"gauges,omitempty"`
+	}
+	rec := map[string]any{
+		FieldMetricsBatch: batch{counters, gauges},
+	}
+	if !l.skipClientTime || l.procID != 0 {
+		logtail := map[string]any{}
+		if !l.skipClientTime {
+			logtail["client_time"] = now.UTC().Format(time.RFC3339Nano)
+		}
+		if l.procID != 0 {
+			logtail["proc_id"] = l.procID
+		}
+		rec["logtail"] = logtail
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		// Can't happen; it's all strings and numbers.
+		return nil
+	}
+	return b
+}
@@ -0,0 +1,103 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"tailscale.com/tstest"
+	"tailscale.com/util/clientmetric"
+)
+
+func TestMetricsBatch(t *testing.T) {
+	clock := tstest.NewClock(tstest.ClockOpts{Start: time.Unix(123, 0).UTC()})
+	l := &Logger{
+		clock:        clock,
+		buffer:       NewMemoryBuffer(100),
+		metricsBatch: metricsBatch{interval: time.Minute},
+	}
+
+	type batch struct {
+		Counters map[string]int64
+		Gauges   map[string]int64
+	}
+	// drain returns the metrics batch in the next upload, if any.
+	drain := func() *batch {
+		t.Helper()
+		l.Logf("some log")
+		var ents []map[string]json.RawMessage
+		if err := json.Unmarshal(l.drainPending(nil), &ents); err != nil {
+			t.Fatal(err)
+		}
+		var ret *batch
+		for _, ent := range ents {
+			if raw, ok := ent[FieldMetricsBatch]; ok {
+				if ret != nil {
+					t.Fatal("more than one metrics batch")
+				}
+				ret = new(batch)
+				if err := json.Unmarshal(raw, ret); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		return ret
+	}
+
+	if b := drain(); b != nil {
+		t.Errorf("batch with no metrics: %+v", b)
+	}
+	l.AddCounter("requests", 2)
+	l.AddCounter("requests", 3)
+	l.SetGauge("peers", 7)
+	b := drain()
+	if b == nil || b.Counters["requests"] != 5 || b.Gauges["peers"] != 7 {
+		t.Fatalf("got batch %+v; want requests=5, peers=7", b)
+	}
+
+	// Within the interval, values accumulate until the next batch.
+	l.AddCounter("requests", 1)
+	if b := drain(); b != nil {
+		t.Errorf("batch within interval: %+v", b)
+	}
+	l.AddCounter("requests", 1)
+	clock.Advance(time.Minute)
+	b = drain()
+	if b == nil || b.Counters["requests"] != 2 || len(b.Gauges) != 0 {
+		t.Fatalf("got batch %+v; want only requests=2", b)
+	}
+
+	// Without an interval, nothing is batched.
+	l2 := &Logger{clock: clock}
+	l2.AddCounter("requests", 1)
+	if b := l2.encodeMetricsBatch(); b != nil {
+		t.Errorf("batch without interval: %s", b)
+	}
+}
+
+func TestMetricsBatchClientMetrics(t *testing.T) {
+	c := clientmetric.NewCounter("test_logtail_metrics_batch_counter")
+	c.Add(3)
+	l := &Logger{
+		clock:          tstest.NewClock(tstest.ClockOpts{}),
+		skipClientTime: true,
+		metricsBatch:   metricsBatch{interval: time.Nanosecond, clientMetrics: true},
+	}
+	l.encodeMetricsBatch()
+	c.Add(2)
+	l.clock.(*tstest.Clock).Advance(time.Second)
+	var rec struct {
+		Batch struct {
+			Counters map[string]int64
+		} `json:"metrics_batch"`
+	}
+	if err := json.Unmarshal(l.encodeMetricsBatch(), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.Batch.Counters[c.Name()]; got != 2 {
+		t.Errorf("counter delta = %d; want 2", got)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"regexp"
+
+	"tailscale.com/envknob"
+	"tailscale.com/net/tsaddr"
+)
+
+var (
+	obscureIPs      = envknob.RegisterBool("TS_OBSCURE_LOGGED_IPS")
+	redactMACs      = envknob.RegisterBool("TS_REDACT_LOGGED_MACS")
+	redactEmails    = envknob.RegisterBool("TS_REDACT_LOGGED_EMAILS")
+	redactHostnames = envknob.RegisterString("TS_REDACT_LOGGED_HOSTNAMES")
+)
+
+// RedactionPolicy configures what sensitive data a Logger removes from
+// logs before buffering and uploading them. Logs written to stderr aren't
+// redacted.
+//
+// Each kind of redaction can also be enabled by an environment variable,
+// which adds to the policy in Config.Redaction.
+type RedactionPolicy struct {
+	// IPs, if true, obscures IP addresses other than Tailscale ones,
+	// keeping only their first half, as in "192.168.x.x".
+	// It's also enabled by TS_OBSCURE_LOGGED_IPS=true.
+	IPs bool
+
+	// MACs, if true, obscures MAC addresses, keeping only their first
+	// three bytes (the vendor's OUI), as in "00:1a:2b:x:x:x".
+	// It's also enabled by TS_REDACT_LOGGED_MACS=true.
+	MACs bool
+
+	// Emails, if true, replaces email addresses with "[email]".
+	// It's also enabled by TS_REDACT_LOGGED_EMAILS=true.
+	Emails bool
+
+	// Hostnames, if non-empty, are patterns matching host names (or any
+	// other text) to replace with "[hostname]", such as
+	// `[a-z0-9-]+\.corp\.example\.com`.
+	// A pattern can also be set with TS_REDACT_LOGGED_HOSTNAMES.
+	Hostnames []*regexp.Regexp
+}
+
+// withEnv returns p plus the redactions enabled by environment variables.
+// It returns an error if TS_REDACT_LOGGED_HOSTNAMES is invalid.
+func (p RedactionPolicy) withEnv() (RedactionPolicy, error) {
+	p.MACs = p.MACs || redactMACs()
+	p.Emails = p.Emails || redactEmails()
+	// IPs are checked on each use, for tests and compatibility with
+	// the behavior before RedactionPolicy existed.
+	if s := redactHostnames(); s != "" {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return p, fmt.Errorf("invalid TS_REDACT_LOGGED_HOSTNAMES: %w", err)
+		}
+		p.Hostnames = append(p.Hostnames[:len(p.Hostnames):len(p.Hostnames)], re)
+	}
+	return p, nil
+}
+
+// redact returns buf with the data that l's redaction policy removes
+// replaced. It may modify buf in place.
+func (l *Logger) redact(buf []byte) []byte {
+	p := &l.redaction
+	// MAC addresses and emails go first, as parts of them could
+	// otherwise be taken for IP addresses or host names.
+	if p.MACs {
+		buf = redactMACAddrs(buf)
+	}
+	if p.Emails {
+		buf = regexMatchesEmail.ReplaceAll(buf, []byte("[email]"))
+	}
+	for _, re := range p.Hostnames {
+		buf = re.ReplaceAll(buf, []byte("[hostname]"))
+	}
+	if p.IPs || obscureIPs() {
+		buf = redactIPs(buf)
+	}
+	return buf
+}
+
+// redactMACAddrs returns buf with the last three bytes of each MAC address
+// in it replaced by "x".
+func redactMACAddrs(buf []byte) []byte {
+	return regexMatchesMAC.ReplaceAllFunc(buf, func(b []byte) []byte {
+		sep := b[2]
+		out := append([]byte(nil), b[:9]...) // "00:1a:2b:"
+		return append(out, 'x', sep, 'x', sep, 'x')
+	})
+}
+
+var (
+	regexMatchesIPv6  = regexp.MustCompile(`([0-9a-fA-F]{1,4}):([0-9a-fA-F]{1,4}):([0-9a-fA-F:]{1,4})*`)
+	regexMatchesIPv4  = regexp.MustCompile(`(\d{1,3})\.(\d{1,3})\.\d{1,3}\.\d{1,3}`)
+	regexMatchesMAC   = regexp.MustCompile(`\b([0-9a-fA-F]{2}[:-]){5}[0-9a-fA-F]{2}\b`)
+	regexMatchesEmail = regexp.MustCompile(`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9-]+(\.[a-zA-Z0-9-]+)*\.[a-zA-Z]{2,}`)
+)
+
+// redactIPs is a helper function used in Write() to redact IPs (other than tailscale IPs).
+// This function takes a log line as a byte slice and
+// uses regex matching to parse and find IP addresses. Based on if the IP address is IPv4 or
+// IPv6, it parses and replaces the end of the addresses with an "x". This function returns the
+// log line with the IPs redacted.
+func redactIPs(buf []byte) []byte {
+	out := regexMatchesIPv6.ReplaceAllFunc(buf, func(b []byte) []byte {
+		ip, err := netip.ParseAddr(string(b))
+		if err != nil || tsaddr.IsTailscaleIP(ip) {
+			return b // don't change this one
+		}
+
+		prefix := bytes.Split(b, []byte(":"))
+		return bytes.Join(append(prefix[:2], []byte("x")), []byte(":"))
+	})
+
+	out = regexMatchesIPv4.ReplaceAllFunc(out, func(b []byte) []byte {
+		ip, err := netip.ParseAddr(string(b))
+		if err != nil || tsaddr.IsTailscaleIP(ip) {
+			return b // don't change this one
+		}
+
+		prefix := bytes.Split(b, []byte("."))
+		return bytes.Join(append(prefix[:2], []byte("x.x")), []byte("."))
+	})
+
+	return []byte(out)
+}
@@ -0,0 +1,96 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/envknob"
+	"tailscale.com/tstest"
+)
+
+func TestRedactionPolicy(t *testing.T) {
+	envknob.Setenv("TS_OBSCURE_LOGGED_IPS", "") // TestRedact may have set it
+	tests := []struct {
+		name   string
+		policy RedactionPolicy
+		in     string
+		want   string
+	}{
+		{
+			name: "none",
+			in:   "link 00:1a:2b:3c:4d:5e up; owner bob@example.com at 10.0.0.1",
+			want: "link 00:1a:2b:3c:4d:5e up; owner bob@example.com at 10.0.0.1",
+		},
+		{
+			name:   "macs",
+			policy: RedactionPolicy{MACs: true},
+			in:     "link 00:1a:2b:3c:4d:5e up, peer AA-BB-CC-DD-EE-FF; not 2001:db8::1",
+			want:   "link 00:1a:2b:x:x:x up, peer AA-BB-CC-x-x-x; not 2001:db8::1",
+		},
+		{
+			name:   "emails",
+			policy: RedactionPolicy{Emails: true},
+			in:     "user bob.smith+ts@mail.example.co.uk logged in (not user@github)",
+			want:   "user [email] logged in (not user@github)",
+		},
+		{
+			name: "hostnames",
+			policy: RedactionPolicy{Hostnames: []*regexp.Regexp{
+				regexp.MustCompile(`[a-z0-9-]+\.corp\.example\.com`),
+			}},
+			in:   "dialing db-1.corp.example.com and www.example.com",
+			want: "dialing [hostname] and www.example.com",
+		},
+		{
+			name:   "all",
+			policy: RedactionPolicy{IPs: true, MACs: true, Emails: true},
+			in:     "bob@example.com on 00:1a:2b:3c:4d:5e at 192.168.1.20 and 100.64.0.1",
+			want:   "[email] on 00:1a:2b:x:x:x at 192.168.x.x and 100.64.0.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Logger{redaction: tt.policy}
+			if got := string(l.redact([]byte(tt.in))); got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactionPolicyEnv(t *testing.T) {
+	envknob.Setenv("TS_REDACT_LOGGED_EMAILS", "true")
+	envknob.Setenv("TS_REDACT_LOGGED_HOSTNAMES", `secret-[a-z]+`)
+	defer envknob.Setenv("TS_REDACT_LOGGED_EMAILS", "")
+	defer envknob.Setenv("TS_REDACT_LOGGED_HOSTNAMES", "")
+
+	p, err := RedactionPolicy{}.withEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(simpleMemBuf)
+	l := &Logger{
+		clock:     tstest.NewClock(tstest.ClockOpts{Start: time.Unix(123, 0).UTC()}),
+		buffer:    buf,
+		redaction: p,
+	}
+	l.Logf("alice@example.com connected to secret-box")
+	l.WithComponent("test").Logf("bob@example.com too")
+	got := buf.buf.String()
+	if strings.Contains(got, "@example.com") || strings.Contains(got, "secret-box") {
+		t.Errorf("not redacted: %s", got)
+	}
+	if !strings.Contains(got, "[email] connected to [hostname]") {
+		t.Errorf("unexpected output: %s", got)
+	}
+
+	envknob.Setenv("TS_REDACT_LOGGED_HOSTNAMES", `(`)
+	if _, err := (RedactionPolicy{}).withEnv(); err == nil {
+		t.Error("invalid TS_REDACT_LOGGED_HOSTNAMES accepted")
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// A Sink is a destination for logs other than the log server at
+// Config.BaseURL, such as a local file, syslog, or an OpenTelemetry
+// collector.
+//
+// If a Sink implements io.Closer, it is closed when the Logger is shut down.
+type Sink interface {
+	// Upload sends body, a JSON array of log entries as uploaded to
+	// the log server, to the sink. If it fails, Upload is called again
+	// with the same body after retryAfter, if positive, or otherwise
+	// after a random delay.
+	Upload(ctx context.Context, body []byte) (retryAfter time.Duration, err error)
+}
+
+// logEntry is the subset of the fields of a log entry that sinks need to
+// interpret. Entries can contain other fields too.
+type logEntry struct {
+	Logtail struct {
+		ClientTime time.Time `json:"client_time"`
+	} `json:"logtail"`
+	Text string `json:"text"`
+	V    int    `json:"v"` // verbosity level; 0 is the non-verbose messages
+}
+
+// parseEntry parses the log entry raw. It returns the fields of raw not in
+// logEntry (nor "metrics", which sinks ignore) in extra.
+func parseEntry(raw json.RawMessage) (e logEntry, extra map[string]json.RawMessage) {
+	json.Unmarshal(raw, &e)
+	json.Unmarshal(raw, &extra)
+	for _, k := range []string{"logtail", "text", "v", "metrics"} {
+		delete(extra, k)
+	}
+	return e, extra
+}
+
+// splitBatch splits body, a JSON array of log entries, into its entries.
+func splitBatch(body []byte) ([]json.RawMessage, error) {
+	var ents []json.RawMessage
+	if err := json.Unmarshal(body, &ents); err != nil {
+		return nil, fmt.Errorf("logtail: malformed log batch: %w", err)
+	}
+	return ents, nil
+}
+
+// FileSink is a Sink that appends logs to a file, one JSON entry per line.
+type FileSink struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewFileSink returns a FileSink appending to the file at path, which is
+// created if needed.
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{f: f}, nil
+}
+
+// Upload implements Sink.
+func (s *FileSink) Upload(ctx context.Context, body []byte) (time.Duration, error) {
+	ents, err := splitBatch(body)
+	if err != nil {
+		return 0, nil // retrying won't help
+	}
+	var buf []byte
+	for _, e := range ents {
+		buf = append(buf, e...)
+		buf = append(buf, '\n')
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.Write(buf)
+	return 0, err
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
@@ -0,0 +1,27 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build windows || wasm || plan9 || tamago
+
+package logtail
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// SyslogSink is a Sink that sends logs to the local syslog daemon. It's
+// not supported on this platform.
+type SyslogSink struct{}
+
+// NewSyslogSink returns an error, as syslog isn't supported on this
+// platform.
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	return nil, errors.New("logtail: syslog not supported on this platform")
+}
+
+// Upload implements Sink.
+func (s *SyslogSink) Upload(ctx context.Context, body []byte) (time.Duration, error) {
+	return 0, errors.New("logtail: syslog not supported on this platform")
+}
@@ -0,0 +1,164 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// OTLP severity numbers, from the OpenTelemetry logs data model.
+const (
+	otlpSeverityDebug = 5
+	otlpSeverityInfo  = 9
+)
+
+// OTLPSink is a Sink that exports logs to an OpenTelemetry collector using
+// OTLP/HTTP with JSON encoding.
+//
+// Plain text log entries are exported with their text as the log record
+// body. The other fields of structured entries are exported as attributes
+// holding their JSON encoding. Non-verbose entries have the INFO severity
+// and verbose ones DEBUG.
+type OTLPSink struct {
+	url         string
+	httpc       *http.Client
+	serviceName string
+}
+
+// NewOTLPSink returns an OTLPSink exporting to url, the collector's logs
+// endpoint (typically "http://host:4318/v1/logs"), using httpc, or
+// http.DefaultClient if nil. The logs' resource has serviceName as its
+// service.name attribute.
+func NewOTLPSink(url string, httpc *http.Client, serviceName string) *OTLPSink {
+	if httpc == nil {
+		httpc = http.DefaultClient
+	}
+	return &OTLPSink{url: url, httpc: httpc, serviceName: serviceName}
+}
+
+// The following types are the subset of the OTLP/JSON encoding of
+// ExportLogsServiceRequest that OTLPSink uses.
+
+type otlpLogsRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         string         `json:"timeUnixNano,omitempty"`
+	ObservedTimeUnixNano string         `json:"observedTimeUnixNano"`
+	SeverityNumber       int            `json:"severityNumber"`
+	SeverityText         string         `json:"severityText"`
+	Body                 otlpAnyValue   `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// logsRequest returns the OTLP request exporting ents, observed at now.
+func (s *OTLPSink) logsRequest(ents []json.RawMessage, now time.Time) *otlpLogsRequest {
+	recs := make([]otlpLogRecord, 0, len(ents))
+	for _, raw := range ents {
+		e, extra := parseEntry(raw)
+		rec := otlpLogRecord{
+			ObservedTimeUnixNano: strconv.FormatInt(now.UnixNano(), 10),
+			SeverityNumber:       otlpSeverityInfo,
+			SeverityText:         "INFO",
+			Body:                 otlpAnyValue{StringValue: e.Text},
+		}
+		if !e.Logtail.ClientTime.IsZero() {
+			rec.TimeUnixNano = strconv.FormatInt(e.Logtail.ClientTime.UnixNano(), 10)
+		}
+		if e.V > 0 {
+			rec.SeverityNumber = otlpSeverityDebug
+			rec.SeverityText = "DEBUG"
+		}
+		keys := make([]string, 0, len(extra))
+		for k := range extra {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			rec.Attributes = append(rec.Attributes, otlpKeyValue{
+				Key:   k,
+				Value: otlpAnyValue{StringValue: string(extra[k])},
+			})
+		}
+		recs = append(recs, rec)
+	}
+	return &otlpLogsRequest{
+		ResourceLogs: []otlpResourceLogs{{
+			Resource: otlpResource{
+				Attributes: []otlpKeyValue{{
+					Key:   "service.name",
+					Value: otlpAnyValue{StringValue: s.serviceName},
+				}},
+			},
+			ScopeLogs: []otlpScopeLogs{{
+				Scope:      otlpScope{Name: "tailscale.com/logtail"},
+				LogRecords: recs,
+			}},
+		}},
+	}
+}
+
+// Upload implements Sink.
+func (s *OTLPSink) Upload(ctx context.Context, body []byte) (retryAfter time.Duration, err error) {
+	ents, err := splitBatch(body)
+	if err != nil {
+		return 0, nil // retrying won't help
+	}
+	reqBody, err := json.Marshal(s.logsRequest(ents, time.Now()))
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url, bytes.NewReader(reqBody))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.httpc.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("OTLP export of %d logs failed: %w", len(ents), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		n, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return time.Duration(n) * time.Second, fmt.Errorf("OTLP export of %d logs failed %d: %s", len(ents), resp.StatusCode, bytes.TrimSpace(b))
+	}
+	return 0, nil
+}
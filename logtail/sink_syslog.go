@@ -0,0 +1,87 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !windows && !wasm && !plan9 && !tamago
+
+package logtail
+
+import (
+	"context"
+	"crypto/sha256"
+	"log/syslog"
+	"sync"
+	"time"
+)
+
+// SyslogSink is a Sink that sends logs to the local syslog daemon.
+//
+// Plain text log entries are sent as their text; structured ones are sent
+// as their JSON encoding. Non-verbose entries are logged at the info
+// level and verbose ones at the debug level.
+type SyslogSink struct {
+	w syslogWriter
+
+	mu sync.Mutex
+	// If the last batch failed part way through, partial is its hash and
+	// sent is how many of its entries were logged, so that those aren't
+	// logged again when it's retried.
+	partial [sha256.Size]byte
+	sent    int
+}
+
+// syslogWriter is the subset of *syslog.Writer used by SyslogSink.
+type syslogWriter interface {
+	Info(string) error
+	Debug(string) error
+	Close() error
+}
+
+// NewSyslogSink returns a SyslogSink logging with the given tag, using the
+// daemon facility.
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{w: w}, nil
+}
+
+// Upload implements Sink. If logging an entry fails, the ones before it
+// aren't logged again when the batch is retried.
+func (s *SyslogSink) Upload(ctx context.Context, body []byte) (time.Duration, error) {
+	ents, err := splitBatch(body)
+	if err != nil {
+		return 0, nil // retrying won't help
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := sha256.Sum256(body)
+	start := 0
+	if s.sent > 0 && h == s.partial {
+		start = s.sent
+	}
+	s.sent = 0
+	for i, raw := range ents[start:] {
+		e, extra := parseEntry(raw)
+		msg := e.Text
+		if len(extra) > 0 {
+			msg = string(raw)
+		}
+		if e.V > 0 {
+			err = s.w.Debug(msg)
+		} else {
+			err = s.w.Info(msg)
+		}
+		if err != nil {
+			s.partial = h
+			s.sent = start + i
+			return 0, err
+		}
+	}
+	return 0, nil
+}
+
+// Close closes the connection to the syslog daemon.
+func (s *SyslogSink) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,63 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !windows && !wasm && !plan9 && !tamago
+
+package logtail
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// fakeSyslog is a syslogWriter that records the messages it logs and fails
+// when failAt messages have been logged.
+type fakeSyslog struct {
+	msgs   []string
+	failAt int // or -1 to never fail
+}
+
+func (w *fakeSyslog) log(msg string) error {
+	if len(w.msgs) == w.failAt {
+		w.failAt = -1
+		return errors.New("syslog unavailable")
+	}
+	w.msgs = append(w.msgs, msg)
+	return nil
+}
+
+func (w *fakeSyslog) Info(msg string) error  { return w.log(msg) }
+func (w *fakeSyslog) Debug(msg string) error { return w.log(msg) }
+func (w *fakeSyslog) Close() error           { return nil }
+
+func TestSyslogSinkPartialWrite(t *testing.T) {
+	w := &fakeSyslog{failAt: 1}
+	s := &SyslogSink{w: w}
+	ctx := context.Background()
+
+	if _, err := s.Upload(ctx, []byte(testBatch)); err == nil {
+		t.Fatal("Upload succeeded; want error")
+	}
+	if _, err := s.Upload(ctx, []byte(testBatch)); err != nil {
+		t.Fatalf("retried Upload: %v", err)
+	}
+	want := []string{
+		"hello\n",
+		"verbose\n",
+		`{"logtail": {"client_time": "2024-03-01T12:00:02Z"}, "peer": "n123", "count": 3}`,
+	}
+	if !slices.Equal(w.msgs, want) {
+		t.Errorf("logged %q; want %q", w.msgs, want)
+	}
+
+	// Once a batch went through, the next one is logged in full, even if
+	// it has the same contents.
+	if _, err := s.Upload(ctx, []byte(testBatch)); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.msgs) != 2*len(want) {
+		t.Errorf("logged %d messages after second batch; want %d", len(w.msgs), 2*len(want))
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testBatch = `[{"logtail": {"client_time": "2024-03-01T12:00:00Z"}, "text": "hello\n"},` +
+	`{"logtail": {"client_time": "2024-03-01T12:00:01Z"}, "v":1, "text": "verbose\n"},` +
+	`{"logtail": {"client_time": "2024-03-01T12:00:02Z"}, "peer": "n123", "count": 3}]`
+
+type chanSink chan []byte
+
+func (s chanSink) Upload(ctx context.Context, body []byte) (time.Duration, error) {
+	s <- append([]byte(nil), body...)
+	return 0, nil
+}
+
+func TestLoggerSink(t *testing.T) {
+	sink := make(chanSink, 10)
+	l := NewLogger(Config{
+		BaseURL:      "http://unused.invalid",
+		Sink:         sink,
+		FlushDelayFn: func() time.Duration { return 0 },
+	}, t.Logf)
+	defer l.Shutdown(context.Background())
+
+	l.Logf("to the sink")
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case body := <-sink:
+			if strings.Contains(string(body), "to the sink") {
+				return
+			}
+		case <-deadline:
+			t.Fatal("log line never reached sink")
+		}
+	}
+}
+
+func TestMetricsUploaded(t *testing.T) {
+	uploaded := make(chan []byte, 10)
+	l := NewLogger(Config{
+		BaseURL:         "http://unused.invalid",
+		Sink:            make(chanSink, 10),
+		FlushDelayFn:    func() time.Duration { return 0 },
+		MetricsDelta:    func() string { return "Q02" },
+		MetricsUploaded: func(body []byte) { uploaded <- append([]byte(nil), body...) },
+	}, t.Logf)
+	defer l.Shutdown(context.Background())
+
+	l.Logf("with metrics")
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case body := <-uploaded:
+			if strings.Contains(string(body), `"metrics": "Q02"`) {
+				return
+			}
+		case <-deadline:
+			t.Fatal("upload of metrics never acknowledged")
+		}
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tailscaled.log")
+	s, err := NewFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if _, err := s.Upload(context.Background(), []byte(testBatch)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("got %d lines; want 6:\n%s", len(lines), b)
+	}
+	for _, line := range lines {
+		if !json.Valid([]byte(line)) {
+			t.Errorf("invalid JSON line %q", line)
+		}
+	}
+}
+
+func TestOTLPSink(t *testing.T) {
+	var got otlpLogsRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("bad request body: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	s := NewOTLPSink(ts.URL+"/v1/logs", ts.Client(), "tailscaled")
+	if _, err := s.Upload(context.Background(), []byte(testBatch)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.ResourceLogs) != 1 || len(got.ResourceLogs[0].ScopeLogs) != 1 {
+		t.Fatalf("unexpected request shape: %+v", got)
+	}
+	if a := got.ResourceLogs[0].Resource.Attributes; len(a) != 1 || a[0].Value.StringValue != "tailscaled" {
+		t.Errorf("resource attributes = %+v", a)
+	}
+	recs := got.ResourceLogs[0].ScopeLogs[0].LogRecords
+	if len(recs) != 3 {
+		t.Fatalf("got %d records; want 3", len(recs))
+	}
+	if recs[0].Body.StringValue != "hello\n" || recs[0].SeverityNumber != otlpSeverityInfo {
+		t.Errorf("record 0 = %+v", recs[0])
+	}
+	if want := "1709294400000000000"; recs[0].TimeUnixNano != want {
+		t.Errorf("record 0 time = %q; want %q", recs[0].TimeUnixNano, want)
+	}
+	if recs[1].SeverityNumber != otlpSeverityDebug {
+		t.Errorf("record 1 severity = %v; want debug", recs[1].SeverityNumber)
+	}
+	wantAttrs := []otlpKeyValue{
+		{Key: "count", Value: otlpAnyValue{StringValue: "3"}},
+		{Key: "peer", Value: otlpAnyValue{StringValue: `"n123"`}},
+	}
+	if a := recs[2].Attributes; len(a) != 2 || a[0] != wantAttrs[0] || a[1] != wantAttrs[1] {
+		t.Errorf("record 2 attributes = %+v; want %+v", a, wantAttrs)
+	}
+}
@@ -0,0 +1,437 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"tailscale.com/atomicfile"
+)
+
+// DefaultSpoolMaxSize is the maximum size of a Spool's directory if none is
+// specified.
+const DefaultSpoolMaxSize = 64 << 20
+
+const (
+	// spoolSegments is how many segment files a full Spool is split into.
+	// When a Spool is full, its oldest segment is dropped to make room.
+	spoolSegments = 8
+
+	spoolSegSuffix  = ".spool"
+	spoolCursorFile = "cursor"
+
+	// spoolFrameHeaderLen is the length of the header before each log
+	// line in a segment file: the little-endian uint32 length of the
+	// line, then the little-endian CRC-32 (IEEE) of the line.
+	spoolFrameHeaderLen = 8
+)
+
+var errSpoolLineTooLong = errors.New("logtail: log line too long for spool")
+
+// A Spool is a Buffer that keeps log lines in files in a directory until
+// they're uploaded, so that they survive process restarts and long offline
+// periods.
+//
+// Lines are appended to segment files, which are rotated as they fill up.
+// Once the directory reaches its maximum size, the oldest segment is
+// dropped, along with any lines in it that hadn't been uploaded yet.
+//
+// Lines read from a Spool are only forgotten once Commit is called, which
+// Logger does after uploading them. If the process exits before that, they
+// are read again by the next Spool opened on the same directory, so a log
+// line may be uploaded more than once but isn't lost. A line whose write was
+// interrupted by a crash is detected and discarded. Writes aren't synced to
+// disk, so lines can still be lost if the whole machine crashes.
+type Spool struct {
+	dir     string
+	maxSize int64 // max total size of all segments
+	segSize int64 // max size of each segment
+
+	mu      sync.Mutex
+	done    []*spoolSeg // fully read but not yet committed, oldest first
+	segs    []*spoolSeg // not fully read, oldest first; the last one is appended to
+	w       *os.File    // open for appending to the last segment
+	r       *os.File    // open for reading segs[0]; nil until needed
+	roff    int64       // offset of next line to read in segs[0]
+	rlines  int         // number of lines of segs[0] already read
+	dropped int         // lines dropped unread since the last TryReadLine
+	dropTot int64       // lines dropped unread ever
+	rbuf    []byte
+	closed  bool
+}
+
+// spoolSeg is a segment file of a Spool.
+type spoolSeg struct {
+	seq   uint64 // sequence number, increasing with each new segment
+	size  int64  // size of the complete frames in the file
+	lines int    // number of complete frames in the file
+}
+
+func (s *Spool) segPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%016x%s", seq, spoolSegSuffix))
+}
+
+// NewSpool returns a Spool keeping log lines in dir, which is created if
+// needed, resuming from any lines left unuploaded by a previous Spool on the
+// same directory. The total size of the files in dir is kept below maxSize
+// bytes, or DefaultSpoolMaxSize if maxSize is zero.
+//
+// Only one Spool may use dir at a time.
+func NewSpool(dir string, maxSize int64) (*Spool, error) {
+	if maxSize == 0 {
+		maxSize = DefaultSpoolMaxSize
+	}
+	if maxSize < spoolSegments*4096 {
+		return nil, fmt.Errorf("logtail: spool max size %d is too small", maxSize)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &Spool{
+		dir:     dir,
+		maxSize: maxSize,
+		segSize: maxSize / spoolSegments,
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the segments and cursor left in s.dir by a previous Spool and
+// opens the last segment for appending, creating it if needed.
+func (s *Spool) load() error {
+	des, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	var seqs []uint64
+	for _, de := range des {
+		name, ok := strings.CutSuffix(de.Name(), spoolSegSuffix)
+		if !ok {
+			continue
+		}
+		seq, err := strconv.ParseUint(name, 16, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	slices.Sort(seqs)
+
+	curSeq, curOff := s.readCursor()
+	for _, seq := range seqs {
+		if seq < curSeq {
+			// Already uploaded, but not yet removed.
+			os.Remove(s.segPath(seq))
+			continue
+		}
+		seg, err := s.scanSeg(seq)
+		if err != nil {
+			return err
+		}
+		s.segs = append(s.segs, seg)
+	}
+
+	if len(s.segs) > 0 && s.segs[0].seq == curSeq {
+		s.roff, s.rlines, err = s.countLines(s.segs[0], curOff)
+		if err != nil {
+			return err
+		}
+	}
+	if len(s.segs) == 0 {
+		s.segs = []*spoolSeg{{seq: curSeq}}
+	}
+	last := s.segs[len(s.segs)-1]
+	s.w, err = os.OpenFile(s.segPath(last.seq), os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	// Cut off any partial frame left by a crash, so new frames are
+	// appended right after the last complete one.
+	if err := s.w.Truncate(last.size); err != nil {
+		return err
+	}
+	if _, err := s.w.Seek(last.size, io.SeekStart); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readCursor returns the position of the first line not yet uploaded, as
+// saved by Commit. It returns zeros if there is none.
+func (s *Spool) readCursor() (seq uint64, off int64) {
+	b, err := os.ReadFile(filepath.Join(s.dir, spoolCursorFile))
+	if err != nil {
+		return 0, 0
+	}
+	seqStr, offStr, ok := strings.Cut(strings.TrimSpace(string(b)), " ")
+	if !ok {
+		return 0, 0
+	}
+	seq, err1 := strconv.ParseUint(seqStr, 16, 64)
+	off, err2 := strconv.ParseInt(offStr, 10, 64)
+	if err1 != nil || err2 != nil || off < 0 {
+		return 0, 0
+	}
+	return seq, off
+}
+
+// scanSeg returns the segment with sequence number seq, counting its
+// complete frames.
+func (s *Spool) scanSeg(seq uint64) (*spoolSeg, error) {
+	seg := &spoolSeg{seq: seq}
+	var err error
+	seg.size, seg.lines, err = s.countLines(seg, -1)
+	return seg, err
+}
+
+// countLines returns the offset just past the last complete frame of seg
+// that ends at or before limit, and the number of frames up to there. A
+// negative limit means the whole file.
+func (s *Spool) countLines(seg *spoolSeg, limit int64) (off int64, lines int, err error) {
+	f, err := os.Open(s.segPath(seg.seq))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	var buf []byte
+	for limit < 0 || off < limit {
+		var n int64
+		n, buf, err = readFrame(f, off, buf)
+		if err != nil || (limit >= 0 && off+n > limit) {
+			// A short or corrupt frame marks the end of the
+			// segment.
+			break
+		}
+		off += n
+		lines++
+	}
+	return off, lines, nil
+}
+
+// readFrame reads the frame at off in f into buf, returning the frame's
+// total length and the line it holds.
+func readFrame(f *os.File, off int64, buf []byte) (n int64, line []byte, err error) {
+	var hdr [spoolFrameHeaderLen]byte
+	if _, err := f.ReadAt(hdr[:], off); err != nil {
+		return 0, buf, err
+	}
+	ln := binary.LittleEndian.Uint32(hdr[0:4])
+	sum := binary.LittleEndian.Uint32(hdr[4:8])
+	if ln > 1<<30 {
+		return 0, buf, errors.New("corrupt frame")
+	}
+	if cap(buf) < int(ln) {
+		buf = make([]byte, ln)
+	}
+	buf = buf[:ln]
+	if _, err := f.ReadAt(buf, off+spoolFrameHeaderLen); err != nil {
+		return 0, buf, err
+	}
+	if crc32.ChecksumIEEE(buf) != sum {
+		return 0, buf, errors.New("corrupt frame")
+	}
+	return spoolFrameHeaderLen + int64(ln), buf, nil
+}
+
+// TryReadLine implements the Buffer interface.
+func (s *Spool) TryReadLine() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, io.EOF
+	}
+	if s.dropped > 0 {
+		msg := fmt.Appendf(nil, "----------- %d logs dropped ----------", s.dropped)
+		s.dropped = 0
+		return msg, nil
+	}
+	for {
+		seg := s.segs[0]
+		if s.roff < seg.size {
+			break
+		}
+		if len(s.segs) == 1 {
+			return nil, nil
+		}
+		// Done reading the oldest segment. Keep it until Commit,
+		// but move on to the next one.
+		if err := s.advanceReadLocked(); err != nil {
+			return nil, err
+		}
+	}
+	if s.r == nil {
+		var err error
+		if s.r, err = os.Open(s.segPath(s.segs[0].seq)); err != nil {
+			return nil, err
+		}
+	}
+	n, line, err := readFrame(s.r, s.roff, s.rbuf)
+	s.rbuf = line
+	if err != nil {
+		return nil, err
+	}
+	s.roff += n
+	s.rlines++
+	return line, nil
+}
+
+// advanceReadLocked moves the read position to the start of segs[1]. The
+// segment being left is kept on disk in s.done until it's committed.
+func (s *Spool) advanceReadLocked() error {
+	if s.r != nil {
+		s.r.Close()
+		s.r = nil
+	}
+	s.done = append(s.done, s.segs[0])
+	s.segs = s.segs[1:]
+	s.roff = 0
+	s.rlines = 0
+	return nil
+}
+
+// Write implements the Buffer interface.
+func (s *Spool) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, errors.New("logtail: spool closed")
+	}
+	frameLen := spoolFrameHeaderLen + int64(len(b))
+	if frameLen > s.segSize {
+		return 0, errSpoolLineTooLong
+	}
+	last := s.segs[len(s.segs)-1]
+	if last.size > 0 && last.size+frameLen > s.segSize {
+		if err := s.rotateLocked(); err != nil {
+			return 0, err
+		}
+		last = s.segs[len(s.segs)-1]
+	}
+	for s.sizeLocked()+frameLen > s.maxSize && s.dropOldestLocked() {
+	}
+
+	frame := make([]byte, spoolFrameHeaderLen, frameLen)
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(len(b)))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(b))
+	frame = append(frame, b...)
+	if _, err := s.w.Write(frame); err != nil {
+		// Undo any partial write so the next frame starts in the
+		// right place.
+		s.w.Truncate(last.size)
+		s.w.Seek(last.size, io.SeekStart)
+		return 0, err
+	}
+	last.size += frameLen
+	last.lines++
+	return len(b), nil
+}
+
+// rotateLocked starts a new segment to append to.
+func (s *Spool) rotateLocked() error {
+	seq := s.segs[len(s.segs)-1].seq + 1
+	w, err := os.OpenFile(s.segPath(seq), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	s.w.Close()
+	s.w = w
+	s.segs = append(s.segs, &spoolSeg{seq: seq})
+	return nil
+}
+
+// sizeLocked returns the total size of the segment files.
+func (s *Spool) sizeLocked() int64 {
+	var n int64
+	for _, seg := range s.done {
+		n += seg.size
+	}
+	for _, seg := range s.segs {
+		n += seg.size
+	}
+	return n
+}
+
+// Stats implements the StatsBuffer interface. Its sizes are in bytes.
+func (s *Spool) Stats() BufferStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return BufferStats{
+		Used:     s.sizeLocked(),
+		Capacity: s.maxSize,
+		Dropped:  s.dropTot,
+	}
+}
+
+// dropOldestLocked removes the oldest segment other than the one being
+// appended to, counting any of its lines that weren't read yet as dropped.
+// It reports whether there was such a segment.
+func (s *Spool) dropOldestLocked() bool {
+	if len(s.done) > 0 {
+		seg := s.done[0]
+		s.done = s.done[1:]
+		os.Remove(s.segPath(seg.seq))
+		return true
+	}
+	if len(s.segs) < 2 {
+		return false
+	}
+	seg := s.segs[0]
+	s.dropped += seg.lines - s.rlines
+	s.dropTot += int64(seg.lines - s.rlines)
+	if s.r != nil {
+		s.r.Close()
+		s.r = nil
+	}
+	s.segs = s.segs[1:]
+	s.roff = 0
+	s.rlines = 0
+	os.Remove(s.segPath(seg.seq))
+	return true
+}
+
+// Commit records that all lines read so far have been uploaded, so that
+// they're not read again by a later Spool.
+func (s *Spool) Commit() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	cursor := fmt.Sprintf("%016x %d\n", s.segs[0].seq, s.roff)
+	if err := atomicfile.WriteFile(filepath.Join(s.dir, spoolCursorFile), []byte(cursor), 0600); err != nil {
+		return err
+	}
+	for _, seg := range s.done {
+		os.Remove(s.segPath(seg.seq))
+	}
+	s.done = nil
+	return nil
+}
+
+// Close closes the files of s. Lines read but not yet committed are read
+// again by the next Spool on the same directory.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.r != nil {
+		s.r.Close()
+	}
+	return s.w.Close()
+}
@@ -0,0 +1,134 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readAll(t *testing.T, s *Spool) []string {
+	t.Helper()
+	var lines []string
+	for {
+		b, err := s.TryReadLine()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b == nil {
+			return lines
+		}
+		lines = append(lines, string(b))
+	}
+}
+
+func mustNewSpool(t *testing.T, dir string, maxSize int64) *Spool {
+	t.Helper()
+	s, err := NewSpool(dir, maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+	s := mustNewSpool(t, dir, 0)
+	for i := range 3 {
+		if _, err := fmt.Fprintf(s, "line %d", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := readAll(t, s); len(got) != 3 || got[0] != "line 0" || got[2] != "line 2" {
+		t.Fatalf("got %q", got)
+	}
+
+	// Uncommitted lines are read again after a restart.
+	s.Close()
+	s = mustNewSpool(t, dir, 0)
+	if got := readAll(t, s); len(got) != 3 {
+		t.Fatalf("after restart got %q; want 3 lines", got)
+	}
+	if err := s.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(s, "line 3")
+	s.Close()
+
+	// Committed ones aren't.
+	s = mustNewSpool(t, dir, 0)
+	if got := readAll(t, s); len(got) != 1 || got[0] != "line 3" {
+		t.Fatalf("after commit and restart got %q; want [line 3]", got)
+	}
+}
+
+func TestSpoolTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	s := mustNewSpool(t, dir, 0)
+	fmt.Fprintf(s, "complete")
+	s.Close()
+
+	// Simulate a crash in the middle of writing a frame.
+	f, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("%016x%s", 0, spoolSegSuffix)), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{100, 0, 0, 0, 1, 2, 3, 4, 'x'})
+	f.Close()
+
+	s = mustNewSpool(t, dir, 0)
+	fmt.Fprintf(s, "after")
+	if got := readAll(t, s); len(got) != 2 || got[0] != "complete" || got[1] != "after" {
+		t.Fatalf("got %q; want [complete after]", got)
+	}
+}
+
+func TestSpoolRotateAndDrop(t *testing.T) {
+	dir := t.TempDir()
+	const maxSize = spoolSegments * 4096
+	s := mustNewSpool(t, dir, maxSize)
+	line := strings.Repeat("x", 1000)
+
+	// Write about three times the max size without reading.
+	const n = 3 * maxSize / 1000
+	for range n {
+		if _, err := s.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var size int64
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, de := range des {
+		fi, err := de.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += fi.Size()
+	}
+	if size > maxSize {
+		t.Errorf("spool uses %d bytes; want at most %d", size, maxSize)
+	}
+
+	got := readAll(t, s)
+	if len(got) == 0 || !strings.HasSuffix(got[0], "logs dropped ----------") {
+		t.Fatalf("first line = %.40q; want dropped notice", got)
+	}
+	var dropped int
+	fmt.Sscanf(got[0], "----------- %d logs dropped", &dropped)
+	if kept := len(got) - 1; dropped+kept != n {
+		t.Errorf("dropped %d + kept %d = %d; want %d", dropped, kept, dropped+kept, n)
+	}
+
+	if _, err := s.Write(make([]byte, maxSize)); err != errSpoolLineTooLong {
+		t.Errorf("writing huge line: err = %v; want %v", err, errSpoolLineTooLong)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"tailscale.com/logtail/filch"
+	"tailscale.com/util/clientmetric"
+)
+
+var metricDroppedLines = clientmetric.NewCounter("logtail_dropped_lines")
+
+// BufferStats describes how full a Logger's buffer is.
+type BufferStats struct {
+	// Used and Capacity are how much of the buffer is used and its
+	// total size. Their unit (lines or bytes) depends on the Buffer, so
+	// only their ratio is meaningful across buffer types. Both are
+	// zero if the Buffer doesn't implement StatsBuffer and isn't a
+	// filch.Filch.
+	Used, Capacity int64
+
+	// Dropped is the number of log lines dropped because the buffer
+	// was full or failed to store them.
+	Dropped int64
+}
+
+// Fill returns the fraction of the buffer that's in use, from 0 to 1,
+// or 0 if its capacity is unknown.
+func (s BufferStats) Fill() float64 {
+	if s.Capacity <= 0 {
+		return 0
+	}
+	return min(float64(s.Used)/float64(s.Capacity), 1)
+}
+
+// A StatsBuffer is a Buffer that can report how full it is.
+type StatsBuffer interface {
+	Buffer
+
+	// Stats returns the buffer's current statistics.
+	Stats() BufferStats
+}
+
+// dropState tracks whether a Logger is dropping logs, to notify
+// Config.OnDropping of changes.
+type dropState struct {
+	notify func(dropping bool) // or nil
+
+	writeErrs atomic.Int64 // failed buffer writes not counted by the buffer's Stats
+	dropping  atomic.Bool
+
+	lastDropped int64 // Dropped as of the last send; guarded by Logger.writeLock
+
+	notifyMu sync.Mutex // serializes calls to notify
+	notified bool       // last value passed to notify; guarded by notifyMu
+}
+
+// BufferStats returns statistics about l's buffer of logs waiting to be
+// uploaded. If the buffer doesn't implement StatsBuffer and isn't a
+// filch.Filch, only Dropped is set, to the number of lines it refused.
+func (l *Logger) BufferStats() BufferStats {
+	var s BufferStats
+	switch b := l.buffer.(type) {
+	case StatsBuffer:
+		s = b.Stats()
+	case *filch.Filch:
+		// Package filch doesn't depend on logtail, so it can't
+		// implement StatsBuffer itself.
+		fs := b.Stats()
+		s = BufferStats{Used: fs.Used, Capacity: fs.Capacity, Dropped: fs.Dropped}
+	}
+	s.Dropped += l.drops.writeErrs.Load()
+	return s
+}
+
+// Dropping reports whether l is dropping logs: whether any were dropped
+// and it hasn't since completed an upload without dropping more.
+func (l *Logger) Dropping() bool {
+	return l.drops.dropping.Load()
+}
+
+// noteSendLocked records the result of writing a line to l.buffer, and
+// marks l as dropping logs if the buffer has dropped any since the last
+// call. l.writeLock must be held.
+func (l *Logger) noteSendLocked(err error) {
+	if err != nil {
+		// Our own buffers count the lines they refuse because
+		// they're full, but not other errors.
+		if _, ok := l.buffer.(StatsBuffer); !ok || err != errBufferFull {
+			l.drops.writeErrs.Add(1)
+		}
+	}
+	if err == nil && !l.mayDropSilently() {
+		return
+	}
+	dropped := l.BufferStats().Dropped
+	if n := dropped - l.drops.lastDropped; n > 0 {
+		l.drops.lastDropped = dropped
+		metricDroppedLines.Add(n)
+		l.setDropping(true)
+	}
+}
+
+// mayDropSilently reports whether l.buffer can drop lines while accepting
+// writes, as a Spool or a filch.Filch does to make room for new ones.
+func (l *Logger) mayDropSilently() bool {
+	switch l.buffer.(type) {
+	case *Spool, *filch.Filch:
+		return true
+	}
+	return false
+}
+
+// noteUploadSucceeded is called by the uploading goroutine after each
+// successful upload with the value of BufferStats().Dropped as of the
+// previous one. It clears the dropping state if nothing has been dropped
+// since, and returns the new value to pass next time.
+func (l *Logger) noteUploadSucceeded(prevDropped int64) int64 {
+	dropped := l.BufferStats().Dropped
+	if dropped == prevDropped {
+		l.setDropping(false)
+	}
+	return dropped
+}
+
+// setDropping sets whether l is dropping logs, calling Config.OnDropping
+// in a new goroutine if that changed. The callback isn't run synchronously
+// as it might log.
+func (l *Logger) setDropping(dropping bool) {
+	d := &l.drops
+	if d.dropping.Swap(dropping) == dropping || d.notify == nil {
+		return
+	}
+	go func() {
+		d.notifyMu.Lock()
+		defer d.notifyMu.Unlock()
+		// Report the latest state rather than dropping, in case
+		// goroutines ran out of order.
+		v := d.dropping.Load()
+		if v == d.notified {
+			return
+		}
+		d.notified = v
+		d.notify(v)
+	}()
+}
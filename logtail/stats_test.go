@@ -0,0 +1,128 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/logtail/filch"
+	"tailscale.com/tstest"
+)
+
+func TestDropping(t *testing.T) {
+	notified := make(chan bool, 10)
+	l := &Logger{
+		clock:  tstest.NewClock(tstest.ClockOpts{Start: time.Unix(123, 0).UTC()}),
+		buffer: NewMemoryBuffer(2),
+		drops:  dropState{notify: func(dropping bool) { notified <- dropping }},
+	}
+	wantNotify := func(want bool) {
+		t.Helper()
+		select {
+		case got := <-notified:
+			if got != want {
+				t.Fatalf("notified %v; want %v", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for notification of %v", want)
+		}
+	}
+
+	l.Logf("one")
+	l.Logf("two")
+	if l.Dropping() {
+		t.Fatal("dropping before buffer full")
+	}
+	before := metricDroppedLines.Value()
+	l.Logf("three")
+	l.Logf("four")
+	wantNotify(true)
+	if !l.Dropping() {
+		t.Fatal("not dropping after buffer full")
+	}
+	if got, want := l.BufferStats(), (BufferStats{Used: 2, Capacity: 2, Dropped: 2}); got != want {
+		t.Errorf("BufferStats = %+v; want %+v", got, want)
+	}
+	if got := metricDroppedLines.Value() - before; got != 2 {
+		t.Errorf("dropped lines metric increased by %d; want 2", got)
+	}
+	if got := l.BufferStats().Fill(); got != 1 {
+		t.Errorf("Fill = %v; want 1", got)
+	}
+
+	// An upload after more drops doesn't clear the state, but the next
+	// one without any does.
+	dropped := l.noteUploadSucceeded(0)
+	if !l.Dropping() {
+		t.Fatal("dropping cleared by upload following drops")
+	}
+	l.noteUploadSucceeded(dropped)
+	wantNotify(false)
+	if l.Dropping() {
+		t.Error("still dropping after clean upload")
+	}
+}
+
+func TestSpoolStats(t *testing.T) {
+	const maxSize = spoolSegments * 4096
+	s, err := NewSpool(t.TempDir(), maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	l := &Logger{
+		clock:  tstest.NewClock(tstest.ClockOpts{Start: time.Unix(123, 0).UTC()}),
+		buffer: s,
+	}
+	line := strings.Repeat("x", 500)
+	for range 10 {
+		l.Logf("%s", line)
+	}
+	st := l.BufferStats()
+	if st.Used == 0 || st.Capacity != maxSize || st.Dropped != 0 || l.Dropping() {
+		t.Fatalf("BufferStats = %+v, dropping %v; want some used and none dropped", st, l.Dropping())
+	}
+
+	// The spool accepts every write, dropping its oldest lines instead.
+	for range 200 {
+		l.Logf("%s", line)
+	}
+	st = l.BufferStats()
+	if st.Dropped == 0 || st.Used > maxSize || !l.Dropping() {
+		t.Errorf("BufferStats = %+v, dropping %v; want drops", st, l.Dropping())
+	}
+}
+
+func TestFilchStats(t *testing.T) {
+	f, err := filch.New(filepath.Join(t.TempDir(), "logs"), filch.Options{MaxFileSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	l := &Logger{
+		clock:  tstest.NewClock(tstest.ClockOpts{Start: time.Unix(123, 0).UTC()}),
+		buffer: f,
+	}
+	line := strings.Repeat("x", 500)
+	for range 5 {
+		l.Logf("%s", line)
+	}
+	st := l.BufferStats()
+	if st.Used == 0 || st.Capacity != 2*4096 || st.Dropped != 0 || l.Dropping() {
+		t.Fatalf("BufferStats = %+v, dropping %v; want some used and none dropped", st, l.Dropping())
+	}
+
+	// Like the spool, filch accepts every write, throwing away old lines
+	// when it's full.
+	for range 500 {
+		l.Logf("%s", line)
+	}
+	st = l.BufferStats()
+	if st.Dropped == 0 || !l.Dropping() {
+		t.Errorf("BufferStats = %+v, dropping %v; want drops", st, l.Dropping())
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// zBufferFrameSize is the uncompressed size at which a zBuffer compresses
+// the frame of log lines it's been writing.
+const zBufferFrameSize = 32 << 10
+
+// NewCompressedMemoryBuffer returns a Buffer that keeps log lines in memory,
+// compressed with DEFLATE in frames of many lines, using about maxBytes of
+// memory at most. As log lines compress well, it typically holds several
+// times as many lines as a memory buffer of the same size.
+//
+// maxBytes is raised to at least twice the frame size.
+func NewCompressedMemoryBuffer(maxBytes int) Buffer {
+	return &zBuffer{maxBytes: max(maxBytes, 2*zBufferFrameSize)}
+}
+
+// zBuffer is a Buffer keeping log lines in compressed frames. Each line is
+// stored prefixed by its uvarint length.
+type zBuffer struct {
+	maxBytes int
+
+	mu        sync.Mutex
+	frames    [][]byte // compressed frames, oldest first
+	size      int      // total size of frames
+	open      []byte   // uncompressed lines not yet compressed into a frame
+	dropCount int      // lines dropped since the last written one
+	dropTotal int64    // lines dropped ever
+	fw        *flate.Writer
+	fr        io.ReadCloser // reused flate reader
+	rbuf      []byte        // uncompressed frame being read
+	cur       []byte        // unread remainder of rbuf
+}
+
+// TryReadLine implements the Buffer interface.
+func (z *zBuffer) TryReadLine() ([]byte, error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if len(z.cur) == 0 {
+		switch {
+		case len(z.frames) > 0:
+			if err := z.inflateLocked(z.frames[0]); err != nil {
+				return nil, err
+			}
+			z.size -= len(z.frames[0])
+			z.frames[0] = nil
+			z.frames = z.frames[1:]
+		case len(z.open) > 0:
+			// Take the lines written since the last frame
+			// directly, rather than waiting for the frame to
+			// fill up.
+			z.rbuf, z.open = z.open, z.rbuf[:0]
+			z.cur = z.rbuf
+		default:
+			return nil, nil
+		}
+	}
+	n, w := binary.Uvarint(z.cur)
+	if w <= 0 || uint64(len(z.cur)-w) < n {
+		z.cur = nil
+		return nil, fmt.Errorf("logtail: corrupt compressed buffer frame")
+	}
+	line := z.cur[w : w+int(n)]
+	z.cur = z.cur[w+int(n):]
+	return line, nil
+}
+
+// inflateLocked decompresses frame into z.rbuf and sets z.cur to it.
+func (z *zBuffer) inflateLocked(frame []byte) error {
+	if z.fr == nil {
+		z.fr = flate.NewReader(bytes.NewReader(frame))
+	} else if err := z.fr.(flate.Resetter).Reset(bytes.NewReader(frame), nil); err != nil {
+		return err
+	}
+	buf := bytes.NewBuffer(z.rbuf[:0])
+	if _, err := buf.ReadFrom(z.fr); err != nil {
+		return err
+	}
+	z.rbuf = buf.Bytes()
+	z.cur = z.rbuf
+	return nil
+}
+
+// Write implements the Buffer interface.
+func (z *zBuffer) Write(b []byte) (int, error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if z.size+len(z.open)+binary.MaxVarintLen64+len(b) > z.maxBytes {
+		z.dropCount++
+		z.dropTotal++
+		return 0, errBufferFull
+	}
+	if z.dropCount > 0 {
+		z.appendLocked(fmt.Appendf(nil, "----------- %d logs dropped ----------", z.dropCount))
+		z.dropCount = 0
+	}
+	z.appendLocked(b)
+	if len(z.open) >= zBufferFrameSize {
+		z.sealLocked()
+	}
+	return len(b), nil
+}
+
+// Stats implements the StatsBuffer interface. Its sizes are in bytes.
+func (z *zBuffer) Stats() BufferStats {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return BufferStats{
+		Used:     int64(z.size + len(z.open)),
+		Capacity: int64(z.maxBytes),
+		Dropped:  z.dropTotal,
+	}
+}
+
+func (z *zBuffer) appendLocked(b []byte) {
+	z.open = binary.AppendUvarint(z.open, uint64(len(b)))
+	z.open = append(z.open, b...)
+}
+
+// sealLocked compresses the open lines into a new frame.
+func (z *zBuffer) sealLocked() {
+	var buf bytes.Buffer
+	if z.fw == nil {
+		z.fw, _ = flate.NewWriter(&buf, flate.BestSpeed)
+	} else {
+		z.fw.Reset(&buf)
+	}
+	z.fw.Write(z.open)
+	z.fw.Close()
+	frame := bytes.Clone(buf.Bytes())
+	z.frames = append(z.frames, frame)
+	z.size += len(frame)
+	z.open = z.open[:0]
+}
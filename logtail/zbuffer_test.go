@@ -0,0 +1,82 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestCompressedMemoryBuffer(t *testing.T) {
+	const maxBytes = 4 * zBufferFrameSize
+	z := NewCompressedMemoryBuffer(maxBytes).(*zBuffer)
+
+	// Write far more compressible lines than maxBytes uncompressed.
+	const n = 2000
+	for i := range n {
+		line := fmt.Sprintf(`{"text": "line %d %s"}`, i, strings.Repeat("x", 200))
+		if _, err := z.Write([]byte(line)); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+	}
+	if len(z.frames) == 0 {
+		t.Fatal("no compressed frames")
+	}
+	if z.size+len(z.open) > maxBytes {
+		t.Errorf("buffer uses %d bytes; want at most %d", z.size+len(z.open), maxBytes)
+	}
+
+	for i := range n {
+		b, err := z.TryReadLine()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf(`{"text": "line %d `, i); !strings.HasPrefix(string(b), want) {
+			t.Fatalf("line %d = %.30q; want prefix %q", i, b, want)
+		}
+	}
+	if b, err := z.TryReadLine(); b != nil || err != nil {
+		t.Errorf("TryReadLine on empty buffer = %q, %v; want nil, nil", b, err)
+	}
+}
+
+func TestCompressedMemoryBufferFull(t *testing.T) {
+	z := NewCompressedMemoryBuffer(0).(*zBuffer)
+	// Random lines don't compress much, so fill it up quickly.
+	rnd := rand.New(rand.NewSource(1))
+	var dropped int
+	for range 1000 {
+		line := make([]byte, 200)
+		rnd.Read(line)
+		line = fmt.Appendf(nil, "%x", line)
+		if _, err := z.Write(line); err == errBufferFull {
+			dropped++
+		}
+	}
+	if dropped == 0 {
+		t.Fatal("no lines dropped")
+	}
+
+	// Once there's room again, the next line is preceded by a note of
+	// how many were dropped.
+	for {
+		b, err := z.TryReadLine()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b == nil {
+			break
+		}
+	}
+	z.Write([]byte("after"))
+	b, _ := z.TryReadLine()
+	if want := fmt.Sprintf("----------- %d logs dropped ----------", dropped); string(b) != want {
+		t.Errorf("got %q; want %q", b, want)
+	}
+	if b, _ := z.TryReadLine(); string(b) != "after" {
+		t.Errorf("got %q; want %q", b, "after")
+	}
+}
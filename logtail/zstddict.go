@@ -0,0 +1,330 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/atomicfile"
+)
+
+// ZstdDictConfig configures the zstd dictionary that a Logger compresses
+// uploads with, if the log server supports it. The dictionary is learned
+// from the logs uploaded by a process and persisted for the following ones,
+// so that even their first uploads compress well.
+type ZstdDictConfig struct {
+	// Path is the file that the dictionary is persisted in.
+	Path string
+
+	// Build returns a dictionary with the given ID for compressing log
+	// batches like samples.
+	Build func(id uint32, samples [][]byte) ([]byte, error)
+
+	// NewEncoder returns an Encoder that compresses with dict.
+	NewEncoder func(dict []byte) (Encoder, error)
+}
+
+const (
+	// zstdDictVersion is the version of the dictionaries built by Loggers,
+	// which the log server must support for them to be used. It's bumped
+	// whenever the way they're built changes incompatibly.
+	zstdDictVersion = 1
+
+	// zstdDictSampleSize is how many bytes of uploaded log batches a
+	// dictionary is built from.
+	zstdDictSampleSize = 64 << 10
+
+	// zstdDictMaxAge is how old a dictionary can get before it's replaced
+	// by one learned from more recent logs.
+	zstdDictMaxAge = 30 * 24 * time.Hour
+
+	// zstdDictMinRetry and zstdDictMaxRetry bound how long a Logger waits
+	// before sending its dictionary again after it failed to get the log
+	// server to use it more than once in a row. The first failure is
+	// retried right away.
+	zstdDictMinRetry = time.Minute
+	zstdDictMaxRetry = time.Hour
+
+	// zstdDictMaxFailures is how many times in a row the log server can
+	// refuse the dictionary, or ask for it again after getting it, before
+	// the Logger stops using dictionaries. Failures to reach the log server
+	// don't count.
+	zstdDictMaxFailures = 3
+)
+
+// Headers of the zstd dictionary negotiation with the log server.
+const (
+	// zstdDictVersionsHeader is the response header in which the log server
+	// lists the dictionary versions it supports, comma-separated.
+	zstdDictVersionsHeader = "Zstd-Dict-Versions"
+	// zstdDictHeader is the request header with the "<version>/<id>" of
+	// the dictionary that the body is, or is compressed with.
+	zstdDictHeader = "Zstd-Dict"
+	// zstdDictContentType is the content type of dictionary uploads.
+	zstdDictContentType = "application/x-zstd-dictionary"
+)
+
+var (
+	// errZstdDictUnknown is returned by Logger.upload when the log server
+	// doesn't have the dictionary that the upload was compressed with.
+	errZstdDictUnknown = errors.New("log server doesn't have zstd dictionary")
+
+	// errZstdDictRefused is wrapped by the errors of Logger.sendZstdDict
+	// when the log server refused the dictionary, as opposed to failing to
+	// handle the request.
+	errZstdDictRefused = errors.New("log server refused zstd dictionary")
+)
+
+// zstdDictFile is the JSON format of ZstdDictConfig.Path.
+type zstdDictFile struct {
+	Version int
+	ID      uint32
+	Created time.Time
+	Dict    []byte
+
+	// SentTo is the upload URL of the log server that the dictionary was
+	// last sent to, or empty if it hasn't been sent.
+	SentTo string `json:",omitempty"`
+}
+
+// header returns the zstdDictHeader value for f.
+func (f *zstdDictFile) header() string {
+	return fmt.Sprintf("%d/%d", f.Version, f.ID)
+}
+
+// zstdDict is the zstd dictionary state of a Logger. It's only used by the
+// uploading goroutine.
+type zstdDict struct {
+	conf *ZstdDictConfig // or nil if disabled
+
+	file     *zstdDictFile // or nil if there's none yet
+	enc      Encoder       // non-nil once the log server has file's dictionary
+	serverOK bool          // the log server supports zstdDictVersion
+
+	samples    [][]byte // of uploaded batches, while learning a new dictionary
+	sampleSize int
+
+	failures  int           // consecutive permanent failures to get the dictionary used
+	retryAt   time.Time     // when to send the dictionary again after a failure
+	retryWait time.Duration // how long to wait after the next failure
+}
+
+// initZstdDict loads the dictionary persisted at conf.Path, if any.
+func (l *Logger) initZstdDict(conf *ZstdDictConfig) {
+	if conf == nil || l.zstdEncoder == nil {
+		return
+	}
+	d := &l.zdict
+	d.conf = conf
+	b, err := os.ReadFile(conf.Path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Fprintf(l.stderr, "logtail: reading zstd dictionary: %v\n", err)
+		}
+		return
+	}
+	f := new(zstdDictFile)
+	if err := json.Unmarshal(b, f); err != nil || f.Version != zstdDictVersion {
+		// Replaced once a new one has been learned.
+		return
+	}
+	d.file = f
+	if f.SentTo == l.url {
+		// The log server got the dictionary before the process
+		// restarted, so use it right away.
+		d.serverOK = true
+		l.useZstdDict()
+	}
+}
+
+// useZstdDict starts compressing uploads with the current dictionary.
+func (l *Logger) useZstdDict() {
+	d := &l.zdict
+	if d.enc != nil {
+		d.enc.Close()
+		d.enc = nil
+	}
+	enc, err := d.conf.NewEncoder(d.file.Dict)
+	if err != nil {
+		fmt.Fprintf(l.stderr, "logtail: zstd dictionary: %v\n", err)
+		return
+	}
+	d.enc = enc
+}
+
+// disableZstdDict stops using zstd dictionaries for the life of the process.
+func (l *Logger) disableZstdDict() {
+	d := &l.zdict
+	if d.enc != nil {
+		d.enc.Close()
+	}
+	*d = zstdDict{}
+}
+
+// noteZstdDictVersions records whether the log server supports the
+// dictionaries built by l, from the header of one of its responses.
+func (d *zstdDict) noteZstdDictVersions(h http.Header) {
+	if d.conf == nil || d.serverOK {
+		return
+	}
+	v, ok := h[zstdDictVersionsHeader]
+	if !ok {
+		return
+	}
+	for _, s := range strings.Split(strings.Join(v, ","), ",") {
+		if s = strings.TrimSpace(s); s == strconv.Itoa(zstdDictVersion) {
+			d.serverOK = true
+			return
+		}
+	}
+}
+
+// needsNew reports whether a new dictionary should be learned at now.
+func (d *zstdDict) needsNew(now time.Time) bool {
+	return d.file == nil || now.Sub(d.file.Created) > zstdDictMaxAge
+}
+
+// updateZstdDict is called after body was uploaded to learn a new
+// dictionary if needed, and to send the current one to the log server if it
+// doesn't have it yet.
+func (l *Logger) updateZstdDict(ctx context.Context, body []byte) {
+	d := &l.zdict
+	if d.conf == nil || !d.serverOK {
+		return
+	}
+	if now := l.clock.Now(); d.needsNew(now) {
+		d.samples = append(d.samples, bytes.Clone(body))
+		d.sampleSize += len(body)
+		if d.sampleSize < zstdDictSampleSize {
+			return
+		}
+		// User dictionary IDs should be in [32768, 2^31).
+		id := uint32(32768 + mrand.Int31n(1<<31-32768))
+		dict, err := d.conf.Build(id, d.samples)
+		d.samples, d.sampleSize = nil, 0
+		if err != nil {
+			fmt.Fprintf(l.stderr, "logtail: building zstd dictionary: %v\n", err)
+			l.disableZstdDict()
+			return
+		}
+		d.file = &zstdDictFile{
+			Version: zstdDictVersion,
+			ID:      id,
+			Created: now,
+			Dict:    dict,
+		}
+		if d.enc != nil {
+			d.enc.Close()
+			d.enc = nil
+		}
+		l.saveZstdDict()
+	}
+	if d.file.SentTo == l.url && d.enc != nil {
+		return
+	}
+	l.resendZstdDict(ctx)
+}
+
+// resendZstdDict sends the current dictionary to the log server, unless
+// it's backing off after failing to.
+func (l *Logger) resendZstdDict(ctx context.Context) {
+	d := &l.zdict
+	if d.conf == nil || d.file == nil || l.clock.Now().Before(d.retryAt) {
+		return
+	}
+	if err := l.sendZstdDict(ctx); err != nil {
+		l.zstdDictFailed(err, errors.Is(err, errZstdDictRefused))
+	}
+}
+
+// zstdDictFailed stops compressing uploads with the current dictionary, as
+// the log server doesn't have it because of err, until it's sent again after
+// a backoff. If permanent, err counts as the log server not wanting the
+// dictionary, and after zstdDictMaxFailures such failures in a row,
+// dictionaries are disabled for the life of the process.
+func (l *Logger) zstdDictFailed(err error, permanent bool) {
+	d := &l.zdict
+	if d.enc != nil {
+		d.enc.Close()
+		d.enc = nil
+	}
+	if permanent {
+		d.failures++
+		if d.failures >= zstdDictMaxFailures {
+			fmt.Fprintf(l.stderr, "logtail: %v; not using zstd dictionary\n", err)
+			l.disableZstdDict()
+			return
+		}
+	}
+	d.retryAt = l.clock.Now().Add(d.retryWait)
+	fmt.Fprintf(l.stderr, "logtail: %v; sending zstd dictionary again in %v\n", err, d.retryWait)
+	d.retryWait = min(max(2*d.retryWait, zstdDictMinRetry), zstdDictMaxRetry)
+}
+
+// noteZstdDictUsed records that the log server accepted an upload
+// compressed with the dictionary, which means that it has it.
+func (d *zstdDict) noteZstdDictUsed() {
+	d.failures = 0
+	d.retryWait = 0
+}
+
+// sendZstdDict sends the current dictionary to the log server and starts
+// compressing uploads with it.
+func (l *Logger) sendZstdDict(ctx context.Context) error {
+	d := &l.zdict
+	ctx, cancel := context.WithTimeout(ctx, 45*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", l.url, bytes.NewReader(d.file.Dict))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", zstdDictContentType)
+	req.Header.Set(zstdDictHeader, d.file.header())
+	resp, err := l.httpc.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending zstd dictionary: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		err := fmt.Errorf("sending zstd dictionary failed %d: %s", resp.StatusCode, bytes.TrimSpace(b))
+		switch resp.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		default:
+			if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+				err = fmt.Errorf("%w: %w", errZstdDictRefused, err)
+			}
+		}
+		return err
+	}
+	if d.file.SentTo != l.url {
+		d.file.SentTo = l.url
+		l.saveZstdDict()
+	}
+	l.useZstdDict()
+	return nil
+}
+
+// saveZstdDict persists the current dictionary.
+func (l *Logger) saveZstdDict() {
+	d := &l.zdict
+	b, err := json.Marshal(d.file)
+	if err == nil {
+		err = atomicfile.WriteFile(d.conf.Path, b, 0600)
+	}
+	if err != nil {
+		fmt.Fprintf(l.stderr, "logtail: saving zstd dictionary: %v\n", err)
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/tstest"
+)
+
+// fakeDictEncoder is an Encoder that pretends to compress anything with a
+// dictionary to a single byte.
+type fakeDictEncoder struct{}
+
+func (fakeDictEncoder) EncodeAll(src, dst []byte) []byte { return append(dst, 'd') }
+func (fakeDictEncoder) Close() error                     { return nil }
+
+func TestZstdDict(t *testing.T) {
+	type upload struct {
+		dict string // Zstd-Dict header
+		body string
+	}
+	var (
+		mu    sync.Mutex
+		dicts = map[string]string{} // by Zstd-Dict header
+	)
+	uploads := make(chan upload, 100)
+	dictSent := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Zstd-Dict-Versions", "2, 1")
+		b, _ := io.ReadAll(r.Body)
+		dict := r.Header.Get("Zstd-Dict")
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Content-Type") == "application/x-zstd-dictionary" {
+			dicts[dict] = string(b)
+			dictSent <- dict
+			return
+		}
+		if _, ok := dicts[dict]; dict != "" && !ok {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		uploads <- upload{dict, string(b)}
+	}))
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "log.zdict")
+	var built int
+	conf := Config{
+		BaseURL:        ts.URL,
+		FlushDelayFn:   func() time.Duration { return 0 },
+		NewZstdEncoder: func() Encoder { return fakeZstdEncoder{} },
+		ZstdDict: &ZstdDictConfig{
+			Path: path,
+			Build: func(id uint32, samples [][]byte) ([]byte, error) {
+				built++
+				return []byte(fmt.Sprintf("dict%d", id)), nil
+			},
+			NewEncoder: func(dict []byte) (Encoder, error) { return fakeDictEncoder{}, nil },
+		},
+	}
+	waitDictUpload := func(l *Logger) upload {
+		t.Helper()
+		l.Logf("%s", strings.Repeat("compress me with a dictionary ", 100))
+		for {
+			select {
+			case u := <-uploads:
+				if u.dict != "" {
+					if u.body != "d" {
+						t.Errorf("upload with dictionary %q has body %q", u.dict, u.body)
+					}
+					return u
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("timeout waiting for upload with dictionary")
+			}
+		}
+	}
+
+	// Learn a dictionary from the first uploads and send it to the server.
+	l := NewLogger(conf, t.Logf)
+	var sent string
+	for sent == "" {
+		l.Logf("%s", strings.Repeat("x", 8<<10))
+		select {
+		case sent = <-dictSent:
+		case <-uploads:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timeout waiting for dictionary")
+		}
+	}
+	if u := waitDictUpload(l); u.dict != sent {
+		t.Errorf("upload compressed with dictionary %q; want %q", u.dict, sent)
+	}
+	if err := l.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var f zstdDictFile
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &f); err != nil {
+		t.Fatal(err)
+	}
+	if f.header() != sent || f.SentTo == "" || built != 1 {
+		t.Fatalf("persisted dictionary %q sent to %q after %d builds; want %q sent once built", f.header(), f.SentTo, built, sent)
+	}
+
+	// After a restart, the persisted dictionary is used from the start.
+	l = NewLogger(conf, t.Logf)
+	if l.zdict.enc == nil {
+		t.Error("persisted dictionary not used after restart")
+	}
+	if u := waitDictUpload(l); u.dict != sent {
+		t.Errorf("upload compressed with dictionary %q; want %q", u.dict, sent)
+	}
+
+	// If the server loses the dictionary, it's sent again.
+	mu.Lock()
+	clear(dicts)
+	mu.Unlock()
+	waitDictUpload(l)
+	select {
+	case d := <-dictSent:
+		if d != sent {
+			t.Errorf("resent dictionary %q; want %q", d, sent)
+		}
+	default:
+		t.Error("dictionary not resent")
+	}
+	if err := l.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if built != 1 {
+		t.Errorf("dictionary built %d times; want once", built)
+	}
+}
+
+func TestZstdDictRetry(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		status int // of dictionary uploads
+		posts  int
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		posts++
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+	setStatus := func(code int) {
+		mu.Lock()
+		defer mu.Unlock()
+		status = code
+		posts = 0
+	}
+	numPosts := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return posts
+	}
+
+	clock := tstest.NewClock(tstest.ClockOpts{Start: time.Now()})
+	newLogger := func() *Logger {
+		return &Logger{
+			stderr: io.Discard,
+			httpc:  ts.Client(),
+			url:    ts.URL,
+			clock:  clock,
+			zdict: zstdDict{
+				conf: &ZstdDictConfig{
+					Path:       filepath.Join(t.TempDir(), "log.zdict"),
+					NewEncoder: func(dict []byte) (Encoder, error) { return fakeDictEncoder{}, nil },
+				},
+				file: &zstdDictFile{
+					Version: zstdDictVersion,
+					ID:      32768,
+					Created: clock.Now(),
+					Dict:    []byte("dict"),
+				},
+				serverOK: true,
+			},
+		}
+	}
+	ctx := context.Background()
+
+	// Failures to reach the log server are retried with backoff, forever.
+	setStatus(http.StatusServiceUnavailable)
+	l := newLogger()
+	l.updateZstdDict(ctx, nil)
+	l.updateZstdDict(ctx, nil) // the first failure is retried right away
+	l.updateZstdDict(ctx, nil)
+	if got := numPosts(); got != 2 {
+		t.Errorf("sent dictionary %d times before backing off; want 2", got)
+	}
+	for wait := zstdDictMinRetry; wait <= 4*zstdDictMaxRetry; wait *= 2 {
+		clock.Advance(min(wait, zstdDictMaxRetry))
+		l.updateZstdDict(ctx, nil)
+	}
+	if l.zdict.conf == nil {
+		t.Fatal("dictionary disabled after failures to reach the log server")
+	}
+	setStatus(http.StatusOK)
+	clock.Advance(zstdDictMaxRetry)
+	l.updateZstdDict(ctx, nil)
+	if l.zdict.enc == nil || numPosts() != 1 {
+		t.Fatalf("dictionary not used after the log server got it")
+	}
+
+	// A log server that refuses the dictionary gets it a few times.
+	setStatus(http.StatusBadRequest)
+	l = newLogger()
+	for range zstdDictMaxFailures + 2 {
+		l.updateZstdDict(ctx, nil)
+		clock.Advance(zstdDictMaxRetry)
+	}
+	if l.zdict.conf != nil || numPosts() != zstdDictMaxFailures {
+		t.Errorf("dictionary sent %d times and disabled=%v; want %d times and disabled", numPosts(), l.zdict.conf == nil, zstdDictMaxFailures)
+	}
+
+	// So does one that keeps asking for it after getting it, unless
+	// uploads with it succeed in between.
+	setStatus(http.StatusOK)
+	l = newLogger()
+	l.updateZstdDict(ctx, nil)
+	for range zstdDictMaxFailures - 1 {
+		l.zstdDictFailed(errZstdDictUnknown, true)
+		l.resendZstdDict(ctx)
+		clock.Advance(zstdDictMaxRetry)
+	}
+	l.zdict.noteZstdDictUsed()
+	l.zstdDictFailed(errZstdDictUnknown, true)
+	l.resendZstdDict(ctx)
+	if l.zdict.conf == nil || l.zdict.enc == nil {
+		t.Fatal("dictionary disabled after uploads with it succeeded")
+	}
+	for range zstdDictMaxFailures - 1 {
+		clock.Advance(zstdDictMaxRetry)
+		l.zstdDictFailed(errZstdDictUnknown, true)
+		l.resendZstdDict(ctx)
+	}
+	if l.zdict.conf != nil {
+		t.Error("dictionary still used after the log server kept asking for it")
+	}
+}
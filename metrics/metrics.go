@@ -73,7 +73,13 @@ func CurrentFDs() int {
 	return currentFDs()
 }
 
-// Histogram is a histogram of values.
+// Histogram is a histogram of values, such as latencies, counted in
+// fixed buckets. It satisfies the expvar.Var interface.
+//
+// It's exported by tsweb's Prometheus exporter as a Prometheus
+// histogram, whether or not its expvar name has the "histogram_"
+// prefix.
+//
 // It should be created with NewHistogram.
 type Histogram struct {
 	// buckets is a list of bucket boundaries, in increasing order.
@@ -88,15 +94,17 @@ type Histogram struct {
 	count      expvar.Int
 }
 
-// NewHistogram returns a new histogram that reports to the given
-// expvar map under the given name.
+// NewHistogram returns a new histogram with the given buckets. It
+// needs to be published with expvar.Publish or added to a Set to be
+// exported.
 //
-// The buckets are the boundaries of the histogram buckets, in
-// increasing order. The last bucket is +Inf.
+// The buckets are the inclusive upper bounds of the histogram
+// buckets, in increasing order. An implicit last bucket is +Inf.
 func NewHistogram(buckets []float64) *Histogram {
 	if !slices.IsSorted(buckets) {
 		panic("buckets must be sorted")
 	}
+	buckets = slices.Clone(buckets)
 	labels := make([]string, len(buckets))
 	for i, b := range buckets {
 		labels[i] = fmt.Sprintf("%v", b)
@@ -110,6 +118,9 @@ func NewHistogram(buckets []float64) *Histogram {
 }
 
 // Observe records a new observation in the histogram.
+//
+// Buckets are cumulative, as in Prometheus: v is counted in every
+// bucket whose bound is at least v.
 func (h *Histogram) Observe(v float64) {
 	h.sum.Add(v)
 	h.count.Add(1)
@@ -144,7 +155,8 @@ func (h *Histogram) String() string {
 	return b.String()
 }
 
-// Do calls f for each bucket in the histogram.
+// Do calls f for each bucket in the histogram, keyed by its upper
+// bound, with the count of observations in it and all lower buckets.
 func (h *Histogram) Do(f func(expvar.KeyValue)) {
 	for i := range h.bucketVars {
 		f(expvar.KeyValue{Key: h.bucketStrings[i], Value: &h.bucketVars[i]})
@@ -4,6 +4,9 @@
 package metrics
 
 import (
+	"bytes"
+	"encoding/json"
+	"expvar"
 	"os"
 	"runtime"
 	"testing"
@@ -23,6 +26,78 @@ func TestLabelMap(t *testing.T) {
 	}
 }
 
+func TestHistogram(t *testing.T) {
+	buckets := []float64{1, 10, 100}
+	h := NewHistogram(buckets)
+	buckets[0] = 1000 // must not affect h
+	for _, v := range []float64{0.5, 1, 5, 50, 500} {
+		h.Observe(v)
+	}
+
+	var got map[string]float64
+	if err := json.Unmarshal([]byte(h.String()), &got); err != nil {
+		t.Fatalf("String() = %q; not JSON: %v", h.String(), err)
+	}
+	want := map[string]float64{
+		"1":     2,
+		"10":    3,
+		"100":   4,
+		"+Inf":  5,
+		"sum":   556.5,
+		"count": 5,
+	}
+	if len(got) != len(want) {
+		t.Errorf("String() = %v; want %v", got, want)
+	}
+	for k, w := range want {
+		if g, ok := got[k]; !ok || g != w {
+			t.Errorf("String()[%q] = %v; want %v", k, g, w)
+		}
+	}
+
+	var buf bytes.Buffer
+	h.PromExport(&buf, "lat")
+	const wantProm = `# TYPE lat histogram
+lat_bucket{le="1"} 2
+lat_bucket{le="10"} 3
+lat_bucket{le="100"} 4
+lat_bucket{le="+Inf"} 5
+lat_sum 556.5
+lat_count 5
+`
+	if got := buf.String(); got != wantProm {
+		t.Errorf("PromExport = %q; want %q", got, wantProm)
+	}
+}
+
+func TestProcessSet(t *testing.T) {
+	runtime.GC()
+	s := NewProcessSet()
+	got := map[string]string{}
+	s.Do(func(kv expvar.KeyValue) {
+		got[kv.Key] = kv.Value.String()
+	})
+	for _, k := range []string{"counter_gc_cycles", "counter_gc_pause_seconds"} {
+		if _, ok := got[k]; !ok {
+			t.Errorf("missing %q", k)
+		}
+	}
+	// These are published by tsweb/varz outside of the set.
+	for _, k := range []string{"gauge_goroutines", "counter_uptime_sec", "gauge_start_unix_time"} {
+		if _, ok := got[k]; ok {
+			t.Errorf("unexpected duplicate %q", k)
+		}
+	}
+	if got["counter_gc_cycles"] == "0" {
+		t.Errorf("got %v; want non-zero GC cycles", got)
+	}
+	if runtime.GOOS == "linux" {
+		if v := got["gauge_resident_bytes"]; v == "" || v == "0" {
+			t.Errorf("resident bytes = %q; want non-zero", v)
+		}
+	}
+}
+
 func TestCurrentFileDescriptors(t *testing.T) {
 	if runtime.GOOS != "linux" {
 		t.Skipf("skipping on %v", runtime.GOOS)
@@ -0,0 +1,179 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package metrics
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// MultiLabelMap is a map of label tuples to variables. It satisfies the
+// expvar.Var interface.
+//
+// Semantically, it's like LabelMap but with several labels: it's exported
+// by tsweb's Prometheus exporter as a collection of variables with the
+// same name, with one label per field of T. For example, a
+// MultiLabelMap[struct{ Region, Family string }] is exported as
+//
+//	name{region="nyc",family="ipv4"} 1
+//
+// T must be a struct type. Its fields, which must not be unexported, are
+// the labels, in order. A label is named by the field's "prom" struct tag,
+// or else by the lowercased field name. Label values are formatted with
+// %v.
+//
+// The zero value is ready to use.
+type MultiLabelMap[T comparable] struct {
+	// Type is the Prometheus type of the variables, "counter" or
+	// "gauge". If empty, they're exported without a type.
+	Type string
+
+	mu     sync.RWMutex
+	m      map[T]*labeledVar
+	sorted []*labeledVar // all of m, sorted by labels; nil if stale
+}
+
+// labeledVar is a variable in a MultiLabelMap.
+type labeledVar struct {
+	labels string // as exported, like `region="nyc",family="ipv4"`
+	v      expvar.Var
+}
+
+// Get returns a direct pointer to the expvar.Int for key, creating it if
+// necessary. It panics if key's variable was created with GetFloat.
+func (m *MultiLabelMap[T]) Get(key T) *expvar.Int {
+	return m.getOrCreate(key, func() expvar.Var { return new(expvar.Int) }).(*expvar.Int)
+}
+
+// GetFloat returns a direct pointer to the expvar.Float for key, creating
+// it if necessary. It panics if key's variable was created with Get.
+func (m *MultiLabelMap[T]) GetFloat(key T) *expvar.Float {
+	return m.getOrCreate(key, func() expvar.Var { return new(expvar.Float) }).(*expvar.Float)
+}
+
+// Add adds delta to the expvar.Int for key, creating it if necessary.
+func (m *MultiLabelMap[T]) Add(key T, delta int64) {
+	m.Get(key).Add(delta)
+}
+
+func (m *MultiLabelMap[T]) getOrCreate(key T, newVar func() expvar.Var) expvar.Var {
+	m.mu.RLock()
+	lv, ok := m.m[key]
+	m.mu.RUnlock()
+	if ok {
+		return lv.v
+	}
+
+	labels := labelString(key)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if lv, ok := m.m[key]; ok {
+		return lv.v
+	}
+	if m.m == nil {
+		m.m = make(map[T]*labeledVar)
+	}
+	lv = &labeledVar{labels: labels, v: newVar()}
+	m.m[key] = lv
+	m.sorted = nil
+	return lv.v
+}
+
+// Len returns the number of label tuples in m.
+func (m *MultiLabelMap[T]) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.m)
+}
+
+// sortedVars returns the variables of m, sorted by their labels. The
+// returned slice must not be modified.
+func (m *MultiLabelMap[T]) sortedVars() []*labeledVar {
+	m.mu.RLock()
+	s := m.sorted
+	m.mu.RUnlock()
+	if s != nil || m.Len() == 0 {
+		return s
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sorted == nil {
+		s := make([]*labeledVar, 0, len(m.m))
+		for _, lv := range m.m {
+			s = append(s, lv)
+		}
+		slices.SortFunc(s, func(a, b *labeledVar) int {
+			return strings.Compare(a.labels, b.labels)
+		})
+		m.sorted = s
+	}
+	return m.sorted
+}
+
+// Do calls f for each variable in m, in a stable order. Its key is the
+// labels of the variable as exported, like `region="nyc",family="ipv4"`.
+func (m *MultiLabelMap[T]) Do(f func(expvar.KeyValue)) {
+	for _, lv := range m.sortedVars() {
+		f(expvar.KeyValue{Key: lv.labels, Value: lv.v})
+	}
+}
+
+// String returns a JSON representation of m, keyed by the labels of each
+// variable. This is used to satisfy the expvar.Var interface.
+func (m *MultiLabelMap[T]) String() string {
+	var b strings.Builder
+	b.WriteString("{")
+	first := true
+	m.Do(func(kv expvar.KeyValue) {
+		if !first {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, "%q: %v", kv.Key, kv.Value)
+		first = false
+	})
+	b.WriteString("}")
+	return b.String()
+}
+
+// PromExport writes m to w in Prometheus exposition format.
+func (m *MultiLabelMap[T]) PromExport(w io.Writer, name string) {
+	if m.Type != "" {
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, m.Type)
+	}
+	m.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, "%s{%s} %v\n", name, kv.Key, kv.Value)
+	})
+}
+
+// labelString returns the labels of key in Prometheus exposition format,
+// like `region="nyc",family="ipv4"`.
+func labelString(key any) string {
+	rv := reflect.ValueOf(key)
+	t := rv.Type()
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("MultiLabelMap key type %v is not a struct", t))
+	}
+	var b strings.Builder
+	for i := range t.NumField() {
+		ft := t.Field(i)
+		if !ft.IsExported() {
+			panic(fmt.Sprintf("MultiLabelMap key type %v has unexported field %s", t, ft.Name))
+		}
+		label := ft.Tag.Get("prom")
+		if label == "" {
+			label = strings.ToLower(ft.Name)
+		}
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, "%s=%q", label, fmt.Sprint(rv.Field(i).Interface()))
+	}
+	return b.String()
+}
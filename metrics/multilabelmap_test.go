@@ -0,0 +1,77 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestMultiLabelMap(t *testing.T) {
+	type labels struct {
+		Region string
+		Family string `prom:"af"`
+		Port   int
+	}
+	m := &MultiLabelMap[labels]{Type: "counter"}
+	m.Add(labels{"nyc", "ipv6", 3478}, 1)
+	m.Add(labels{"nyc", "ipv4", 3478}, 2)
+	m.Add(labels{"ams", "ipv4", 3478}, 3)
+	m.Get(labels{"nyc", "ipv4", 3478}).Add(1)
+
+	if g, w := m.Get(labels{"nyc", "ipv4", 3478}).Value(), int64(3); g != w {
+		t.Errorf("nyc/ipv4 = %v; want %v", g, w)
+	}
+	if g, w := m.Len(), 3; g != w {
+		t.Errorf("Len = %v; want %v", g, w)
+	}
+
+	var buf bytes.Buffer
+	m.PromExport(&buf, "stun")
+	const want = `# TYPE stun counter
+stun{region="ams",af="ipv4",port="3478"} 3
+stun{region="nyc",af="ipv4",port="3478"} 3
+stun{region="nyc",af="ipv6",port="3478"} 1
+`
+	if got := buf.String(); got != want {
+		t.Errorf("PromExport = %q; want %q", got, want)
+	}
+
+	var got map[string]int64
+	if err := json.Unmarshal([]byte(m.String()), &got); err != nil {
+		t.Fatalf("String() = %q; not JSON: %v", m.String(), err)
+	}
+	if g, w := got[`region="ams",af="ipv4",port="3478"`], int64(3); g != w {
+		t.Errorf("String() = %v; want ams/ipv4 %v", got, w)
+	}
+
+	f := new(MultiLabelMap[labels])
+	f.GetFloat(labels{"nyc", "ipv4", 1}).Set(0.5)
+	buf.Reset()
+	f.PromExport(&buf, "x")
+	if got, want := buf.String(), "x{region=\"nyc\",af=\"ipv4\",port=\"1\"} 0.5\n"; got != want {
+		t.Errorf("untyped PromExport = %q; want %q", got, want)
+	}
+}
+
+func TestMultiLabelMapNotStruct(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic for non-struct key type")
+		}
+	}()
+	new(MultiLabelMap[string]).Get("foo")
+}
+
+func BenchmarkMultiLabelMapGet(b *testing.B) {
+	type labels struct{ Region, Family string }
+	m := new(MultiLabelMap[labels])
+	k := labels{"nyc", "ipv4"}
+	m.Get(k)
+	b.ReportAllocs()
+	for range b.N {
+		m.Get(k).Add(1)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package metrics
+
+import (
+	"expvar"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// NewProcessSet returns a new Set of metrics about the resource usage of
+// the current process, for every daemon to export the same baseline:
+//
+//   - counter_gc_cycles: the number of completed GC cycles
+//   - counter_gc_pause_seconds: the total time the GC stopped the world
+//   - gauge_resident_bytes: the resident set size (Linux only)
+//   - gauge_open_fds: the number of open file descriptors (Linux only)
+//
+// It's published by tsweb/varz as "proc", so that the metrics are exported
+// as proc_gc_cycles and so on, apart from the process_ metrics of the
+// Prometheus client library's process collector. The goroutine count,
+// uptime and start time aren't included, as tsweb/varz already exports
+// them as gauge_goroutines, counter_uptime_sec and process_start_unix_time.
+func NewProcessSet() *Set {
+	s := new(Set)
+	s.Set("counter_gc_cycles", expvar.Func(func() any { return readGCStats().NumGC }))
+	s.Set("counter_gc_pause_seconds", expvar.Func(func() any { return readGCStats().PauseTotal.Seconds() }))
+	if runtime.GOOS == "linux" {
+		s.Set("gauge_resident_bytes", expvar.Func(func() any { return residentBytes() }))
+		s.Set("gauge_open_fds", expvar.Func(func() any { return CurrentFDs() }))
+	}
+	return s
+}
+
+var (
+	gcStatsMu sync.Mutex
+	gcStats   debug.GCStats // reused to not allocate its Pause slice each time
+)
+
+func readGCStats() debug.GCStats {
+	gcStatsMu.Lock()
+	defer gcStatsMu.Unlock()
+	debug.ReadGCStats(&gcStats)
+	return debug.GCStats{NumGC: gcStats.NumGC, PauseTotal: gcStats.PauseTotal}
+}
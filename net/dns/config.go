@@ -14,6 +14,7 @@
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/util/dnsname"
+	"tailscale.com/util/set"
 )
 
 // Config is a DNS configuration.
@@ -30,6 +31,11 @@ type Config struct {
 	// A Routes entry with no resolvers means the route should be
 	// authoritatively answered using the contents of Hosts.
 	Routes map[dnsname.FQDN][]*dnstype.Resolver
+	// FailoverRoutes are the Routes entries whose resolvers are in
+	// order of preference and are used one at a time, failing over
+	// from one to the next when it's unhealthy, rather than all at
+	// once.
+	FailoverRoutes set.Set[dnsname.FQDN]
 	// SearchDomains are DNS suffixes to try when expanding
 	// single-label queries.
 	SearchDomains []dnsname.FQDN
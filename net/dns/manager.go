@@ -151,6 +151,7 @@ func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig
 	// authoritative suffixes, even if we don't propagate MagicDNS to
 	// the OS.
 	rcfg.Hosts = cfg.Hosts
+	rcfg.FailoverRoutes = cfg.FailoverRoutes
 	routes := map[dnsname.FQDN][]*dnstype.Resolver{} // assigned conditionally to rcfg.Routes below.
 	for suffix, resolvers := range cfg.Routes {
 		if len(resolvers) == 0 {
@@ -216,9 +217,10 @@ func (m *Manager) compileConfig(cfg Config) (rcfg resolver.Config, ocfg OSConfig
 	// This bool is used in a couple of places below to implement this
 	// workaround.
 	isWindows := runtime.GOOS == "windows"
-	if len(cfg.singleResolverSet()) > 0 && m.os.SupportsSplitDNS() && !isWindows {
+	if len(cfg.singleResolverSet()) > 0 && m.os.SupportsSplitDNS() && !isWindows && len(cfg.FailoverRoutes) == 0 {
 		// Split DNS configuration requested, where all split domains
-		// go to the same resolvers. We can let the OS do it.
+		// go to the same resolvers. We can let the OS do it, unless
+		// they need failing over, which only quad-100 does.
 		ocfg.Nameservers = toIPsOnly(cfg.singleResolverSet())
 		ocfg.MatchDomains = cfg.matchDomains()
 		return rcfg, ocfg, nil
@@ -445,7 +447,10 @@ func (m *Manager) Down() error {
 	return nil
 }
 
+// FlushCaches flushes the OS's DNS caches and the cache of Tailscale's own
+// resolver.
 func (m *Manager) FlushCaches() error {
+	m.resolver.FlushCache()
 	return flushCaches()
 }
 
@@ -15,6 +15,7 @@
 	"tailscale.com/net/tsdial"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/util/dnsname"
+	"tailscale.com/util/set"
 )
 
 type fakeOSConfigurator struct {
@@ -399,6 +400,24 @@ func TestManager(t *testing.T) {
 				MatchDomains:  fqdns("corp.com"),
 			},
 		},
+		{
+			name: "routes-failover-split",
+			in: Config{
+				Routes:         upstreams("corp.com", "2.2.2.2", "2.2.2.3"),
+				FailoverRoutes: set.SetOf(fqdns("corp.com")),
+				SearchDomains:  fqdns("tailscale.com", "universe.tf"),
+			},
+			split: true,
+			os: OSConfig{
+				Nameservers:   mustIPs("100.100.100.100"),
+				SearchDomains: fqdns("tailscale.com", "universe.tf"),
+				MatchDomains:  fqdns("corp.com"),
+			},
+			rs: resolver.Config{
+				Routes:         upstreams("corp.com.", "2.2.2.2", "2.2.2.3"),
+				FailoverRoutes: set.SetOf(fqdns("corp.com")),
+			},
+		},
 		{
 			name: "routes-multi",
 			in: Config{
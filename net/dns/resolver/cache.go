@@ -0,0 +1,248 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"sync"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/envknob"
+	"tailscale.com/tstime"
+	"tailscale.com/util/lru"
+)
+
+var (
+	// disableCache disables caching the responses of upstream resolvers.
+	disableCache = envknob.RegisterBool("TS_DNS_CACHE_DISABLE")
+
+	// cacheMinTTL and cacheMaxTTL, if non-zero, clamp the TTLs of cached
+	// responses, which is how long they're cached and the TTLs they're
+	// served with. If cacheMaxTTL is zero, defaultCacheMaxTTL is used.
+	cacheMinTTL = envknob.RegisterDuration("TS_DNS_CACHE_MIN_TTL")
+	cacheMaxTTL = envknob.RegisterDuration("TS_DNS_CACHE_MAX_TTL")
+)
+
+const (
+	// defaultCacheMaxTTL is the longest a response is cached, whatever
+	// its TTL, unless TS_DNS_CACHE_MAX_TTL says otherwise.
+	defaultCacheMaxTTL = time.Hour
+
+	// maxCacheEntries is the most responses to cache. The least recently
+	// used are evicted beyond that.
+	maxCacheEntries = 4096
+)
+
+// cacheKey identifies the queries that a cached response answers.
+type cacheKey struct {
+	name  string // lowercase, with trailing dot
+	typ   dns.Type
+	class dns.Class
+
+	// family is "udp" or "tcp". They're cached separately as responses to
+	// UDP queries may be truncated.
+	family string
+
+	// edns is whether the query had an EDNS OPT record, which the
+	// response then has too.
+	edns bool
+}
+
+// cacheEntry is a cached response.
+type cacheEntry struct {
+	msg     dns.Message // the response; TTLs are clamped
+	stored  time.Time
+	expires time.Time
+}
+
+// responseCache caches the positive and negative responses of upstream
+// resolvers to forwarded queries. It is safe for concurrent use.
+type responseCache struct {
+	clock tstime.Clock
+
+	mu   sync.Mutex
+	ents lru.Cache[cacheKey, *cacheEntry]
+}
+
+func newResponseCache(clock tstime.Clock) *responseCache {
+	c := &responseCache{clock: clock}
+	c.ents.MaxEntries = maxCacheEntries
+	return c
+}
+
+// cacheTTLBounds returns the TTL clamps to apply to cached responses.
+func cacheTTLBounds() (lo, hi uint32) {
+	maxTTL := cacheMaxTTL()
+	if maxTTL <= 0 {
+		maxTTL = defaultCacheMaxTTL
+	}
+	return uint32(max(cacheMinTTL(), 0) / time.Second), uint32(maxTTL / time.Second)
+}
+
+// parseCacheableQuery returns the header of the DNS query q and its cache
+// key, or false if its response can't be cached.
+func parseCacheableQuery(q []byte, family string) (dns.Header, cacheKey, bool) {
+	var p dns.Parser
+	hdr, err := p.Start(q)
+	if err != nil || hdr.Response || hdr.OpCode != 0 {
+		return dns.Header{}, cacheKey{}, false
+	}
+	qs, err := p.AllQuestions()
+	if err != nil || len(qs) != 1 {
+		return dns.Header{}, cacheKey{}, false
+	}
+	if p.SkipAllAnswers() != nil || p.SkipAllAuthorities() != nil {
+		return dns.Header{}, cacheKey{}, false
+	}
+	k := cacheKey{
+		name:   rawNameToLower(qs[0].Name.Data[:qs[0].Name.Length]),
+		typ:    qs[0].Type,
+		class:  qs[0].Class,
+		family: family,
+	}
+	for {
+		h, err := p.AdditionalHeader()
+		if err == dns.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return dns.Header{}, cacheKey{}, false
+		}
+		if h.Type == dns.TypeOPT {
+			k.edns = true
+		}
+		if err := p.SkipAdditional(); err != nil {
+			return dns.Header{}, cacheKey{}, false
+		}
+	}
+	return hdr, k, true
+}
+
+// get returns the cached response to query q received over family, if any,
+// with the query's ID and question and its TTLs reduced by the time it's
+// been cached.
+func (c *responseCache) get(q []byte, family string) ([]byte, bool) {
+	hdr, k, ok := parseCacheableQuery(q, family)
+	if !ok {
+		return nil, false
+	}
+	now := c.clock.Now()
+	c.mu.Lock()
+	e, ok := c.ents.GetOk(k)
+	if ok && !now.Before(e.expires) {
+		c.ents.Delete(k)
+		ok = false
+	}
+	c.mu.Unlock()
+	if !ok {
+		metricDNSFwdCacheMiss.Add(1)
+		return nil, false
+	}
+
+	var p dns.Parser
+	p.Start(q)
+	qq, err := p.Question()
+	if err != nil {
+		return nil, false
+	}
+	age := uint32(now.Sub(e.stored) / time.Second)
+	msg := e.msg
+	msg.Header.ID = hdr.ID
+	msg.Questions = []dns.Question{qq} // with the query's capitalization
+	msg.Answers = agedResources(msg.Answers, age)
+	msg.Authorities = agedResources(msg.Authorities, age)
+	msg.Additionals = agedResources(msg.Additionals, age)
+	res, err := msg.Pack()
+	if err != nil {
+		return nil, false
+	}
+	metricDNSFwdCacheHit.Add(1)
+	return res, true
+}
+
+// agedResources returns a copy of rrs with their TTLs reduced by age.
+func agedResources(rrs []dns.Resource, age uint32) []dns.Resource {
+	if len(rrs) == 0 {
+		return rrs
+	}
+	ret := make([]dns.Resource, len(rrs))
+	for i, rr := range rrs {
+		if rr.Header.Type != dns.TypeOPT { // OPT's TTL holds flags
+			rr.Header.TTL -= min(rr.Header.TTL, age)
+		}
+		ret[i] = rr
+	}
+	return ret
+}
+
+// put caches res, the response of an upstream resolver to query q received
+// over family, if it can be cached.
+//
+// Successful responses with answers are cached for their lowest TTL.
+// NXDOMAIN and empty successful responses are cached for the TTL of the SOA
+// record in their authority section, as in RFC 2308, if there is one.
+func (c *responseCache) put(q, res []byte, family string) {
+	_, k, ok := parseCacheableQuery(q, family)
+	if !ok {
+		return
+	}
+	var msg dns.Message
+	if err := msg.Unpack(res); err != nil || msg.Header.Truncated {
+		return
+	}
+	var ttl uint32
+	switch {
+	case msg.Header.RCode == dns.RCodeSuccess && len(msg.Answers) > 0:
+		ttl = msg.Answers[0].Header.TTL
+		for _, rr := range msg.Answers[1:] {
+			ttl = min(ttl, rr.Header.TTL)
+		}
+	case msg.Header.RCode == dns.RCodeSuccess || msg.Header.RCode == dns.RCodeNameError:
+		var haveSOA bool
+		for _, rr := range msg.Authorities {
+			if soa, ok := rr.Body.(*dns.SOAResource); ok {
+				ttl = min(rr.Header.TTL, soa.MinTTL)
+				haveSOA = true
+				break
+			}
+		}
+		if !haveSOA {
+			return
+		}
+	default:
+		return
+	}
+	lo, hi := cacheTTLBounds()
+	ttl = min(max(ttl, lo), hi)
+	if ttl == 0 {
+		return
+	}
+	for _, rrs := range [][]dns.Resource{msg.Answers, msg.Authorities, msg.Additionals} {
+		for i := range rrs {
+			if h := &rrs[i].Header; h.Type != dns.TypeOPT {
+				h.TTL = min(max(h.TTL, lo), hi)
+			}
+		}
+	}
+
+	now := c.clock.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ents.Set(k, &cacheEntry{
+		msg:     msg,
+		stored:  now,
+		expires: now.Add(time.Duration(ttl) * time.Second),
+	})
+}
+
+// flush drops all cached responses and returns how many there were.
+func (c *responseCache) flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.ents.Len()
+	for c.ents.Len() > 0 {
+		c.ents.DeleteOldest()
+	}
+	return n
+}
@@ -0,0 +1,243 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	miekdns "github.com/miekg/dns"
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/envknob"
+	"tailscale.com/tstest"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/dnsname"
+)
+
+// cacheTestResponse returns a response to query q with the given rcode,
+// answered with one A record per TTL in answerTTLs and, if soaTTL is
+// non-zero, an SOA record with that TTL and MinTTL in the authority section.
+func cacheTestResponse(t *testing.T, q []byte, rcode dns.RCode, soaTTL uint32, answerTTLs ...uint32) []byte {
+	t.Helper()
+	var p dns.Parser
+	hdr, err := p.Start(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qq, err := p.Question()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := dns.Message{
+		Header:    dns.Header{ID: hdr.ID, Response: true, RCode: rcode},
+		Questions: []dns.Question{qq},
+	}
+	for i, ttl := range answerTTLs {
+		msg.Answers = append(msg.Answers, dns.Resource{
+			Header: dns.ResourceHeader{Name: qq.Name, Type: dns.TypeA, Class: dns.ClassINET, TTL: ttl},
+			Body:   &dns.AResource{A: [4]byte{192, 0, 2, byte(i + 1)}},
+		})
+	}
+	if soaTTL != 0 {
+		msg.Authorities = append(msg.Authorities, dns.Resource{
+			Header: dns.ResourceHeader{Name: dns.MustNewName("example.com."), Type: dns.TypeSOA, Class: dns.ClassINET, TTL: soaTTL},
+			Body: &dns.SOAResource{
+				NS:     dns.MustNewName("ns.example.com."),
+				MBox:   dns.MustNewName("admin.example.com."),
+				MinTTL: soaTTL,
+			},
+		})
+	}
+	res, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// cachedTTLs returns the TTLs of the records in the cached response to q, or
+// nil if there's none.
+func cachedTTLs(t *testing.T, c *responseCache, q []byte) []uint32 {
+	t.Helper()
+	res, ok := c.get(q, "udp")
+	if !ok {
+		return nil
+	}
+	var msg dns.Message
+	if err := msg.Unpack(res); err != nil {
+		t.Fatal(err)
+	}
+	if getTxID(res) != getTxID(q) {
+		t.Errorf("cached response has ID %v; want %v", getTxID(res), getTxID(q))
+	}
+	ttls := []uint32{}
+	for _, rr := range append(msg.Answers, msg.Authorities...) {
+		ttls = append(ttls, rr.Header.TTL)
+	}
+	return ttls
+}
+
+func TestResponseCache(t *testing.T) {
+	clock := tstest.NewClock(tstest.ClockOpts{})
+	q := dnspacket("www.example.com.", dns.TypeA, noEdns)
+
+	t.Run("positive", func(t *testing.T) {
+		c := newResponseCache(clock)
+		c.put(q, cacheTestResponse(t, q, dns.RCodeSuccess, 0, 300, 60), "udp")
+		if got := cachedTTLs(t, c, q); len(got) != 2 || got[0] != 300 || got[1] != 60 {
+			t.Errorf("TTLs = %v; want [300 60]", got)
+		}
+		clock.Advance(10 * time.Second)
+		if got := cachedTTLs(t, c, q); len(got) != 2 || got[0] != 290 || got[1] != 50 {
+			t.Errorf("TTLs after 10s = %v; want [290 50]", got)
+		}
+
+		// Other capitalizations, IDs and types.
+		q2 := dnspacket("WWW.Example.com.", dns.TypeA, noEdns)
+		q2[0]++
+		res, ok := c.get(q2, "udp")
+		if !ok {
+			t.Fatal("query with other capitalization not answered from cache")
+		}
+		var p dns.Parser
+		p.Start(res)
+		if qq, _ := p.Question(); qq.Name.String() != "WWW.Example.com." || getTxID(res) != getTxID(q2) {
+			t.Errorf("cached response to %q has question %q and ID %v", "WWW.Example.com.", qq.Name.String(), getTxID(res))
+		}
+		if _, ok := c.get(dnspacket("www.example.com.", dns.TypeAAAA, noEdns), "udp"); ok {
+			t.Error("AAAA query answered with cached A response")
+		}
+		if _, ok := c.get(q, "tcp"); ok {
+			t.Error("TCP query answered with cached UDP response")
+		}
+		if _, ok := c.get(dnspacket("www.example.com.", dns.TypeA, 1232), "udp"); ok {
+			t.Error("EDNS query answered with cached non-EDNS response")
+		}
+
+		clock.Advance(50 * time.Second)
+		if got := cachedTTLs(t, c, q); got != nil {
+			t.Errorf("response still cached after its lowest TTL: %v", got)
+		}
+	})
+
+	t.Run("negative", func(t *testing.T) {
+		c := newResponseCache(clock)
+		c.put(q, cacheTestResponse(t, q, dns.RCodeNameError, 30), "udp")
+		if got := cachedTTLs(t, c, q); len(got) != 1 || got[0] != 30 {
+			t.Errorf("NXDOMAIN TTLs = %v; want [30]", got)
+		}
+		clock.Advance(30 * time.Second)
+		if got := cachedTTLs(t, c, q); got != nil {
+			t.Errorf("NXDOMAIN still cached after SOA TTL: %v", got)
+		}
+
+		// Without an SOA record, there's no TTL to cache for.
+		c.put(q, cacheTestResponse(t, q, dns.RCodeNameError, 0), "udp")
+		if got := cachedTTLs(t, c, q); got != nil {
+			t.Errorf("NXDOMAIN without SOA cached: %v", got)
+		}
+		c.put(q, cacheTestResponse(t, q, dns.RCodeServerFailure, 30), "udp")
+		if got := cachedTTLs(t, c, q); got != nil {
+			t.Errorf("SERVFAIL cached: %v", got)
+		}
+	})
+
+	t.Run("clamp", func(t *testing.T) {
+		envknob.Setenv("TS_DNS_CACHE_MIN_TTL", "2m")
+		envknob.Setenv("TS_DNS_CACHE_MAX_TTL", "10m")
+		defer envknob.Setenv("TS_DNS_CACHE_MIN_TTL", "")
+		defer envknob.Setenv("TS_DNS_CACHE_MAX_TTL", "")
+
+		c := newResponseCache(clock)
+		c.put(q, cacheTestResponse(t, q, dns.RCodeSuccess, 0, 0, 86400), "udp")
+		if got := cachedTTLs(t, c, q); len(got) != 2 || got[0] != 120 || got[1] != 600 {
+			t.Errorf("clamped TTLs = %v; want [120 600]", got)
+		}
+		clock.Advance(2 * time.Minute)
+		if got := cachedTTLs(t, c, q); got != nil {
+			t.Errorf("response still cached after min TTL: %v", got)
+		}
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		c := newResponseCache(clock)
+		c.put(q, cacheTestResponse(t, q, dns.RCodeSuccess, 0, 300), "udp")
+		c.put(q, cacheTestResponse(t, q, dns.RCodeSuccess, 0, 300), "tcp")
+		if n := c.flush(); n != 2 {
+			t.Errorf("flush = %d; want 2", n)
+		}
+		if got := cachedTTLs(t, c, q); got != nil {
+			t.Errorf("response still cached after flush: %v", got)
+		}
+	})
+}
+
+func TestResolverCache(t *testing.T) {
+	var queries atomic.Int32
+	server := serveDNS(t, "127.0.0.1:0", "example.com.", miekdns.HandlerFunc(func(w miekdns.ResponseWriter, req *miekdns.Msg) {
+		queries.Add(1)
+		m := new(miekdns.Msg)
+		m.SetReply(req)
+		m.Answer = append(m.Answer, &miekdns.A{
+			Hdr: miekdns.RR_Header{Name: req.Question[0].Name, Rrtype: miekdns.TypeA, Class: miekdns.ClassINET, Ttl: 300},
+			A:   netip.MustParseAddr("192.0.2.1").AsSlice(),
+		})
+		w.WriteMsg(m)
+	}))
+	defer server.Shutdown()
+
+	r := newResolver(t)
+	defer r.Close()
+	if r.forwarder.cache == nil {
+		t.Skip("cache disabled by TS_DNS_CACHE_DISABLE")
+	}
+
+	cfg := dnsCfg
+	cfg.Routes = map[dnsname.FQDN][]*dnstype.Resolver{
+		".": {{Addr: server.PacketConn.LocalAddr().String()}},
+	}
+	r.SetConfig(cfg)
+
+	query := func() {
+		t.Helper()
+		res, err := syncRespond(r, dnspacket("www.example.com.", dns.TypeA, noEdns))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rcode := getRCode(res); rcode != dns.RCodeSuccess {
+			t.Fatalf("rcode = %v", rcode)
+		}
+	}
+	query()
+	query()
+	if n := queries.Load(); n != 1 {
+		t.Errorf("upstream got %d queries; want 1", n)
+	}
+
+	// The same config keeps the cache; new routes flush it.
+	r.SetConfig(cfg)
+	query()
+	if n := queries.Load(); n != 1 {
+		t.Errorf("upstream got %d queries after same config; want 1", n)
+	}
+	cfg.Routes = map[dnsname.FQDN][]*dnstype.Resolver{
+		".":             {{Addr: server.PacketConn.LocalAddr().String()}},
+		"corp.example.": {{Addr: "192.0.2.53"}},
+	}
+	r.SetConfig(cfg)
+	query()
+	if n := queries.Load(); n != 2 {
+		t.Errorf("upstream got %d queries after new routes; want 2", n)
+	}
+
+	if n := r.FlushCache(); n != 1 {
+		t.Errorf("FlushCache = %d; want 1", n)
+	}
+	query()
+	if n := queries.Load(); n != 3 {
+		t.Errorf("upstream got %d queries after flush; want 3", n)
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/dnsname"
+)
+
+const (
+	// failoverQueryTimeout is how long to wait for one resolver of a
+	// failover route to answer before trying the next one. It leaves
+	// send time to retry over TCP after udpRaceTimeout.
+	failoverQueryTimeout = 3 * time.Second
+
+	// failoverProbeInterval is how often to health check the unhealthy
+	// resolvers of a failover route, to notice when they're back.
+	failoverProbeInterval = 10 * time.Second
+)
+
+// failoverRoute is the state of a route in Config.FailoverRoutes. Its
+// resolvers are used one at a time, in order of preference: queries go to
+// the first healthy one, and a resolver that fails to answer a query is
+// marked unhealthy until a health check sees it answer again.
+type failoverRoute struct {
+	f         *forwarder
+	suffix    dnsname.FQDN
+	resolvers []resolverAndDelay // in order of preference; no start delays
+
+	// ctx is done when the route is replaced by a new config or the
+	// forwarder is closed. It stops probeLoop.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex       // guards following
+	health  []resolverHealth // parallel to resolvers
+	probing bool             // whether probeLoop is running
+}
+
+// resolverHealth is the health of one resolver of a failoverRoute.
+type resolverHealth struct {
+	downSince time.Time // zero if healthy
+	lastErr   error     // why it was marked unhealthy
+}
+
+func newFailoverRoute(f *forwarder, suffix dnsname.FQDN, rs []*dnstype.Resolver) *failoverRoute {
+	fr := &failoverRoute{
+		f:         f,
+		suffix:    suffix,
+		resolvers: make([]resolverAndDelay, len(rs)),
+		health:    make([]resolverHealth, len(rs)),
+	}
+	for i, r := range rs {
+		fr.resolvers[i] = resolverAndDelay{name: r}
+	}
+	fr.ctx, fr.cancel = context.WithCancel(f.ctx)
+	return fr
+}
+
+// usesResolvers reports whether fr uses exactly rs, in the same order, so
+// that it can be kept across a reconfig along with its health state.
+func (fr *failoverRoute) usesResolvers(rs []*dnstype.Resolver) bool {
+	return slices.EqualFunc(fr.resolvers, rs, func(rr resolverAndDelay, r *dnstype.Resolver) bool {
+		return rr.name.Equal(r)
+	})
+}
+
+// close stops fr's health checks.
+func (fr *failoverRoute) close() {
+	fr.cancel()
+}
+
+// order returns the indexes into fr.resolvers in the order to try them: the
+// healthy ones first, then the unhealthy ones as a last resort, each in
+// order of preference.
+func (fr *failoverRoute) order() []int {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	ret := make([]int, 0, len(fr.health))
+	for i, h := range fr.health {
+		if h.downSince.IsZero() {
+			ret = append(ret, i)
+		}
+	}
+	for i, h := range fr.health {
+		if !h.downSince.IsZero() {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+// activeLocked returns the index of the resolver queries currently go to.
+//
+// fr.mu must be held.
+func (fr *failoverRoute) activeLocked() int {
+	for i, h := range fr.health {
+		if h.downSince.IsZero() {
+			return i
+		}
+	}
+	return 0
+}
+
+// markDown marks resolver i unhealthy after it failed to answer with err,
+// and starts health checking it.
+func (fr *failoverRoute) markDown(i int, err error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	h := &fr.health[i]
+	h.lastErr = err
+	if !h.downSince.IsZero() {
+		return
+	}
+	h.downSince = time.Now()
+	metricDNSFwdFailover.Add(1)
+	fr.f.logf("failover %v: resolver %v unhealthy: %v; now using %v", fr.suffix, fr.resolvers[i].name.Addr, err, fr.resolvers[fr.activeLocked()].name.Addr)
+	if !fr.probing {
+		fr.probing = true
+		go fr.probeLoop()
+	}
+}
+
+// markUp marks resolver i healthy after it answered.
+func (fr *failoverRoute) markUp(i int) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	h := &fr.health[i]
+	if h.downSince.IsZero() {
+		return
+	}
+	*h = resolverHealth{}
+	metricDNSFwdFailoverRestored.Add(1)
+	fr.f.logf("failover %v: resolver %v healthy again; now using %v", fr.suffix, fr.resolvers[i].name.Addr, fr.resolvers[fr.activeLocked()].name.Addr)
+}
+
+// probeLoop health checks fr's unhealthy resolvers every
+// failoverProbeInterval until they're all healthy or fr is closed.
+func (fr *failoverRoute) probeLoop() {
+	t := time.NewTicker(failoverProbeInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-fr.ctx.Done():
+			return
+		case <-t.C:
+		}
+		fr.mu.Lock()
+		var down []int
+		for i, h := range fr.health {
+			if !h.downSince.IsZero() {
+				down = append(down, i)
+			}
+		}
+		if len(down) == 0 {
+			fr.probing = false
+			fr.mu.Unlock()
+			return
+		}
+		fr.mu.Unlock()
+		for _, i := range down {
+			if err := fr.probe(i); err == nil {
+				fr.markUp(i)
+			} else if fr.ctx.Err() == nil {
+				fr.markDown(i, err)
+			}
+		}
+	}
+}
+
+// probe health checks resolver i by asking it for the SOA record of the
+// route's suffix. Any answer that isn't a server failure, including
+// NXDOMAIN, counts as healthy.
+func (fr *failoverRoute) probe(i int) error {
+	pkt, err := probeQuery(fr.suffix)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(fr.ctx, failoverQueryTimeout)
+	defer cancel()
+	fq := &forwardQuery{
+		txid:           getTxID(pkt),
+		packet:         pkt,
+		family:         "udp",
+		closeOnCtxDone: new(closePool),
+	}
+	defer fq.closeOnCtxDone.Close()
+	_, err = fr.f.send(ctx, fq, fr.resolvers[i])
+	return err
+}
+
+// probeQuery returns a recursive DNS query for the SOA record of name.
+func probeQuery(name dnsname.FQDN) ([]byte, error) {
+	n, err := dns.NewName(name.WithTrailingDot())
+	if err != nil {
+		return nil, err
+	}
+	b := dns.NewBuilder(nil, dns.Header{
+		ID:               uint16(rand.Intn(1 << 16)),
+		RecursionDesired: true,
+	})
+	b.StartQuestions()
+	b.Question(dns.Question{Name: n, Type: dns.TypeSOA, Class: dns.ClassINET})
+	return b.Finish()
+}
+
+// status returns the current state of fr.
+func (fr *failoverRoute) status() dnstype.FailoverRouteStatus {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	st := dnstype.FailoverRouteStatus{
+		Suffix:    string(fr.suffix),
+		Active:    fr.resolvers[fr.activeLocked()].name.Addr,
+		Resolvers: make([]dnstype.FailoverResolverStatus, len(fr.resolvers)),
+	}
+	for i, h := range fr.health {
+		rs := dnstype.FailoverResolverStatus{
+			Addr:      fr.resolvers[i].name.Addr,
+			Healthy:   h.downSince.IsZero(),
+			DownSince: h.downSince,
+		}
+		if h.lastErr != nil {
+			rs.LastError = h.lastErr.Error()
+		}
+		st.Resolvers[i] = rs
+	}
+	return st
+}
+
+// forwardFailover sends fq to the resolvers of fr one at a time, in the
+// order given by fr.order, and returns the first answer and the address of
+// the resolver that sent it. Resolvers that don't answer within
+// failoverQueryTimeout are marked unhealthy. If none answer, it returns the
+// first error.
+func (f *forwarder) forwardFailover(ctx context.Context, fq *forwardQuery, fr *failoverRoute) (res []byte, upstream string, err error) {
+	var firstErr error
+	for _, i := range fr.order() {
+		rr := fr.resolvers[i]
+		actx, cancel := context.WithTimeout(ctx, failoverQueryTimeout)
+		res, err := f.send(actx, fq, rr)
+		cancel()
+		if err == nil {
+			fr.markUp(i)
+			return res, rr.name.Addr, nil
+		}
+		if ctx.Err() != nil {
+			// Our caller gave up; that's not the resolver's fault.
+			return nil, "", ctx.Err()
+		}
+		fr.markDown(i, err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, "", firstErr
+}
+
+// failoverStatus returns the state of the current failover routes, sorted by
+// suffix.
+func (f *forwarder) failoverStatus() []dnstype.FailoverRouteStatus {
+	f.mu.Lock()
+	routes := f.routes
+	f.mu.Unlock()
+	var ret []dnstype.FailoverRouteStatus
+	for _, r := range routes {
+		if r.Failover != nil {
+			ret = append(ret, r.Failover.status())
+		}
+	}
+	slices.SortFunc(ret, func(a, b dnstype.FailoverRouteStatus) int {
+		return strings.Compare(a.Suffix, b.Suffix)
+	})
+	return ret
+}
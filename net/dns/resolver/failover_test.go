@@ -0,0 +1,127 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"sync/atomic"
+	"testing"
+
+	miekdns "github.com/miekg/dns"
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/util/set"
+)
+
+// failoverTestServer is an upstream DNS server for corp.example that can be
+// made to fail.
+type failoverTestServer struct {
+	addr    string
+	queries atomic.Int32
+	down    atomic.Bool // whether to answer SERVFAIL
+}
+
+func newFailoverTestServer(t *testing.T) *failoverTestServer {
+	s := new(failoverTestServer)
+	server := serveDNS(t, "127.0.0.1:0", "corp.example.", miekdns.HandlerFunc(func(w miekdns.ResponseWriter, req *miekdns.Msg) {
+		s.queries.Add(1)
+		m := new(miekdns.Msg)
+		if s.down.Load() {
+			m.SetRcode(req, miekdns.RcodeServerFailure)
+		} else {
+			m.SetRcode(req, miekdns.RcodeNameError)
+		}
+		w.WriteMsg(m)
+	}))
+	t.Cleanup(func() { server.Shutdown() })
+	s.addr = server.PacketConn.LocalAddr().String()
+	return s
+}
+
+func TestFailoverRoute(t *testing.T) {
+	primary := newFailoverTestServer(t)
+	backup := newFailoverTestServer(t)
+
+	r := newResolver(t)
+	defer r.Close()
+
+	cfg := dnsCfg
+	cfg.Routes = map[dnsname.FQDN][]*dnstype.Resolver{
+		"corp.example.": {{Addr: primary.addr}, {Addr: backup.addr}},
+	}
+	cfg.FailoverRoutes = set.SetOf([]dnsname.FQDN{"corp.example."})
+	r.SetConfig(cfg)
+
+	query := func() {
+		t.Helper()
+		res, err := syncRespond(r, dnspacket("host.corp.example.", dns.TypeA, noEdns))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rcode := getRCode(res); rcode != dns.RCodeNameError {
+			t.Fatalf("rcode = %v; want NameError", rcode)
+		}
+	}
+	checkStatus := func(active string, primaryHealthy bool) {
+		t.Helper()
+		st := r.FailoverStatus()
+		if len(st) != 1 {
+			t.Fatalf("FailoverStatus = %+v; want one route", st)
+		}
+		if st[0].Suffix != "corp.example." || st[0].Active != active || len(st[0].Resolvers) != 2 {
+			t.Errorf("FailoverStatus = %+v; want active %v", st[0], active)
+		}
+		if got := st[0].Resolvers[0]; got.Healthy != primaryHealthy || got.DownSince.IsZero() == !primaryHealthy {
+			t.Errorf("primary status = %+v; want healthy=%v", got, primaryHealthy)
+		}
+	}
+
+	// Healthy: only the primary is queried.
+	query()
+	if p, b := primary.queries.Load(), backup.queries.Load(); p != 1 || b != 0 {
+		t.Errorf("primary got %d queries, backup %d; want 1, 0", p, b)
+	}
+	checkStatus(primary.addr, true)
+
+	// The primary fails: the query fails over to the backup, and the
+	// next one goes straight to it.
+	primary.down.Store(true)
+	query()
+	query()
+	if p, b := primary.queries.Load(), backup.queries.Load(); p != 2 || b != 2 {
+		t.Errorf("primary got %d queries, backup %d; want 2, 2", p, b)
+	}
+	checkStatus(backup.addr, false)
+
+	// Reapplying the same config keeps the health state.
+	r.SetConfig(cfg)
+	checkStatus(backup.addr, false)
+
+	// Once a health check sees the primary answer, it's used again.
+	fr := r.forwarder.routes[0].Failover
+	if err := fr.probe(0); err == nil {
+		t.Fatal("probe of failing primary succeeded")
+	}
+	primary.down.Store(false)
+	if err := fr.probe(0); err != nil {
+		t.Fatalf("probe of restored primary: %v", err)
+	}
+	fr.markUp(0)
+	checkStatus(primary.addr, true)
+	backupQueries := backup.queries.Load()
+	query()
+	if b := backup.queries.Load(); b != backupQueries {
+		t.Errorf("backup queried after primary restored")
+	}
+
+	// Without FailoverRoutes, there's no failover state.
+	cfg.FailoverRoutes = nil
+	r.SetConfig(cfg)
+	if st := r.FailoverStatus(); len(st) != 0 {
+		t.Errorf("FailoverStatus = %+v; want none", st)
+	}
+	if fr.ctx.Err() == nil {
+		t.Error("replaced failover route not closed")
+	}
+}
@@ -15,6 +15,7 @@
 	"net/http"
 	"net/netip"
 	"net/url"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -31,12 +32,14 @@
 	"tailscale.com/net/netns"
 	"tailscale.com/net/sockstats"
 	"tailscale.com/net/tsdial"
+	"tailscale.com/tstime"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/nettype"
 	"tailscale.com/util/cloudenv"
 	"tailscale.com/util/dnsname"
 	"tailscale.com/util/race"
+	"tailscale.com/util/set"
 	"tailscale.com/version"
 )
 
@@ -169,6 +172,10 @@ func clampEDNSSize(packet []byte, maxSize uint16) {
 type route struct {
 	Suffix    dnsname.FQDN
 	Resolvers []resolverAndDelay
+
+	// Failover, if non-nil, means Resolvers are used one at a time, in
+	// order, rather than all at once. It holds their health state.
+	Failover *failoverRoute
 }
 
 // resolverAndDelay is an upstream DNS resolver and a delay for how
@@ -196,6 +203,8 @@ type forwarder struct {
 	ctx       context.Context    // good until Close
 	ctxCancel context.CancelFunc // closes ctx
 
+	cache *responseCache // or nil if disabled
+
 	mu sync.Mutex // guards following
 
 	dohClient map[string]*http.Client // urlBase -> client
@@ -227,6 +236,9 @@ func newForwarder(logf logger.Logf, netMon *netmon.Monitor, linkSel ForwardLinkS
 		dialer:       dialer,
 		controlKnobs: knobs,
 	}
+	if !disableCache() {
+		f.cache = newResponseCache(tstime.StdClock{})
+	}
 	f.ctx, f.ctxCancel = context.WithCancel(context.Background())
 	return f
 }
@@ -317,15 +329,40 @@ func cloudResolvers() []resolverAndDelay {
 }
 
 // setRoutes sets the routes to use for DNS forwarding. It's called by
-// Resolver.SetConfig on reconfig.
+// Resolver.SetConfig on reconfig. The routes whose suffixes are in failover
+// use their resolvers one at a time, in order; their health state is kept
+// if their resolvers haven't changed.
 //
 // The memory referenced by routesBySuffix should not be modified.
-func (f *forwarder) setRoutes(routesBySuffix map[dnsname.FQDN][]*dnstype.Resolver) {
+func (f *forwarder) setRoutes(routesBySuffix map[dnsname.FQDN][]*dnstype.Resolver, failover set.Set[dnsname.FQDN]) {
 	routes := make([]route, 0, len(routesBySuffix))
 
+	f.mu.Lock()
+	oldFailover := map[dnsname.FQDN]*failoverRoute{}
+	oldResolvers := map[dnsname.FQDN][]resolverAndDelay{}
+	for _, r := range f.routes {
+		if r.Failover != nil {
+			oldFailover[r.Suffix] = r.Failover
+		}
+		oldResolvers[r.Suffix] = r.Resolvers
+	}
+	f.mu.Unlock()
+
 	cloudHostFallback := cloudResolvers()
 	for suffix, rs := range routesBySuffix {
-		if suffix == "." && len(rs) == 0 && len(cloudHostFallback) > 0 {
+		if failover.Contains(suffix) && len(rs) > 0 {
+			fr, ok := oldFailover[suffix]
+			if ok && fr.usesResolvers(rs) {
+				delete(oldFailover, suffix)
+			} else {
+				fr = newFailoverRoute(f, suffix, rs)
+			}
+			routes = append(routes, route{
+				Suffix:    suffix,
+				Resolvers: fr.resolvers,
+				Failover:  fr,
+			})
+		} else if suffix == "." && len(rs) == 0 && len(cloudHostFallback) > 0 {
 			routes = append(routes, route{
 				Suffix:    suffix,
 				Resolvers: cloudHostFallback,
@@ -356,6 +393,39 @@ func (f *forwarder) setRoutes(routesBySuffix map[dnsname.FQDN][]*dnstype.Resolve
 	defer f.mu.Unlock()
 	f.routes = routes
 	f.cloudHostFallback = cloudHostFallback
+	for _, fr := range oldFailover {
+		fr.close()
+	}
+	if f.cache != nil && !sameRoutes(oldResolvers, routes) {
+		// The new resolvers may answer differently.
+		f.cache.flush()
+	}
+}
+
+// sameRoutes reports whether routes use the same resolvers for the same
+// suffixes as old does.
+func sameRoutes(old map[dnsname.FQDN][]resolverAndDelay, routes []route) bool {
+	if len(old) != len(routes) {
+		return false
+	}
+	for _, r := range routes {
+		rr, ok := old[r.Suffix]
+		if !ok || !slices.EqualFunc(rr, r.Resolvers, func(a, b resolverAndDelay) bool {
+			return a.name.Equal(b.name)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// flushCache drops all cached upstream responses and returns how many
+// there were.
+func (f *forwarder) flushCache() int {
+	if f.cache == nil {
+		return 0
+	}
+	return f.cache.flush()
 }
 
 var stdNetPacketListener nettype.PacketListenerWithNetIP = nettype.MakePacketListenerWithNetIP(new(net.ListenConfig))
@@ -774,18 +844,19 @@ func (f *forwarder) sendTCP(ctx context.Context, fq *forwardQuery, rr resolverAn
 	return out, nil
 }
 
-// resolvers returns the resolvers to use for domain.
-func (f *forwarder) resolvers(domain dnsname.FQDN) []resolverAndDelay {
+// lookupRoute returns the resolvers to use for domain and, if they're those
+// of a failover route, its state.
+func (f *forwarder) lookupRoute(domain dnsname.FQDN) ([]resolverAndDelay, *failoverRoute) {
 	f.mu.Lock()
 	routes := f.routes
 	cloudHostFallback := f.cloudHostFallback
 	f.mu.Unlock()
 	for _, route := range routes {
 		if route.Suffix == "." || route.Suffix.Contains(domain) {
-			return route.Resolvers
+			return route.Resolvers, route.Failover
 		}
 	}
-	return cloudHostFallback // or nil if no fallback
+	return cloudHostFallback, nil // or nil if no fallback
 }
 
 // forwardQuery is information and state about a forwarded DNS query that's
@@ -820,7 +891,8 @@ type forwardQuery struct {
 // non-nil error (without sending to the channel).
 //
 // If resolvers is non-empty, it's used explicitly (notably, for exit
-// node DNS proxy queries), otherwise f.resolvers is used.
+// node DNS proxy queries), otherwise f.lookupRoute is used. A failover
+// route's resolvers are tried one at a time rather than raced.
 func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, responseChan chan<- packet, resolvers ...resolverAndDelay) error {
 	metricDNSFwd.Add(1)
 	domain, err := nameFromQuery(query.bs)
@@ -860,8 +932,26 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 
 	clampEDNSSize(query.bs, maxResponseBytes)
 
+	// Only cache the responses of the configured routes' resolvers;
+	// explicitly given ones, as for exit node DNS, may answer
+	// differently.
+	useCache := f.cache != nil && len(resolvers) == 0
+	if useCache {
+		if res, ok := f.cache.get(query.bs, query.family); ok {
+			select {
+			case <-ctx.Done():
+				metricDNSFwdErrorContext.Add(1)
+				return ctx.Err()
+			case responseChan <- packet{res, query.family, query.addr, ""}:
+				metricDNSFwdSuccess.Add(1)
+				return nil
+			}
+		}
+	}
+
+	var failover *failoverRoute
 	if len(resolvers) == 0 {
-		resolvers = f.resolvers(domain)
+		resolvers, failover = f.lookupRoute(domain)
 		if len(resolvers) == 0 {
 			metricDNSFwdErrorNoUpstream.Add(1)
 			f.logf("no upstream resolvers set, returning SERVFAIL")
@@ -889,32 +979,45 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 	}
 	defer fq.closeOnCtxDone.Close()
 
-	resc := make(chan []byte, 1) // it's fine buffered or not
+	resc := make(chan packet, 1) // it's fine buffered or not
 	errc := make(chan error, 1)  // it's fine buffered or not too
-	for i := range resolvers {
-		go func(rr *resolverAndDelay) {
-			if rr.startDelay > 0 {
-				timer := time.NewTimer(rr.startDelay)
-				select {
-				case <-timer.C:
-				case <-ctx.Done():
-					timer.Stop()
-					return
-				}
-			}
-			resb, err := f.send(ctx, fq, *rr)
-			if err != nil {
-				select {
-				case errc <- err:
-				case <-ctx.Done():
-				}
-				return
-			}
+	deliver := func(resb []byte, upstream string, err error) {
+		if err != nil {
 			select {
-			case resc <- resb:
+			case errc <- err:
 			case <-ctx.Done():
 			}
-		}(&resolvers[i])
+			return
+		}
+		select {
+		case resc <- packet{resb, query.family, query.addr, upstream}:
+		case <-ctx.Done():
+		}
+	}
+	numUpstreams := len(resolvers)
+	if failover != nil {
+		// forwardFailover tries the resolvers in turn itself, so
+		// there's just one result to wait for.
+		numUpstreams = 1
+		go func() {
+			deliver(f.forwardFailover(ctx, fq, failover))
+		}()
+	} else {
+		for i := range resolvers {
+			go func(rr *resolverAndDelay) {
+				if rr.startDelay > 0 {
+					timer := time.NewTimer(rr.startDelay)
+					select {
+					case <-timer.C:
+					case <-ctx.Done():
+						timer.Stop()
+						return
+					}
+				}
+				res, err := f.send(ctx, fq, *rr)
+				deliver(res, rr.name.Addr, err)
+			}(&resolvers[i])
+		}
 	}
 
 	var firstErr error
@@ -922,11 +1025,14 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 	for {
 		select {
 		case v := <-resc:
+			if useCache {
+				f.cache.put(query.bs, v.bs, query.family)
+			}
 			select {
 			case <-ctx.Done():
 				metricDNSFwdErrorContext.Add(1)
 				return ctx.Err()
-			case responseChan <- packet{v, query.family, query.addr}:
+			case responseChan <- v:
 				metricDNSFwdSuccess.Add(1)
 				return nil
 			}
@@ -935,7 +1041,7 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 				firstErr = err
 			}
 			numErr++
-			if numErr == len(resolvers) {
+			if numErr == numUpstreams {
 				if errors.Is(firstErr, errServerFailure) {
 					res, err := servfailResponse(query)
 					if err != nil {
@@ -36,6 +36,7 @@
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/cloudenv"
 	"tailscale.com/util/dnsname"
+	"tailscale.com/util/set"
 )
 
 const dnsSymbolicFQDN = "magicdns.localhost-tailscale-daemon."
@@ -57,6 +58,11 @@ type packet struct {
 	bs     []byte
 	family string         // either "tcp" or "udp"
 	addr   netip.AddrPort // src for a request, dst for a response
+
+	// upstream is, for a response, the address of the upstream resolver
+	// that sent it, as in dnstype.Resolver.Addr. It's empty if the response
+	// wasn't forwarded from one, such as if it came from the cache.
+	upstream string
 }
 
 // Config is a resolver configuration.
@@ -71,6 +77,10 @@ type Config struct {
 	// Queries only match the most specific suffix.
 	// To register a "default route", add an entry for ".".
 	Routes map[dnsname.FQDN][]*dnstype.Resolver
+	// FailoverRoutes are the Routes entries whose resolvers are used
+	// one at a time, in order, failing over from one to the next,
+	// rather than all at once.
+	FailoverRoutes set.Set[dnsname.FQDN]
 	// LocalHosts is a map of FQDNs to corresponding IPs.
 	Hosts map[dnsname.FQDN][]netip.Addr
 	// LocalDomains is a list of DNS name suffixes that should not be
@@ -190,6 +200,9 @@ type Resolver struct {
 	// closed signals all goroutines to stop.
 	closed chan struct{}
 
+	// queryLogFunc, if non-nil, is called with each query handled by Query.
+	queryLogFunc syncs.AtomicValue[func(dnstype.QueryLogEntry)]
+
 	// mu guards the following fields from being updated while used.
 	mu           sync.Mutex
 	localDomains []dnsname.FQDN
@@ -237,7 +250,7 @@ func (r *Resolver) SetConfig(cfg Config) error {
 		}
 	}
 
-	r.forwarder.setRoutes(cfg.Routes)
+	r.forwarder.setRoutes(cfg.Routes, cfg.FailoverRoutes)
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -266,37 +279,97 @@ func (r *Resolver) Close() {
 // bound on per-query resource usage.
 const dnsQueryTimeout = 10 * time.Second
 
+// SetQueryLogFunc sets the function to call with each DNS query handled by
+// Query, once it has been answered, or nil to stop. It's used to keep the
+// DNS query log. The function must not block.
+func (r *Resolver) SetQueryLogFunc(fn func(dnstype.QueryLogEntry)) {
+	r.queryLogFunc.Store(fn)
+}
+
+// FailoverStatus returns the state of the routes in the current config's
+// FailoverRoutes, sorted by suffix.
+func (r *Resolver) FailoverStatus() []dnstype.FailoverRouteStatus {
+	return r.forwarder.failoverStatus()
+}
+
+// FlushCache drops all cached responses from upstream resolvers and returns
+// how many there were.
+func (r *Resolver) FlushCache() int {
+	return r.forwarder.flushCache()
+}
+
 func (r *Resolver) Query(ctx context.Context, bs []byte, family string, from netip.AddrPort) ([]byte, error) {
+	logQuery := r.queryLogFunc.Load()
+	if logQuery == nil {
+		out, _, err := r.query(ctx, bs, family, from)
+		return out, err
+	}
+	start := time.Now()
+	out, upstream, err := r.query(ctx, bs, family, from)
+	logQuery(queryLogEntry(start, bs, out, upstream, err))
+	return out, err
+}
+
+// query implements Query. It also returns the address of the upstream
+// resolver that answered the query, if it was forwarded to one and not
+// answered locally or from the cache.
+func (r *Resolver) query(ctx context.Context, bs []byte, family string, from netip.AddrPort) (out []byte, upstream string, err error) {
 	metricDNSQueryLocal.Add(1)
 	select {
 	case <-r.closed:
 		metricDNSQueryErrorClosed.Add(1)
-		return nil, net.ErrClosed
+		return nil, "", net.ErrClosed
 	default:
 	}
 
-	out, err := r.respond(bs)
+	out, err = r.respond(bs)
 	if err == errNotOurName {
 		responses := make(chan packet, 1)
 		ctx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
 		defer close(responses)
 		defer cancel()
-		err = r.forwarder.forwardWithDestChan(ctx, packet{bs, family, from}, responses)
+		err = r.forwarder.forwardWithDestChan(ctx, packet{bs, family, from, ""}, responses)
 		if err != nil {
 			select {
 			// Best effort: use any error response sent by forwardWithDestChan.
 			// This is present in some errors paths, such as when all upstream
 			// DNS servers replied with an error.
 			case resp := <-responses:
-				return resp.bs, err
+				return resp.bs, resp.upstream, err
 			default:
-				return nil, err
+				return nil, "", err
 			}
 		}
-		return (<-responses).bs, nil
+		resp := <-responses
+		return resp.bs, resp.upstream, nil
 	}
 
-	return out, err
+	return out, "", err
+}
+
+// queryLogEntry returns the query log entry for the query q received at
+// start, which got the response res from the upstream resolver upstream (or
+// locally, if empty) and error err.
+func queryLogEntry(start time.Time, q, res []byte, upstream string, err error) dnstype.QueryLogEntry {
+	e := dnstype.QueryLogEntry{
+		Time:     start,
+		Upstream: upstream,
+		Latency:  time.Since(start),
+	}
+	var p dns.Parser
+	if _, perr := p.Start(q); perr == nil {
+		if qq, perr := p.Question(); perr == nil {
+			e.Name = qq.Name.String()
+			e.Type = strings.TrimPrefix(qq.Type.String(), "Type")
+		}
+	}
+	if hdr, perr := p.Start(res); perr == nil {
+		e.RCode = strings.TrimPrefix(hdr.RCode.String(), "RCode")
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
 }
 
 // parseExitNodeQuery parses a DNS request packet.
@@ -372,7 +445,7 @@ func (r *Resolver) HandlePeerDNSQuery(ctx context.Context, q []byte, from netip.
 			}}
 		}
 
-		err = r.forwarder.forwardWithDestChan(ctx, packet{q, "tcp", from}, ch, resolvers...)
+		err = r.forwarder.forwardWithDestChan(ctx, packet{q, "tcp", from, ""}, ch, resolvers...)
 		if err != nil {
 			metricDNSExitProxyErrorForward.Add(1)
 			return nil, err
@@ -1330,6 +1403,12 @@ func unARPA(a string) (ipStr string, ok bool) {
 	metricDNSFwdDoHErrorTransport = clientmetric.NewCounter("dns_query_fwd_doh_error_transport")
 	metricDNSFwdDoHErrorBody      = clientmetric.NewCounter("dns_query_fwd_doh_error_body")
 
+	metricDNSFwdCacheHit  = clientmetric.NewCounter("dns_query_fwd_cache_hit")
+	metricDNSFwdCacheMiss = clientmetric.NewCounter("dns_query_fwd_cache_miss")
+
+	metricDNSFwdFailover         = clientmetric.NewCounter("dns_query_fwd_failover")          // a failover route's resolver was marked unhealthy
+	metricDNSFwdFailoverRestored = clientmetric.NewCounter("dns_query_fwd_failover_restored") // and later healthy again
+
 	metricDNSResolveLocal             = clientmetric.NewCounter("dns_resolve_local")
 	metricDNSResolveLocalErrorOnion   = clientmetric.NewCounter("dns_resolve_local_error_onion")
 	metricDNSResolveLocalErrorMissing = clientmetric.NewCounter("dns_resolve_local_error_missing")
@@ -1466,3 +1466,47 @@ func TestServfail(t *testing.T) {
 		t.Errorf("response was %X, want %X", pkt, wantPkt)
 	}
 }
+
+func TestQueryLog(t *testing.T) {
+	server := serveDNS(t, "127.0.0.1:0", "test.site.", miekdns.HandlerFunc(func(w miekdns.ResponseWriter, req *miekdns.Msg) {
+		m := new(miekdns.Msg)
+		m.SetRcode(req, miekdns.RcodeNameError)
+		w.WriteMsg(m)
+	}))
+	defer server.Shutdown()
+	// A resolver that fails to answer, so that only the other one does.
+	failing := serveDNS(t, "127.0.0.1:0", "test.site.", miekdns.HandlerFunc(func(w miekdns.ResponseWriter, req *miekdns.Msg) {
+		m := new(miekdns.Msg)
+		m.SetRcode(req, miekdns.RcodeServerFailure)
+		w.WriteMsg(m)
+	}))
+	defer failing.Shutdown()
+
+	r := newResolver(t)
+	defer r.Close()
+
+	upstream := server.PacketConn.LocalAddr().String()
+	cfg := dnsCfg
+	cfg.Routes = map[dnsname.FQDN][]*dnstype.Resolver{
+		".": {{Addr: failing.PacketConn.LocalAddr().String()}, {Addr: upstream}},
+	}
+	r.SetConfig(cfg)
+
+	var got []dnstype.QueryLogEntry
+	r.SetQueryLogFunc(func(e dnstype.QueryLogEntry) { got = append(got, e) })
+
+	syncRespond(r, dnspacket("test1.ipn.dev.", dns.TypeA, noEdns))
+	syncRespond(r, dnspacket("test.site.", dns.TypeAAAA, noEdns))
+	r.SetQueryLogFunc(nil)
+	syncRespond(r, dnspacket("test2.ipn.dev.", dns.TypeA, noEdns))
+
+	if len(got) != 2 {
+		t.Fatalf("got %d entries, want 2: %+v", len(got), got)
+	}
+	if e := got[0]; e.Name != "test1.ipn.dev." || e.Type != "A" || e.Upstream != "" || e.RCode != "Success" || e.Error != "" {
+		t.Errorf("local query logged as %+v", e)
+	}
+	if e := got[1]; e.Name != "test.site." || e.Type != "AAAA" || e.Upstream != upstream || e.RCode != "NameError" {
+		t.Errorf("forwarded query logged as %+v", e)
+	}
+}
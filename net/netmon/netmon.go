@@ -55,6 +55,7 @@ type Monitor struct {
 	om     osMon         // nil means not supported on this platform
 	change chan bool     // send false to wake poller, true to also force ChangeDeltas be sent
 	stop   chan struct{} // closed on Stop
+	static bool          // whether the state only changes via SetStateForTest
 
 	// Things that must be set early, before use,
 	// and not change at runtime.
@@ -139,6 +140,33 @@ func New(logf logger.Logf) (*Monitor, error) {
 	return m, nil
 }
 
+// NewStatic returns a Monitor whose interface state is st and which never
+// looks at the machine's network interfaces, for hermetic tests. Its state
+// changes only with SetStateForTest. If st is nil, an empty state is used.
+func NewStatic(st *interfaces.State) *Monitor {
+	if st == nil {
+		st = new(interfaces.State)
+	}
+	return &Monitor{
+		logf:     logger.Discard,
+		change:   make(chan bool, 1),
+		stop:     make(chan struct{}),
+		static:   true,
+		ifState:  st,
+		lastWall: wallTime(),
+	}
+}
+
+// SetStateForTest sets the interface state of a Monitor returned by
+// NewStatic to st and notifies the registered ChangeFuncs, as if the
+// network changed. It panics if m isn't static.
+func (m *Monitor) SetStateForTest(st *interfaces.State) {
+	if !m.static {
+		panic("SetStateForTest called on non-static Monitor")
+	}
+	m.handlePotentialChange(st, true)
+}
+
 // InterfaceState returns the latest snapshot of the machine's network
 // interfaces.
 //
@@ -173,6 +201,9 @@ func (m *Monitor) GatewayAndSelfIP() (gw, myIP netip.Addr, ok bool) {
 	if m.gwValid {
 		return m.gw, m.gwSelfIP, true
 	}
+	if m.static {
+		return netip.Addr{}, netip.Addr{}, false
+	}
 	gw, myIP, ok = interfaces.LikelyHomeRouterIP()
 	changed := false
 	if ok {
@@ -230,7 +261,7 @@ func (m *Monitor) Start() {
 	}
 	m.started = true
 
-	if shouldMonitorTimeJump {
+	if shouldMonitorTimeJump && !m.static {
 		m.wallTimer = time.AfterFunc(pollWallTimeInterval, m.pollWallTime)
 	}
 
@@ -391,7 +422,7 @@ func (m *Monitor) handlePotentialChange(newState *interfaces.State, forceCallbac
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	oldState := m.ifState
-	timeJumped := shouldMonitorTimeJump && m.checkWallTimeAdvanceLocked()
+	timeJumped := shouldMonitorTimeJump && !m.static && m.checkWallTimeAdvanceLocked()
 	if !timeJumped && !forceCallbacks && oldState.Equal(newState) {
 		// Exactly equal. Nothing to do.
 		metricChangeEq.Add(1)
@@ -59,6 +59,35 @@ func TestMonitorInjectEvent(t *testing.T) {
 	}
 }
 
+func TestStaticMonitor(t *testing.T) {
+	st := &interfaces.State{HaveV4: true, DefaultRouteInterface: "eth0"}
+	mon := NewStatic(st)
+	defer mon.Close()
+	if got := mon.InterfaceState(); got != st {
+		t.Fatalf("InterfaceState = %v; want %v", got, st)
+	}
+	if _, _, ok := mon.GatewayAndSelfIP(); ok {
+		t.Error("GatewayAndSelfIP succeeded on static monitor")
+	}
+
+	got := make(chan *ChangeDelta, 1)
+	mon.RegisterChangeCallback(func(d *ChangeDelta) { got <- d })
+	mon.Start()
+	st2 := &interfaces.State{HaveV4: true, DefaultRouteInterface: "wlan0"}
+	mon.SetStateForTest(st2)
+	select {
+	case d := <-got:
+		if d.Old != st || d.New != st2 || !d.Major {
+			t.Errorf("delta = %+v; want major change from %v to %v", d, st, st2)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for callback")
+	}
+	if got := mon.InterfaceState(); got != st2 {
+		t.Errorf("InterfaceState after SetStateForTest = %v; want %v", got, st2)
+	}
+}
+
 var (
 	monitor         = flag.String("monitor", "", `go into monitor mode like 'route monitor'; test never terminates. Value can be either "raw" or "callback"`)
 	monitorDuration = flag.Duration("monitor-duration", 0, "if non-zero, how long to run TestMonitorMode. Zero means forever.")
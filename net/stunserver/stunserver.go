@@ -9,14 +9,18 @@
 	"context"
 	"errors"
 	"expvar"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/netip"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"tailscale.com/metrics"
 	"tailscale.com/net/stun"
+	"tailscale.com/util/mak"
 )
 
 var (
@@ -30,17 +34,42 @@
 
 	stunIPv4 = stunAddrFamily.Get("ipv4")
 	stunIPv6 = stunAddrFamily.Get("ipv6")
+
+	// The dispositions of binding requests by address family, in a single
+	// variable with both labels.
+	stunRequestsByFamily = &metrics.MultiLabelMap[stunRequestLabels]{Type: "counter"}
+
+	// The same per family, under the names that existing dashboards and
+	// alerts use. TODO: remove once they've moved to stunRequestsByFamily.
+	stunDispositionIPv4 = &metrics.LabelMap{Label: "disposition"}
+	stunDispositionIPv6 = &metrics.LabelMap{Label: "disposition"}
 )
 
+// stunRequestLabels are the labels of stunRequestsByFamily.
+type stunRequestLabels struct {
+	Family      string // "ipv4" or "ipv6"
+	Disposition string // "success" or "write_error"
+}
+
 func init() {
 	stats.Set("counter_requests", stunDisposition)
 	stats.Set("counter_addrfamily", stunAddrFamily)
+	stats.Set("requests_by_family", stunRequestsByFamily)
+	stats.Set("counter_requests_ipv4", stunDispositionIPv4)
+	stats.Set("counter_requests_ipv6", stunDispositionIPv6)
 	expvar.Publish("stun", stats)
 }
 
 type STUNServer struct {
-	ctx context.Context // ctx signals service shutdown
-	pc  *net.UDPConn    // pc is the UDP listener
+	ctx     context.Context // ctx signals service shutdown
+	pc      *net.UDPConn    // pc is the UDP listener
+	network string          // network of pc: "udp", "udp4" or "udp6"
+
+	mu     sync.Mutex
+	probes map[stun.TxID]bool // transaction IDs of Probe requests in flight
+
+	served      atomic.Int64 // number of binding requests answered
+	lastSuccess atomic.Int64 // unix nanos of the last binding request answered
 }
 
 // New creates a new STUN server. The server is shutdown when ctx is done.
@@ -50,14 +79,22 @@ func New(ctx context.Context) *STUNServer {
 
 // Listen binds the listen socket for the server at listenAddr.
 func (s *STUNServer) Listen(listenAddr string) error {
-	uaddr, err := net.ResolveUDPAddr("udp", listenAddr)
+	return s.ListenNetwork("udp", listenAddr)
+}
+
+// ListenNetwork is like Listen, but binds the listen socket for the given
+// network, which is "udp", "udp4" or "udp6". The latter two restrict the
+// server to a single address family.
+func (s *STUNServer) ListenNetwork(network, listenAddr string) error {
+	uaddr, err := net.ResolveUDPAddr(network, listenAddr)
 	if err != nil {
 		return err
 	}
-	s.pc, err = net.ListenUDP("udp", uaddr)
+	s.pc, err = net.ListenUDP(network, uaddr)
 	if err != nil {
 		return err
 	}
+	s.network = network
 	log.Printf("STUN server listening on %v", s.LocalAddr())
 	// close the listener on shutdown in order to break out of the read loop
 	go func() {
@@ -96,18 +133,32 @@ func (s *STUNServer) Serve() error {
 			stunNotSTUN.Add(1)
 			continue
 		}
+		addr, _ := netip.AddrFromSlice(ua.IP)
+		res := stun.Response(txid, netip.AddrPortFrom(addr, uint16(ua.Port)))
+		if s.isProbe(txid) {
+			// Answer our own health probes without counting them as
+			// requests served.
+			s.pc.WriteTo(res, ua)
+			continue
+		}
+		family, familyDisposition := "ipv6", stunDispositionIPv6
 		if ua.IP.To4() != nil {
 			stunIPv4.Add(1)
+			family, familyDisposition = "ipv4", stunDispositionIPv4
 		} else {
 			stunIPv6.Add(1)
 		}
-		addr, _ := netip.AddrFromSlice(ua.IP)
-		res := stun.Response(txid, netip.AddrPortFrom(addr, uint16(ua.Port)))
 		_, err = s.pc.WriteTo(res, ua)
 		if err != nil {
 			stunWriteError.Add(1)
+			stunRequestsByFamily.Add(stunRequestLabels{family, "write_error"}, 1)
+			familyDisposition.Add("write_error", 1)
 		} else {
 			stunSuccess.Add(1)
+			stunRequestsByFamily.Add(stunRequestLabels{family, "success"}, 1)
+			familyDisposition.Add("success", 1)
+			s.served.Add(1)
+			s.lastSuccess.Store(time.Now().UnixNano())
 		}
 	}
 }
@@ -124,3 +175,106 @@ func (s *STUNServer) ListenAndServe(listenAddr string) error {
 func (s *STUNServer) LocalAddr() net.Addr {
 	return s.pc.LocalAddr()
 }
+
+// Served returns the number of binding requests that the server has
+// answered, and when it last did, if ever.
+func (s *STUNServer) Served() (n int64, last time.Time) {
+	n = s.served.Load()
+	if ns := s.lastSuccess.Load(); ns != 0 {
+		last = time.Unix(0, ns)
+	}
+	return n, last
+}
+
+// isProbe reports whether txid is that of a request sent by Probe.
+func (s *STUNServer) isProbe(txid stun.TxID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.probes[txid]
+}
+
+// probeAddrs returns the addresses to which Probe sends binding requests:
+// the listen address, or the loopback address of each address family the
+// server serves if it's listening on all addresses.
+func (s *STUNServer) probeAddrs() []netip.AddrPort {
+	local := s.LocalAddr().(*net.UDPAddr).AddrPort()
+	ip, port := local.Addr().Unmap(), local.Port()
+	if !ip.IsUnspecified() {
+		return []netip.AddrPort{netip.AddrPortFrom(ip, port)}
+	}
+	v4 := netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), port)
+	v6 := netip.AddrPortFrom(netip.IPv6Loopback(), port)
+	switch {
+	case ip.Is4() || s.network == "udp4":
+		return []netip.AddrPort{v4}
+	case s.network == "udp6":
+		return []netip.AddrPort{v6}
+	default:
+		// A dual-stack socket.
+		return []netip.AddrPort{v4, v6}
+	}
+}
+
+// Probe checks that the server answers binding requests by sending it one
+// from the same host, over loopback if the server is listening on all
+// addresses, in which case it probes each address family. Probes aren't
+// counted as requests served. It must not be called before Listen.
+func (s *STUNServer) Probe(ctx context.Context) error {
+	addrs := s.probeAddrs()
+	errs := make([]error, len(addrs))
+	var wg sync.WaitGroup
+	for i, ap := range addrs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.probe(ctx, ap); err != nil {
+				errs[i] = fmt.Errorf("probe %v: %w", ap, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// probe sends a binding request to the server at ap and waits for the
+// response.
+func (s *STUNServer) probe(ctx context.Context, ap netip.AddrPort) error {
+	c, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(ap))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if d, ok := ctx.Deadline(); ok {
+		c.SetDeadline(d)
+	} else {
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+	}
+
+	txid := stun.NewTxID()
+	s.mu.Lock()
+	mak.Set(&s.probes, txid, true)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.probes, txid)
+		s.mu.Unlock()
+	}()
+
+	if _, err := c.Write(stun.Request(txid)); err != nil {
+		return err
+	}
+	var buf [1500]byte
+	for {
+		n, err := c.Read(buf[:])
+		if err != nil {
+			return err
+		}
+		tid, _, err := stun.ParseResponse(buf[:n])
+		if err != nil {
+			return fmt.Errorf("invalid STUN response: %w", err)
+		}
+		if tid == txid {
+			return nil
+		}
+	}
+}
@@ -58,6 +58,75 @@ func TestSTUNServer(t *testing.T) {
 	}
 }
 
+func TestRequestsByFamily(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx)
+	must.Do(s.ListenNetwork("udp4", "127.0.0.1:0"))
+	go s.Serve()
+
+	successes := stunRequestsByFamily.Get(stunRequestLabels{"ipv4", "success"})
+	before, beforeOld := successes.Value(), stunDispositionIPv4.Get("success").Value()
+
+	c := must.Get(net.DialUDP("udp", nil, s.LocalAddr().(*net.UDPAddr)))
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	must.Get(c.Write(stun.Request(stun.NewTxID())))
+	var buf [1500]byte
+	must.Get(c.Read(buf[:]))
+
+	if got := successes.Value() - before; got != 1 {
+		t.Errorf("requests_by_family successes = %d; want 1", got)
+	}
+	if got := stunDispositionIPv4.Get("success").Value() - beforeOld; got != 1 {
+		t.Errorf("counter_requests_ipv4 successes = %d; want 1", got)
+	}
+	if n, last := s.Served(); n != 1 || last.IsZero() {
+		t.Errorf("Served() = %d, %v; want 1 and non-zero", n, last)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tests := []struct {
+		network, addr string
+		wantProbes    int // addresses probed
+	}{
+		{"udp4", "0.0.0.0:0", 1},
+		{"udp4", "127.0.0.1:0", 1},
+		{"udp6", "[::1]:0", 1},
+		{"udp", "[::]:0", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.network+"/"+tt.addr, func(t *testing.T) {
+			s := New(ctx)
+			if err := s.ListenNetwork(tt.network, tt.addr); err != nil {
+				if tt.network != "udp4" {
+					t.Skipf("no IPv6: %v", err)
+				}
+				t.Fatal(err)
+			}
+			go s.Serve()
+
+			if got := len(s.probeAddrs()); got != tt.wantProbes {
+				t.Errorf("probing %v; want %d addresses", s.probeAddrs(), tt.wantProbes)
+			}
+			before := stunSuccess.Value()
+			if err := s.Probe(ctx); err != nil {
+				t.Fatalf("Probe: %v", err)
+			}
+			if got := stunSuccess.Value() - before; got != 0 {
+				t.Errorf("probes counted as %d successes; want 0", got)
+			}
+			if n, last := s.Served(); n != 0 || !last.IsZero() {
+				t.Errorf("Served() = %d, %v after probing; want 0 and zero", n, last)
+			}
+		})
+	}
+}
+
 func BenchmarkServerSTUN(b *testing.B) {
 	b.ReportAllocs()
 	ctx, cancel := context.WithCancel(context.Background())
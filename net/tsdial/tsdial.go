@@ -324,6 +324,12 @@ func (d *Dialer) SystemDial(ctx context.Context, network, addr string) (net.Conn
 	}, nil
 }
 
+// UserDialResolve resolves addr as UserDial does, without connecting to
+// it.
+func (d *Dialer) UserDialResolve(ctx context.Context, network, addr string) (netip.AddrPort, error) {
+	return d.userDialResolve(ctx, network, addr)
+}
+
 // UserDial connects to the provided network address as if a user were
 // initiating the dial. (e.g. from a SOCKS or HTTP outbound proxy)
 func (d *Dialer) UserDial(ctx context.Context, network, addr string) (net.Conn, error) {
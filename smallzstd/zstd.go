@@ -76,3 +76,29 @@ func NewEncoder(w io.Writer, options ...zstd.EOption) (*zstd.Encoder, error) {
 
 	return zstd.NewWriter(w, append(defaults, options...)...)
 }
+
+// BuildDict returns a zstd dictionary with the given ID for compressing data
+// like samples with encoders from NewEncoder. Its content is taken from the
+// end of samples and is at most WindowSize bytes long, as matches further
+// back than the window can't be used.
+func BuildDict(id uint32, samples [][]byte) ([]byte, error) {
+	var hist []byte
+	for _, s := range samples {
+		hist = append(hist, s...)
+	}
+	if len(hist) > WindowSize {
+		hist = hist[len(hist)-WindowSize:]
+	}
+	return zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       id,
+		Contents: samples,
+		History:  hist,
+		Offsets:  [3]int{1, 4, 8}, // the zstd defaults
+	})
+}
+
+// NewDictEncoder returns a zstd.Encoder like NewEncoder that compresses with
+// dict, such as one from BuildDict.
+func NewDictEncoder(dict []byte, options ...zstd.EOption) (*zstd.Encoder, error) {
+	return NewEncoder(nil, append([]zstd.EOption{zstd.WithEncoderDict(dict)}, options...)...)
+}
@@ -128,7 +128,9 @@
 //   - 85: 2024-01-05: Client understands MaxKeyDuration
 //   - 86: 2024-01-23: Client understands NodeAttrProbeUDPLifetime
 //   - 87: 2024-02-11: UserProfile.Groups removed (added in 66)
-const CurrentCapabilityVersion CapabilityVersion = 87
+//   - 88: 2024-02-20: Client understands NodeAttrPQHybridKeyExchange
+//   - 89: 2024-03-04: Client understands DNSConfig.FailoverRoutes
+const CurrentCapabilityVersion CapabilityVersion = 89
 
 type StableID string
 
@@ -1340,6 +1342,9 @@ type CapGrant struct {
 	PeerCapabilityWebUI PeerCapability = "tailscale.com/cap/webui"
 	// PeerCapabilityTailFS grants the ability for a peer to access tailfs shares.
 	PeerCapabilityTailFS PeerCapability = "tailscale.com/cap/tailfs"
+	// PeerCapabilityBench grants the ability for a peer to measure the
+	// throughput of its path to this node, with "tailscale ping --bench".
+	PeerCapabilityBench PeerCapability = "tailscale.com/cap/bench"
 )
 
 // NodeCapMap is a map of capabilities to their optional values. It is valid for
@@ -1508,6 +1513,15 @@ type DNSConfig struct {
 	// doesn't work yet without explicit default resolvers.
 	// https://github.com/tailscale/tailscale/issues/1743
 	FallbackResolvers []*dnstype.Resolver `json:",omitempty"`
+
+	// FailoverRoutes are keys of Routes whose resolvers are listed in
+	// order of preference. Rather than querying all of them at once, clients
+	// use the first healthy one, fail over to the next when it stops
+	// answering, and go back to it once health checks see it answer again.
+	//
+	// It's only sent to clients with CapabilityVersion 89 or later.
+	FailoverRoutes []string `json:",omitempty"`
+
 	// Domains are the search domains to use.
 	// Search domains must be FQDNs, but *without* the trailing dot.
 	Domains []string `json:",omitempty"`
@@ -2209,6 +2223,13 @@ type Oauth2Token struct {
 
 	// NodeAttrsTailFSAccess enables accessing shares via TailFS.
 	NodeAttrsTailFSAccess NodeCapability = "tailfs:access"
+
+	// NodeAttrPQHybridKeyExchange enables the experimental post-quantum
+	// hybrid (X25519+Kyber768) key exchange in magicsock, used to derive
+	// WireGuard preshared keys. It only takes effect between two peers if
+	// it's set both on the self node and in the peer's CapMap, so control
+	// can select which links use it.
+	NodeAttrPQHybridKeyExchange NodeCapability = "pq-hybrid-kex"
 )
 
 // SetDNSRequest is a request to add a DNS record.
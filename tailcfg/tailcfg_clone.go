@@ -258,6 +258,7 @@ func (src *DNSConfig) Clone() *DNSConfig {
 			dst.FallbackResolvers[i] = src.FallbackResolvers[i].Clone()
 		}
 	}
+	dst.FailoverRoutes = append(src.FailoverRoutes[:0:0], src.FailoverRoutes...)
 	dst.Domains = append(src.Domains[:0:0], src.Domains...)
 	dst.Nameservers = append(src.Nameservers[:0:0], src.Nameservers...)
 	dst.CertDomains = append(src.CertDomains[:0:0], src.CertDomains...)
@@ -271,6 +272,7 @@ func (src *DNSConfig) Clone() *DNSConfig {
 	Resolvers           []*dnstype.Resolver
 	Routes              map[string][]*dnstype.Resolver
 	FallbackResolvers   []*dnstype.Resolver
+	FailoverRoutes      []string
 	Domains             []string
 	Proxied             bool
 	Nameservers         []netip.Addr
@@ -566,6 +566,9 @@ func (v DNSConfigView) Routes() views.MapFn[string, []*dnstype.Resolver, views.S
 func (v DNSConfigView) FallbackResolvers() views.SliceView[*dnstype.Resolver, dnstype.ResolverView] {
 	return views.SliceOfViews[*dnstype.Resolver, dnstype.ResolverView](v.ж.FallbackResolvers)
 }
+func (v DNSConfigView) FailoverRoutes() views.Slice[string] {
+	return views.SliceOf(v.ж.FailoverRoutes)
+}
 func (v DNSConfigView) Domains() views.Slice[string]         { return views.SliceOf(v.ж.Domains) }
 func (v DNSConfigView) Proxied() bool                        { return v.ж.Proxied }
 func (v DNSConfigView) Nameservers() views.Slice[netip.Addr] { return views.SliceOf(v.ж.Nameservers) }
@@ -581,6 +584,7 @@ func (v DNSConfigView) TempCorpIssue13969() string { return v.ж.TempCorpIssue13
 	Resolvers           []*dnstype.Resolver
 	Routes              map[string][]*dnstype.Resolver
 	FallbackResolvers   []*dnstype.Resolver
+	FailoverRoutes      []string
 	Domains             []string
 	Proxied             bool
 	Nameservers         []netip.Addr
@@ -0,0 +1,34 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tailfs
+
+import "time"
+
+// AuditEntry records an operation that a remote node performed, or tried to
+// perform, on this node's shares.
+type AuditEntry struct {
+	// Time is when the operation finished.
+	Time time.Time `json:"time"`
+
+	// NodeID, Node and User identify the remote node and the user owning
+	// it. Node and User are empty if unknown.
+	NodeID string `json:"nodeID"`
+	Node   string `json:"node,omitempty"`
+	User   string `json:"user,omitempty"`
+
+	// Op is the WebDAV method of the operation, like "GET" or "PUT".
+	Op string `json:"op"`
+
+	// Path is the path of the file or directory operated on, starting with
+	// the share name, like "/docs/notes.txt". Destination is the path that
+	// it was moved or copied to, if any.
+	Path        string `json:"path"`
+	Destination string `json:"destination,omitempty"`
+
+	// Bytes is how many bytes of the file were read or written.
+	Bytes int64 `json:"bytes"`
+
+	// Status is the HTTP status code of the response, like 200 or 403.
+	Status int `json:"status"`
+}
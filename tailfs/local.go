@@ -10,8 +10,13 @@
 package tailfs
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"net"
 	"net/http"
+	"strings"
+	"time"
 )
 
 // Remote represents a remote TailFS node.
@@ -19,12 +24,51 @@ type Remote struct {
 	Name      string
 	URL       string
 	Available func() bool
+
+	// Permissions, if non-nil, returns this node's access level to each of
+	// the remote's shares, such as by calling FetchPermissions. Shares to
+	// which it only has read-only access are mounted read-only locally, so
+	// that attempts to modify them fail without asking the remote, which
+	// enforces the access levels either way.
+	Permissions func(context.Context) (Permissions, error)
+}
+
+// FetchPermissions gets this node's access level to each of the shares of the
+// remote whose WebDAV server is at url, from its PermissionsPath, using
+// transport.
+func FetchPermissions(ctx context.Context, transport http.RoundTripper, url string) (Permissions, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(url, "/")+PermissionsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching permissions: %s", res.Status)
+	}
+	var p Permissions
+	if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+		return nil, fmt.Errorf("fetching permissions: %w", err)
+	}
+	return p, nil
 }
 
 // FileSystemForLocal is the TailFS filesystem exposed to local clients. It
-// provides a unified WebDAV interface to remote TailFS shares on other nodes.
+// provides a unified interface to remote TailFS shares on other nodes. Package
+// tailfsimpl has implementations that serve it over WebDAV, and over a plain
+// HTTP API for virtual filesystem providers, such as one built on the Windows
+// Cloud Files API, as Windows' WebDAV redirector limits file sizes and
+// performs poorly.
+//
+// TODO: add an SMB implementation. None is available among our dependencies,
+// and writing one requires at least SMB 2.1 with NTLMv2 authentication and
+// message signing for current versions of Windows to connect to it.
 type FileSystemForLocal interface {
-	// HandleConn handles connections from local WebDAV clients
+	// HandleConn handles connections from local clients, using whichever
+	// protocol the implementation serves.
 	HandleConn(conn net.Conn, remoteAddr net.Addr) error
 
 	// SetRemotes sets the complete set of remotes on the given tailnet domain
@@ -32,6 +76,45 @@ type FileSystemForLocal interface {
 	// will be used to connect to these remotes.
 	SetRemotes(domain string, remotes []*Remote, transport http.RoundTripper)
 
-	// Close() stops serving the WebDAV content
+	// Transfers returns the files being read or written by local clients,
+	// sorted by ID.
+	Transfers() []*Transfer
+
+	// CancelTransfer stops the transfer with the given ID, failing it for
+	// the client. It reports whether there was such a transfer.
+	CancelTransfer(id int64) bool
+
+	// WatchChanges registers fn to be called with the path of each file or
+	// directory that changes on a remote share, as seen by local clients,
+	// like "/example.ts.net/host/share/file.txt". The returned func
+	// unregisters fn.
+	WatchChanges(fn func(name string)) (unregister func())
+
+	// Close() stops serving the content
 	Close() error
 }
+
+// Transfer describes a file being read or written on a remote TailFS share by
+// a local client.
+type Transfer struct {
+	// ID identifies the transfer while it's active.
+	ID int64 `json:"id"`
+
+	// Path is the path of the file as seen by local clients, like
+	// "/example.ts.net/host/share/file.txt".
+	Path string `json:"path"`
+
+	// Upload is whether the file is being written, as opposed to read.
+	Upload bool `json:"upload,omitempty"`
+
+	// Started is when the transfer started.
+	Started time.Time `json:"started"`
+
+	// Bytes is how many bytes have been transferred so far, and Size is
+	// the total, or -1 if it isn't known.
+	Bytes int64 `json:"bytes"`
+	Size  int64 `json:"size"`
+
+	// BytesPerSecond is the average rate of the transfer so far.
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+}
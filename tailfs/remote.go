@@ -4,7 +4,9 @@
 package tailfs
 
 import (
+	"context"
 	"net/http"
+	"time"
 )
 
 var (
@@ -31,6 +33,42 @@ type Share struct {
 	// Can be left blank to use the default value of "whoever is running the
 	// Tailscale GUI".
 	As string `json:"who"`
+
+	// Access, if non-empty, limits which peers can access this share and
+	// how. A peer matching none of the entries has no access, and a peer
+	// matching several gets the most permissive of them. Access can only
+	// restrict what peers have been granted with the
+	// tailscale.com/cap/tailfs capability, never extend it.
+	Access []*ShareAccess `json:"access,omitempty"`
+
+	// Quota, if positive, is the most bytes that the files in this share
+	// may take up. Writes by remote nodes that would exceed it fail.
+	Quota int64 `json:"quota,omitempty"`
+
+	// DownloadLimit and UploadLimit, if positive, limit how many bytes per
+	// second of files remote nodes may read from and write to this share,
+	// respectively. Each limit is shared by all remote nodes.
+	DownloadLimit int64 `json:"downloadLimit,omitempty"`
+	UploadLimit   int64 `json:"uploadLimit,omitempty"`
+}
+
+// ShareUsage describes how much a share is being used by remote nodes.
+type ShareUsage struct {
+	// Name is the name of the share.
+	Name string `json:"name"`
+
+	// Quota is the share's Quota, or zero if it has none.
+	Quota int64 `json:"quota,omitempty"`
+
+	// StoredBytes is the total size of the files in the share, or -1 if
+	// it couldn't be determined.
+	StoredBytes int64 `json:"storedBytes"`
+
+	// BytesServed and BytesWritten are how many bytes of files remote
+	// nodes have read from and written to the share since it was shared
+	// or tailscaled started, whichever was later.
+	BytesServed  int64 `json:"bytesServed"`
+	BytesWritten int64 `json:"bytesWritten"`
 }
 
 // FileSystemForRemote is the TailFS filesystem exposed to remote nodes. It
@@ -51,9 +89,18 @@ type FileSystemForRemote interface {
 	SetShares(shares map[string]*Share)
 
 	// ServeHTTPWithPerms behaves like the similar method from http.Handler but
-	// also accepts a Permissions map that captures the permissions of the
-	// connecting node.
-	ServeHTTPWithPerms(permissions Permissions, w http.ResponseWriter, r *http.Request)
+	// also accepts the connecting node and a Permissions map that captures
+	// the permissions granted to it, which are further limited by each
+	// Share's Access.
+	ServeHTTPWithPerms(principal Principal, permissions Permissions, w http.ResponseWriter, r *http.Request)
+
+	// Usage reports how much each share is being used, sorted by name.
+	Usage(ctx context.Context) []*ShareUsage
+
+	// AuditLog returns the recorded operations of remote nodes on shares
+	// that finished after since, oldest first. Only a limited number of
+	// the most recent operations are kept.
+	AuditLog(since time.Time) []*AuditEntry
 
 	// Close() stops serving the WebDAV content
 	Close() error
@@ -5,7 +5,10 @@
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 )
 
 type Permission uint8
@@ -23,6 +26,37 @@
 	wildcardShare = "*"
 )
 
+// PermissionsPath is the path on a remote's WebDAV server from which a node
+// gets its access level to each of the remote's shares, as Permissions in
+// JSON, like {"docs": "ro", "photos": "rw"}. Shares to which it has no access
+// aren't included. See FetchPermissions.
+const PermissionsPath = "/.tailfs-permissions"
+
+// MarshalText implements encoding.TextMarshaler, encoding p as "ro" or "rw",
+// like the access of a grant.
+func (p Permission) MarshalText() ([]byte, error) {
+	switch p {
+	case PermissionReadOnly:
+		return []byte(accessReadOnly), nil
+	case PermissionReadWrite:
+		return []byte(accessReadWrite), nil
+	}
+	return nil, fmt.Errorf("can't marshal permission %d", p)
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (p *Permission) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case accessReadOnly:
+		*p = PermissionReadOnly
+	case accessReadWrite:
+		*p = PermissionReadWrite
+	default:
+		return fmt.Errorf("invalid permission %q, want %q or %q", b, accessReadOnly, accessReadWrite)
+	}
+	return nil
+}
+
 // Permissions represents the set of permissions for a given principal to a
 // set of shares.
 type Permissions map[string]Permission
@@ -63,3 +97,110 @@ func (p Permissions) For(share string) Permission {
 	}
 	return wildcard
 }
+
+// Principal identifies a remote node accessing shares.
+type Principal struct {
+	// NodeID is the node's stable node ID.
+	NodeID string
+	// Tags are the node's ACL tags, like "tag:server".
+	Tags []string
+	// Name and User are the node's name and the login name of its owner,
+	// if known. They're only used for display, such as in audit entries.
+	Name string
+	User string
+}
+
+// ShareAccess is an entry in Share.Access, setting the access level of some
+// peers to the share.
+type ShareAccess struct {
+	// Nodes are the stable node IDs of the peers to which this applies.
+	Nodes []string `json:"nodes,omitempty"`
+	// Tags are the ACL tags of the peers to which this applies.
+	Tags []string `json:"tags,omitempty"`
+	// Access is "ro" for read-only or "rw" for read-write access.
+	Access string `json:"access"`
+}
+
+func (sa *ShareAccess) permission() (Permission, error) {
+	switch sa.Access {
+	case accessReadOnly:
+		return PermissionReadOnly, nil
+	case accessReadWrite:
+		return PermissionReadWrite, nil
+	}
+	return PermissionNone, fmt.Errorf("invalid access %q, want %q or %q", sa.Access, accessReadOnly, accessReadWrite)
+}
+
+func (sa *ShareAccess) matches(p Principal) bool {
+	if slices.Contains(sa.Nodes, p.NodeID) {
+		return true
+	}
+	for _, tag := range sa.Tags {
+		if slices.Contains(p.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateAccess reports an error if any of s.Access is invalid.
+func (s *Share) ValidateAccess() error {
+	for _, sa := range s.Access {
+		if _, err := sa.permission(); err != nil {
+			return err
+		}
+		if len(sa.Nodes) == 0 && len(sa.Tags) == 0 {
+			return errors.New("access entry applies to no nodes or tags")
+		}
+		for _, tag := range sa.Tags {
+			if !strings.HasPrefix(tag, "tag:") {
+				return fmt.Errorf("invalid tag %q, must start with \"tag:\"", tag)
+			}
+		}
+	}
+	return nil
+}
+
+// MaxPermission returns the most permissive access that s.Access allows p.
+func (s *Share) MaxPermission(p Principal) Permission {
+	if len(s.Access) == 0 {
+		return PermissionReadWrite
+	}
+	best := PermissionNone
+	for _, sa := range s.Access {
+		if perm, err := sa.permission(); err == nil && perm > best && sa.matches(p) {
+			best = perm
+		}
+	}
+	return best
+}
+
+// Restrict returns the permissions p has to each of the given shares after
+// limiting them by each share's Access for the given principal. Shares not
+// in shares, including the wildcard share, aren't included.
+func (p Permissions) Restrict(shares map[string]*Share, principal Principal) Permissions {
+	res := make(Permissions, len(shares))
+	for name, share := range shares {
+		perm := min(p.For(name), share.MaxPermission(principal))
+		if perm != PermissionNone {
+			res[name] = perm
+		}
+	}
+	return res
+}
+
+// AccessString describes s.Access for display, like
+// "rw: tag:laptop; ro: n1234, tag:tv". It returns the empty string if s
+// doesn't limit which peers can access it.
+func (s *Share) AccessString() string {
+	var b strings.Builder
+	for i, sa := range s.Access {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(sa.Access)
+		b.WriteString(": ")
+		b.WriteString(strings.Join(append(slices.Clip(sa.Nodes), sa.Tags...), ", "))
+	}
+	return b.String()
+}
@@ -5,6 +5,7 @@
 
 import (
 	"encoding/json"
+	"maps"
 	"testing"
 )
 
@@ -59,3 +60,100 @@ func TestPermissions(t *testing.T) {
 		})
 	}
 }
+
+func TestShareAccess(t *testing.T) {
+	shares := map[string]*Share{
+		"open": {Name: "open"},
+		"limited": {Name: "limited", Access: []*ShareAccess{
+			{Tags: []string{"tag:ro"}, Access: "ro"},
+			{Nodes: []string{"nrw"}, Tags: []string{"tag:rw"}, Access: "rw"},
+		}},
+	}
+	rw := Permissions{wildcardShare: PermissionReadWrite}
+	ro := Permissions{wildcardShare: PermissionReadOnly}
+
+	tests := []struct {
+		name      string
+		perms     Permissions
+		principal Principal
+		want      Permissions
+	}{
+		{
+			name:      "unmatched",
+			perms:     rw,
+			principal: Principal{NodeID: "nother"},
+			want:      Permissions{"open": PermissionReadWrite},
+		},
+		{
+			name:      "tag_ro",
+			perms:     rw,
+			principal: Principal{NodeID: "nother", Tags: []string{"tag:ro"}},
+			want:      Permissions{"open": PermissionReadWrite, "limited": PermissionReadOnly},
+		},
+		{
+			name:      "most_permissive",
+			perms:     rw,
+			principal: Principal{NodeID: "nrw", Tags: []string{"tag:ro"}},
+			want:      Permissions{"open": PermissionReadWrite, "limited": PermissionReadWrite},
+		},
+		{
+			name:      "grant_still_limits",
+			perms:     ro,
+			principal: Principal{NodeID: "nrw"},
+			want:      Permissions{"open": PermissionReadOnly, "limited": PermissionReadOnly},
+		},
+		{
+			name:      "no_grant",
+			perms:     Permissions{"open": PermissionReadOnly},
+			principal: Principal{NodeID: "nrw"},
+			want:      Permissions{"open": PermissionReadOnly},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.perms.Restrict(shares, tt.principal)
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShareValidateAccess(t *testing.T) {
+	for _, sa := range []*ShareAccess{
+		{Nodes: []string{"n1"}, Access: "rx"},
+		{Access: "ro"},
+		{Tags: []string{"server"}, Access: "rw"},
+	} {
+		s := &Share{Name: "s", Access: []*ShareAccess{sa}}
+		if err := s.ValidateAccess(); err == nil {
+			t.Errorf("ValidateAccess(%+v) succeeded; want error", sa)
+		}
+	}
+	s := &Share{Name: "s", Access: []*ShareAccess{{Tags: []string{"tag:server"}, Access: "rw"}}}
+	if err := s.ValidateAccess(); err != nil {
+		t.Errorf("ValidateAccess: %v", err)
+	}
+}
+
+func TestShareAccessString(t *testing.T) {
+	tests := []struct {
+		access []*ShareAccess
+		want   string
+	}{
+		{nil, ""},
+		{[]*ShareAccess{
+			{Tags: []string{"tag:laptop"}, Access: "rw"},
+		}, "rw: tag:laptop"},
+		{[]*ShareAccess{
+			{Tags: []string{"tag:laptop"}, Access: "rw"},
+			{Nodes: []string{"n1234"}, Tags: []string{"tag:tv"}, Access: "ro"},
+		}, "rw: tag:laptop; ro: n1234, tag:tv"},
+	}
+	for _, tt := range tests {
+		s := &Share{Name: "docs", Access: tt.access}
+		if got := s.AccessString(); got != tt.want {
+			t.Errorf("AccessString() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tailfsimpl
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/tailfs"
+)
+
+// maxAuditEntries is the number of audit entries that an auditLog keeps.
+const maxAuditEntries = 1000
+
+// auditMethods are the methods of the operations recorded in the audit log.
+// Metadata operations like PROPFIND are left out, as clients make many of
+// them just browsing.
+var auditMethods = map[string]bool{
+	"GET":       true,
+	"PUT":       true,
+	"POST":      true,
+	"COPY":      true,
+	"MKCOL":     true,
+	"MOVE":      true,
+	"PROPPATCH": true,
+	"DELETE":    true,
+}
+
+// auditLog keeps the most recent audit entries.
+type auditLog struct {
+	// mu guards the below values.
+	mu      sync.Mutex
+	entries []*tailfs.AuditEntry // oldest first
+}
+
+// add adds e to the log, forgetting the oldest entry if it's full.
+func (l *auditLog) add(e *tailfs.AuditEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) == maxAuditEntries {
+		l.entries = append(l.entries[:0], l.entries[1:]...)
+	}
+	l.entries = append(l.entries, e)
+}
+
+// since returns the entries for operations that finished after t.
+func (l *auditLog) since(t time.Time) []*tailfs.AuditEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var result []*tailfs.AuditEntry
+	for _, e := range l.entries {
+		if e.Time.After(t) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// AuditLog implements tailfs.FileSystemForRemote.
+func (s *FileSystemForRemote) AuditLog(since time.Time) []*tailfs.AuditEntry {
+	return s.audit.since(since)
+}
+
+// auditRecorder is an http.ResponseWriter that records the status code and
+// body size of a response, along with the size of its request body, for an
+// audit entry.
+type auditRecorder struct {
+	http.ResponseWriter
+	status   int
+	written  int64
+	received *countingReader
+}
+
+func (ar *auditRecorder) WriteHeader(status int) {
+	if ar.status == 0 {
+		ar.status = status
+	}
+	ar.ResponseWriter.WriteHeader(status)
+}
+
+func (ar *auditRecorder) Write(b []byte) (int, error) {
+	if ar.status == 0 {
+		ar.status = http.StatusOK
+	}
+	n, err := ar.ResponseWriter.Write(b)
+	ar.written += int64(n)
+	return n, err
+}
+
+// startAudit returns an auditRecorder to serve r with if its operation is to
+// be audited, or nil otherwise. It replaces r.Body to count its size.
+func startAudit(w http.ResponseWriter, r *http.Request) *auditRecorder {
+	if !auditMethods[r.Method] {
+		return nil
+	}
+	ar := &auditRecorder{ResponseWriter: w}
+	if r.Body != nil {
+		ar.received = &countingReader{ReadCloser: r.Body}
+		r.Body = ar.received
+	}
+	return ar
+}
+
+// finishAudit records the operation of r, served with ar, in the audit log
+// and the logs.
+func (s *FileSystemForRemote) finishAudit(principal tailfs.Principal, r *http.Request, ar *auditRecorder) {
+	e := &tailfs.AuditEntry{
+		Time:   time.Now(),
+		NodeID: principal.NodeID,
+		Node:   principal.Name,
+		User:   principal.User,
+		Op:     r.Method,
+		Path:   path.Clean("/" + r.URL.Path),
+		Status: ar.status,
+	}
+	if e.Status == 0 {
+		e.Status = http.StatusOK
+	}
+	switch {
+	case r.Method == "GET":
+		if e.Status < http.StatusBadRequest {
+			e.Bytes = ar.written
+		}
+	case ar.received != nil:
+		e.Bytes = ar.received.n
+	}
+	if r.Method == "MOVE" || r.Method == "COPY" {
+		e.Destination = destinationPath(r)
+	}
+	s.audit.add(e)
+	s.logf.JSON(1, "tailfs_audit", e)
+}
+
+// destinationPath returns the path in the Destination header of the MOVE or
+// COPY request r, relative to the TailFS root like r.URL.Path.
+func destinationPath(r *http.Request) string {
+	dst := r.Header.Get("Destination")
+	if u, err := url.Parse(dst); err == nil {
+		dst = u.Path
+	}
+	// The destination includes any prefix stripped from r.URL.Path
+	// before it got to us.
+	if full := r.RequestURI; full != "" {
+		if u, err := url.ParseRequestURI(full); err == nil {
+			if prefix, ok := strings.CutSuffix(u.Path, r.URL.Path); ok {
+				dst = strings.TrimPrefix(dst, prefix)
+			}
+		}
+	}
+	return path.Clean("/" + dst)
+}
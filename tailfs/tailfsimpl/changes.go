@@ -0,0 +1,325 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tailfsimpl
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tailscale/xnet/webdav"
+	"tailscale.com/tailfs"
+	"tailscale.com/tailfs/tailfsimpl/shared"
+)
+
+const (
+	// maxRecentChanges is the number of changes that a changeLog remembers.
+	maxRecentChanges = 256
+
+	// changesPollTimeout is how long a request for changes waits for any
+	// before returning none.
+	changesPollTimeout = 30 * time.Second
+
+	// changesIdleTimeout is how long after the last request for changes the
+	// shares stop being scanned for changes.
+	changesIdleTimeout = time.Minute
+
+	// shareScanTimeout bounds each scan of a share for changes.
+	shareScanTimeout = time.Minute
+
+	// maxScannedEntries bounds the number of files and directories scanned
+	// in each share. In larger shares, only changes to the files that were
+	// already scanned are detected.
+	maxScannedEntries = 10000
+
+	// maxScanChanges is the most changes to a share that a scan reports
+	// individually. If there are more, the share itself is reported as
+	// changed.
+	maxScanChanges = 64
+)
+
+// sharePollInterval is how often shares are scanned for changes made other
+// than through TailFS, such as by programs on this machine, while anyone is
+// watching for changes. It's a var so that tests can shorten it.
+var sharePollInterval = 10 * time.Second
+
+// changeLog records recent changes made to shares, either through TailFS or
+// found by scanning them.
+type changeLog struct {
+	// mu guards the below values.
+	mu       sync.Mutex
+	seq      uint64
+	recent   []shared.Change // oldest first
+	wake     chan struct{}   // closed when a change is added
+	watchers int             // number of requests for changes in progress
+	lastWait time.Time       // when the last request for changes finished
+}
+
+func newChangeLog() *changeLog {
+	return &changeLog{wake: make(chan struct{})}
+}
+
+// add records a change to the file or directory at p.
+func (c *changeLog) add(p string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	if len(c.recent) == maxRecentChanges {
+		c.recent = append(c.recent[:0], c.recent[1:]...)
+	}
+	c.recent = append(c.recent, shared.Change{Seq: c.seq, Path: p})
+	close(c.wake)
+	c.wake = make(chan struct{})
+}
+
+// since returns the changes after seq, and a channel that's closed when there
+// are more.
+func (c *changeLog) since(seq uint64) (shared.Changes, <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := shared.Changes{Seq: c.seq}
+	switch {
+	case seq > c.seq:
+		// from before a restart
+		res.Reset = true
+	case len(c.recent) > 0 && seq+1 < c.recent[0].Seq:
+		// some were forgotten
+		res.Reset = true
+	default:
+		for _, ch := range c.recent {
+			if ch.Seq > seq {
+				res.Changes = append(res.Changes, ch)
+			}
+		}
+	}
+	return res, c.wake
+}
+
+// startWatch records that a request for changes started. The returned func
+// records that it finished.
+func (c *changeLog) startWatch() (done func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.watchers++
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.watchers--
+		c.lastWait = time.Now()
+	}
+}
+
+// watched reports whether anyone requested changes recently.
+func (c *changeLog) watched() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.watchers > 0 || (!c.lastWait.IsZero() && time.Since(c.lastWait) < changesIdleTimeout)
+}
+
+// serveChanges serves long-polling requests for changes to the shares to which
+// the connecting principal has access. See shared.ChangesPath.
+func (s *FileSystemForRemote) serveChanges(permissions tailfs.Permissions, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sinceStr := r.URL.Query().Get("since")
+	since, err := strconv.ParseUint(sinceStr, 10, 64)
+	if sinceStr != "" && err != nil {
+		http.Error(w, "invalid since", http.StatusBadRequest)
+		return
+	}
+	s.pollOnce.Do(func() { go s.pollShares() })
+	defer s.changes.startWatch()()
+
+	timer := time.NewTimer(changesPollTimeout)
+	defer timer.Stop()
+	for {
+		changes, wake := s.changes.since(since)
+		if sinceStr == "" {
+			// just return the current sequence number
+			changes.Changes = nil
+			writeChanges(w, changes)
+			return
+		}
+
+		// filter out changes to shares to which the principal has no access
+		visible := changes.Changes[:0]
+		for _, ch := range changes.Changes {
+			if permissions.For(shared.CleanAndSplit(ch.Path)[0]) != tailfs.PermissionNone {
+				visible = append(visible, ch)
+			}
+		}
+		changes.Changes = visible
+		if len(changes.Changes) > 0 || changes.Reset {
+			writeChanges(w, changes)
+			return
+		}
+
+		since = changes.Seq
+		select {
+		case <-wake:
+		case <-timer.C:
+			writeChanges(w, changes)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// pollShares scans the shares for changes every sharePollInterval while
+// anyone is watching for changes, until s is closed. This catches changes
+// made directly on this machine, which don't go through TailFS.
+func (s *FileSystemForRemote) pollShares() {
+	scans := make(map[string]*shareScan) // by share name
+	t := time.NewTicker(sharePollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.pollCtx.Done():
+			return
+		case <-t.C:
+		}
+		if !s.changes.watched() {
+			// Watchers resync when they come back, so there's no
+			// need to report what changed in the meantime.
+			clear(scans)
+			continue
+		}
+
+		s.mu.RLock()
+		fileSystems := s.fileSystems
+		s.mu.RUnlock()
+		for name := range scans {
+			if _, ok := fileSystems[name]; !ok {
+				delete(scans, name)
+			}
+		}
+		for name, fs := range fileSystems {
+			ctx, cancel := context.WithTimeout(s.pollCtx, shareScanTimeout)
+			scan, err := scanShare(ctx, fs)
+			cancel()
+			if err != nil {
+				// Keep the previous scan to compare the next one to.
+				continue
+			}
+			if prev := scans[name]; prev != nil {
+				for _, p := range scan.changedSince(prev) {
+					s.changes.add(shared.Join(name, p))
+				}
+			}
+			scans[name] = scan
+		}
+	}
+}
+
+// scanEntry is the part of a file's metadata used to tell whether it changed.
+type scanEntry struct {
+	isDir   bool
+	size    int64
+	modTime int64 // in Unix nanoseconds
+}
+
+// shareScan is a snapshot of the metadata of the files and directories in a
+// share.
+type shareScan struct {
+	entries   map[string]scanEntry // by path within the share
+	truncated bool                 // whether maxScannedEntries was reached
+}
+
+// scanShare scans the share served by fs, breadth first, stopping after
+// maxScannedEntries files and directories.
+func scanShare(ctx context.Context, fs webdav.FileSystem) (*shareScan, error) {
+	scan := &shareScan{entries: make(map[string]scanEntry)}
+	dirs := []string{"/"}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+		f, err := fs.OpenFile(ctx, dir, os.O_RDONLY, 0)
+		if err != nil {
+			return nil, err
+		}
+		fis, err := f.Readdir(0)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range fis {
+			if len(scan.entries) == maxScannedEntries {
+				scan.truncated = true
+				return scan, nil
+			}
+			p := path.Join(dir, fi.Name())
+			scan.entries[p] = scanEntry{
+				isDir:   fi.IsDir(),
+				size:    fi.Size(),
+				modTime: fi.ModTime().UnixNano(),
+			}
+			if fi.IsDir() {
+				dirs = append(dirs, p)
+			}
+		}
+	}
+	return scan, nil
+}
+
+// changedSince returns the paths within the share of the files and
+// directories that were created, modified or removed since the earlier scan
+// prev, sorted, or just "/" if there are more than maxScanChanges. If either
+// scan was truncated, files that only one of them reached are ignored.
+func (scan *shareScan) changedSince(prev *shareScan) []string {
+	truncated := scan.truncated || prev.truncated
+	var changed []string
+	for p, e := range scan.entries {
+		pe, ok := prev.entries[p]
+		if (ok && pe != e) || (!ok && !truncated) {
+			changed = append(changed, p)
+		}
+	}
+	if !truncated {
+		for p := range prev.entries {
+			if _, ok := scan.entries[p]; !ok {
+				changed = append(changed, p)
+			}
+		}
+	}
+	if len(changed) > maxScanChanges {
+		return []string{"/"}
+	}
+	slices.Sort(changed)
+	return changed
+}
+
+func writeChanges(w http.ResponseWriter, changes shared.Changes) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
+
+// statusRecorder is an http.ResponseWriter that records the status code of
+// the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
@@ -5,7 +5,13 @@
 package compositefs
 
 import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path"
@@ -15,9 +21,11 @@
 	"time"
 
 	"github.com/tailscale/xnet/webdav"
+	"tailscale.com/atomicfile"
 	"tailscale.com/tailfs/tailfsimpl/shared"
 	"tailscale.com/tstime"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/set"
 )
 
 // Child is a child filesystem of a CompositeFileSystem
@@ -29,6 +37,14 @@ type Child struct {
 	// Available is a function indicating whether or not the child is currently
 	// available.
 	Available func() bool
+	// ReadOnly, if true, makes the child read-only. Any attempt to modify
+	// its contents fails with os.ErrPermission.
+	ReadOnly bool
+	// Identity, if set, identifies what the child serves, like the URL of
+	// a remote. A child that replaces one with the same Name and Identity
+	// keeps its inode number, modification time and ETag, so that clients
+	// don't consider it changed, unless it had become unavailable.
+	Identity string
 }
 
 func (c *Child) isAvailable() bool {
@@ -49,6 +65,9 @@ type Options struct {
 	// Clock, if specified, determines the current time. If not specified, we
 	// default to time.Now().
 	Clock tstime.Clock
+	// StatePath, if specified, is the file in which the inode numbers of
+	// the children are kept, so that they survive restarts.
+	StatePath string
 }
 
 // New constructs a CompositeFileSystem that logs using the given logf.
@@ -60,12 +79,20 @@ func New(opts Options) *CompositeFileSystem {
 	fs := &CompositeFileSystem{
 		logf:         logf,
 		statChildren: opts.StatChildren,
+		statePath:    opts.StatePath,
+		inodes:       make(map[childKey]*childInode),
 	}
 	if opts.Clock != nil {
 		fs.now = opts.Clock.Now
 	} else {
 		fs.now = time.Now
 	}
+	if fs.statePath != "" {
+		if err := fs.loadLocked(); err != nil {
+			logf("compositefs: loading inodes from %s: %v", fs.statePath, err)
+		}
+	}
+	fs.rootModTime = fs.now()
 	return fs
 }
 
@@ -85,20 +112,216 @@ func New(opts Options) *CompositeFileSystem {
 type CompositeFileSystem struct {
 	logf         logger.Logf
 	statChildren bool
+	statePath    string
 	now          func() time.Time
 
-	// childrenMu guards children
+	// childrenMu guards the below values.
 	childrenMu sync.Mutex
 	children   []*Child
+	// inodes are the inodes of all children ever added, so that a child
+	// that's replaced by an equivalent one, such as when the set of
+	// remotes is refreshed, or that's removed and added again, appears
+	// unchanged to clients. There's one for each distinct name and
+	// identity, which is few enough to keep them all. They're saved to
+	// statePath, if set.
+	inodes  map[childKey]*childInode
+	lastIno uint64
+	// rootETag is the ETag of the root as of the last time it was
+	// computed, and rootModTime when it last changed.
+	rootETag    string
+	rootModTime time.Time
+}
+
+// childKey identifies equivalent children.
+type childKey struct {
+	name, identity string
+}
+
+// childInode is the stable identity of equivalent children.
+type childInode struct {
+	ino uint64
+	// gen counts the times that an equivalent child became available
+	// again after it was removed or unavailable, which is when whatever it
+	// serves may have changed without us noticing.
+	gen     uint64
+	modTime time.Time // when the child was first added, or gen last changed
+	// available is whether an equivalent child was available when last
+	// checked.
+	available bool
+}
+
+// observeLocked returns the inode of child, assigning it one if it's the
+// first child with its name and identity, and bumps its generation if the
+// child became available again.
+func (cfs *CompositeFileSystem) observeLocked(child *Child) *childInode {
+	k := childKey{child.Name, child.Identity}
+	available := child.isAvailable()
+	in, ok := cfs.inodes[k]
+	switch {
+	case !ok:
+		cfs.lastIno++
+		in = &childInode{ino: cfs.lastIno, modTime: cfs.now()}
+		cfs.inodes[k] = in
+		cfs.saveLocked()
+	case available && !in.available:
+		in.gen++
+		in.modTime = cfs.now()
+		cfs.saveLocked()
+	}
+	in.available = available
+	return in
+}
+
+// forgetLocked records that the children with the given keys were removed.
+func (cfs *CompositeFileSystem) forgetLocked(keys ...childKey) {
+	for _, k := range keys {
+		if in, ok := cfs.inodes[k]; ok {
+			in.available = false
+		}
+	}
+}
+
+// persistedInode is the form in which childInodes are saved to
+// Options.StatePath.
+type persistedInode struct {
+	Name     string
+	Identity string `json:",omitempty"`
+	Ino      uint64
+	Gen      uint64 `json:",omitempty"`
+	ModTime  time.Time
+}
+
+// loadLocked loads the inodes saved in cfs.statePath, if any. Loaded
+// children are considered available, so that a restart alone doesn't
+// change them.
+func (cfs *CompositeFileSystem) loadLocked() error {
+	b, err := os.ReadFile(cfs.statePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var inodes []persistedInode
+	if err := json.Unmarshal(b, &inodes); err != nil {
+		return err
+	}
+	for _, pi := range inodes {
+		cfs.inodes[childKey{pi.Name, pi.Identity}] = &childInode{
+			ino:       pi.Ino,
+			gen:       pi.Gen,
+			modTime:   pi.ModTime,
+			available: true,
+		}
+		cfs.lastIno = max(cfs.lastIno, pi.Ino)
+	}
+	return nil
+}
+
+// saveLocked saves the inodes to cfs.statePath, if set. It's called only
+// when an inode is added or its generation changes, which is rare.
+func (cfs *CompositeFileSystem) saveLocked() {
+	if cfs.statePath == "" {
+		return
+	}
+	inodes := make([]persistedInode, 0, len(cfs.inodes))
+	for k, in := range cfs.inodes {
+		inodes = append(inodes, persistedInode{
+			Name:     k.name,
+			Identity: k.identity,
+			Ino:      in.ino,
+			Gen:      in.gen,
+			ModTime:  in.modTime,
+		})
+	}
+	slices.SortFunc(inodes, func(a, b persistedInode) int {
+		return cmp.Compare(a.Ino, b.Ino)
+	})
+	b, err := json.Marshal(inodes)
+	if err == nil {
+		err = atomicfile.WriteFile(cfs.statePath, b, 0600)
+	}
+	if err != nil {
+		cfs.logf("compositefs: saving inodes: %v", err)
+	}
+}
+
+// childInfo returns the fs.FileInfo of child, as it appears in the root
+// directory under the given name. Unless the CompositeFileSystem stats its
+// children, it's a read-only directory whose modification time and ETag
+// change when the child is replaced by one with a different identity, or
+// becomes available again. As we can't tell whether the contents of the
+// child changed otherwise, its ETag is weak.
+func (cfs *CompositeFileSystem) childInfo(ctx context.Context, name string, child *Child) (fs.FileInfo, error) {
+	if cfs.statChildren {
+		fi, err := child.FS.Stat(ctx, "/")
+		if err != nil {
+			return nil, err
+		}
+		// we use the full name, which is different than what the child sees
+		return shared.RenamedFileInfo(ctx, name, fi), nil
+	}
+	cfs.childrenMu.Lock()
+	in := cfs.observeLocked(child)
+	ino, gen, modTime := in.ino, in.gen, in.modTime
+	cfs.childrenMu.Unlock()
+	fi := shared.ReadOnlyDirInfo(name, modTime)
+	fi.ETagged = fmt.Sprintf(`W/"%x-%x"`, ino, gen)
+	return fi, nil
+}
+
+// rootInfo returns the fs.FileInfo of the root directory, whose ETag
+// identifies the set of available children and their generations, and whose
+// modification time is when that last changed, or the latest modification
+// time of the children if the CompositeFileSystem stats them.
+func (cfs *CompositeFileSystem) rootInfo(ctx context.Context, name string) (fs.FileInfo, error) {
+	cfs.childrenMu.Lock()
+	children := cfs.children
+	h := fnv.New64a()
+	for _, child := range children {
+		if in := cfs.observeLocked(child); in.available {
+			fmt.Fprintf(h, "%x-%x,", in.ino, in.gen)
+		}
+	}
+	etag := fmt.Sprintf(`"%x"`, h.Sum64())
+	if etag != cfs.rootETag {
+		if cfs.rootETag != "" {
+			cfs.rootModTime = cfs.now()
+		}
+		cfs.rootETag = etag
+	}
+	fi := shared.ReadOnlyDirInfo(name, cfs.rootModTime)
+	cfs.childrenMu.Unlock()
+
+	if cfs.statChildren {
+		// update last modified time based on children
+		for i, child := range children {
+			childInfo, err := child.FS.Stat(ctx, "/")
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 || childInfo.ModTime().After(fi.ModTime()) {
+				fi.ModdedTime = childInfo.ModTime()
+			}
+		}
+		// The children's modification times are part of the ETag, as
+		// the root's is derived from them.
+		etag = fmt.Sprintf(`"%x-%x"`, h.Sum64(), fi.ModdedTime.UnixNano())
+	}
+	fi.ETagged = etag
+	return fi, nil
 }
 
 // AddChild ads a single child with the given name, replacing any existing
 // child with the same name.
 func (cfs *CompositeFileSystem) AddChild(child *Child) {
 	cfs.childrenMu.Lock()
+	cfs.observeLocked(child)
 	oldIdx, oldChild := cfs.findChildLocked(child.Name)
 	if oldChild != nil {
 		// replace old child
+		if oldChild.Identity != child.Identity {
+			cfs.forgetLocked(childKey{oldChild.Name, oldChild.Identity})
+		}
 		cfs.children[oldIdx] = child
 	} else {
 		// insert new child
@@ -121,6 +344,7 @@ func (cfs *CompositeFileSystem) RemoveChild(name string) {
 	oldPos, oldChild := cfs.findChildLocked(name)
 	if oldChild != nil {
 		// remove old child
+		cfs.forgetLocked(childKey{oldChild.Name, oldChild.Identity})
 		copy(cfs.children[oldPos:], cfs.children[oldPos+1:])
 		cfs.children = cfs.children[:len(cfs.children)-1]
 	}
@@ -145,6 +369,16 @@ func (cfs *CompositeFileSystem) SetChildren(children ...*Child) {
 	})
 
 	cfs.childrenMu.Lock()
+	keys := make(set.Set[childKey], len(children))
+	for _, child := range children {
+		cfs.observeLocked(child)
+		keys.Add(childKey{child.Name, child.Identity})
+	}
+	for _, child := range cfs.children {
+		if k := (childKey{child.Name, child.Identity}); !keys.Contains(k) {
+			cfs.forgetLocked(k)
+		}
+	}
 	oldChildren := cfs.children
 	cfs.children = children
 	cfs.childrenMu.Unlock()
@@ -11,6 +11,7 @@
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -495,3 +496,170 @@ type closeableFS struct {
 func (cfs *closeableFS) Close() error {
 	return nil
 }
+
+func TestReadOnlyChild(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte("12345"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	cfs := New(Options{Logf: t.Logf})
+	cfs.AddChild(&Child{Name: "ro", FS: webdav.Dir(dir), ReadOnly: true})
+
+	ctx := context.Background()
+	f, err := cfs.OpenFile(ctx, "/ro/file.txt", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("opening for reading: %v", err)
+	}
+	f.Close()
+
+	tests := []struct {
+		label string
+		do    func() error
+	}{
+		{"write file", func() error {
+			_, err := cfs.OpenFile(ctx, "/ro/file.txt", os.O_WRONLY, 0)
+			return err
+		}},
+		{"create file", func() error {
+			_, err := cfs.OpenFile(ctx, "/ro/new.txt", os.O_RDWR|os.O_CREATE, 0666)
+			return err
+		}},
+		{"mkdir", func() error { return cfs.Mkdir(ctx, "/ro/dir", 0777) }},
+		{"remove", func() error { return cfs.RemoveAll(ctx, "/ro/file.txt") }},
+		{"rename", func() error { return cfs.Rename(ctx, "/ro/file.txt", "/ro/moved.txt") }},
+	}
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			if err := test.do(); !errors.Is(err, os.ErrPermission) {
+				t.Errorf("got error %v; want %v", err, os.ErrPermission)
+			}
+		})
+	}
+	if _, err := os.Stat(filepath.Join(dir, "file.txt")); err != nil {
+		t.Errorf("file modified: %v", err)
+	}
+}
+
+func TestStableInfo(t *testing.T) {
+	clock := tstest.NewClock(tstest.ClockOpts{Start: time.Now()})
+	cfs := New(Options{Logf: t.Logf, Clock: clock})
+	dir := t.TempDir()
+	available := true
+	children := func(identityA string) []*Child {
+		return []*Child{
+			{Name: "a", FS: webdav.Dir(dir), Identity: identityA},
+			{Name: "b", FS: webdav.Dir(dir), Identity: "b", Available: func() bool { return available }},
+		}
+	}
+	ctx := context.Background()
+	statETag := func(name string) (string, time.Time) {
+		t.Helper()
+		fi, err := cfs.Stat(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		etag, err := fi.(webdav.ETager).ETag(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return etag, fi.ModTime()
+	}
+
+	start := clock.Now()
+	cfs.SetChildren(children("a1")...)
+	rootETag, rootModTime := statETag("/")
+	aETag, aModTime := statETag("/a")
+	if !rootModTime.Equal(start) || !aModTime.Equal(start) {
+		t.Errorf("got mod times %v and %v, want %v", rootModTime, aModTime, start)
+	}
+
+	// Replacing the children with equivalent ones changes nothing.
+	clock.Advance(time.Minute)
+	cfs.SetChildren(children("a1")...)
+	if etag, modTime := statETag("/"); etag != rootETag || !modTime.Equal(rootModTime) {
+		t.Errorf("root changed to %s at %v after replacing children with equivalent ones", etag, modTime)
+	}
+	if etag, modTime := statETag("/a"); etag != aETag || !modTime.Equal(aModTime) {
+		t.Errorf("child changed to %s at %v after replacing it with an equivalent one", etag, modTime)
+	}
+
+	// A child with a different identity is a different directory.
+	clock.Advance(time.Minute)
+	cfs.SetChildren(children("a2")...)
+	if etag, modTime := statETag("/"); etag == rootETag || !modTime.Equal(clock.Now()) {
+		t.Errorf("root unchanged after replacing a child with a different one")
+	}
+	if etag, modTime := statETag("/a"); etag == aETag || !modTime.Equal(clock.Now()) {
+		t.Errorf("child unchanged after replacing it with a different one")
+	}
+	rootETag, _ = statETag("/")
+
+	// The root changes when children become unavailable.
+	clock.Advance(time.Minute)
+	available = false
+	if etag, modTime := statETag("/"); etag == rootETag || !modTime.Equal(clock.Now()) {
+		t.Errorf("root unchanged after a child became unavailable")
+	}
+}
+
+func TestChildGenerations(t *testing.T) {
+	clock := tstest.NewClock(tstest.ClockOpts{Start: time.Now()})
+	statePath := filepath.Join(t.TempDir(), "inodes.json")
+	dir := t.TempDir()
+	available := true
+	child := &Child{Name: "a", FS: webdav.Dir(dir), Identity: "a", Available: func() bool { return available }}
+	ctx := context.Background()
+	statETag := func(cfs *CompositeFileSystem) (string, time.Time) {
+		t.Helper()
+		fi, err := cfs.Stat(ctx, "/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		etag, err := fi.(webdav.ETager).ETag(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return etag, fi.ModTime()
+	}
+
+	cfs := New(Options{Logf: t.Logf, Clock: clock, StatePath: statePath})
+	cfs.SetChildren(child)
+	etag, modTime := statETag(cfs)
+	if !strings.HasPrefix(etag, "W/") {
+		t.Errorf("ETag %s of a child that isn't stat'd is strong", etag)
+	}
+
+	// A child that was unavailable may have changed in the meantime.
+	clock.Advance(time.Minute)
+	available = false
+	cfs.Stat(ctx, "/")
+	available = true
+	if got, gotModTime := statETag(cfs); got == etag || !gotModTime.Equal(clock.Now()) {
+		t.Errorf("child unchanged after it became available again")
+	}
+	etag, modTime = statETag(cfs)
+
+	// So may a child that was removed and added again.
+	clock.Advance(time.Minute)
+	cfs.RemoveChild("a")
+	cfs.AddChild(child)
+	if got, _ := statETag(cfs); got == etag {
+		t.Errorf("child unchanged after it was removed and added again")
+	}
+	etag, modTime = statETag(cfs)
+
+	// The inodes survive restarts.
+	clock.Advance(time.Minute)
+	cfs = New(Options{Logf: t.Logf, Clock: clock, StatePath: statePath})
+	cfs.SetChildren(&Child{Name: "b", FS: webdav.Dir(dir), Identity: "b"}, child)
+	if got, gotModTime := statETag(cfs); got != etag || !gotModTime.Equal(modTime) {
+		t.Errorf("child changed to %s at %v after a restart, want %s at %v", got, gotModTime, etag, modTime)
+	}
+	fi, err := cfs.Stat(ctx, "/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := fi.(webdav.ETager).ETag(ctx); got == etag {
+		t.Errorf("new child got the inode of an existing one")
+	}
+}
@@ -13,7 +13,7 @@
 // Mkdir implements webdav.Filesystem. The root of this file system is
 // read-only, so any attempts to make directories within the root will fail
 // with os.ErrPermission. Attempts to make directories within one of the child
-// filesystems will be handled by the respective child.
+// filesystems will be handled by the respective child, unless it's read-only.
 func (cfs *CompositeFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
 	if shared.IsRoot(name) {
 		// root directory already exists, consider this okay
@@ -35,5 +35,9 @@ func (cfs *CompositeFileSystem) Mkdir(ctx context.Context, name string, perm os.
 		return err
 	}
 
+	if pathInfo.child.ReadOnly {
+		return os.ErrPermission
+	}
+
 	return pathInfo.child.FS.Mkdir(ctx, pathInfo.pathOnChild, perm)
 }
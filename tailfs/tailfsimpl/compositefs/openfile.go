@@ -20,6 +20,10 @@ func (cfs *CompositeFileSystem) OpenFile(ctx context.Context, name string, flag
 			return nil, err
 		}
 
+		if pathInfo.child.ReadOnly && flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+			return nil, os.ErrPermission
+		}
+
 		if pathInfo.refersToChild {
 			// this is the child itself, ask it to open its root
 			return pathInfo.child.FS.OpenFile(ctx, "/", flag, perm)
@@ -44,17 +48,9 @@ func (cfs *CompositeFileSystem) OpenFile(ctx context.Context, name string, flag
 			childInfos := make([]fs.FileInfo, 0, len(cfs.children))
 			for _, c := range children {
 				if c.isAvailable() {
-					var childInfo fs.FileInfo
-					if cfs.statChildren {
-						fi, err := c.FS.Stat(ctx, "/")
-						if err != nil {
-							return nil, err
-						}
-						// we use the full name
-						childInfo = shared.RenamedFileInfo(ctx, c.Name, fi)
-					} else {
-						// always use now() as the modified time to bust caches
-						childInfo = shared.ReadOnlyDirInfo(c.Name, cfs.now())
+					childInfo, err := cfs.childInfo(ctx, c.Name, c)
+					if err != nil {
+						return nil, err
 					}
 					childInfos = append(childInfos, childInfo)
 				}
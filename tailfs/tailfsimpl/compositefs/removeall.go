@@ -12,7 +12,8 @@
 
 // RemoveAll implements webdav.File. The root of this file system is read-only,
 // so attempting to call RemoveAll on the root will fail with os.ErrPermission.
-// RemoveAll within a child will be handled by the respective child.
+// RemoveAll within a child will be handled by the respective child, unless it's
+// read-only.
 func (cfs *CompositeFileSystem) RemoveAll(ctx context.Context, name string) error {
 	if shared.IsRoot(name) {
 		// root directory is read-only
@@ -29,5 +30,9 @@ func (cfs *CompositeFileSystem) RemoveAll(ctx context.Context, name string) erro
 		return err
 	}
 
+	if pathInfo.child.ReadOnly {
+		return os.ErrPermission
+	}
+
 	return pathInfo.child.FS.RemoveAll(ctx, pathInfo.pathOnChild)
 }
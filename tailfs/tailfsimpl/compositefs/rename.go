@@ -14,7 +14,7 @@
 // is read-only, so any attempt to rename a child within the root of this
 // filesystem will fail with os.ErrPermission. Renaming across children is not
 // supported and will fail with os.ErrPermission. Renaming within a child will
-// be handled by the respective child.
+// be handled by the respective child, unless it's read-only.
 func (cfs *CompositeFileSystem) Rename(ctx context.Context, oldName, newName string) error {
 	if shared.IsRoot(oldName) || shared.IsRoot(newName) {
 		// root directory is read-only
@@ -44,6 +44,10 @@ func (cfs *CompositeFileSystem) Rename(ctx context.Context, oldName, newName str
 		return os.ErrPermission
 	}
 
+	if oldPathInfo.child.ReadOnly {
+		return os.ErrPermission
+	}
+
 	// file is moving within the same child, let the child handle it
 	return oldPathInfo.child.FS.Rename(ctx, oldPathInfo.pathOnChild, newPathInfo.pathOnChild)
 }
@@ -14,24 +14,7 @@
 func (cfs *CompositeFileSystem) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
 	if shared.IsRoot(name) {
 		// Root is a directory
-		// always use now() as the modified time to bust caches
-		fi := shared.ReadOnlyDirInfo(name, cfs.now())
-		if cfs.statChildren {
-			// update last modified time based on children
-			cfs.childrenMu.Lock()
-			children := cfs.children
-			cfs.childrenMu.Unlock()
-			for i, child := range children {
-				childInfo, err := child.FS.Stat(ctx, "/")
-				if err != nil {
-					return nil, err
-				}
-				if i == 0 || childInfo.ModTime().After(fi.ModTime()) {
-					fi.ModdedTime = childInfo.ModTime()
-				}
-			}
-		}
-		return fi, nil
+		return cfs.rootInfo(ctx, name)
 	}
 
 	pathInfo, err := cfs.pathInfoFor(name)
@@ -39,10 +22,8 @@ func (cfs *CompositeFileSystem) Stat(ctx context.Context, name string) (fs.FileI
 		return nil, err
 	}
 
-	if pathInfo.refersToChild && !cfs.statChildren {
-		// Return a read-only FileInfo for this child.
-		// Always use now() as the modified time to bust caches.
-		return shared.ReadOnlyDirInfo(name, cfs.now()), nil
+	if pathInfo.refersToChild {
+		return cfs.childInfo(ctx, name, pathInfo.child)
 	}
 
 	fi, err := pathInfo.child.FS.Stat(ctx, pathInfo.pathOnChild)
@@ -8,13 +8,18 @@
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/tailscale/xnet/webdav"
 	"tailscale.com/tailfs"
 	"tailscale.com/tailfs/tailfsimpl/compositefs"
+	"tailscale.com/tailfs/tailfsimpl/shared"
 	"tailscale.com/tailfs/tailfsimpl/webdavfs"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/set"
 )
 
 const (
@@ -23,11 +28,36 @@
 	// DirectoryCacheLifetime setting of Windows' built-in SMB client,
 	// see https://learn.microsoft.com/en-us/previous-versions/windows/it-pro/windows-7/ff686200(v=ws.10)
 	statCacheTTL = 10 * time.Second
+
+	// readAhead is how many chunks of large files the local WebDAV proxy
+	// fetches from remotes in parallel while they're read.
+	readAhead = 4
 )
 
-// NewFileSystemForLocal starts serving a filesystem for local clients.
+// NewFileSystemForLocal starts serving a filesystem for local WebDAV clients.
 // Inbound connections must be handed to HandleConn.
 func NewFileSystemForLocal(logf logger.Logf) *FileSystemForLocal {
+	return newFileSystemForLocal(logf, func(cfs webdav.FileSystem) http.Handler {
+		return &webdav.Handler{
+			FileSystem: cfs,
+			LockSystem: webdav.NewMemLS(),
+		}
+	})
+}
+
+// NewFileSystemForLocalVFS starts serving a filesystem for local virtual
+// filesystem providers, using a plain HTTP API rather than WebDAV. It's an
+// alternative to NewFileSystemForLocal for clients whose WebDAV support is
+// lacking, like Windows, whose WebDAV redirector limits file sizes and
+// performs poorly. See vfsHandler for the API. Inbound connections must be
+// handed to HandleConn.
+func NewFileSystemForLocalVFS(logf logger.Logf) *FileSystemForLocal {
+	return newFileSystemForLocal(logf, func(cfs webdav.FileSystem) http.Handler {
+		return &vfsHandler{fs: cfs}
+	})
+}
+
+func newFileSystemForLocal(logf logger.Logf, newHandler func(webdav.FileSystem) http.Handler) *FileSystemForLocal {
 	if logf == nil {
 		logf = log.Printf
 	}
@@ -36,24 +66,88 @@ func NewFileSystemForLocal(logf logger.Logf) *FileSystemForLocal {
 		cfs:      compositefs.New(compositefs.Options{Logf: logf}),
 		listener: newConnListener(),
 	}
-	fs.startServing()
+	fs.startServing(newHandler(fs.cfs))
 	return fs
 }
 
 // FileSystemForLocal is the TailFS filesystem exposed to local clients. It
-// provides a unified WebDAV interface to remote TailFS shares on other nodes.
+// provides a unified interface to remote TailFS shares on other nodes, served
+// over WebDAV or the API of vfsHandler.
 type FileSystemForLocal struct {
-	logf     logger.Logf
-	cfs      *compositefs.CompositeFileSystem
-	listener *connListener
+	logf      logger.Logf
+	cfs       *compositefs.CompositeFileSystem
+	listener  *connListener
+	transfers transfers
+
+	// mu guards the below values.
+	mu           sync.Mutex
+	contentCache *webdavfs.ContentCache
+	stateDir     string
+	watchers     set.HandleSet[func(string)]
 }
 
-func (s *FileSystemForLocal) startServing() {
+// SetStateDir makes s keep the inode numbers of remotes in dir, so that
+// local clients don't consider them changed after a restart. It applies to
+// tailnet domains first set by subsequent calls to SetRemotes.
+func (s *FileSystemForLocal) SetStateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.stateDir = dir
+	s.mu.Unlock()
+	return nil
+}
+
+// EnableContentCache makes s cache the contents of files read from remotes
+// in dir, using at most maxSize bytes, so that files read repeatedly aren't
+// downloaded each time. Files cached in dir before are kept, and used if
+// they're unchanged on the remote. It applies to remotes set by subsequent
+// calls to SetRemotes.
+func (s *FileSystemForLocal) EnableContentCache(dir string, maxSize int64) error {
+	cache, err := webdavfs.NewContentCache(dir, maxSize)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.contentCache = cache
+	s.mu.Unlock()
+	return nil
+}
+
+// WatchChanges registers fn to be called with the path of each file or
+// directory that changes on a remote share, such as
+// "/example.ts.net/host/share/dir/file.txt", as seen by local clients. If
+// anything in a remote might have changed, fn is called with the path of the
+// remote itself. Remotes are only watched once they've been accessed, and
+// only if they support it. The returned func unregisters fn.
+func (s *FileSystemForLocal) WatchChanges(fn func(name string)) (unregister func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.watchers.Add(fn)
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.watchers, h)
+	}
+}
+
+// notifyChange calls the functions registered with WatchChanges.
+func (s *FileSystemForLocal) notifyChange(name string) {
+	s.mu.Lock()
+	fns := make([]func(string), 0, len(s.watchers))
+	for _, fn := range s.watchers {
+		fns = append(fns, fn)
+	}
+	s.mu.Unlock()
+	for _, fn := range fns {
+		fn(name)
+	}
+}
+
+func (s *FileSystemForLocal) startServing(h http.Handler) {
 	hs := &http.Server{
-		Handler: &webdav.Handler{
-			FileSystem: s.cfs,
-			LockSystem: webdav.NewMemLS(),
-		},
+		Handler: s.transfers.wrap(h),
 	}
 	go func() {
 		err := hs.Serve(s.listener)
@@ -64,39 +158,71 @@ func (s *FileSystemForLocal) startServing() {
 	}()
 }
 
-// HandleConn handles connections from local WebDAV clients
+// HandleConn handles connections from local clients
 func (s *FileSystemForLocal) HandleConn(conn net.Conn, remoteAddr net.Addr) error {
 	return s.listener.HandleConn(conn, remoteAddr)
 }
 
 // SetRemotes sets the complete set of remotes on the given tailnet domain
 // using a map of name -> url. If transport is specified, that transport
-// will be used to connect to these remotes.
+// will be used to connect to these remotes. The shares of remotes with
+// Permissions to which this node only has read-only access are read-only.
 func (s *FileSystemForLocal) SetRemotes(domain string, remotes []*tailfs.Remote, transport http.RoundTripper) {
+	s.mu.Lock()
+	contentCache := s.contentCache
+	stateDir := s.stateDir
+	s.mu.Unlock()
+
 	children := make([]*compositefs.Child, 0, len(remotes))
 	for _, remote := range remotes {
+		prefix := shared.Join(domain, remote.Name)
 		opts := webdavfs.Options{
 			URL:          remote.URL,
 			Transport:    transport,
 			StatCacheTTL: statCacheTTL,
-			Logf:         s.logf,
+			ContentCache: contentCache,
+			WatchChanges: true,
+			ReadAhead:    readAhead,
+			OnChange: func(name string) {
+				s.notifyChange(shared.Join(prefix, name))
+			},
+			Logf: s.logf,
+		}
+		var fs webdav.FileSystem = webdavfs.New(opts)
+		if remote.Permissions != nil {
+			fs = &readOnlySharesFS{FileSystem: fs, permissions: remote.Permissions, logf: s.logf}
 		}
 		children = append(children, &compositefs.Child{
 			Name:      remote.Name,
-			FS:        webdavfs.New(opts),
+			FS:        fs,
 			Available: remote.Available,
+			Identity:  remote.URL,
 		})
 	}
 
 	domainChild, found := s.cfs.GetChild(domain)
 	if !found {
-		domainChild = compositefs.New(compositefs.Options{Logf: s.logf})
+		opts := compositefs.Options{Logf: s.logf}
+		if stateDir != "" {
+			opts.StatePath = filepath.Join(stateDir, "inodes-"+domain+".json")
+		}
+		domainChild = compositefs.New(opts)
 		s.cfs.SetChildren(&compositefs.Child{Name: domain, FS: domainChild})
 	}
 	domainChild.(*compositefs.CompositeFileSystem).SetChildren(children...)
 }
 
-// Close() stops serving the WebDAV content
+// Transfers implements tailfs.FileSystemForLocal.
+func (s *FileSystemForLocal) Transfers() []*tailfs.Transfer {
+	return s.transfers.list()
+}
+
+// CancelTransfer implements tailfs.FileSystemForLocal.
+func (s *FileSystemForLocal) CancelTransfer(id int64) bool {
+	return s.transfers.cancel(id)
+}
+
+// Close() stops serving the content
 func (s *FileSystemForLocal) Close() error {
 	s.cfs.Close()
 	return s.listener.Close()
@@ -0,0 +1,106 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tailfsimpl
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"golang.org/x/time/rate"
+	"tailscale.com/tailfs"
+)
+
+// shareLimits holds the rate limiters shared by all transfers of files from
+// and to a share.
+type shareLimits struct {
+	download *rate.Limiter // or nil if unlimited
+	upload   *rate.Limiter // or nil if unlimited
+}
+
+// newShareLimits returns the shareLimits for share, or nil if it has none.
+func newShareLimits(share *tailfs.Share) *shareLimits {
+	if share.DownloadLimit <= 0 && share.UploadLimit <= 0 {
+		return nil
+	}
+	return &shareLimits{
+		download: newLimiter(share.DownloadLimit),
+		upload:   newLimiter(share.UploadLimit),
+	}
+}
+
+// newLimiter returns a limiter allowing bytesPerSecond, or nil if it's not
+// positive.
+func newLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	// Allow bursts of a fraction of a second's worth, within reason.
+	burst := min(max(bytesPerSecond/4, 4<<10), 256<<10)
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(burst))
+}
+
+// buildLimits returns the shareLimits for shares, reusing those in old for
+// shares whose limits haven't changed so that changing other settings doesn't
+// reset them.
+func buildLimits(shares, oldShares map[string]*tailfs.Share, old map[string]*shareLimits) map[string]*shareLimits {
+	limits := make(map[string]*shareLimits)
+	for name, share := range shares {
+		if prev := oldShares[name]; prev != nil && prev.DownloadLimit == share.DownloadLimit && prev.UploadLimit == share.UploadLimit {
+			if l := old[name]; l != nil {
+				limits[name] = l
+			}
+			continue
+		}
+		if l := newShareLimits(share); l != nil {
+			limits[name] = l
+		}
+	}
+	return limits
+}
+
+// limitedReader is a request body read no faster than its limiter allows.
+type limitedReader struct {
+	io.ReadCloser
+	ctx context.Context
+	lim *rate.Limiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > lr.lim.Burst() {
+		p = p[:lr.lim.Burst()]
+	}
+	n, err := lr.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := lr.lim.WaitN(lr.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// limitedResponseWriter is an http.ResponseWriter whose response body is
+// written no faster than its limiter allows.
+type limitedResponseWriter struct {
+	http.ResponseWriter
+	ctx context.Context
+	lim *rate.Limiter
+}
+
+func (lw *limitedResponseWriter) Write(b []byte) (int, error) {
+	var written int
+	for len(b) > 0 {
+		chunk := b[:min(len(b), lw.lim.Burst())]
+		if err := lw.lim.WaitN(lw.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := lw.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,79 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tailfsimpl
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/time/rate"
+	"tailscale.com/tailfs"
+)
+
+func TestBuildLimits(t *testing.T) {
+	shares := map[string]*tailfs.Share{
+		"a": {Name: "a", DownloadLimit: 1000},
+		"b": {Name: "b"},
+		"c": {Name: "c", UploadLimit: 1000},
+	}
+	limits := buildLimits(shares, nil, nil)
+	if l := limits["a"]; l == nil || l.download == nil || l.upload != nil {
+		t.Errorf("limits for a = %+v, want download only", l)
+	}
+	if l := limits["b"]; l != nil {
+		t.Errorf("limits for b = %+v, want none", l)
+	}
+	if l := limits["c"]; l == nil || l.download != nil || l.upload == nil {
+		t.Errorf("limits for c = %+v, want upload only", l)
+	}
+
+	newShares := map[string]*tailfs.Share{
+		"a": {Name: "a", Path: "/elsewhere", DownloadLimit: 1000},
+		"c": {Name: "c", UploadLimit: 2000},
+	}
+	newLimits := buildLimits(newShares, shares, limits)
+	if newLimits["a"] != limits["a"] {
+		t.Error("limits for a with unchanged limits weren't reused")
+	}
+	if newLimits["c"] == limits["c"] {
+		t.Error("limits for c with changed limits were reused")
+	}
+}
+
+func TestLimitedTransfers(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	newLim := func() *rate.Limiter {
+		return rate.NewLimiter(rate.Inf, 4<<10)
+	}
+
+	rec := httptest.NewRecorder()
+	lw := &limitedResponseWriter{ResponseWriter: rec, ctx: context.Background(), lim: newLim()}
+	n, err := lw.Write(data)
+	if err != nil || n != len(data) {
+		t.Fatalf("Write = %d, %v; want %d, nil", n, err, len(data))
+	}
+	if !bytes.Equal(rec.Body.Bytes(), data) {
+		t.Error("written data doesn't match")
+	}
+
+	lr := &limitedReader{ReadCloser: io.NopCloser(bytes.NewReader(data)), ctx: context.Background(), lim: newLim()}
+	got, err := io.ReadAll(lr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("read data doesn't match")
+	}
+
+	// A canceled context stops transfers once the burst is used up.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	lw = &limitedResponseWriter{ResponseWriter: httptest.NewRecorder(), ctx: ctx, lim: rate.NewLimiter(1, 4<<10)}
+	if n, err := lw.Write(data); err == nil || n > 4<<10 {
+		t.Errorf("Write with canceled context = %d, %v; want at most %d bytes and an error", n, err, 4<<10)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tailfsimpl
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tailscale/xnet/webdav"
+	"tailscale.com/tailfs"
+	"tailscale.com/tailfs/tailfsimpl/shared"
+	"tailscale.com/types/logger"
+)
+
+// writeFlags are the flags to OpenFile that open a file for modification.
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_CREATE | os.O_TRUNC | os.O_APPEND
+
+// readOnlySharesFS is the filesystem of a remote, whose first level of
+// directories are its shares. Attempts to modify the shares to which this
+// node only has read-only access, according to permissions, fail with
+// os.ErrPermission without being passed to the remote.
+type readOnlySharesFS struct {
+	webdav.FileSystem
+	permissions func(context.Context) (tailfs.Permissions, error)
+	logf        logger.Logf
+
+	mu      sync.Mutex
+	perms   tailfs.Permissions // as of fetched; nil if unknown
+	fetched time.Time
+}
+
+// readOnly reports whether name is in a share to which this node only has
+// read-only access, and whether it's the share itself.
+func (sfs *readOnlySharesFS) readOnly(ctx context.Context, name string) (readOnly, isShare bool) {
+	if shared.IsRoot(name) {
+		return false, false
+	}
+	parts := shared.CleanAndSplit(name)
+	sfs.mu.Lock()
+	defer sfs.mu.Unlock()
+	if time.Since(sfs.fetched) > statCacheTTL {
+		perms, err := sfs.permissions(ctx)
+		if err != nil {
+			// Let the remote enforce them.
+			sfs.logf("tailfs: getting share permissions: %v", err)
+		}
+		sfs.perms, sfs.fetched = perms, time.Now()
+	}
+	return sfs.perms.For(parts[0]) == tailfs.PermissionReadOnly, len(parts) == 1
+}
+
+// Mkdir implements webdav.FileSystem.
+func (sfs *readOnlySharesFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	// The share's directory already exists, which the remote reports as
+	// success, even if it's read-only; see FileSystemForRemote.
+	if ro, isShare := sfs.readOnly(ctx, name); ro && !isShare {
+		return os.ErrPermission
+	}
+	return sfs.FileSystem.Mkdir(ctx, name, perm)
+}
+
+// OpenFile implements webdav.FileSystem.
+func (sfs *readOnlySharesFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&writeFlags != 0 {
+		if ro, _ := sfs.readOnly(ctx, name); ro {
+			return nil, os.ErrPermission
+		}
+	}
+	return sfs.FileSystem.OpenFile(ctx, name, flag, perm)
+}
+
+// RemoveAll implements webdav.FileSystem.
+func (sfs *readOnlySharesFS) RemoveAll(ctx context.Context, name string) error {
+	if ro, _ := sfs.readOnly(ctx, name); ro {
+		return os.ErrPermission
+	}
+	return sfs.FileSystem.RemoveAll(ctx, name)
+}
+
+// Rename implements webdav.FileSystem.
+func (sfs *readOnlySharesFS) Rename(ctx context.Context, oldName, newName string) error {
+	if ro, _ := sfs.readOnly(ctx, oldName); ro {
+		return os.ErrPermission
+	}
+	if ro, _ := sfs.readOnly(ctx, newName); ro {
+		return os.ErrPermission
+	}
+	return sfs.FileSystem.Rename(ctx, oldName, newName)
+}
+
+// Close closes the underlying filesystem, if it can be closed.
+func (sfs *readOnlySharesFS) Close() error {
+	if c, ok := sfs.FileSystem.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -5,7 +5,9 @@
 
 import (
 	"bufio"
+	"context"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
 	"math"
@@ -14,6 +16,7 @@
 	"net/netip"
 	"os"
 	"os/exec"
+	"path"
 	"strings"
 	"sync"
 	"time"
@@ -36,7 +39,9 @@ func NewFileSystemForRemote(logf logger.Logf) *FileSystemForRemote {
 		lockSystem:  webdav.NewMemLS(),
 		fileSystems: make(map[string]webdav.FileSystem),
 		userServers: make(map[string]*userServer),
+		changes:     newChangeLog(),
 	}
+	fs.pollCtx, fs.stopPolling = context.WithCancel(context.Background())
 	return fs
 }
 
@@ -44,6 +49,14 @@ func NewFileSystemForRemote(logf logger.Logf) *FileSystemForRemote {
 type FileSystemForRemote struct {
 	logf       logger.Logf
 	lockSystem webdav.LockSystem
+	changes    *changeLog
+	audit      auditLog
+
+	// pollOnce starts pollShares when changes are first requested, and
+	// stopPolling stops it.
+	pollOnce    sync.Once
+	pollCtx     context.Context
+	stopPolling context.CancelFunc
 
 	// mu guards the below values. Acquire a write lock before updating any of
 	// them, acquire a read lock before reading any of them.
@@ -51,6 +64,8 @@ type FileSystemForRemote struct {
 	fileServerAddr string
 	shares         map[string]*tailfs.Share
 	fileSystems    map[string]webdav.FileSystem
+	usages         map[string]*shareUsage
+	limits         map[string]*shareLimits
 	userServers    map[string]*userServer
 }
 
@@ -87,6 +102,8 @@ func (s *FileSystemForRemote) SetShares(shares map[string]*tailfs.Share) {
 	}
 
 	s.mu.Lock()
+	s.usages = buildUsages(shares, s.shares, s.usages)
+	s.limits = buildLimits(shares, s.shares, s.limits)
 	s.shares = shares
 	oldFileSystems := s.fileSystems
 	oldUserServers := s.userServers
@@ -156,10 +173,45 @@ func (s *FileSystemForRemote) buildWebDAVFS(share *tailfs.Share) webdav.FileSyst
 }
 
 // ServeHTTPWithPerms implements tailfs.FileSystemForRemote.
-func (s *FileSystemForRemote) ServeHTTPWithPerms(permissions tailfs.Permissions, w http.ResponseWriter, r *http.Request) {
+func (s *FileSystemForRemote) ServeHTTPWithPerms(principal tailfs.Principal, permissions tailfs.Permissions, w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	fileSystems := s.fileSystems
+	shares := s.shares
+	usages := s.usages
+	limits := s.limits
+	s.mu.RUnlock()
+
+	// limit the granted permissions by what each share allows the principal
+	permissions = permissions.Restrict(shares, principal)
+
+	switch path.Clean(r.URL.Path) {
+	case shared.ChangesPath:
+		s.serveChanges(permissions, w, r)
+		return
+	case tailfs.PermissionsPath:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(permissions)
+		return
+	}
+
+	parts := shared.CleanAndSplit(r.URL.Path)
+	share := parts[0]
+	if r.Method == "MKCOL" && len(parts) == 1 && permissions.For(share) != tailfs.PermissionNone {
+		// The share's directory already exists, even if it's read-only.
+		// Clients create the parent directories of the files they write
+		// before each write, so succeed without auditing it or recording
+		// it as a change, and let the write itself be checked.
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	if ar := startAudit(w, r); ar != nil {
+		w = ar
+		defer s.finishAudit(principal, r, ar)
+	}
+
 	isWrite := writeMethods[r.Method]
 	if isWrite {
-		share := shared.CleanAndSplit(r.URL.Path)[0]
 		switch permissions.For(share) {
 		case tailfs.PermissionNone:
 			// If we have no permissions to this share, treat it as not found
@@ -172,18 +224,22 @@ func (s *FileSystemForRemote) ServeHTTPWithPerms(permissions tailfs.Permissions,
 		}
 	}
 
-	s.mu.RLock()
-	fileSystems := s.fileSystems
-	s.mu.RUnlock()
-
 	children := make([]*compositefs.Child, 0, len(fileSystems))
 	// filter out shares to which the connecting principal has no access
 	for name, fs := range fileSystems {
-		if permissions.For(name) == tailfs.PermissionNone {
+		permission := permissions.For(name)
+		if permission == tailfs.PermissionNone {
 			continue
 		}
 
-		children = append(children, &compositefs.Child{Name: name, FS: fs})
+		if u := usages[name]; u != nil {
+			fs = &usageFS{FileSystem: fs, share: shares[name], usage: u}
+		}
+		children = append(children, &compositefs.Child{
+			Name:     name,
+			FS:       fs,
+			ReadOnly: permission == tailfs.PermissionReadOnly,
+		})
 	}
 
 	cfs := compositefs.New(
@@ -196,7 +252,63 @@ func (s *FileSystemForRemote) ServeHTTPWithPerms(permissions tailfs.Permissions,
 		FileSystem: cfs,
 		LockSystem: s.lockSystem,
 	}
-	h.ServeHTTP(w, r)
+	u, lim := usages[share], limits[share]
+	if r.Method == "GET" && u != nil {
+		if lim != nil && lim.download != nil {
+			w = &limitedResponseWriter{ResponseWriter: w, ctx: r.Context(), lim: lim.download}
+		}
+		h.ServeHTTP(&countingResponseWriter{ResponseWriter: w, n: &u.served}, r)
+		return
+	}
+	if !changeMethods[r.Method] {
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	var replaced int64
+	var body *countingReader
+	var qr *quotaReader
+	if r.Method == "PUT" && u != nil {
+		var ok bool
+		replaced, qr, ok = admitPut(w, r, shares[share], fileSystems[share], u)
+		if !ok {
+			return
+		}
+		if lim != nil && lim.upload != nil {
+			r.Body = &limitedReader{ReadCloser: r.Body, ctx: r.Context(), lim: lim.upload}
+		}
+		body = &countingReader{ReadCloser: r.Body}
+		r.Body = body
+	}
+	sr := &statusRecorder{ResponseWriter: w}
+	h.ServeHTTP(sr, r)
+	if qr != nil && qr.exceeded {
+		// remove what was written before the quota was exceeded
+		name := shared.Join(shared.CleanAndSplit(r.URL.Path)[1:]...)
+		if err := fileSystems[share].RemoveAll(context.Background(), name); err != nil {
+			s.logf("error removing file exceeding quota of share %v: %v", share, err)
+		}
+		s.changes.add(path.Clean(r.URL.Path))
+		u.invalidate()
+		return
+	}
+	if sr.status >= http.StatusBadRequest {
+		return
+	}
+	s.changes.add(path.Clean(r.URL.Path))
+	switch {
+	case body != nil:
+		u.written.Add(body.n)
+		u.adjust(body.n - replaced)
+	case r.Method == "PUT" || r.Method == "MKCOL" || r.Method == "PROPPATCH":
+		// doesn't change the size of any files
+	default:
+		// Deletes, moves and copies can affect any number of files,
+		// possibly in other shares.
+		for _, u := range usages {
+			u.invalidate()
+		}
+	}
 }
 
 func (s *FileSystemForRemote) stopUserServers(userServers map[string]*userServer) {
@@ -220,6 +332,7 @@ func (s *FileSystemForRemote) closeFileSystems(fileSystems map[string]webdav.Fil
 
 // Close() implements tailfs.FileSystemForRemote.
 func (s *FileSystemForRemote) Close() error {
+	s.stopPolling()
 	s.mu.Lock()
 	userServers := s.userServers
 	fileSystems := s.fileSystems
@@ -347,6 +460,17 @@ func (s *userServer) run(executable string) error {
 	return cmd.Wait()
 }
 
+// changeMethods are the methods that can change files or directories.
+var changeMethods = map[string]bool{
+	"PUT":       true,
+	"POST":      true,
+	"COPY":      true,
+	"MKCOL":     true,
+	"MOVE":      true,
+	"PROPPATCH": true,
+	"DELETE":    true,
+}
+
 var writeMethods = map[string]bool{
 	"PUT":       true,
 	"POST":      true,
@@ -0,0 +1,38 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package shared
+
+// ChangesPath is the path on a remote's WebDAV server from which changes to
+// its shares can be long-polled. It can't conflict with a share, as share
+// names can't start with a dot.
+//
+// A GET of ChangesPath without parameters returns the current Changes.Seq
+// immediately. With a "since" parameter, it waits for changes after that
+// sequence number, or until it times out and returns no changes.
+const ChangesPath = "/.tailfs-changes"
+
+// Changes is the response to a request for ChangesPath.
+type Changes struct {
+	// Seq is the sequence number of the latest change, to pass as the
+	// "since" parameter of the next request.
+	Seq uint64 `json:"seq"`
+
+	// Reset is whether changes since the requested sequence number were
+	// forgotten (or the remote restarted), so that everything should be
+	// considered changed.
+	Reset bool `json:"reset,omitempty"`
+
+	// Changes are the changes since the requested sequence number, oldest
+	// first.
+	Changes []Change `json:"changes,omitempty"`
+}
+
+// Change is a change to a file or directory in a share.
+type Change struct {
+	// Seq is the sequence number of the change.
+	Seq uint64 `json:"seq"`
+	// Path is the path to the changed file or directory, starting with the
+	// share name.
+	Path string `json:"path"`
+}
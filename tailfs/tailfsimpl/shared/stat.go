@@ -7,6 +7,8 @@
 	"context"
 	"io/fs"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/tailscale/xnet/webdav"
@@ -28,6 +30,9 @@ type StaticFileInfo struct {
 	ModdedTime time.Time
 	// Dir controls IsDir()
 	Dir bool
+	// ETagged controls ETag(). If it's empty, the webdav package derives
+	// the ETag from ModTime() and Size().
+	ETagged string
 }
 
 // BirthTime implements webdav.BirthTimer
@@ -41,6 +46,16 @@ func (fi *StaticFileInfo) ModTime() time.Time { return fi.ModdedTime }
 func (fi *StaticFileInfo) IsDir() bool        { return fi.Dir }
 func (fi *StaticFileInfo) Sys() any           { return nil }
 
+// ETag implements webdav.ETager.
+func (fi *StaticFileInfo) ETag(_ context.Context) (string, error) {
+	if fi.ETagged == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return fi.ETagged, nil
+}
+
+// RenamedFileInfo returns a static copy of fi with the given name, keeping
+// its ETag, if any.
 func RenamedFileInfo(ctx context.Context, name string, fi fs.FileInfo) *StaticFileInfo {
 	var birthTime time.Time
 	var birthTimeErr error
@@ -57,7 +72,28 @@ func RenamedFileInfo(ctx context.Context, name string, fi fs.FileInfo) *StaticFi
 		BirthedTimeErr: birthTimeErr,
 		ModdedTime:     fi.ModTime(),
 		Dir:            fi.IsDir(),
+		ETagged:        etagOf(ctx, fi),
+	}
+}
+
+// etagOf returns the ETag of fi, or the empty string if it doesn't have one.
+// It understands both webdav.ETager and the ETag method of the FileInfos of
+// remote WebDAV servers, which returns the ETag without quotes.
+func etagOf(ctx context.Context, fi fs.FileInfo) string {
+	switch fi := fi.(type) {
+	case webdav.ETager:
+		if etag, err := fi.ETag(ctx); err == nil {
+			return etag
+		}
+	case interface{ ETag() string }:
+		if etag := fi.ETag(); etag != "" {
+			if strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, "W/") {
+				return etag
+			}
+			return strconv.Quote(etag)
+		}
 	}
+	return ""
 }
 
 // ReadOnlyDirInfo returns a static fs.FileInfo for a read-only directory
@@ -34,8 +34,12 @@
 	share11 = `share$%11`
 	share12 = `_share$%12`
 	file111 = `file$%111.txt`
+	share13 = `share$%13`
 )
 
+// principal is the node accessing the remotes in these tests.
+var principal = tailfs.Principal{NodeID: "nlocal", Tags: []string{"tag:test"}}
+
 func init() {
 	// set AllowShareAs() to false so that we don't try to use sub-processes
 	// for access files on disk.
@@ -82,10 +86,221 @@ func TestFileManipulation(t *testing.T) {
 	s.addShare(remote1, share12, tailfs.PermissionReadOnly)
 	s.writeFile("writing file to read-only remote should fail", remote1, share12, file111, "hello world", false)
 
+	s.addShare(remote1, share13, tailfs.PermissionReadWrite, &tailfs.ShareAccess{Tags: []string{"tag:test"}, Access: "ro"})
+	s.writeFile("writing file to share limited to read-only by its access should fail", remote1, share13, file111, "hello world", false)
+	s.checkDirList("share limited to read-only by its access should be listed", shared.Join(domain, remote1), share12, share11, share13)
+
 	s.writeFile("writing file to non-existent remote should fail", "non-existent", share11, file111, "hello world", false)
 	s.writeFile("writing file to non-existent share should fail", remote1, "non-existent", file111, "hello world", false)
 }
 
+func TestReadOnlySharesLocally(t *testing.T) {
+	s := newSystem(t)
+	defer s.stop()
+
+	s.addRemote(remote1)
+	s.addShare(remote1, share11, tailfs.PermissionReadWrite)
+	s.addShare(remote1, share12, tailfs.PermissionReadOnly)
+	s.addShare(remote1, share13, tailfs.PermissionReadWrite, &tailfs.ShareAccess{Tags: []string{"tag:test"}, Access: "ro"})
+
+	url := fmt.Sprintf("http://%s", s.remotes[remote1].l.Addr())
+	perms, err := tailfs.FetchPermissions(context.Background(), &http.Transport{DisableKeepAlives: true}, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPerms := tailfs.Permissions{
+		share11: tailfs.PermissionReadWrite,
+		share12: tailfs.PermissionReadOnly,
+		share13: tailfs.PermissionReadOnly,
+	}
+	if diff := cmp.Diff(wantPerms, perms); diff != "" {
+		t.Errorf("wrong permissions (-want, +got):\n%s", diff)
+	}
+
+	s.enforcePermissionsLocally(remote1)
+	start := time.Now()
+	s.writeFile("writing file to read/write share should succeed", remote1, share11, file111, "hello world", true)
+	s.writeFile("writing file to read-only share should fail", remote1, share12, file111, "hello world", false)
+	s.writeFile("writing file to share limited to read-only by its access should fail", remote1, share13, file111, "hello world", false)
+	ctx := context.Background()
+	// The WebDAV client reports the failure of MKCOL as success, so check
+	// the remote's disk instead.
+	s.fs.Mkdir(ctx, pathTo(remote1, share12, "dir"), 0755)
+	if _, err := os.Stat(filepath.Join(s.remotes[remote1].shares[share12], "dir")); !os.IsNotExist(err) {
+		t.Errorf("making directory in read-only share: %v; want it not to exist", err)
+	}
+	if err := s.fs.Rename(ctx, pathTo(remote1, share11, file111), pathTo(remote1, share12, file111)); err == nil {
+		t.Error("renaming file into read-only share succeeded")
+	}
+	s.checkDirList("read-only shares should be listed", shared.Join(domain, remote1), share12, share11, share13)
+
+	// The failed attempts never reached the remote.
+	var got []string
+	for _, e := range s.remotes[remote1].fs.AuditLog(start) {
+		got = append(got, e.Op+" "+e.Path)
+	}
+	want := []string{"PUT " + shared.Join(share11, file111)}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("wrong audit log (-want, +got):\n%s", diff)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	s := newSystem(t)
+	defer s.stop()
+
+	start := time.Now()
+	s.addRemote(remote1)
+	s.addShare(remote1, share11, tailfs.PermissionReadWrite)
+	s.addShare(remote1, share12, tailfs.PermissionReadOnly)
+	s.writeFile("writing file to read/write remote should succeed", remote1, share11, file111, "hello world", true)
+	s.checkFileContents(remote1, share11, file111)
+	s.writeFile("writing file to read-only remote should fail", remote1, share12, file111, "hello world", false)
+
+	type entry struct {
+		NodeID string
+		Op     string
+		Path   string
+		Bytes  int64
+		Status int
+	}
+	var got []entry
+	for _, e := range s.remotes[remote1].fs.AuditLog(start) {
+		got = append(got, entry{e.NodeID, e.Op, e.Path, e.Bytes, e.Status})
+	}
+	want := []entry{
+		{principal.NodeID, "PUT", shared.Join(share11, file111), 11, http.StatusCreated},
+		{principal.NodeID, "GET", shared.Join(share11, file111), 11, http.StatusOK},
+		{principal.NodeID, "PUT", shared.Join(share12, file111), 0, http.StatusForbidden},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("wrong audit log (-want, +got):\n%s", diff)
+	}
+	if got := s.remotes[remote1].fs.AuditLog(time.Now()); len(got) != 0 {
+		t.Errorf("got %d audit entries after now, want none", len(got))
+	}
+}
+
+func TestShareQuota(t *testing.T) {
+	s := newSystem(t)
+	defer s.stop()
+
+	s.addRemote(remote1)
+	s.addShare(remote1, share11, tailfs.PermissionReadWrite)
+	s.setQuota(remote1, share11, 20)
+	s.writeFile("writing file within quota should succeed", remote1, share11, "a.txt", "hello world", true)
+	s.writeFile("replacing file within quota should succeed", remote1, share11, "a.txt", "hello there", true)
+	s.writeFile("writing file beyond quota should fail", remote1, share11, "b.txt", "hello world", false)
+	s.checkFileContents(remote1, share11, "a.txt")
+
+	usage := s.remotes[remote1].fs.Usage(context.Background())
+	want := []*tailfs.ShareUsage{{
+		Name:         share11,
+		Quota:        20,
+		StoredBytes:  11,
+		BytesServed:  11,
+		BytesWritten: 22,
+	}}
+	if diff := cmp.Diff(want, usage); diff != "" {
+		t.Errorf("wrong usage (-want, +got):\n%s", diff)
+	}
+}
+
+func TestWatchChanges(t *testing.T) {
+	s := newSystem(t)
+	defer s.stop()
+
+	changed := make(chan string, 10)
+	unregister := s.local.fs.WatchChanges(func(name string) {
+		select {
+		case changed <- name:
+		default:
+		}
+	})
+	defer unregister()
+
+	s.addRemote(remote1)
+	s.addShare(remote1, share11, tailfs.PermissionReadWrite)
+	s.checkDirList("remote should contain its share", shared.Join(domain, remote1), share11)
+
+	// The remote is watched once it's been accessed, but the watch may not
+	// have been established before the first write, so keep writing until
+	// a change is seen.
+	want := shared.Join(domain, remote1, share11, file111)
+	deadline := time.After(10 * time.Second)
+	for {
+		s.writeFile("writing file to read/write remote should succeed", remote1, share11, file111, "hello world", true)
+		select {
+		case got := <-changed:
+			if got != want {
+				t.Fatalf("got change to %q, want %q", got, want)
+			}
+			return
+		case <-time.After(500 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("timed out waiting for change")
+		}
+	}
+}
+
+func TestWatchHostChanges(t *testing.T) {
+	oldInterval := sharePollInterval
+	sharePollInterval = 50 * time.Millisecond
+	defer func() { sharePollInterval = oldInterval }()
+
+	s := newSystem(t)
+	defer s.stop()
+
+	changed := make(chan string, 10)
+	unregister := s.local.fs.WatchChanges(func(name string) {
+		select {
+		case changed <- name:
+		default:
+		}
+	})
+	defer unregister()
+
+	s.addRemote(remote1)
+	s.addShare(remote1, share11, tailfs.PermissionReadWrite)
+	s.checkDirList("remote should contain its share", shared.Join(domain, remote1), share11)
+
+	// Change the file directly on the remote's filesystem, bypassing
+	// TailFS, until the remote's scan of its shares finds a change.
+	file := filepath.Join(s.remotes[remote1].shares[share11], file111)
+	want := shared.Join(domain, remote1, share11, file111)
+	deadline := time.After(10 * time.Second)
+	for i := 0; ; i++ {
+		if err := os.WriteFile(file, []byte(fmt.Sprint("hello ", i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-changed:
+			if got == want {
+				return
+			}
+		case <-time.After(500 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("timed out waiting for change")
+		}
+	}
+}
+
+func TestLargeFileRead(t *testing.T) {
+	s := newSystem(t)
+	defer s.stop()
+
+	s.addRemote(remote1)
+	s.addShare(remote1, share11, tailfs.PermissionReadWrite)
+
+	// big enough to be read in parallel chunks
+	b := make([]byte, 9<<20+123)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	s.writeFile("writing large file should succeed", remote1, share11, file111, string(b), true)
+	s.checkFileContents(remote1, share11, file111)
+}
+
 func TestFileOps(t *testing.T) {
 	ctx := context.Background()
 
@@ -269,7 +484,13 @@ type remote struct {
 	fileServer  *FileServer
 	shares      map[string]string
 	permissions map[string]tailfs.Permission
+	access      map[string][]*tailfs.ShareAccess
+	quotas      map[string]int64
 	mu          sync.RWMutex
+
+	// localPermissions is whether the local filesystem is given the
+	// remote's permissions, to enforce them itself.
+	localPermissions bool
 }
 
 func (r *remote) freeze() {
@@ -281,9 +502,14 @@ func (r *remote) unfreeze() {
 }
 
 func (r *remote) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == shared.ChangesPath {
+		// Don't hold up freezing the remote while long-polling.
+		r.fs.ServeHTTPWithPerms(principal, r.permissions, w, req)
+		return
+	}
 	r.mu.RLock()
 	defer r.mu.RUnlock()
-	r.fs.ServeHTTPWithPerms(r.permissions, w, req)
+	r.fs.ServeHTTPWithPerms(principal, r.permissions, w, req)
 }
 
 type system struct {
@@ -345,22 +571,42 @@ func (s *system) addRemote(name string) {
 		fs:          NewFileSystemForRemote(log.Printf),
 		shares:      make(map[string]string),
 		permissions: make(map[string]tailfs.Permission),
+		access:      make(map[string][]*tailfs.ShareAccess),
+		quotas:      make(map[string]int64),
 	}
 	r.fs.SetFileServerAddr(fileServer.Addr())
 	go http.Serve(l, r)
 	s.remotes[name] = r
+	s.setRemotes()
+}
 
+func (s *system) setRemotes() {
 	remotes := make([]*tailfs.Remote, 0, len(s.remotes))
 	for name, r := range s.remotes {
-		remotes = append(remotes, &tailfs.Remote{
-			Name: name,
-			URL:  fmt.Sprintf("http://%s", r.l.Addr()),
-		})
+		url := fmt.Sprintf("http://%s", r.l.Addr())
+		remote := &tailfs.Remote{Name: name, URL: url}
+		if r.localPermissions {
+			remote.Permissions = func(ctx context.Context) (tailfs.Permissions, error) {
+				return tailfs.FetchPermissions(ctx, &http.Transport{DisableKeepAlives: true}, url)
+			}
+		}
+		remotes = append(remotes, remote)
 	}
 	s.local.fs.SetRemotes(domain, remotes, &http.Transport{})
 }
 
-func (s *system) addShare(remoteName, shareName string, permission tailfs.Permission) {
+// enforcePermissionsLocally makes the local filesystem enforce the
+// permissions of the named remote itself.
+func (s *system) enforcePermissionsLocally(remoteName string) {
+	r, ok := s.remotes[remoteName]
+	if !ok {
+		s.t.Fatalf("unknown remote %q", remoteName)
+	}
+	r.localPermissions = true
+	s.setRemotes()
+}
+
+func (s *system) addShare(remoteName, shareName string, permission tailfs.Permission, access ...*tailfs.ShareAccess) {
 	r, ok := s.remotes[remoteName]
 	if !ok {
 		s.t.Fatalf("unknown remote %q", remoteName)
@@ -369,12 +615,27 @@ func (s *system) addShare(remoteName, shareName string, permission tailfs.Permis
 	f := s.t.TempDir()
 	r.shares[shareName] = f
 	r.permissions[shareName] = permission
+	r.access[shareName] = access
+	r.setShares()
+}
+
+func (s *system) setQuota(remoteName, shareName string, quota int64) {
+	r, ok := s.remotes[remoteName]
+	if !ok {
+		s.t.Fatalf("unknown remote %q", remoteName)
+	}
+	r.quotas[shareName] = quota
+	r.setShares()
+}
 
+func (r *remote) setShares() {
 	shares := make(map[string]*tailfs.Share, len(r.shares))
 	for shareName, folder := range r.shares {
 		shares[shareName] = &tailfs.Share{
-			Name: shareName,
-			Path: folder,
+			Name:   shareName,
+			Path:   folder,
+			Access: r.access[shareName],
+			Quota:  r.quotas[shareName],
 		}
 	}
 	r.fs.SetShares(shares)
@@ -0,0 +1,190 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tailfsimpl
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/tailfs"
+)
+
+var errTransferCanceled = errors.New("transfer canceled")
+
+// transfers tracks the files being read or written by local clients, so that
+// they can be shown and canceled. The zero value is ready for use.
+type transfers struct {
+	// mu guards the below values.
+	mu     sync.Mutex
+	lastID int64
+	active map[int64]*transfer
+}
+
+// transfer is an active transfer.
+type transfer struct {
+	id      int64
+	path    string
+	upload  bool
+	started time.Time
+	bytes   atomic.Int64
+	size    atomic.Int64 // or -1 if unknown
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+}
+
+// wrap returns an http.Handler that passes requests to h, tracking those
+// that read or write files.
+func (ts *transfers) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var upload bool
+		switch r.Method {
+		case "GET":
+		case "PUT":
+			upload = true
+		default:
+			h.ServeHTTP(w, r)
+			return
+		}
+		t := ts.start(r, upload)
+		defer ts.finish(t)
+		r = r.WithContext(t.ctx)
+		if upload {
+			r.Body = &transferReader{ReadCloser: r.Body, t: t}
+			h.ServeHTTP(w, r)
+		} else {
+			h.ServeHTTP(&transferResponseWriter{ResponseWriter: w, t: t}, r)
+		}
+	})
+}
+
+func (ts *transfers) start(r *http.Request, upload bool) *transfer {
+	t := &transfer{
+		path:    r.URL.Path,
+		upload:  upload,
+		started: time.Now(),
+	}
+	t.size.Store(-1)
+	if upload && r.ContentLength >= 0 {
+		t.size.Store(r.ContentLength)
+	}
+	t.ctx, t.cancel = context.WithCancelCause(r.Context())
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.lastID++
+	t.id = ts.lastID
+	if ts.active == nil {
+		ts.active = make(map[int64]*transfer)
+	}
+	ts.active[t.id] = t
+	return t
+}
+
+func (ts *transfers) finish(t *transfer) {
+	t.cancel(nil)
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	delete(ts.active, t.id)
+}
+
+// list returns the active transfers, sorted by ID.
+func (ts *transfers) list() []*tailfs.Transfer {
+	ts.mu.Lock()
+	active := make([]*transfer, 0, len(ts.active))
+	for _, t := range ts.active {
+		active = append(active, t)
+	}
+	ts.mu.Unlock()
+
+	now := time.Now()
+	res := make([]*tailfs.Transfer, 0, len(active))
+	for _, t := range active {
+		tr := &tailfs.Transfer{
+			ID:      t.id,
+			Path:    t.path,
+			Upload:  t.upload,
+			Started: t.started,
+			Bytes:   t.bytes.Load(),
+			Size:    t.size.Load(),
+		}
+		if d := now.Sub(t.started).Seconds(); d > 0 {
+			tr.BytesPerSecond = float64(tr.Bytes) / d
+		}
+		res = append(res, tr)
+	}
+	slices.SortFunc(res, func(a, b *tailfs.Transfer) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return res
+}
+
+// cancel cancels the transfer with the given ID, reporting whether there was
+// one.
+func (ts *transfers) cancel(id int64) bool {
+	ts.mu.Lock()
+	t, ok := ts.active[id]
+	ts.mu.Unlock()
+	if ok {
+		t.cancel(errTransferCanceled)
+	}
+	return ok
+}
+
+// transferReader is the body of an upload, counting the bytes read from it.
+// Once the transfer is canceled, reads fail.
+type transferReader struct {
+	io.ReadCloser
+	t *transfer
+}
+
+func (r *transferReader) Read(p []byte) (int, error) {
+	if err := context.Cause(r.t.ctx); err != nil {
+		return 0, err
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.t.bytes.Add(int64(n))
+	return n, err
+}
+
+// transferResponseWriter is the response to a download, counting the bytes
+// written to it. Once the transfer is canceled, writes fail.
+type transferResponseWriter struct {
+	http.ResponseWriter
+	t           *transfer
+	wroteHeader bool
+}
+
+func (w *transferResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if code == http.StatusOK || code == http.StatusPartialContent {
+		if n, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil {
+			w.t.size.Store(n)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *transferResponseWriter) Write(p []byte) (int, error) {
+	if err := context.Cause(w.t.ctx); err != nil {
+		return 0, err
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.t.bytes.Add(int64(n))
+	return n, err
+}
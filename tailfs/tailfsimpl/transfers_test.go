@@ -0,0 +1,68 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tailfsimpl
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTransfers(t *testing.T) {
+	var ts transfers
+	wrote := make(chan struct{})
+	resume := make(chan struct{})
+	writeErr := make(chan error, 1)
+	h := ts.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			w.Header().Set("Content-Length", "10")
+			w.Write([]byte("12345"))
+			close(wrote)
+			<-resume
+			_, err := w.Write([]byte("67890"))
+			writeErr <- err
+		case "PUT":
+			io.Copy(io.Discard, r.Body)
+		}
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/example.ts.net/host/share/file.txt", nil))
+	}()
+	<-wrote
+
+	// Requests that don't transfer files aren't tracked.
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PROPFIND", "/example.ts.net", nil))
+	// Finished transfers aren't listed.
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", "/example.ts.net/host/share/up.txt", strings.NewReader("abc")))
+
+	got := ts.list()
+	if len(got) != 1 {
+		t.Fatalf("got %d transfers, want 1", len(got))
+	}
+	tr := got[0]
+	if tr.ID != 1 || tr.Path != "/example.ts.net/host/share/file.txt" || tr.Upload || tr.Bytes != 5 || tr.Size != 10 {
+		t.Errorf("unexpected transfer %+v", tr)
+	}
+
+	if ts.cancel(2) {
+		t.Errorf("canceled finished transfer")
+	}
+	if !ts.cancel(tr.ID) {
+		t.Fatalf("failed to cancel transfer")
+	}
+	close(resume)
+	if err := <-writeErr; err != errTransferCanceled {
+		t.Errorf("write after cancel: got error %v, want %v", err, errTransferCanceled)
+	}
+	<-done
+	if got := ts.list(); len(got) != 0 {
+		t.Errorf("got %d transfers after cancel, want 0", len(got))
+	}
+}
@@ -0,0 +1,335 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tailfsimpl
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tailscale/xnet/webdav"
+	"tailscale.com/tailfs"
+	"tailscale.com/tailfs/tailfsimpl/shared"
+)
+
+const (
+	// usageScanInterval is how long the total size of the files in a share
+	// is trusted before the share is scanned again.
+	usageScanInterval = 5 * time.Minute
+
+	// usageScanTimeout bounds scans of shares done in the background.
+	usageScanTimeout = time.Minute
+)
+
+var (
+	// quotaUsedBytes and quotaAvailableBytes are the WebDAV properties
+	// reporting the usage of a share, as defined in RFC 4331.
+	quotaUsedBytes      = xml.Name{Space: "DAV:", Local: "quota-used-bytes"}
+	quotaAvailableBytes = xml.Name{Space: "DAV:", Local: "quota-available-bytes"}
+)
+
+var errQuotaExceeded = errors.New("share quota exceeded")
+
+// shareUsage tracks how much a share is being used. The total size of the
+// files in a share is found by scanning it periodically, and adjusted in the
+// meantime by the writes made through TailFS, so it's only approximate if the
+// files are also changed by other means.
+type shareUsage struct {
+	served  atomic.Int64
+	written atomic.Int64
+
+	// mu guards the below values.
+	mu       sync.Mutex
+	stored   int64     // total size of the files, if known
+	known    bool      // whether stored was ever scanned
+	stale    bool      // whether stored needs to be scanned again
+	scanned  time.Time // when stored was last scanned
+	scanning bool      // whether a background scan is running
+}
+
+// storedBytes returns the total size of the files in the share served by fs,
+// scanning it if the last scan is too old. If wait is false, any scan is done
+// in the background and the previous size returned. ok is false if the size
+// isn't known.
+func (u *shareUsage) storedBytes(ctx context.Context, fs webdav.FileSystem, wait bool) (n int64, ok bool) {
+	u.mu.Lock()
+	if u.known && !u.stale && time.Since(u.scanned) < usageScanInterval {
+		defer u.mu.Unlock()
+		return u.stored, true
+	}
+	if !wait {
+		defer u.mu.Unlock()
+		if !u.scanning {
+			u.scanning = true
+			go u.scanInBackground(fs)
+		}
+		return u.stored, u.known
+	}
+	u.mu.Unlock()
+
+	n, err := dirSize(ctx, fs, "/")
+	if err != nil {
+		return 0, false
+	}
+	u.mu.Lock()
+	u.setStoredLocked(n)
+	u.mu.Unlock()
+	return n, true
+}
+
+func (u *shareUsage) scanInBackground(fs webdav.FileSystem) {
+	ctx, cancel := context.WithTimeout(context.Background(), usageScanTimeout)
+	defer cancel()
+	n, err := dirSize(ctx, fs, "/")
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.scanning = false
+	if err == nil {
+		u.setStoredLocked(n)
+	}
+}
+
+func (u *shareUsage) setStoredLocked(n int64) {
+	u.stored = n
+	u.known = true
+	u.stale = false
+	u.scanned = time.Now()
+}
+
+// adjust adds delta to the total size of the files in the share.
+func (u *shareUsage) adjust(delta int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.stored = max(u.stored+delta, 0)
+}
+
+// invalidate marks the total size of the files in the share as needing to be
+// scanned again.
+func (u *shareUsage) invalidate() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.stale = true
+}
+
+// dirSize returns the total size of the files in the named directory of fs
+// and its subdirectories.
+func dirSize(ctx context.Context, fs webdav.FileSystem, name string) (int64, error) {
+	f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	fis, err := f.Readdir(0)
+	f.Close()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, fi := range fis {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		if !fi.IsDir() {
+			total += fi.Size()
+			continue
+		}
+		n, err := dirSize(ctx, fs, path.Join(name, fi.Name()))
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// Usage implements tailfs.FileSystemForRemote.
+func (s *FileSystemForRemote) Usage(ctx context.Context) []*tailfs.ShareUsage {
+	s.mu.RLock()
+	shares := s.shares
+	fileSystems := s.fileSystems
+	usages := s.usages
+	s.mu.RUnlock()
+
+	result := make([]*tailfs.ShareUsage, 0, len(shares))
+	for name, share := range shares {
+		u, fs := usages[name], fileSystems[name]
+		if u == nil || fs == nil {
+			continue
+		}
+		stored, ok := u.storedBytes(ctx, fs, true)
+		if !ok {
+			stored = -1
+		}
+		result = append(result, &tailfs.ShareUsage{
+			Name:         name,
+			Quota:        max(share.Quota, 0),
+			StoredBytes:  stored,
+			BytesServed:  u.served.Load(),
+			BytesWritten: u.written.Load(),
+		})
+	}
+	slices.SortFunc(result, func(a, b *tailfs.ShareUsage) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return result
+}
+
+// buildUsages returns the shareUsages for shares, reusing those in old for
+// shares whose path hasn't changed.
+func buildUsages(shares, oldShares map[string]*tailfs.Share, old map[string]*shareUsage) map[string]*shareUsage {
+	usages := make(map[string]*shareUsage, len(shares))
+	for name, share := range shares {
+		if u, ok := old[name]; ok && oldShares[name] != nil && oldShares[name].Path == share.Path {
+			usages[name] = u
+			continue
+		}
+		usages[name] = new(shareUsage)
+	}
+	return usages
+}
+
+// admitPut checks whether the PUT request r fits within the quota of share,
+// whose files are served by fs, and responds with an error if not. If the size
+// of the upload isn't known in advance, r.Body is replaced by the returned
+// quotaReader so that reading past the quota fails. It returns the size of the
+// file being replaced, if any.
+func admitPut(w http.ResponseWriter, r *http.Request, share *tailfs.Share, fs webdav.FileSystem, u *shareUsage) (replaced int64, qr *quotaReader, ok bool) {
+	name := shared.Join(shared.CleanAndSplit(r.URL.Path)[1:]...)
+	if fi, err := fs.Stat(r.Context(), name); err == nil && !fi.IsDir() {
+		replaced = fi.Size()
+	}
+	if share.Quota <= 0 {
+		return replaced, nil, true
+	}
+	stored, known := u.storedBytes(r.Context(), fs, true)
+	if !known {
+		http.Error(w, "unable to determine share usage", http.StatusInternalServerError)
+		return 0, nil, false
+	}
+	available := share.Quota - stored + replaced
+	if r.ContentLength > available {
+		http.Error(w, errQuotaExceeded.Error(), http.StatusInsufficientStorage)
+		return 0, nil, false
+	}
+	if r.ContentLength < 0 {
+		qr = &quotaReader{ReadCloser: r.Body, remaining: available}
+		r.Body = qr
+	}
+	return replaced, qr, true
+}
+
+// quotaReader is a request body that fails once more than remaining bytes
+// have been read from it. Whatever was written of the file by then has to be
+// removed.
+type quotaReader struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+func (qr *quotaReader) Read(p []byte) (int, error) {
+	if qr.exceeded {
+		return 0, errQuotaExceeded
+	}
+	n, err := qr.ReadCloser.Read(p)
+	if int64(n) > qr.remaining {
+		qr.exceeded = true
+		n = int(qr.remaining)
+		err = errQuotaExceeded
+	}
+	qr.remaining -= int64(n)
+	return n, err
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// countingResponseWriter is an http.ResponseWriter that counts the bytes of
+// the response body.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n *atomic.Int64
+}
+
+func (cw *countingResponseWriter) Write(b []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(b)
+	cw.n.Add(int64(n))
+	return n, err
+}
+
+// usageFS extends the webdav.FileSystem of a share so that its root directory
+// reports the share's usage through the quota properties of RFC 4331.
+type usageFS struct {
+	webdav.FileSystem
+	share *tailfs.Share
+	usage *shareUsage
+}
+
+func (fs *usageFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	f, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil || !shared.IsRoot(name) {
+		return f, err
+	}
+	return &usageDir{File: f, fs: fs}, nil
+}
+
+// usageDir is the root directory of a share, implementing
+// webdav.DeadPropsHolder to report the share's usage.
+type usageDir struct {
+	webdav.File
+	fs *usageFS
+}
+
+// DeadProps implements webdav.DeadPropsHolder. It doesn't wait for the share
+// to be scanned, leaving out the quota properties if its usage isn't known.
+func (d *usageDir) DeadProps() (map[xml.Name]webdav.Property, error) {
+	used, ok := d.fs.usage.storedBytes(context.Background(), d.fs.FileSystem, false)
+	if !ok {
+		return nil, nil
+	}
+	props := map[xml.Name]webdav.Property{
+		quotaUsedBytes: {
+			XMLName:  quotaUsedBytes,
+			InnerXML: []byte(strconv.FormatInt(used, 10)),
+		},
+	}
+	if quota := d.fs.share.Quota; quota > 0 {
+		props[quotaAvailableBytes] = webdav.Property{
+			XMLName:  quotaAvailableBytes,
+			InnerXML: []byte(strconv.FormatInt(max(quota-used, 0), 10)),
+		}
+	}
+	return props, nil
+}
+
+// Patch implements webdav.DeadPropsHolder. The properties of a share's root
+// directory can't be changed.
+func (d *usageDir) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	pstat := webdav.Propstat{Status: http.StatusForbidden}
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			pstat.Props = append(pstat.Props, webdav.Property{XMLName: p.XMLName})
+		}
+	}
+	return []webdav.Propstat{pstat}, nil
+}
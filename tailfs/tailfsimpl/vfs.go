@@ -0,0 +1,206 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tailfsimpl
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tailscale/xnet/webdav"
+)
+
+// vfsHeader is the header that POST requests to vfsHandler must have.
+// POST, unlike PUT and DELETE, is a method browsers let any web page send
+// cross-site without asking the server first, but not with this header,
+// which web pages can't set at all, so that they can't create or rename
+// files through the API.
+const vfsHeader = "Sec-Tailscale-VFS"
+
+// vfsEntry describes a file or directory in the responses of vfsHandler.
+type vfsEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	IsDir   bool      `json:"isDir,omitempty"`
+}
+
+func newVFSEntry(fi fs.FileInfo) vfsEntry {
+	return vfsEntry{
+		Name:    fi.Name(),
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		IsDir:   fi.IsDir(),
+	}
+}
+
+// vfsHandler serves a filesystem over a plain HTTP API meant for virtual
+// filesystem providers on the local machine, such as one built on the
+// Windows Cloud Files API, which present it to applications as regular
+// files. Unlike the Windows WebDAV redirector, they don't limit file sizes,
+// and can fetch just the parts of files that are read.
+//
+// Paths are those of the files and directories in the filesystem. The API
+// is:
+//
+//	GET    /path                   the contents of the file; Range requests are supported
+//	PUT    /path                   replaces the contents of the file with the body
+//	DELETE /path                   removes the file or directory and its contents
+//	POST   /path?op=stat           the vfsEntry of the file or directory, as JSON
+//	POST   /path?op=list           the vfsEntries of the directory's children, as JSON
+//	POST   /path?op=mkdir          creates the directory
+//	POST   /path?op=rename&to=/new renames the file or directory
+//
+// POST requests must have the Sec-Tailscale-VFS header set, to any value,
+// or are refused with 403 Forbidden.
+//
+// Errors are reported with 404 Not Found if the file or its parent doesn't
+// exist, 403 Forbidden if it can't be accessed, 409 Conflict if it already
+// exists, and 500 Internal Server Error otherwise.
+type vfsHandler struct {
+	fs webdav.FileSystem
+}
+
+func (h *vfsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	var err error
+	switch r.Method {
+	case "GET", "HEAD":
+		err = h.serveFile(w, r, name)
+	case "PUT":
+		err = h.writeFile(r, name)
+		if err == nil {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case "DELETE":
+		if _, err = h.fs.Stat(r.Context(), name); err == nil {
+			err = h.fs.RemoveAll(r.Context(), name)
+		}
+		if err == nil {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case "POST":
+		if r.Header.Get(vfsHeader) == "" {
+			http.Error(w, "missing "+vfsHeader+" header", http.StatusForbidden)
+			return
+		}
+		err = h.serveOp(w, r, name)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		vfsError(w, err)
+	}
+}
+
+// serveFile serves the contents of the named file.
+func (h *vfsHandler) serveFile(w http.ResponseWriter, r *http.Request, name string) error {
+	f, err := h.fs.OpenFile(r.Context(), name, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		http.Error(w, "is a directory", http.StatusBadRequest)
+		return nil
+	}
+	// Don't let ServeContent read the file to sniff its type.
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, name, fi.ModTime(), f)
+	return nil
+}
+
+// writeFile replaces the contents of the named file with the request body.
+func (h *vfsHandler) writeFile(r *http.Request, name string) error {
+	f, err := h.fs.OpenFile(r.Context(), name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// serveOp serves the operations other than reading and writing files, which
+// are requested with POST and the op parameter.
+func (h *vfsHandler) serveOp(w http.ResponseWriter, r *http.Request, name string) error {
+	ctx := r.Context()
+	switch op := r.URL.Query().Get("op"); op {
+	case "stat":
+		fi, err := h.fs.Stat(ctx, name)
+		if err != nil {
+			return err
+		}
+		writeVFSJSON(w, newVFSEntry(fi))
+	case "list":
+		f, err := h.fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+		if err != nil {
+			return err
+		}
+		fis, err := f.Readdir(0)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		entries := make([]vfsEntry, 0, len(fis))
+		for _, fi := range fis {
+			entries = append(entries, newVFSEntry(fi))
+		}
+		slices.SortFunc(entries, func(a, b vfsEntry) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+		writeVFSJSON(w, entries)
+	case "mkdir":
+		if err := h.fs.Mkdir(ctx, name, 0755); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusCreated)
+	case "rename":
+		to := r.URL.Query().Get("to")
+		if to == "" {
+			http.Error(w, "missing to", http.StatusBadRequest)
+			return nil
+		}
+		if err := h.fs.Rename(ctx, name, path.Clean("/"+to)); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unknown op "+op, http.StatusBadRequest)
+	}
+	return nil
+}
+
+func writeVFSJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// vfsError reports err, returned by the filesystem, to the client.
+func vfsError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, fs.ErrExist):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
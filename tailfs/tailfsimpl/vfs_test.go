@@ -0,0 +1,98 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tailfsimpl
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tailscale/xnet/webdav"
+)
+
+func TestVFSHandler(t *testing.T) {
+	dir := t.TempDir()
+	ts := httptest.NewServer(&vfsHandler{fs: webdav.Dir(dir)})
+	defer ts.Close()
+
+	do := func(method, p, body string, hdr ...string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+p, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if method == "POST" {
+			req.Header.Set(vfsHeader, "1")
+		}
+		for i := 0; i < len(hdr); i += 2 {
+			req.Header.Set(hdr[i], hdr[i+1])
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(b)
+	}
+	check := func(method, p, body string, wantStatus int, wantBody string, hdr ...string) {
+		t.Helper()
+		status, got := do(method, p, body, hdr...)
+		if status != wantStatus {
+			t.Errorf("%s %s: status %d, want %d (%s)", method, p, status, wantStatus, got)
+		} else if wantBody != "" && got != wantBody {
+			t.Errorf("%s %s: got %q, want %q", method, p, got, wantBody)
+		}
+	}
+
+	// POSTs without the header, as sent cross-site by web pages, are refused.
+	check("POST", "/dir?op=mkdir", "", http.StatusForbidden, "", vfsHeader, "")
+	if _, err := os.Stat(filepath.Join(dir, "dir")); !os.IsNotExist(err) {
+		t.Errorf("mkdir without %s: dir exists: %v", vfsHeader, err)
+	}
+	check("POST", "/dir?op=mkdir", "", http.StatusCreated, "")
+	check("POST", "/dir?op=mkdir", "", http.StatusConflict, "")
+	check("PUT", "/dir/file.txt", "hello world", http.StatusNoContent, "")
+	check("PUT", "/missing/file.txt", "hello world", http.StatusNotFound, "")
+	check("GET", "/dir/file.txt", "", http.StatusOK, "hello world")
+	check("GET", "/dir/file.txt", "", http.StatusPartialContent, "world", "Range", "bytes=6-")
+	check("GET", "/dir", "", http.StatusBadRequest, "")
+	check("GET", "/dir/missing.txt", "", http.StatusNotFound, "")
+
+	_, body := do("POST", "/dir?op=list", "")
+	var entries []vfsEntry
+	if err := json.Unmarshal([]byte(body), &entries); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != "file.txt" || entries[0].Size != 11 || entries[0].IsDir {
+		t.Errorf("list: got %+v", entries)
+	}
+	_, body = do("POST", "/dir?op=stat", "")
+	var e vfsEntry
+	if err := json.Unmarshal([]byte(body), &e); err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if e.Name != "dir" || !e.IsDir {
+		t.Errorf("stat: got %+v", e)
+	}
+	check("POST", "/dir?op=frob", "", http.StatusBadRequest, "")
+
+	check("POST", "/dir/file.txt?op=rename&to=/dir/renamed.txt", "", http.StatusNoContent, "")
+	if _, err := os.Stat(filepath.Join(dir, "dir", "renamed.txt")); err != nil {
+		t.Errorf("rename: %v", err)
+	}
+	check("DELETE", "/dir", "", http.StatusNoContent, "")
+	check("DELETE", "/dir", "", http.StatusNotFound, "")
+	if _, err := os.Stat(filepath.Join(dir, "dir")); !os.IsNotExist(err) {
+		t.Errorf("delete: dir still exists: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package webdavfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// cachedFile is a webdav.File reading a remote file's contents from a
+// ContentCache.
+type cachedFile struct {
+	*os.File
+	fi fs.FileInfo // of the remote file
+}
+
+// Readdir implements webdav.File. Since this is a file, it always fails with
+// an os.PathError.
+func (f *cachedFile) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, &os.PathError{
+		Op:   "readdir",
+		Path: f.fi.Name(),
+		Err:  errors.New("is a file"),
+	}
+}
+
+// Stat implements webdav.File, returning the FileInfo of the remote file.
+func (f *cachedFile) Stat() (fs.FileInfo, error) {
+	return f.fi, nil
+}
+
+// Write implements webdav.File. As this file is read-only, it always fails
+// with an os.PathError.
+func (f *cachedFile) Write(p []byte) (int, error) {
+	return 0, &os.PathError{
+		Op:   "write",
+		Path: f.fi.Name(),
+		Err:  errors.New("read-only"),
+	}
+}
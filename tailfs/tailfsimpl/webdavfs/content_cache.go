@@ -0,0 +1,320 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package webdavfs
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentCache is an on-disk cache of the contents of remote files, so that
+// files read repeatedly don't have to be downloaded again each time. A cached
+// file is only used while its ETag (or if the server doesn't provide one, its
+// modification time and size) is unchanged. When the cache is full, the least
+// recently used files are evicted.
+//
+// Each cached file is stored in a file named after the hash of its key,
+// alongside a metaSuffix file with its cacheEntry, so that the cache
+// survives restarts.
+//
+// A ContentCache can be shared by several filesystems, as long as each uses a
+// different URL.
+type ContentCache struct {
+	dir     string
+	maxSize int64
+
+	// mu guards the below values.
+	mu      sync.Mutex
+	entries map[string]*list.Element // key -> element with *cacheEntry
+	lru     list.List                // of *cacheEntry, most recently used first
+	size    int64                    // total size of cached files
+	nextTmp int
+}
+
+// cacheEntry describes a cached file. It's stored in its metaSuffix file.
+type cacheEntry struct {
+	Key     string
+	Version string
+	Size    int64
+}
+
+// metaSuffix is the suffix of the files in which the cacheEntry of each
+// cached file is stored, as JSON.
+const metaSuffix = ".meta"
+
+// NewContentCache returns a ContentCache keeping at most maxSize bytes of
+// files in dir, which is created if needed.
+//
+// The files already cached in dir are kept, and like any others only used
+// if they're still current when they're next read. Those that are corrupt
+// or too big for maxSize are removed, as are the least recently used ones
+// if there are more than maxSize bytes of them.
+func NewContentCache(dir string, maxSize int64) (*ContentCache, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("invalid content cache size %d", maxSize)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	c := &ContentCache{
+		dir:     dir,
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load adds the files cached in c.dir by a previous ContentCache to c,
+// removing anything else there.
+func (c *ContentCache) load() error {
+	des, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	type loaded struct {
+		ent  *cacheEntry
+		used time.Time
+	}
+	var found []loaded
+	valid := make(map[string]bool) // names of files in c.dir to keep
+	for _, de := range des {
+		name, ok := strings.CutSuffix(de.Name(), metaSuffix)
+		if !ok || !de.Type().IsRegular() {
+			continue
+		}
+		ent, used, err := c.loadEntry(name)
+		if err != nil {
+			continue
+		}
+		valid[name] = true
+		valid[de.Name()] = true
+		found = append(found, loaded{ent, used})
+	}
+	for _, de := range des {
+		if !valid[de.Name()] {
+			// Incomplete fills, contents without metadata and
+			// vice versa, and corrupt files.
+			os.RemoveAll(filepath.Join(c.dir, de.Name()))
+		}
+	}
+
+	// Most recently used first, as in c.lru.
+	slices.SortFunc(found, func(a, b loaded) int {
+		return b.used.Compare(a.used)
+	})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, l := range found {
+		c.entries[l.ent.Key] = c.lru.PushBack(l.ent)
+		c.size += l.ent.Size
+	}
+	for c.size > c.maxSize {
+		c.removeLocked(c.lru.Back())
+	}
+	return nil
+}
+
+// loadEntry returns the cacheEntry of the cached file stored as name in
+// c.dir, and when it was last used, or an error if it's corrupt or too big.
+func (c *ContentCache) loadEntry(name string) (ent *cacheEntry, used time.Time, err error) {
+	b, err := os.ReadFile(filepath.Join(c.dir, name+metaSuffix))
+	if err != nil {
+		return nil, used, err
+	}
+	if err := json.Unmarshal(b, &ent); err != nil {
+		return nil, used, err
+	}
+	if ent == nil || filepath.Base(c.path(ent.Key)) != name {
+		return nil, used, fmt.Errorf("content cache entry %q doesn't match its name", name)
+	}
+	if ent.Size > c.maxFileSize() {
+		return nil, used, fmt.Errorf("content cache entry %q is too big", name)
+	}
+	fi, err := os.Stat(filepath.Join(c.dir, name))
+	if err != nil {
+		return nil, used, err
+	}
+	if !fi.Mode().IsRegular() || fi.Size() != ent.Size {
+		return nil, used, fmt.Errorf("content cache entry %q is corrupt", name)
+	}
+	return ent, fi.ModTime(), nil
+}
+
+// maxFileSize is the size of the largest file that c caches, so that one
+// file can't evict everything else.
+func (c *ContentCache) maxFileSize() int64 {
+	return c.maxSize / 4
+}
+
+// fileVersion returns a string that changes whenever the contents of the file
+// described by fi do.
+func fileVersion(fi fs.FileInfo) string {
+	if e, ok := fi.(interface{ ETag() string }); ok {
+		if etag := e.ETag(); etag != "" {
+			return "etag:" + etag
+		}
+	}
+	return fmt.Sprintf("mod:%d:%d", fi.ModTime().UnixNano(), fi.Size())
+}
+
+func (c *ContentCache) path(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(h[:]))
+}
+
+// open returns the cached contents of the file with the given key if they're
+// of the given version, or nil if they aren't cached.
+func (c *ContentCache) open(key, version string) *os.File {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if el.Value.(*cacheEntry).Version != version {
+		c.removeLocked(el)
+		return nil
+	}
+	f, err := os.Open(c.path(key))
+	if err != nil {
+		c.removeLocked(el)
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	// Record the use, for the order of the entries after a restart.
+	now := time.Now()
+	os.Chtimes(f.Name(), now, now)
+	return f
+}
+
+// startFill returns a cacheFill for adding the contents of the file with the
+// given key, version and size to c, or nil if it's too big to cache.
+func (c *ContentCache) startFill(key, version string, size int64) *cacheFill {
+	if size > c.maxFileSize() {
+		return nil
+	}
+	c.mu.Lock()
+	c.nextTmp++
+	tmp := filepath.Join(c.dir, fmt.Sprintf("tmp-%d", c.nextTmp))
+	c.mu.Unlock()
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil
+	}
+	return &cacheFill{
+		c:       c,
+		f:       f,
+		key:     key,
+		version: version,
+		size:    size,
+	}
+}
+
+// add adds the file at tmp to c as the contents of key.
+func (c *ContentCache) add(tmp, key, version string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.removeLocked(el)
+	}
+	for c.size+size > c.maxSize && c.lru.Len() > 0 {
+		c.removeLocked(c.lru.Back())
+	}
+	ent := &cacheEntry{Key: key, Version: version, Size: size}
+	meta, err := json.Marshal(ent)
+	if err != nil {
+		os.Remove(tmp)
+		return
+	}
+	p := c.path(key)
+	if err := os.Rename(tmp, p); err != nil {
+		os.Remove(tmp)
+		return
+	}
+	// Write the metadata after the contents, so that the contents are
+	// complete if it exists.
+	if err := os.WriteFile(tmp, meta, 0600); err != nil {
+		os.Remove(tmp)
+		os.Remove(p)
+		return
+	}
+	if err := os.Rename(tmp, p+metaSuffix); err != nil {
+		os.Remove(tmp)
+		os.Remove(p)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(ent)
+	c.size += size
+}
+
+// invalidate removes the file with the given key from c, along with any files
+// under it if it's a directory.
+func (c *ContentCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefix := strings.TrimSuffix(key, "/") + "/"
+	for k, el := range c.entries {
+		if k == key || strings.HasPrefix(k, prefix) {
+			c.removeLocked(el)
+		}
+	}
+}
+
+func (c *ContentCache) removeLocked(el *list.Element) {
+	ent := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, ent.Key)
+	c.size -= ent.Size
+	p := c.path(ent.Key)
+	os.Remove(p + metaSuffix)
+	os.Remove(p)
+}
+
+// cacheFill copies the contents of a file into a ContentCache as it's read.
+// The file has to be read sequentially from the start.
+type cacheFill struct {
+	c       *ContentCache
+	f       *os.File
+	key     string
+	version string
+	size    int64
+	written int64
+	failed  bool
+}
+
+// write adds b, the next bytes of the file, to the cached contents.
+func (cf *cacheFill) write(b []byte) {
+	if cf.failed {
+		return
+	}
+	n, err := cf.f.Write(b)
+	cf.written += int64(n)
+	if err != nil || cf.written > cf.size {
+		cf.failed = true
+	}
+}
+
+// finish completes the fill, adding the file to the cache if all of it was
+// written, or discarding it otherwise.
+func (cf *cacheFill) finish() {
+	tmp := cf.f.Name()
+	err := cf.f.Close()
+	if err != nil || cf.failed || cf.written != cf.size {
+		os.Remove(tmp)
+		return
+	}
+	cf.c.add(tmp, cf.key, cf.version, cf.size)
+}
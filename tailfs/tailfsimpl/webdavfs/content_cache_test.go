@@ -0,0 +1,193 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package webdavfs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fillCache adds contents to c as the given version of key.
+func fillCache(t *testing.T, c *ContentCache, key, version, contents string) {
+	t.Helper()
+	cf := c.startFill(key, version, int64(len(contents)))
+	if cf == nil {
+		t.Fatalf("startFill(%q) = nil", key)
+	}
+	cf.write([]byte(contents))
+	cf.finish()
+}
+
+// readCache returns the contents cached in c for the given version of key,
+// and whether there were any.
+func readCache(t *testing.T, c *ContentCache, key, version string) (string, bool) {
+	t.Helper()
+	f := c.open(key, version)
+	if f == nil {
+		return "", false
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b), true
+}
+
+func TestContentCache(t *testing.T) {
+	c, err := NewContentCache(t.TempDir(), 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fill := func(key, version, contents string) {
+		t.Helper()
+		fillCache(t, c, key, version, contents)
+	}
+	read := func(key, version string) (string, bool) {
+		t.Helper()
+		return readCache(t, c, key, version)
+	}
+
+	a := strings.Repeat("a", 100)
+	fill("/s/a", "v1", a)
+	if got, ok := read("/s/a", "v1"); !ok || got != a {
+		t.Fatalf("read = %q, %v; want cached contents", got, ok)
+	}
+	if _, ok := read("/s/a", "v2"); ok {
+		t.Fatal("read of changed file hit the cache")
+	}
+	if _, ok := read("/s/a", "v1"); ok {
+		t.Fatal("changed file still cached")
+	}
+
+	// Incomplete fills aren't cached.
+	cf := c.startFill("/s/short", "v1", 10)
+	cf.write([]byte("12345"))
+	cf.finish()
+	if _, ok := read("/s/short", "v1"); ok {
+		t.Error("incomplete file cached")
+	}
+
+	// Files bigger than a quarter of the cache aren't cached.
+	if cf := c.startFill("/s/big", "v1", 101); cf != nil {
+		t.Error("startFill of too big file succeeded")
+	}
+
+	// Filling up the cache evicts the least recently used files.
+	for _, key := range []string{"/s/1", "/s/2", "/s/3", "/s/4"} {
+		fill(key, "v1", a)
+	}
+	read("/s/1", "v1")
+	fill("/s/5", "v1", a)
+	if _, ok := read("/s/2", "v1"); ok {
+		t.Error("least recently used file not evicted")
+	}
+	if _, ok := read("/s/1", "v1"); !ok {
+		t.Error("recently used file evicted")
+	}
+	if c.size > c.maxSize {
+		t.Errorf("cache size %d > max %d", c.size, c.maxSize)
+	}
+
+	// Invalidating a directory removes the files in it.
+	c.invalidate("/s")
+	if len(c.entries) != 0 || c.size != 0 {
+		t.Errorf("%d entries (%d bytes) left after invalidating all", len(c.entries), c.size)
+	}
+	ents, err := os.ReadDir(c.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != 0 {
+		t.Errorf("%d files left in cache dir", len(ents))
+	}
+}
+
+func TestContentCacheReload(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewContentCache(dir, 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Six files of 50 bytes, /s/1 the least recently used, plus one that
+	// will be too big for the smaller cache below.
+	a := strings.Repeat("a", 50)
+	base := time.Now().Add(-time.Hour)
+	for i, key := range []string{"/s/1", "/s/2", "/s/3", "/s/4", "/s/5", "/s/6", "/s/big"} {
+		contents := a
+		if key == "/s/big" {
+			contents = strings.Repeat("b", 60)
+		}
+		fillCache(t, c, key, "v1", contents)
+		used := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(c.path(key), used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Corrupt /s/6, and leave behind a fill and contents without metadata.
+	if err := os.Truncate(c.path("/s/6"), 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tmp-1"), []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(c.path("/s/orphan"), []byte(a), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restarting with a smaller cache keeps the valid files that fit.
+	c, err = NewContentCache(dir, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.size != 200 {
+		t.Errorf("cache size %d after reload; want 200", c.size)
+	}
+	for _, key := range []string{"/s/1", "/s/6", "/s/big", "/s/orphan"} {
+		if _, ok := readCache(t, c, key, "v1"); ok {
+			t.Errorf("%s still cached after reload", key)
+		}
+	}
+	for _, key := range []string{"/s/4", "/s/5", "/s/2"} {
+		if got, ok := readCache(t, c, key, "v1"); !ok || got != a {
+			t.Errorf("read %s = %q, %v; want cached contents", key, got, ok)
+		}
+	}
+	// Files changed on the remote meanwhile are revalidated on first use.
+	if _, ok := readCache(t, c, "/s/3", "v2"); ok {
+		t.Error("read of changed file hit the cache after reload")
+	}
+
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 2 * len(c.entries); len(ents) != want {
+		var names []string
+		for _, de := range ents {
+			names = append(names, de.Name())
+		}
+		t.Errorf("cache dir has %d files %q; want %d, the contents and metadata of each entry", len(ents), names, want)
+	}
+
+	// The order of use survives restarts too: /s/2 was used last.
+	c, err = NewContentCache(dir, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		order = append(order, el.Value.(*cacheEntry).Key)
+	}
+	if want := []string{"/s/2", "/s/5", "/s/4"}; !slices.Equal(order, want) {
+		t.Errorf("entries after reload, most recently used first: %q; want %q", order, want)
+	}
+}
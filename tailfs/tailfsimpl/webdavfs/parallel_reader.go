@@ -0,0 +1,135 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package webdavfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+)
+
+const (
+	// readChunkSize is the size of the chunks in which large files are
+	// fetched in parallel.
+	readChunkSize = 4 << 20
+
+	// minParallelReadSize is the smallest amount of a file left to read for
+	// which chunks are fetched in parallel.
+	minParallelReadSize = 2 * readChunkSize
+)
+
+// parallelReader reads a remote file sequentially from an offset, fetching
+// up to readAhead chunks of it in parallel with HTTP range requests. This
+// improves throughput over high-latency connections, on which a single
+// stream is limited by its TCP window.
+type parallelReader struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	fetch     func(ctx context.Context, off, n int64) ([]byte, error)
+	size      int64 // of the file
+	readAhead int
+
+	next    int64    // offset of the next chunk to fetch
+	pending []*chunk // chunks being fetched, in order
+	cur     []byte   // unread part of the current chunk
+}
+
+// chunk is a part of a file being fetched.
+type chunk struct {
+	done chan struct{} // closed when data or err is set
+	data []byte
+	err  error
+}
+
+func newParallelReader(fetch func(ctx context.Context, off, n int64) ([]byte, error), off, size int64, readAhead int) *parallelReader {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &parallelReader{
+		ctx:       ctx,
+		cancel:    cancel,
+		fetch:     fetch,
+		size:      size,
+		readAhead: readAhead,
+		next:      off,
+	}
+}
+
+// Read implements io.Reader.
+func (pr *parallelReader) Read(p []byte) (int, error) {
+	for len(pr.cur) == 0 {
+		pr.startFetches()
+		if len(pr.pending) == 0 {
+			return 0, io.EOF
+		}
+		c := pr.pending[0]
+		select {
+		case <-c.done:
+		case <-pr.ctx.Done():
+			return 0, pr.ctx.Err()
+		}
+		if c.err != nil {
+			return 0, c.err
+		}
+		pr.pending = pr.pending[1:]
+		pr.cur = c.data
+	}
+	n := copy(p, pr.cur)
+	pr.cur = pr.cur[n:]
+	pr.startFetches()
+	return n, nil
+}
+
+// startFetches starts fetching chunks until readAhead of them are pending or
+// the end of the file is reached.
+func (pr *parallelReader) startFetches() {
+	for len(pr.pending) < pr.readAhead && pr.next < pr.size {
+		off, n := pr.next, min(readChunkSize, pr.size-pr.next)
+		c := &chunk{done: make(chan struct{})}
+		go func() {
+			defer close(c.done)
+			c.data, c.err = pr.fetch(pr.ctx, off, n)
+		}()
+		pr.pending = append(pr.pending, c)
+		pr.next += n
+	}
+}
+
+// Close implements io.Closer, canceling any pending fetches.
+func (pr *parallelReader) Close() error {
+	pr.cancel()
+	return nil
+}
+
+// fetchRange fetches n bytes of the named file starting at off, using an HTTP
+// range request.
+func (wfs *webdavFS) fetchRange(ctx context.Context, name string, off, n int64) ([]byte, error) {
+	u := wfs.url + (&url.URL{Path: path.Clean("/" + name)}).EscapedPath()
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	resp, err := wfs.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusNotFound:
+		return nil, os.ErrNotExist
+	default:
+		// Including 200 OK from servers that ignore ranges, as we'd have
+		// to read the whole file to get to ours.
+		return nil, fmt.Errorf("unexpected status fetching range of %v: %v", name, resp.Status)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(resp.Body, b); err != nil {
+		return nil, fmt.Errorf("reading range of %v: %w", name, err)
+	}
+	return b, nil
+}
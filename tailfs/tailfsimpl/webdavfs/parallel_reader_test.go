@@ -0,0 +1,66 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package webdavfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParallelReader(t *testing.T) {
+	data := make([]byte, 3*readChunkSize+12345)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	var inFlight, maxInFlight atomic.Int32
+	fetch := func(ctx context.Context, off, n int64) ([]byte, error) {
+		cur := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if cur <= m || maxInFlight.CompareAndSwap(m, cur) {
+				break
+			}
+		}
+		return bytes.Clone(data[off : off+n]), nil
+	}
+
+	for _, off := range []int64{0, 100, readChunkSize + 1} {
+		pr := newParallelReader(fetch, off, int64(len(data)), 2)
+		got, err := io.ReadAll(pr)
+		pr.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data[off:]) {
+			t.Errorf("reading from %d: got %d bytes, want %d matching bytes", off, len(got), len(data)-int(off))
+		}
+	}
+	if m := maxInFlight.Load(); m > 2 {
+		t.Errorf("%d fetches in flight, want at most 2", m)
+	}
+}
+
+func TestParallelReaderError(t *testing.T) {
+	errFetch := errors.New("fetch failed")
+	fetch := func(ctx context.Context, off, n int64) ([]byte, error) {
+		if off > 0 {
+			return nil, errFetch
+		}
+		return make([]byte, n), nil
+	}
+	pr := newParallelReader(fetch, 0, 3*readChunkSize, 3)
+	defer pr.Close()
+	n, err := io.Copy(io.Discard, pr)
+	if !errors.Is(err, errFetch) {
+		t.Errorf("got error %v, want %v", err, errFetch)
+	}
+	if n != readChunkSize {
+		t.Errorf("read %d bytes before the error, want %d", n, readChunkSize)
+	}
+}
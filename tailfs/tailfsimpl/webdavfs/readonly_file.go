@@ -26,6 +26,7 @@
 type readOnlyFile struct {
 	name         string
 	client       *gowebdav.Client
+	fs           *webdavFS
 	rewindBuffer []byte
 	position     int
 
@@ -35,6 +36,7 @@ type readOnlyFile struct {
 	io.ReadCloser
 	initialFI fs.FileInfo
 	fi        fs.FileInfo
+	fill      *cacheFill // if non-nil, copies the contents into a ContentCache
 }
 
 // Readdir implements webdav.File. Since this is a file, it always failes with
@@ -80,6 +82,15 @@ func (f *readOnlyFile) Seek(offset int64, whence int) (int64, error) {
 			return 0, nil
 		} else if f.position == 0 {
 			// this is usually done to perform a range request to skip the head of the file
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			if f.ReadCloser != nil {
+				// Some of the file was read to sniff its content type
+				// before rewinding, so read from offset afresh.
+				f.ReadCloser.Close()
+				f.ReadCloser = nil
+				f.rewindBuffer = f.rewindBuffer[:0]
+			}
 			f.position = int(offset)
 			return offset, nil
 		}
@@ -113,13 +124,14 @@ func (f *readOnlyFile) Read(p []byte) (int, error) {
 
 	amountToReadFromBuffer := len(f.rewindBuffer) - f.position
 	if amountToReadFromBuffer > 0 {
-		n := copy(p, f.rewindBuffer)
+		n := copy(p, f.rewindBuffer[f.position:])
 		f.position += n
 		return n, nil
 	}
 
 	n, err := f.ReadCloser.Read(p)
-	if n > 0 && f.position < MaxRewindBuffer {
+	f.fillCache(p[:n], err)
+	if n > 0 && f.position < MaxRewindBuffer && f.position == len(f.rewindBuffer) {
 		amountToReadIntoBuffer := MaxRewindBuffer - f.position
 		if amountToReadIntoBuffer > n {
 			amountToReadIntoBuffer = n
@@ -146,12 +158,37 @@ func (f *readOnlyFile) Close() error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
+	if f.fill != nil {
+		// closed before reading all of it
+		f.fill.failed = true
+		f.fill.finish()
+		f.fill = nil
+	}
 	if f.ReadCloser == nil {
 		return nil
 	}
 	return f.ReadCloser.Close()
 }
 
+// fillCache adds b, read from the server with the given error, to the
+// content cache if the file is being cached.
+func (f *readOnlyFile) fillCache(b []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.fill == nil {
+		return
+	}
+	f.fill.write(b)
+	if err != nil {
+		if err != io.EOF {
+			f.fill.failed = true
+		}
+		f.fill.finish()
+		f.fill = nil
+	}
+}
+
 // statIfNecessary lazily initializes the FileInfo, bypassing the stat cache to
 // make sure we have fresh info before trying to read the file.
 func (f *readOnlyFile) statIfNecessary() error {
@@ -181,8 +218,30 @@ func (f *readOnlyFile) initReaderIfNecessary() error {
 	defer f.mu.Unlock()
 
 	if f.ReadCloser == nil {
+		if f.fill != nil && f.position != 0 {
+			// only files read from the start can be cached
+			f.fill.failed = true
+			f.fill.finish()
+			f.fill = nil
+		}
+		fi := f.fi
+		if fi == nil {
+			fi = f.initialFI
+		}
+		if f.fs != nil && f.fs.readAhead > 1 && fi != nil && fi.Size()-int64(f.position) >= minParallelReadSize {
+			f.ReadCloser = newParallelReader(func(ctx context.Context, off, n int64) ([]byte, error) {
+				return f.fs.fetchRange(ctx, f.name, off, n)
+			}, int64(f.position), fi.Size(), f.fs.readAhead)
+			return nil
+		}
 		var err error
-		f.ReadCloser, err = f.client.ReadStreamOffset(context.Background(), f.name, f.position)
+		if f.position > 0 && fi != nil {
+			// ReadStreamOffset fails on 206 Partial Content responses,
+			// so use a range request for the rest of the file.
+			f.ReadCloser, err = f.client.ReadStreamRange(context.Background(), f.name, int64(f.position), fi.Size()-int64(f.position))
+		} else {
+			f.ReadCloser, err = f.client.ReadStreamOffset(context.Background(), f.name, f.position)
+		}
 		if err != nil {
 			return translateWebDAVError(err)
 		}
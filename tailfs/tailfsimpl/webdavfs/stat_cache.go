@@ -5,7 +5,9 @@
 
 import (
 	"io/fs"
+	"path"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -59,6 +61,25 @@ func (c *statCache) set(parentPath string, infos []fs.FileInfo) {
 	}
 }
 
+// invalidatePath removes the cached metadata of the named file or directory,
+// anything under it, and its parent directory, whose modification time
+// changes along with it. Metadata elsewhere is kept.
+func (c *statCache) invalidatePath(name string) {
+	name = path.Clean("/" + name)
+	parent := path.Dir(name)
+	prefix := strings.TrimSuffix(name, "/") + "/"
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range c.cache.Keys() {
+		k := path.Clean("/" + filepath.ToSlash(key))
+		if k == name || k == parent || strings.HasPrefix(k, prefix) {
+			c.cache.Delete(key)
+		}
+	}
+}
+
 func (c *statCache) invalidate() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
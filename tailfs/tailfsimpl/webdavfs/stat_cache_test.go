@@ -102,3 +102,36 @@ func TestStatCache(t *testing.T) {
 
 	c.stop()
 }
+
+func TestStatCacheInvalidatePath(t *testing.T) {
+	c := newStatCache(time.Hour)
+	defer c.stop()
+
+	fi := func(name string, dir bool) fs.FileInfo {
+		return &shared.StaticFileInfo{Named: name, Dir: dir}
+	}
+	c.set("/", []fs.FileInfo{fi("a", true), fi("b", true), fi("ab", true)})
+	c.set("/a", []fs.FileInfo{fi("x", true), fi("f", false)})
+	c.set("/a/x", []fs.FileInfo{fi("g", false)})
+	c.set("/b", []fs.FileInfo{fi("h", false)})
+
+	c.invalidatePath("/a/x")
+
+	notFetched := func(name string) (fs.FileInfo, error) {
+		return nil, os.ErrNotExist
+	}
+	for name, wantCached := range map[string]bool{
+		"/a":     false, // parent
+		"/a/x":   false,
+		"/a/x/g": false,
+		"/a/f":   true,
+		"/ab":    true,
+		"/b":     true,
+		"/b/h":   true,
+	} {
+		_, err := c.getOrFetch(name, notFetched)
+		if cached := err == nil; cached != wantCached {
+			t.Errorf("%v cached = %v, want %v", name, cached, wantCached)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package webdavfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"tailscale.com/tailfs/tailfsimpl/shared"
+	"tailscale.com/tstime"
+)
+
+const (
+	// watchRequestTimeout bounds each long-poll request for changes, which
+	// the server answers after about 30 seconds when there are none.
+	watchRequestTimeout = 2 * time.Minute
+
+	// watchMaxBackoff is the longest a watcher waits before retrying after
+	// an error.
+	watchMaxBackoff = 5 * time.Minute
+)
+
+// errWatchNotSupported is returned by watcher.poll when the server doesn't
+// support watching for changes, as with nodes running older versions.
+var errWatchNotSupported = errors.New("server doesn't support watching for changes")
+
+// watcher long-polls a remote server for changes to its files, invalidating
+// the caches of a webdavFS when they change.
+type watcher struct {
+	wfs      *webdavFS
+	url      string
+	client   *http.Client
+	onChange func(name string) // or nil
+
+	startOnce sync.Once
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+func newWatcher(wfs *webdavFS, baseURL string, transport http.RoundTripper, onChange func(string)) *watcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &watcher{
+		wfs:      wfs,
+		url:      baseURL + shared.ChangesPath,
+		client:   &http.Client{Transport: transport, Timeout: watchRequestTimeout},
+		onChange: onChange,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// start starts watching for changes, if it hasn't started yet. Watching
+// is started lazily so that remotes that are never used aren't polled.
+func (w *watcher) start() {
+	w.startOnce.Do(func() {
+		go w.run()
+	})
+}
+
+// stop stops watching for changes.
+func (w *watcher) stop() {
+	w.cancel()
+}
+
+func (w *watcher) run() {
+	var since string // empty until synced with the server
+	var synced bool  // whether we've ever synced
+	backoff := time.Second
+	for w.ctx.Err() == nil {
+		changes, err := w.poll(since)
+		if err != nil {
+			if w.ctx.Err() != nil {
+				return
+			}
+			if err == errWatchNotSupported {
+				w.wfs.logf("not watching %v for changes: %v", w.url, err)
+				return
+			}
+			w.wfs.logf("watching %v for changes: %v; retrying in %v", w.url, err, backoff)
+			since = ""
+			tstime.Sleep(w.ctx, backoff)
+			backoff = min(backoff*2, watchMaxBackoff)
+			continue
+		}
+		backoff = time.Second
+
+		switch {
+		case since == "" && synced:
+			// We might have missed changes while not synced.
+			w.changed("/", true)
+		case since == "":
+		case changes.Reset:
+			w.changed("/", true)
+		default:
+			for _, ch := range changes.Changes {
+				w.changed(shared.Join(ch.Path), false)
+			}
+		}
+		since = strconv.FormatUint(changes.Seq, 10)
+		synced = true
+	}
+}
+
+// poll requests the changes since the given sequence number, or just the
+// current sequence number if since is empty.
+func (w *watcher) poll(since string) (*shared.Changes, error) {
+	u := w.url
+	if since != "" {
+		u += "?since=" + url.QueryEscape(since)
+	}
+	req, err := http.NewRequestWithContext(w.ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errWatchNotSupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %v", resp.Status)
+	}
+	var changes shared.Changes
+	if err := json.NewDecoder(resp.Body).Decode(&changes); err != nil {
+		return nil, err
+	}
+	return &changes, nil
+}
+
+// changed invalidates the caches of the named file or directory, or all of
+// them if all is true, and reports the change to onChange.
+func (w *watcher) changed(name string, all bool) {
+	if all {
+		if w.wfs.statCache != nil {
+			w.wfs.statCache.invalidate()
+		}
+		w.wfs.invalidateContent("/")
+	} else {
+		if w.wfs.statCache != nil {
+			w.wfs.statCache.invalidatePath(name)
+		}
+		w.wfs.invalidateContent(name)
+	}
+	if w.onChange != nil {
+		w.onChange(name)
+	}
+}
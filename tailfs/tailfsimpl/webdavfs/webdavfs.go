@@ -14,6 +14,7 @@
 	"log"
 	"net/http"
 	"os"
+	"path"
 	"time"
 
 	"github.com/tailscale/gowebdav"
@@ -43,6 +44,22 @@ type Options struct {
 	StatRoot bool
 	// StatCacheTTL, when greater than 0, enables caching of file metadata
 	StatCacheTTL time.Duration
+	// ContentCache, if non-nil, is used to cache the contents of files read
+	// from the remote server.
+	ContentCache *ContentCache
+	// WatchChanges, if true, makes the filesystem long-poll the remote
+	// server for changes once it's first used, so that its caches are
+	// invalidated as soon as files change rather than when they expire.
+	WatchChanges bool
+	// OnChange, if non-nil and WatchChanges is true, is called with the
+	// path of each file or directory that changed on the remote server, or
+	// "/" if anything might have.
+	OnChange func(name string)
+	// ReadAhead, if greater than 1, is how many chunks of large files are
+	// fetched in parallel with HTTP range requests while reading them
+	// sequentially, which greatly improves throughput over high-latency
+	// connections.
+	ReadAhead int
 	// Clock, if specified, determines the current time. If not specified, we
 	// default to time.Now().
 	Clock tstime.Clock
@@ -56,6 +73,12 @@ type webdavFS struct {
 	now       func() time.Time
 	statRoot  bool
 	statCache *statCache
+
+	url          string
+	httpClient   *http.Client
+	contentCache *ContentCache // or nil
+	watcher      *watcher      // or nil
+	readAhead    int
 }
 
 // New creates a new webdav.FileSystem backed by the given gowebdav.Client.
@@ -70,10 +93,18 @@ func New(opts Options) webdav.FileSystem {
 		transport: opts.Transport,
 		Client:    gowebdav.New(&gowebdav.Opts{URI: opts.URL, Transport: opts.Transport}),
 		statRoot:  opts.StatRoot,
+
+		url:          opts.URL,
+		httpClient:   &http.Client{Transport: opts.Transport},
+		contentCache: opts.ContentCache,
+		readAhead:    opts.ReadAhead,
 	}
 	if opts.StatCacheTTL > 0 {
 		wfs.statCache = newStatCache(opts.StatCacheTTL)
 	}
+	if opts.WatchChanges {
+		wfs.watcher = newWatcher(wfs, opts.URL, opts.Transport, opts.OnChange)
+	}
 	if opts.Clock != nil {
 		wfs.now = opts.Clock.Now
 	} else {
@@ -88,7 +119,7 @@ func (wfs *webdavFS) Mkdir(ctx context.Context, name string, perm os.FileMode) e
 	defer cancel()
 
 	if wfs.statCache != nil {
-		wfs.statCache.invalidate()
+		wfs.statCache.invalidatePath(name)
 	}
 	return translateWebDAVError(wfs.Client.Mkdir(ctxWithTimeout, name, perm))
 }
@@ -103,13 +134,15 @@ func (wfs *webdavFS) OpenFile(ctx context.Context, name string, flag int, perm o
 		}
 	}
 
+	wfs.startWatching()
 	ctxWithTimeout, cancel := context.WithTimeout(ctx, opTimeout)
 	defer cancel()
 
 	if hasFlag(flag, os.O_WRONLY) || hasFlag(flag, os.O_RDWR) {
 		if wfs.statCache != nil {
-			wfs.statCache.invalidate()
+			wfs.statCache.invalidatePath(name)
 		}
+		wfs.invalidateContent(name)
 
 		fi, err := wfs.Stat(ctxWithTimeout, name)
 		if err != nil && !errors.Is(err, os.ErrNotExist) {
@@ -149,9 +182,13 @@ func (wfs *webdavFS) OpenFile(ctx context.Context, name string, flag int, perm o
 	if fi.IsDir() {
 		return wfs.dirWithChildren(name, fi), nil
 	}
+	if wfs.contentCache != nil {
+		return wfs.openCached(name)
+	}
 
 	return &readOnlyFile{
 		client:       wfs.Client,
+		fs:           wfs,
 		name:         name,
 		initialFI:    fi,
 		rewindBuffer: make([]byte, 0, MaxRewindBuffer),
@@ -180,14 +217,60 @@ func (wfs *webdavFS) dirWithChildren(name string, fi fs.FileInfo) webdav.File {
 	}
 }
 
+// startWatching starts watching for changes on the server, if enabled.
+func (wfs *webdavFS) startWatching() {
+	if wfs.watcher != nil {
+		wfs.watcher.start()
+	}
+}
+
+// openCached opens the named file for reading from the content cache if its
+// contents are cached and unchanged, or otherwise from the server, filling
+// the cache as it's read.
+func (wfs *webdavFS) openCached(name string) (webdav.File, error) {
+	// Bypass the stat cache to make sure the cached contents are current.
+	fi, err := wfs.doStat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	key, version := wfs.contentKey(name), fileVersion(fi)
+	if f := wfs.contentCache.open(key, version); f != nil {
+		return &cachedFile{File: f, fi: fi}, nil
+	}
+	return &readOnlyFile{
+		client:       wfs.Client,
+		fs:           wfs,
+		name:         name,
+		initialFI:    fi,
+		fi:           fi,
+		rewindBuffer: make([]byte, 0, MaxRewindBuffer),
+		fill:         wfs.contentCache.startFill(key, version, fi.Size()),
+	}, nil
+}
+
+// contentKey returns the content cache key of the named file.
+func (wfs *webdavFS) contentKey(name string) string {
+	return wfs.url + path.Clean("/"+name)
+}
+
+// invalidateContent removes the named file or directory from the content
+// cache, if any.
+func (wfs *webdavFS) invalidateContent(name string) {
+	if wfs.contentCache != nil {
+		wfs.contentCache.invalidate(wfs.contentKey(name))
+	}
+}
+
 // RemoveAll implements webdav.FileSystem.
 func (wfs *webdavFS) RemoveAll(ctx context.Context, name string) error {
 	ctxWithTimeout, cancel := context.WithTimeout(ctx, opTimeout)
 	defer cancel()
 
 	if wfs.statCache != nil {
-		wfs.statCache.invalidate()
+		wfs.statCache.invalidatePath(name)
 	}
+	wfs.invalidateContent(name)
 	return wfs.Client.RemoveAll(ctxWithTimeout, name)
 }
 
@@ -197,13 +280,17 @@ func (wfs *webdavFS) Rename(ctx context.Context, oldName, newName string) error
 	defer cancel()
 
 	if wfs.statCache != nil {
-		wfs.statCache.invalidate()
+		wfs.statCache.invalidatePath(oldName)
+		wfs.statCache.invalidatePath(newName)
 	}
+	wfs.invalidateContent(oldName)
+	wfs.invalidateContent(newName)
 	return wfs.Client.Rename(ctxWithTimeout, oldName, newName, false)
 }
 
 // Stat implements webdav.FileSystem.
 func (wfs *webdavFS) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	wfs.startWatching()
 	if wfs.statCache != nil {
 		return wfs.statCache.getOrFetch(name, wfs.doStat)
 	}
@@ -212,6 +299,9 @@ func (wfs *webdavFS) Stat(ctx context.Context, name string) (fs.FileInfo, error)
 
 // Close implements webdav.FileSystem.
 func (wfs *webdavFS) Close() error {
+	if wfs.watcher != nil {
+		wfs.watcher.stop()
+	}
 	if wfs.statCache != nil {
 		wfs.statCache.stop()
 	}
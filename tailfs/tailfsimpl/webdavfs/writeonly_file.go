@@ -82,6 +82,10 @@ func (f *writeOnlyFile) Write(p []byte) (int, error) {
 func (f *writeOnlyFile) Close() error {
 	err := f.WriteCloser.Close()
 	writeErr := <-f.finalError
+	if f.fs.statCache != nil {
+		// in case the file was statted while it was being written
+		f.fs.statCache.invalidatePath(f.name)
+	}
 	if writeErr != nil {
 		return writeErr
 	}
@@ -21,6 +21,7 @@
 	"fmt"
 	"reflect"
 
+	"tailscale.com/control/controlclient"
 	"tailscale.com/control/controlknobs"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/conffile"
@@ -29,6 +30,7 @@
 	"tailscale.com/net/tsdial"
 	"tailscale.com/net/tstun"
 	"tailscale.com/proxymap"
+	"tailscale.com/tailcfg"
 	"tailscale.com/tailfs"
 	"tailscale.com/types/netmap"
 	"tailscale.com/wgengine"
@@ -51,6 +53,17 @@ type System struct {
 	TailFSForLocal  SubSystem[tailfs.FileSystemForLocal]
 	TailFSForRemote SubSystem[tailfs.FileSystemForRemote]
 
+	// The following subsystems are optional hooks for hermetic tests of
+	// code that embeds a LocalBackend. Nothing sets them in production.
+
+	// NewControlClient, if set, creates the control plane clients
+	// instead of controlclient.New, so tests can use a fake control
+	// server or none at all.
+	NewControlClient SubSystem[NewControlClientFunc]
+	// DERPMap, if set, is used instead of the DERP map from the control
+	// plane, so tests can point at an in-process DERP server.
+	DERPMap SubSystem[*tailcfg.DERPMap]
+
 	// InitialConfig is initial server config, if any.
 	// It is nil if the node is not in declarative mode.
 	// This value is never updated after startup.
@@ -72,6 +85,9 @@ type NetstackImpl interface {
 	UpdateNetstackIPs(*netmap.NetworkMap)
 }
 
+// NewControlClientFunc is the type of the System.NewControlClient hook.
+type NewControlClientFunc func(controlclient.Options) (controlclient.Client, error)
+
 // Set is a convenience method to set a subsystem value.
 // It panics if the type is unknown or has that type
 // has already been set.
@@ -105,6 +121,10 @@ type ft interface {
 		s.TailFSForLocal.Set(v)
 	case tailfs.FileSystemForRemote:
 		s.TailFSForRemote.Set(v)
+	case NewControlClientFunc:
+		s.NewControlClient.Set(v)
+	case *tailcfg.DERPMap:
+		s.DERPMap.Set(v)
 	default:
 		panic(fmt.Sprintf("unknown type %T", v))
 	}
@@ -0,0 +1,80 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnet
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+
+	"tailscale.com/tsweb/varz"
+	"tailscale.com/util/clientmetric"
+)
+
+// DebugHandler returns an HTTP handler serving debug information about s,
+// like tailscaled's -debug server:
+//
+//   - /debug/metrics: the process's metrics and the client metrics, in
+//     Prometheus format
+//   - /debug/status: a summary of the current network map, including
+//     whether each peer is reached directly or via DERP
+//   - /debug/magicsock: the engine's DERP connections and peer paths
+//   - /debug/pprof/: the Go profiler
+//
+// The handler does no authentication, so it should only be served on a
+// local admin listener, like a Unix socket or a loopback address, and
+// never on the tailnet or the internet.
+//
+// It will start the server, on the first request, if it has not been
+// started yet.
+func (s *Server) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/", serveDebugIndex)
+	mux.HandleFunc("/debug/metrics", serveDebugMetrics)
+	mux.HandleFunc("/debug/status", s.serveDebugStatus)
+	mux.HandleFunc("/debug/magicsock", s.serveDebugMagicsock)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+func serveDebugIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/debug/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<html><body><h1>tsnet debug</h1><ul>\n")
+	for _, p := range []string{"metrics", "status", "magicsock", "pprof/"} {
+		fmt.Fprintf(w, "<li><a href=\"/debug/%s\">%s</a></li>\n", p, p)
+	}
+	fmt.Fprintf(w, "</ul></body></html>\n")
+}
+
+func serveDebugMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	varz.Handler(w, r)
+	clientmetric.WritePrometheusExpositionFormat(w)
+}
+
+func (s *Server) serveDebugStatus(w http.ResponseWriter, r *http.Request) {
+	if err := s.Start(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	s.lb.Status().WriteHTML(w)
+}
+
+func (s *Server) serveDebugMagicsock(w http.ResponseWriter, r *http.Request) {
+	if err := s.Start(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	s.lb.MagicConn().ServeHTTPDebug(w, r)
+}
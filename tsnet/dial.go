@@ -0,0 +1,88 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnet
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+// DialOptions are options for Server.DialWithOptions.
+//
+// The zero value dials like Dial: the connection is made right away,
+// through a DERP relay if there's no direct path to the peer yet, and
+// it's only limited by the context's deadline.
+type DialOptions struct {
+	// DirectTimeout, if positive, is how long to wait for a direct path
+	// to the peer before connecting, which avoids starting the
+	// connection through a DERP relay with its higher latency. If no
+	// direct path is found in time, the connection is made through DERP
+	// anyway. For addresses in a subnet route, the wait is for a direct
+	// path to the subnet router. It's skipped for addresses that aren't
+	// routed to a peer.
+	DirectTimeout time.Duration
+
+	// ConnectTimeout, if positive, limits the time to connect, after
+	// the wait for a direct path. It doesn't include name resolution.
+	ConnectTimeout time.Duration
+}
+
+// directPingInterval is how often DialWithOptions checks for a direct
+// path while waiting for one.
+const directPingInterval = 250 * time.Millisecond
+
+// DialWithOptions connects to the address on the tailnet, like Dial, with
+// finer control over how long each phase of the connection may take.
+// It will start the server if it has not been started yet.
+func (s *Server) DialWithOptions(ctx context.Context, network, address string, opts DialOptions) (net.Conn, error) {
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+	ipp, err := s.dialer.UserDialResolve(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if opts.DirectTimeout > 0 {
+		s.waitForDirectPath(ctx, ipp.Addr(), opts.DirectTimeout)
+	}
+	if opts.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.ConnectTimeout)
+		defer cancel()
+	}
+	return s.dialer.UserDial(ctx, network, ipp.String())
+}
+
+// waitForDirectPath sends disco pings to the peer that ip is routed to,
+// which also makes magicsock look for a direct path to it, until one is
+// answered over a direct path or the timeout or ctx expires. It returns
+// right away if ip isn't routed to a peer.
+func (s *Server) waitForDirectPath(ctx context.Context, ip netip.Addr, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	t := time.NewTicker(directPingInterval)
+	defer t.Stop()
+	for {
+		res, err := s.lb.Ping(ctx, ip, tailcfg.PingDisco, 0)
+		if err != nil {
+			return // timed out
+		}
+		if res.NodeIP == "" {
+			// Not routed to a peer, or it's our own address.
+			return
+		}
+		if res.Err == "" && res.Endpoint != "" {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+
+	"tailscale.com/ipn"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/util/mak"
+)
+
+// Node returns a Server for an additional node named hostname, running in
+// the same process as s, creating it on the first call for hostname.
+//
+// Each node is registered separately with the control server, so it has
+// its own Tailscale IPs, MagicDNS name and TLS certificate domains, and
+// its own listeners. This lets a multi-tenant service answer on several
+// hostnames without running one process per hostname.
+//
+// The node is configured like s: it uses the same AuthKey, ControlURL,
+// Ephemeral, LogoutOnClose and Logf settings. Its state is kept in a
+// subdirectory of s's state directory, or in s.Store under keys prefixed
+// with its hostname if s.Store is set. Its exported fields must not be
+// changed.
+//
+// It will start s if it has not been started yet, but the returned node
+// is started on first use, like any Server. Closing s closes all its
+// nodes.
+func (s *Server) Node(hostname string) (*Server, error) {
+	if err := dnsname.ValidLabel(hostname); err != nil {
+		return nil, fmt.Errorf("tsnet: invalid node hostname %q: %w", hostname, err)
+	}
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, fmt.Errorf("tsnet: %w", net.ErrClosed)
+	}
+	if hostname == s.hostname {
+		return nil, fmt.Errorf("tsnet: node hostname %q is the hostname of the server itself", hostname)
+	}
+	if n, ok := s.nodes[hostname]; ok {
+		return n, nil
+	}
+	n := &Server{
+		Dir:           filepath.Join(s.rootPath, "nodes", hostname),
+		Hostname:      hostname,
+		Ephemeral:     s.Ephemeral,
+		LogoutOnClose: s.LogoutOnClose,
+		AuthKey:       s.AuthKey,
+		ControlURL:    s.ControlURL,
+	}
+	if s.Logf != nil {
+		n.Logf = logger.WithPrefix(s.Logf, hostname+": ")
+	}
+	if s.Store != nil {
+		n.Store = &prefixStore{
+			StateStore: s.Store,
+			prefix:     ipn.StateKey("node-" + hostname + "/"),
+		}
+	}
+	mak.Set(&s.nodes, hostname, n)
+	return n, nil
+}
+
+// prefixStore is an ipn.StateStore storing its state in another
+// StateStore, under keys with a prefix.
+type prefixStore struct {
+	ipn.StateStore
+	prefix ipn.StateKey
+}
+
+func (ps *prefixStore) ReadState(id ipn.StateKey) ([]byte, error) {
+	return ps.StateStore.ReadState(ps.prefix + id)
+}
+
+func (ps *prefixStore) WriteState(id ipn.StateKey, bs []byte) error {
+	return ps.StateStore.WriteState(ps.prefix+id, bs)
+}
+
+// SetDialer implements ipn.StateStoreDialerSetter, if the underlying
+// store does.
+func (ps *prefixStore) SetDialer(d func(ctx context.Context, network, address string) (net.Conn, error)) {
+	if sds, ok := ps.StateStore.(ipn.StateStoreDialerSetter); ok {
+		sds.SetDialer(d)
+	}
+}
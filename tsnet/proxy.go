@@ -0,0 +1,131 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"net/url"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+)
+
+// ReverseProxyOptions are options for Server.ReverseProxy.
+type ReverseProxyOptions struct {
+	// Addr is the address to listen on, like ":8443".
+	// If empty, ":443" is used, or ":80" if Insecure is set.
+	Addr string
+
+	// Insecure, if true, serves plain HTTP on the tailnet instead of
+	// terminating TLS with this node's certificate. WireGuard still
+	// encrypts the traffic, but browsers won't treat the site as
+	// secure.
+	Insecure bool
+}
+
+// ReverseProxy listens on the Tailscale network and proxies HTTP
+// requests to target, such as "http://127.0.0.1:8080", until s is closed.
+// It will start the server if it has not been started yet.
+//
+// Like "tailscale serve", it sets the Tailscale-User-Login,
+// Tailscale-User-Name and Tailscale-User-Profile-Pic headers of the
+// proxied requests to the identity of the caller, as looked up with
+// WhoIs, and removes any such headers sent by the caller. Requests from
+// tagged nodes, which don't have a user identity, are proxied without
+// them. The X-Forwarded-For, X-Forwarded-Host and X-Forwarded-Proto
+// headers are also set.
+//
+// Unless opts.Insecure is set, TLS is terminated with this node's
+// certificate, as with ListenTLS, which requires HTTPS to be enabled
+// for the tailnet.
+//
+// opts may be nil to use the defaults.
+func (s *Server) ReverseProxy(target string, opts *ReverseProxyOptions) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("tsnet: invalid reverse proxy target %q: %w", target, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("tsnet: invalid reverse proxy target %q: scheme must be http or https", target)
+	}
+	if opts == nil {
+		opts = new(ReverseProxyOptions)
+	}
+	addr := opts.Addr
+	if addr == "" {
+		addr = ":443"
+		if opts.Insecure {
+			addr = ":80"
+		}
+	}
+
+	var ln net.Listener
+	if opts.Insecure {
+		ln, err = s.Listen("tcp", addr)
+	} else {
+		ln, err = s.ListenTLS("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	hs := &http.Server{
+		Handler:  newIdentityProxy(u, s.lb.WhoIs),
+		ErrorLog: logger.StdLogger(s.logf),
+	}
+	err = hs.Serve(ln)
+	if errors.Is(err, net.ErrClosed) {
+		// The listener was closed by s.Close.
+		return nil
+	}
+	return err
+}
+
+// whoIsFunc looks up the node and user at a tailnet address, like
+// ipnlocal.LocalBackend.WhoIs.
+type whoIsFunc func(netip.AddrPort) (tailcfg.NodeView, tailcfg.UserProfile, bool)
+
+// identityHeaders are the headers set by ReverseProxy with the identity of
+// the caller.
+var identityHeaders = []string{
+	"Tailscale-User-Login",
+	"Tailscale-User-Name",
+	"Tailscale-User-Profile-Pic",
+	"Tailscale-Headers-Info",
+}
+
+// newIdentityProxy returns a reverse proxy to target that adds the
+// identity of the caller, as looked up by whois, to the proxied requests.
+func newIdentityProxy(target *url.URL, whois whoIsFunc) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{Rewrite: func(r *httputil.ProxyRequest) {
+		r.SetURL(target)
+		r.Out.Host = r.In.Host
+		r.SetXForwarded()
+
+		// Clear any incoming values squatting in the headers.
+		for _, h := range identityHeaders {
+			r.Out.Header.Del(h)
+		}
+
+		src, err := netip.ParseAddrPort(r.In.RemoteAddr)
+		if err != nil {
+			return
+		}
+		node, user, ok := whois(src)
+		if !ok || node.IsTagged() {
+			// Tagged nodes have no user identity to pass on.
+			return
+		}
+		r.Out.Header.Set("Tailscale-User-Login", user.LoginName)
+		r.Out.Header.Set("Tailscale-User-Name", user.DisplayName)
+		r.Out.Header.Set("Tailscale-User-Profile-Pic", user.ProfilePicURL)
+		r.Out.Header.Set("Tailscale-Headers-Info", "https://tailscale.com/s/serve-headers")
+	}}
+}
@@ -95,8 +95,20 @@ type Server struct {
 
 	// Ephemeral, if true, specifies that the instance should register
 	// as an Ephemeral node (https://tailscale.com/s/ephemeral-nodes).
+	//
+	// An ephemeral node is logged out, and so deleted from the tailnet,
+	// when the server is closed. If the process exits without closing
+	// it, the control server deletes it after it's been offline for a
+	// while.
 	Ephemeral bool
 
+	// LogoutOnClose, if true, specifies that the node should be logged
+	// out when the server is closed, as ephemeral nodes are. This
+	// deletes nodes registered with an ephemeral auth key even if
+	// Ephemeral isn't set. The node then needs to be authorized again
+	// on the next start, even if its state was persisted.
+	LogoutOnClose bool
+
 	// AuthKey, if non-empty, is the auth key to create the node
 	// and will be preferred over the TS_AUTHKEY environment
 	// variable. If the node is already created (from state
@@ -143,6 +155,10 @@ type Server struct {
 	fallbackTCPHandlers set.HandleSet[FallbackTCPHandler]
 	dialer              *tsdial.Dialer
 	closed              bool
+	shuttingDown        bool                  // Shutdown was called
+	activeConns         set.Set[*trackedConn] // accepted TCP conns, for Shutdown
+
+	nodes map[string]*Server // by hostname; see Node
 }
 
 // FallbackTCPHandler describes the callback which
@@ -160,6 +176,8 @@ type Server struct {
 
 // Dial connects to the address on the tailnet.
 // It will start the server if it has not been started yet.
+//
+// See DialWithOptions for more control over the connection's latency.
 func (s *Server) Dial(ctx context.Context, network, address string) (net.Conn, error) {
 	if err := s.Start(); err != nil {
 		return nil, err
@@ -348,6 +366,14 @@ func (s *Server) Close() error {
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	if s.lb != nil && (s.Ephemeral || s.LogoutOnClose) {
+		// Log out first, while the node is fully up and its logs are
+		// still uploaded, so that it's deleted from the tailnet right
+		// away.
+		if err := s.lb.Logout(ctx); err != nil {
+			s.logf("tsnet: logging out on close: %v", err)
+		}
+	}
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
@@ -394,12 +420,112 @@ func (s *Server) Close() error {
 	for _, ln := range s.listeners {
 		ln.closeLocked()
 	}
+	for _, n := range s.nodes {
+		n.Close() // an error means it was closed already
+	}
 
 	wg.Wait()
 	s.closed = true
 	return nil
 }
 
+// shutdownPollInterval is how often Shutdown checks whether all
+// connections are closed.
+const shutdownPollInterval = 100 * time.Millisecond
+
+// Shutdown gracefully stops the server: it closes all listeners, so that no
+// new connections are accepted, then waits for the TCP connections already
+// accepted from them to be closed, and then closes the server like Close.
+// The nodes created with Node are shut down first, in the same way.
+//
+// If ctx is done before all connections are closed, the remaining
+// connections are closed, the server is closed, and the context's error
+// is returned.
+//
+// Like Close, it must not be called before or concurrently with Start.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return fmt.Errorf("tsnet: %w", net.ErrClosed)
+	}
+	s.shuttingDown = true
+	for _, ln := range s.listeners {
+		ln.closeLocked()
+	}
+	nodes := make([]*Server, 0, len(s.nodes))
+	for _, n := range s.nodes {
+		nodes = append(nodes, n)
+	}
+	s.mu.Unlock()
+
+	for _, n := range nodes {
+		n.Shutdown(ctx) // an error means it was closed already
+	}
+
+	t := time.NewTicker(shutdownPollInterval)
+	defer t.Stop()
+	var err error
+	for s.numActiveConns() > 0 && err == nil {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-t.C:
+		}
+	}
+	s.mu.Lock()
+	for c := range s.activeConns {
+		c.Conn.Close()
+	}
+	s.mu.Unlock()
+
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *Server) numActiveConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.activeConns)
+}
+
+// trackedConn is a TCP connection accepted from a listener, which is
+// waited for by Shutdown until it's closed. Its NetConn method gives
+// callers the netstack connection it wraps; see Listen.
+//
+// Funnel connections aren't wrapped in a trackedConn themselves, as callers
+// type-assert them to *ipn.FunnelConn; their underlying Conn is instead.
+type trackedConn struct {
+	net.Conn
+	s         *Server
+	closeOnce sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.s.mu.Lock()
+		defer c.s.mu.Unlock()
+		delete(c.s.activeConns, c)
+	})
+	return c.Conn.Close()
+}
+
+// CloseWrite shuts down the writing side of the connection, if the
+// underlying connection supports it.
+func (c *trackedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
+
+// NetConn returns the underlying connection.
+func (c *trackedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
 func (s *Server) doInit() {
 	s.shutdownCtx, s.shutdownCancel = context.WithCancel(context.Background())
 	if err := s.start(); err != nil {
@@ -662,8 +788,9 @@ func (s *Server) startLogger(closePool *closeOnErrorPool) error {
 			}
 			return w
 		},
-		HTTPC:        &http.Client{Transport: logpolicy.NewLogtailTransport(logtail.DefaultHost, s.netMon, s.logf)},
-		MetricsDelta: clientmetric.EncodeLogTailMetricsDelta,
+		HTTPC:           &http.Client{Transport: logpolicy.NewLogtailTransport(logtail.DefaultHost, s.netMon, s.logf)},
+		MetricsDelta:    clientmetric.EncodeLogTailMetricsDelta,
+		MetricsUploaded: clientmetric.AckLogTailUpload,
 	}
 	s.logtail = logtail.NewLogger(c, s.logf)
 	closePool.addFunc(func() { s.logtail.Shutdown(context.Background()) })
@@ -893,6 +1020,19 @@ func (s *Server) APIClient() (*tailscale.Client, error) {
 // IPv6 address of this node) only. To listen for traffic on other addresses
 // such as those routed inbound via subnet routes, explicitly specify
 // the listening address or use RegisterFallbackTCPHandler.
+//
+// The TCP connections it accepts wrap the netstack connections, typically
+// *gonet.TCPConn, so that Shutdown can wait for them to be closed. Rather
+// than type-asserting them to *gonet.TCPConn, use their NetConn method to
+// get the underlying connection:
+//
+//	if nc, ok := c.(interface{ NetConn() net.Conn }); ok {
+//		tc, ok := nc.NetConn().(*gonet.TCPConn)
+//		...
+//	}
+//
+// They also implement CloseWrite. Funnel connections (see ListenFunnel) are
+// still *ipn.FunnelConn, whose Conn is such a wrapper.
 func (s *Server) Listen(network, addr string) (net.Listener, error) {
 	return s.listen(network, addr, listenOnTailnet)
 }
@@ -1113,6 +1253,10 @@ func (s *Server) listen(network, addr string, lnOn listenOn) (net.Listener, erro
 		conn: make(chan net.Conn),
 	}
 	s.mu.Lock()
+	if s.closed || s.shuttingDown {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("tsnet: %w", net.ErrClosed)
+	}
 	for _, key := range keys {
 		if _, ok := s.listeners[key]; ok {
 			s.mu.Unlock()
@@ -1206,6 +1350,19 @@ func (ln *listener) closeLocked() error {
 }
 
 func (ln *listener) handle(c net.Conn) {
+	if strings.HasPrefix(ln.keys[0].network, "tcp") {
+		var tc *trackedConn
+		if fc, ok := c.(*ipn.FunnelConn); ok {
+			tc = &trackedConn{Conn: fc.Conn, s: ln.s}
+			fc.Conn = tc
+		} else {
+			tc = &trackedConn{Conn: c, s: ln.s}
+			c = tc
+		}
+		ln.s.mu.Lock()
+		mak.Set(&ln.s.activeConns, tc, struct{}{})
+		ln.s.mu.Unlock()
+	}
 	t := time.NewTimer(time.Second)
 	defer t.Stop()
 	select {
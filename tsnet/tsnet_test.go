@@ -21,6 +21,7 @@
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -31,6 +32,7 @@
 	"time"
 
 	"golang.org/x/net/proxy"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
 	"tailscale.com/cmd/testwrapper/flakytest"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/store/mem"
@@ -469,6 +471,222 @@ func TestTailscaleIPs(t *testing.T) {
 
 // TestListenerCleanup is a regression test to verify that s.Close doesn't
 // deadlock if a listener is still open.
+func TestShutdown(t *testing.T) {
+	tstest.ResourceCheck(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL, _ := startControl(t)
+	s1, s1ip, _ := startServer(t, ctx, controlURL, "s1")
+	s2, _, _ := startServer(t, ctx, controlURL, "s2")
+
+	ln, err := s1.Listen("tcp", ":8081")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := s2.Dial(ctx, "tcp", fmt.Sprintf("%s:8081", s1ip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	r, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shutdownDone := make(chan error, 1)
+	go func() {
+		shutdownDone <- s1.Shutdown(ctx)
+	}()
+
+	// The listener is closed right away, but the accepted conn still
+	// works and Shutdown waits for it.
+	if _, err := ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept after Shutdown = %v; want net.ErrClosed", err)
+	}
+	if _, err := io.WriteString(w, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len("hello"))
+	if _, err := io.ReadFull(r, got); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-shutdownDone:
+		t.Fatalf("Shutdown returned %v before the conn was closed", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	r.Close()
+	if err := <-shutdownDone; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if _, err := s1.Listen("tcp", ":8082"); err == nil {
+		t.Errorf("Listen after Shutdown succeeded")
+	}
+}
+
+// TestListenerConnType tests what the conns a listener accepts can be
+// type-asserted to, as documented on Listen.
+func TestListenerConnType(t *testing.T) {
+	tstest.ResourceCheck(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL, _ := startControl(t)
+	s1, s1ip, _ := startServer(t, ctx, controlURL, "s1")
+	s2, _, _ := startServer(t, ctx, controlURL, "s2")
+
+	ln, err := s1.Listen("tcp", ":8081")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	w, err := s2.Dial(ctx, "tcp", fmt.Sprintf("%s:8081", s1ip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	r, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if _, ok := r.(*gonet.TCPConn); ok {
+		t.Errorf("accepted conn is a *gonet.TCPConn; Shutdown can't track it")
+	}
+	nc, ok := r.(interface{ NetConn() net.Conn })
+	if !ok {
+		t.Fatalf("accepted conn %T has no NetConn method", r)
+	}
+	if _, ok := nc.NetConn().(*gonet.TCPConn); !ok {
+		t.Errorf("NetConn returned %T; want *gonet.TCPConn", nc.NetConn())
+	}
+	cw, ok := r.(interface{ CloseWrite() error })
+	if !ok {
+		t.Fatalf("accepted conn %T has no CloseWrite method", r)
+	}
+	if err := cw.CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite: %v", err)
+	}
+	if n, err := w.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read after CloseWrite = %d, %v; want EOF", n, err)
+	}
+}
+
+func TestEphemeralDeletedOnClose(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL, control := startControl(t)
+	s1, _, s1PubKey := startServer(t, ctx, controlURL, "s1")
+	if control.Node(s1PubKey) == nil {
+		t.Fatal("node not registered")
+	}
+	if err := s1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if control.Node(s1PubKey) != nil {
+		t.Error("ephemeral node still registered after Close")
+	}
+}
+
+func TestLogoutOnClose(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL, _ := startControl(t)
+	dir := filepath.Join(t.TempDir(), "s1")
+	newServer := func() *Server {
+		s := &Server{
+			Dir:           dir,
+			ControlURL:    controlURL,
+			Hostname:      "s1",
+			LogoutOnClose: true,
+		}
+		if !*verboseNodes {
+			s.Logf = logger.Discard
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	}
+
+	s1 := newServer()
+	status, err := s1.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKey := status.Self.PublicKey
+	if err := s1.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The node was logged out, so even with its state persisted it
+	// registers again with a new node key.
+	s2 := newServer()
+	status, err = s2.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Self.PublicKey == oldKey {
+		t.Error("node kept its node key across a Close with LogoutOnClose")
+	}
+}
+
+func TestDialWithOptions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL, _ := startControl(t)
+	s1, s1ip, _ := startServer(t, ctx, controlURL, "s1")
+	s2, _, _ := startServer(t, ctx, controlURL, "s2")
+
+	// ping to make sure the connection is up, as otherwise the first
+	// WireGuard handshake may be retried only after ConnectTimeout.
+	lc2, err := s2.LocalClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lc2.Ping(ctx, s1ip, tailcfg.PingICMP); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := s1.Listen("tcp", ":8081")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	w, err := s2.DialWithOptions(ctx, "tcp", fmt.Sprintf("%s:8081", s1ip), DialOptions{
+		DirectTimeout:  5 * time.Second,
+		ConnectTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	r, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// The wait for a direct path ends early for addresses that aren't
+	// routed to any peer, and the connect timeout applies.
+	start := time.Now()
+	_, err = s2.DialWithOptions(ctx, "tcp", "192.0.2.1:80", DialOptions{
+		DirectTimeout:  10 * time.Second,
+		ConnectTimeout: time.Second,
+	})
+	if err == nil {
+		t.Fatal("dial to unrouted address succeeded")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("dial to unrouted address took %v", d)
+	}
+}
+
 func TestListenerCleanup(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -565,6 +783,10 @@ func TestFunnel(t *testing.T) {
 				t.Errorf("ConnContext called with wrong SrcAddrPort; got %v, want %v", fc.Src, wantSrcAddrPort)
 			} else if fc.Target != wantTarget {
 				t.Errorf("ConnContext called with wrong Target; got %q, want %q", fc.Target, wantTarget)
+			} else if nc, ok := fc.Conn.(interface{ NetConn() net.Conn }); !ok {
+				t.Errorf("FunnelConn wraps %T, which has no NetConn method", fc.Conn)
+			} else if _, ok := nc.NetConn().(*gonet.TCPConn); !ok {
+				t.Errorf("FunnelConn's NetConn is %T; want *gonet.TCPConn", nc.NetConn())
 			}
 			return ctx
 		},
@@ -744,3 +966,124 @@ func TestCapturePcap(t *testing.T) {
 		t.Errorf("s2 pcap file size = %d, want > pcapHeaderSize(%d)", got, pcapHeaderSize)
 	}
 }
+
+func TestIdentityProxy(t *testing.T) {
+	var gotHeader http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header.Clone()
+	}))
+	defer backend.Close()
+	target, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := tailcfg.UserProfile{
+		LoginName:     "alice@example.com",
+		DisplayName:   "Alice",
+		ProfilePicURL: "https://example.com/alice.png",
+	}
+	userNode := (&tailcfg.Node{}).View()
+	taggedNode := (&tailcfg.Node{Tags: []string{"tag:server"}}).View()
+	whois := func(ap netip.AddrPort) (tailcfg.NodeView, tailcfg.UserProfile, bool) {
+		switch ap.Addr() {
+		case netip.MustParseAddr("100.64.0.1"):
+			return userNode, user, true
+		case netip.MustParseAddr("100.64.0.2"):
+			return taggedNode, tailcfg.UserProfile{}, true
+		}
+		return tailcfg.NodeView{}, tailcfg.UserProfile{}, false
+	}
+	rp := newIdentityProxy(target, whois)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		wantLogin  string
+		wantName   string
+	}{
+		{"user", "100.64.0.1:1234", "alice@example.com", "Alice"},
+		{"tagged", "100.64.0.2:1234", "", ""},
+		{"unknown", "100.64.0.3:1234", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "https://node.example.ts.net/foo", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("Tailscale-User-Login", "mallory@example.com")
+			req.Header.Set("Tailscale-User-Name", "Mallory")
+			req.Header.Set("X-Forwarded-For", "1.2.3.4")
+			rec := httptest.NewRecorder()
+			rp.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d; want 200", rec.Code)
+			}
+			if got := gotHeader.Get("Tailscale-User-Login"); got != tt.wantLogin {
+				t.Errorf("Tailscale-User-Login = %q; want %q", got, tt.wantLogin)
+			}
+			if got := gotHeader.Get("Tailscale-User-Name"); got != tt.wantName {
+				t.Errorf("Tailscale-User-Name = %q; want %q", got, tt.wantName)
+			}
+			wantXFF, _, _ := strings.Cut(tt.remoteAddr, ":")
+			if got := gotHeader.Get("X-Forwarded-For"); got != wantXFF {
+				t.Errorf("X-Forwarded-For = %q; want %q", got, wantXFF)
+			}
+		})
+	}
+}
+
+func TestPrefixStore(t *testing.T) {
+	st := new(mem.Store)
+	a := &prefixStore{StateStore: st, prefix: "node-a/"}
+	b := &prefixStore{StateStore: st, prefix: "node-b/"}
+	if err := a.WriteState("k", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteState("k", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		st   ipn.StateStore
+		key  ipn.StateKey
+		want string
+	}{
+		{a, "k", "a"},
+		{b, "k", "b"},
+		{st, "node-a/k", "a"},
+		{st, "node-b/k", "b"},
+	} {
+		got, err := tt.st.ReadState(tt.key)
+		if err != nil {
+			t.Fatalf("ReadState(%q): %v", tt.key, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("ReadState(%q) = %q; want %q", tt.key, got, tt.want)
+		}
+	}
+	if _, err := a.ReadState("other"); err != ipn.ErrStateNotExist {
+		t.Errorf("ReadState(other) error = %v; want ErrStateNotExist", err)
+	}
+}
+
+func TestDebugHandler(t *testing.T) {
+	s := new(Server)
+	h := s.DebugHandler()
+	for _, tt := range []struct {
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{"/debug/", 200, `href="/debug/status"`},
+		{"/debug/metrics", 200, "# TYPE"},
+		{"/debug/nope", 404, ""},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		if rec.Code != tt.wantCode {
+			t.Errorf("GET %s: status = %d; want %d", tt.path, rec.Code, tt.wantCode)
+		}
+		if !strings.Contains(rec.Body.String(), tt.wantBody) {
+			t.Errorf("GET %s: body doesn't contain %q:\n%s", tt.path, tt.wantBody, rec.Body.String())
+		}
+	}
+}
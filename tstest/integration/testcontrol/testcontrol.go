@@ -91,6 +91,7 @@ type Server struct {
 	updates       map[tailcfg.NodeID]chan updateType
 	authPath      map[string]*AuthPath
 	nodeKeyAuthed map[key.NodePublic]bool // key => true once authenticated
+	ephemeral     set.Set[key.NodePublic] // nodes that registered as ephemeral
 	msgToSend     map[key.NodePublic]any  // value is *tailcfg.PingRequest or entire *tailcfg.MapResponse
 	allExpired    bool                    // All nodes will be told their node key is expired.
 }
@@ -607,22 +608,35 @@ func (s *Server) serveRegister(w http.ResponseWriter, r *http.Request, mkey key.
 		v6Prefix,
 	}
 
-	s.nodes[nk] = &tailcfg.Node{
-		ID:                tailcfg.NodeID(user.ID),
-		StableID:          tailcfg.StableNodeID(fmt.Sprintf("TESTCTRL%08x", int(user.ID))),
-		User:              user.ID,
-		Machine:           mkey,
-		Key:               req.NodeKey,
-		MachineAuthorized: machineAuthorized,
-		Addresses:         allowedIPs,
-		AllowedIPs:        allowedIPs,
-		Hostinfo:          req.Hostinfo.View(),
-		Name:              req.Hostinfo.Hostname,
-		Capabilities: []tailcfg.NodeCapability{
-			tailcfg.CapabilityHTTPS,
-			tailcfg.NodeAttrFunnel,
-			tailcfg.CapabilityFunnelPorts + "?ports=8080,443",
-		},
+	if req.Ephemeral {
+		if s.ephemeral == nil {
+			s.ephemeral = set.Set[key.NodePublic]{}
+		}
+		s.ephemeral.Add(nk)
+	}
+	if !req.Expiry.IsZero() && req.Expiry.Before(time.Now()) && s.ephemeral.Contains(nk) {
+		// An ephemeral node logging out is deleted right away. The
+		// logout request itself doesn't say whether it's ephemeral.
+		delete(s.nodes, nk)
+		s.ephemeral.Delete(nk)
+	} else {
+		s.nodes[nk] = &tailcfg.Node{
+			ID:                tailcfg.NodeID(user.ID),
+			StableID:          tailcfg.StableNodeID(fmt.Sprintf("TESTCTRL%08x", int(user.ID))),
+			User:              user.ID,
+			Machine:           mkey,
+			Key:               req.NodeKey,
+			MachineAuthorized: machineAuthorized,
+			Addresses:         allowedIPs,
+			AllowedIPs:        allowedIPs,
+			Hostinfo:          req.Hostinfo.View(),
+			Name:              req.Hostinfo.Hostname,
+			Capabilities: []tailcfg.NodeCapability{
+				tailcfg.CapabilityHTTPS,
+				tailcfg.NodeAttrFunnel,
+				tailcfg.CapabilityFunnelPorts + "?ports=8080,443",
+			},
+		}
 	}
 	requireAuth := s.RequireAuth
 	if requireAuth && s.nodeKeyAuthed[nk] {
@@ -28,6 +28,7 @@ func init() {
 	expvar.Publish("go_version", expvar.Func(func() any { return runtime.Version() }))
 	expvar.Publish("counter_uptime_sec", expvar.Func(func() any { return int64(Uptime().Seconds()) }))
 	expvar.Publish("gauge_goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+	expvar.Publish("proc", metrics.NewProcessSet())
 }
 
 const (
@@ -110,6 +111,9 @@ func writePromExpVar(w io.Writer, prefix string, kv expvar.KeyValue) {
 			writePromExpVar(w, name+"_", kv)
 		})
 		return
+	case promExporter:
+		v.PromExport(w, name)
+		return
 	case PrometheusMetricsReflectRooter:
 		root := v.PrometheusMetricsReflectRoot()
 		rv := reflect.ValueOf(root)
@@ -194,8 +198,6 @@ funcRet = fmt.Sprintf(" returning %T", v)
 		v.Do(func(kv expvar.KeyValue) {
 			fmt.Fprintf(w, "%s{%s=%q} %v\n", name, cmp.Or(v.Label, "label"), kv.Key, kv.Value)
 		})
-	case *metrics.Histogram:
-		v.PromExport(w, name)
 	case *expvar.Map:
 		if label != "" && typ != "" {
 			fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
@@ -210,6 +212,13 @@ funcRet = fmt.Sprintf(" returning %T", v)
 	}
 }
 
+// promExporter is implemented by the metrics types that write themselves in
+// the Prometheus format, such as *metrics.Histogram and
+// *metrics.MultiLabelMap.
+type promExporter interface {
+	PromExport(w io.Writer, name string)
+}
+
 var sortedKVsPool = &sync.Pool{New: func() any { return new(sortedKVs) }}
 
 // sortedKV is a KeyValue with a sort key.
@@ -234,6 +243,9 @@ type sortedKVs struct {
 //     underscores. So use underscores as your metric names.
 //   - an expvar named starting with "gauge_" or "counter_" is of that
 //     Prometheus type, and has that prefix stripped.
+//   - a *tailscale/metrics.Histogram is a histogram.
+//   - a *tailscale/metrics.MultiLabelMap is of its Type, with a label per
+//     field of its key type.
 //   - anything else is untyped and thus not exported.
 //   - expvar.Func can return an int or int64 (for now) and anything else
 //     is not exported.
@@ -175,6 +175,39 @@ func() *expvar.Map {
 			})(),
 			"foo{label=\"a\"} 1\n",
 		},
+		{
+			"histogram",
+			"derp_latency",
+			(func() *metrics.Histogram {
+				h := metrics.NewHistogram([]float64{0.1, 1})
+				h.Observe(0.05)
+				h.Observe(0.5)
+				h.Observe(2)
+				return h
+			})(),
+			"# TYPE derp_latency histogram\nderp_latency_bucket{le=\"0.1\"} 1\nderp_latency_bucket{le=\"1\"} 2\nderp_latency_bucket{le=\"+Inf\"} 3\nderp_latency_sum 2.55\nderp_latency_count 3\n",
+		},
+		{
+			"histogram_in_set",
+			"s",
+			(func() *metrics.Set {
+				s := new(metrics.Set)
+				s.Set("lat", metrics.NewHistogram([]float64{1}))
+				return s
+			})(),
+			"# TYPE s_lat histogram\ns_lat_bucket{le=\"1\"} 0\ns_lat_bucket{le=\"+Inf\"} 0\ns_lat_sum 0\ns_lat_count 0\n",
+		},
+		{
+			"multi_label_map",
+			"m",
+			(func() *metrics.MultiLabelMap[struct{ Region, Family string }] {
+				m := &metrics.MultiLabelMap[struct{ Region, Family string }]{Type: "gauge"}
+				m.Add(struct{ Region, Family string }{"nyc", "ipv6"}, 1)
+				m.Add(struct{ Region, Family string }{"ams", "ipv4"}, 2)
+				return m
+			})(),
+			"# TYPE m gauge\nm{region=\"ams\",family=\"ipv4\"} 2\nm{region=\"nyc\",family=\"ipv6\"} 1\n",
+		},
 		{
 			"expvar_label_map",
 			"counter_labelmap_keyname_m",
@@ -72,4 +72,23 @@ type AppConnectorAttr struct {
 	// These can either be "*" to match any advertising connector, or a
 	// tag of the form tag:<tag-name>.
 	Connectors []string `json:"connectors,omitempty"`
+
+	// Partitions, if greater than one, splits Domains and Routes into that
+	// many partitions so that several app connectors can serve them without
+	// all advertising the same routes. Each domain or route belongs to the
+	// partition given by appc.Partition, and a connector only serves the
+	// partitions that PartitionOwners assigns to it.
+	Partitions int `json:"partitions,omitempty"`
+	// PartitionOwners maps the stable node ID of each app connector to the
+	// partitions it owns, numbered from zero. It is only used if Partitions
+	// is greater than one. A connector with no entry owns no partitions.
+	PartitionOwners map[tailcfg.StableNodeID][]int `json:"partitionOwners,omitempty"`
+
+	// RouteMaxAgeSeconds, if positive, is how long, in seconds, a route
+	// that the app connectors learned from a DNS response for one of
+	// Domains stays advertised after the last response that contained it.
+	// If several attributes apply to a connector, the shortest is used.
+	// Zero means forever, unless the TS_APPC_ROUTE_MAX_AGE environment
+	// variable is set on the connector.
+	RouteMaxAgeSeconds int `json:"routeMaxAgeSeconds,omitempty"`
 }
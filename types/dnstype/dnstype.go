@@ -9,6 +9,7 @@
 import (
 	"net/netip"
 	"slices"
+	"time"
 )
 
 // Resolver is the configuration for one DNS resolver.
@@ -66,3 +67,54 @@ func (r *Resolver) Equal(other *Resolver) bool {
 
 	return r.Addr == other.Addr && slices.Equal(r.BootstrapResolution, other.BootstrapResolution)
 }
+
+// QueryLogEntry describes a DNS query handled by Tailscale's DNS resolver, as
+// kept in the DNS query log when the DNSQueryLog pref is enabled.
+type QueryLogEntry struct {
+	Time time.Time // when the query was received
+	Name string    // the queried name, as an FQDN
+	Type string    // the query type, such as "A" or "AAAA"
+
+	// Upstream is the address of the upstream resolver that answered the
+	// query, as in Resolver.Addr. It's empty if the query was answered by
+	// Tailscale's own resolver, such as for MagicDNS names, or from its
+	// cache of upstream responses.
+	Upstream string `json:",omitempty"`
+
+	Latency time.Duration // from receiving the query to having the response
+
+	// RCode is the response code, such as "Success" or "NameError". It's
+	// empty if there was no response.
+	RCode string `json:",omitempty"`
+
+	// Error is the error resolving the query, if any.
+	Error string `json:",omitempty"`
+}
+
+// FailoverRouteStatus is the state of a split DNS route whose resolvers are
+// used one at a time, in order of preference, failing over from one to the
+// next. See tailcfg.DNSConfig.FailoverRoutes.
+type FailoverRouteStatus struct {
+	Suffix string // the route's DNS suffix, as an FQDN
+
+	// Active is the address of the resolver that queries are currently
+	// sent to, as in Resolver.Addr: the first healthy one.
+	Active string
+
+	// Resolvers are the route's resolvers, in order of preference.
+	Resolvers []FailoverResolverStatus
+}
+
+// FailoverResolverStatus is the health of one resolver of a failover route.
+type FailoverResolverStatus struct {
+	Addr    string // as in Resolver.Addr
+	Healthy bool
+
+	// DownSince is when the resolver was marked unhealthy. It's the zero
+	// time if Healthy.
+	DownSince time.Time
+
+	// LastError is the error that caused the resolver to be marked
+	// unhealthy, if any.
+	LastError string `json:",omitempty"`
+}
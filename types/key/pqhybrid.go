@@ -0,0 +1,189 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package key
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+
+	"github.com/cloudflare/circl/kem/kyber/kyber768"
+	"golang.org/x/crypto/curve25519"
+	"tailscale.com/types/structs"
+)
+
+// The hybrid KEM is X25519 combined with Kyber768, the round 3 submission
+// that ML-KEM-768 was standardized from, as implemented by circl. (The
+// standard library only has ML-KEM as of Go 1.24.) Its public keys and
+// ciphertexts are the X25519 part followed by the Kyber768 part.
+const (
+	// HybridKEMPublicLen is the length in bytes of a serialized
+	// HybridKEMPublic.
+	HybridKEMPublicLen = 32 + kyber768.PublicKeySize
+
+	// HybridKEMCiphertextLen is the length in bytes of a ciphertext
+	// produced by HybridKEMPublic.Encapsulate.
+	HybridKEMCiphertextLen = 32 + kyber768.CiphertextSize
+)
+
+// HybridKEMPrivate is an ephemeral private key for the experimental
+// X25519+Kyber768 hybrid key exchange between peers. The shared secrets
+// it produces are only used to derive WireGuard preshared keys (see
+// HybridPresharedKey), so the classical Noise handshake is kept as is and a
+// failure of either half of the hybrid still leaves the other one.
+type HybridKEMPrivate struct {
+	_      structs.Incomparable // because == isn't constant-time
+	x25519 [32]byte
+	seed   [kyber768.KeySeedSize]byte
+}
+
+// NewHybridKEM creates and returns a new hybrid KEM private key.
+func NewHybridKEM() HybridKEMPrivate {
+	var ret HybridKEMPrivate
+	rand(ret.x25519[:])
+	clamp25519Private(ret.x25519[:])
+	rand(ret.seed[:])
+	return ret
+}
+
+// IsZero reports whether k is the zero value.
+func (k HybridKEMPrivate) IsZero() bool {
+	var zero HybridKEMPrivate
+	return subtle.ConstantTimeCompare(k.x25519[:], zero.x25519[:]) == 1 &&
+		subtle.ConstantTimeCompare(k.seed[:], zero.seed[:]) == 1
+}
+
+// Public returns the HybridKEMPublic for k.
+// Panics if HybridKEMPrivate is zero.
+func (k HybridKEMPrivate) Public() HybridKEMPublic {
+	if k.IsZero() {
+		panic("can't take the public key of a zero HybridKEMPrivate")
+	}
+	pkX, err := curve25519.X25519(k.x25519[:], curve25519.Basepoint)
+	if err != nil {
+		panic(err) // can't happen; k.x25519 is clamped
+	}
+	pkK, _ := kyber768.NewKeyFromSeed(k.seed[:])
+	ret := make([]byte, HybridKEMPublicLen)
+	copy(ret, pkX)
+	pkK.Pack(ret[32:])
+	return HybridKEMPublic{k: ret}
+}
+
+// Decapsulate returns the shared secret encapsulated in ciphertext by
+// HybridKEMPublic.Encapsulate for k's public key.
+func (k HybridKEMPrivate) Decapsulate(ciphertext []byte) (secret [32]byte, err error) {
+	if len(ciphertext) != HybridKEMCiphertextLen {
+		return secret, fmt.Errorf("hybrid KEM ciphertext is %d bytes, want %d", len(ciphertext), HybridKEMCiphertextLen)
+	}
+	ctX := ciphertext[:32]
+	_, skK := kyber768.NewKeyFromSeed(k.seed[:])
+	ssK := make([]byte, kyber768.SharedKeySize)
+	skK.DecapsulateTo(ssK, ciphertext[32:])
+	ssX, err := curve25519.X25519(k.x25519[:], ctX)
+	if err != nil {
+		return secret, err
+	}
+	pkX, err := curve25519.X25519(k.x25519[:], curve25519.Basepoint)
+	if err != nil {
+		return secret, err
+	}
+	return hybridCombine(ssK, ssX, ctX, pkX), nil
+}
+
+// HybridKEMPublic is the public portion of a HybridKEMPrivate.
+type HybridKEMPublic struct {
+	k []byte // HybridKEMPublicLen bytes, or nil for the zero value
+}
+
+// HybridKEMPublicFromRaw returns the HybridKEMPublic serialized in raw, as
+// produced by HybridKEMPublic.AppendTo.
+func HybridKEMPublicFromRaw(raw []byte) (HybridKEMPublic, error) {
+	if len(raw) != HybridKEMPublicLen {
+		return HybridKEMPublic{}, fmt.Errorf("hybrid KEM public key is %d bytes, want %d", len(raw), HybridKEMPublicLen)
+	}
+	return HybridKEMPublic{k: bytes.Clone(raw)}, nil
+}
+
+// IsZero reports whether k is the zero value.
+func (k HybridKEMPublic) IsZero() bool {
+	return len(k.k) == 0
+}
+
+// AppendTo appends k, serialized as HybridKEMPublicLen bytes, to buf.
+func (k HybridKEMPublic) AppendTo(buf []byte) []byte {
+	return append(buf, k.k...)
+}
+
+// Encapsulate generates a fresh shared secret for the holder of the private
+// half of k, returning the ciphertext to send them and the secret.
+func (k HybridKEMPublic) Encapsulate() (ciphertext []byte, secret [32]byte, err error) {
+	if len(k.k) != HybridKEMPublicLen {
+		return nil, secret, fmt.Errorf("invalid hybrid KEM public key")
+	}
+	pkX := k.k[:32]
+	ctX, ssX, err := x25519Encapsulate(pkX)
+	if err != nil {
+		return nil, secret, err
+	}
+	var pkK kyber768.PublicKey
+	pkK.Unpack(k.k[32:])
+	ciphertext = make([]byte, HybridKEMCiphertextLen)
+	copy(ciphertext, ctX)
+	ssK := make([]byte, kyber768.SharedKeySize)
+	pkK.EncapsulateTo(ciphertext[32:], ssK, nil)
+	return ciphertext, hybridCombine(ssK, ssX, ctX, pkX), nil
+}
+
+// x25519Encapsulate performs the X25519 half of an encapsulation to the
+// X25519 public key pub, returning the ephemeral public key to send to the
+// peer and the shared secret.
+func x25519Encapsulate(pub []byte) (ct, ss []byte, err error) {
+	var eph [32]byte
+	rand(eph[:])
+	clamp25519Private(eph[:])
+	ct, err = curve25519.X25519(eph[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	ss, err = curve25519.X25519(eph[:], pub)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ct, ss, nil
+}
+
+// hybridCombine derives the hybrid shared secret from the Kyber768 and X25519
+// shared secrets. Like X-Wing, it binds the X25519 ciphertext and public key
+// so the result stays secure as long as either component is.
+func hybridCombine(ssKyber, ssX25519, ctX25519, pkX25519 []byte) [32]byte {
+	h := sha256.New()
+	h.Write([]byte("tailscale hybrid kem v1"))
+	h.Write(ssKyber)
+	h.Write(ssX25519)
+	h.Write(ctX25519)
+	h.Write(pkX25519)
+	var ret [32]byte
+	h.Sum(ret[:0])
+	return ret
+}
+
+// HybridPresharedKey returns the WireGuard preshared key to use between the
+// nodes a and b given the secret they agreed on with the hybrid KEM. The
+// result is the same regardless of the order of a and b.
+func HybridPresharedKey(secret [32]byte, a, b NodePublic) [32]byte {
+	ra, rb := a.Raw32(), b.Raw32()
+	if bytes.Compare(ra[:], rb[:]) > 0 {
+		ra, rb = rb, ra
+	}
+	h := sha256.New()
+	h.Write([]byte("tailscale hybrid psk v1"))
+	h.Write(secret[:])
+	h.Write(ra[:])
+	h.Write(rb[:])
+	var ret [32]byte
+	h.Sum(ret[:0])
+	return ret
+}
@@ -0,0 +1,58 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package key
+
+import (
+	"testing"
+)
+
+func TestHybridKEM(t *testing.T) {
+	k := NewHybridKEM()
+	if k.IsZero() {
+		t.Fatal("NewHybridKEM returned zero key")
+	}
+
+	raw := k.Public().AppendTo(nil)
+	if len(raw) != HybridKEMPublicLen {
+		t.Fatalf("public key is %d bytes, want %d", len(raw), HybridKEMPublicLen)
+	}
+	pub, err := HybridKEMPublicFromRaw(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ct, secret, err := pub.Encapsulate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ct) != HybridKEMCiphertextLen {
+		t.Fatalf("ciphertext is %d bytes, want %d", len(ct), HybridKEMCiphertextLen)
+	}
+	got, err := k.Decapsulate(ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != secret {
+		t.Errorf("decapsulated secret %x, want %x", got, secret)
+	}
+
+	if got, err := NewHybridKEM().Decapsulate(ct); err == nil && got == secret {
+		t.Error("different key decapsulated the same secret")
+	}
+	if _, err := HybridKEMPublicFromRaw(raw[:100]); err == nil {
+		t.Error("short public key accepted")
+	}
+}
+
+func TestHybridPresharedKey(t *testing.T) {
+	a, b := NewNode().Public(), NewNode().Public()
+	var secret [32]byte
+	secret[0] = 1
+	if HybridPresharedKey(secret, a, b) != HybridPresharedKey(secret, b, a) {
+		t.Error("preshared key depends on node key order")
+	}
+	if HybridPresharedKey(secret, a, b) == HybridPresharedKey(secret, a, NewNode().Public()) {
+		t.Error("preshared key doesn't depend on node keys")
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package views
+
+import (
+	"reflect"
+)
+
+// Diff returns the fields that differ between the values viewed by a and b,
+// in field order. Fields of nested structs and views are reported by their
+// dotted path, like "Hostinfo.OS". An invalid view is treated as viewing the zero
+// value.
+//
+// Unexported fields are ignored. Structs without exported fields, such as
+// netip.Addr, and structs with an Equal method, such as time.Time, are
+// compared as a whole, with their Equal method if they have one. Slices and
+// maps are also compared as a whole.
+//
+// It works by reflection on copies of the values, so it's meant for logging
+// and tests rather than hot paths.
+func Diff[T any, V StructView[T]](a, b V) []string {
+	av, bv := reflect.ValueOf(a.AsStruct()), reflect.ValueOf(b.AsStruct())
+	return appendDiff(nil, "", av, bv)
+}
+
+// appendDiff appends to diffs the paths of the fields that differ between a
+// and b, which are of the same type, with path as the prefix of their paths.
+// a and b are reported as a whole, at path, if they're not structs that can
+// be compared field by field.
+func appendDiff(diffs []string, path string, a, b reflect.Value) []string {
+	t := a.Type()
+	if m, ok := t.MethodByName("AsStruct"); ok && m.Type.NumIn() == 1 && m.Type.NumOut() == 1 {
+		// A nested view, like tailcfg.HostinfoView.
+		a, b = m.Func.Call([]reflect.Value{a})[0], m.Func.Call([]reflect.Value{b})[0]
+		t = a.Type()
+	}
+	if t.Kind() == reflect.Pointer && t.Elem().Kind() == reflect.Struct {
+		switch {
+		case a.IsNil() && b.IsNil():
+			return diffs
+		case a.IsNil():
+			a = reflect.New(t.Elem())
+		case b.IsNil():
+			b = reflect.New(t.Elem())
+		}
+		return appendDiff(diffs, path, a.Elem(), b.Elem())
+	}
+	if t.Kind() != reflect.Struct || !hasExportedField(t) || equalMethod(t).IsValid() {
+		if !valuesEqual(a, b) {
+			diffs = append(diffs, path)
+		}
+		return diffs
+	}
+	for i := range t.NumField() {
+		if !t.Field(i).IsExported() {
+			continue
+		}
+		name := t.Field(i).Name
+		if path != "" {
+			name = path + "." + name
+		}
+		diffs = appendDiff(diffs, name, a.Field(i), b.Field(i))
+	}
+	return diffs
+}
+
+func hasExportedField(t reflect.Type) bool {
+	for i := range t.NumField() {
+		if t.Field(i).IsExported() {
+			return true
+		}
+	}
+	return false
+}
+
+// equalMethod returns the func of the method of t like
+//
+//	func (T) Equal(T) bool
+//
+// or the zero Value if it has none.
+func equalMethod(t reflect.Type) reflect.Value {
+	m, ok := t.MethodByName("Equal")
+	if !ok {
+		return reflect.Value{}
+	}
+	mt := m.Type
+	if mt.NumIn() != 2 || mt.In(1) != t || mt.NumOut() != 1 || mt.Out(0).Kind() != reflect.Bool {
+		return reflect.Value{}
+	}
+	return m.Func
+}
+
+// valuesEqual reports whether a and b are equal, according to their Equal
+// method if they have one.
+func valuesEqual(a, b reflect.Value) bool {
+	if eq := equalMethod(a.Type()); eq.IsValid() {
+		return eq.Call([]reflect.Value{a, b})[0].Bool()
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
@@ -7,6 +7,7 @@
 
 import (
 	"bytes"
+	"cmp"
 	"encoding/json"
 	"errors"
 	"maps"
@@ -200,6 +201,26 @@ func (v SliceView[T, V]) AsSlice() []V {
 	return v.AppendTo(nil)
 }
 
+// AppendStructsTo appends deep clones of the underlying slice values to dst,
+// as their concrete types rather than as views.
+func (v SliceView[T, V]) AppendStructsTo(dst []T) []T {
+	dst = slices.Grow(dst, len(v.ж))
+	for _, x := range v.ж {
+		dst = append(dst, x.Clone())
+	}
+	return dst
+}
+
+// AppendViews appends views of the elements of x to dst. It's the inverse of
+// SliceView.AppendStructsTo, without cloning.
+func AppendViews[T ViewCloner[T, V], V StructView[T]](dst []V, x []T) []V {
+	dst = slices.Grow(dst, len(x))
+	for _, e := range x {
+		dst = append(dst, e.View())
+	}
+	return dst
+}
+
 // Slice is a read-only accessor for a slice.
 type Slice[T any] struct {
 	// ж is the underlying mutable value, named with a hard-to-type
@@ -272,6 +293,24 @@ func (v Slice[T]) AsSlice() []T {
 	return v.AppendTo(v.ж[:0:0])
 }
 
+// CopyInto copies the underlying slice values into dst, reusing its
+// capacity, and returns the result, which is dst[:v.Len()] if dst was large
+// enough. It's like AsSlice for loops that would otherwise allocate a copy on
+// each iteration.
+func (v Slice[T]) CopyInto(dst []T) []T {
+	return append(dst[:0], v.ж...)
+}
+
+// AppendStrings appends the underlying values of v, of some string type, to
+// dst as plain strings.
+func AppendStrings[T ~string](dst []string, v Slice[T]) []string {
+	dst = slices.Grow(dst, len(v.ж))
+	for _, s := range v.ж {
+		dst = append(dst, string(s))
+	}
+	return dst
+}
+
 // IndexFunc returns the first index of an element in v satisfying f(e),
 // or -1 if none do.
 //
@@ -434,6 +473,25 @@ func (m Map[K, V]) Range(f MapRangeFn[K, V]) {
 	}
 }
 
+// All returns an iterator over the k,v pairs in the underlying map, in no
+// particular order, for use with range-over-func (Go 1.23, or Go 1.22 with
+// GOEXPERIMENT=rangefunc):
+//
+//	for k, v := range m.All() {
+//		...
+//	}
+//
+// See MapSlice to iterate in sorted key order.
+func (m Map[K, V]) All() func(yield func(K, V) bool) {
+	return func(yield func(K, V) bool) {
+		for k, v := range m.ж {
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
 // MapFnOf returns a MapFn for m.
 func MapFnOf[K comparable, T any, V any](m map[K]T, f func(T) V) MapFn[K, T, V] {
 	return MapFn[K, T, V]{
@@ -488,3 +546,239 @@ func (m MapFn[K, T, V]) Range(f MapRangeFn[K, V]) {
 		}
 	}
 }
+
+// All returns an iterator over the k,v pairs in the underlying map, in no
+// particular order. See Map.All.
+func (m MapFn[K, T, V]) All() func(yield func(K, V) bool) {
+	return func(yield func(K, V) bool) {
+		for k, v := range m.ж {
+			if !yield(k, m.wrapv(v)) {
+				return
+			}
+		}
+	}
+}
+
+// MapSliceOf returns a MapSlice over m. It is the caller's responsibility to
+// make sure K and V are immutable, if this is being used to provide a
+// read-only view over m.
+//
+// It sorts the keys of m, so iterating over the returned MapSlice doesn't
+// need to, however many times it's done.
+func MapSliceOf[K cmp.Ordered, V any](m map[K]V) MapSlice[K, V] {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return MapSlice[K, V]{ж: m, keys: keys}
+}
+
+// SortedMap returns a MapSlice over the same map as m, to iterate over it in
+// sorted key order. Unlike Map.AsMap, it doesn't copy the map.
+func SortedMap[K cmp.Ordered, V any](m Map[K, V]) MapSlice[K, V] {
+	return MapSliceOf(m.ж)
+}
+
+// MapSlice is a view over a map, like Map, that iterates over the map in
+// sorted key order without allocating.
+type MapSlice[K cmp.Ordered, V any] struct {
+	// ж is the underlying mutable value, named with a hard-to-type
+	// character that looks pointy like a pointer.
+	// It is named distinctively to make you think of how dangerous it is to escape
+	// to callers. You must not let callers be able to mutate it.
+	ж    map[K]V
+	keys []K // of ж, sorted
+}
+
+// Has reports whether k has an entry in the map.
+func (m MapSlice[K, V]) Has(k K) bool {
+	_, ok := m.ж[k]
+	return ok
+}
+
+// IsNil reports whether the underlying map is nil.
+func (m MapSlice[K, V]) IsNil() bool {
+	return m.ж == nil
+}
+
+// Len returns the number of elements in the map.
+func (m MapSlice[K, V]) Len() int { return len(m.keys) }
+
+// Get returns the element with key k.
+func (m MapSlice[K, V]) Get(k K) V {
+	return m.ж[k]
+}
+
+// GetOk returns the element with key k and a bool representing whether the key
+// is in map.
+func (m MapSlice[K, V]) GetOk(k K) (V, bool) {
+	v, ok := m.ж[k]
+	return v, ok
+}
+
+// KeyAt returns the i-th smallest key in the map.
+func (m MapSlice[K, V]) KeyAt(i int) K { return m.keys[i] }
+
+// Keys returns the keys of the map, in sorted order.
+func (m MapSlice[K, V]) Keys() Slice[K] { return SliceOf(m.keys) }
+
+// All returns an iterator over the k,v pairs in the underlying map, in sorted
+// key order. See Map.All.
+func (m MapSlice[K, V]) All() func(yield func(K, V) bool) {
+	return func(yield func(K, V) bool) {
+		for _, k := range m.keys {
+			if !yield(k, m.ж[k]) {
+				return
+			}
+		}
+	}
+}
+
+// Range calls f for every k,v pair in the underlying map, in sorted key
+// order. It stops iteration immediately if f returns false.
+func (m MapSlice[K, V]) Range(f MapRangeFn[K, V]) {
+	m.All()(f)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (m MapSlice[K, V]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.ж)
+}
+
+// AsMap returns a shallow-clone of the underlying map.
+// If V is a pointer type, it is the caller's responsibility to make sure
+// the values are immutable.
+func (m MapSlice[K, V]) AsMap() map[K]V {
+	return maps.Clone(m.ж)
+}
+
+// Concat returns a read-only view of the elements of parts, in order, as a
+// single slice, without copying them.
+func Concat[T any](parts ...Slice[T]) ConcatView[T] {
+	n := 0
+	for _, p := range parts {
+		n += p.Len()
+	}
+	return ConcatView[T]{parts: parts, n: n}
+}
+
+// ConcatView is a read-only view of several Slices as one. It should be
+// created with Concat.
+type ConcatView[T any] struct {
+	parts []Slice[T]
+	n     int // total length of parts
+}
+
+// Len returns the total number of elements of the Slices.
+func (v ConcatView[T]) Len() int { return v.n }
+
+// At returns the element at index i, counting across the Slices. It takes
+// time linear in the number of Slices.
+func (v ConcatView[T]) At(i int) T {
+	for _, p := range v.parts {
+		if i < p.Len() {
+			return p.At(i)
+		}
+		i -= p.Len()
+	}
+	panic("index out of range")
+}
+
+// All returns an iterator over the index and value of the elements, for use
+// with range-over-func. See Map.All.
+func (v ConcatView[T]) All() func(yield func(int, T) bool) {
+	return func(yield func(int, T) bool) {
+		i := 0
+		for _, p := range v.parts {
+			for _, e := range p.ж {
+				if !yield(i, e) {
+					return
+				}
+				i++
+			}
+		}
+	}
+}
+
+// ContainsFunc reports whether any element satisfies f.
+func (v ConcatView[T]) ContainsFunc(f func(T) bool) bool {
+	for _, p := range v.parts {
+		if p.ContainsFunc(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// AppendTo appends the elements to dst and returns the result.
+func (v ConcatView[T]) AppendTo(dst []T) []T {
+	dst = slices.Grow(dst, v.n)
+	for _, p := range v.parts {
+		dst = append(dst, p.ж...)
+	}
+	return dst
+}
+
+// AsSlice returns a copy of the elements as a single slice.
+func (v ConcatView[T]) AsSlice() []T {
+	return v.AppendTo(nil)
+}
+
+// Filter returns a read-only view of the elements of s for which keep
+// returns true, without copying them. keep is called each time the view is
+// used, so it should be cheap.
+func Filter[T any](s Slice[T], keep func(T) bool) FilterView[T] {
+	return FilterView[T]{s: s, keep: keep}
+}
+
+// FilterView is a read-only view of the elements of a Slice satisfying a
+// func. It should be created with Filter.
+type FilterView[T any] struct {
+	s    Slice[T]
+	keep func(T) bool
+}
+
+// Len returns the number of elements kept. It takes time linear in the
+// length of the underlying Slice.
+func (v FilterView[T]) Len() int {
+	n := 0
+	for _, e := range v.s.ж {
+		if v.keep(e) {
+			n++
+		}
+	}
+	return n
+}
+
+// All returns an iterator over the elements kept, for use with
+// range-over-func. See Map.All.
+func (v FilterView[T]) All() func(yield func(T) bool) {
+	return func(yield func(T) bool) {
+		for _, e := range v.s.ж {
+			if v.keep(e) && !yield(e) {
+				return
+			}
+		}
+	}
+}
+
+// ContainsFunc reports whether any element kept satisfies f.
+func (v FilterView[T]) ContainsFunc(f func(T) bool) bool {
+	return slices.ContainsFunc(v.s.ж, func(e T) bool { return v.keep(e) && f(e) })
+}
+
+// AppendTo appends the elements kept to dst and returns the result.
+func (v FilterView[T]) AppendTo(dst []T) []T {
+	for _, e := range v.s.ж {
+		if v.keep(e) {
+			dst = append(dst, e)
+		}
+	}
+	return dst
+}
+
+// AsSlice returns a copy of the elements kept.
+func (v FilterView[T]) AsSlice() []T {
+	return v.AppendTo(nil)
+}
@@ -8,8 +8,10 @@
 	"encoding/json"
 	"net/netip"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 )
@@ -212,3 +214,242 @@ func TestSliceMapKey(t *testing.T) {
 		}
 	}
 }
+
+type diffInner struct {
+	Name string
+	When time.Time
+}
+
+type diffStruct struct {
+	Int    int
+	Addr   netip.Addr
+	Tags   []string
+	Inner  diffInner
+	Ptr    *diffInner
+	Next   diffStructView
+	hidden int
+}
+
+// diffStructView is a StructView of diffStruct, as cmd/viewer would
+// generate.
+type diffStructView struct{ ж *diffStruct }
+
+func (v diffStructView) Valid() bool { return v.ж != nil }
+
+func (v diffStructView) AsStruct() *diffStruct {
+	if v.ж == nil {
+		return nil
+	}
+	x := *v.ж
+	return &x
+}
+
+func (x *diffStruct) View() diffStructView { return diffStructView{x} }
+
+func (x *diffStruct) Clone() *diffStruct {
+	if x == nil {
+		return nil
+	}
+	y := *x
+	y.Tags = slices.Clone(x.Tags)
+	return &y
+}
+
+func TestDiff(t *testing.T) {
+	t0 := time.Unix(1700000000, 0)
+	base := diffStruct{
+		Int:   1,
+		Addr:  netip.MustParseAddr("100.64.0.1"),
+		Tags:  []string{"a"},
+		Inner: diffInner{Name: "x", When: t0},
+	}
+	view := func(f func(*diffStruct)) diffStructView {
+		x := base
+		x.Tags = slices.Clone(base.Tags)
+		f(&x)
+		return diffStructView{&x}
+	}
+	tests := []struct {
+		name string
+		a, b diffStructView
+		want []string
+	}{
+		{"same", view(func(*diffStruct) {}), view(func(*diffStruct) {}), nil},
+		{"invalid", diffStructView{}, diffStructView{}, nil},
+		{
+			"fields",
+			view(func(*diffStruct) {}),
+			view(func(x *diffStruct) {
+				x.Int = 2
+				x.Tags = append(x.Tags, "b")
+				x.hidden = 1
+			}),
+			[]string{"Int", "Tags"},
+		},
+		{
+			"nested",
+			view(func(*diffStruct) {}),
+			view(func(x *diffStruct) {
+				x.Addr = netip.MustParseAddr("100.64.0.2")
+				x.Inner.When = t0.Add(time.Second)
+				x.Ptr = &diffInner{Name: "y"}
+			}),
+			[]string{"Addr", "Inner.When", "Ptr.Name"},
+		},
+		{
+			"view",
+			view(func(*diffStruct) {}),
+			view(func(x *diffStruct) { x.Next = diffStructView{&diffStruct{Int: 5}} }),
+			[]string{"Next.Int"},
+		},
+		{
+			"equal_method",
+			view(func(*diffStruct) {}),
+			view(func(x *diffStruct) { x.Inner.When = t0.In(time.FixedZone("X", 3600)) }),
+			nil,
+		},
+		{
+			"zero",
+			diffStructView{},
+			view(func(x *diffStruct) { x.Tags = nil }),
+			[]string{"Int", "Addr", "Inner.Name", "Inner.When"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Diff(tt.a, tt.b); !slices.Equal(got, tt.want) {
+				t.Errorf("Diff = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMapSlice(t *testing.T) {
+	m := map[string]int{"c": 3, "a": 1, "b": 2, "d": 4}
+	ms := SortedMap(MapOf(m))
+	if got, want := ms.Keys().AsSlice(), []string{"a", "b", "c", "d"}; !slices.Equal(got, want) {
+		t.Errorf("Keys = %q; want %q", got, want)
+	}
+	if got := ms.KeyAt(1); got != "b" {
+		t.Errorf("KeyAt(1) = %q; want b", got)
+	}
+	if v, ok := ms.GetOk("c"); v != 3 || !ok || ms.Has("e") || ms.Len() != 4 {
+		t.Errorf("GetOk, Has or Len wrong")
+	}
+
+	var got []int
+	ms.All()(func(k string, v int) bool {
+		got = append(got, v)
+		return k != "c"
+	})
+	if want := []int{1, 2, 3}; !slices.Equal(got, want) {
+		t.Errorf("All until c = %v; want %v", got, want)
+	}
+
+	sum := 0
+	allocs := testing.AllocsPerRun(100, func() {
+		ms.All()(func(_ string, v int) bool {
+			sum += v
+			return true
+		})
+	})
+	if allocs != 0 {
+		t.Errorf("All allocated %v times; want 0", allocs)
+	}
+
+	var unordered int
+	MapOf(m).All()(func(_ string, v int) bool {
+		unordered += v
+		return true
+	})
+	if unordered != 10 {
+		t.Errorf("Map.All sum = %v; want 10", unordered)
+	}
+}
+
+func TestConcat(t *testing.T) {
+	c := Concat(SliceOf([]int{1, 2}), SliceOf([]int(nil)), SliceOf([]int{3}))
+	if got, want := c.AsSlice(), []int{1, 2, 3}; !slices.Equal(got, want) {
+		t.Errorf("AsSlice = %v; want %v", got, want)
+	}
+	if c.Len() != 3 || c.At(2) != 3 || c.At(1) != 2 {
+		t.Errorf("Len = %v, At(1) = %v, At(2) = %v", c.Len(), c.At(1), c.At(2))
+	}
+	if !c.ContainsFunc(func(e int) bool { return e == 3 }) {
+		t.Error("ContainsFunc(3) = false")
+	}
+	var idx []int
+	c.All()(func(i, e int) bool {
+		idx = append(idx, i)
+		return e < 2
+	})
+	if want := []int{0, 1}; !slices.Equal(idx, want) {
+		t.Errorf("All until 2 = %v; want %v", idx, want)
+	}
+	if got, want := c.AppendTo([]int{0}), []int{0, 1, 2, 3}; !slices.Equal(got, want) {
+		t.Errorf("AppendTo = %v; want %v", got, want)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	even := func(e int) bool { return e%2 == 0 }
+	f := Filter(SliceOf([]int{1, 2, 3, 4, 6}), even)
+	if got, want := f.AsSlice(), []int{2, 4, 6}; !slices.Equal(got, want) {
+		t.Errorf("AsSlice = %v; want %v", got, want)
+	}
+	if f.Len() != 3 {
+		t.Errorf("Len = %v; want 3", f.Len())
+	}
+	if f.ContainsFunc(func(e int) bool { return e == 3 }) {
+		t.Error("ContainsFunc(3) = true for filtered out element")
+	}
+	var got []int
+	f.All()(func(e int) bool {
+		got = append(got, e)
+		return e < 4
+	})
+	if want := []int{2, 4}; !slices.Equal(got, want) {
+		t.Errorf("All until 4 = %v; want %v", got, want)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		f.All()(func(int) bool { return true })
+	})
+	if allocs != 0 {
+		t.Errorf("All allocated %v times; want 0", allocs)
+	}
+}
+
+func TestCopyInto(t *testing.T) {
+	buf := make([]int, 0, 8)
+	v := SliceOf([]int{1, 2, 3})
+	got := v.CopyInto(buf)
+	if !slices.Equal(got, []int{1, 2, 3}) || &got[0] != &buf[:1][0] {
+		t.Errorf("CopyInto = %v; want [1 2 3] in buf", got)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { buf = v.CopyInto(buf) }); allocs != 0 {
+		t.Errorf("CopyInto allocated %v times; want 0", allocs)
+	}
+
+	type tag string
+	if got, want := AppendStrings([]string{"a"}, SliceOf([]tag{"b", "c"})), []string{"a", "b", "c"}; !slices.Equal(got, want) {
+		t.Errorf("AppendStrings = %q; want %q", got, want)
+	}
+}
+
+func TestAppendStructsTo(t *testing.T) {
+	orig := []*diffStruct{{Int: 1, Tags: []string{"a"}}, {Int: 2}}
+	sv := SliceOfViews(orig)
+	structs := sv.AppendStructsTo(nil)
+	if len(structs) != 2 || structs[0].Int != 1 || structs[1].Int != 2 {
+		t.Fatalf("AppendStructsTo = %v", structs)
+	}
+	structs[0].Tags[0] = "changed"
+	if orig[0].Tags[0] != "a" {
+		t.Error("AppendStructsTo didn't clone")
+	}
+
+	views := AppendViews(nil, structs)
+	if len(views) != 2 || views[1].AsStruct().Int != 2 {
+		t.Errorf("AppendViews = %v", views)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package clientmetric
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/atomicfile"
+	"tailscale.com/util/mak"
+)
+
+// maxUnackedDeltas is the number of encoded deltas kept until they're
+// acknowledged, which is at least the last few hours' worth. Older ones
+// are forgotten, and can't be resent if they turn out to be lost.
+const maxUnackedDeltas = 1024
+
+// AckLogTailUpload notes that body, a JSON array of log entries, was
+// accepted by the log server, along with the metrics deltas in it.
+//
+// It implements the requirements of a logtail.Config.MetricsUploaded func.
+//
+// As log entries are uploaded in the order they were written, deltas
+// encoded before the newest delta in body that are neither in body nor
+// acknowledged already were lost, such as when logs were dropped while
+// offline. Their changes are encoded again by the next call to
+// EncodeLogTailMetricsDelta.
+func AckLogTailUpload(body []byte) {
+	var entries []struct {
+		Metrics string `json:"metrics"`
+	}
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return
+	}
+	var acked map[uint64]bool
+	var maxAcked uint64
+	for _, e := range entries {
+		hexSeq, ok := strings.CutPrefix(e.Metrics, "Q")
+		if !ok {
+			continue
+		}
+		seq, ok := parseHexVarint(hexSeq)
+		if !ok {
+			continue
+		}
+		mak.Set(&acked, seq, true)
+		maxAcked = max(maxAcked, seq)
+	}
+	if len(acked) == 0 {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	kept := unacked[:0]
+	for _, d := range unacked {
+		switch {
+		case acked[d.Seq]:
+		case d.Seq > maxAcked:
+			kept = append(kept, d)
+		default:
+			d.noteLostLocked()
+		}
+	}
+	if len(kept) == len(unacked) {
+		return
+	}
+	clear(unacked[len(kept):])
+	unacked = kept
+	saveStateLocked()
+}
+
+// noteLostLocked arranges for the changes in d to be encoded again.
+// mu must be held.
+func (d *encodedDelta) noteLostLocked() {
+	for name, delta := range d.Deltas {
+		if d.Seq < startSeq {
+			// Encoded by an earlier run, so not reflected in the
+			// values of this one.
+			mak.Set(&carried, name, carried[name]+delta)
+		} else {
+			mak.Set(&resend, name, resend[name]+delta)
+		}
+		if m, ok := metrics[name]; ok {
+			// The name may have been lost with the delta.
+			m.lastNamed = time.Time{}
+		}
+	}
+	for name, delta := range d.Carried {
+		mak.Set(&carried, name, carried[name]+delta)
+	}
+}
+
+// parseHexVarint parses the hex-encoded varint at the start of s, as
+// written by deltaEncBuf.writeHexVarint.
+func parseHexVarint(s string) (v uint64, ok bool) {
+	var buf [binary.MaxVarintLen64]byte
+	for n := 0; n < len(buf) && 2*n+2 <= len(s); n++ {
+		if _, err := hex.Decode(buf[n:n+1], []byte(s[2*n:2*n+2])); err != nil {
+			return 0, false
+		}
+		if buf[n] < 0x80 {
+			x, m := binary.Varint(buf[:n+1])
+			if m <= 0 || x < 0 {
+				return 0, false
+			}
+			return uint64(x), true
+		}
+	}
+	return 0, false
+}
+
+// clientmetricState is the format of the file set by SetStateFile.
+type clientmetricState struct {
+	Unacked []encodedDelta   `json:",omitempty"`
+	Carried map[string]int64 `json:",omitempty"` // resend and carried
+}
+
+// SetStateFile sets the file in which the metrics deltas not yet
+// acknowledged by AckLogTailUpload, or to be encoded again, are kept, so
+// that they survive restarts of the process. It loads the deltas kept in
+// the file by a previous run, if it exists.
+//
+// The increments of counters found lost after a restart are encoded again
+// as increments of the counters with the same names, even though the
+// counters themselves were reset.
+func SetStateFile(path string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	statePath = path
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st clientmetricState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return fmt.Errorf("clientmetric: parsing %s: %w", path, err)
+	}
+	unacked = append(st.Unacked, unacked...)
+	for name, delta := range st.Carried {
+		mak.Set(&carried, name, carried[name]+delta)
+	}
+	return nil
+}
+
+var (
+	stateDirty  bool           // whether the state changed since saveState last read it; guarded by mu
+	stateSaving bool           // whether a saveState goroutine is running; guarded by mu
+	stateSaveWG sync.WaitGroup // for the saveState goroutine, for tests
+)
+
+// saveStateLocked arranges for the state to be written to statePath, if
+// set, without blocking on the write. mu must be held.
+func saveStateLocked() {
+	if statePath == "" {
+		return
+	}
+	stateDirty = true
+	if !stateSaving {
+		stateSaving = true
+		stateSaveWG.Add(1)
+		go saveState()
+	}
+}
+
+// saveState writes the state to statePath until it no longer changes
+// while being written. Only mu is held while copying the state, so that
+// metrics can be encoded and acknowledged while it's being written.
+func saveState() {
+	defer stateSaveWG.Done()
+	for {
+		mu.Lock()
+		if !stateDirty || statePath == "" {
+			stateSaving = false
+			mu.Unlock()
+			return
+		}
+		stateDirty = false
+		path := statePath
+		st := clientmetricState{Unacked: slices.Clone(unacked)}
+		for _, m := range []map[string]int64{resend, carried} {
+			for name, delta := range m {
+				mak.Set(&st.Carried, name, st.Carried[name]+delta)
+			}
+		}
+		mu.Unlock()
+
+		b, err := json.Marshal(st)
+		if err != nil {
+			continue
+		}
+		// Errors are ignored: the deltas are still in memory, and they'll
+		// be written again with the next change.
+		atomicfile.WriteFile(path, b, 0600)
+	}
+}
@@ -11,11 +11,14 @@
 	"encoding/hex"
 	"fmt"
 	"io"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"tailscale.com/util/mak"
 )
 
 var (
@@ -34,8 +37,42 @@
 	// They're contiguous to reduce cache churn during diff scans.
 	// When out of length, a new backing array is made.
 	valFreeList []int64
+
+	// lastSeq is the sequence number of the last encoded delta.
+	lastSeq uint64
+
+	// unacked are the deltas encoded but not yet acknowledged by
+	// AckLogTailUpload, oldest first.
+	unacked []encodedDelta
+
+	// resend are the changes to encode again, by metric name, because
+	// the deltas they were encoded in were lost.
+	resend map[string]int64
+
+	// carried are the counter increments of earlier runs of the process
+	// to encode again, by metric name, because the deltas they were
+	// encoded in were lost. Unlike resend, they aren't reflected in the
+	// values of the metrics.
+	carried map[string]int64
+
+	// statePath is the file in which unacked, resend and carried are
+	// kept across restarts, if non-empty. See SetStateFile.
+	statePath string
 )
 
+// startSeq is the lowest sequence number of the deltas encoded by this
+// process. It's the process start time in milliseconds, so that sequence
+// numbers keep increasing across restarts without being persisted, as
+// deltas are encoded much less often than every millisecond.
+var startSeq = uint64(time.Now().UnixMilli())
+
+// encodedDelta is a delta returned by EncodeLogTailMetricsDelta.
+type encodedDelta struct {
+	Seq     uint64
+	Deltas  map[string]int64 // changes, by metric name
+	Carried map[string]int64 `json:",omitempty"` // from carried
+}
+
 // scanEntry contains the minimal data needed for quickly scanning
 // memory for changed values. It's small to reduce memory pressure.
 type scanEntry struct {
@@ -261,12 +298,20 @@ func WritePrometheusExpositionFormat(w io.Writer) {
 // without further escaping.
 //
 // The current encoding is:
+//   - sequence number, first in each delta:
+//     'Q' + hex(varint(seq))
 //   - name immediately following metric:
 //     'N' + hex(varint(len(name))) + name
 //   - set value of a metric:
 //     'S' + hex(varint(wireid)) + hex(varint(value))
 //   - increment a metric: (decrements if negative)
 //     'I' + hex(varint(wireid)) + hex(varint(value))
+//
+// Sequence numbers increase across deltas, including across restarts of
+// the process. A delta may be uploaded more than once, so the log server
+// should ignore deltas whose sequence number isn't higher than that of the
+// last one it applied. Deltas found lost by AckLogTailUpload are encoded
+// again in a later delta.
 func EncodeLogTailMetricsDelta() string {
 	mu.Lock()
 	defer mu.Unlock()
@@ -278,6 +323,7 @@ func EncodeLogTailMetricsDelta() string {
 	lastDelta = now
 
 	var enc *deltaEncBuf // lazy
+	var d encodedDelta   // what enc holds, once non-nil
 	for i, ent := range lastLogVal {
 		var val int64
 		if ent.f != nil {
@@ -285,15 +331,27 @@ func EncodeLogTailMetricsDelta() string {
 		} else {
 			val = atomic.LoadInt64(ent.v)
 		}
+		m := unsorted[i]
 		delta := val - ent.lastLogged
-		if delta == 0 {
+		lost, resending := resend[m.name]
+		prev := carried[m.name]
+		if m.typ != TypeCounter || m.deltasDisabled {
+			// Only counters can keep counting across restarts.
+			prev = 0
+		}
+		delete(carried, m.name)
+		if delta == 0 && !resending && prev == 0 {
 			continue
 		}
 		lastLogVal[i].lastLogged = val
-		m := unsorted[i]
+		delete(resend, m.name)
+		delta += lost
 		if enc == nil {
 			enc = deltaPool.Get().(*deltaEncBuf)
 			enc.buf.Reset()
+			lastSeq = max(lastSeq+1, startSeq)
+			enc.writeSeq(lastSeq)
+			d = encodedDelta{Seq: lastSeq, Deltas: make(map[string]int64)}
 		}
 		if m.wireID == 0 {
 			numWireID++
@@ -311,11 +369,21 @@ func EncodeLogTailMetricsDelta() string {
 		} else {
 			enc.writeDelta(m.wireID, delta)
 		}
+		d.Deltas[m.name] = delta
+		if prev != 0 {
+			enc.writeDelta(m.wireID, prev)
+			mak.Set(&d.Carried, m.name, prev)
+		}
 	}
 	if enc == nil {
 		return ""
 	}
 	defer deltaPool.Put(enc)
+	unacked = append(unacked, d)
+	if len(unacked) > maxUnackedDeltas {
+		unacked = slices.Delete(unacked, 0, len(unacked)-maxUnackedDeltas)
+	}
+	saveStateLocked()
 	return enc.buf.String()
 }
 
@@ -332,6 +400,12 @@ type deltaEncBuf struct {
 	scratch [binary.MaxVarintLen64]byte
 }
 
+// writeSeq writes a "sequence number" (Q) record to the buffer.
+func (b *deltaEncBuf) writeSeq(seq uint64) {
+	b.buf.WriteByte('Q')
+	b.writeHexVarint(int64(seq))
+}
+
 // writeName writes a "name" (N) record to the buffer, which notes
 // that the immediately following record's wireID has the provided
 // name.
@@ -4,6 +4,8 @@
 package clientmetric
 
 import (
+	"fmt"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -28,6 +30,7 @@ func TestDeltaEncBuf(t *testing.T) {
 }
 
 func clearMetrics() {
+	stateSaveWG.Wait()
 	mu.Lock()
 	defer mu.Unlock()
 	metrics = map[string]*Metric{}
@@ -36,6 +39,12 @@ func clearMetrics() {
 	sorted = nil
 	lastLogVal = nil
 	unsorted = nil
+	lastSeq = 0
+	startSeq = 1
+	unacked = nil
+	resend = nil
+	carried = nil
+	statePath = ""
 }
 
 func advanceTime() {
@@ -50,13 +59,13 @@ func TestEncodeLogTailMetricsDelta(t *testing.T) {
 	c1 := NewCounter("foo")
 	c2 := NewGauge("bar")
 	c1.Add(123)
-	if got, want := EncodeLogTailMetricsDelta(), "N06fooS02f601"; got != want {
+	if got, want := EncodeLogTailMetricsDelta(), "Q02N06fooS02f601"; got != want {
 		t.Errorf("first = %q; want %q", got, want)
 	}
 
 	c2.Add(456)
 	advanceTime()
-	if got, want := EncodeLogTailMetricsDelta(), "N12gauge_barS049007"; got != want {
+	if got, want := EncodeLogTailMetricsDelta(), "Q04N12gauge_barS049007"; got != want {
 		t.Errorf("second = %q; want %q", got, want)
 	}
 
@@ -68,7 +77,7 @@ func TestEncodeLogTailMetricsDelta(t *testing.T) {
 	c1.Add(1)
 	c2.Add(2)
 	advanceTime()
-	if got, want := EncodeLogTailMetricsDelta(), "I0202I0404"; got != want {
+	if got, want := EncodeLogTailMetricsDelta(), "Q06I0202I0404"; got != want {
 		t.Errorf("with increments = %q; want %q", got, want)
 	}
 }
@@ -80,13 +89,13 @@ func TestDisableDeltas(t *testing.T) {
 	c.DisableDeltas()
 	c.Set(123)
 
-	if got, want := EncodeLogTailMetricsDelta(), "N06fooS02f601"; got != want {
+	if got, want := EncodeLogTailMetricsDelta(), "Q02N06fooS02f601"; got != want {
 		t.Errorf("first = %q; want %q", got, want)
 	}
 
 	c.Set(456)
 	advanceTime()
-	if got, want := EncodeLogTailMetricsDelta(), "S029007"; got != want {
+	if got, want := EncodeLogTailMetricsDelta(), "Q04S029007"; got != want {
 		t.Errorf("second = %q; want %q", got, want)
 	}
 }
@@ -97,13 +106,106 @@ func TestWithFunc(t *testing.T) {
 	v := int64(123)
 	NewCounterFunc("foo", func() int64 { return v })
 
-	if got, want := EncodeLogTailMetricsDelta(), "N06fooS02f601"; got != want {
+	if got, want := EncodeLogTailMetricsDelta(), "Q02N06fooS02f601"; got != want {
 		t.Errorf("first = %q; want %q", got, want)
 	}
 
 	v = 456
 	advanceTime()
-	if got, want := EncodeLogTailMetricsDelta(), "I029a05"; got != want {
+	if got, want := EncodeLogTailMetricsDelta(), "Q04I029a05"; got != want {
 		t.Errorf("second = %q; want %q", got, want)
 	}
 }
+
+// uploaded returns a log upload body holding the given metrics deltas.
+func uploaded(deltas ...string) []byte {
+	b := []byte("[")
+	for i, d := range deltas {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = fmt.Appendf(b, `{"logtail": {}, "metrics": "%s","text": "x"}`, d)
+	}
+	return append(b, ']')
+}
+
+func TestAckLogTailUpload(t *testing.T) {
+	clearMetrics()
+
+	c := NewCounter("foo")
+	c.Add(1)
+	d1 := EncodeLogTailMetricsDelta()
+	c.Add(2)
+	advanceTime()
+	d2 := EncodeLogTailMetricsDelta()
+	c.Add(3)
+	advanceTime()
+	d3 := EncodeLogTailMetricsDelta()
+	if d1 != "Q02N06fooS0202" || d2 != "Q04I0204" || d3 != "Q06I0206" {
+		t.Fatalf("deltas = %q, %q, %q", d1, d2, d3)
+	}
+
+	// A delta in a field of another name, even one nested in the entry,
+	// isn't acknowledged.
+	AckLogTailUpload(fmt.Appendf(nil, `[{"text": "x", "fields": {"metrics": %q}}]`, d2))
+
+	// Acknowledging the first and third delta means the second was lost.
+	AckLogTailUpload(uploaded(d1))
+	AckLogTailUpload(fmt.Appendf(nil, `[{"metrics":%q}]`, d3))
+	advanceTime()
+	d4 := EncodeLogTailMetricsDelta()
+	if want := "Q08N06fooS020c"; d4 != want {
+		t.Errorf("after loss = %q; want %q", d4, want)
+	}
+	AckLogTailUpload(uploaded(d4))
+
+	// Uploading the same delta twice is harmless.
+	c.Add(1)
+	advanceTime()
+	d5 := EncodeLogTailMetricsDelta()
+	AckLogTailUpload(uploaded(d5))
+	AckLogTailUpload(uploaded(d5))
+	advanceTime()
+	if got, want := EncodeLogTailMetricsDelta(), ""; got != want {
+		t.Errorf("after acks = %q; want %q", got, want)
+	}
+	if len(unacked) != 0 {
+		t.Errorf("unacked = %v; want none", unacked)
+	}
+}
+
+func TestStateFile(t *testing.T) {
+	clearMetrics()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	t.Cleanup(clearMetrics) // waits for the state to be saved
+	if err := SetStateFile(path); err != nil {
+		t.Fatal(err)
+	}
+	NewCounter("foo").Add(3)
+	NewGauge("bar").Add(5)
+	if got, want := EncodeLogTailMetricsDelta(), "Q02N06fooS0206N12gauge_barS040a"; got != want {
+		t.Fatalf("first = %q; want %q", got, want)
+	}
+
+	// Restart before the delta is acknowledged.
+	clearMetrics()
+	startSeq = 100
+	if err := SetStateFile(path); err != nil {
+		t.Fatal(err)
+	}
+	c := NewCounter("foo")
+	NewGauge("bar")
+	c.Add(1)
+	d := EncodeLogTailMetricsDelta()
+	if want := "Qc801N06fooS0202"; d != want {
+		t.Fatalf("after restart = %q; want %q", d, want)
+	}
+
+	// The delta from before the restart was lost, so its counter
+	// increment is encoded again, but not its gauge value.
+	AckLogTailUpload(uploaded(d))
+	advanceTime()
+	if got, want := EncodeLogTailMetricsDelta(), "Qca01N06fooS0202I0206"; got != want {
+		t.Errorf("after loss = %q; want %q", got, want)
+	}
+}
@@ -8,6 +8,8 @@
 import (
 	"errors"
 	"fmt"
+	"net/netip"
+	"strconv"
 	"strings"
 )
 
@@ -69,9 +71,20 @@ func (n *fakeIPTables) Exists(table, chain string, args ...string) (bool, error)
 	}
 }
 
+// Delete deletes the rule matching args, or the rule with the given number,
+// counting from 1, if args is just a number, as iptables -D does.
 func (n *fakeIPTables) Delete(table, chain string, args ...string) error {
 	k := table + "/" + chain
 	if rules, ok := n.n[k]; ok {
+		if len(args) == 1 {
+			if num, err := strconv.Atoi(args[0]); err == nil {
+				if num < 1 || num > len(rules) {
+					return fmt.Errorf("bad rule number %d in %s", num, k)
+				}
+				n.n[k] = append(rules[:num-1], rules[num:]...)
+				return nil
+			}
+		}
 		for i, rule := range rules {
 			if rule == strings.Join(args, " ") {
 				rules = append(rules[:i], rules[i+1:]...)
@@ -85,6 +98,88 @@ func (n *fakeIPTables) Delete(table, chain string, args ...string) error {
 	}
 }
 
+// List lists the rules of the chain like iptables -S does: after a line
+// declaring the chain, the rules in a canonical form that needn't match the
+// arguments they were added with. See iptablesSaveForm.
+func (n *fakeIPTables) List(table, chain string) ([]string, error) {
+	k := table + "/" + chain
+	rules, ok := n.n[k]
+	if !ok {
+		return nil, fmt.Errorf("unknown table/chain %s", k)
+	}
+	lines := make([]string, 0, len(rules)+1)
+	if chain == strings.ToUpper(chain) {
+		lines = append(lines, "-P "+chain+" ACCEPT")
+	} else {
+		lines = append(lines, "-N "+chain)
+	}
+	for _, rule := range rules {
+		lines = append(lines, "-A "+chain+" "+iptablesSaveForm(strings.Fields(rule)))
+	}
+	return lines, nil
+}
+
+// iptablesSaveForm returns the rule with arguments args as iptables lists
+// it, for the arguments used by linuxfw: with short option names, host
+// addresses given a prefix length, implicit matches of protocols spelled
+// out, and comments quoted.
+func iptablesSaveForm(args []string) string {
+	var out []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch a {
+		case "--destination":
+			a = "-d"
+		case "--source":
+			a = "-s"
+		case "--in-interface":
+			a = "-i"
+		case "--out-interface":
+			a = "-o"
+		case "--protocol":
+			a = "-p"
+		}
+		out = append(out, a)
+		if i+1 == len(args) {
+			break
+		}
+		switch a {
+		case "-d", "-s":
+			i++
+			v := args[i]
+			if ip, err := netip.ParseAddr(v); err == nil {
+				v = netip.PrefixFrom(ip, ip.BitLen()).String()
+			}
+			out = append(out, v)
+		case "-p":
+			i++
+			out = append(out, args[i])
+			if args[i] == "tcp" || args[i] == "udp" {
+				out = append(out, "-m", args[i])
+			}
+		case "--comment":
+			i++
+			out = append(out, strconv.Quote(args[i]))
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// ListWithCounters is like List, with zero counters, as there's no
+// traffic through the fake.
+func (n *fakeIPTables) ListWithCounters(table, chain string) ([]string, error) {
+	lines, err := n.List(table, chain)
+	if err != nil {
+		return nil, err
+	}
+	for i, line := range lines {
+		if rule, ok := strings.CutPrefix(line, "-A "+chain); ok {
+			lines[i] = "-A " + chain + " -c 0 0" + rule
+		}
+	}
+	return lines, nil
+}
+
 func (n *fakeIPTables) ClearChain(table, chain string) error {
 	k := table + "/" + chain
 	if _, ok := n.n[k]; ok {
@@ -121,6 +216,6 @@ func NewFakeIPTablesRunner() *iptablesRunner {
 	ipt4 := newFakeIPTables()
 	ipt6 := newFakeIPTables()
 
-	iptr := &iptablesRunner{ipt4, ipt6, true, true}
+	iptr := &iptablesRunner{ipt4, ipt6, true, true, ""}
 	return iptr
 }
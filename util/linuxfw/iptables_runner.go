@@ -25,6 +25,8 @@ type iptablesInterface interface {
 	Append(table, chain string, args ...string) error
 	Exists(table, chain string, args ...string) (bool, error)
 	Delete(table, chain string, args ...string) error
+	List(table, chain string) ([]string, error)
+	ListWithCounters(table, chain string) ([]string, error)
 	ClearChain(table, chain string) error
 	NewChain(table, chain string) error
 	DeleteChain(table, chain string) error
@@ -36,6 +38,7 @@ type iptablesRunner struct {
 
 	v6Available    bool
 	v6NATAvailable bool
+	v6Reason       string // why IPv6 or IPv6 NAT isn't available
 }
 
 func checkIP6TablesExists() error {
@@ -57,17 +60,18 @@ func newIPTablesRunner(logf logger.Logf) (*iptablesRunner, error) {
 	}
 
 	supportsV6, supportsV6NAT := false, false
+	var v6Reason string
 	v6err := checkIPv6(logf)
 	ip6terr := checkIP6TablesExists()
 	switch {
 	case v6err != nil:
 		logf("disabling tunneled IPv6 due to system IPv6 config: %v", v6err)
+		v6Reason = fmt.Sprintf("system IPv6 config: %v", v6err)
 	case ip6terr != nil:
 		logf("disabling tunneled IPv6 due to missing ip6tables: %v", ip6terr)
+		v6Reason = fmt.Sprintf("missing ip6tables: %v", ip6terr)
 	default:
 		supportsV6 = true
-		supportsV6NAT = supportsV6 && checkSupportsV6NAT()
-		logf("v6nat = %v", supportsV6NAT)
 	}
 
 	var ipt6 *iptables.IPTables
@@ -76,8 +80,27 @@ func newIPTablesRunner(logf logger.Logf) (*iptablesRunner, error) {
 		if err != nil {
 			return nil, err
 		}
+		if err := probeIP6TablesNAT(ipt6); err != nil {
+			v6Reason = err.Error()
+		} else {
+			supportsV6NAT = true
+		}
+		logf("v6nat = %v", supportsV6NAT)
 	}
-	return &iptablesRunner{ipt4, ipt6, supportsV6, supportsV6NAT}, nil
+	return &iptablesRunner{ipt4, ipt6, supportsV6, supportsV6NAT, v6Reason}, nil
+}
+
+// probeIP6TablesNAT returns an error if IPv6 NAT rules can't be installed
+// with ipt6. Listing the nat table makes ip6tables load the kernel module
+// for it if needed, so it fails only if the kernel can't NAT IPv6.
+func probeIP6TablesNAT(ipt6 iptablesInterface) error {
+	if err := checkSupportsV6NAT(); err != nil {
+		return err
+	}
+	if _, err := ipt6.List("nat", "POSTROUTING"); err != nil {
+		return fmt.Errorf("ip6tables nat table unusable: %w", err)
+	}
+	return nil
 }
 
 // HasIPV6 reports true if the system supports IPv6.
@@ -90,6 +113,15 @@ func (i *iptablesRunner) HasIPV6NAT() bool {
 	return i.v6NATAvailable
 }
 
+// IPv6Capabilities reports the IPv6 support probed when i was created.
+func (i *iptablesRunner) IPv6Capabilities() IPv6Capabilities {
+	return IPv6Capabilities{
+		Available: i.v6Available,
+		NAT:       i.v6NATAvailable,
+		Reason:    i.v6Reason,
+	}
+}
+
 func isErrChainNotExist(err error) bool {
 	return errCode(err) == 1
 }
@@ -293,23 +325,213 @@ func (i *iptablesRunner) addBase4(tunname string) error {
 }
 
 func (i *iptablesRunner) AddDNATRule(origDst, dst netip.Addr) error {
-	table := i.getIPTByAddr(dst)
-	return table.Insert("nat", "PREROUTING", 1, "--destination", origDst.String(), "-j", "DNAT", "--to-destination", dst.String())
+	return i.addProxyRule(proxyRule{kind: proxyRuleDNAT, a: origDst, b: dst}, nil)
+}
+
+func (i *iptablesRunner) AddDNATRuleForPort(origDst, dst netip.Addr, pm PortMap) error {
+	pr := proxyRule{kind: proxyRuleDNATPort, a: origDst, b: dst, pm: pm}
+	if err := pr.validate(); err != nil {
+		return err
+	}
+	return i.addProxyRule(pr, nil)
+}
+
+func (i *iptablesRunner) DelDNATRuleForPort(origDst, dst netip.Addr, pm PortMap) error {
+	pr := proxyRule{kind: proxyRuleDNATPort, a: origDst, b: dst, pm: pm}
+	if err := pr.validate(); err != nil {
+		return err
+	}
+	table, chain, _, args := proxyRuleSpec(pr)
+	ipt := i.getIPTByAddr(origDst)
+	exists, err := ipt.Exists(table, chain, args...)
+	if err != nil {
+		return fmt.Errorf("checking for %v in %s/%s: %w", args, table, chain, err)
+	}
+	if !exists {
+		return nil
+	}
+	if err := ipt.Delete(table, chain, args...); err != nil {
+		return fmt.Errorf("removing %v in %s/%s: %w", args, table, chain, err)
+	}
+	return nil
 }
 
 func (i *iptablesRunner) AddSNATRuleForDst(src, dst netip.Addr) error {
-	table := i.getIPTByAddr(dst)
-	return table.Insert("nat", "POSTROUTING", 1, "--destination", dst.String(), "-j", "SNAT", "--to-source", src.String())
+	return i.addProxyRule(proxyRule{kind: proxyRuleSNAT, a: src, b: dst}, nil)
 }
 
 func (i *iptablesRunner) DNATNonTailscaleTraffic(tun string, dst netip.Addr) error {
-	table := i.getIPTByAddr(dst)
-	return table.Insert("nat", "PREROUTING", 1, "!", "-i", tun, "-j", "DNAT", "--to-destination", dst.String())
+	return i.addProxyRule(proxyRule{kind: proxyRuleDNATNonTailscale, a: dst, iface: tun}, nil)
 }
 
 func (i *iptablesRunner) ClampMSSToPMTU(tun string, addr netip.Addr) error {
-	table := i.getIPTByAddr(addr)
-	return table.Append("mangle", "FORWARD", "-o", tun, "-p", "tcp", "--tcp-flags", "SYN,RST", "SYN", "-j", "TCPMSS", "--clamp-mss-to-pmtu")
+	return i.addProxyRule(proxyRule{kind: proxyRuleClampMSS, a: addr, iface: tun}, nil)
+}
+
+// iptablesProxyRuleChains are the table/chain pairs that proxy rules are
+// installed in.
+var iptablesProxyRuleChains = [][2]string{
+	{"nat", "PREROUTING"},
+	{"nat", "POSTROUTING"},
+	{"mangle", "FORWARD"},
+}
+
+// proxyRuleSpec returns the table and chain of pr, whether it's inserted
+// at the start of the chain rather than appended, and its rulespec.
+func proxyRuleSpec(pr proxyRule) (table, chain string, insert bool, args []string) {
+	switch pr.kind {
+	case proxyRuleDNAT:
+		return "nat", "PREROUTING", true, []string{"--destination", pr.a.String(), "-j", "DNAT", "--to-destination", pr.b.String()}
+	case proxyRuleSNAT:
+		return "nat", "POSTROUTING", true, []string{"--destination", pr.b.String(), "-j", "SNAT", "--to-source", pr.a.String()}
+	case proxyRuleDNATNonTailscale:
+		return "nat", "PREROUTING", true, []string{"!", "-i", pr.iface, "-j", "DNAT", "--to-destination", pr.a.String()}
+	case proxyRuleDNATPort:
+		return "nat", "PREROUTING", true, []string{
+			"--destination", pr.a.String(),
+			"-p", pr.pm.Protocol, "--dport", strconv.Itoa(int(pr.pm.MatchPort)),
+			"-j", "DNAT", "--to-destination", netip.AddrPortFrom(pr.b, pr.pm.TargetPort).String(),
+		}
+	case proxyRuleClampMSS:
+		return "mangle", "FORWARD", false, []string{"-o", pr.iface, "-p", "tcp", "--tcp-flags", "SYN,RST", "SYN", "-j", "TCPMSS", "--clamp-mss-to-pmtu"}
+	}
+	panic(fmt.Sprintf("unknown proxy rule kind %q", pr.kind))
+}
+
+// addProxyRule installs pr, with the extra match args first.
+func (i *iptablesRunner) addProxyRule(pr proxyRule, extra []string) error {
+	table, chain, insert, args := proxyRuleSpec(pr)
+	args = append(extra, args...)
+	ipt := i.getIPTByAddr(pr.a)
+	if insert {
+		return ipt.Insert(table, chain, 1, args...)
+	}
+	return ipt.Append(table, chain, args...)
+}
+
+// EnsureProxyRules installs the proxy rules in rules that aren't installed
+// yet, and removes the installed proxy rules that aren't in rules, as well
+// as duplicates. The rules are told apart from others by their comments.
+func (i *iptablesRunner) EnsureProxyRules(rules ProxyRules) error {
+	want, skipped6, err := proxyRulesToInstall(rules, i.HasIPV6NAT())
+	if err != nil {
+		return err
+	}
+	wantIDs := make(map[string]bool)
+	for _, pr := range want {
+		wantIDs[pr.id()] = true
+	}
+
+	var errs []error
+	for _, ipt := range i.getNATTables() {
+		installed := make(map[string]bool)
+		for _, tc := range iptablesProxyRuleChains {
+			table, chain := tc[0], tc[1]
+			lines, err := ipt.List(table, chain)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("listing %s/%s: %w", table, chain, err))
+				continue
+			}
+			var stale []int // rule numbers, in increasing order
+			ruleNum := 0
+			for _, line := range lines {
+				if !strings.HasPrefix(line, "-A ") {
+					continue // the chain's "-N" or "-P" line
+				}
+				ruleNum++
+				id, ok := proxyRuleIDOf(line)
+				if !ok {
+					continue
+				}
+				if wantIDs[id] && !installed[id] {
+					installed[id] = true
+					continue
+				}
+				// Stale, such as left over from an earlier
+				// configuration, or a duplicate.
+				stale = append(stale, ruleNum)
+			}
+			// Delete the stale rules by number, as the listed rules
+			// don't round-trip as arguments: iptables quotes comments
+			// and canonicalises addresses and matches. Go from the
+			// last to the first, so the numbers of the rules yet to
+			// delete don't change.
+			for j := len(stale) - 1; j >= 0; j-- {
+				if err := ipt.Delete(table, chain, strconv.Itoa(stale[j])); err != nil {
+					errs = append(errs, fmt.Errorf("deleting stale rule %d of %s/%s: %w", stale[j], table, chain, err))
+				}
+			}
+		}
+		for _, pr := range want {
+			if i.getIPTByAddr(pr.a) != ipt || installed[pr.id()] {
+				continue
+			}
+			if err := i.addProxyRule(pr, []string{"-m", "comment", "--comment", pr.id()}); err != nil {
+				errs = append(errs, fmt.Errorf("adding %s proxy rule: %w", pr.kind, err))
+			}
+		}
+	}
+	if skipped6 > 0 {
+		errs = append(errs, fmt.Errorf("skipped %d IPv6 proxy rules: %w", skipped6, ErrIPv6NATUnsupported))
+	}
+	return multierr.New(errs...)
+}
+
+// ProxyRuleCounters returns the counters of the proxy rules installed by
+// EnsureProxyRules.
+func (i *iptablesRunner) ProxyRuleCounters() ([]ProxyRuleCounter, error) {
+	var counters []ProxyRuleCounter
+	for _, ipt := range i.getNATTables() {
+		for _, tc := range iptablesProxyRuleChains {
+			table, chain := tc[0], tc[1]
+			lines, err := ipt.ListWithCounters(table, chain)
+			if err != nil {
+				return nil, fmt.Errorf("listing %s/%s: %w", table, chain, err)
+			}
+			for _, line := range lines {
+				id, ok := proxyRuleIDOf(line)
+				if !ok {
+					continue
+				}
+				packets, bytes, ok := iptablesCountersOf(line)
+				if !ok {
+					return nil, fmt.Errorf("no counters in rule %q", line)
+				}
+				counters = append(counters, newProxyRuleCounter(id, packets, bytes))
+			}
+		}
+	}
+	return counters, nil
+}
+
+// iptablesCountersOf returns the packet and byte counters of line, a rule
+// as listed by iptables -v -S, which has them as "-c <packets> <bytes>".
+func iptablesCountersOf(line string) (packets, bytes uint64, ok bool) {
+	f := strings.Fields(line)
+	for j := 0; j+2 < len(f); j++ {
+		if f[j] != "-c" {
+			continue
+		}
+		p, err1 := strconv.ParseUint(f[j+1], 10, 64)
+		b, err2 := strconv.ParseUint(f[j+2], 10, 64)
+		if err1 == nil && err2 == nil {
+			return p, b, true
+		}
+	}
+	return 0, 0, false
+}
+
+// proxyRuleIDOf returns the proxy rule ID in the comment of line, a rule
+// as listed by iptables -S.
+func proxyRuleIDOf(line string) (id string, ok bool) {
+	f := strings.Fields(line)
+	for j := 0; j+1 < len(f); j++ {
+		if f[j] == "--comment" {
+			id = strings.Trim(f[j+1], `"`)
+			return id, strings.HasPrefix(id, proxyRuleIDPrefix)
+		}
+	}
+	return "", false
 }
 
 // addBase6 adds some basic IPv6 processing rules to be
@@ -6,7 +6,9 @@
 package linuxfw
 
 import (
+	"errors"
 	"net/netip"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -283,3 +285,215 @@ func TestAddAndDelSNATRule(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestEnsureProxyRules(t *testing.T) {
+	iptr := NewFakeIPTablesRunner()
+	tsIP := netip.MustParseAddr("100.64.0.1")
+	dst1 := netip.MustParseAddr("10.0.0.1")
+	dst2 := netip.MustParseAddr("10.0.0.2")
+
+	// A rule not installed by EnsureProxyRules, which must be left alone.
+	if err := iptr.AddDNATRule(tsIP, dst1); err != nil {
+		t.Fatal(err)
+	}
+	unmanaged := "--destination 100.64.0.1 -j DNAT --to-destination 10.0.0.1"
+
+	rulesFor := func(dst netip.Addr) ProxyRules {
+		return ProxyRules{
+			DNAT:             []DNATRule{{OrigDst: tsIP, Dst: dst}},
+			DNATNonTailscale: []DNATNonTailscaleRule{{ExemptInterface: "tailscale0", Dst: dst}},
+			SNAT:             []SNATRule{{Src: tsIP, Dst: dst}},
+			ClampMSS:         []ClampMSSRule{{Tun: "tailscale0", Addr: dst}, {Tun: "tailscale0", Addr: dst}},
+			DNATPort:         []DNATPortRule{{OrigDst: tsIP, Dst: dst, PortMap: PortMap{Protocol: "udp", MatchPort: 53, TargetPort: 5353}}},
+		}
+	}
+	checkRules := func(dst netip.Addr, wantManaged bool) {
+		t.Helper()
+		ipt := iptr.ipt4.(*fakeIPTables)
+		want := map[string][]string{
+			"nat/PREROUTING":  {unmanaged},
+			"nat/POSTROUTING": nil,
+			"mangle/FORWARD":  nil,
+		}
+		if wantManaged {
+			for _, pr := range rulesFor(dst).rules() {
+				table, chain, insert, args := proxyRuleSpec(pr)
+				rule := strings.Join(append([]string{"-m", "comment", "--comment", pr.id()}, args...), " ")
+				k := table + "/" + chain
+				if insert {
+					want[k] = append([]string{rule}, want[k]...)
+				} else {
+					want[k] = append(want[k], rule)
+				}
+			}
+		}
+		for k, wantRules := range want {
+			got := ipt.n[k]
+			if strings.Join(got, "\n") != strings.Join(wantRules, "\n") {
+				t.Errorf("%s rules:\n%s\nwant:\n%s", k, strings.Join(got, "\n"), strings.Join(wantRules, "\n"))
+			}
+		}
+	}
+
+	if err := iptr.EnsureProxyRules(rulesFor(dst1)); err != nil {
+		t.Fatal(err)
+	}
+	checkRules(dst1, true)
+
+	// Like iptables, the fake lists the rules in a form that differs
+	// from the arguments they were added with, so stale rules must be
+	// deleted some other way than with the listed arguments.
+	lines, err := iptr.ipt4.List("nat", "POSTROUTING")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLine := `-A POSTROUTING -m comment --comment "ts-proxy,snat,100.64.0.1,10.0.0.1" -d 10.0.0.1/32 -j SNAT --to-source 100.64.0.1`
+	if len(lines) != 2 || lines[1] != wantLine {
+		t.Errorf("listed POSTROUTING:\n%s\nwant a line:\n%s", strings.Join(lines, "\n"), wantLine)
+	}
+
+	// Ensuring the same rules again changes nothing.
+	if err := iptr.EnsureProxyRules(rulesFor(dst1)); err != nil {
+		t.Fatal(err)
+	}
+	checkRules(dst1, true)
+
+	// Duplicates, as left by an earlier run, are removed.
+	pr := proxyRule{kind: proxyRuleSNAT, a: tsIP, b: dst1}
+	if err := iptr.addProxyRule(pr, []string{"-m", "comment", "--comment", pr.id()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := iptr.EnsureProxyRules(rulesFor(dst1)); err != nil {
+		t.Fatal(err)
+	}
+	checkRules(dst1, true)
+
+	// Changed rules replace the stale ones.
+	if err := iptr.EnsureProxyRules(rulesFor(dst2)); err != nil {
+		t.Fatal(err)
+	}
+	checkRules(dst2, true)
+
+	if err := iptr.EnsureProxyRules(ProxyRules{}); err != nil {
+		t.Fatal(err)
+	}
+	checkRules(dst2, false)
+}
+
+func TestDNATRuleForPort(t *testing.T) {
+	iptr := NewFakeIPTablesRunner()
+	origDst := netip.MustParseAddr("100.64.0.1")
+	dst := netip.MustParseAddr("10.0.0.1")
+	pm := PortMap{Protocol: "tcp", MatchPort: 80, TargetPort: 8080}
+
+	if err := iptr.AddDNATRuleForPort(origDst, dst, pm); err != nil {
+		t.Fatal(err)
+	}
+	want := "--destination 100.64.0.1 -p tcp --dport 80 -j DNAT --to-destination 10.0.0.1:8080"
+	if got := iptr.ipt4.(*fakeIPTables).n["nat/PREROUTING"]; len(got) != 1 || got[0] != want {
+		t.Errorf("rules = %q; want [%q]", got, want)
+	}
+
+	if err := iptr.DelDNATRuleForPort(origDst, dst, pm); err != nil {
+		t.Fatal(err)
+	}
+	if got := iptr.ipt4.(*fakeIPTables).n["nat/PREROUTING"]; len(got) != 0 {
+		t.Errorf("rules after delete = %q; want none", got)
+	}
+	// Deleting a rule that isn't installed is not an error.
+	if err := iptr.DelDNATRuleForPort(origDst, dst, pm); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bad := range []PortMap{
+		{Protocol: "sctp", MatchPort: 80, TargetPort: 80},
+		{Protocol: "tcp", MatchPort: 0, TargetPort: 80},
+		{Protocol: "udp", MatchPort: 53, TargetPort: 0},
+	} {
+		if err := iptr.AddDNATRuleForPort(origDst, dst, bad); err == nil {
+			t.Errorf("AddDNATRuleForPort(%+v) succeeded; want error", bad)
+		}
+	}
+}
+
+func TestProxyRuleCounters(t *testing.T) {
+	iptr := NewFakeIPTablesRunner()
+	tsIP := netip.MustParseAddr("100.64.0.1")
+	dst := netip.MustParseAddr("10.0.0.1")
+
+	// Rules not installed by EnsureProxyRules have no counters.
+	if err := iptr.AddDNATRule(tsIP, dst); err != nil {
+		t.Fatal(err)
+	}
+	if err := iptr.EnsureProxyRules(ProxyRules{
+		DNAT: []DNATRule{{OrigDst: tsIP, Dst: dst}},
+		SNAT: []SNATRule{{Src: tsIP, Dst: dst}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := iptr.ProxyRuleCounters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ProxyRuleCounter{
+		{Kind: "dnat", ID: "ts-proxy,dnat,100.64.0.1,10.0.0.1"},
+		{Kind: "snat", ID: "ts-proxy,snat,100.64.0.1,10.0.0.1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ProxyRuleCounters = %+v; want %+v", got, want)
+	}
+}
+
+func TestIPTablesCountersOf(t *testing.T) {
+	tests := []struct {
+		line           string
+		packets, bytes uint64
+		ok             bool
+	}{
+		{
+			line:    `-A PREROUTING -d 100.64.0.1/32 -m comment --comment "ts-proxy,dnat,100.64.0.1,10.0.0.1" -c 12 720 -j DNAT --to-destination 10.0.0.1`,
+			packets: 12,
+			bytes:   720,
+			ok:      true,
+		},
+		{
+			line: `-A PREROUTING -d 100.64.0.1/32 -j DNAT --to-destination 10.0.0.1`,
+		},
+		{
+			line: `-A PREROUTING -c x 1`,
+		},
+	}
+	for _, tt := range tests {
+		packets, bytes, ok := iptablesCountersOf(tt.line)
+		if packets != tt.packets || bytes != tt.bytes || ok != tt.ok {
+			t.Errorf("iptablesCountersOf(%q) = %d, %d, %v; want %d, %d, %v", tt.line, packets, bytes, ok, tt.packets, tt.bytes, tt.ok)
+		}
+	}
+}
+
+func TestEnsureProxyRulesWithoutIPv6NAT(t *testing.T) {
+	iptr := NewFakeIPTablesRunner()
+	iptr.v6NATAvailable = false
+	iptr.v6Reason = "no nat table"
+
+	err := iptr.EnsureProxyRules(ProxyRules{
+		DNAT: []DNATRule{
+			{OrigDst: netip.MustParseAddr("100.64.0.1"), Dst: netip.MustParseAddr("10.0.0.1")},
+			{OrigDst: netip.MustParseAddr("fd7a:115c:a1e0::1"), Dst: netip.MustParseAddr("fd00::1")},
+		},
+	})
+	if !errors.Is(err, ErrIPv6NATUnsupported) {
+		t.Fatalf("EnsureProxyRules error = %v; want ErrIPv6NATUnsupported", err)
+	}
+	if got := iptr.ipt4.(*fakeIPTables).n["nat/PREROUTING"]; len(got) != 1 {
+		t.Errorf("IPv4 rules = %q; want the IPv4 rule installed", got)
+	}
+	if got := iptr.ipt6.(*fakeIPTables).n["nat/PREROUTING"]; len(got) != 0 {
+		t.Errorf("IPv6 rules = %q; want none", got)
+	}
+
+	want := IPv6Capabilities{Available: true, NAT: false, Reason: "no nat table"}
+	if got := iptr.IPv6Capabilities(); got != want {
+		t.Errorf("IPv6Capabilities = %+v; want %+v", got, want)
+	}
+}
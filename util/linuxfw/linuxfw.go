@@ -170,26 +170,44 @@ func checkIPv6(logf logger.Logf) error {
 	return nil
 }
 
-// checkSupportsV6NAT returns whether the system has a "nat" table in the
-// IPv6 netfilter stack.
+// IPv6Capabilities is the IPv6 support of the system's netfilter, as
+// probed when a NetfilterRunner is created.
+type IPv6Capabilities struct {
+	// Available is whether IPv6 rules can be installed.
+	Available bool
+	// NAT is whether IPv6 NAT rules can be installed. Without it, IPv6
+	// traffic can't be masqueraded or forwarded by DNAT rules, so only
+	// IPv4 traffic is.
+	NAT bool
+	// Reason explains why IPv6 or IPv6 NAT isn't available, if it isn't.
+	Reason string
+}
+
+// ErrIPv6NATUnsupported is returned, wrapped, by NetfilterRunner methods
+// that had to skip IPv6 NAT rules because the system can't NAT IPv6
+// traffic. The IPv4 rules are installed regardless.
+var ErrIPv6NATUnsupported = errors.New("IPv6 NAT is not supported on this system")
+
+// checkSupportsV6NAT returns an error if the system has no "nat" table in
+// the IPv6 netfilter stack.
 //
 // The nat table was added after the initial release of ipv6
 // netfilter, so some older distros ship a kernel that can't NAT IPv6
 // traffic.
-func checkSupportsV6NAT() bool {
+func checkSupportsV6NAT() error {
 	bs, err := os.ReadFile("/proc/net/ip6_tables_names")
 	if err != nil {
 		// Can't read the file. Assume SNAT works.
-		return true
+		return nil
 	}
 	if bytes.Contains(bs, []byte("nat\n")) {
-		return true
+		return nil
 	}
 	// In nftables mode, that proc file will be empty. Try another thing:
-	if exec.Command("modprobe", "ip6table_nat").Run() == nil {
-		return true
+	if err := exec.Command("modprobe", "ip6table_nat").Run(); err != nil {
+		return fmt.Errorf("kernel has no IPv6 nat table, and loading ip6table_nat failed: %w", err)
 	}
-	return false
+	return nil
 }
 
 func CheckIPRuleSupportsV6(logf logger.Logf) error {
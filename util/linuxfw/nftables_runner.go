@@ -69,6 +69,7 @@ type nftablesRunner struct {
 
 	v6Available    bool
 	v6NATAvailable bool
+	v6Reason       string // why IPv6 or IPv6 NAT isn't available
 }
 
 func (n *nftablesRunner) ensurePreroutingChain(dst netip.Addr) (*nftables.Table, *nftables.Chain, error) {
@@ -94,11 +95,64 @@ func (n *nftablesRunner) ensurePreroutingChain(dst netip.Addr) (*nftables.Table,
 	return nat, preroutingCh, nil
 }
 
+func (n *nftablesRunner) ensurePostroutingChain(dst netip.Addr) (*nftables.Table, *nftables.Chain, error) {
+	polAccept := nftables.ChainPolicyAccept
+	table := n.getNFTByAddr(dst)
+	nat, err := createTableIfNotExist(n.conn, table.Proto, "nat")
+	if err != nil {
+		return nil, nil, fmt.Errorf("error ensuring nat table exists: %w", err)
+	}
+
+	// ensure postrouting chain exists
+	postRoutingCh, err := getOrCreateChain(n.conn, chainInfo{
+		table:         nat,
+		name:          "POSTROUTING",
+		chainType:     nftables.ChainTypeNAT,
+		chainHook:     nftables.ChainHookPostrouting,
+		chainPriority: nftables.ChainPriorityNATSource,
+		chainPolicy:   &polAccept,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error ensuring postrouting chain: %w", err)
+	}
+	return nat, postRoutingCh, nil
+}
+
+func (n *nftablesRunner) ensureForwardChain(addr netip.Addr) (*nftables.Table, *nftables.Chain, error) {
+	polAccept := nftables.ChainPolicyAccept
+	table := n.getNFTByAddr(addr)
+	filterTable, err := createTableIfNotExist(n.conn, table.Proto, "filter")
+	if err != nil {
+		return nil, nil, fmt.Errorf("error ensuring filter table: %w", err)
+	}
+
+	// ensure forwarding chain exists
+	fwChain, err := getOrCreateChain(n.conn, chainInfo{
+		table:         filterTable,
+		name:          "FORWARD",
+		chainType:     nftables.ChainTypeFilter,
+		chainHook:     nftables.ChainHookForward,
+		chainPriority: nftables.ChainPriorityFilter,
+		chainPolicy:   &polAccept,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error ensuring forward chain: %w", err)
+	}
+	return filterTable, fwChain, nil
+}
+
 func (n *nftablesRunner) AddDNATRule(origDst netip.Addr, dst netip.Addr) error {
 	nat, preroutingCh, err := n.ensurePreroutingChain(dst)
 	if err != nil {
 		return err
 	}
+	n.conn.InsertRule(createDNATRule(nat, preroutingCh, origDst, dst))
+	return n.conn.Flush()
+}
+
+// createDNATRule creates a rule to DNAT traffic destined for origDst to
+// dst, as added by AddDNATRule.
+func createDNATRule(nat *nftables.Table, preroutingCh *nftables.Chain, origDst, dst netip.Addr) *nftables.Rule {
 	var daddrOffset, fam, dadderLen uint32
 	if origDst.Is4() {
 		daddrOffset = 16
@@ -110,7 +164,7 @@ func (n *nftablesRunner) AddDNATRule(origDst netip.Addr, dst netip.Addr) error {
 		fam = unix.NFPROTO_IPV6
 	}
 
-	dnatRule := &nftables.Rule{
+	return &nftables.Rule{
 		Table: nat,
 		Chain: preroutingCh,
 		Exprs: []expr.Any{
@@ -136,15 +190,121 @@ func (n *nftablesRunner) AddDNATRule(origDst netip.Addr, dst netip.Addr) error {
 			},
 		},
 	}
-	n.conn.InsertRule(dnatRule)
+}
+
+func (n *nftablesRunner) AddDNATRuleForPort(origDst, dst netip.Addr, pm PortMap) error {
+	if err := (proxyRule{kind: proxyRuleDNATPort, a: origDst, b: dst, pm: pm}).validate(); err != nil {
+		return err
+	}
+	nat, preroutingCh, err := n.ensurePreroutingChain(dst)
+	if err != nil {
+		return err
+	}
+	n.conn.InsertRule(createDNATPortRule(nat, preroutingCh, origDst, dst, pm))
 	return n.conn.Flush()
 }
 
+func (n *nftablesRunner) DelDNATRuleForPort(origDst, dst netip.Addr, pm PortMap) error {
+	if err := (proxyRule{kind: proxyRuleDNATPort, a: origDst, b: dst, pm: pm}).validate(); err != nil {
+		return err
+	}
+	nat, preroutingCh, err := n.ensurePreroutingChain(dst)
+	if err != nil {
+		return err
+	}
+	rule, err := findRule(n.conn, createDNATPortRule(nat, preroutingCh, origDst, dst, pm))
+	if err != nil {
+		return fmt.Errorf("find rule: %w", err)
+	}
+	if rule == nil {
+		return nil
+	}
+	if err := n.conn.DelRule(rule); err != nil {
+		return fmt.Errorf("delete rule: %w", err)
+	}
+	return n.conn.Flush()
+}
+
+// createDNATPortRule creates a rule to DNAT traffic of pm's protocol
+// destined for origDst on pm's match port to dst on pm's target port, as
+// added by AddDNATRuleForPort.
+func createDNATPortRule(nat *nftables.Table, preroutingCh *nftables.Chain, origDst, dst netip.Addr, pm PortMap) *nftables.Rule {
+	var daddrOffset, fam, daddrLen uint32
+	if origDst.Is4() {
+		daddrOffset = 16
+		daddrLen = 4
+		fam = unix.NFPROTO_IPV4
+	} else {
+		daddrOffset = 24
+		daddrLen = 16
+		fam = unix.NFPROTO_IPV6
+	}
+	proto := byte(unix.IPPROTO_TCP)
+	if pm.Protocol == "udp" {
+		proto = unix.IPPROTO_UDP
+	}
+	matchPort := make([]byte, 2)
+	binary.BigEndian.PutUint16(matchPort, pm.MatchPort)
+	targetPort := make([]byte, 2)
+	binary.BigEndian.PutUint16(targetPort, pm.TargetPort)
+
+	return &nftables.Rule{
+		Table: nat,
+		Chain: preroutingCh,
+		Exprs: []expr.Any{
+			&expr.Payload{
+				DestRegister: 1,
+				Base:         expr.PayloadBaseNetworkHeader,
+				Offset:       daddrOffset,
+				Len:          daddrLen,
+			},
+			&expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: 1,
+				Data:     origDst.AsSlice(),
+			},
+			&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+			&expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: 1,
+				Data:     []byte{proto},
+			},
+			newLoadDportExpr(1),
+			&expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: 1,
+				Data:     matchPort,
+			},
+			&expr.Immediate{
+				Register: 1,
+				Data:     dst.AsSlice(),
+			},
+			&expr.Immediate{
+				Register: 2,
+				Data:     targetPort,
+			},
+			&expr.NAT{
+				Type:        expr.NATTypeDestNAT,
+				Family:      fam,
+				RegAddrMin:  1,
+				RegProtoMin: 2,
+			},
+		},
+	}
+}
+
 func (n *nftablesRunner) DNATNonTailscaleTraffic(tunname string, dst netip.Addr) error {
 	nat, preroutingCh, err := n.ensurePreroutingChain(dst)
 	if err != nil {
 		return err
 	}
+	n.conn.AddRule(createDNATNonTailscaleRule(nat, preroutingCh, tunname, dst))
+	return n.conn.Flush()
+}
+
+// createDNATNonTailscaleRule creates a rule to DNAT traffic not from
+// tunname to dst, as added by DNATNonTailscaleTraffic.
+func createDNATNonTailscaleRule(nat *nftables.Table, preroutingCh *nftables.Chain, tunname string, dst netip.Addr) *nftables.Rule {
 	var famConst uint32
 	if dst.Is4() {
 		famConst = unix.NFPROTO_IPV4
@@ -152,7 +312,7 @@ func (n *nftablesRunner) DNATNonTailscaleTraffic(tunname string, dst netip.Addr)
 		famConst = unix.NFPROTO_IPV6
 	}
 
-	dnatRule := &nftables.Rule{
+	return &nftables.Rule{
 		Table: nat,
 		Chain: preroutingCh,
 		Exprs: []expr.Any{
@@ -173,30 +333,20 @@ func (n *nftablesRunner) DNATNonTailscaleTraffic(tunname string, dst netip.Addr)
 			},
 		},
 	}
-	n.conn.AddRule(dnatRule)
-	return n.conn.Flush()
 }
 
 func (n *nftablesRunner) AddSNATRuleForDst(src, dst netip.Addr) error {
-	polAccept := nftables.ChainPolicyAccept
-	table := n.getNFTByAddr(dst)
-	nat, err := createTableIfNotExist(n.conn, table.Proto, "nat")
+	nat, postRoutingCh, err := n.ensurePostroutingChain(dst)
 	if err != nil {
-		return fmt.Errorf("error ensuring nat table exists: %w", err)
+		return err
 	}
+	n.conn.AddRule(createSNATRuleForDst(nat, postRoutingCh, src, dst))
+	return n.conn.Flush()
+}
 
-	// ensure postrouting chain exists
-	postRoutingCh, err := getOrCreateChain(n.conn, chainInfo{
-		table:         nat,
-		name:          "POSTROUTING",
-		chainType:     nftables.ChainTypeNAT,
-		chainHook:     nftables.ChainHookPostrouting,
-		chainPriority: nftables.ChainPriorityNATSource,
-		chainPolicy:   &polAccept,
-	})
-	if err != nil {
-		return fmt.Errorf("error ensuring postrouting chain: %w", err)
-	}
+// createSNATRuleForDst creates a rule to SNAT traffic destined for dst to
+// src, as added by AddSNATRuleForDst.
+func createSNATRuleForDst(nat *nftables.Table, postRoutingCh *nftables.Chain, src, dst netip.Addr) *nftables.Rule {
 	var daddrOffset, fam, daddrLen uint32
 	if dst.Is4() {
 		daddrOffset = 16
@@ -208,7 +358,7 @@ func (n *nftablesRunner) AddSNATRuleForDst(src, dst netip.Addr) error {
 		fam = unix.NFPROTO_IPV6
 	}
 
-	snatRule := &nftables.Rule{
+	return &nftables.Rule{
 		Table: nat,
 		Chain: postRoutingCh,
 		Exprs: []expr.Any{
@@ -234,32 +384,21 @@ func (n *nftablesRunner) AddSNATRuleForDst(src, dst netip.Addr) error {
 			},
 		},
 	}
-	n.conn.AddRule(snatRule)
-	return n.conn.Flush()
 }
 
 func (n *nftablesRunner) ClampMSSToPMTU(tun string, addr netip.Addr) error {
-	polAccept := nftables.ChainPolicyAccept
-	table := n.getNFTByAddr(addr)
-	filterTable, err := createTableIfNotExist(n.conn, table.Proto, "filter")
-	if err != nil {
-		return fmt.Errorf("error ensuring filter table: %w", err)
-	}
-
-	// ensure forwarding chain exists
-	fwChain, err := getOrCreateChain(n.conn, chainInfo{
-		table:         filterTable,
-		name:          "FORWARD",
-		chainType:     nftables.ChainTypeFilter,
-		chainHook:     nftables.ChainHookForward,
-		chainPriority: nftables.ChainPriorityFilter,
-		chainPolicy:   &polAccept,
-	})
+	filterTable, fwChain, err := n.ensureForwardChain(addr)
 	if err != nil {
-		return fmt.Errorf("error ensuring forward chain: %w", err)
+		return err
 	}
+	n.conn.AddRule(createClampMSSRule(filterTable, fwChain, tun))
+	return n.conn.Flush()
+}
 
-	clampRule := &nftables.Rule{
+// createClampMSSRule creates a rule to clamp the MSS of TCP traffic
+// forwarded to tun, as added by ClampMSSToPMTU.
+func createClampMSSRule(filterTable *nftables.Table, fwChain *nftables.Chain, tun string) *nftables.Rule {
+	return &nftables.Rule{
 		Table: filterTable,
 		Chain: fwChain,
 		Exprs: []expr.Any{
@@ -313,8 +452,164 @@ func (n *nftablesRunner) ClampMSSToPMTU(tun string, addr netip.Addr) error {
 			},
 		},
 	}
-	n.conn.AddRule(clampRule)
-	return n.conn.Flush()
+}
+
+// newProxyRule creates the rule for pr, creating the chain it goes in if
+// needed, and reports whether it's inserted at the start of the chain
+// rather than appended.
+func (n *nftablesRunner) newProxyRule(pr proxyRule) (r *nftables.Rule, insert bool, err error) {
+	switch pr.kind {
+	case proxyRuleDNAT:
+		nat, ch, err := n.ensurePreroutingChain(pr.b)
+		if err != nil {
+			return nil, false, err
+		}
+		return createDNATRule(nat, ch, pr.a, pr.b), true, nil
+	case proxyRuleDNATNonTailscale:
+		nat, ch, err := n.ensurePreroutingChain(pr.a)
+		if err != nil {
+			return nil, false, err
+		}
+		return createDNATNonTailscaleRule(nat, ch, pr.iface, pr.a), false, nil
+	case proxyRuleSNAT:
+		nat, ch, err := n.ensurePostroutingChain(pr.b)
+		if err != nil {
+			return nil, false, err
+		}
+		return createSNATRuleForDst(nat, ch, pr.a, pr.b), false, nil
+	case proxyRuleClampMSS:
+		filterTable, ch, err := n.ensureForwardChain(pr.a)
+		if err != nil {
+			return nil, false, err
+		}
+		return createClampMSSRule(filterTable, ch, pr.iface), false, nil
+	case proxyRuleDNATPort:
+		nat, ch, err := n.ensurePreroutingChain(pr.b)
+		if err != nil {
+			return nil, false, err
+		}
+		return createDNATPortRule(nat, ch, pr.a, pr.b, pr.pm), true, nil
+	}
+	return nil, false, fmt.Errorf("unknown proxy rule kind %q", pr.kind)
+}
+
+// nftablesProxyRuleChains are the table/chain pairs that proxy rules are
+// installed in.
+var nftablesProxyRuleChains = [][2]string{
+	{"nat", "PREROUTING"},
+	{"nat", "POSTROUTING"},
+	{"filter", "FORWARD"},
+}
+
+// EnsureProxyRules installs the proxy rules in rules that aren't installed
+// yet, and removes the installed proxy rules that aren't in rules, as well
+// as duplicates. The rules are told apart from others by their user data.
+func (n *nftablesRunner) EnsureProxyRules(rules ProxyRules) error {
+	want, skipped6, err := proxyRulesToInstall(rules, n.HasIPV6NAT())
+	if err != nil {
+		return err
+	}
+	wantIDs := make(map[string]bool)
+	for _, pr := range want {
+		wantIDs[pr.id()] = true
+	}
+
+	rs, err := n.installedProxyRules()
+	if err != nil {
+		return err
+	}
+	installed := make(map[string]bool)
+	for _, r := range rs {
+		id := string(r.UserData)
+		if wantIDs[id] && !installed[id] {
+			installed[id] = true
+			continue
+		}
+		// Stale, such as left over from an earlier configuration, or a
+		// duplicate.
+		if err := n.conn.DelRule(r); err != nil {
+			return fmt.Errorf("deleting stale rule: %w", err)
+		}
+	}
+	for _, pr := range want {
+		if installed[pr.id()] {
+			continue
+		}
+		r, insert, err := n.newProxyRule(pr)
+		if err != nil {
+			return fmt.Errorf("adding %s proxy rule: %w", pr.kind, err)
+		}
+		r.UserData = []byte(pr.id())
+		// Count the packets matching the rule, just before its
+		// action, for ProxyRuleCounters.
+		last := len(r.Exprs) - 1
+		r.Exprs = append(r.Exprs[:last:last], &expr.Counter{}, r.Exprs[last])
+		if insert {
+			n.conn.InsertRule(r)
+		} else {
+			n.conn.AddRule(r)
+		}
+	}
+	if err := n.conn.Flush(); err != nil {
+		return err
+	}
+	if skipped6 > 0 {
+		return fmt.Errorf("skipped %d IPv6 proxy rules: %w", skipped6, ErrIPv6NATUnsupported)
+	}
+	return nil
+}
+
+// ProxyRuleCounters returns the counters of the proxy rules installed by
+// EnsureProxyRules.
+func (n *nftablesRunner) ProxyRuleCounters() ([]ProxyRuleCounter, error) {
+	rs, err := n.installedProxyRules()
+	if err != nil {
+		return nil, err
+	}
+	var counters []ProxyRuleCounter
+	for _, r := range rs {
+		for _, e := range r.Exprs {
+			if c, ok := e.(*expr.Counter); ok {
+				counters = append(counters, newProxyRuleCounter(string(r.UserData), c.Packets, c.Bytes))
+				break
+			}
+		}
+	}
+	return counters, nil
+}
+
+// installedProxyRules returns the proxy rules installed by
+// EnsureProxyRules, in all the tables and chains they may be in.
+func (n *nftablesRunner) installedProxyRules() ([]*nftables.Rule, error) {
+	var proxyRules []*nftables.Rule
+	for _, nf := range n.getNATTables() {
+		for _, tc := range nftablesProxyRuleChains {
+			table, err := getTableIfExists(n.conn, nf.Proto, tc[0])
+			if err != nil {
+				return nil, fmt.Errorf("getting table %s: %w", tc[0], err)
+			}
+			if table == nil {
+				continue
+			}
+			chain, err := getChainFromTable(n.conn, table, tc[1])
+			if errors.Is(err, errorChainNotFound{table.Name, tc[1]}) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("getting chain %s: %w", tc[1], err)
+			}
+			rs, err := n.conn.GetRules(table, chain)
+			if err != nil {
+				return nil, fmt.Errorf("listing rules of %s/%s: %w", tc[0], tc[1], err)
+			}
+			for _, r := range rs {
+				if strings.HasPrefix(string(r.UserData), proxyRuleIDPrefix) {
+					proxyRules = append(proxyRules, r)
+				}
+			}
+		}
+	}
+	return proxyRules, nil
 }
 
 // deleteTableIfExists deletes a nftables table via connection c if it exists
@@ -488,12 +783,27 @@ type NetfilterRunner interface {
 	// HasIPV6NAT reports true if the system supports IPv6 NAT.
 	HasIPV6NAT() bool
 
+	// IPv6Capabilities reports the IPv6 support of the system, as probed
+	// when the runner was created, including why IPv6 or IPv6 NAT isn't
+	// available, so callers can warn about running IPv4-only.
+	IPv6Capabilities() IPv6Capabilities
+
 	// AddDNATRule adds a rule to the nat/PREROUTING chain to DNAT traffic
 	// destined for the given original destination to the given new destination.
 	// This is used to forward all traffic destined for the Tailscale interface
 	// to the provided destination, as used in the Kubernetes ingress proxies.
 	AddDNATRule(origDst, dst netip.Addr) error
 
+	// AddDNATRuleForPort adds a rule to the nat/PREROUTING chain to DNAT
+	// traffic of pm.Protocol destined for origDst on pm.MatchPort to dst
+	// on pm.TargetPort. Unlike AddDNATRule, traffic to other ports of
+	// origDst is left alone, so several proxies can share the address.
+	AddDNATRuleForPort(origDst, dst netip.Addr, pm PortMap) error
+
+	// DelDNATRuleForPort removes the rule added by AddDNATRuleForPort, if
+	// it exists.
+	DelDNATRuleForPort(origDst, dst netip.Addr, pm PortMap) error
+
 	// AddSNATRuleForDst adds a rule to the nat/POSTROUTING chain to SNAT
 	// traffic destined for dst to src.
 	// This is used to forward traffic destined for the local machine over
@@ -518,6 +828,25 @@ type NetfilterRunner interface {
 	// DelMagicsockPortRule removes the rule created by AddMagicsockPortRule,
 	// if it exists.
 	DelMagicsockPortRule(port uint16, network string) error
+
+	// EnsureProxyRules makes the installed proxy rules, as added by
+	// AddDNATRule, AddDNATRuleForPort, DNATNonTailscaleTraffic,
+	// AddSNATRuleForDst and ClampMSSToPMTU, match rules: it adds the
+	// missing rules, and removes the ones not in rules and any
+	// duplicates, such as those left behind by an earlier run of a
+	// container that crashed. Only the rules installed by
+	// EnsureProxyRules itself are considered installed, not those added
+	// by the other methods.
+	//
+	// If the system can't NAT IPv6 traffic, the IPv6 rules are skipped,
+	// the IPv4 ones are installed nonetheless, and the returned error
+	// wraps ErrIPv6NATUnsupported.
+	EnsureProxyRules(rules ProxyRules) error
+
+	// ProxyRuleCounters returns the packet and byte counters of the proxy
+	// rules installed by EnsureProxyRules, to tell whether traffic flows
+	// through them.
+	ProxyRuleCounters() ([]ProxyRuleCounter, error)
 }
 
 // New creates a NetfilterRunner, auto-detecting whether to use
@@ -546,15 +875,22 @@ func newNfTablesRunner(logf logger.Logf) (*nftablesRunner, error) {
 	}
 	nft4 := &nftable{Proto: nftables.TableFamilyIPv4}
 
+	var v6Reason string
 	v6err := checkIPv6(logf)
 	if v6err != nil {
 		logf("disabling tunneled IPv6 due to system IPv6 config: %v", v6err)
+		v6Reason = fmt.Sprintf("system IPv6 config: %v", v6err)
 	}
 	supportsV6 := v6err == nil
-	supportsV6NAT := supportsV6 && checkSupportsV6NAT()
+	supportsV6NAT := false
 
 	var nft6 *nftable
 	if supportsV6 {
+		if err := probeNftablesV6NAT(conn); err != nil {
+			v6Reason = err.Error()
+		} else {
+			supportsV6NAT = true
+		}
 		logf("v6nat availability: %v", supportsV6NAT)
 		nft6 = &nftable{Proto: nftables.TableFamilyIPv6}
 	}
@@ -567,9 +903,43 @@ func newNfTablesRunner(logf logger.Logf) (*nftablesRunner, error) {
 		nft6:           nft6,
 		v6Available:    supportsV6,
 		v6NATAvailable: supportsV6NAT,
+		v6Reason:       v6Reason,
 	}, nil
 }
 
+// v6NATProbeTable is the name of the table created by probeNftablesV6NAT.
+const v6NATProbeTable = "ts-v6nat-probe"
+
+// probeNftablesV6NAT returns an error if IPv6 NAT rules can't be
+// installed with conn. It creates, and then deletes, an ip6 table with a
+// NAT chain, which only succeeds if the kernel can NAT IPv6. Unlike
+// checkSupportsV6NAT, it doesn't depend on the iptables kernel modules.
+func probeNftablesV6NAT(conn *nftables.Conn) error {
+	polAccept := nftables.ChainPolicyAccept
+	table := conn.AddTable(&nftables.Table{
+		Family: nftables.TableFamilyIPv6,
+		Name:   v6NATProbeTable,
+	})
+	conn.AddChain(&nftables.Chain{
+		Name:     "POSTROUTING",
+		Table:    table,
+		Type:     nftables.ChainTypeNAT,
+		Hooknum:  nftables.ChainHookPostrouting,
+		Priority: nftables.ChainPriorityNATSource,
+		Policy:   &polAccept,
+	})
+	probeErr := conn.Flush()
+	// Delete the table even if the chain couldn't be created, in case
+	// the table was.
+	if err := deleteTableIfExists(conn, nftables.TableFamilyIPv6, v6NATProbeTable); err != nil {
+		return fmt.Errorf("deleting IPv6 NAT probe table: %w", err)
+	}
+	if probeErr != nil {
+		return fmt.Errorf("creating an IPv6 NAT chain: %w", probeErr)
+	}
+	return nil
+}
+
 // newLoadSaddrExpr creates a new nftables expression that loads the source
 // address of the packet into the given register.
 func newLoadSaddrExpr(proto nftables.TableFamily, destReg uint32) (expr.Any, error) {
@@ -614,6 +984,15 @@ func (n *nftablesRunner) HasIPV6NAT() bool {
 	return n.v6NATAvailable
 }
 
+// IPv6Capabilities reports the IPv6 support probed when n was created.
+func (n *nftablesRunner) IPv6Capabilities() IPv6Capabilities {
+	return IPv6Capabilities{
+		Available: n.v6Available,
+		NAT:       n.v6NATAvailable,
+		Reason:    n.v6Reason,
+	}
+}
+
 // findRule iterates through the rules to find the rule with matching expressions.
 func findRule(conn *nftables.Conn, rule *nftables.Rule) (*nftables.Rule, error) {
 	rules, err := conn.GetRules(rule.Table, rule.Chain)
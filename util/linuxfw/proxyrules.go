@@ -0,0 +1,228 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux
+
+package linuxfw
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ProxyRules is the full set of rules that forward traffic through a
+// proxy, like those installed one by one by AddDNATRule,
+// AddDNATRuleForPort, DNATNonTailscaleTraffic, AddSNATRuleForDst and
+// ClampMSSToPMTU. It's installed by NetfilterRunner.EnsureProxyRules.
+type ProxyRules struct {
+	// DNAT are rules like those added by AddDNATRule.
+	DNAT []DNATRule
+	// DNATNonTailscale are rules like those added by
+	// DNATNonTailscaleTraffic.
+	DNATNonTailscale []DNATNonTailscaleRule
+	// SNAT are rules like those added by AddSNATRuleForDst.
+	SNAT []SNATRule
+	// ClampMSS are rules like those added by ClampMSSToPMTU.
+	ClampMSS []ClampMSSRule
+	// DNATPort are rules like those added by AddDNATRuleForPort.
+	DNATPort []DNATPortRule
+}
+
+// DNATRule is a rule to DNAT traffic destined for OrigDst to Dst.
+type DNATRule struct {
+	OrigDst, Dst netip.Addr
+}
+
+// DNATPortRule is a rule to DNAT traffic destined for OrigDst on a port,
+// as described by PortMap, to Dst.
+type DNATPortRule struct {
+	OrigDst, Dst netip.Addr
+	PortMap      PortMap
+}
+
+// PortMap describes the forwarding of a single port: traffic of Protocol
+// destined for MatchPort is forwarded to TargetPort.
+type PortMap struct {
+	// Protocol is "tcp" or "udp".
+	Protocol   string
+	MatchPort  uint16
+	TargetPort uint16
+}
+
+// DNATNonTailscaleRule is a rule to DNAT all traffic inbound from any
+// interface except ExemptInterface to Dst.
+type DNATNonTailscaleRule struct {
+	ExemptInterface string
+	Dst             netip.Addr
+}
+
+// SNATRule is a rule to SNAT traffic destined for Dst to Src.
+type SNATRule struct {
+	Src, Dst netip.Addr
+}
+
+// ClampMSSRule is a rule to clamp the MSS of TCP traffic forwarded to the
+// Tun interface, in the IP family of Addr.
+type ClampMSSRule struct {
+	Tun  string
+	Addr netip.Addr
+}
+
+// ProxyRuleCounter is the number of packets and bytes that matched a proxy
+// rule installed by NetfilterRunner.EnsureProxyRules, which tells whether
+// traffic is flowing through the rule.
+//
+// The rules in the nat table only see the first packet of each connection,
+// so for DNAT and SNAT rules Packets is the number of connections
+// forwarded, and Bytes is of those first packets only.
+type ProxyRuleCounter struct {
+	// Kind is the kind of the rule: "dnat", "dnat-port", "dnat-non-ts",
+	// "snat" or "clamp-mss".
+	Kind string
+	// ID identifies the rule. It's made of its kind, addresses,
+	// interface and ports, like "ts-proxy,dnat,100.64.0.1,10.0.0.1".
+	ID      string
+	Packets uint64
+	Bytes   uint64
+}
+
+// newProxyRuleCounter returns the ProxyRuleCounter of the installed rule
+// with the given ID.
+func newProxyRuleCounter(id string, packets, bytes uint64) ProxyRuleCounter {
+	kind, _, _ := strings.Cut(strings.TrimPrefix(id, proxyRuleIDPrefix), ",")
+	return ProxyRuleCounter{
+		Kind:    kind,
+		ID:      id,
+		Packets: packets,
+		Bytes:   bytes,
+	}
+}
+
+// proxyRuleKind is the kind of a proxyRule.
+type proxyRuleKind string
+
+const (
+	proxyRuleDNAT             proxyRuleKind = "dnat"
+	proxyRuleDNATNonTailscale proxyRuleKind = "dnat-non-ts"
+	proxyRuleSNAT             proxyRuleKind = "snat"
+	proxyRuleClampMSS         proxyRuleKind = "clamp-mss"
+	proxyRuleDNATPort         proxyRuleKind = "dnat-port"
+)
+
+// proxyRuleIDPrefix is the prefix of the IDs of proxy rules, which are
+// stored with the installed rules to tell them apart from other rules.
+const proxyRuleIDPrefix = "ts-proxy,"
+
+// proxyRule is a rule of ProxyRules, of any kind.
+type proxyRule struct {
+	kind proxyRuleKind
+	// a and b are the addresses of the rule: OrigDst and Dst of a
+	// DNATRule or DNATPortRule, Dst of a DNATNonTailscaleRule, Src and
+	// Dst of a SNATRule, or Addr of a ClampMSSRule.
+	a, b  netip.Addr
+	iface string  // the interface of DNATNonTailscale and ClampMSS rules
+	pm    PortMap // the ports of DNATPort rules
+}
+
+// rules returns all the rules of r, de-duplicated.
+func (r ProxyRules) rules() []proxyRule {
+	var rules []proxyRule
+	seen := make(map[proxyRule]bool)
+	add := func(pr proxyRule) {
+		if !seen[pr] {
+			seen[pr] = true
+			rules = append(rules, pr)
+		}
+	}
+	for _, x := range r.DNAT {
+		add(proxyRule{kind: proxyRuleDNAT, a: x.OrigDst, b: x.Dst})
+	}
+	for _, x := range r.DNATNonTailscale {
+		add(proxyRule{kind: proxyRuleDNATNonTailscale, a: x.Dst, iface: x.ExemptInterface})
+	}
+	for _, x := range r.SNAT {
+		add(proxyRule{kind: proxyRuleSNAT, a: x.Src, b: x.Dst})
+	}
+	for _, x := range r.ClampMSS {
+		add(proxyRule{kind: proxyRuleClampMSS, a: x.Addr, iface: x.Tun})
+	}
+	for _, x := range r.DNATPort {
+		add(proxyRule{kind: proxyRuleDNATPort, a: x.OrigDst, b: x.Dst, pm: x.PortMap})
+	}
+	return rules
+}
+
+// proxyRulesToInstall returns the validated rules of r that can be
+// installed, leaving out the IPv6 ones if hasV6NAT is false, and the number
+// of rules left out.
+func proxyRulesToInstall(r ProxyRules, hasV6NAT bool) (rules []proxyRule, skipped6 int, err error) {
+	for _, pr := range r.rules() {
+		if err := pr.validate(); err != nil {
+			return nil, 0, err
+		}
+		if pr.is6() && !hasV6NAT {
+			skipped6++
+			continue
+		}
+		rules = append(rules, pr)
+	}
+	return rules, skipped6, nil
+}
+
+// validate reports an error if the rule can't be installed.
+func (pr proxyRule) validate() error {
+	if !pr.a.IsValid() || (pr.b.IsValid() && pr.a.Is4() != pr.b.Is4()) {
+		return fmt.Errorf("invalid addresses %v, %v in %s proxy rule", pr.a, pr.b, pr.kind)
+	}
+	if (pr.kind == proxyRuleDNAT || pr.kind == proxyRuleSNAT || pr.kind == proxyRuleDNATPort) && !pr.b.IsValid() {
+		return fmt.Errorf("missing address in %s proxy rule", pr.kind)
+	}
+	if pr.kind == proxyRuleDNATPort {
+		if err := pr.pm.validate(); err != nil {
+			return fmt.Errorf("%s proxy rule: %w", pr.kind, err)
+		}
+	}
+	if strings.ContainsAny(pr.iface, ", \"") {
+		return fmt.Errorf("invalid interface name %q in %s proxy rule", pr.iface, pr.kind)
+	}
+	return nil
+}
+
+// is6 reports whether the rule belongs in the IPv6 tables.
+func (pr proxyRule) is6() bool {
+	return pr.a.Is6()
+}
+
+// id returns the ID of the rule, which identifies it among the installed
+// rules. It contains no spaces or quotes.
+func (pr proxyRule) id() string {
+	var b strings.Builder
+	b.WriteString(proxyRuleIDPrefix)
+	b.WriteString(string(pr.kind))
+	for _, a := range []netip.Addr{pr.a, pr.b} {
+		if a.IsValid() {
+			b.WriteString(",")
+			b.WriteString(a.String())
+		}
+	}
+	if pr.iface != "" {
+		b.WriteString(",")
+		b.WriteString(pr.iface)
+	}
+	if pr.kind == proxyRuleDNATPort {
+		fmt.Fprintf(&b, ",%s:%d:%d", pr.pm.Protocol, pr.pm.MatchPort, pr.pm.TargetPort)
+	}
+	return b.String()
+}
+
+// validate reports an error if pm can't be forwarded.
+func (pm PortMap) validate() error {
+	if pm.Protocol != "tcp" && pm.Protocol != "udp" {
+		return fmt.Errorf("unsupported protocol %q in port map", pm.Protocol)
+	}
+	if pm.MatchPort == 0 || pm.TargetPort == 0 {
+		return fmt.Errorf("invalid port 0 in port map")
+	}
+	return nil
+}
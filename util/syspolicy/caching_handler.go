@@ -4,6 +4,7 @@
 package syspolicy
 
 import (
+	"context"
 	"errors"
 	"sync"
 )
@@ -96,3 +97,23 @@ func (ch *CachingHandler) ReadBoolean(key string) (bool, error) {
 	ch.bools[key] = val
 	return val, nil
 }
+
+// ResetCache discards the cached policy values, so that they're read from the
+// underlying handler again.
+func (ch *CachingHandler) ResetCache() {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	clear(ch.strings)
+	clear(ch.uint64s)
+	clear(ch.bools)
+	clear(ch.notFound)
+}
+
+// WatchChanges implements ChangeNotifier if the underlying handler does, and
+// otherwise returns errors.ErrUnsupported.
+func (ch *CachingHandler) WatchChanges(ctx context.Context, changed func()) error {
+	if n, ok := ch.handler.(ChangeNotifier); ok {
+		return n.WatchChanges(ctx, changed)
+	}
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,168 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package syspolicy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// filePollInterval is how often a fileHandler checks whether its file
+// changed, to notify the watcher.
+const filePollInterval = 10 * time.Second
+
+// fileHandler is a Handler that reads policies from a JSON file containing an
+// object keyed by policy name, such as:
+//
+//	{
+//		"ExitNodeID": "nXXXXXCNTRL",
+//		"AllowIncomingConnections": "never",
+//		"FlushDNSOnSessionUnlock": true
+//	}
+//
+// String policies must be JSON strings, uint64 policies JSON numbers and
+// boolean policies JSON booleans. A missing file sets no policies. The file
+// is re-read whenever it changes.
+type fileHandler struct {
+	path string
+
+	mu       sync.Mutex
+	loaded   bool                       // whether the file was read yet
+	stat     fileStat                   // of the file when it was read
+	policies map[string]json.RawMessage // empty if the file doesn't exist
+	err      error                      // from reading or parsing the file
+}
+
+// fileStat is the part of a file's metadata used to tell whether it changed.
+type fileStat struct {
+	exists  bool
+	modTime int64 // in Unix nanoseconds
+	size    int64
+	mode    fs.FileMode
+}
+
+func statFile(path string) (fileStat, error) {
+	fi, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fileStat{}, nil
+	}
+	if err != nil {
+		return fileStat{}, err
+	}
+	return fileStat{exists: true, modTime: fi.ModTime().UnixNano(), size: fi.Size(), mode: fi.Mode()}, nil
+}
+
+func newFileHandler(path string) *fileHandler {
+	return &fileHandler{path: path}
+}
+
+// lookup returns the raw JSON value of the policy key.
+func (h *fileHandler) lookup(key string) (json.RawMessage, error) {
+	st, err := statFile(h.path)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.loaded || st != h.stat {
+		h.loaded = true
+		h.stat = st
+		h.policies, h.err = readPolicyFile(h.path, st)
+	}
+	if h.err != nil {
+		return nil, h.err
+	}
+	v, ok := h.policies[key]
+	if !ok {
+		return nil, ErrNoSuchKey
+	}
+	return v, nil
+}
+
+// readPolicyFile reads and parses the policy file at path, whose metadata is
+// st. It returns an empty map if the file doesn't exist.
+func readPolicyFile(path string, st fileStat) (map[string]json.RawMessage, error) {
+	if !st.exists {
+		return map[string]json.RawMessage{}, nil
+	}
+	// Anyone who can write the file can change the control server, so
+	// don't trust a world-writable one.
+	if st.mode.Perm()&0o002 != 0 {
+		return nil, fmt.Errorf("policy file %s is world-writable", path)
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]json.RawMessage{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var policies map[string]json.RawMessage
+	if err := json.Unmarshal(b, &policies); err != nil {
+		return nil, fmt.Errorf("policy file %s: %w", path, err)
+	}
+	if policies == nil { // the file contained "null"
+		policies = map[string]json.RawMessage{}
+	}
+	return policies, nil
+}
+
+// readValue reads the policy key into v, which points to a value of its
+// expected type.
+func (h *fileHandler) readValue(key string, v any) error {
+	raw, err := h.lookup(key)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("policy %s in %s: %w", key, h.path, err)
+	}
+	return nil
+}
+
+func (h *fileHandler) ReadString(key string) (string, error) {
+	var s string
+	err := h.readValue(key, &s)
+	return s, err
+}
+
+func (h *fileHandler) ReadUInt64(key string) (uint64, error) {
+	var u uint64
+	err := h.readValue(key, &u)
+	return u, err
+}
+
+func (h *fileHandler) ReadBoolean(key string) (bool, error) {
+	var b bool
+	err := h.readValue(key, &b)
+	return b, err
+}
+
+// WatchChanges implements ChangeNotifier by checking every filePollInterval
+// whether the file was created, modified or removed.
+func (h *fileHandler) WatchChanges(ctx context.Context, changed func()) error {
+	last, _ := statFile(h.path)
+	t := time.NewTicker(filePollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+		st, err := statFile(h.path)
+		if err != nil || st == last {
+			continue
+		}
+		last = st
+		changed()
+	}
+}
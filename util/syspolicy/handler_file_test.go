@@ -0,0 +1,83 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package syspolicy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	h := newFileHandler(path)
+
+	// No file, no policies.
+	if _, err := h.ReadString(string(ExitNodeID)); !errors.Is(err, ErrNoSuchKey) {
+		t.Errorf("ReadString without file: err = %v; want ErrNoSuchKey", err)
+	}
+
+	write := func(contents string, mode os.FileMode) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(contents), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+		// Make sure the change is noticed even if the file system's
+		// timestamps are coarse and the size doesn't change.
+		mt := time.Now().Add(time.Duration(len(contents)) * time.Second)
+		if err := os.Chtimes(path, mt, mt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{
+		"ExitNodeID": "node1",
+		"AllowIncomingConnections": "never",
+		"FlushDNSOnSessionUnlock": true,
+		"SomeNumber": 42
+	}`, 0o644)
+	if v, err := h.ReadString(string(ExitNodeID)); err != nil || v != "node1" {
+		t.Errorf("ReadString(ExitNodeID) = %q, %v; want node1", v, err)
+	}
+	if v, err := h.ReadBoolean(string(FlushDNSOnSessionUnlock)); err != nil || !v {
+		t.Errorf("ReadBoolean(FlushDNSOnSessionUnlock) = %v, %v; want true", v, err)
+	}
+	if v, err := h.ReadUInt64("SomeNumber"); err != nil || v != 42 {
+		t.Errorf("ReadUInt64(SomeNumber) = %v, %v; want 42", v, err)
+	}
+	if _, err := h.ReadBoolean(string(ExitNodeID)); err == nil || errors.Is(err, ErrNoSuchKey) {
+		t.Errorf("ReadBoolean of string policy: err = %v; want type error", err)
+	}
+	if _, err := h.ReadString(string(Tailnet)); !errors.Is(err, ErrNoSuchKey) {
+		t.Errorf("ReadString(Tailnet): err = %v; want ErrNoSuchKey", err)
+	}
+
+	// Changes are picked up on the next read.
+	write(`{"ExitNodeID": "node2"}`, 0o644)
+	if v, err := h.ReadString(string(ExitNodeID)); err != nil || v != "node2" {
+		t.Errorf("ReadString(ExitNodeID) after change = %q, %v; want node2", v, err)
+	}
+
+	// Malformed and world-writable files are errors, not missing policies.
+	write(`{"ExitNodeID": `, 0o644)
+	if _, err := h.ReadString(string(ExitNodeID)); err == nil || errors.Is(err, ErrNoSuchKey) {
+		t.Errorf("ReadString from malformed file: err = %v; want parse error", err)
+	}
+	write(`{"ExitNodeID": "node3"}`, 0o666)
+	if _, err := h.ReadString(string(ExitNodeID)); err == nil || errors.Is(err, ErrNoSuchKey) {
+		t.Errorf("ReadString from world-writable file: err = %v; want error", err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.ReadString(string(ExitNodeID)); !errors.Is(err, ErrNoSuchKey) {
+		t.Errorf("ReadString after removing file: err = %v; want ErrNoSuchKey", err)
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !android
+
+package syspolicy
+
+import "tailscale.com/envknob"
+
+// defaultPolicyFile is where policies are read from on Linux, unless the
+// TS_POLICY_FILE environment variable names another file.
+const defaultPolicyFile = "/etc/tailscale/policy.json"
+
+// RegisterFileHandler registers a Handler that reads policies from
+// /etc/tailscale/policy.json, or the file named by the TS_POLICY_FILE
+// environment variable. It's meant to be called by tailscaled on startup,
+// before any policy is read.
+func RegisterFileHandler() {
+	path := envknob.String("TS_POLICY_FILE")
+	if path == "" {
+		path = defaultPolicyFile
+	}
+	RegisterHandler(NewCachingHandler(newFileHandler(path)))
+}
@@ -4,9 +4,12 @@
 package syspolicy
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/winutil"
 )
@@ -93,3 +96,41 @@ func (windowsHandler) ReadBoolean(key string) (bool, error) {
 	}
 	return value != 0, err
 }
+
+// WatchChanges implements ChangeNotifier. It watches the whole
+// HKLM\SOFTWARE\Policies subtree so that it also notices when the Tailscale
+// policy key is created or deleted.
+func (windowsHandler) WatchChanges(ctx context.Context, changed func()) error {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Policies`, registry.NOTIFY)
+	if err != nil {
+		return err
+	}
+	defer k.Close()
+	ev, err := windows.CreateEvent(nil, 0, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(ev)
+
+	const filter = windows.REG_NOTIFY_CHANGE_NAME | windows.REG_NOTIFY_CHANGE_LAST_SET | windows.REG_NOTIFY_THREAD_AGNOSTIC
+	for {
+		if err := windows.RegNotifyChangeKeyValue(windows.Handle(k), true, filter, ev, true); err != nil {
+			return err
+		}
+		// The event can't be waited on along with ctx, so check ctx
+		// every second.
+		for {
+			s, err := windows.WaitForSingleObject(ev, 1000)
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if s == windows.WAIT_OBJECT_0 {
+				break
+			}
+		}
+		changed()
+	}
+}
@@ -15,6 +15,12 @@
 	// To find the node ID, go to /api.md#device.
 	ExitNodeID Key = "ExitNodeID"
 	ExitNodeIP Key = "ExitNodeIP" // default ""; if blank, no exit node is forced. Value is exit node IP.
+	// ExitNodeAllowedLANRoutes is a comma-separated list of local network
+	// prefixes, such as "192.168.10.0/24,fd00:10::/64", that remain directly
+	// reachable while using an exit node. If set, it also prevents access to
+	// the rest of the local network, overriding ExitNodeAllowLANAccess.
+	// default ""; if blank, users decide.
+	ExitNodeAllowedLANRoutes Key = "ExitNodeAllowedLANRoutes"
 
 	// Keys with a string value that specifies an option: "always", "never", "user-decides".
 	// The default is "user-decides" unless otherwise stated. Enforcement of
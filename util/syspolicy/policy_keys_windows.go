@@ -9,6 +9,7 @@
 	Tailnet,
 	ExitNodeID,
 	ExitNodeIP,
+	ExitNodeAllowedLANRoutes,
 	EnableIncomingConnections,
 	EnableServerMode,
 	ExitNodeAllowLANAccess,
@@ -0,0 +1,99 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package syspolicy
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"tailscale.com/util/set"
+)
+
+// refreshInterval is how often policies are re-read while there are change
+// callbacks registered, to pick up changes that the handler doesn't notify
+// us of, or if it can't notify us at all.
+const refreshInterval = 5 * time.Minute
+
+// ChangeNotifier is an optional interface implemented by Handlers that can
+// tell when the policies they read may have changed.
+type ChangeNotifier interface {
+	// WatchChanges calls changed whenever the policies may have changed. It
+	// blocks until ctx is done or it can no longer watch for changes, and
+	// returns why.
+	WatchChanges(ctx context.Context, changed func()) error
+}
+
+// cacheResetter is implemented by Handlers that cache policy values.
+type cacheResetter interface {
+	// ResetCache discards the cached policy values.
+	ResetCache()
+}
+
+var (
+	watchMu     sync.Mutex
+	callbacks   set.HandleSet[func()] // guarded by watchMu
+	stopWatcher context.CancelFunc    // guarded by watchMu; non-nil while watching
+)
+
+// RegisterChangeCallback registers cb to be called when the system policies
+// may have changed, after which reads return their new values. The callback
+// may be called when nothing changed, and concurrently with itself.
+//
+// Policies are watched for changes while any callback is registered.
+func RegisterChangeCallback(cb func()) (unregister func()) {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	handle := callbacks.Add(cb)
+	if stopWatcher == nil {
+		var ctx context.Context
+		ctx, stopWatcher = context.WithCancel(context.Background())
+		go watch(ctx, handler)
+	}
+	return func() {
+		watchMu.Lock()
+		defer watchMu.Unlock()
+		delete(callbacks, handle)
+		if len(callbacks) == 0 && stopWatcher != nil {
+			stopWatcher()
+			stopWatcher = nil
+		}
+	}
+}
+
+// Refresh discards any cached policy values and calls the registered change
+// callbacks. It's called when the policies may have changed, and can also be
+// called by code that knows they did.
+func Refresh() {
+	if r, ok := handler.(cacheResetter); ok {
+		r.ResetCache()
+	}
+	watchMu.Lock()
+	cbs := make([]func(), 0, len(callbacks))
+	for _, cb := range callbacks {
+		cbs = append(cbs, cb)
+	}
+	watchMu.Unlock()
+	for _, cb := range cbs {
+		cb()
+	}
+}
+
+// watch calls Refresh when h says the policies changed and every
+// refreshInterval, until ctx is done.
+func watch(ctx context.Context, h Handler) {
+	if n, ok := h.(ChangeNotifier); ok {
+		go n.WatchChanges(ctx, Refresh)
+	}
+	t := time.NewTicker(refreshInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			Refresh()
+		}
+	}
+}
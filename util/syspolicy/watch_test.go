@@ -0,0 +1,49 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package syspolicy
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestRefresh(t *testing.T) {
+	th := &testHandler{
+		t:   t,
+		key: "test",
+		s:   "foo",
+	}
+	ch := NewCachingHandler(th)
+	SetHandlerForTest(t, ch)
+
+	var calls atomic.Int32
+	unregister := RegisterChangeCallback(func() { calls.Add(1) })
+
+	if v, err := GetString("test", ""); err != nil || v != "foo" {
+		t.Fatalf("GetString = %q, %v; want foo", v, err)
+	}
+	th.s = "bar"
+	if v, _ := GetString("test", ""); v != "foo" {
+		t.Fatalf("GetString = %q before Refresh; want cached foo", v)
+	}
+
+	Refresh()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("callback called %d times; want 1", n)
+	}
+	if v, _ := GetString("test", ""); v != "bar" {
+		t.Errorf("GetString = %q after Refresh; want bar", v)
+	}
+
+	unregister()
+	Refresh()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("callback called %d times after unregister; want 1", n)
+	}
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	if stopWatcher != nil {
+		t.Error("watcher still running with no callbacks")
+	}
+}
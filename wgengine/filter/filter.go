@@ -303,13 +303,68 @@ func (f *Filter) logRateLimit(runflags RunFlags, q *packet.Parsed, dir direction
 // Check determines whether traffic from srcIP to dstIP:dstPort is allowed
 // using protocol proto.
 func (f *Filter) Check(srcIP, dstIP netip.Addr, dstPort uint16, proto ipproto.Proto) Response {
+	pkt := checkPacket(srcIP, dstIP, dstPort, proto)
+	if pkt == nil {
+		// Mismatched address families, no filters will
+		// match.
+		return Drop
+	}
+	return f.RunIn(pkt, 0)
+}
+
+// Explain is like Check, but also returns a short description of why the
+// filter reached its verdict and, if the traffic was accepted by one of the
+// filter's rules, the first such rule.
+func (f *Filter) Explain(srcIP, dstIP netip.Addr, dstPort uint16, proto ipproto.Proto) (r Response, why string, rule *Match) {
+	q := checkPacket(srcIP, dstIP, dstPort, proto)
+	if q == nil {
+		return Drop, "mismatched address families", nil
+	}
+	if r := f.pre(q, 0, in); r != noVerdict {
+		return r, "pre-filter", nil
+	}
+
+	var ms matches
+	switch q.IPVersion {
+	case 4:
+		r, why = f.runIn4(q)
+		ms = f.matches4
+	case 6:
+		r, why = f.runIn6(q)
+		ms = f.matches6
+	}
+	if r != Accept {
+		return r, why, nil
+	}
+
+	// Find the rule that accepted q, using the same matcher as runIn4 and
+	// runIn6 do for its protocol.
+	var match func(matches, *packet.Parsed) bool
+	switch proto {
+	case ipproto.ICMPv4, ipproto.ICMPv6:
+		match = matches.matchIPsOnly
+	case ipproto.TCP, ipproto.UDP, ipproto.SCTP:
+		match = matches.match
+	case ipproto.TSMP:
+		return r, why, nil
+	default:
+		match = matches.matchProtoAndIPsOnlyIfAllPorts
+	}
+	if m, ok := ms.first(q, match); ok {
+		rule = &m
+	}
+	return r, why, rule
+}
+
+// checkPacket returns a synthesized packet from srcIP to dstIP:dstPort using
+// protocol proto, for evaluating the filter without a real packet. It returns
+// nil if srcIP and dstIP are of different address families.
+func checkPacket(srcIP, dstIP netip.Addr, dstPort uint16, proto ipproto.Proto) *packet.Parsed {
 	pkt := &packet.Parsed{}
 	pkt.Decode(dummyPacket) // initialize private fields
 	switch {
 	case (srcIP.Is4() && dstIP.Is6()) || (srcIP.Is6() && srcIP.Is4()):
-		// Mismatched address families, no filters will
-		// match.
-		return Drop
+		return nil
 	case srcIP.Is4():
 		pkt.IPVersion = 4
 	case srcIP.Is6():
@@ -323,8 +378,7 @@ func (f *Filter) Check(srcIP, dstIP netip.Addr, dstPort uint16, proto ipproto.Pr
 	if proto == ipproto.TCP {
 		pkt.TCPFlags = packet.TCPSyn
 	}
-
-	return f.RunIn(pkt, 0)
+	return pkt
 }
 
 // CheckTCP determines whether TCP traffic from srcIP to dstIP:dstPort
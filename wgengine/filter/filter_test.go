@@ -159,6 +159,42 @@ type InOut struct {
 	}
 }
 
+func TestExplain(t *testing.T) {
+	acl := newFilter(t.Logf)
+	tests := []struct {
+		name     string
+		src, dst string
+		port     uint16
+		proto    ipproto.Proto
+		want     Response
+		wantWhy  string
+		wantRule string // Match.String of the accepting rule, or empty
+	}{
+		{"tcp", "8.2.2.2", "1.2.3.4", 22, ipproto.TCP, Accept, "tcp ok", "[TCP UDP ICMPv4 ICMPv6][8.1.1.1/32,8.2.2.2/32]=>[1.2.3.4/32:22,5.6.7.8/32:23-24]"},
+		{"tcp_wildcard_src", "17.34.51.68", "8.1.34.51", 443, ipproto.TCP, Accept, "tcp ok", "[TCP UDP ICMPv4 ICMPv6]0.0.0.0/0=>0.0.0.0/0:443"},
+		{"icmp", "8.1.1.1", "1.2.3.4", 0, ipproto.ICMPv4, Accept, "icmp ok", "[TCP UDP ICMPv4 ICMPv6][8.1.1.1/32,8.2.2.2/32]=>[1.2.3.4/32:22,5.6.7.8/32:23-24]"},
+		{"portless", "1.2.3.4", "5.6.7.8", 0, testAllowedProto, Accept, "other-portless ok", "[IPProto-116]0.0.0.0/0=>0.0.0.0/0:*"},
+		{"no_match", "8.3.3.3", "1.2.3.4", 22, ipproto.TCP, Drop, "no rules matched", ""},
+		{"not_local", "8.1.1.1", "16.32.48.64", 443, ipproto.TCP, Drop, "destination not allowed", ""},
+		{"mixed_families", "8.1.1.1", "2001::1", 22, ipproto.TCP, Drop, "mismatched address families", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, why, rule := acl.Explain(netip.MustParseAddr(tt.src), netip.MustParseAddr(tt.dst), tt.port, tt.proto)
+			if got != tt.want || why != tt.wantWhy {
+				t.Errorf("Explain = %v, %q; want %v, %q", got, why, tt.want, tt.wantWhy)
+			}
+			var gotRule string
+			if rule != nil {
+				gotRule = rule.String()
+			}
+			if gotRule != tt.wantRule {
+				t.Errorf("Explain rule = %q; want %q", gotRule, tt.wantRule)
+			}
+		})
+	}
+}
+
 func TestUDPState(t *testing.T) {
 	acl := newFilter(t.Logf)
 	flags := LogDrops | LogAccepts
@@ -157,6 +157,17 @@ func (ms matches) matchProtoAndIPsOnlyIfAllPorts(q *packet.Parsed) bool {
 	return false
 }
 
+// first returns the first Match in ms that match, one of the matches methods,
+// reports as matching q.
+func (ms matches) first(q *packet.Parsed, match func(matches, *packet.Parsed) bool) (Match, bool) {
+	for i := range ms {
+		if match(ms[i:i+1], q) {
+			return ms[i], true
+		}
+	}
+	return Match{}, false
+}
+
 func ipInList(ip netip.Addr, netlist []netip.Prefix) bool {
 	for _, net := range netlist {
 		if net.Contains(ip) {
@@ -60,6 +60,12 @@
 	//
 	//lint:ignore U1000 used on Linux/Darwin only
 	debugPMTUD = envknob.RegisterBool("TS_DEBUG_PMTUD")
+	// debugNoDERPFailoverDup disables duplicating packets to both our
+	// old and new DERP homes while changing homes. See derpFailover.
+	debugNoDERPFailoverDup = envknob.RegisterBool("TS_DEBUG_NO_DERP_FAILOVER_DUP")
+	// debugNoDERPSteering disables following the advice of loaded DERP
+	// servers to use an alternate region as our home. See steerDERP.
+	debugNoDERPSteering = envknob.RegisterBool("TS_DEBUG_NO_DERP_STEERING")
 	// Hey you! Adding a new debugknob? Make sure to stub it out in the
 	// debugknobs_stubs.go file too.
 )
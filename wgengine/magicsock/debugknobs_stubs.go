@@ -28,3 +28,5 @@ func debugEnablePMTUD() opt.Bool       { return "" }
 func debugRingBufferMaxSizeBytes() int { return 0 }
 func inTest() bool                     { return false }
 func debugPeerMap() bool               { return false }
+func debugNoDERPFailoverDup() bool     { return false }
+func debugNoDERPSteering() bool        { return false }
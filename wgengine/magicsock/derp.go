@@ -23,6 +23,7 @@
 	"tailscale.com/health"
 	"tailscale.com/logtail/backoff"
 	"tailscale.com/net/dnscache"
+	"tailscale.com/net/netcheck"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/syncs"
 	"tailscale.com/tailcfg"
@@ -156,6 +157,13 @@ func (c *Conn) setNearestDERP(derpNum int) (wantDERP bool) {
 	}
 	if c.myDerp != 0 && derpNum != 0 {
 		metricDERPHomeChange.Add(1)
+		if !debugNoDERPFailoverDup() {
+			c.setDERPFailoverLocked(derpFailover{
+				from:  c.myDerp,
+				to:    derpNum,
+				until: time.Now().Add(derpFailoverDupDuration),
+			})
+		}
 	}
 	c.myDerp = derpNum
 	health.SetMagicSockDERPHome(derpNum, c.homeless)
@@ -181,6 +189,171 @@ func (c *Conn) setNearestDERP(derpNum int) (wantDERP bool) {
 	return true
 }
 
+// derpSteeringTTL is how long the steering advice of a DERP server is
+// considered fresh. Servers send their advice again periodically to the
+// clients connected to them; if we moved away from a server's region, we
+// reconnect to it briefly once its advice is stale to hear from it again.
+const derpSteeringTTL = 15 * time.Minute
+
+// derpSteeringMaxAge is how long stale steering advice is still followed
+// while we wait to hear from the server again, after which we move back to
+// the nearest region.
+const derpSteeringMaxAge = 4 * derpSteeringTTL
+
+// derpSteeringHint is the steering advice that a DERP server last sent us.
+type derpSteeringHint struct {
+	si derp.ServerInfoMessage
+	at time.Time
+}
+
+// noteDERPSteering records the steering advice in si from the DERP server
+// of region regionID.
+//
+// If the server of our home region advises moving, such as when it's
+// draining, it starts a netcheck to pick our home again.
+func (c *Conn) noteDERPSteering(regionID int, si derp.ServerInfoMessage) {
+	c.mu.Lock()
+	if si.Load == 0 && len(si.AlternateRegions) == 0 {
+		delete(c.derpSteering, regionID)
+		c.mu.Unlock()
+		return
+	}
+	mak.Set(&c.derpSteering, regionID, derpSteeringHint{si, time.Now()})
+	isHome := regionID == c.myDerp
+	c.mu.Unlock()
+
+	if isHome && derphttp.SteeringAdvised(si) && !debugNoDERPSteering() {
+		c.ReSTUN("derp-steering")
+	}
+}
+
+// steerDERP returns the DERP region to use as our home instead of
+// preferred, the nearest one, following the recent advice of the servers of
+// preferred to move to an alternate region when they're heavily loaded.
+//
+// c.mu must NOT be held.
+func (c *Conn) steerDERP(preferred int, report *netcheck.Report) int {
+	if debugNoDERPSteering() {
+		return preferred
+	}
+	c.mu.Lock()
+	h, ok := c.derpSteering[preferred]
+	myDerp := c.myDerp
+	c.mu.Unlock()
+	if !ok {
+		return preferred
+	}
+	switch age := time.Since(h.at); {
+	case age > derpSteeringMaxAge:
+		return preferred
+	case age > derpSteeringTTL && myDerp != preferred:
+		// We're not connected to preferred, so its servers can't
+		// tell us whether to stay away. Connect to it to get their
+		// advice, and stay where we are until then.
+		c.goDerpConnect(preferred)
+	}
+	rid := derphttp.SteerRegion(preferred, myDerp, h.si, report.RegionLatency)
+	if rid != preferred && rid != myDerp {
+		c.logf("magicsock: derp-%d is loaded (%.0f%%); steering to derp-%d", preferred, h.si.Load*100, rid)
+	}
+	return rid
+}
+
+// derpFailoverDupDuration is how long after a change of our DERP home packets
+// sent via the old or new home continue to be duplicated to the other, for
+// peers we haven't yet heard from via the new home.
+const derpFailoverDupDuration = 30 * time.Second
+
+// derpFailover is a change of our DERP home from region from to region to.
+//
+// Until a peer learns of our new home from the control plane, it keeps
+// sending to us via the old one, and we reply to it there (see derpRoute).
+// If our home changed because the old one degraded, those packets are
+// likely lost, while once the peer has moved to the new home, the old one
+// has no route to it at all. So until we hear from the peer via the new
+// home, or until derpFailoverDupDuration passes, packets to it via either
+// home are sent via both, such that it gets them whichever it's using.
+type derpFailover struct {
+	from, to int       // region IDs; from is zero if there's no change in progress
+	until    time.Time // when to stop duplicating packets
+}
+
+// setDERPFailoverLocked sets c.derpFailover to f, or clears it if f is the
+// zero value.
+//
+// c.mu must be held.
+func (c *Conn) setDERPFailoverLocked(f derpFailover) {
+	c.derpFailover = f
+	if f.from == 0 {
+		c.derpFailoverUntil.Store(0)
+	} else {
+		c.derpFailoverUntil.Store(f.until.UnixNano())
+	}
+}
+
+// derpFailoverChanLocked returns the write channel of the DERP connection
+// to which to duplicate a packet for peer that's being sent to the write
+// channel primary, or nil if it's not to be duplicated.
+//
+// c.mu must be held.
+func (c *Conn) derpFailoverChanLocked(peer key.NodePublic, primary chan<- derpWriteRequest) chan<- derpWriteRequest {
+	f := c.derpFailover
+	if f.from == 0 || peer.IsZero() {
+		return nil
+	}
+	if time.Now().After(f.until) {
+		c.setDERPFailoverLocked(derpFailover{})
+		return nil
+	}
+	if r, ok := c.derpRoute[peer]; ok && r.derpID == f.to {
+		// We've heard from the peer via our new home, so it'll
+		// get our packets there.
+		return nil
+	}
+	from, fromOK := c.activeDerp[f.from]
+	to, toOK := c.activeDerp[f.to]
+	if !fromOK || !toOK {
+		return nil
+	}
+	switch primary {
+	case from.writeCh:
+		*to.lastWrite = time.Now()
+		return to.writeCh
+	case to.writeCh:
+		*from.lastWrite = time.Now()
+		return from.writeCh
+	}
+	// The packet is sent via neither home, so most likely to the peer's
+	// own home, where it's always connected.
+	return nil
+}
+
+// sendDERPFailoverDup sends a copy of wr, which was just sent to the write
+// channel primary, to our other DERP home if there's a change of home in
+// progress. See derpFailover.
+//
+// c.mu must NOT be held.
+func (c *Conn) sendDERPFailoverDup(primary chan<- derpWriteRequest, wr derpWriteRequest) {
+	// Most of the time there's no change of home in progress, so check
+	// for one without taking c.mu.
+	if until := c.derpFailoverUntil.Load(); until == 0 || time.Now().UnixNano() > until {
+		return
+	}
+	c.mu.Lock()
+	ch := c.derpFailoverChanLocked(wr.pubKey, primary)
+	c.mu.Unlock()
+	if ch == nil {
+		return
+	}
+	// The DERP writers don't modify the packet, so it can be shared.
+	select {
+	case ch <- wr:
+		metricSendDERPFailoverDup.Add(1)
+	default:
+		metricSendDERPFailoverDupDropped.Add(1)
+	}
+}
+
 // startDerpHomeConnectLocked starts connecting to our DERP home, if any.
 //
 // c.mu must be held.
@@ -475,6 +648,7 @@ func (c *Conn) runDerpReader(ctx context.Context, derpFakeAddr netip.AddrPort, d
 	bo := backoff.NewBackoff(fmt.Sprintf("derp-%d", regionID), c.logf, 5*time.Second)
 	var lastPacketTime time.Time
 	var lastPacketSrc key.NodePublic
+	var infoConnGen int // connGen of the last ServerInfoMessage
 
 	for {
 		msg, connGen, err := dc.RecvDetail()
@@ -523,6 +697,13 @@ func (c *Conn) runDerpReader(ctx context.Context, derpFakeAddr netip.AddrPort, d
 
 		switch m := msg.(type) {
 		case derp.ServerInfoMessage:
+			c.noteDERPSteering(regionID, m)
+			if connGen == infoConnGen {
+				// The server sent its info again on the same
+				// connection, as when it starts draining.
+				continue
+			}
+			infoConnGen = connGen
 			health.SetDERPRegionConnectedState(regionID, true)
 			health.SetDERPRegionHealth(regionID, "") // until declared otherwise
 			c.logf("magicsock: derp-%d connected; connGen=%v", regionID, connGen)
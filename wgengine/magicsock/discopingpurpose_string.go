@@ -15,11 +15,12 @@ func _() {
 	_ = x[pingHeartbeat-1]
 	_ = x[pingCLI-2]
 	_ = x[pingHeartbeatForUDPLifetime-3]
+	_ = x[pingMTUProbe-4]
 }
 
-const _discoPingPurpose_name = "DiscoveryHeartbeatCLIHeartbeatForUDPLifetime"
+const _discoPingPurpose_name = "DiscoveryHeartbeatCLIHeartbeatForUDPLifetimeMTUProbe"
 
-var _discoPingPurpose_index = [...]uint8{0, 9, 18, 21, 44}
+var _discoPingPurpose_index = [...]uint8{0, 9, 18, 21, 44, 52}
 
 func (i discoPingPurpose) String() string {
 	if i < 0 || i >= discoPingPurpose(len(_discoPingPurpose_index)-1) {
@@ -93,17 +93,65 @@ type endpoint struct {
 	heartbeatDisabled bool
 	probeUDPLifetime  *probeUDPLifetime // UDP path lifetime probing; nil if disabled
 
+	mtuProbe     *pathMTUProbe // re-probe of bestAddr's path MTU in progress, or nil
+	lastMTUProbe mono.Time     // when bestAddr's path MTU was last probed
+
 	expired         bool // whether the node has expired
 	isWireguardOnly bool // whether the endpoint is WireGuard only
+
+	// resumedPath is the path hint bestAddr was seeded with by resumePath,
+	// until disco either confirms or replaces it; zero otherwise.
+	resumedPath netip.AddrPort
+
+	pqHybrid pqHybridState // see pqhybrid.go
 }
 
 func (de *endpoint) setBestAddrLocked(v addrQuality) {
 	if v.AddrPort != de.bestAddr.AddrPort {
 		de.probeUDPLifetime.resetCycleEndpointLocked()
+		// The discovery pings that found the new address probed its
+		// path MTU, if enabled.
+		de.mtuProbe = nil
+		de.lastMTUProbe = mono.Now()
+		de.notePeerConnEventLocked("path", pathString(de.bestAddr.AddrPort), pathString(v.AddrPort))
 	}
 	de.bestAddr = v
 }
 
+// notePeerConnEventLocked reports a connectivity transition for de's peer to
+// Options.OnPeerConnEvent, if set.
+// de.mu must be held.
+func (de *endpoint) notePeerConnEventLocked(what, from, to string) {
+	if de.c.onPeerConnEvent == nil {
+		return
+	}
+	de.c.onPeerConnEvent(de.publicKey, ipnstate.PeerConnEvent{
+		When: time.Now(),
+		What: what,
+		From: from,
+		To:   to,
+	})
+}
+
+// pathString returns the PeerConnEvent representation of the path to a peer
+// whose best UDP address is ap, which is invalid if it's only reachable over
+// DERP.
+func pathString(ap netip.AddrPort) string {
+	if !ap.IsValid() {
+		return "derp"
+	}
+	return "udp:" + ap.String()
+}
+
+// derpHomeString returns the PeerConnEvent representation of the DERP home
+// whose magic address is ap, or the empty string if ap is invalid.
+func derpHomeString(ap netip.AddrPort) string {
+	if !ap.IsValid() {
+		return ""
+	}
+	return fmt.Sprintf("derp-%d", ap.Port())
+}
+
 const (
 	// udpLifetimeProbeCliffSlack is how much slack to use relative to a
 	// ProbeUDPLifetimeConfig.Cliffs duration in order to account for RTT,
@@ -821,6 +869,7 @@ func (de *endpoint) heartbeat() {
 	if udpAddr.IsValid() {
 		// We have a preferred path. Ping that every 2 seconds.
 		de.startDiscoPingLocked(udpAddr, now, pingHeartbeat, 0, nil)
+		de.maybeProbePathMTULocked(udpAddr, now)
 	}
 
 	if de.wantFullPingLocked(now) {
@@ -830,6 +879,68 @@ func (de *endpoint) heartbeat() {
 	de.heartBeatTimer = time.AfterFunc(heartbeatInterval, de.heartbeat)
 }
 
+// pathMTUProbe is the state of a re-probe of the path MTU of an endpoint's
+// bestAddr, which is done with one disco ping of each of the sizes that
+// discovery probes.
+type pathMTUProbe struct {
+	to      netip.AddrPort
+	pending int           // pings neither answered nor timed out yet
+	maxMTU  tstun.WireMTU // largest answered ping's; 0 if none yet
+}
+
+// maybeProbePathMTULocked probes the path MTU of ep, the best UDP address,
+// if peer path MTU discovery is enabled and it wasn't probed in the last
+// pathMTUProbeInterval. When the probe is done, the path MTU of bestAddr
+// is set to the largest ping size that got through, which may be smaller
+// than before if the path changed.
+//
+// de.mu must be held.
+func (de *endpoint) maybeProbePathMTULocked(ep netip.AddrPort, now mono.Time) {
+	if !de.c.PeerMTUEnabled() || de.isWireguardOnly || de.mtuProbe != nil {
+		return
+	}
+	if ep != de.bestAddr.AddrPort || now.Sub(de.lastMTUProbe) < pathMTUProbeInterval {
+		return
+	}
+	de.lastMTUProbe = now
+	de.startDiscoPingLocked(ep, now, pingMTUProbe, 0, nil)
+}
+
+// pathMTUProbeDoneLocked records the result of sp, a ping of a path MTU
+// probe, and updates the path MTU of bestAddr when all of its pings are
+// done.
+//
+// de.mu must be held.
+func (de *endpoint) pathMTUProbeDoneLocked(sp sentPing, result discoPingResult) {
+	p := de.mtuProbe
+	if p == nil || p.to != sp.to {
+		return
+	}
+	if result == discoPongReceived {
+		p.maxMTU = max(p.maxMTU, pingSizeToPktLen(sp.size, sp.to.Addr().Is6()))
+	}
+	p.pending--
+	if p.pending > 0 {
+		return
+	}
+	de.mtuProbe = nil
+	if p.maxMTU == 0 || de.bestAddr.AddrPort != p.to || de.bestAddr.wireMTU == p.maxMTU {
+		// Nothing got through, which the heartbeats deal with, or
+		// nothing changed.
+		return
+	}
+	de.c.logf("magicsock: disco: node %v %v path MTU to %v changed from %v to %v", de.publicKey.ShortString(), de.discoShort(), p.to, de.bestAddr.wireMTU, p.maxMTU)
+	newBest := de.bestAddr
+	newBest.wireMTU = p.maxMTU
+	de.debugUpdates.Add(EndpointChange{
+		When: time.Now(),
+		What: "pathMTUProbe-bestAddr-mtu",
+		From: de.bestAddr,
+		To:   newBest,
+	})
+	de.bestAddr = newBest
+}
+
 // setHeartbeatDisabled sets heartbeatDisabled to the provided value.
 func (de *endpoint) setHeartbeatDisabled(v bool) {
 	de.mu.Lock()
@@ -1061,8 +1172,11 @@ func (de *endpoint) removeSentDiscoPingLocked(txid stun.TxID, sp sentPing, resul
 	// Stop the timer for the case where sendPing failed to write to UDP.
 	// In the case of a timer already having fired, this is a no-op:
 	sp.timer.Stop()
-	if sp.purpose == pingHeartbeatForUDPLifetime {
+	switch sp.purpose {
+	case pingHeartbeatForUDPLifetime:
 		de.probeUDPLifetimeCliffDoneLocked(result, txid)
+	case pingMTUProbe:
+		de.pathMTUProbeDoneLocked(sp, result)
 	}
 	delete(de.sentPing, txid)
 }
@@ -1121,6 +1235,10 @@ func (de *endpoint) sendDiscoPing(ep netip.AddrPort, discoKey key.DiscoPublic, t
 	// discover whether the UDP path was still active through any and all
 	// stateful middleboxes involved.
 	pingHeartbeatForUDPLifetime
+
+	// pingMTUProbe means that the purpose of a ping was to probe the
+	// path MTU of the best UDP address again.
+	pingMTUProbe
 )
 
 // startDiscoPingLocked sends a disco ping to ep in a separate goroutine. resCB,
@@ -1146,15 +1264,15 @@ func (de *endpoint) startDiscoPingLocked(ep netip.AddrPort, now mono.Time, purpo
 		st.lastPing = now
 	}
 
-	// If we are doing a discovery ping or a CLI ping with no specified size
-	// to a non DERP address, then probe the MTU. Otherwise just send the
-	// one specified ping.
+	// If we are doing a discovery ping, an MTU probe or a CLI ping with no
+	// specified size to a non DERP address, then probe the MTU. Otherwise
+	// just send the one specified ping.
 
 	// Default to sending a single ping of the specified size
 	sizes := []int{size}
 	if de.c.PeerMTUEnabled() {
 		isDerp := ep.Addr() == tailcfg.DerpMagicIPAddr
-		if !isDerp && ((purpose == pingDiscovery) || (purpose == pingCLI && size == 0)) {
+		if !isDerp && (purpose == pingDiscovery || purpose == pingMTUProbe || (purpose == pingCLI && size == 0)) {
 			de.c.dlogf("[v1] magicsock: starting MTU probe")
 			sizes = mtuProbePingSizesV4
 			if ep.Addr().Is6() {
@@ -1162,9 +1280,12 @@ func (de *endpoint) startDiscoPingLocked(ep netip.AddrPort, now mono.Time, purpo
 			}
 		}
 	}
+	if purpose == pingMTUProbe {
+		de.mtuProbe = &pathMTUProbe{to: ep, pending: len(sizes)}
+	}
 
 	logLevel := discoLog
-	if purpose == pingHeartbeat {
+	if purpose == pingHeartbeat || purpose == pingMTUProbe {
 		logLevel = discoVerboseLog
 	}
 	if purpose == pingCLI {
@@ -1192,6 +1313,7 @@ func (de *endpoint) startDiscoPingLocked(ep netip.AddrPort, now mono.Time, purpo
 // sendDiscoPingsLocked starts pinging all of ep's endpoints.
 func (de *endpoint) sendDiscoPingsLocked(now mono.Time, sendCallMeMaybe bool) {
 	de.lastFullPing = now
+	de.maybeStartPQHybridLocked(now)
 	var sentAny bool
 	for ep, st := range de.endpointState {
 		if st.shouldDeleteLocked() {
@@ -1321,6 +1443,7 @@ func (de *endpoint) updateFromNode(n tailcfg.NodeView, heartbeatDisabled bool, p
 		de.setProbeUDPLifetimeConfigLocked(nil)
 	}
 	de.expired = n.Expired()
+	de.pqHybrid.peerCap = n.HasCap(tailcfg.NodeAttrPQHybridKeyExchange)
 
 	epDisco := de.disco.Load()
 	var discoKey key.DiscoPublic
@@ -1339,6 +1462,7 @@ func (de *endpoint) updateFromNode(n tailcfg.NodeView, heartbeatDisabled bool, p
 			What: "updateFromNode-resetLocked",
 		})
 		de.resetLocked()
+		de.resetPQHybridLocked()
 	}
 	if n.DERP() == "" {
 		if de.derpAddr.IsValid() {
@@ -1347,6 +1471,7 @@ func (de *endpoint) updateFromNode(n tailcfg.NodeView, heartbeatDisabled bool, p
 				What: "updateFromNode-remove-DERP",
 				From: de.derpAddr,
 			})
+			de.notePeerConnEventLocked("derp-home", derpHomeString(de.derpAddr), "")
 		}
 		de.derpAddr = netip.AddrPort{}
 	} else {
@@ -1358,6 +1483,7 @@ func (de *endpoint) updateFromNode(n tailcfg.NodeView, heartbeatDisabled bool, p
 				From: de.derpAddr,
 				To:   newDerp,
 			})
+			de.notePeerConnEventLocked("derp-home", derpHomeString(de.derpAddr), derpHomeString(newDerp))
 		}
 		de.derpAddr = newDerp
 	}
@@ -1460,6 +1586,7 @@ func (de *endpoint) clearBestAddrLocked() {
 	de.setBestAddrLocked(addrQuality{})
 	de.bestAddrAt = 0
 	de.trustBestAddrUntil = 0
+	de.resumedPath = netip.AddrPort{}
 }
 
 // noteBadEndpoint marks ipp as a bad endpoint that would need to be
@@ -1613,6 +1740,12 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src netip
 			de.bestAddrAt = now
 			de.trustBestAddrUntil = now.Add(trustUDPAddrDuration)
 		}
+		if de.resumedPath.IsValid() {
+			if de.bestAddr.AddrPort == de.resumedPath {
+				metricPathHintsConfirmed.Add(1)
+			}
+			de.resumedPath = netip.AddrPort{}
+		}
 	}
 	return
 }
@@ -1841,5 +1974,9 @@ func (de *endpoint) numStopAndReset() int64 {
 func (de *endpoint) setDERPHome(regionID uint16) {
 	de.mu.Lock()
 	defer de.mu.Unlock()
-	de.derpAddr = netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, uint16(regionID))
+	newDerp := netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, uint16(regionID))
+	if de.derpAddr != newDerp {
+		de.notePeerConnEventLocked("derp-home", derpHomeString(de.derpAddr), derpHomeString(newDerp))
+	}
+	de.derpAddr = newDerp
 }
@@ -9,7 +9,9 @@
 	"time"
 
 	"github.com/dsnet/try"
+	"tailscale.com/tstime/mono"
 	"tailscale.com/types/key"
+	"tailscale.com/util/ringbuffer"
 )
 
 func TestProbeUDPLifetimeConfig_Equals(t *testing.T) {
@@ -324,3 +326,44 @@ func(lifetime *probeUDPLifetime) time.Duration {
 		})
 	}
 }
+
+func TestPathMTUProbeDone(t *testing.T) {
+	addr := netip.MustParseAddrPort("1.1.1.1:1")
+	de := &endpoint{
+		c:            &Conn{logf: t.Logf},
+		bestAddr:     addrQuality{AddrPort: addr, wireMTU: 9000},
+		debugUpdates: ringbuffer.New[EndpointChange](10),
+	}
+	de.maybeProbePathMTULocked(addr, mono.Now())
+	if de.mtuProbe != nil {
+		t.Fatal("path MTU probed with peer path MTU discovery disabled")
+	}
+
+	// The path shrank: only the pings of up to 1400 bytes get through.
+	de.mtuProbe = &pathMTUProbe{to: addr, pending: len(mtuProbePingSizesV4)}
+	for _, size := range mtuProbePingSizesV4 {
+		result := discoPongReceived
+		if pingSizeToPktLen(size, false) > 1400 {
+			result = discoPingTimedOut
+		}
+		if de.bestAddr.wireMTU != 9000 {
+			t.Fatalf("path MTU changed to %v before the probe was done", de.bestAddr.wireMTU)
+		}
+		de.pathMTUProbeDoneLocked(sentPing{to: addr, size: size, purpose: pingMTUProbe}, result)
+	}
+	if de.mtuProbe != nil {
+		t.Error("probe still in progress after all its pings are done")
+	}
+	if de.bestAddr.wireMTU != 1400 {
+		t.Errorf("path MTU = %v; want 1400", de.bestAddr.wireMTU)
+	}
+
+	// A probe that nothing answers leaves the path MTU alone.
+	de.mtuProbe = &pathMTUProbe{to: addr, pending: len(mtuProbePingSizesV4)}
+	for _, size := range mtuProbePingSizesV4 {
+		de.pathMTUProbeDoneLocked(sentPing{to: addr, size: size, purpose: pingMTUProbe}, discoPingTimedOut)
+	}
+	if de.bestAddr.wireMTU != 1400 {
+		t.Errorf("path MTU = %v after an unanswered probe; want 1400", de.bestAddr.wireMTU)
+	}
+}
@@ -87,9 +87,11 @@ type Conn struct {
 	derpActiveFunc         func()
 	idleFunc               func() time.Duration // nil means unknown
 	testOnlyPacketListener nettype.PacketListener
-	noteRecvActivity       func(key.NodePublic) // or nil, see Options.NoteRecvActivity
-	netMon                 *netmon.Monitor      // or nil
-	controlKnobs           *controlknobs.Knobs  // or nil
+	noteRecvActivity       func(key.NodePublic)                            // or nil, see Options.NoteRecvActivity
+	onPeerConnEvent        func(key.NodePublic, ipnstate.PeerConnEvent)    // or nil, see Options.OnPeerConnEvent
+	onPQHybridKey          func(key.NodePublic, key.DiscoPublic, [32]byte) // or nil, see Options.OnPQHybridKey
+	netMon                 *netmon.Monitor                                 // or nil
+	controlKnobs           *controlknobs.Knobs                             // or nil
 
 	// ================================================================
 	// No locking required to access these fields, either because
@@ -142,6 +144,7 @@ type Conn struct {
 	silentDiscoOn atomic.Bool // whether silent disco is enabled
 
 	probeUDPLifetimeOn atomic.Bool // whether probing of UDP lifetime is enabled
+	pqHybridOn         atomic.Bool // whether the hybrid key exchange is enabled; see pqhybrid.go
 
 	// noV4Send is whether IPv4 UDP is known to be unable to transmit
 	// at all. This could happen if the socket is in an invalid state
@@ -181,6 +184,16 @@ type Conn struct {
 	// captureHook, if non-nil, is the pcap logging callback when capturing.
 	captureHook syncs.AtomicValue[capture.Callback]
 
+	// testOnlyDropDisco, if non-nil, reports whether to drop a disco
+	// message to the given node instead of sending it, as if it were lost.
+	// For tests only.
+	testOnlyDropDisco syncs.AtomicValue[func(key.NodePublic, disco.Message) bool]
+
+	// derpFailoverUntil is derpFailover.until in Unix nanoseconds, or zero
+	// if there's no change of DERP home in progress. It lets DERP sends
+	// skip taking mu to check for one.
+	derpFailoverUntil atomic.Int64
+
 	// discoPrivate is the private naclbox key used for active
 	// discovery traffic. It is always present, and immutable.
 	discoPrivate key.DiscoPrivate
@@ -292,6 +305,20 @@ type Conn struct {
 	// peer. It's only used to quiet logging, so we only log on change.
 	peerLastDerp map[key.NodePublic]int
 
+	// derpFailover is the change of our DERP home in progress, if any,
+	// during which packets sent via one of the old and new homes are
+	// duplicated to the other. See derpFailoverChanLocked.
+	derpFailover derpFailover
+
+	// derpSteering is the steering advice that the DERP servers of each
+	// region last sent us. See steerDERP.
+	derpSteering map[int]derpSteeringHint
+
+	// pathHints are direct paths to peers that were in use before this
+	// node last restarted, to be resumed when the peers' endpoints are
+	// created. Entries are removed once used. See SetPathHints.
+	pathHints map[key.NodePublic]netip.AddrPort
+
 	// wgPinger is the WireGuard only pinger used for latency measurements.
 	wgPinger lazy.SyncValue[*ping.Pinger]
 
@@ -367,6 +394,18 @@ type Options struct {
 	// OnPortUpdate is called with the new port when magicsock rebinds to
 	// a new port.
 	OnPortUpdate func(port uint16, network string)
+
+	// OnPeerConnEvent, if provided, is called whenever the path used to
+	// reach a peer or the peer's home DERP region changes.
+	// It is called with internal locks held and must not block or call
+	// back into the Conn.
+	OnPeerConnEvent func(key.NodePublic, ipnstate.PeerConnEvent)
+
+	// OnPQHybridKey, if provided, is called with the WireGuard preshared key
+	// to use with a peer after the experimental post-quantum hybrid key
+	// exchange with it completes. The key is only valid for as long as the
+	// peer has the provided disco key. It's called without any locks held.
+	OnPQHybridKey func(key.NodePublic, key.DiscoPublic, [32]byte)
 }
 
 func (o *Options) logf() logger.Logf {
@@ -434,6 +473,8 @@ func NewConn(opts Options) (*Conn, error) {
 	c.idleFunc = opts.IdleFunc
 	c.testOnlyPacketListener = opts.TestOnlyPacketListener
 	c.noteRecvActivity = opts.NoteRecvActivity
+	c.onPeerConnEvent = opts.OnPeerConnEvent
+	c.onPQHybridKey = opts.OnPQHybridKey
 	c.portMapper = portmapper.NewClient(logger.WithPrefix(c.logf, "portmapper: "), opts.NetMon, nil, opts.ControlKnobs, c.onPortMapChanged)
 	if opts.NetMon != nil {
 		c.portMapper.SetGatewayLookupFunc(opts.NetMon.GatewayAndSelfIP)
@@ -652,6 +693,14 @@ func (c *Conn) updateNetInfo(ctx context.Context) (*netcheck.Report, error) {
 	c.noV6.Store(!report.IPv6)
 	c.noV4Send.Store(!report.IPv4CanSend)
 
+	// Only full reports check for a captive portal. Otherwise, working UDP
+	// means we're no longer stuck behind one.
+	if v, ok := report.CaptivePortal.Get(); ok {
+		health.SetCaptivePortalDetected(v)
+	} else if report.UDP {
+		health.SetCaptivePortalDetected(false)
+	}
+
 	ni := &tailcfg.NetInfo{
 		DERPLatency:           map[string]float64{},
 		MappingVariesByDestIP: report.MappingVariesByDestIP,
@@ -677,6 +726,8 @@ func (c *Conn) updateNetInfo(ctx context.Context) (*netcheck.Report, error) {
 		// Perhaps UDP is blocked. Pick a deterministic but arbitrary
 		// one.
 		ni.PreferredDERP = c.pickDERPFallback()
+	} else {
+		ni.PreferredDERP = c.steerDERP(ni.PreferredDERP, report)
 	}
 	if !c.setNearestDERP(ni.PreferredDERP) {
 		ni.PreferredDERP = 0
@@ -758,6 +809,12 @@ func (c *Conn) LastRecvActivityOfNodeKey(nk key.NodePublic) string {
 	return mono.Since(saw).Round(time.Second).String()
 }
 
+// LastNetcheckReport returns the report of the most recent netcheck, or nil
+// if none has completed yet. The caller must not modify it.
+func (c *Conn) LastNetcheckReport() *netcheck.Report {
+	return c.lastNetCheckReport.Load()
+}
+
 // Ping handles a "tailscale ping" CLI query.
 func (c *Conn) Ping(peer tailcfg.NodeView, res *ipnstate.PingResult, size int, cb func(*ipnstate.PingResult)) {
 	c.mu.Lock()
@@ -1117,6 +1174,7 @@ func (c *Conn) sendAddr(addr netip.AddrPort, pubKey key.NodePublic, b []byte) (s
 		return false, errConnClosed
 	case ch <- derpWriteRequest{addr, pubKey, pkt}:
 		metricSendDERPQueued.Add(1)
+		c.sendDERPFailoverDup(ch, derpWriteRequest{addr, pubKey, pkt})
 		return true, nil
 	default:
 		metricSendDERPErrorQueue.Add(1)
@@ -1276,6 +1334,9 @@ func (c *Conn) sendDiscoMessage(dst netip.AddrPort, dstKey key.NodePublic, dstDi
 	if _, isPong := m.(*disco.Pong); isPong && !isDERP && dst.Addr().Is4() {
 		time.Sleep(debugIPv4DiscoPingPenalty())
 	}
+	if drop := c.testOnlyDropDisco.Load(); drop != nil && drop(dstKey, m) {
+		return true, nil
+	}
 
 	c.mu.Lock()
 	if c.closed {
@@ -1492,6 +1553,27 @@ func (c *Conn) handleDiscoMessage(msg []byte, src netip.AddrPort, derpNodeSrc ke
 			ep.publicKey.ShortString(), derpStr(src.String()),
 			len(dm.MyNumber))
 		go ep.handleCallMeMaybe(dm)
+	case *disco.HybridKEMInit, *disco.HybridKEMResponse, *disco.HybridKEMAck:
+		// Like CallMeMaybe, these only come via DERP, which
+		// authenticates the sender's node key.
+		if !isDERP || derpNodeSrc.IsZero() {
+			return
+		}
+		ep, ok := c.peerMap.endpointForNodeKey(derpNodeSrc)
+		if !ok {
+			return
+		}
+		if epDisco := ep.disco.Load(); epDisco == nil || epDisco.key != di.discoKey {
+			return
+		}
+		switch dm := dm.(type) {
+		case *disco.HybridKEMInit:
+			go ep.handleHybridKEMInit(dm)
+		case *disco.HybridKEMResponse:
+			go ep.handleHybridKEMResponse(dm)
+		case *disco.HybridKEMAck:
+			go ep.handleHybridKEMAck(dm)
+		}
 	}
 	return
 }
@@ -2009,6 +2091,10 @@ func (c *Conn) SetNetworkMap(nm *netmap.NetworkMap) {
 		}
 
 		ep.updateFromNode(n, flags.heartbeatDisabled, flags.probeUDPLifetimeOn)
+		if addr, ok := c.pathHints[n.Key()]; ok {
+			delete(c.pathHints, n.Key())
+			ep.resumePath(addr)
+		}
 		c.peerMap.upsertEndpoint(ep, key.DiscoPublic{})
 	}
 
@@ -2786,6 +2872,11 @@ func (c *Conn) SetHomeless(v bool) {
 	// path (without using DERP) without having heard a Pong reply.
 	trustUDPAddrDuration = 6500 * time.Millisecond
 
+	// pathMTUProbeInterval is how often, while a session is active, the
+	// path MTU of the best UDP address is probed again if peer path MTU
+	// discovery is enabled, so that it tracks changes of the path.
+	pathMTUProbeInterval = 5 * time.Minute
+
 	// goodEnoughLatency is the latency at or under which we don't
 	// try to upgrade to a better path.
 	goodEnoughLatency = 5 * time.Millisecond
@@ -2958,10 +3049,19 @@ type discoInfo struct {
 	metricRecvDiscoCallMeMaybeBadDisco = clientmetric.NewCounter("magicsock_disco_recv_callmemaybe_bad_disco")
 	metricRecvDiscoDERPPeerNotHere     = clientmetric.NewCounter("magicsock_disco_recv_derp_peer_not_here")
 	metricRecvDiscoDERPPeerGoneUnknown = clientmetric.NewCounter("magicsock_disco_recv_derp_peer_gone_unknown")
+	// Path hints, see SetPathHints.
+	metricPathHintsResumed   = clientmetric.NewCounter("magicsock_path_hints_resumed")
+	metricPathHintsConfirmed = clientmetric.NewCounter("magicsock_path_hints_confirmed")
+
 	// metricDERPHomeChange is how many times our DERP home region DI has
 	// changed from non-zero to a different non-zero.
 	metricDERPHomeChange = clientmetric.NewCounter("derp_home_change")
 
+	// Packets duplicated to our other DERP home during a change of home,
+	// see derpFailover.
+	metricSendDERPFailoverDup        = clientmetric.NewCounter("magicsock_send_derp_failover_dup")
+	metricSendDERPFailoverDupDropped = clientmetric.NewCounter("magicsock_send_derp_failover_dup_dropped")
+
 	// Disco packets received bpf read path
 	//lint:ignore U1000 used on Linux only
 	metricRecvDiscoPacketIPv4 = clientmetric.NewCounter("magicsock_disco_recv_bpf_ipv4")
@@ -1524,6 +1524,159 @@ func TestSetNetworkMapChangingNodeKey(t *testing.T) {
 	}
 }
 
+func TestPathHints(t *testing.T) {
+	conn := newTestConn(t)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetPrivateKey(key.NodePrivateFromRaw32(mem.B([]byte{0: 1, 31: 0})))
+
+	discoKey := key.DiscoPublicFromRaw32(mem.B([]byte{31: 1}))
+	nodeKey1 := key.NodePublicFromRaw32(mem.B([]byte{0: 'N', 1: 'K', 2: '1', 31: 0}))
+	nodeKey2 := key.NodePublicFromRaw32(mem.B([]byte{0: 'N', 1: 'K', 2: '2', 31: 0}))
+	hinted := netip.MustParseAddrPort("203.0.113.1:41641")
+
+	conn.SetPathHints([]PathHint{
+		{Node: nodeKey1, Addr: hinted, LastUsed: time.Now()},
+		{Node: nodeKey2, Addr: hinted, LastUsed: time.Now().Add(-2 * pathHintMaxAge)},
+	})
+	conn.SetNetworkMap(&netmap.NetworkMap{
+		Peers: nodeViews([]*tailcfg.Node{
+			{
+				ID:        1,
+				Key:       nodeKey1,
+				DiscoKey:  discoKey,
+				Endpoints: eps("192.168.1.2:345"),
+			},
+			{
+				ID:        2,
+				Key:       nodeKey2,
+				DiscoKey:  discoKey,
+				Endpoints: eps("192.168.1.3:345"),
+			},
+		}),
+	})
+
+	de1, ok := conn.peerMap.endpointForNodeKey(nodeKey1)
+	if !ok {
+		t.Fatal("no endpoint for node 1")
+	}
+	de1.mu.Lock()
+	if de1.bestAddr.AddrPort != hinted {
+		t.Errorf("node 1 bestAddr = %v; want hinted path %v", de1.bestAddr.AddrPort, hinted)
+	}
+	if de1.trustBestAddrUntil != 0 {
+		t.Errorf("node 1 hinted path is trusted before disco confirmed it")
+	}
+	if _, ok := de1.endpointState[hinted]; !ok {
+		t.Errorf("node 1 hinted path is not a candidate endpoint")
+	}
+	de1.mu.Unlock()
+
+	de2, ok := conn.peerMap.endpointForNodeKey(nodeKey2)
+	if !ok {
+		t.Fatal("no endpoint for node 2")
+	}
+	de2.mu.Lock()
+	if de2.bestAddr.IsValid() {
+		t.Errorf("node 2 bestAddr = %v from stale hint; want none", de2.bestAddr)
+	}
+	de2.mu.Unlock()
+
+	if got := conn.PathHints(); len(got) != 0 {
+		t.Errorf("PathHints = %v before any path was confirmed; want none", got)
+	}
+
+	de1.mu.Lock()
+	de1.bestAddrAt = mono.Now()
+	de1.mu.Unlock()
+	got := conn.PathHints()
+	if len(got) != 1 || got[0].Node != nodeKey1 || got[0].Addr != hinted {
+		t.Errorf("PathHints = %v; want a single hint for node 1 via %v", got, hinted)
+	}
+}
+
+func TestPathStats(t *testing.T) {
+	conn := newTestConn(t)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetPrivateKey(key.NodePrivateFromRaw32(mem.B([]byte{0: 1, 31: 0})))
+
+	discoKey := key.DiscoPublicFromRaw32(mem.B([]byte{31: 1}))
+	nodeKey := key.NodePublicFromRaw32(mem.B([]byte{0: 'N', 1: 'K', 2: '1', 31: 0}))
+	conn.SetNetworkMap(&netmap.NetworkMap{
+		Peers: nodeViews([]*tailcfg.Node{
+			{
+				ID:        1,
+				Key:       nodeKey,
+				DiscoKey:  discoKey,
+				DERP:      "127.3.3.40:1",
+				Endpoints: eps("192.168.1.2:345", "203.0.113.1:41641"),
+			},
+		}),
+	})
+
+	getStats := func() *ipnstate.PeerPathStats {
+		t.Helper()
+		got := conn.PathStats()
+		if len(got) != 1 || got[0].NodeKey != nodeKey {
+			t.Fatalf("PathStats = %v; want stats for a single peer", got)
+		}
+		return got[0]
+	}
+
+	ps := getStats()
+	if ps.Path != "none" || ps.Endpoint != "" || len(ps.Endpoints) != 2 {
+		t.Errorf("idle peer stats = %+v; want no path and 2 endpoints", ps)
+	}
+
+	de, ok := conn.peerMap.endpointForNodeKey(nodeKey)
+	if !ok {
+		t.Fatal("no endpoint for node")
+	}
+	direct := netip.MustParseAddrPort("203.0.113.1:41641")
+	now := mono.Now()
+	de.mu.Lock()
+	de.lastSendExt = now
+	de.endpointState[direct].addPongReplyLocked(pongReply{latency: 30 * time.Millisecond, pongAt: now, from: direct})
+	de.endpointState[direct].addPongReplyLocked(pongReply{latency: 20 * time.Millisecond, pongAt: now, from: direct})
+	de.mu.Unlock()
+
+	ps = getStats()
+	if ps.Path != "derp" {
+		t.Errorf("Path = %q without a direct path; want derp", ps.Path)
+	}
+	for _, es := range ps.Endpoints {
+		if es.Addr != direct {
+			continue
+		}
+		if es.Pongs != 2 || es.LatestRTTSeconds != 0.02 || es.MinRTTSeconds != 0.02 {
+			t.Errorf("endpoint stats = %+v; want 2 pongs, latest and min RTT of 20ms", es)
+		}
+	}
+
+	de.mu.Lock()
+	de.setBestAddrLocked(addrQuality{AddrPort: direct, latency: 20 * time.Millisecond, wireMTU: 1400})
+	de.trustBestAddrUntil = now.Add(time.Minute)
+	de.mu.Unlock()
+
+	ps = getStats()
+	if ps.Path != "direct" || ps.Endpoint != direct.String() || ps.RTTSeconds != 0.02 || ps.PathMTU != 1400 {
+		t.Errorf("stats = %+v; want a direct path via %v with RTT of 20ms and MTU of 1400", ps, direct)
+	}
+
+	de.mu.Lock()
+	de.trustBestAddrUntil = now.Add(-time.Second)
+	de.mu.Unlock()
+	ps = getStats()
+	if ps.Path != "udp+derp" || ps.Endpoint != direct.String() {
+		t.Errorf("stats = %+v; want a udp+derp path via %v once the direct path expired", ps, direct)
+	}
+	de.mu.Lock()
+	best, trustUntil := de.bestAddr, de.trustBestAddrUntil
+	de.mu.Unlock()
+	if best.AddrPort != direct || trustUntil != now.Add(-time.Second) {
+		t.Errorf("PathStats changed the best address to %v, trusted until %v", best.AddrPort, trustUntil)
+	}
+}
+
 func TestRebindStress(t *testing.T) {
 	conn := newTestConn(t)
 
@@ -3004,3 +3157,56 @@ func TestAddrForPingSizeLocked(t *testing.T) {
 		})
 	}
 }
+
+func TestDERPFailoverChan(t *testing.T) {
+	peer := key.NewNode().Public()
+	newActiveDerp := func() activeDerp {
+		return activeDerp{
+			writeCh:   make(chan derpWriteRequest, 1),
+			lastWrite: new(time.Time),
+		}
+	}
+	c := newConn()
+	c.activeDerp = map[int]activeDerp{
+		1: newActiveDerp(), // old home
+		2: newActiveDerp(), // new home
+		3: newActiveDerp(), // the peer's home
+	}
+	old, home, theirs := c.activeDerp[1].writeCh, c.activeDerp[2].writeCh, c.activeDerp[3].writeCh
+
+	if ch := c.derpFailoverChanLocked(peer, old); ch != nil {
+		t.Fatal("duplicated without a change of home")
+	}
+
+	c.setDERPFailoverLocked(derpFailover{from: 1, to: 2, until: time.Now().Add(time.Minute)})
+	if c.derpFailoverUntil.Load() == 0 {
+		t.Error("failover not published for the send fast path")
+	}
+	if ch := c.derpFailoverChanLocked(peer, old); ch != home {
+		t.Error("packet via old home not duplicated to new home")
+	}
+	if ch := c.derpFailoverChanLocked(peer, home); ch != old {
+		t.Error("packet via new home not duplicated to old home")
+	}
+	if ch := c.derpFailoverChanLocked(peer, theirs); ch != nil {
+		t.Error("packet via peer's home duplicated")
+	}
+	if ch := c.derpFailoverChanLocked(key.NodePublic{}, old); ch != nil {
+		t.Error("packet to no peer duplicated")
+	}
+
+	// Once we've heard from the peer via the new home, it's confirmed.
+	c.derpRoute = map[key.NodePublic]derpRoute{peer: {derpID: 2}}
+	if ch := c.derpFailoverChanLocked(peer, old); ch != nil {
+		t.Error("duplicated after hearing from peer via new home")
+	}
+	delete(c.derpRoute, peer)
+
+	c.derpFailover.until = time.Now().Add(-time.Second)
+	if ch := c.derpFailoverChanLocked(peer, old); ch != nil {
+		t.Error("duplicated after failover window")
+	}
+	if c.derpFailover.from != 0 || c.derpFailoverUntil.Load() != 0 {
+		t.Error("expired failover not cleared")
+	}
+}
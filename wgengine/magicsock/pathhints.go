@@ -0,0 +1,90 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net/netip"
+	"time"
+
+	"tailscale.com/net/tstun"
+	"tailscale.com/types/key"
+	"tailscale.com/util/mak"
+)
+
+// PathHint is a direct (non-DERP) path to a peer that was recently in use.
+//
+// Path hints are meant to be persisted across restarts of the node, so that
+// after an upgrade it can resume sending to its peers directly right away
+// rather than relaying via DERP until discovery completes again for each peer.
+type PathHint struct {
+	Node     key.NodePublic // the peer's node key
+	Addr     netip.AddrPort // the peer's direct address
+	LastUsed time.Time      // when the path was last known to be in use
+}
+
+// pathHintMaxAge is how old a PathHint can be before SetPathHints ignores it.
+const pathHintMaxAge = 24 * time.Hour
+
+// PathHints returns hints for the direct paths currently in use to peers.
+func (c *Conn) PathHints() []PathHint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	var hints []PathHint
+	c.peerMap.forEachEndpoint(func(ep *endpoint) {
+		ep.mu.Lock()
+		defer ep.mu.Unlock()
+		if ep.isWireguardOnly || !ep.bestAddr.IsValid() || ep.bestAddrAt == 0 {
+			// No direct path was ever confirmed with disco.
+			return
+		}
+		hints = append(hints, PathHint{
+			Node:     ep.publicKey,
+			Addr:     ep.bestAddr.AddrPort,
+			LastUsed: now,
+		})
+	})
+	return hints
+}
+
+// SetPathHints sets the direct paths to try first for peers that are added by
+// subsequent calls to SetNetworkMap, replacing any previously set hints. Hints
+// older than pathHintMaxAge are ignored.
+func (c *Conn) SetPathHints(hints []PathHint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pathHints = nil
+	now := time.Now()
+	for _, h := range hints {
+		if !h.Addr.IsValid() || now.Sub(h.LastUsed) > pathHintMaxAge {
+			continue
+		}
+		mak.Set(&c.pathHints, h.Node, h.Addr)
+	}
+}
+
+// resumePath seeds de with addr, a direct path to the peer that was in use
+// before this node restarted. addr becomes de's best address, but isn't
+// trusted until disco confirms it: until then, packets are sent both to it
+// and via DERP, and discovery runs as usual.
+func (de *endpoint) resumePath(addr netip.AddrPort) {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	if de.isWireguardOnly || de.bestAddr.IsValid() {
+		return
+	}
+	if _, ok := de.endpointState[addr]; !ok {
+		// Treat it like an endpoint learned at runtime, so that it's
+		// discarded if it doesn't pan out.
+		de.endpointState[addr] = &endpointState{lastGotPing: time.Now()}
+	}
+	de.debugUpdates.Add(EndpointChange{
+		When: time.Now(),
+		What: "resumePath",
+		To:   addr,
+	})
+	de.setBestAddrLocked(addrQuality{AddrPort: addr, wireMTU: tstun.SafeWireMTU()})
+	de.resumedPath = addr
+	metricPathHintsResumed.Add(1)
+}
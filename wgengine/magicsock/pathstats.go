@@ -0,0 +1,94 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"slices"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tstime/mono"
+)
+
+// PathStats returns the statistics of the paths to each peer, without the
+// fields that magicsock doesn't know about: their DNSName, TailscaleIPs,
+// LastHandshake, HandshakeAgeSeconds and Migrations.
+func (c *Conn) PathStats() []*ipnstate.PeerPathStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := mono.Now()
+	var ret []*ipnstate.PeerPathStats
+	c.peerMap.forEachEndpoint(func(ep *endpoint) {
+		ret = append(ret, ep.pathStats(now))
+	})
+	return ret
+}
+
+// pathStats returns the statistics of the path to de's peer.
+//
+// c.mu must be held.
+func (de *endpoint) pathStats(now mono.Time) *ipnstate.PeerPathStats {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+
+	ps := &ipnstate.PeerPathStats{
+		NodeKey:        de.publicKey,
+		Path:           "none",
+		DERPRegionCode: de.c.derpRegionCodeOfIDLocked(int(de.derpAddr.Port())),
+	}
+	if de.bestAddr.IsValid() {
+		ps.Endpoint = de.bestAddr.AddrPort.String()
+		ps.RTTSeconds = de.bestAddr.latency.Seconds()
+		ps.PathMTU = int(de.bestAddr.wireMTU)
+	}
+	if !de.lastSendExt.IsZero() && now.Sub(de.lastSendExt) < sessionActiveTimeout {
+		ps.Path = de.sendPathLocked(now)
+	}
+
+	for ipp, st := range de.endpointState {
+		es := &ipnstate.PathEndpointStats{
+			Addr:  ipp,
+			Pongs: len(st.recentPongs),
+		}
+		if len(st.recentPongs) > 0 {
+			es.LatestRTTSeconds = st.recentPongs[st.recentPong].latency.Seconds()
+			minLatency := st.recentPongs[0].latency
+			for _, pr := range st.recentPongs[1:] {
+				minLatency = min(minLatency, pr.latency)
+			}
+			es.MinRTTSeconds = minLatency.Seconds()
+		}
+		ps.Endpoints = append(ps.Endpoints, es)
+	}
+	slices.SortFunc(ps.Endpoints, func(a, b *ipnstate.PathEndpointStats) int {
+		return a.Addr.Compare(b.Addr)
+	})
+	return ps
+}
+
+// sendPathLocked returns the PeerPathStats.Path of how packets to de's peer
+// are sent now. Unlike addrForSendLocked, which it mirrors, it never
+// changes the endpoint's state.
+//
+// de.mu must be held.
+func (de *endpoint) sendPathLocked(now mono.Time) string {
+	udpAddr := de.bestAddr.AddrPort
+	switch {
+	case udpAddr.IsValid() && !now.After(de.trustBestAddrUntil):
+		return "direct"
+	case de.isWireguardOnly:
+		// addrForWireGuardSendLocked picks one of the endpoints.
+		if udpAddr.IsValid() || len(de.endpointState) > 0 {
+			return "direct"
+		}
+	case udpAddr.IsValid() && de.derpAddr.IsValid():
+		// The best address expired, so we send both to it and
+		// DERP while re-validating it.
+		return "udp+derp"
+	case de.derpAddr.IsValid():
+		return "derp"
+	case udpAddr.IsValid():
+		return "direct"
+	}
+	return "none"
+}
@@ -0,0 +1,234 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	crand "crypto/rand"
+	"time"
+
+	"tailscale.com/disco"
+	"tailscale.com/tstime/mono"
+	"tailscale.com/types/key"
+	"tailscale.com/util/clientmetric"
+)
+
+// This file implements the experimental post-quantum hybrid key exchange
+// enabled by tailcfg.NodeAttrPQHybridKeyExchange.
+//
+// Between two peers that both have the capability, the one with the lower
+// node key sends a disco.HybridKEMInit over DERP with an ephemeral
+// X25519+Kyber768 public key, and the other replies with a
+// disco.HybridKEMResponse encapsulating a fresh secret to it. Both sides
+// derive the same WireGuard preshared key from that secret and hand it to
+// Options.OnPQHybridKey. Mixing the preshared key into the Noise handshake
+// makes the session keys depend on both the classical and the post-quantum
+// exchange, without changing the data path.
+//
+// WireGuard handshakes fail while the two sides use different preshared
+// keys, so neither side switches to the new one until it knows the other
+// has it: the initiator sends a disco.HybridKEMAck once it's decapsulated
+// the response, the responder switches when it gets that ack and echoes it
+// back, and the initiator switches when it gets the echo. If any of these
+// messages is lost, the initiator starts over after pqHybridRetryInterval;
+// only a lost echo leaves the two sides with different keys until then.
+//
+// The exchange is redone whenever the peer's disco key changes (that is,
+// when it restarts and loses its preshared key).
+
+// pqHybridRetryInterval is how long an initiator waits for an exchange to
+// be confirmed before starting over with a new key.
+const pqHybridRetryInterval = 5 * time.Second
+
+// pqHybridState is an endpoint's state for the hybrid key exchange.
+// It's guarded by endpoint.mu.
+type pqHybridState struct {
+	peerCap  bool                 // peer has tailcfg.NodeAttrPQHybridKeyExchange
+	priv     key.HybridKEMPrivate // of the exchange in flight we initiated; zero if none
+	txID     [12]byte             // of the exchange in flight
+	lastInit mono.Time            // when priv was generated
+	pending  bool                 // secret was derived for txID but isn't confirmed yet
+	secret   [32]byte             // if pending, the secret derived
+	done     bool                 // a preshared key was agreed for the current disco key
+}
+
+// SetPQHybridKeyExchange sets whether the experimental post-quantum hybrid
+// key exchange is enabled for this node. It still only runs with peers that
+// have it enabled too.
+func (c *Conn) SetPQHybridKeyExchange(v bool) {
+	c.pqHybridOn.Store(v)
+}
+
+// pqHybridInitiatorLocked reports whether this node is the one to start the
+// hybrid key exchange with de.
+//
+// de.mu must be held.
+func (de *endpoint) pqHybridInitiatorLocked() bool {
+	self := de.c.publicKeyAtomic.Load()
+	return !self.IsZero() && self.Less(de.publicKey)
+}
+
+// maybeStartPQHybridLocked sends a HybridKEMInit to de if the hybrid key
+// exchange is enabled on both ends, this node is the initiator, and no
+// preshared key has been agreed yet. It's called whenever de is pinged, so
+// the exchange only happens with peers we're actually talking to.
+//
+// de.mu must be held.
+func (de *endpoint) maybeStartPQHybridLocked(now mono.Time) {
+	st := &de.pqHybrid
+	if !de.c.pqHybridOn.Load() || !st.peerCap || st.done || !de.derpAddr.IsValid() {
+		return
+	}
+	if !st.lastInit.IsZero() && now.Sub(st.lastInit) < pqHybridRetryInterval {
+		return
+	}
+	if !de.pqHybridInitiatorLocked() {
+		return
+	}
+	epDisco := de.disco.Load()
+	if epDisco == nil {
+		return
+	}
+	if !st.priv.IsZero() || st.pending {
+		// The previous attempt timed out.
+		metricPQHybridFailed.Add(1)
+	}
+	st.pending, st.secret = false, [32]byte{}
+	st.priv = key.NewHybridKEM()
+	crand.Read(st.txID[:])
+	st.lastInit = now
+	metricPQHybridInitiated.Add(1)
+	derpAddr, priv, txID := de.derpAddr, st.priv, st.txID
+	go func() {
+		de.c.sendDiscoMessage(derpAddr, de.publicKey, epDisco.key, &disco.HybridKEMInit{
+			TxID:   txID,
+			Public: priv.Public(),
+		}, discoLog)
+	}()
+}
+
+// resetPQHybridLocked forgets any agreed preshared key and exchange in
+// flight, so the exchange starts over the next time de is pinged.
+//
+// de.mu must be held.
+func (de *endpoint) resetPQHybridLocked() {
+	de.pqHybrid = pqHybridState{peerCap: de.pqHybrid.peerCap}
+}
+
+// handleHybridKEMInit answers a HybridKEMInit from de's peer. The secret
+// encapsulated in the response is only used once the peer acknowledges it;
+// see handleHybridKEMAck. It does the encapsulation, so it's run in its own
+// goroutine without any locks held.
+func (de *endpoint) handleHybridKEMInit(m *disco.HybridKEMInit) {
+	de.mu.Lock()
+	ok := de.c.pqHybridOn.Load() && de.pqHybrid.peerCap && !de.pqHybridInitiatorLocked()
+	derpAddr := de.derpAddr
+	de.mu.Unlock()
+	epDisco := de.disco.Load()
+	if !ok || !derpAddr.IsValid() || epDisco == nil {
+		return
+	}
+
+	ct, secret, err := m.Public.Encapsulate()
+	if err != nil {
+		metricPQHybridFailed.Add(1)
+		de.c.logf("magicsock: hybrid key exchange with %v failed: %v", de.publicKey.ShortString(), err)
+		return
+	}
+	sent, _ := de.c.sendDiscoMessage(derpAddr, de.publicKey, epDisco.key, &disco.HybridKEMResponse{
+		TxID:       m.TxID,
+		Ciphertext: ct,
+	}, discoLog)
+	if !sent {
+		// The initiator will retry.
+		return
+	}
+	de.mu.Lock()
+	de.pqHybrid.txID = m.TxID
+	de.pqHybrid.pending = true
+	de.pqHybrid.secret = secret
+	de.mu.Unlock()
+}
+
+// handleHybridKEMResponse decapsulates the secret in the response to the
+// exchange started by maybeStartPQHybridLocked and acknowledges it. Like
+// handleHybridKEMInit, it's run in its own goroutine without any locks held.
+func (de *endpoint) handleHybridKEMResponse(m *disco.HybridKEMResponse) {
+	de.mu.Lock()
+	st := &de.pqHybrid
+	if st.priv.IsZero() || st.txID != m.TxID {
+		de.mu.Unlock()
+		return
+	}
+	priv := st.priv
+	st.priv = key.HybridKEMPrivate{}
+	de.mu.Unlock()
+	epDisco := de.disco.Load()
+	if epDisco == nil {
+		return
+	}
+
+	secret, err := priv.Decapsulate(m.Ciphertext)
+	if err != nil {
+		metricPQHybridFailed.Add(1)
+		de.c.logf("magicsock: hybrid key exchange with %v failed: %v", de.publicKey.ShortString(), err)
+		return
+	}
+	de.mu.Lock()
+	if de.pqHybrid.txID != m.TxID {
+		// Superseded by a new exchange meanwhile.
+		de.mu.Unlock()
+		return
+	}
+	de.pqHybrid.pending = true
+	de.pqHybrid.secret = secret
+	derpAddr := de.derpAddr
+	de.mu.Unlock()
+	de.c.sendDiscoMessage(derpAddr, de.publicKey, epDisco.key, &disco.HybridKEMAck{TxID: m.TxID}, discoLog)
+}
+
+// handleHybridKEMAck completes the exchange with transaction m.TxID once the
+// peer has confirmed it derived the secret, by starting to use the preshared
+// key. The responder echoes the ack back to the initiator, which then does
+// too. It's run in its own goroutine without any locks held.
+func (de *endpoint) handleHybridKEMAck(m *disco.HybridKEMAck) {
+	de.mu.Lock()
+	st := &de.pqHybrid
+	if !st.pending || st.txID != m.TxID {
+		de.mu.Unlock()
+		return
+	}
+	secret := st.secret
+	st.pending, st.secret = false, [32]byte{}
+	st.done = true
+	initiator := de.pqHybridInitiatorLocked()
+	derpAddr := de.derpAddr
+	de.mu.Unlock()
+	epDisco := de.disco.Load()
+	if epDisco == nil {
+		return
+	}
+
+	if !initiator {
+		de.c.sendDiscoMessage(derpAddr, de.publicKey, epDisco.key, m, discoLog)
+	}
+	de.notePQHybridKey(epDisco.key, secret)
+}
+
+// notePQHybridKey reports the preshared key derived from secret, agreed
+// while the peer had disco key dk, to Options.OnPQHybridKey.
+func (de *endpoint) notePQHybridKey(dk key.DiscoPublic, secret [32]byte) {
+	metricPQHybridCompleted.Add(1)
+	de.c.logf("[v1] magicsock: hybrid key exchange with %v completed", de.publicKey.ShortString())
+	if de.c.onPQHybridKey == nil {
+		return
+	}
+	psk := key.HybridPresharedKey(secret, de.c.publicKeyAtomic.Load(), de.publicKey)
+	de.c.onPQHybridKey(de.publicKey, dk, psk)
+}
+
+var (
+	metricPQHybridInitiated = clientmetric.NewCounter("magicsock_pq_hybrid_initiated")
+	metricPQHybridCompleted = clientmetric.NewCounter("magicsock_pq_hybrid_completed")
+	metricPQHybridFailed    = clientmetric.NewCounter("magicsock_pq_hybrid_failed")
+)
@@ -0,0 +1,245 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tailscale.com/disco"
+	"tailscale.com/net/netaddr"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstest"
+	"tailscale.com/tstime/mono"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+	"tailscale.com/util/mak"
+)
+
+// pqHybridKey is a call to Options.OnPQHybridKey.
+type pqHybridKey struct {
+	peer  key.NodePublic
+	disco key.DiscoPublic
+	psk   [32]byte
+}
+
+// newPQHybridStack returns a magicStack with the hybrid key exchange set to
+// enabled, and the channel to which its Options.OnPQHybridKey sends.
+func newPQHybridStack(t *testing.T, derpMap *tailcfg.DERPMap, enabled bool) (*magicStack, <-chan pqHybridKey) {
+	m := newMagicStack(t, t.Logf, localhostListener{}, derpMap)
+	keys := make(chan pqHybridKey, 10)
+	// There are no peers yet, so nothing can call it concurrently.
+	m.conn.onPQHybridKey = func(nk key.NodePublic, dk key.DiscoPublic, psk [32]byte) {
+		keys <- pqHybridKey{nk, dk, psk}
+	}
+	m.conn.SetPQHybridKeyExchange(enabled)
+	return m, keys
+}
+
+// pqHybridCapFor returns a meshStacks netmap mutator that gives the peers
+// in the netmaps of the stacks with the given indexes
+// tailcfg.NodeAttrPQHybridKeyExchange.
+func pqHybridCapFor(idxs ...int) func(int, *netmap.NetworkMap) {
+	return func(idx int, nm *netmap.NetworkMap) {
+		for _, i := range idxs {
+			if i != idx {
+				continue
+			}
+			for j, p := range nm.Peers {
+				n := p.AsStruct()
+				mak.Set(&n.CapMap, tailcfg.NodeAttrPQHybridKeyExchange, nil)
+				nm.Peers[j] = n.View()
+			}
+		}
+	}
+}
+
+// waitPQHybridKey returns the next preshared key sent to keys.
+func waitPQHybridKey(t *testing.T, keys <-chan pqHybridKey) pqHybridKey {
+	t.Helper()
+	select {
+	case k := <-keys:
+		return k
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for hybrid key exchange")
+		return pqHybridKey{}
+	}
+}
+
+// waitEndpoint waits for m to know about peer, and returns its endpoint.
+func waitEndpoint(t *testing.T, m, peer *magicStack) *endpoint {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		m.conn.mu.Lock()
+		de, ok := m.conn.peerMap.endpointForNodeKey(peer.Public())
+		m.conn.mu.Unlock()
+		if ok {
+			de.mu.Lock()
+			valid := de.derpAddr.IsValid() && de.pqHybrid.peerCap
+			de.mu.Unlock()
+			if valid {
+				return de
+			}
+		}
+	}
+	t.Fatalf("%v never learned about %v", m, peer)
+	return nil
+}
+
+func TestPQHybridKeyExchange(t *testing.T) {
+	tstest.ResourceCheck(t)
+
+	derpMap, cleanup := runDERPAndStun(t, t.Logf, localhostListener{}, netaddr.IPv4(127, 0, 0, 1))
+	defer cleanup()
+
+	m1, keys1 := newPQHybridStack(t, derpMap, true)
+	defer m1.Close()
+	m2, keys2 := newPQHybridStack(t, derpMap, true)
+	defer m2.Close()
+
+	cleanupMesh := meshStacks(t.Logf, pqHybridCapFor(0, 1), m1, m2)
+	defer cleanupMesh()
+
+	// Traffic between the two starts the exchange.
+	cleanupPinger := newPinger(t, t.Logf, m1, m2)
+	defer cleanupPinger()
+
+	k1 := waitPQHybridKey(t, keys1)
+	k2 := waitPQHybridKey(t, keys2)
+	if k1.peer != m2.Public() || k1.disco != m2.conn.DiscoPublicKey() {
+		t.Errorf("conn1 got key for %v/%v, want %v/%v", k1.peer.ShortString(), k1.disco.ShortString(), m2, m2.conn.DiscoPublicKey().ShortString())
+	}
+	if k2.peer != m1.Public() || k2.disco != m1.conn.DiscoPublicKey() {
+		t.Errorf("conn2 got key for %v/%v, want %v/%v", k2.peer.ShortString(), k2.disco.ShortString(), m1, m1.conn.DiscoPublicKey().ShortString())
+	}
+	if k1.psk != k2.psk {
+		t.Errorf("preshared keys differ: %x != %x", k1.psk, k2.psk)
+	}
+	if k1.psk == ([32]byte{}) {
+		t.Error("preshared key is zero")
+	}
+
+	mustDirect(t, t.Logf, m1, m2)
+	mustDirect(t, t.Logf, m2, m1)
+}
+
+func TestPQHybridKeyExchangeLostAck(t *testing.T) {
+	tstest.ResourceCheck(t)
+
+	derpMap, cleanup := runDERPAndStun(t, t.Logf, localhostListener{}, netaddr.IPv4(127, 0, 0, 1))
+	defer cleanup()
+
+	m1, keys1 := newPQHybridStack(t, derpMap, true)
+	defer m1.Close()
+	m2, keys2 := newPQHybridStack(t, derpMap, true)
+	defer m2.Close()
+
+	initiator, initKeys, responder, respKeys := m1, keys1, m2, keys2
+	if m2.Public().Less(m1.Public()) {
+		initiator, initKeys, responder, respKeys = m2, keys2, m1, keys1
+	}
+
+	// Lose the first ack the responder echoes back.
+	var acks atomic.Int32
+	responder.conn.testOnlyDropDisco.Store(func(_ key.NodePublic, m disco.Message) bool {
+		_, isAck := m.(*disco.HybridKEMAck)
+		return isAck && acks.Add(1) == 1
+	})
+
+	cleanupMesh := meshStacks(t.Logf, pqHybridCapFor(0, 1), m1, m2)
+	defer cleanupMesh()
+
+	// There's no traffic between the two, so drive the exchange as
+	// pinging the peer would.
+	de := waitEndpoint(t, initiator, responder)
+	maybeStart := func(now mono.Time) (txID [12]byte) {
+		de.mu.Lock()
+		defer de.mu.Unlock()
+		de.maybeStartPQHybridLocked(now)
+		return de.pqHybrid.txID
+	}
+	start := mono.Now()
+	txID := maybeStart(start)
+
+	// The responder switches to the new key once it gets the ack, but
+	// the initiator never learns it did.
+	lost := waitPQHybridKey(t, respKeys)
+	if got := acks.Load(); got != 1 {
+		t.Fatalf("responder echoed %d acks, want 1", got)
+	}
+	de.mu.Lock()
+	pending, done := de.pqHybrid.pending, de.pqHybrid.done
+	de.mu.Unlock()
+	if !pending || done {
+		t.Errorf("initiator pending=%v done=%v, want the exchange pending", pending, done)
+	}
+	select {
+	case k := <-initKeys:
+		t.Fatalf("initiator switched to %x without the echoed ack", k.psk)
+	default:
+	}
+
+	// It doesn't retry until pqHybridRetryInterval has passed...
+	if got := maybeStart(start.Add(pqHybridRetryInterval / 2)); got != txID {
+		t.Fatal("initiator retried before pqHybridRetryInterval")
+	}
+	// ... after which both sides agree on a new key.
+	if got := maybeStart(start.Add(pqHybridRetryInterval)); got == txID {
+		t.Fatal("initiator didn't retry after pqHybridRetryInterval")
+	}
+	ki := waitPQHybridKey(t, initKeys)
+	kr := waitPQHybridKey(t, respKeys)
+	if ki.psk != kr.psk {
+		t.Errorf("preshared keys differ after retry: %x != %x", ki.psk, kr.psk)
+	}
+	if kr.psk == lost.psk {
+		t.Error("retry reused the preshared key of the lost exchange")
+	}
+}
+
+func TestPQHybridKeyExchangeWithoutPeerCap(t *testing.T) {
+	tstest.ResourceCheck(t)
+
+	derpMap, cleanup := runDERPAndStun(t, t.Logf, localhostListener{}, netaddr.IPv4(127, 0, 0, 1))
+	defer cleanup()
+
+	// m2 doesn't have NodeAttrPQHybridKeyExchange, so m1 doesn't see it
+	// on m2, but m2 sees it on m1.
+	m1, keys1 := newPQHybridStack(t, derpMap, true)
+	defer m1.Close()
+	m2, keys2 := newPQHybridStack(t, derpMap, false)
+	defer m2.Close()
+
+	var sent atomic.Int32
+	countHybrid := func(_ key.NodePublic, m disco.Message) bool {
+		switch m.(type) {
+		case *disco.HybridKEMInit, *disco.HybridKEMResponse, *disco.HybridKEMAck:
+			sent.Add(1)
+		}
+		return false
+	}
+	m1.conn.testOnlyDropDisco.Store(countHybrid)
+	m2.conn.testOnlyDropDisco.Store(countHybrid)
+
+	cleanupMesh := meshStacks(t.Logf, pqHybridCapFor(1), m1, m2)
+	defer cleanupMesh()
+
+	cleanupPinger := newPinger(t, t.Logf, m1, m2)
+	defer cleanupPinger()
+
+	mustDirect(t, t.Logf, m1, m2)
+	mustDirect(t, t.Logf, m2, m1)
+
+	if got := sent.Load(); got != 0 {
+		t.Errorf("sent %d hybrid key exchange messages, want 0", got)
+	}
+	select {
+	case k := <-keys1:
+		t.Errorf("conn1 got preshared key for %v", k.peer.ShortString())
+	case k := <-keys2:
+		t.Errorf("conn2 got preshared key for %v", k.peer.ShortString())
+	default:
+	}
+}
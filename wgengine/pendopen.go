@@ -8,6 +8,7 @@
 	"runtime"
 	"time"
 
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/flowtrack"
 	"tailscale.com/net/packet"
 	"tailscale.com/net/tsaddr"
@@ -208,6 +209,11 @@ func (e *userspaceEngine) onOpenTimeout(flow flowtrack.Tuple) {
 		flow, n.Key().ShortString(),
 		online,
 		e.magicConn.LastRecvActivityOfNodeKey(n.Key()))
+	e.notePeerConnEvent(n.Key(), ipnstate.PeerConnEvent{
+		When: time.Now(),
+		What: "handshake-timeout",
+		To:   flow.Dst.String(),
+	})
 }
 
 func durFmt(t time.Time) string {
@@ -85,6 +85,7 @@ type Config struct {
 	SNATSubnetRoutes bool                   // SNAT traffic to local subnets
 	NetfilterMode    preftype.NetfilterMode // how much to manage netfilter rules
 	NetfilterKind    string                 // what kind of netfilter to use (nftables, iptables)
+	RouteMTUs        map[netip.Prefix]int   // MTUs of some of Routes; others use the interface MTU
 }
 
 func (a *Config) Equal(b *Config) bool {
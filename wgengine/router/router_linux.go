@@ -22,10 +22,12 @@
 	"golang.org/x/sys/unix"
 	"golang.org/x/time/rate"
 	"tailscale.com/envknob"
+	"tailscale.com/health"
 	"tailscale.com/net/netmon"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/preftype"
 	"tailscale.com/util/linuxfw"
+	"tailscale.com/util/mak"
 	"tailscale.com/util/multierr"
 	"tailscale.com/version/distro"
 )
@@ -44,6 +46,8 @@ type linuxRouter struct {
 	unregNetMon      func()
 	addrs            map[netip.Prefix]bool
 	routes           map[netip.Prefix]bool
+	routeMTUs        map[netip.Prefix]int // MTUs of the installed routes that have one
+	wantRouteMTUs    map[netip.Prefix]int // Config.RouteMTUs of the current config
 	localRoutes      map[netip.Prefix]bool
 	snatSubnetRoutes bool
 	netfilterMode    preftype.NetfilterMode
@@ -325,6 +329,7 @@ func (r *linuxRouter) Close() error {
 
 	r.addrs = nil
 	r.routes = nil
+	r.routeMTUs = nil
 	r.localRoutes = nil
 
 	return nil
@@ -374,6 +379,19 @@ func (r *linuxRouter) Set(cfg *Config) error {
 	}
 	r.localRoutes = newLocalRoutes
 
+	// Reinstall the routes whose MTU changed. cidrDiff only adds and
+	// deletes routes, so remove them from r.routes for it to add them
+	// back with their new MTU.
+	r.wantRouteMTUs = cfg.RouteMTUs
+	for cidr := range r.routes {
+		if r.routeMTUs[cidr] != cfg.RouteMTUs[cidr] {
+			if err := r.delRoute(cidr); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			delete(r.routes, cidr)
+		}
+	}
 	newRoutes, err := cidrDiff("route", r.routes, cfg.Routes, r.addRoute, r.delRoute, r.logf)
 	if err != nil {
 		errs = append(errs, err)
@@ -399,10 +417,30 @@ func (r *linuxRouter) Set(cfg *Config) error {
 		}
 	}
 	r.snatSubnetRoutes = cfg.SNATSubnetRoutes
+	r.updateIPv6NATHealth(cfg)
 
 	return multierr.New(errs...)
 }
 
+var warnIPv6NATUnsupported = health.NewWarnable()
+
+// updateIPv6NATHealth warns if IPv6 subnet routes are advertised with
+// SNAT enabled on a system that can't NAT IPv6 traffic. Only the IPv4
+// subnet routes are masqueraded then; traffic to the IPv6 ones is
+// forwarded with the original source addresses.
+func (r *linuxRouter) updateIPv6NATHealth(cfg *Config) {
+	var err error
+	if r.nfr != nil && cfg.SNATSubnetRoutes && r.nfr.HasIPV6() && !r.nfr.HasIPV6NAT() {
+		for _, route := range cfg.SubnetRoutes {
+			if route.Addr().Is6() {
+				err = fmt.Errorf("IPv6 subnet route %v is not masqueraded: %s", route, r.nfr.IPv6Capabilities().Reason)
+				break
+			}
+		}
+	}
+	warnIPv6NATUnsupported.Set(err)
+}
+
 // UpdateMagicsockPort implements the Router interface.
 func (r *linuxRouter) UpdateMagicsockPort(port uint16, network string) error {
 	if r.nfr == nil {
@@ -690,18 +728,43 @@ func (r *linuxRouter) addRoute(cidr netip.Prefix) error {
 	if !r.getV6Available() && cidr.Addr().Is6() {
 		return nil
 	}
+	mtu := r.wantRouteMTUs[cidr]
+	var err error
 	if r.useIPCommand() {
-		return r.addRouteDef([]string{normalizeCIDR(cidr), "dev", r.tunname}, cidr)
+		err = r.addRouteDef(r.routeDef(cidr, mtu), cidr)
+	} else {
+		var linkIndex int
+		linkIndex, err = r.linkIndex()
+		if err != nil {
+			return err
+		}
+		err = netlink.RouteReplace(&netlink.Route{
+			LinkIndex: linkIndex,
+			Dst:       netipx.PrefixIPNet(cidr.Masked()),
+			Table:     r.routeTable(),
+			MTU:       mtu,
+		})
 	}
-	linkIndex, err := r.linkIndex()
 	if err != nil {
 		return err
 	}
-	return netlink.RouteReplace(&netlink.Route{
-		LinkIndex: linkIndex,
-		Dst:       netipx.PrefixIPNet(cidr.Masked()),
-		Table:     r.routeTable(),
-	})
+	if mtu != 0 {
+		mak.Set(&r.routeMTUs, cidr, mtu)
+	} else {
+		delete(r.routeMTUs, cidr)
+	}
+	return nil
+}
+
+// routeDef returns the arguments of "ip route" for the route for cidr
+// pointing to the tunnel interface, with the given MTU, or the interface
+// MTU if zero.
+func (r *linuxRouter) routeDef(cidr netip.Prefix, mtu int) []string {
+	def := []string{normalizeCIDR(cidr), "dev", r.tunname}
+	if mtu != 0 {
+		def = append(def, "mtu", strconv.Itoa(mtu))
+	}
+	return def
 }
 
 // addThrowRoute adds a throw route for the provided cidr.
@@ -763,12 +826,17 @@ func (r *linuxRouter) addRouteDef(routeDef []string, cidr netip.Prefix) error {
 // delRoute removes the route for cidr pointing to the tunnel
 // interface. Fails if the route doesn't exist, or if removing the
 // route fails.
-func (r *linuxRouter) delRoute(cidr netip.Prefix) error {
+func (r *linuxRouter) delRoute(cidr netip.Prefix) (err error) {
 	if !r.getV6Available() && cidr.Addr().Is6() {
 		return nil
 	}
+	defer func() {
+		if err == nil {
+			delete(r.routeMTUs, cidr)
+		}
+	}()
 	if r.useIPCommand() {
-		return r.delRouteDef([]string{normalizeCIDR(cidr), "dev", r.tunname}, cidr)
+		return r.delRouteDef(r.routeDef(cidr, r.routeMTUs[cidr]), cidr)
 	}
 	linkIndex, err := r.linkIndex()
 	if err != nil {
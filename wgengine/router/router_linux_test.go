@@ -76,6 +76,36 @@ func TestRouterStates(t *testing.T) {
 ip route add 192.168.16.0/24 dev tailscale0 table 52` + basic,
 		},
 
+		{
+			name: "addr and routes with route MTU",
+			in: &Config{
+				LocalAddrs:    mustCIDRs("100.101.102.103/10"),
+				Routes:        mustCIDRs("100.100.100.100/32", "192.168.16.0/24"),
+				RouteMTUs:     map[netip.Prefix]int{netip.MustParsePrefix("192.168.16.0/24"): 1280},
+				NetfilterMode: netfilterOff,
+			},
+			want: `
+up
+ip addr add 100.101.102.103/10 dev tailscale0
+ip route add 100.100.100.100/32 dev tailscale0 table 52
+ip route add 192.168.16.0/24 dev tailscale0 mtu 1280 table 52` + basic,
+		},
+
+		{
+			name: "addr and routes with changed route MTU",
+			in: &Config{
+				LocalAddrs:    mustCIDRs("100.101.102.103/10"),
+				Routes:        mustCIDRs("100.100.100.100/32", "192.168.16.0/24"),
+				RouteMTUs:     map[netip.Prefix]int{netip.MustParsePrefix("192.168.16.0/24"): 1400},
+				NetfilterMode: netfilterOff,
+			},
+			want: `
+up
+ip addr add 100.101.102.103/10 dev tailscale0
+ip route add 100.100.100.100/32 dev tailscale0 table 52
+ip route add 192.168.16.0/24 dev tailscale0 mtu 1400 table 52` + basic,
+		},
+
 		{
 			name: "addr and routes and subnet routes",
 			in: &Config{
@@ -470,6 +500,14 @@ func (n *fakeIPTablesRunner) AddDNATRule(origDst, dst netip.Addr) error {
 	return errors.New("not implemented")
 }
 
+func (n *fakeIPTablesRunner) AddDNATRuleForPort(origDst, dst netip.Addr, pm linuxfw.PortMap) error {
+	return errors.New("not implemented")
+}
+
+func (n *fakeIPTablesRunner) DelDNATRuleForPort(origDst, dst netip.Addr, pm linuxfw.PortMap) error {
+	return errors.New("not implemented")
+}
+
 func (n *fakeIPTablesRunner) AddSNATRuleForDst(src, dst netip.Addr) error {
 	return errors.New("not implemented")
 }
@@ -482,6 +520,14 @@ func (n *fakeIPTablesRunner) ClampMSSToPMTU(tun string, addr netip.Addr) error {
 	return errors.New("not implemented")
 }
 
+func (n *fakeIPTablesRunner) EnsureProxyRules(rules linuxfw.ProxyRules) error {
+	return errors.New("not implemented")
+}
+
+func (n *fakeIPTablesRunner) ProxyRuleCounters() ([]linuxfw.ProxyRuleCounter, error) {
+	return nil, errors.New("not implemented")
+}
+
 func (n *fakeIPTablesRunner) addBase4(tunname string) error {
 	curIPT := n.ipt4
 	newRules := []struct{ chain, rule string }{
@@ -661,6 +707,9 @@ func (n *fakeIPTablesRunner) DelMagicsockPortRule(port uint16, network string) e
 
 func (n *fakeIPTablesRunner) HasIPV6() bool    { return true }
 func (n *fakeIPTablesRunner) HasIPV6NAT() bool { return true }
+func (n *fakeIPTablesRunner) IPv6Capabilities() linuxfw.IPv6Capabilities {
+	return linuxfw.IPv6Capabilities{Available: true, NAT: true}
+}
 
 // fakeOS implements commandRunner and provides v4 and v6
 // netfilterRunners, but captures changes without touching the OS.
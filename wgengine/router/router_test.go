@@ -24,7 +24,7 @@ func TestConfigEqual(t *testing.T) {
 	testedFields := []string{
 		"LocalAddrs", "Routes", "LocalRoutes", "NewMTU",
 		"SubnetRoutes", "SNATSubnetRoutes", "NetfilterMode",
-		"NetfilterKind",
+		"NetfilterKind", "RouteMTUs",
 	}
 	configType := reflect.TypeFor[Config]()
 	configFields := []string{}
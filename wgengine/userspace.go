@@ -112,6 +112,10 @@ type userspaceEngine struct {
 	// is being routed over Tailscale.
 	isDNSIPOverTailscale syncs.AtomicValue[func(netip.Addr) bool]
 
+	// peerConnEventCallback is the callback registered via
+	// SetPeerConnEventCallback, or nil.
+	peerConnEventCallback syncs.AtomicValue[PeerConnEventCallback]
+
 	wgLock              sync.Mutex // serializes all wgdev operations; see lock order comment below
 	lastCfgFull         wgcfg.Config
 	lastNMinPeers       int
@@ -124,7 +128,8 @@ type userspaceEngine struct {
 	trimmedNodes        map[key.NodePublic]bool   // set of node keys of peers currently excluded from wireguard config
 	sentActivityAt      map[netip.Addr]*mono.Time // value is accessed atomically
 	destIPActivityFuncs map[netip.Addr]func()
-	lastStatusPollTime  mono.Time // last time we polled the engine status
+	lastStatusPollTime  mono.Time                           // last time we polled the engine status
+	peerPresharedKeys   map[key.NodePublic]peerPresharedKey // from magicsock's hybrid key exchange
 
 	mu             sync.Mutex         // guards following; see lock order comment below
 	netMap         *netmap.NetworkMap // or nil
@@ -213,6 +218,8 @@ type Config struct {
 // The opts may contain the following types:
 //
 //   - int or uint16: to set the ListenPort.
+//   - *netmon.Monitor: to use instead of a new network monitor, such as
+//     one from netmon.NewStatic.
 func NewFakeUserspaceEngine(logf logger.Logf, opts ...any) (Engine, error) {
 	conf := Config{
 		RespondToPing: true,
@@ -230,6 +237,8 @@ func NewFakeUserspaceEngine(logf logger.Logf, opts ...any) (Engine, error) {
 			conf.SetSubsystem = v
 		case *controlknobs.Knobs:
 			conf.ControlKnobs = v
+		case *netmon.Monitor:
+			conf.NetMon = v
 		default:
 			return nil, fmt.Errorf("unknown option type %T", v)
 		}
@@ -343,6 +352,8 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 		DERPActiveFunc:   e.RequestStatus,
 		IdleFunc:         e.tundev.IdleDuration,
 		NoteRecvActivity: e.noteRecvActivity,
+		OnPeerConnEvent:  e.notePeerConnEvent,
+		OnPQHybridKey:    e.setPeerPresharedKey,
 		NetMon:           e.netMon,
 		ControlKnobs:     conf.ControlKnobs,
 		OnPortUpdate:     onPortUpdate,
@@ -671,6 +682,12 @@ func (e *userspaceEngine) maybeReconfigWireguardLocked(discoChanged map[key.Node
 		}
 	}
 	e.lastNMinPeers = len(min.Peers)
+	for i := range min.Peers {
+		p := &min.Peers[i]
+		if k, ok := e.peerPresharedKeys[p.PublicKey]; ok && k.disco == p.DiscoKey {
+			p.PresharedKey = k.psk
+		}
+	}
 
 	if changed := deephash.Update(&e.lastEngineSigTrim, &struct {
 		WGConfig     *wgcfg.Config
@@ -882,6 +899,12 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 		}
 	}
 
+	for nk := range e.peerPresharedKeys {
+		if !peerSet.Contains(nk) {
+			delete(e.peerPresharedKeys, nk)
+		}
+	}
+
 	e.lastCfgFull = *cfg.Clone()
 
 	// Tell magicsock about the new (or initial) private key
@@ -987,6 +1010,37 @@ func (e *userspaceEngine) SetStatusCallback(cb StatusCallback) {
 	e.statusCallback = cb
 }
 
+func (e *userspaceEngine) SetPeerConnEventCallback(cb PeerConnEventCallback) {
+	e.peerConnEventCallback.Store(cb)
+}
+
+// notePeerConnEvent passes ev for the peer with node key nk on to the
+// callback registered via SetPeerConnEventCallback, if any.
+func (e *userspaceEngine) notePeerConnEvent(nk key.NodePublic, ev ipnstate.PeerConnEvent) {
+	if cb := e.peerConnEventCallback.Load(); cb != nil {
+		cb(nk, ev)
+	}
+}
+
+// peerPresharedKey is a WireGuard preshared key agreed with a peer by
+// magicsock's hybrid key exchange.
+type peerPresharedKey struct {
+	disco key.DiscoPublic // peer's disco key at the time; the key is void once it restarts
+	psk   [32]byte
+}
+
+// setPeerPresharedKey is called by magicsock when the hybrid key exchange
+// with the peer with node key nk and disco key dk completes, and reconfigures
+// wireguard-go to use psk with that peer from its next handshake on.
+func (e *userspaceEngine) setPeerPresharedKey(nk key.NodePublic, dk key.DiscoPublic, psk [32]byte) {
+	e.wgLock.Lock()
+	defer e.wgLock.Unlock()
+	mak.Set(&e.peerPresharedKeys, nk, peerPresharedKey{disco: dk, psk: psk})
+	if err := e.maybeReconfigWireguardLocked(nil); err != nil {
+		e.logf("wgengine: setting preshared key for %v: %v", nk.ShortString(), err)
+	}
+}
+
 func (e *userspaceEngine) getStatusCallback() StatusCallback {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -19,6 +19,7 @@
 	"tailscale.com/net/dns"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/netmap"
+	"tailscale.com/util/clientmetric"
 	"tailscale.com/wgengine/capture"
 	"tailscale.com/wgengine/filter"
 	"tailscale.com/wgengine/router"
@@ -30,6 +31,16 @@
 //
 // If they do not, the watchdog crashes the process.
 func NewWatchdog(e Engine) Engine {
+	return NewWatchdogWithReset(e, nil)
+}
+
+// NewWatchdogWithReset is like NewWatchdog, but if a method doesn't complete
+// in time, the watchdog first calls reset (if non-nil) and gives the method
+// another chance to complete before crashing the process. The reset func
+// should reset the parts of the engine that wedge it, such as its sockets,
+// and is passed a description of why it's called. It's called in its own
+// goroutine, as it might block on whatever the method is stuck on.
+func NewWatchdogWithReset(e Engine, reset func(why string)) Engine {
 	if envknob.Bool("TS_DEBUG_DISABLE_WATCHDOG") {
 		return e
 	}
@@ -37,6 +48,7 @@ func NewWatchdog(e Engine) Engine {
 		wrap:     e,
 		logf:     log.Printf,
 		fatalf:   log.Fatalf,
+		reset:    reset,
 		maxWait:  45 * time.Second,
 		inFlight: make(map[inFlightKey]time.Time),
 	}
@@ -51,6 +63,7 @@ type watchdogEngine struct {
 	wrap    Engine
 	logf    func(format string, args ...any)
 	fatalf  func(format string, args ...any)
+	reset   func(why string) // or nil
 	maxWait time.Duration
 
 	// Track the start time(s) of in-flight operations
@@ -106,6 +119,19 @@ func (e *watchdogEngine) watchdogErr(name string, fn func() error) error {
 		// Print everything as a single string to avoid log
 		// rate limits.
 		e.logf("wgengine watchdog in-flight:\n%s", b)
+
+		if e.reset != nil {
+			go e.reset("watchdog timeout on " + name)
+			t.Reset(e.maxWait)
+			select {
+			case err := <-errCh:
+				t.Stop()
+				metricWatchdogRecoveries.Add(1)
+				e.logf("wgengine: watchdog recovered %s after reset", name)
+				return err
+			case <-t.C:
+			}
+		}
 		e.fatalf("wgengine: watchdog timeout on %s", name)
 		return nil
 	}
@@ -130,6 +156,9 @@ func (e *watchdogEngine) SetFilter(filt *filter.Filter) {
 func (e *watchdogEngine) SetStatusCallback(cb StatusCallback) {
 	e.watchdog("SetStatusCallback", func() { e.wrap.SetStatusCallback(cb) })
 }
+func (e *watchdogEngine) SetPeerConnEventCallback(cb PeerConnEventCallback) {
+	e.watchdog("SetPeerConnEventCallback", func() { e.wrap.SetPeerConnEventCallback(cb) })
+}
 func (e *watchdogEngine) UpdateStatus(sb *ipnstate.StatusBuilder) {
 	e.watchdog("UpdateStatus", func() { e.wrap.UpdateStatus(sb) })
 }
@@ -157,3 +186,5 @@ func (e *watchdogEngine) Wait() {
 func (e *watchdogEngine) InstallCaptureHook(cb capture.Callback) {
 	e.wrap.InstallCaptureHook(cb)
 }
+
+var metricWatchdogRecoveries = clientmetric.NewCounter("wgengine_watchdog_recoveries")
@@ -4,6 +4,7 @@
 package wgengine
 
 import (
+	"errors"
 	"runtime"
 	"testing"
 	"time"
@@ -36,4 +37,33 @@ func TestWatchdog(t *testing.T) {
 		e.RequestStatus()
 		e.Close()
 	})
+	t.Run("reset unblocks stuck call", func(t *testing.T) {
+		t.Parallel()
+		e, err := NewFakeUserspaceEngine(t.Logf, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(e.Close)
+
+		unblock := make(chan struct{})
+		var resetWhy string
+		w := NewWatchdogWithReset(e, func(why string) {
+			resetWhy = why
+			close(unblock)
+		}).(*watchdogEngine)
+		w.maxWait = maxWaitMultiple * 150 * time.Millisecond
+		w.logf = t.Logf
+		w.fatalf = t.Fatalf
+
+		wantErr := errors.New("stuck")
+		if err := w.watchdogErr("Stuck", func() error {
+			<-unblock
+			return wantErr
+		}); err != wantErr {
+			t.Errorf("got error %v, want %v", err, wantErr)
+		}
+		if want := "watchdog timeout on Stuck"; resetWhy != want {
+			t.Errorf("reset called with %q, want %q", resetWhy, want)
+		}
+	})
 }
@@ -138,6 +138,14 @@ func TestDeviceConfig(t *testing.T) {
 		cmp(t, device1, cfg1)
 	})
 
+	t.Run("device1 set preshared key", func(t *testing.T) {
+		cfg1.Peers[0].PresharedKey = [32]byte{0: 1, 31: 2}
+		if err := ReconfigDevice(device1, cfg1, t.Logf); err != nil {
+			t.Fatal(err)
+		}
+		cmp(t, device1, cfg1)
+	})
+
 	t.Run("device1 add new peer", func(t *testing.T) {
 		cfg1.Peers = append(cfg1.Peers, Peer{
 			PublicKey:  k3,
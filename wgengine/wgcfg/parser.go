@@ -5,6 +5,7 @@
 
 import (
 	"bufio"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net"
@@ -172,8 +173,14 @@ func (cfg *Config) handlePeerLine(peer *Peer, k, value mem.RO, valueBytes []byte
 		if !value.EqualString("1") {
 			return fmt.Errorf("invalid protocol version: %q", value.StringCopy())
 		}
+	case k.EqualString("preshared_key"):
+		if len(valueBytes) != hex.EncodedLen(len(peer.PresharedKey)) {
+			return fmt.Errorf("invalid preshared key length %d", len(valueBytes))
+		}
+		if _, err := hex.Decode(peer.PresharedKey[:], valueBytes); err != nil {
+			return err
+		}
 	case k.EqualString("replace_allowed_ips") ||
-		k.EqualString("preshared_key") ||
 		k.EqualString("last_handshake_time_sec") ||
 		k.EqualString("last_handshake_time_nsec") ||
 		k.EqualString("tx_bytes") ||
@@ -74,5 +74,6 @@ func (src *Peer) Clone() *Peer {
 	V4MasqAddr          *netip.Addr
 	V6MasqAddr          *netip.Addr
 	PersistentKeepalive uint16
+	PresharedKey        [32]byte
 	WGEndpoint          key.NodePublic
 }{})
@@ -4,6 +4,7 @@
 package wgcfg
 
 import (
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/netip"
@@ -63,8 +64,9 @@ func (cfg *Config) ToUAPI(logf logger.Logf, w io.Writer, prev *Config) error {
 		willSetEndpoint := oldPeer.WGEndpoint != p.PublicKey || !wasPresent
 		willChangeIPs := !cidrsEqual(oldPeer.AllowedIPs, p.AllowedIPs) || !wasPresent
 		willChangeKeepalive := oldPeer.PersistentKeepalive != p.PersistentKeepalive || !wasPresent
+		willChangePresharedKey := oldPeer.PresharedKey != p.PresharedKey
 
-		if !willSetEndpoint && !willChangeIPs && !willChangeKeepalive {
+		if !willSetEndpoint && !willChangeIPs && !willChangeKeepalive && !willChangePresharedKey {
 			// It's safe to skip doing anything here; wireguard-go
 			// will not remove a peer if it's unspecified unless we
 			// tell it to (which we do below if necessary).
@@ -101,6 +103,10 @@ func (cfg *Config) ToUAPI(logf logger.Logf, w io.Writer, prev *Config) error {
 			}
 		}
 
+		if willChangePresharedKey {
+			set("preshared_key", hex.EncodeToString(p.PresharedKey[:]))
+		}
+
 		// Set PersistentKeepalive after the peer is otherwise configured,
 		// because it can trigger handshake packets.
 		if willChangeKeepalive {
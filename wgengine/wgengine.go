@@ -11,6 +11,7 @@
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/dns"
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
 	"tailscale.com/types/netmap"
 	"tailscale.com/wgengine/capture"
 	"tailscale.com/wgengine/filter"
@@ -34,6 +35,13 @@ type Status struct {
 // Exactly one of Status or error is non-nil.
 type StatusCallback func(*Status, error)
 
+// PeerConnEventCallback is the type of callbacks used by
+// Engine.SetPeerConnEventCallback.
+//
+// It may be called with internal locks held and must not block or call
+// back into the Engine.
+type PeerConnEventCallback func(key.NodePublic, ipnstate.PeerConnEvent)
+
 // NetworkMapCallback is the type used by callbacks that hook
 // into network map updates.
 type NetworkMapCallback func(*netmap.NetworkMap)
@@ -80,6 +88,11 @@ type Engine interface {
 	// WireGuard status changes.
 	SetStatusCallback(StatusCallback)
 
+	// SetPeerConnEventCallback sets the function to call when the
+	// way a peer is reached changes or a connection to it fails to
+	// open.
+	SetPeerConnEventCallback(PeerConnEventCallback)
+
 	// RequestStatus requests a WireGuard status update right
 	// away, sent to the callback registered via SetStatusCallback.
 	RequestStatus()